@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+)
+
+// argon2id のパラメータを選ぶためのベンチマークツール。
+// 候補のパラメータごとにハッシュ化の時間を計測し、1 回が -target 以内に収まる最も強いパラメータを OP_ARGON2_* の値として示す。
+// 本番と同じ CPU・メモリ制限の環境 (コンテナ) で実行する
+// Usage:
+//
+//	go run cmd/argon2bench/main.go [-memory 19456,47104,65536,131072] [-time 1,2,3,4] [-threads 4] [-iterations 5] [-target 500ms] [-concurrency <n>]
+func main() {
+	memoryList := flag.String("memory", "19456,47104,65536,131072", "candidate memory sizes in KiB (comma separated)")
+	timeList := flag.String("time", "1,2,3,4", "candidate iterations (comma separated)")
+	threads := flag.Uint("threads", 4, "parallelism")
+	iterations := flag.Int("iterations", 5, "measured hashes per parameter set")
+	target := flag.Duration("target", 500*time.Millisecond, "maximum acceptable time per hash")
+	concurrency := flag.Int("concurrency", runtime.NumCPU(), "planned OP_ARGON2_MAX_CONCURRENCY (used to estimate peak memory)")
+	flag.Parse()
+
+	memories, err := parseList(*memoryList)
+	if err != nil {
+		log.Fatalf("invalid -memory: %v", err)
+	}
+	times, err := parseList(*timeList)
+	if err != nil {
+		log.Fatalf("invalid -time: %v", err)
+	}
+	if *threads < 1 || *threads > 255 || *iterations < 1 || *concurrency < 1 {
+		log.Fatalf("-threads must be 1-255, -iterations and -concurrency must be at least 1")
+	}
+
+	fmt.Printf("GOMAXPROCS=%d, concurrency=%d, target=%s\n\n", runtime.GOMAXPROCS(0), *concurrency, *target)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PARAMS\tMEDIAN\tMAX\tPEAK MEMORY")
+
+	var best *result
+	for _, m := range memories {
+		for _, t := range times {
+			params := crypto.Argon2Params{Memory: m, Time: t, Threads: uint8(*threads)}
+			r, err := measure(params, *iterations)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t%v\t\t\n", params, err)
+				continue
+			}
+			// 同時に計算する上限まで埋まった場合に argon2 が使うメモリ
+			peak := uint64(m) * uint64(*concurrency) / 1024
+			fmt.Fprintf(w, "%s\t%s\t%s\t%d MiB\n", params, r.median.Round(time.Millisecond), r.max.Round(time.Millisecond), peak)
+			if r.median <= *target && (best == nil || r.stronger(best)) {
+				best = r
+			}
+		}
+	}
+	w.Flush()
+
+	if best == nil {
+		fmt.Printf("\nno parameter set hashes within %s\n", *target)
+		os.Exit(1)
+	}
+	fmt.Printf("\nrecommended (%s per hash):\n", best.median.Round(time.Millisecond))
+	fmt.Printf("OP_ARGON2_MEMORY_KIB=%d\nOP_ARGON2_TIME=%d\nOP_ARGON2_THREADS=%d\nOP_ARGON2_MAX_CONCURRENCY=%d\n",
+		best.params.Memory, best.params.Time, best.params.Threads, *concurrency)
+}
+
+type result struct {
+	params crypto.Argon2Params
+	median time.Duration
+	max    time.Duration
+}
+
+// stronger はメモリ × 反復回数 (攻撃者が 1 回の推測に払うコスト) が大きいか判定する。同じ場合はメモリを優先する
+func (r *result) stronger(other *result) bool {
+	cost := uint64(r.params.Memory) * uint64(r.params.Time)
+	otherCost := uint64(other.params.Memory) * uint64(other.params.Time)
+	if cost != otherCost {
+		return cost > otherCost
+	}
+	return r.params.Memory > other.params.Memory
+}
+
+// measure は 1 回ウォームアップしてから iterations 回ハッシュ化し、時間の中央値と最大値を返す
+func measure(params crypto.Argon2Params, iterations int) (*result, error) {
+	hasher, err := crypto.NewPasswordHasher(params, 1)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hasher.HashPassword(context.Background(), "warmup"); err != nil {
+		return nil, err
+	}
+
+	durations := make([]time.Duration, iterations)
+	for i := range durations {
+		start := time.Now()
+		if _, err := hasher.HashPassword(context.Background(), "benchmark-password"); err != nil {
+			return nil, err
+		}
+		durations[i] = time.Since(start)
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return &result{params: params, median: durations[len(durations)/2], max: durations[len(durations)-1]}, nil
+}
+
+// parseList はカンマ区切りの正の整数を読み込む
+func parseList(v string) ([]uint32, error) {
+	var out []uint32
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(s, 10, 32)
+		if err != nil || n == 0 {
+			return nil, fmt.Errorf("%q is not a positive integer", s)
+		}
+		out = append(out, uint32(n))
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty list")
+	}
+	return out, nil
+}
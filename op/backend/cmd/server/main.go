@@ -2,8 +2,12 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
 	"log"
 	"net/http"
+	"os"
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
@@ -12,9 +16,17 @@ import (
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/auth"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/database"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/federation"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/jwt"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/ldap"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/mailer"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/management"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/migrationhook"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/notifier"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/oidc"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/saml"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/scim"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/sms"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/store"
 )
 
@@ -46,10 +58,32 @@ func main() {
 	accessTokenRepo := store.NewAccessTokenRepository(db)
 	refreshTokenRepo := store.NewRefreshTokenRepository(db)
 	idTokenRepo := store.NewIDTokenRepository(db)
+	apiResourceRepo := store.NewAPIResourceRepository(db)
+	authorizationDetailTypeRepo := store.NewAuthorizationDetailTypeRepository(db)
+	authorizationDetailsApprovalRepo := store.NewAuthorizationDetailsApprovalRepository(db)
+	scopeDefinitionRepo := store.NewScopeDefinitionRepository(db)
+	userConsentRepo := store.NewUserConsentRepository(db)
 	signKeyRepo := store.NewSignKeyRepository(db)
 	redirectURIRepo := store.NewRedirectURIRepository(db)
 	adminUserRepo := store.NewAdminUserRepository(db)
 	adminSessionRepo := store.NewAdminSessionRepository(db)
+	clientAssertionJTIRepo := store.NewClientAssertionJTIRepository(db)
+	initialAccessTokenRepo := store.NewInitialAccessTokenRepository(db)
+	deviceCodeRepo := store.NewDeviceCodeRepository(db)
+	backchannelAuthRepo := store.NewBackchannelAuthRequestRepository(db)
+	pairwiseSubjectRepo := store.NewPairwiseSubjectRepository(db)
+	emailVerificationTokenRepo := store.NewEmailVerificationTokenRepository(db)
+	smsOTPCodeRepo := store.NewSMSOTPCodeRepository(db)
+	emailChangeRequestRepo := store.NewEmailChangeRequestRepository(db)
+	userAuditLogRepo := store.NewUserAuditLogRepository(db)
+	identityProviderRepo := store.NewIdentityProviderRepository(db)
+	federationStateRepo := store.NewFederationStateRepository(db)
+	samlServiceProviderRepo := store.NewSAMLServiceProviderRepository(db)
+	samlSessionRepo := store.NewSAMLSessionRepository(db)
+	scimTokenRepo := store.NewSCIMTokenRepository(db)
+	groupRepo := store.NewGroupRepository(db)
+	ldapDirectoryRepo := store.NewLDAPDirectoryRepository(db)
+	userMigrationHookRepo := store.NewUserMigrationHookRepository(db)
 
 	// JWT サービス初期化
 	keySvc, err := jwt.NewKeyService(signKeyRepo, cfg.KeyEncryptionKey)
@@ -65,28 +99,107 @@ func main() {
 
 	tokenSvc := jwt.NewTokenService(keySvc)
 
+	// パスワード・client_secret のハッシュ。設定より弱いハッシュはログインの成功時に作り直す
+	argon2Params := crypto.Argon2Params{Memory: cfg.Argon2MemoryKiB, Time: cfg.Argon2Time, Threads: cfg.Argon2Threads}
+	passwordHasher, err := crypto.NewPasswordHasher(argon2Params, cfg.Argon2MaxConcurrency)
+	if err != nil {
+		log.Fatalf("failed to create password hasher: %v", err)
+	}
+	log.Printf("argon2id parameters: %s, max concurrency: %d", argon2Params, cfg.Argon2MaxConcurrency)
+
+	// メール送信。SMTP サーバーが設定されていなければ送信せずログに出力する
+	var mailSender auth.MailSender = mailer.NewOutbox(log.Default())
+	if cfg.SMTPAddr != "" {
+		smtpSender, err := mailer.NewSMTPSender(cfg.SMTPAddr, cfg.SMTPFrom, cfg.SMTPUsername, cfg.SMTPPassword)
+		if err != nil {
+			log.Fatalf("failed to initialize SMTP sender: %v", err)
+		}
+		mailSender = smtpSender
+	}
+
+	// SMS 送信。SMS ゲートウェイとの連携はなく、送信せずログに出力する
+	smsSender := sms.NewOutbox(log.Default())
+
+	// ハンドラとサービスは echo のロガーでログを出力する
+	e := echo.New()
+
 	// Auth サービス初期化
-	authSvc := auth.NewAuthService(tenantRepo, userRepo, sessionRepo, crypto.VerifyPassword)
+	emailVerificationSvc := auth.NewEmailVerificationService(emailVerificationTokenRepo, userRepo, mailSender, jwt.SHA256Hex, cfg.FrontendBaseURL)
+	smsOTPSvc := auth.NewSMSOTPService(smsOTPCodeRepo, userRepo, smsSender, jwt.SHA256Hex)
+	ldapClient := ldap.NewClient()
+	// 認証ソースは LDAP ディレクトリ → OP のパスワード → 移行元の Webhook の順に試す
+	authenticators := []auth.Authenticator{
+		auth.NewLDAPAuthenticator(ldapDirectoryRepo, userRepo, groupRepo, ldapClient, keySvc.DecryptSecret),
+		auth.NewPasswordAuthenticator(userRepo, userRepo, passwordHasher.VerifyPassword, passwordHasher.HashPassword, passwordHasher.NeedsRehash, e.Logger),
+		auth.NewUserMigrationAuthenticator(userMigrationHookRepo, userRepo, migrationhook.NewClient(), passwordHasher.HashPassword, keySvc.DecryptSecret),
+	}
+	authSvc := auth.NewAuthService(tenantRepo, userRepo, sessionRepo, authenticators, emailVerificationSvc, smsOTPSvc)
+	emailChangeSvc := auth.NewEmailChangeService(
+		emailChangeRequestRepo, userRepo, userAuditLogRepo, mailSender, jwt.SHA256Hex,
+		sessionRepo, accessTokenRepo, refreshTokenRepo, cfg.FrontendBaseURL,
+	)
+	federationSvc := auth.NewFederationService(
+		authSvc, tenantRepo, identityProviderRepo, federationStateRepo, userRepo,
+		federation.NewClient(), jwt.SHA256Hex, keySvc.DecryptSecret, cfg.BaseURL,
+	)
 
 	// Auth ハンドラ初期化
 	loginHandler := auth.NewLoginHandler(authSvc, cfg.IsSecure())
 	meHandler := auth.NewMeHandler(authSvc, userRepo)
+	emailVerificationHandler := auth.NewEmailVerificationHandler(authSvc, userRepo, emailVerificationSvc)
+	emailChangeHandler := auth.NewEmailChangeHandler(authSvc, userRepo, emailChangeSvc)
+	smsHandler := auth.NewSMSHandler(authSvc, userRepo, smsOTPSvc)
+	federationHandler := auth.NewFederationHandler(federationSvc, cfg.FrontendBaseURL, cfg.IsSecure())
 
 	// OIDC ハンドラ初期化
+	subjectMapper := oidc.NewSubjectMapper(tenantRepo, clientRepo, pairwiseSubjectRepo)
 	jwksHandler := oidc.NewJWKSHandler(keySvc)
-	discoveryHandler := oidc.NewDiscoveryHandler(cfg.BaseURL, tenantRepo)
-	authorizeHandler := oidc.NewAuthorizeHandler(tenantRepo, clientRepo, authCodeRepo, authSvc, cfg.FrontendBaseURL)
+	discoveryHandler := oidc.NewDiscoveryHandler(cfg.BaseURL, tenantRepo, authorizationDetailTypeRepo, scopeDefinitionRepo)
+	requestObjectResolver := oidc.NewRequestObjectResolver(cfg.BaseURL)
+	authorizeHandler := oidc.NewAuthorizeHandler(tenantRepo, clientRepo, authCodeRepo, apiResourceRepo, authorizationDetailTypeRepo, authorizationDetailsApprovalRepo, scopeDefinitionRepo, userConsentRepo, subjectMapper, requestObjectResolver, authSvc, tokenSvc, cfg.BaseURL, cfg.FrontendBaseURL)
+	certExtractor, err := oidc.NewClientCertificateExtractor(cfg.ClientCertHeader, cfg.TrustedProxies)
+	if err != nil {
+		log.Fatalf("failed to initialize client certificate extractor: %v", err)
+	}
+	clientCAs, err := loadCertPool(cfg.TLSClientCAFile)
+	if err != nil {
+		log.Fatalf("failed to load client CA: %v", err)
+	}
+	clientAuthenticator := oidc.NewClientAuthenticator(
+		clientRepo, clientRepo, clientAssertionJTIRepo,
+		passwordHasher.VerifyPassword, passwordHasher.HashPassword, passwordHasher.NeedsRehash, keySvc.DecryptSecret,
+		certExtractor, clientCAs, cfg.BaseURL,
+	)
 	tokenHandler := oidc.NewTokenHandler(
-		authCodeRepo, accessTokenRepo, refreshTokenRepo, idTokenRepo,
-		clientRepo, tenantRepo, tokenSvc,
-		crypto.VerifyPassword, crypto.VerifyCodeChallenge,
-		jwt.ComputeATHash, jwt.SHA256Hex,
+		authCodeRepo, accessTokenRepo, refreshTokenRepo, idTokenRepo, idTokenRepo, deviceCodeRepo, backchannelAuthRepo, apiResourceRepo,
+		clientAuthenticator, certExtractor, subjectMapper, tenantRepo, userRepo, tokenSvc, tokenSvc,
+		crypto.VerifyCodeChallenge,
+		jwt.ComputeATHash, jwt.SHA256Hex, jwt.EncryptJWT,
 		cfg.BaseURL,
 	)
-	userInfoHandler := oidc.NewUserInfoHandler(tokenSvc, userRepo, accessTokenRepo)
-	revokeHandler := oidc.NewRevokeHandler(clientRepo, accessTokenRepo, refreshTokenRepo, tokenSvc, crypto.VerifyPassword, jwt.SHA256Hex)
+	userInfoHandler := oidc.NewUserInfoHandler(tokenSvc, userRepo, accessTokenRepo, userConsentRepo, certExtractor, tokenSvc, jwt.EncryptJWT)
+	deviceAuthHandler := oidc.NewDeviceAuthorizationHandler(tenantRepo, clientAuthenticator, deviceCodeRepo, scopeDefinitionRepo, apiResourceRepo, authSvc, jwt.SHA256Hex, cfg.BaseURL, cfg.FrontendBaseURL)
+	deviceVerifyHandler := oidc.NewDeviceVerificationHandler(tenantRepo, deviceCodeRepo, scopeDefinitionRepo, apiResourceRepo, userConsentRepo, authSvc)
+	// CIBA の認証デバイス通知はローカル用のログ出力で代替する
+	backchannelAuthHandler := oidc.NewBackchannelAuthenticationHandler(
+		tenantRepo, clientAuthenticator, backchannelAuthRepo, scopeDefinitionRepo, apiResourceRepo, userRepo, userRepo, subjectMapper, tokenSvc,
+		notifier.NewLogNotifier(log.Default()), jwt.SHA256Hex, keySvc.EncryptSecret, cfg.BaseURL,
+	)
+	backchannelApprovalHandler := oidc.NewBackchannelApprovalHandler(tenantRepo, backchannelAuthRepo, scopeDefinitionRepo, apiResourceRepo, userConsentRepo, authSvc, keySvc.DecryptSecret, cfg.FrontendBaseURL)
+	detailsApprovalHandler := oidc.NewAuthorizationDetailsApprovalHandler(tenantRepo, authorizationDetailsApprovalRepo, authSvc)
+	consentHandler := oidc.NewConsentHandler(tenantRepo, clientRepo, scopeDefinitionRepo, userConsentRepo, authSvc)
+	revokeHandler := oidc.NewRevokeHandler(clientAuthenticator, accessTokenRepo, refreshTokenRepo, tokenSvc, jwt.SHA256Hex)
 
-	e := echo.New()
+	// SAML ハンドラ初期化
+	samlMetadataHandler := saml.NewMetadataHandler(tenantRepo, keySvc, cfg.BaseURL)
+	samlSSOHandler := saml.NewSSOHandler(tenantRepo, samlServiceProviderRepo, authSvc, samlSessionRepo, keySvc, cfg.BaseURL, cfg.FrontendBaseURL)
+	samlSLOHandler := saml.NewSLOHandler(tenantRepo, samlServiceProviderRepo, samlSessionRepo, sessionRepo, keySvc, cfg.BaseURL, cfg.IsSecure())
+
+	// SCIM ハンドラ初期化
+	userRevoker := management.NewUserRevoker(sessionRepo, accessTokenRepo, refreshTokenRepo)
+	scimUserHandler := scim.NewUserHandler(userRepo, groupRepo, userRevoker, passwordHasher.HashPassword, cfg.BaseURL)
+	scimGroupHandler := scim.NewGroupHandler(groupRepo, userRepo, cfg.BaseURL)
+	scimConfigHandler := scim.NewConfigHandler(cfg.BaseURL)
 
 	e.Use(middleware.Logger())
 	e.Use(middleware.Recover())
@@ -111,13 +224,74 @@ func main() {
 	e.POST("/:tenant_code/token", tokenHandler.Handle)
 	e.GET("/:tenant_code/userinfo", userInfoHandler.Handle)
 	e.POST("/:tenant_code/revoke", revokeHandler.Handle)
+	e.POST("/:tenant_code/device/authorize", deviceAuthHandler.HandleAuthorize)
+	e.GET("/:tenant_code/device", deviceAuthHandler.HandleVerification)
+	e.POST("/:tenant_code/bc-authorize", backchannelAuthHandler.HandleAuthorize)
+	e.GET("/:tenant_code/ciba", backchannelApprovalHandler.HandleApproval)
+
+	// SAML 2.0 IdP エンドポイント
+	e.GET("/:tenant_code/saml/metadata", samlMetadataHandler.Handle)
+	e.GET("/:tenant_code/saml/sso", samlSSOHandler.Handle)
+	e.POST("/:tenant_code/saml/sso", samlSSOHandler.HandlePost)
+	e.GET("/:tenant_code/saml/idp-initiated", samlSSOHandler.HandleIdPInitiated)
+	e.GET("/:tenant_code/saml/slo", samlSLOHandler.Handle)
+	e.POST("/:tenant_code/saml/slo", samlSLOHandler.Handle)
+
+	// SCIM 2.0 プロビジョニング (RFC 7644)
+	scimGroup := e.Group("/scim/v2/:tenant_code", scim.NewAuthMiddleware(tenantRepo, scimTokenRepo, jwt.SHA256Hex))
+	scimGroup.GET("/ServiceProviderConfig", scimConfigHandler.HandleServiceProviderConfig)
+	scimGroup.GET("/ResourceTypes", scimConfigHandler.HandleResourceTypes)
+	scimGroup.GET("/Schemas", scimConfigHandler.HandleSchemas)
+	scimGroup.GET("/Users", scimUserHandler.HandleList)
+	scimGroup.POST("/Users", scimUserHandler.HandleCreate)
+	scimGroup.GET("/Users/:id", scimUserHandler.HandleGet)
+	scimGroup.PUT("/Users/:id", scimUserHandler.HandleReplace)
+	scimGroup.PATCH("/Users/:id", scimUserHandler.HandlePatch)
+	scimGroup.DELETE("/Users/:id", scimUserHandler.HandleDelete)
+	scimGroup.GET("/Groups", scimGroupHandler.HandleList)
+	scimGroup.POST("/Groups", scimGroupHandler.HandleCreate)
+	scimGroup.GET("/Groups/:id", scimGroupHandler.HandleGet)
+	scimGroup.PUT("/Groups/:id", scimGroupHandler.HandleReplace)
+	scimGroup.PATCH("/Groups/:id", scimGroupHandler.HandlePatch)
+	scimGroup.DELETE("/Groups/:id", scimGroupHandler.HandleDelete)
+
+	// 動的クライアント登録 (RFC 7591 / RFC 7592)
+	registrationHandler := management.NewRegistrationHandler(
+		tenantRepo, clientRepo, initialAccessTokenRepo,
+		passwordHasher.HashPassword, passwordHasher.VerifyPassword, keySvc.EncryptSecret, jwt.SHA256Hex, oidc.FetchSectorIdentifier,
+		cfg.BaseURL,
+	)
+	e.POST("/:tenant_code/register", registrationHandler.HandleRegister)
+	e.GET("/:tenant_code/register/:client_id", registrationHandler.HandleRead)
+	e.PUT("/:tenant_code/register/:client_id", registrationHandler.HandleUpdate)
+	e.DELETE("/:tenant_code/register/:client_id", registrationHandler.HandleDelete)
 
 	// Internal API (OP Frontend 向け)
 	e.POST("/internal/login", loginHandler.Handle)
+	e.POST("/internal/login/sms", loginHandler.HandleSMS)
 	e.GET("/internal/me", meHandler.Handle)
+	e.POST("/internal/email/verify-request", emailVerificationHandler.HandleRequest)
+	e.POST("/internal/email/verify", emailVerificationHandler.HandleVerify)
+	e.POST("/internal/email/change-request", emailChangeHandler.HandleRequest)
+	e.POST("/internal/email/change-confirm", emailChangeHandler.HandleConfirm)
+	e.POST("/internal/email/change-revert", emailChangeHandler.HandleRevert)
+	e.POST("/internal/phone/verify-request", smsHandler.HandleVerifyRequest)
+	e.POST("/internal/phone/verify", smsHandler.HandleVerify)
+	e.POST("/internal/mfa/sms/enable", smsHandler.HandleEnableMFA)
+	e.POST("/internal/mfa/sms/disable", smsHandler.HandleDisableMFA)
+	e.GET("/internal/federation/providers", federationHandler.HandleList)
+	e.GET("/internal/federation/:idp/start", federationHandler.HandleStart)
+	e.GET("/internal/federation/:idp/callback", federationHandler.HandleCallback)
+	e.POST("/internal/device/verify", deviceVerifyHandler.HandleVerify)
+	e.POST("/internal/device/decide", deviceVerifyHandler.HandleDecide)
+	e.GET("/internal/ciba/requests", backchannelApprovalHandler.HandleList)
+	e.POST("/internal/ciba/decide", backchannelApprovalHandler.HandleDecide)
+	e.POST("/internal/consent", consentHandler.HandleConsent)
+	e.GET("/internal/authorization-details", detailsApprovalHandler.HandleGet)
+	e.POST("/internal/authorization-details/decide", detailsApprovalHandler.HandleDecide)
 
 	// Admin auth サービス初期化
-	adminAuthSvc := management.NewAdminAuthService(adminUserRepo, adminSessionRepo, crypto.VerifyPassword)
+	adminAuthSvc := management.NewAdminAuthService(adminUserRepo, adminSessionRepo, passwordHasher.VerifyPassword, passwordHasher.HashPassword, passwordHasher.NeedsRehash, e.Logger)
 	adminAuthHandler := management.NewAdminAuthHandler(adminAuthSvc, adminUserRepo, cfg.IsSecure())
 
 	// Management auth エンドポイント (認証不要)
@@ -134,7 +308,84 @@ func main() {
 	mgmtGroup.GET("/tenants/:tenant_id", tenantMgmtHandler.HandleGet)
 	mgmtGroup.PUT("/tenants/:tenant_id", tenantMgmtHandler.HandleUpdate)
 
-	clientMgmtHandler := management.NewClientHandler(clientRepo, tenantRepo, crypto.HashPassword)
+	initialAccessTokenHandler := management.NewInitialAccessTokenHandler(initialAccessTokenRepo, tenantRepo, jwt.SHA256Hex)
+	mgmtGroup.GET("/tenants/:tenant_id/initial-access-tokens", initialAccessTokenHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/initial-access-tokens", initialAccessTokenHandler.HandleCreate)
+	mgmtGroup.DELETE("/tenants/:tenant_id/initial-access-tokens/:token_id", initialAccessTokenHandler.HandleDelete)
+
+	scimTokenHandler := management.NewSCIMTokenHandler(scimTokenRepo, tenantRepo, jwt.SHA256Hex)
+	mgmtGroup.GET("/tenants/:tenant_id/scim-tokens", scimTokenHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/scim-tokens", scimTokenHandler.HandleCreate)
+	mgmtGroup.DELETE("/tenants/:tenant_id/scim-tokens/:token_id", scimTokenHandler.HandleDelete)
+
+	apiResourceMgmtHandler := management.NewAPIResourceHandler(apiResourceRepo, tenantRepo)
+	mgmtGroup.GET("/tenants/:tenant_id/api-resources", apiResourceMgmtHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/api-resources", apiResourceMgmtHandler.HandleCreate)
+	mgmtGroup.GET("/api-resources/:id", apiResourceMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/api-resources/:id", apiResourceMgmtHandler.HandleUpdate)
+	mgmtGroup.DELETE("/api-resources/:id", apiResourceMgmtHandler.HandleDelete)
+
+	// authorization_details の type 管理 (RFC 9396)
+	authorizationDetailTypeMgmtHandler := management.NewAuthorizationDetailTypeHandler(authorizationDetailTypeRepo, tenantRepo)
+	mgmtGroup.GET("/tenants/:tenant_id/authorization-detail-types", authorizationDetailTypeMgmtHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/authorization-detail-types", authorizationDetailTypeMgmtHandler.HandleCreate)
+	mgmtGroup.GET("/authorization-detail-types/:id", authorizationDetailTypeMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/authorization-detail-types/:id", authorizationDetailTypeMgmtHandler.HandleUpdate)
+	mgmtGroup.DELETE("/authorization-detail-types/:id", authorizationDetailTypeMgmtHandler.HandleDelete)
+
+	userAttributeSchemaMgmtHandler := management.NewUserAttributeSchemaHandler(tenantRepo)
+	mgmtGroup.GET("/tenants/:tenant_id/user-attribute-schema", userAttributeSchemaMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/tenants/:tenant_id/user-attribute-schema", userAttributeSchemaMgmtHandler.HandleUpdate)
+
+	userMgmtHandler := management.NewUserHandler(userRepo, userAuditLogRepo, emailVerificationSvc)
+	mgmtGroup.GET("/users/:id", userMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/users/:id", userMgmtHandler.HandleUpdate)
+	mgmtGroup.POST("/users/:id/resend-email-verification", userMgmtHandler.HandleResendEmailVerification)
+	mgmtGroup.POST("/users/:id/verify-email", userMgmtHandler.HandleVerifyEmail)
+	mgmtGroup.GET("/users/:id/audit-logs", userMgmtHandler.HandleListAuditLogs)
+
+	userTransferSvc := management.NewUserTransferService(userRepo, userRevoker, crypto.PasswordHashAlgorithm)
+	userTransferMgmtHandler := management.NewUserTransferHandler(userTransferSvc, tenantRepo)
+	mgmtGroup.POST("/tenants/:tenant_id/users/import", userTransferMgmtHandler.HandleImport)
+	mgmtGroup.GET("/tenants/:tenant_id/users/export", userTransferMgmtHandler.HandleExport)
+
+	scopeMgmtHandler := management.NewScopeHandler(scopeDefinitionRepo, tenantRepo)
+	mgmtGroup.GET("/tenants/:tenant_id/scopes", scopeMgmtHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/scopes", scopeMgmtHandler.HandleCreate)
+	mgmtGroup.GET("/scopes/:id", scopeMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/scopes/:id", scopeMgmtHandler.HandleUpdate)
+	mgmtGroup.DELETE("/scopes/:id", scopeMgmtHandler.HandleDelete)
+
+	// 上流の OIDC Provider との連携
+	identityProviderMgmtHandler := management.NewIdentityProviderHandler(identityProviderRepo, tenantRepo, keySvc.EncryptSecret)
+	mgmtGroup.GET("/tenants/:tenant_id/identity-providers", identityProviderMgmtHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/identity-providers", identityProviderMgmtHandler.HandleCreate)
+	mgmtGroup.GET("/identity-providers/:id", identityProviderMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/identity-providers/:id", identityProviderMgmtHandler.HandleUpdate)
+	mgmtGroup.DELETE("/identity-providers/:id", identityProviderMgmtHandler.HandleDelete)
+
+	samlServiceProviderMgmtHandler := management.NewSAMLServiceProviderHandler(samlServiceProviderRepo, tenantRepo)
+	mgmtGroup.GET("/tenants/:tenant_id/saml-service-providers", samlServiceProviderMgmtHandler.HandleList)
+	mgmtGroup.POST("/tenants/:tenant_id/saml-service-providers", samlServiceProviderMgmtHandler.HandleCreate)
+	mgmtGroup.GET("/saml-service-providers/:id", samlServiceProviderMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/saml-service-providers/:id", samlServiceProviderMgmtHandler.HandleUpdate)
+	mgmtGroup.DELETE("/saml-service-providers/:id", samlServiceProviderMgmtHandler.HandleDelete)
+
+	ldapDirectoryMgmtHandler := management.NewLDAPDirectoryHandler(ldapDirectoryRepo, tenantRepo, ldapClient, keySvc.EncryptSecret, keySvc.DecryptSecret)
+	mgmtGroup.GET("/tenants/:tenant_id/ldap-directory", ldapDirectoryMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/tenants/:tenant_id/ldap-directory", ldapDirectoryMgmtHandler.HandlePut)
+	mgmtGroup.DELETE("/tenants/:tenant_id/ldap-directory", ldapDirectoryMgmtHandler.HandleDelete)
+	mgmtGroup.POST("/tenants/:tenant_id/ldap-directory/test", ldapDirectoryMgmtHandler.HandleTest)
+
+	userMigrationHookMgmtHandler := management.NewUserMigrationHookHandler(userMigrationHookRepo, tenantRepo, keySvc.EncryptSecret, keySvc.DecryptSecret)
+	mgmtGroup.GET("/tenants/:tenant_id/user-migration-hook", userMigrationHookMgmtHandler.HandleGet)
+	mgmtGroup.PUT("/tenants/:tenant_id/user-migration-hook", userMigrationHookMgmtHandler.HandlePut)
+	mgmtGroup.DELETE("/tenants/:tenant_id/user-migration-hook", userMigrationHookMgmtHandler.HandleDelete)
+
+	subjectMgmtHandler := management.NewSubjectHandler(tenantRepo, subjectMapper)
+	mgmtGroup.GET("/tenants/:tenant_id/subjects/:subject", subjectMgmtHandler.HandleGet)
+
+	clientMgmtHandler := management.NewClientHandler(clientRepo, tenantRepo, passwordHasher.HashPassword, keySvc.EncryptSecret, oidc.FetchSectorIdentifier)
 	mgmtGroup.GET("/tenants/:tenant_id/clients", clientMgmtHandler.HandleList)
 	mgmtGroup.POST("/tenants/:tenant_id/clients", clientMgmtHandler.HandleCreate)
 	mgmtGroup.GET("/clients/:id", clientMgmtHandler.HandleGet)
@@ -142,7 +393,7 @@ func main() {
 	mgmtGroup.DELETE("/clients/:id", clientMgmtHandler.HandleDelete)
 	mgmtGroup.PUT("/clients/:id/secret", clientMgmtHandler.HandleRotateSecret)
 
-	redirectURIMgmtHandler := management.NewRedirectURIHandler(redirectURIRepo, clientRepo)
+	redirectURIMgmtHandler := management.NewRedirectURIHandler(redirectURIRepo, clientRepo, oidc.FetchSectorIdentifier)
 	mgmtGroup.GET("/clients/:id/redirect-uris", redirectURIMgmtHandler.HandleList)
 	mgmtGroup.POST("/clients/:id/redirect-uris", redirectURIMgmtHandler.HandleCreate)
 	mgmtGroup.DELETE("/clients/:id/redirect-uris/:uri_id", redirectURIMgmtHandler.HandleDelete)
@@ -152,10 +403,43 @@ func main() {
 	mgmtGroup.POST("/keys/rotate", keyMgmtHandler.HandleRotate)
 	mgmtGroup.DELETE("/keys/:kid", keyMgmtHandler.HandleDeactivate)
 
-	incidentHandler := management.NewIncidentHandler(sessionRepo, accessTokenRepo, refreshTokenRepo)
+	incidentHandler := management.NewIncidentHandler(sessionRepo, accessTokenRepo, refreshTokenRepo, subjectMapper)
 	mgmtGroup.POST("/incidents/revoke-all-tokens", incidentHandler.HandleRevokeAll)
 	mgmtGroup.POST("/incidents/revoke-tenant-tokens", incidentHandler.HandleRevokeTenant)
 	mgmtGroup.POST("/incidents/revoke-user-tokens", incidentHandler.HandleRevokeUser)
 
-	e.Logger.Fatal(e.Start(":" + cfg.Port))
+	if !cfg.TLSEnabled() {
+		e.Logger.Fatal(e.Start(":" + cfg.Port))
+	}
+
+	cert, err := tls.LoadX509KeyPair(cfg.TLSCertFile, cfg.TLSKeyFile)
+	if err != nil {
+		log.Fatalf("failed to load TLS key pair: %v", err)
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.TLSMode == config.TLSModeMTLS {
+		// 証明書の検証はクライアントごとの認証方式に応じて ClientAuthenticator で行う
+		// (self_signed_tls_client_auth では CA 検証できないため、ここでは要求のみ)
+		tlsConfig.ClientAuth = tls.RequestClientCert
+	}
+	e.Logger.Fatal(e.StartServer(&http.Server{Addr: ":" + cfg.Port, TLSConfig: tlsConfig}))
+}
+
+// loadCertPool は PEM ファイルから証明書プールを読み込む。パスが空の場合は nil を返す。
+func loadCertPool(path string) (*x509.CertPool, error) {
+	if path == "" {
+		return nil, nil
+	}
+	pemBytes, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
 }
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/config"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/database"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/management"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/store"
+)
+
+// ユーザーの一括インポート・エクスポートツール (管理 API の users/import・users/export と同じ形式)
+// Usage:
+//
+//	go run cmd/users/main.go import -tenant <tenant_code> [-format csv|jsonl] [-dry-run] <file|->
+//	go run cmd/users/main.go export -tenant <tenant_code> [-format csv|jsonl] [-password-hash] [-o <file>]
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	switch os.Args[1] {
+	case "import":
+		runImport(os.Args[2:])
+	case "export":
+		runExport(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: users import -tenant <tenant_code> [-format csv|jsonl] [-dry-run] <file|->")
+	fmt.Fprintln(os.Stderr, "       users export -tenant <tenant_code> [-format csv|jsonl] [-password-hash] [-o <file>]")
+	os.Exit(2)
+}
+
+func runImport(args []string) {
+	fs := flag.NewFlagSet("import", flag.ExitOnError)
+	tenantCode := fs.String("tenant", "", "tenant code")
+	format := fs.String("format", management.UserFileFormatCSV, "file format (csv or jsonl)")
+	dryRun := fs.Bool("dry-run", false, "validate without saving")
+	fs.Parse(args)
+	if *tenantCode == "" || fs.NArg() != 1 {
+		usage()
+	}
+
+	in := os.Stdin
+	if path := fs.Arg(0); path != "-" {
+		f, err := os.Open(path)
+		if err != nil {
+			log.Fatalf("failed to open file: %v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	ctx := context.Background()
+	svc, tenant := setup(ctx, *tenantCode)
+	reader, err := management.NewUserRecordReader(in, *format)
+	if err != nil {
+		log.Fatalf("failed to read file: %v", err)
+	}
+	result, err := svc.Import(ctx, tenant, reader, *dryRun)
+	if err != nil {
+		log.Fatalf("import failed: %v", err)
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(result); err != nil {
+		log.Fatalf("failed to write result: %v", err)
+	}
+	if result.Failed > 0 {
+		os.Exit(1)
+	}
+}
+
+func runExport(args []string) {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	tenantCode := fs.String("tenant", "", "tenant code")
+	format := fs.String("format", management.UserFileFormatCSV, "file format (csv or jsonl)")
+	includePasswordHash := fs.Bool("password-hash", false, "include password_hash")
+	output := fs.String("o", "-", "output file")
+	fs.Parse(args)
+	if *tenantCode == "" || fs.NArg() != 0 {
+		usage()
+	}
+
+	var out io.Writer = os.Stdout
+	if *output != "-" {
+		// パスワードのハッシュを含みうるため所有者のみ読めるようにする
+		f, err := os.OpenFile(*output, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+		if err != nil {
+			log.Fatalf("failed to create file: %v", err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	ctx := context.Background()
+	svc, tenant := setup(ctx, *tenantCode)
+	writer, err := management.NewUserRecordWriter(out, *format)
+	if err != nil {
+		log.Fatalf("failed to write file: %v", err)
+	}
+	count, err := svc.Export(ctx, tenant, writer, *includePasswordHash)
+	if err != nil {
+		log.Fatalf("export failed: %v", err)
+	}
+	fmt.Fprintf(os.Stderr, "exported %d users\n", count)
+}
+
+func setup(ctx context.Context, tenantCode string) (*management.UserTransferService, *model.Tenant) {
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("failed to load config: %v", err)
+	}
+
+	db, err := database.NewDB(cfg.DSN)
+	if err != nil {
+		log.Fatalf("failed to connect database: %v", err)
+	}
+
+	tenant, err := store.NewTenantRepository(db).FindByCode(ctx, tenantCode)
+	if err != nil {
+		log.Fatalf("failed to find tenant: %v", err)
+	}
+	if tenant == nil {
+		log.Fatalf("tenant not found: %s", tenantCode)
+	}
+
+	userRevoker := management.NewUserRevoker(
+		store.NewSessionRepository(db),
+		store.NewAccessTokenRepository(db),
+		store.NewRefreshTokenRepository(db),
+	)
+	svc := management.NewUserTransferService(store.NewUserRepository(db), userRevoker, crypto.PasswordHashAlgorithm)
+	return svc, tenant
+}
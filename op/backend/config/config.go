@@ -3,15 +3,46 @@ package config
 import (
 	"fmt"
 	"os"
+	"runtime"
+	"strconv"
 	"strings"
 )
 
+// TLS モード
+const (
+	TLSModeOff  = "off"  // 平文 HTTP (TLS 終端はリバースプロキシで行う)
+	TLSModeTLS  = "tls"  // TLS で待ち受け、クライアント証明書は要求しない
+	TLSModeMTLS = "mtls" // TLS で待ち受け、クライアント証明書を要求する (RFC 8705)
+)
+
 type Config struct {
 	Port             string
 	DSN              string
 	BaseURL          string
 	KeyEncryptionKey string
 	FrontendBaseURL  string
+
+	TLSMode         string
+	TLSCertFile     string
+	TLSKeyFile      string
+	TLSClientCAFile string
+	// ClientCertHeader は TLS 終端プロキシがクライアント証明書 (URL エンコード済み PEM) を渡すヘッダ名
+	ClientCertHeader string
+	// TrustedProxies は ClientCertHeader を信頼する送信元の CIDR 一覧
+	TrustedProxies []string
+
+	// SMTPAddr は送信に使う SMTP サーバー (host:port)。空の場合はメールを送信せずログに出力する
+	SMTPAddr     string
+	SMTPFrom     string
+	SMTPUsername string
+	SMTPPassword string
+
+	// パスワードハッシュ (argon2id) のパラメータ。これより弱いハッシュはログインの成功時に作り直す
+	Argon2MemoryKiB uint32
+	Argon2Time      uint32
+	Argon2Threads   uint8
+	// Argon2MaxConcurrency は同時に計算するパスワードハッシュの上限。既定値は CPU 数
+	Argon2MaxConcurrency int
 }
 
 func Load() (*Config, error) {
@@ -21,6 +52,16 @@ func Load() (*Config, error) {
 		BaseURL:          os.Getenv("OP_BACKEND_BASE_URL"),
 		KeyEncryptionKey: os.Getenv("OP_KEY_ENCRYPTION_KEY"),
 		FrontendBaseURL:  os.Getenv("OP_FRONTEND_BASE_URL"),
+		TLSMode:          os.Getenv("OP_TLS_MODE"),
+		TLSCertFile:      os.Getenv("OP_TLS_CERT_FILE"),
+		TLSKeyFile:       os.Getenv("OP_TLS_KEY_FILE"),
+		TLSClientCAFile:  os.Getenv("OP_TLS_CLIENT_CA_FILE"),
+		ClientCertHeader: os.Getenv("OP_CLIENT_CERT_HEADER"),
+		TrustedProxies:   splitList(os.Getenv("OP_TRUSTED_PROXIES")),
+		SMTPAddr:         os.Getenv("OP_SMTP_ADDR"),
+		SMTPFrom:         os.Getenv("OP_SMTP_FROM"),
+		SMTPUsername:     os.Getenv("OP_SMTP_USERNAME"),
+		SMTPPassword:     os.Getenv("OP_SMTP_PASSWORD"),
 	}
 
 	if cfg.Port == "" {
@@ -39,6 +80,29 @@ func Load() (*Config, error) {
 		return nil, fmt.Errorf("OP_FRONTEND_BASE_URL is required")
 	}
 
+	switch cfg.TLSMode {
+	case "":
+		cfg.TLSMode = TLSModeOff
+	case TLSModeOff:
+	case TLSModeTLS, TLSModeMTLS:
+		if cfg.TLSCertFile == "" || cfg.TLSKeyFile == "" {
+			return nil, fmt.Errorf("OP_TLS_CERT_FILE and OP_TLS_KEY_FILE are required when OP_TLS_MODE=%s", cfg.TLSMode)
+		}
+	default:
+		return nil, fmt.Errorf("OP_TLS_MODE must be one of off, tls, mtls")
+	}
+	if cfg.ClientCertHeader != "" && len(cfg.TrustedProxies) == 0 {
+		return nil, fmt.Errorf("OP_TRUSTED_PROXIES is required when OP_CLIENT_CERT_HEADER is set")
+	}
+
+	if cfg.SMTPAddr != "" && cfg.SMTPFrom == "" {
+		return nil, fmt.Errorf("OP_SMTP_FROM is required when OP_SMTP_ADDR is set")
+	}
+
+	if err := cfg.loadArgon2(); err != nil {
+		return nil, err
+	}
+
 	// issuer URL の末尾スラッシュを除去 (OIDC Discovery 1.0 Section 4.1)
 	cfg.BaseURL = strings.TrimRight(cfg.BaseURL, "/")
 
@@ -48,3 +112,58 @@ func Load() (*Config, error) {
 func (c *Config) IsSecure() bool {
 	return strings.HasPrefix(c.BaseURL, "https://")
 }
+
+// TLSEnabled はサーバー自身が TLS で待ち受けるか判定する
+func (c *Config) TLSEnabled() bool {
+	return c.TLSMode == TLSModeTLS || c.TLSMode == TLSModeMTLS
+}
+
+// loadArgon2 は argon2id のパラメータを読み込む。未設定の項目は OWASP 推奨値 (64 MiB, t=3, p=4) にする。
+// 範囲の検証は crypto.NewPasswordHasher で行う
+func (c *Config) loadArgon2() error {
+	memory, err := parseUint("OP_ARGON2_MEMORY_KIB", 64*1024, 32)
+	if err != nil {
+		return err
+	}
+	t, err := parseUint("OP_ARGON2_TIME", 3, 32)
+	if err != nil {
+		return err
+	}
+	threads, err := parseUint("OP_ARGON2_THREADS", 4, 8)
+	if err != nil {
+		return err
+	}
+	concurrency, err := parseUint("OP_ARGON2_MAX_CONCURRENCY", uint64(runtime.NumCPU()), 16)
+	if err != nil {
+		return err
+	}
+	c.Argon2MemoryKiB = uint32(memory)
+	c.Argon2Time = uint32(t)
+	c.Argon2Threads = uint8(threads)
+	c.Argon2MaxConcurrency = int(concurrency)
+	return nil
+}
+
+// parseUint は符号なし整数の環境変数を読み込む。未設定の場合は def を返す
+func parseUint(name string, def uint64, bitSize int) (uint64, error) {
+	v := os.Getenv(name)
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.ParseUint(v, 10, bitSize)
+	if err != nil {
+		return 0, fmt.Errorf("%s must be an unsigned integer", name)
+	}
+	return n, nil
+}
+
+// splitList はカンマ区切りの環境変数を空要素を除いて分割する
+func splitList(v string) []string {
+	var out []string
+	for _, s := range strings.Split(v, ",") {
+		if s = strings.TrimSpace(s); s != "" {
+			out = append(out, s)
+		}
+	}
+	return out
+}
@@ -0,0 +1,9 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS client_assertion_jtis;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS jwks_uri;
+ALTER TABLE clients DROP COLUMN IF EXISTS jwks;
+ALTER TABLE clients DROP COLUMN IF EXISTS client_secret_encrypted;
+UPDATE clients SET client_secret_hash = '' WHERE client_secret_hash IS NULL;
+ALTER TABLE clients ALTER COLUMN client_secret_hash SET NOT NULL;
@@ -0,0 +1,26 @@
+SET search_path TO op;
+
+ALTER TABLE clients ALTER COLUMN client_secret_hash DROP NOT NULL;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS client_secret_encrypted TEXT;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS jwks TEXT;
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS jwks_uri VARCHAR(2048);
+
+COMMENT ON COLUMN clients.client_secret_hash IS 'client_secret のハッシュ値。公開クライアント (none / private_key_jwt) は NULL';
+COMMENT ON COLUMN clients.client_secret_encrypted IS 'AES-256-GCM で暗号化された client_secret。client_secret_jwt (HS256) の検証に使用';
+COMMENT ON COLUMN clients.jwks IS 'private_key_jwt 用にクライアントが登録した JWK Set (JSON)';
+COMMENT ON COLUMN clients.jwks_uri IS 'private_key_jwt 用にクライアントが公開する JWK Set の URL';
+
+CREATE TABLE IF NOT EXISTS client_assertion_jtis (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id   UUID         NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    jti         VARCHAR(255) NOT NULL,
+    expires_at  TIMESTAMPTZ  NOT NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    CONSTRAINT uq_client_assertion_jtis_client_id_jti UNIQUE (client_id, jti)
+);
+
+CREATE INDEX idx_client_assertion_jtis_expires_at ON client_assertion_jtis(expires_at);
+
+COMMENT ON TABLE client_assertion_jtis IS 'クライアントアサーション (RFC 7523) の使用済み jti。リプレイ攻撃防止用';
+COMMENT ON COLUMN client_assertion_jtis.jti IS 'アサーションの JWT ID';
+COMMENT ON COLUMN client_assertion_jtis.expires_at IS 'アサーションの exp。これ以降は行を削除してよい';
@@ -0,0 +1,8 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_certificate_bound_access_tokens;
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_auth_san_email;
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_auth_san_ip;
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_auth_san_uri;
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_auth_san_dns;
+ALTER TABLE clients DROP COLUMN IF EXISTS tls_client_auth_subject_dn;
@@ -0,0 +1,15 @@
+SET search_path TO op;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_auth_subject_dn VARCHAR(1024);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_auth_san_dns VARCHAR(255);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_auth_san_uri VARCHAR(2048);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_auth_san_ip VARCHAR(45);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_auth_san_email VARCHAR(255);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS tls_client_certificate_bound_access_tokens BOOLEAN NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN clients.tls_client_auth_subject_dn IS 'tls_client_auth: 期待する証明書の Subject DN (RFC 8705 Section 2.1.2)';
+COMMENT ON COLUMN clients.tls_client_auth_san_dns IS 'tls_client_auth: 期待する SAN dNSName';
+COMMENT ON COLUMN clients.tls_client_auth_san_uri IS 'tls_client_auth: 期待する SAN uniformResourceIdentifier';
+COMMENT ON COLUMN clients.tls_client_auth_san_ip IS 'tls_client_auth: 期待する SAN iPAddress';
+COMMENT ON COLUMN clients.tls_client_auth_san_email IS 'tls_client_auth: 期待する SAN rfc822Name';
+COMMENT ON COLUMN clients.tls_client_certificate_bound_access_tokens IS 'アクセストークンをクライアント証明書に紐付けるか (cnf.x5t#S256, RFC 8705 Section 3)';
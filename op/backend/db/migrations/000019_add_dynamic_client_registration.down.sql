@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS initial_access_tokens;
+ALTER TABLE clients DROP COLUMN IF EXISTS registration_access_token_hash;
+ALTER TABLE tenants DROP COLUMN IF EXISTS registration_policy;
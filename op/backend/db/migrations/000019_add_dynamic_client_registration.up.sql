@@ -0,0 +1,25 @@
+SET search_path TO op;
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS registration_policy VARCHAR(31) NOT NULL DEFAULT 'disabled';
+
+COMMENT ON COLUMN tenants.registration_policy IS '動的クライアント登録 (RFC 7591) の受付方針。disabled / initial_access_token / open';
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS registration_access_token_hash VARCHAR(64);
+
+COMMENT ON COLUMN clients.registration_access_token_hash IS '登録アクセストークン (RFC 7592) の SHA-256 ハッシュ値。管理 API で作成したクライアントは NULL';
+
+CREATE TABLE IF NOT EXISTS initial_access_tokens (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id    UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    token_hash   VARCHAR(64)  NOT NULL UNIQUE,
+    description  VARCHAR(255),
+    expires_at   TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_initial_access_tokens_tenant_id ON initial_access_tokens(tenant_id);
+
+COMMENT ON TABLE initial_access_tokens IS '動的クライアント登録用の初期アクセストークン (RFC 7591 Section 3)。管理者が発行する';
+COMMENT ON COLUMN initial_access_tokens.token_hash IS 'トークンの SHA-256 ハッシュ値。平文は発行時のみ返す';
+COMMENT ON COLUMN initial_access_tokens.description IS '用途メモ';
+COMMENT ON COLUMN initial_access_tokens.expires_at IS '有効期限。NULL の場合は無期限';
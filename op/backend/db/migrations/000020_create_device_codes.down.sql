@@ -0,0 +1,4 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS device_user_code_failures;
+DROP TABLE IF EXISTS device_codes;
@@ -0,0 +1,37 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS device_codes (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id         UUID          NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    device_code_hash  VARCHAR(64)   NOT NULL UNIQUE,
+    user_code         VARCHAR(16)   NOT NULL UNIQUE,
+    scope             VARCHAR(1024) NOT NULL DEFAULT '',
+    status            VARCHAR(16)   NOT NULL DEFAULT 'pending',
+    session_id        UUID          REFERENCES sessions(id) ON DELETE CASCADE,
+    poll_interval     INT           NOT NULL DEFAULT 5,
+    last_polled_at    TIMESTAMPTZ,
+    expires_at        TIMESTAMPTZ   NOT NULL,
+    created_at        TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_codes_expires_at ON device_codes(expires_at);
+
+COMMENT ON TABLE device_codes IS 'デバイス認可リクエスト (RFC 8628)。CLI・TV 等の入力制約のあるクライアント向け';
+COMMENT ON COLUMN device_codes.device_code_hash IS 'device_code の SHA-256 ハッシュ値。平文はクライアントにのみ返す';
+COMMENT ON COLUMN device_codes.user_code IS 'ユーザーが検証画面で入力するコード (区切り文字なしの正規化済み値)';
+COMMENT ON COLUMN device_codes.status IS 'pending: 承認待ち / approved: 承認済み / denied: 拒否 / consumed: トークン発行済み';
+COMMENT ON COLUMN device_codes.session_id IS '承認したユーザーの認証セッション';
+COMMENT ON COLUMN device_codes.poll_interval IS 'トークンエンドポイントのポーリング間隔（秒）。slow_down のたびに 5 秒延長する';
+COMMENT ON COLUMN device_codes.last_polled_at IS '最終ポーリング日時';
+
+CREATE TABLE IF NOT EXISTS device_user_code_failures (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id  UUID         NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    ip_address  VARCHAR(45)  NOT NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_device_user_code_failures_session_id ON device_user_code_failures(session_id, created_at);
+CREATE INDEX idx_device_user_code_failures_ip_address ON device_user_code_failures(ip_address, created_at);
+
+COMMENT ON TABLE device_user_code_failures IS 'user_code 入力失敗の記録。総当たり攻撃の検知に使用 (RFC 8628 Section 5.1)';
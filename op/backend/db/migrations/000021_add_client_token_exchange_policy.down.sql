@@ -0,0 +1,4 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS token_exchange_scopes;
+ALTER TABLE clients DROP COLUMN IF EXISTS token_exchange_audiences;
@@ -0,0 +1,7 @@
+SET search_path TO op;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS token_exchange_audiences JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS token_exchange_scopes JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN clients.token_exchange_audiences IS 'トークン交換 (RFC 8693) で要求できる audience の一覧';
+COMMENT ON COLUMN clients.token_exchange_scopes IS 'トークン交換で発行できるスコープの上限';
@@ -0,0 +1,8 @@
+SET search_path TO op;
+
+ALTER TABLE access_tokens DROP COLUMN IF EXISTS resource;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS resources;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS resources;
+
+DROP TABLE IF EXISTS api_resources;
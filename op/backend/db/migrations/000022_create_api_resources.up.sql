@@ -0,0 +1,28 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS api_resources (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id             UUID          NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    identifier            VARCHAR(2048) NOT NULL,
+    name                  VARCHAR(255)  NOT NULL,
+    scopes                JSONB         NOT NULL DEFAULT '[]',
+    access_token_lifetime INTEGER,
+    created_at            TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at            TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, identifier)
+);
+
+COMMENT ON TABLE api_resources IS 'テナントに登録された API リソース (RFC 8707 のリソース指標)';
+COMMENT ON COLUMN api_resources.identifier IS 'リソース識別子 (絶対 URI)。アクセストークンの aud になる';
+COMMENT ON COLUMN api_resources.scopes IS 'このリソース向けのアクセストークンに含められるスコープ';
+COMMENT ON COLUMN api_resources.access_token_lifetime IS 'アクセストークンの有効期間 (秒)。NULL の場合はテナントの設定を使う';
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS resources JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope VARCHAR(1024);
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS resources JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS resource VARCHAR(2048);
+
+COMMENT ON COLUMN authorization_codes.resources IS '認可リクエストで指定された resource の一覧';
+COMMENT ON COLUMN refresh_tokens.scope IS '認可されたスコープ全体。リフレッシュ時に resource ごとに絞り込む元になる';
+COMMENT ON COLUMN refresh_tokens.resources IS '認可された resource の一覧';
+COMMENT ON COLUMN access_tokens.resource IS 'aud に設定した resource。NULL の場合は aud がクライアント';
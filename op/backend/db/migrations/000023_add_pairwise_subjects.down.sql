@@ -0,0 +1,8 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS pairwise_subjects;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS sector_identifier_uri;
+ALTER TABLE clients DROP COLUMN IF EXISTS subject_type;
+
+ALTER TABLE tenants DROP COLUMN IF EXISTS pairwise_salt;
@@ -0,0 +1,27 @@
+SET search_path TO op;
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS pairwise_salt VARCHAR(64) NOT NULL DEFAULT encode(sha256(gen_random_uuid()::text::bytea), 'hex');
+
+COMMENT ON COLUMN tenants.pairwise_salt IS 'pairwise sub の計算に使う秘密のソルト (OIDC Core 1.0 Section 8.1)。外部に公開しない';
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS subject_type VARCHAR(15) NOT NULL DEFAULT 'public';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS sector_identifier_uri VARCHAR(2048);
+
+COMMENT ON COLUMN clients.subject_type IS 'sub の種別。public / pairwise';
+COMMENT ON COLUMN clients.sector_identifier_uri IS 'pairwise sub のセクターを決める URI。リダイレクト URI の一覧を返す JSON 文書';
+
+CREATE TABLE IF NOT EXISTS pairwise_subjects (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id         UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    sector_identifier VARCHAR(255) NOT NULL,
+    user_id           UUID         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    subject           VARCHAR(64)  NOT NULL,
+    created_at        TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, subject),
+    UNIQUE (tenant_id, sector_identifier, user_id)
+);
+
+CREATE INDEX idx_pairwise_subjects_user_id ON pairwise_subjects(user_id);
+
+COMMENT ON TABLE pairwise_subjects IS '発行済みの pairwise sub とユーザーの対応。sub からユーザーを逆引きするために保存する';
+COMMENT ON COLUMN pairwise_subjects.sector_identifier IS 'セクター識別子 (sector_identifier_uri またはリダイレクト URI のホスト名)';
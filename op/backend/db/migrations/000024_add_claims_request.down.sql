@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS claims;
+ALTER TABLE access_tokens DROP COLUMN IF EXISTS claims;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS claims;
@@ -0,0 +1,9 @@
+SET search_path TO op;
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS claims JSONB;
+ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS claims JSONB;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS claims JSONB;
+
+COMMENT ON COLUMN authorization_codes.claims IS '認可リクエストの claims パラメータ (OIDC Core 1.0 Section 5.5)';
+COMMENT ON COLUMN access_tokens.claims IS '認可時の claims パラメータ。UserInfo で userinfo メンバーを参照する';
+COMMENT ON COLUMN refresh_tokens.claims IS '認可時の claims パラメータ。リフレッシュ後のアクセストークンに引き継ぐ';
@@ -0,0 +1,7 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS userinfo_encrypted_response_enc;
+ALTER TABLE clients DROP COLUMN IF EXISTS userinfo_encrypted_response_alg;
+ALTER TABLE clients DROP COLUMN IF EXISTS id_token_encrypted_response_enc;
+ALTER TABLE clients DROP COLUMN IF EXISTS id_token_encrypted_response_alg;
+ALTER TABLE clients DROP COLUMN IF EXISTS userinfo_signed_response_alg;
@@ -0,0 +1,13 @@
+SET search_path TO op;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS userinfo_signed_response_alg VARCHAR(31);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS id_token_encrypted_response_alg VARCHAR(31);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS id_token_encrypted_response_enc VARCHAR(31);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS userinfo_encrypted_response_alg VARCHAR(31);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS userinfo_encrypted_response_enc VARCHAR(31);
+
+COMMENT ON COLUMN clients.userinfo_signed_response_alg IS 'UserInfo レスポンスの署名アルゴリズム。NULL の場合は JSON で返す';
+COMMENT ON COLUMN clients.id_token_encrypted_response_alg IS 'ID トークン暗号化の鍵管理アルゴリズム (RSA-OAEP-256 / ECDH-ES)。NULL の場合は暗号化しない';
+COMMENT ON COLUMN clients.id_token_encrypted_response_enc IS 'ID トークン暗号化のコンテンツ暗号化アルゴリズム (A256GCM)';
+COMMENT ON COLUMN clients.userinfo_encrypted_response_alg IS 'UserInfo レスポンス暗号化の鍵管理アルゴリズム (RSA-OAEP-256 / ECDH-ES)。NULL の場合は暗号化しない';
+COMMENT ON COLUMN clients.userinfo_encrypted_response_enc IS 'UserInfo レスポンス暗号化のコンテンツ暗号化アルゴリズム (A256GCM)';
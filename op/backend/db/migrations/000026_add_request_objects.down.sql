@@ -0,0 +1,4 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS require_signed_request_object;
+ALTER TABLE clients DROP COLUMN IF EXISTS request_uris;
@@ -0,0 +1,7 @@
+SET search_path TO op;
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS request_uris JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS require_signed_request_object BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN clients.request_uris IS '参照渡しのリクエストオブジェクトとして取得を許可する request_uri (https) の一覧';
+COMMENT ON COLUMN clients.require_signed_request_object IS 'TRUE の場合、認可リクエストに署名付きリクエストオブジェクトを必須とする (RFC 9101 Section 10.5)';
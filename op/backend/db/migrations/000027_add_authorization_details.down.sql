@@ -0,0 +1,7 @@
+SET search_path TO op;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS authorization_details;
+ALTER TABLE access_tokens DROP COLUMN IF EXISTS authorization_details;
+ALTER TABLE authorization_codes DROP COLUMN IF EXISTS authorization_details;
+
+DROP TABLE IF EXISTS authorization_detail_types;
@@ -0,0 +1,25 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS authorization_detail_types (
+    id          UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id   UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    type        VARCHAR(255) NOT NULL,
+    description TEXT         NOT NULL DEFAULT '',
+    schema      JSONB        NOT NULL,
+    created_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at  TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, type)
+);
+
+COMMENT ON TABLE authorization_detail_types IS 'テナントに登録された authorization_details の type (RFC 9396)';
+COMMENT ON COLUMN authorization_detail_types.type IS 'authorization_details 要素の type の値';
+COMMENT ON COLUMN authorization_detail_types.description IS 'ログイン・確認画面でユーザーに示す説明';
+COMMENT ON COLUMN authorization_detail_types.schema IS '要素を検証する JSON Schema (サブセット)';
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS authorization_details JSONB;
+ALTER TABLE access_tokens ADD COLUMN IF NOT EXISTS authorization_details JSONB;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS authorization_details JSONB;
+
+COMMENT ON COLUMN authorization_codes.authorization_details IS '認可リクエストの authorization_details (RFC 9396)';
+COMMENT ON COLUMN access_tokens.authorization_details IS 'アクセストークンに含めた authorization_details';
+COMMENT ON COLUMN refresh_tokens.authorization_details IS '認可された authorization_details。リフレッシュ時はこの範囲内でのみ絞り込める';
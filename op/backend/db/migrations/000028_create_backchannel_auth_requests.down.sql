@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS backchannel_client_notification_endpoint;
+ALTER TABLE clients DROP COLUMN IF EXISTS backchannel_token_delivery_mode;
+DROP TABLE IF EXISTS backchannel_auth_requests;
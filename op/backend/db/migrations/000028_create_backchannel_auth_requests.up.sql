@@ -0,0 +1,39 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS backchannel_auth_requests (
+    id                                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    client_id                           UUID          NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    user_id                             UUID          NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    auth_req_id_hash                    VARCHAR(64)   NOT NULL UNIQUE,
+    auth_req_id_encrypted               TEXT,
+    scope                               VARCHAR(1024) NOT NULL DEFAULT '',
+    binding_message                     VARCHAR(255),
+    delivery_mode                       VARCHAR(8)    NOT NULL,
+    client_notification_token_encrypted TEXT,
+    status                              VARCHAR(16)   NOT NULL DEFAULT 'pending',
+    session_id                          UUID          REFERENCES sessions(id) ON DELETE CASCADE,
+    poll_interval                       INT           NOT NULL DEFAULT 5,
+    last_polled_at                      TIMESTAMPTZ,
+    expires_at                          TIMESTAMPTZ   NOT NULL,
+    created_at                          TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_backchannel_auth_requests_user_id ON backchannel_auth_requests(user_id, status);
+CREATE INDEX idx_backchannel_auth_requests_expires_at ON backchannel_auth_requests(expires_at);
+
+COMMENT ON TABLE backchannel_auth_requests IS 'CIBA のバックチャネル認証リクエスト (OpenID Connect CIBA Core 1.0)';
+COMMENT ON COLUMN backchannel_auth_requests.user_id IS 'login_hint / id_token_hint から特定した認証対象のユーザー';
+COMMENT ON COLUMN backchannel_auth_requests.auth_req_id_hash IS 'auth_req_id の SHA-256 ハッシュ値';
+COMMENT ON COLUMN backchannel_auth_requests.auth_req_id_encrypted IS 'ping モードの通知に使う auth_req_id (AES-256-GCM 暗号化)。poll モードでは NULL';
+COMMENT ON COLUMN backchannel_auth_requests.binding_message IS '認証デバイスと消費デバイスの双方に表示する確認用メッセージ';
+COMMENT ON COLUMN backchannel_auth_requests.delivery_mode IS 'poll / ping';
+COMMENT ON COLUMN backchannel_auth_requests.client_notification_token_encrypted IS 'ping 通知の Bearer トークン (AES-256-GCM 暗号化)。poll モードでは NULL';
+COMMENT ON COLUMN backchannel_auth_requests.status IS 'pending: 承認待ち / approved: 承認済み / denied: 拒否 / consumed: トークン発行済み';
+COMMENT ON COLUMN backchannel_auth_requests.session_id IS '承認したユーザーの認証セッション';
+COMMENT ON COLUMN backchannel_auth_requests.poll_interval IS 'トークンエンドポイントのポーリング間隔（秒）。slow_down のたびに 5 秒延長する';
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS backchannel_token_delivery_mode VARCHAR(8);
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS backchannel_client_notification_endpoint VARCHAR(2048);
+
+COMMENT ON COLUMN clients.backchannel_token_delivery_mode IS 'CIBA のトークン受け渡し方式 (poll / ping)。CIBA を使わないクライアントでは NULL';
+COMMENT ON COLUMN clients.backchannel_client_notification_endpoint IS 'ping モードの通知先 (https)';
@@ -0,0 +1,20 @@
+SET search_path TO op;
+
+ALTER TABLE tenants DROP COLUMN IF EXISTS user_attribute_schema;
+
+ALTER TABLE users DROP COLUMN IF EXISTS custom_attributes;
+ALTER TABLE users DROP COLUMN IF EXISTS address;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_number_verified;
+ALTER TABLE users DROP COLUMN IF EXISTS phone_number;
+ALTER TABLE users DROP COLUMN IF EXISTS locale;
+ALTER TABLE users DROP COLUMN IF EXISTS zoneinfo;
+ALTER TABLE users DROP COLUMN IF EXISTS birthdate;
+ALTER TABLE users DROP COLUMN IF EXISTS gender;
+ALTER TABLE users DROP COLUMN IF EXISTS website;
+ALTER TABLE users DROP COLUMN IF EXISTS picture;
+ALTER TABLE users DROP COLUMN IF EXISTS profile;
+ALTER TABLE users DROP COLUMN IF EXISTS preferred_username;
+ALTER TABLE users DROP COLUMN IF EXISTS nickname;
+ALTER TABLE users DROP COLUMN IF EXISTS middle_name;
+ALTER TABLE users DROP COLUMN IF EXISTS family_name;
+ALTER TABLE users DROP COLUMN IF EXISTS given_name;
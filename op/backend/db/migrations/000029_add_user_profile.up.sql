@@ -0,0 +1,33 @@
+SET search_path TO op;
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS given_name            VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS family_name           VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS middle_name           VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS nickname              VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS preferred_username    VARCHAR(255);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS profile               VARCHAR(2048);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS picture               VARCHAR(2048);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS website               VARCHAR(2048);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS gender                VARCHAR(63);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS birthdate             VARCHAR(10);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS zoneinfo              VARCHAR(63);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS locale                VARCHAR(35);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_number          VARCHAR(32);
+ALTER TABLE users ADD COLUMN IF NOT EXISTS phone_number_verified BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS address               JSONB;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS custom_attributes     JSONB NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN users.given_name IS '名（OIDC given_name クレーム, profile スコープ）';
+COMMENT ON COLUMN users.family_name IS '姓（OIDC family_name クレーム, profile スコープ）';
+COMMENT ON COLUMN users.picture IS 'プロフィール画像の URL（OIDC picture クレーム, profile スコープ）';
+COMMENT ON COLUMN users.birthdate IS '生年月日 YYYY-MM-DD または YYYY（OIDC birthdate クレーム, profile スコープ）';
+COMMENT ON COLUMN users.zoneinfo IS 'IANA タイムゾーン名（OIDC zoneinfo クレーム, profile スコープ）';
+COMMENT ON COLUMN users.locale IS 'BCP 47 言語タグ（OIDC locale クレーム, profile スコープ）';
+COMMENT ON COLUMN users.phone_number IS 'E.164 形式の電話番号（OIDC phone_number クレーム, phone スコープ）';
+COMMENT ON COLUMN users.phone_number_verified IS '電話番号確認済みフラグ（OIDC phone_number_verified クレーム, phone スコープ）';
+COMMENT ON COLUMN users.address IS '住所（OIDC address クレーム, address スコープ）。OIDC Core 1.0 Section 5.1.1 の構造';
+COMMENT ON COLUMN users.custom_attributes IS 'テナントの user_attribute_schema で定義されたカスタム属性';
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS user_attribute_schema JSONB;
+
+COMMENT ON COLUMN tenants.user_attribute_schema IS 'ユーザーのカスタム属性を定義する JSON Schema（object 型）。NULL の場合はカスタム属性なし';
@@ -0,0 +1,7 @@
+SET search_path TO op;
+
+ALTER TABLE clients DROP COLUMN IF EXISTS default_scopes;
+ALTER TABLE clients DROP COLUMN IF EXISTS allowed_scopes;
+
+DROP TABLE IF EXISTS user_consents;
+DROP TABLE IF EXISTS scope_definitions;
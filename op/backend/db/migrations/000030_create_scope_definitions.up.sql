@@ -0,0 +1,37 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS scope_definitions (
+    id               UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id        UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    name             VARCHAR(255) NOT NULL,
+    description      TEXT         NOT NULL DEFAULT '',
+    consent_required BOOLEAN      NOT NULL DEFAULT FALSE,
+    claims           JSONB        NOT NULL DEFAULT '[]',
+    created_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at       TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, name)
+);
+
+COMMENT ON TABLE scope_definitions IS 'テナントが定義したカスタムスコープ';
+COMMENT ON COLUMN scope_definitions.description IS '同意画面でユーザーに示す説明';
+COMMENT ON COLUMN scope_definitions.consent_required IS 'TRUE の場合、クライアントごとにユーザーの同意を得てから認可する';
+COMMENT ON COLUMN scope_definitions.claims IS 'スコープ要求時に返すクレーム。[{"claim", "attribute" | "value"}]';
+
+CREATE TABLE IF NOT EXISTS user_consents (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    client_id  UUID        NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    scopes     JSONB       NOT NULL DEFAULT '[]',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (user_id, client_id)
+);
+
+COMMENT ON TABLE user_consents IS 'ユーザーがクライアントに同意したスコープ';
+COMMENT ON COLUMN user_consents.scopes IS '同意済みのスコープ (consent_required のスコープのみ)';
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS allowed_scopes JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS default_scopes JSONB NOT NULL DEFAULT '[]';
+
+COMMENT ON COLUMN clients.allowed_scopes IS '要求できるスコープ。空の場合はテナントで利用できる全てのスコープ';
+COMMENT ON COLUMN clients.default_scopes IS 'scope を省略した認可リクエストに使うスコープ';
@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+ALTER TABLE tenants DROP COLUMN IF EXISTS email_verification_policy;
+
+DROP TABLE IF EXISTS email_verification_tokens;
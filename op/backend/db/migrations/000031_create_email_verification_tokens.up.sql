@@ -0,0 +1,22 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS email_verification_tokens (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id    UUID         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    email      VARCHAR(255) NOT NULL,
+    token_hash VARCHAR(64)  NOT NULL UNIQUE,
+    expires_at TIMESTAMPTZ  NOT NULL,
+    used_at    TIMESTAMPTZ,
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_verification_tokens_user_id ON email_verification_tokens(user_id);
+
+COMMENT ON TABLE email_verification_tokens IS 'メールアドレス確認用のワンタイムトークン';
+COMMENT ON COLUMN email_verification_tokens.email IS '確認対象のメールアドレス。確認時にユーザーのメールアドレスと一致しない場合は無効';
+COMMENT ON COLUMN email_verification_tokens.token_hash IS 'トークンの SHA-256 ハッシュ (hex)。平文は確認メールにのみ含める';
+COMMENT ON COLUMN email_verification_tokens.used_at IS '使用日時。一度使用したトークンは再利用できない';
+
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS email_verification_policy VARCHAR(31) NOT NULL DEFAULT 'optional';
+
+COMMENT ON COLUMN tenants.email_verification_policy IS 'メールアドレス未確認ユーザーの扱い: optional（制限なし） / login（ログイン不可） / authorization（認可不可）';
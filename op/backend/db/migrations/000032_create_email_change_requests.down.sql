@@ -0,0 +1,6 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS user_audit_logs;
+DROP TABLE IF EXISTS email_change_requests;
+
+DROP INDEX IF EXISTS uq_users_tenant_id_email;
@@ -0,0 +1,43 @@
+SET search_path TO op;
+
+-- メールアドレスはテナント内で一意（大文字小文字を区別しない）。既存データに重複がある場合は解消してから適用する
+CREATE UNIQUE INDEX IF NOT EXISTS uq_users_tenant_id_email ON users (tenant_id, LOWER(email));
+
+CREATE TABLE IF NOT EXISTS email_change_requests (
+    id                 UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id            UUID         NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    old_email          VARCHAR(255) NOT NULL,
+    old_email_verified BOOLEAN      NOT NULL,
+    new_email          VARCHAR(255) NOT NULL,
+    token_hash         VARCHAR(64)  NOT NULL UNIQUE,
+    expires_at         TIMESTAMPTZ  NOT NULL,
+    confirmed_at       TIMESTAMPTZ,
+    revert_token_hash  VARCHAR(64)  UNIQUE,
+    revert_expires_at  TIMESTAMPTZ,
+    reverted_at        TIMESTAMPTZ,
+    created_at         TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_change_requests_user_id ON email_change_requests(user_id);
+
+COMMENT ON TABLE email_change_requests IS 'メールアドレスの変更リクエスト。新しいアドレスで確認するまで users.email は変更しない';
+COMMENT ON COLUMN email_change_requests.old_email_verified IS '変更前の email_verified。取り消し時に戻す';
+COMMENT ON COLUMN email_change_requests.token_hash IS '新しいアドレスに送る確認用トークンの SHA-256 ハッシュ (hex)';
+COMMENT ON COLUMN email_change_requests.revert_token_hash IS '変更前のアドレスに送る取り消し用トークンの SHA-256 ハッシュ (hex)。変更の確定時に発行する';
+
+CREATE TABLE IF NOT EXISTS user_audit_logs (
+    id         UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id  UUID        NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    event      VARCHAR(63) NOT NULL,
+    detail     JSONB       NOT NULL DEFAULT '{}',
+    ip_address VARCHAR(45) NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_audit_logs_user_id_created_at ON user_audit_logs(user_id, created_at);
+
+COMMENT ON TABLE user_audit_logs IS 'ユーザーのアカウント情報の変更履歴';
+COMMENT ON COLUMN user_audit_logs.event IS 'email_change_requested / email_changed / email_change_reverted 等';
+COMMENT ON COLUMN user_audit_logs.detail IS 'イベントの内容（変更前後の値など）';
+COMMENT ON COLUMN user_audit_logs.ip_address IS '操作元の IP アドレス。管理者の操作などで不明な場合は空文字';
@@ -0,0 +1,6 @@
+SET search_path TO op;
+
+ALTER TABLE sessions DROP COLUMN IF EXISTS amr;
+ALTER TABLE users DROP COLUMN IF EXISTS sms_mfa_enabled;
+
+DROP TABLE IF EXISTS sms_otp_codes;
@@ -0,0 +1,31 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS sms_otp_codes (
+    id             UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    user_id        UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    purpose        VARCHAR(31) NOT NULL,
+    phone_number   VARCHAR(32) NOT NULL,
+    code_hash      VARCHAR(64) NOT NULL,
+    challenge_hash VARCHAR(64) UNIQUE,
+    attempts       INT         NOT NULL DEFAULT 0,
+    expires_at     TIMESTAMPTZ NOT NULL,
+    consumed_at    TIMESTAMPTZ,
+    created_at     TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS idx_sms_otp_codes_user_id_created_at ON sms_otp_codes(user_id, created_at);
+
+COMMENT ON TABLE sms_otp_codes IS 'SMS で送信したワンタイムコード';
+COMMENT ON COLUMN sms_otp_codes.purpose IS 'phone_verification（電話番号の確認） / login（ログインの2要素目）';
+COMMENT ON COLUMN sms_otp_codes.phone_number IS '送信先の電話番号。確認時にユーザーの電話番号と一致しない場合は無効';
+COMMENT ON COLUMN sms_otp_codes.code_hash IS 'コードの SHA-256 ハッシュ (hex)。id をソルトに使う';
+COMMENT ON COLUMN sms_otp_codes.challenge_hash IS 'login の場合、パスワード認証後に発行した mfa_token の SHA-256 ハッシュ (hex)';
+COMMENT ON COLUMN sms_otp_codes.attempts IS '入力回数（照合前に数える）。上限に達したコードは使えない';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS sms_mfa_enabled BOOLEAN NOT NULL DEFAULT FALSE;
+
+COMMENT ON COLUMN users.sms_mfa_enabled IS 'ログイン時に SMS のワンタイムコードを2要素目として要求する。確認済みの電話番号が必要';
+
+ALTER TABLE sessions ADD COLUMN IF NOT EXISTS amr JSONB NOT NULL DEFAULT '["pwd"]';
+
+COMMENT ON COLUMN sessions.amr IS '認証に使った方式（RFC 8176 の amr 値）。ID トークンの amr クレームになる';
@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS federation_states;
+DROP TABLE IF EXISTS external_idp_credentials;
+DROP TABLE IF EXISTS identity_providers;
@@ -0,0 +1,59 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS identity_providers (
+    id                      UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id               UUID          NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    code                    VARCHAR(63)   NOT NULL,
+    name                    VARCHAR(255)  NOT NULL,
+    issuer                  VARCHAR(2048) NOT NULL,
+    client_id               VARCHAR(255)  NOT NULL,
+    client_secret_encrypted TEXT,
+    scopes                  JSONB         NOT NULL DEFAULT '["openid", "email", "profile"]',
+    claim_mappings          JSONB         NOT NULL DEFAULT '{}',
+    jit_provisioning        BOOLEAN       NOT NULL DEFAULT TRUE,
+    link_by_email           BOOLEAN       NOT NULL DEFAULT FALSE,
+    status                  VARCHAR(31)   NOT NULL DEFAULT 'active',
+    created_at              TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at              TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, code)
+);
+
+COMMENT ON TABLE identity_providers IS 'テナントが連携する上流の OIDC Provider。認証を委譲し、ユーザーを紐付けまたは JIT 作成する';
+COMMENT ON COLUMN identity_providers.code IS 'テナント内で一意の識別子。/internal/federation/{code}/start・callback のパスに使う';
+COMMENT ON COLUMN identity_providers.issuer IS '上流の issuer。{issuer}/.well-known/openid-configuration からメタデータを取得する';
+COMMENT ON COLUMN identity_providers.client_secret_encrypted IS '上流に登録したクライアントのシークレット（AES-256-GCM で暗号化）。NULL の場合は公開クライアント';
+COMMENT ON COLUMN identity_providers.scopes IS '上流に要求するスコープ。openid を含む';
+COMMENT ON COLUMN identity_providers.claim_mappings IS 'ユーザー属性 → 上流の ID トークンのクレーム名。未指定の標準クレームは同名のクレームを使う';
+COMMENT ON COLUMN identity_providers.jit_provisioning IS 'TRUE の場合、紐付くユーザーがいなければ初回ログイン時に作成する';
+COMMENT ON COLUMN identity_providers.link_by_email IS 'TRUE の場合、上流とローカルの双方で確認済みのメールアドレスが一致する既存ユーザーに紐付ける';
+COMMENT ON COLUMN identity_providers.status IS 'active / disabled';
+
+CREATE TABLE IF NOT EXISTS external_idp_credentials (
+    id                   UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    credential_id        UUID         NOT NULL UNIQUE REFERENCES credentials(id) ON DELETE CASCADE,
+    identity_provider_id UUID         NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+    provider_subject     VARCHAR(255) NOT NULL,
+    created_at           TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (identity_provider_id, provider_subject)
+);
+
+COMMENT ON TABLE external_idp_credentials IS '上流の OIDC Provider のユーザーとの紐付け（credentials.type = oidc_provider）';
+COMMENT ON COLUMN external_idp_credentials.provider_subject IS '上流の ID トークンの sub';
+
+CREATE TABLE IF NOT EXISTS federation_states (
+    id                   UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    identity_provider_id UUID          NOT NULL REFERENCES identity_providers(id) ON DELETE CASCADE,
+    state_hash           VARCHAR(64)   NOT NULL UNIQUE,
+    nonce                VARCHAR(64)   NOT NULL,
+    code_verifier        VARCHAR(128)  NOT NULL,
+    redirect_after_login VARCHAR(4096) NOT NULL DEFAULT '',
+    expires_at           TIMESTAMPTZ   NOT NULL,
+    used_at              TIMESTAMPTZ,
+    created_at           TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE federation_states IS '上流への認可リクエストの状態。callback で 1 回だけ使う';
+COMMENT ON COLUMN federation_states.state_hash IS 'state パラメータの SHA-256 ハッシュ (hex)';
+COMMENT ON COLUMN federation_states.nonce IS '上流の ID トークンの nonce と照合する値';
+COMMENT ON COLUMN federation_states.code_verifier IS 'PKCE の code_verifier (RFC 7636)';
+COMMENT ON COLUMN federation_states.redirect_after_login IS 'ログイン後に戻る OP Backend の相対パス（例: /demo/authorize?...）';
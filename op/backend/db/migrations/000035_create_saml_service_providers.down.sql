@@ -0,0 +1,4 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS saml_sessions;
+DROP TABLE IF EXISTS saml_service_providers;
@@ -0,0 +1,46 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS saml_service_providers (
+    id                        UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id                 UUID          NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    entity_id                 VARCHAR(1024) NOT NULL,
+    name                      VARCHAR(255)  NOT NULL,
+    acs_url                   VARCHAR(2048) NOT NULL,
+    slo_url                   VARCHAR(2048),
+    name_id_format            VARCHAR(255)  NOT NULL DEFAULT 'urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress',
+    attribute_mappings        JSONB         NOT NULL DEFAULT '[]',
+    idp_initiated_sso_enabled BOOLEAN       NOT NULL DEFAULT FALSE,
+    status                    VARCHAR(31)   NOT NULL DEFAULT 'active',
+    created_at                TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at                TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, entity_id)
+);
+
+COMMENT ON TABLE saml_service_providers IS 'テナントの SAML 2.0 IdP に登録された Service Provider（SAML のみに対応するアプリケーション）';
+COMMENT ON COLUMN saml_service_providers.entity_id IS 'SP の entityID。AuthnRequest の Issuer・アサーションの Audience';
+COMMENT ON COLUMN saml_service_providers.acs_url IS 'AssertionConsumerService の URL（HTTP-POST バインディング）。レスポンスはこの URL にのみ送る';
+COMMENT ON COLUMN saml_service_providers.slo_url IS 'SingleLogoutService の URL（HTTP-Redirect バインディング）。NULL の場合は SLO に参加しない';
+COMMENT ON COLUMN saml_service_providers.name_id_format IS 'アサーションの NameID の形式（emailAddress / persistent / transient / unspecified）';
+COMMENT ON COLUMN saml_service_providers.attribute_mappings IS 'アサーションの属性（[{"name": "mail", "attribute": "email", "name_format": "basic"}]）。attribute はユーザー属性名';
+COMMENT ON COLUMN saml_service_providers.idp_initiated_sso_enabled IS 'TRUE の場合、AuthnRequest なしで IdP からアサーションを送る（IdP-initiated SSO）';
+COMMENT ON COLUMN saml_service_providers.status IS 'active / disabled';
+
+CREATE TABLE IF NOT EXISTS saml_sessions (
+    id                  UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id          UUID          NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    service_provider_id UUID          NOT NULL REFERENCES saml_service_providers(id) ON DELETE CASCADE,
+    name_id             VARCHAR(255)  NOT NULL,
+    session_index       VARCHAR(64)   NOT NULL UNIQUE,
+    logout_request_id   VARCHAR(255),
+    logout_relay_state  VARCHAR(1024),
+    logged_out_at       TIMESTAMPTZ,
+    created_at          TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    UNIQUE (session_id, service_provider_id)
+);
+
+COMMENT ON TABLE saml_sessions IS 'OP のセッションでアサーションを発行した SP。SingleLogout の伝播先になる';
+COMMENT ON COLUMN saml_sessions.name_id IS 'アサーションで SP に送った NameID';
+COMMENT ON COLUMN saml_sessions.session_index IS 'AuthnStatement の SessionIndex。SP からの LogoutRequest でセッションを特定する';
+COMMENT ON COLUMN saml_sessions.logout_request_id IS 'この SP から受けた LogoutRequest の ID。伝播の完了後に LogoutResponse の InResponseTo に使う';
+COMMENT ON COLUMN saml_sessions.logout_relay_state IS 'この SP から受けた LogoutRequest の RelayState';
+COMMENT ON COLUMN saml_sessions.logged_out_at IS 'SP にログアウトを要求した（または SP から要求された）日時';
@@ -0,0 +1,9 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS group_members;
+DROP TABLE IF EXISTS groups;
+
+COMMENT ON COLUMN users.status IS 'active / locked / disabled';
+ALTER TABLE users DROP COLUMN IF EXISTS external_id;
+
+DROP TABLE IF EXISTS scim_tokens;
@@ -0,0 +1,50 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS scim_tokens (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id    UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    token_hash   VARCHAR(64)  NOT NULL UNIQUE,
+    description  VARCHAR(255),
+    expires_at   TIMESTAMPTZ,
+    last_used_at TIMESTAMPTZ,
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_scim_tokens_tenant_id ON scim_tokens(tenant_id);
+
+COMMENT ON TABLE scim_tokens IS 'SCIM 2.0 (RFC 7644) のプロビジョニング API を呼び出すための Bearer トークン。管理者がテナントごとに発行する';
+COMMENT ON COLUMN scim_tokens.token_hash IS 'トークンの SHA-256 ハッシュ値。平文は発行時のみ返す';
+COMMENT ON COLUMN scim_tokens.description IS '用途メモ（連携する人事システム名など）';
+COMMENT ON COLUMN scim_tokens.expires_at IS '有効期限。NULL の場合は無期限';
+COMMENT ON COLUMN scim_tokens.last_used_at IS '最後に認証に使われた日時';
+
+ALTER TABLE users ADD COLUMN IF NOT EXISTS external_id VARCHAR(255);
+
+COMMENT ON COLUMN users.external_id IS 'SCIM クライアント（人事システムなど）での識別子 (RFC 7643 Section 3.1 externalId)';
+COMMENT ON COLUMN users.status IS 'active / locked / disabled / deleted（SCIM で削除。ユーザー ID と発行済みトークンの履歴を残すため行は消さない）';
+
+CREATE TABLE IF NOT EXISTS groups (
+    id           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id    UUID         NOT NULL REFERENCES tenants(id) ON DELETE CASCADE,
+    display_name VARCHAR(255) NOT NULL,
+    external_id  VARCHAR(255),
+    created_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    updated_at   TIMESTAMPTZ  NOT NULL DEFAULT NOW(),
+    UNIQUE (tenant_id, display_name)
+);
+
+COMMENT ON TABLE groups IS 'SCIM でプロビジョニングされるユーザーのグループ (RFC 7643 Section 4.2)';
+COMMENT ON COLUMN groups.display_name IS 'テナント内で一意の表示名';
+COMMENT ON COLUMN groups.external_id IS 'SCIM クライアントでの識別子';
+COMMENT ON COLUMN groups.updated_at IS 'メンバーの変更でも更新する（ETag に使う）';
+
+CREATE TABLE IF NOT EXISTS group_members (
+    group_id   UUID        NOT NULL REFERENCES groups(id) ON DELETE CASCADE,
+    user_id    UUID        NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (group_id, user_id)
+);
+
+CREATE INDEX idx_group_members_user_id ON group_members(user_id);
+
+COMMENT ON TABLE group_members IS 'グループのメンバー（ユーザーのみ。グループの入れ子には対応しない）';
@@ -0,0 +1,8 @@
+SET search_path TO op;
+
+DROP INDEX IF EXISTS idx_groups_ldap_directory_external_id;
+ALTER TABLE groups DROP COLUMN IF EXISTS ldap_directory_id;
+COMMENT ON TABLE groups IS 'SCIM でプロビジョニングされるユーザーのグループ (RFC 7643 Section 4.2)';
+
+DROP TABLE IF EXISTS ldap_credentials;
+DROP TABLE IF EXISTS ldap_directories;
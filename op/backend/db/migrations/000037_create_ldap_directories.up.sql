@@ -0,0 +1,60 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS ldap_directories (
+    id                      UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id               UUID          NOT NULL UNIQUE REFERENCES tenants(id) ON DELETE CASCADE,
+    name                    VARCHAR(255)  NOT NULL,
+    host                    VARCHAR(255)  NOT NULL,
+    port                    INTEGER       NOT NULL,
+    security                VARCHAR(31)   NOT NULL DEFAULT 'ldaps',
+    ca_certificate          TEXT,
+    bind_dn                 VARCHAR(1024),
+    bind_password_encrypted TEXT,
+    user_search_base        VARCHAR(1024) NOT NULL,
+    user_search_filter      VARCHAR(1024) NOT NULL DEFAULT '(&(objectClass=user)(sAMAccountName={username}))',
+    id_attribute            VARCHAR(255)  NOT NULL DEFAULT 'objectGUID',
+    attribute_mappings      JSONB         NOT NULL DEFAULT '{}',
+    jit_provisioning        BOOLEAN       NOT NULL DEFAULT TRUE,
+    link_by_login_id        BOOLEAN       NOT NULL DEFAULT FALSE,
+    group_sync_enabled      BOOLEAN       NOT NULL DEFAULT FALSE,
+    group_attribute         VARCHAR(255)  NOT NULL DEFAULT 'memberOf',
+    status                  VARCHAR(31)   NOT NULL DEFAULT 'active',
+    created_at              TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at              TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE ldap_directories IS 'テナントのパスワード認証を委譲する LDAP / Active Directory。テナントごとに 1 つ';
+COMMENT ON COLUMN ldap_directories.security IS 'ldaps（接続時から TLS）/ starttls（StartTLS 拡張操作で TLS に切り替える, RFC 4511 Section 4.14）。平文の接続は使わない';
+COMMENT ON COLUMN ldap_directories.ca_certificate IS 'ディレクトリのサーバー証明書を検証する CA 証明書（PEM）。NULL の場合はシステムの信頼ストアを使う';
+COMMENT ON COLUMN ldap_directories.bind_dn IS 'ユーザーの検索に使うサービスアカウントの DN。NULL の場合は匿名で検索する';
+COMMENT ON COLUMN ldap_directories.bind_password_encrypted IS 'サービスアカウントのパスワード（AES-256-GCM で暗号化）';
+COMMENT ON COLUMN ldap_directories.user_search_filter IS 'ユーザーの検索フィルタ (RFC 4515)。{username} をエスケープした login_id で置き換える';
+COMMENT ON COLUMN ldap_directories.id_attribute IS 'ユーザーとの紐付けに使う変わらない属性。UTF-8 でない値（objectGUID など）は16進数の文字列にする';
+COMMENT ON COLUMN ldap_directories.attribute_mappings IS 'ユーザー属性 → ディレクトリの属性名。ログインのたびにディレクトリの値で更新する';
+COMMENT ON COLUMN ldap_directories.jit_provisioning IS 'TRUE の場合、紐付くユーザーがいなければ初回ログイン時に作成する';
+COMMENT ON COLUMN ldap_directories.link_by_login_id IS 'TRUE の場合、login_id が一致する既存ユーザーに紐付ける。紐付け後はディレクトリのパスワードでのみログインできる';
+COMMENT ON COLUMN ldap_directories.group_sync_enabled IS 'TRUE の場合、ログイン時に group_attribute のグループ DN からグループのメンバーを同期する';
+COMMENT ON COLUMN ldap_directories.group_attribute IS 'ユーザーが所属するグループの DN を持つ属性（AD の memberOf など）';
+COMMENT ON COLUMN ldap_directories.status IS 'active / disabled';
+
+CREATE TABLE IF NOT EXISTS ldap_credentials (
+    id                UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    credential_id     UUID          NOT NULL UNIQUE REFERENCES credentials(id) ON DELETE CASCADE,
+    ldap_directory_id UUID          NOT NULL REFERENCES ldap_directories(id) ON DELETE CASCADE,
+    subject           VARCHAR(255)  NOT NULL,
+    dn                VARCHAR(1024) NOT NULL,
+    created_at        TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at        TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    UNIQUE (ldap_directory_id, subject)
+);
+
+COMMENT ON TABLE ldap_credentials IS 'LDAP ディレクトリのエントリとの紐付け（credentials.type = ldap）';
+COMMENT ON COLUMN ldap_credentials.subject IS 'エントリの id_attribute の値';
+COMMENT ON COLUMN ldap_credentials.dn IS '最後にログインしたときのエントリの DN';
+
+ALTER TABLE groups ADD COLUMN IF NOT EXISTS ldap_directory_id UUID REFERENCES ldap_directories(id) ON DELETE SET NULL;
+
+CREATE UNIQUE INDEX idx_groups_ldap_directory_external_id ON groups(ldap_directory_id, external_id) WHERE ldap_directory_id IS NOT NULL;
+
+COMMENT ON TABLE groups IS 'SCIM でプロビジョニング、または LDAP ディレクトリから同期されるユーザーのグループ (RFC 7643 Section 4.2)';
+COMMENT ON COLUMN groups.ldap_directory_id IS 'LDAP ディレクトリから同期したグループの場合のディレクトリ。external_id にグループの DN を持つ';
@@ -0,0 +1,3 @@
+SET search_path TO op;
+
+DROP TABLE IF EXISTS user_migration_hooks;
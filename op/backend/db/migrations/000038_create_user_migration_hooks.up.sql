@@ -0,0 +1,26 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS user_migration_hooks (
+    id                           UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    tenant_id                    UUID          NOT NULL UNIQUE REFERENCES tenants(id) ON DELETE CASCADE,
+    url                          VARCHAR(2048) NOT NULL,
+    auth_method                  VARCHAR(31)   NOT NULL DEFAULT 'hmac',
+    hmac_secret_encrypted        TEXT,
+    client_certificate           TEXT,
+    client_private_key_encrypted TEXT,
+    ca_certificate               TEXT,
+    timeout_ms                   INTEGER       NOT NULL DEFAULT 5000,
+    status                       VARCHAR(31)   NOT NULL DEFAULT 'active',
+    created_at                   TIMESTAMPTZ   NOT NULL DEFAULT NOW(),
+    updated_at                   TIMESTAMPTZ   NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE user_migration_hooks IS 'ローカルにパスワードのないユーザーのログイン時に、移行元のシステムで login_id とパスワードを検証する Webhook。テナントごとに 1 つ';
+COMMENT ON COLUMN user_migration_hooks.url IS '検証を依頼する移行元のエンドポイント（https のみ）';
+COMMENT ON COLUMN user_migration_hooks.auth_method IS 'hmac（リクエストボディの HMAC-SHA256 署名）/ mtls（クライアント証明書）';
+COMMENT ON COLUMN user_migration_hooks.hmac_secret_encrypted IS '署名の鍵（AES-256-GCM で暗号化）。auth_method = hmac の場合に使う';
+COMMENT ON COLUMN user_migration_hooks.client_certificate IS 'クライアント証明書（PEM）。auth_method = mtls の場合に使う';
+COMMENT ON COLUMN user_migration_hooks.client_private_key_encrypted IS 'クライアント証明書の秘密鍵（PEM を AES-256-GCM で暗号化）';
+COMMENT ON COLUMN user_migration_hooks.ca_certificate IS 'エンドポイントのサーバー証明書を検証する CA 証明書（PEM）。NULL の場合はシステムの信頼ストアを使う';
+COMMENT ON COLUMN user_migration_hooks.timeout_ms IS '1 回の呼び出しのタイムアウト（ミリ秒）';
+COMMENT ON COLUMN user_migration_hooks.status IS 'active / disabled。移行が完了したら disabled にする';
@@ -0,0 +1,3 @@
+SET search_path TO op;
+
+ALTER TABLE id_tokens DROP COLUMN IF EXISTS scope;
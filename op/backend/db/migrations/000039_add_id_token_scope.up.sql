@@ -0,0 +1,5 @@
+SET search_path TO op;
+
+ALTER TABLE id_tokens ADD COLUMN IF NOT EXISTS scope VARCHAR(1024);
+
+COMMENT ON COLUMN id_tokens.scope IS '発行元の認可で付与されたスコープ。トークン交換で ID トークンを subject_token にした場合の上限になる。NULL の場合は交換に使えない';
@@ -0,0 +1,20 @@
+SET search_path TO op;
+
+CREATE TABLE IF NOT EXISTS authorization_details_approvals (
+    id                    UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    session_id            UUID         NOT NULL REFERENCES sessions(id) ON DELETE CASCADE,
+    client_id             UUID         NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    details_hash          VARCHAR(64)  NOT NULL,
+    authorization_details JSONB        NOT NULL,
+    status                VARCHAR(16)  NOT NULL DEFAULT 'pending',
+    expires_at            TIMESTAMPTZ  NOT NULL,
+    created_at            TIMESTAMPTZ  NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX idx_authorization_details_approvals_lookup ON authorization_details_approvals(session_id, client_id, details_hash);
+CREATE INDEX idx_authorization_details_approvals_expires_at ON authorization_details_approvals(expires_at);
+
+COMMENT ON TABLE authorization_details_approvals IS '認可リクエストの authorization_details (RFC 9396) に対するユーザーの承認。OP が保持する内容を承認画面に示し、承認されたものだけを認可コードに含める';
+COMMENT ON COLUMN authorization_details_approvals.session_id IS '承認を求めたログインセッション。別のセッションからは参照・承認できない';
+COMMENT ON COLUMN authorization_details_approvals.details_hash IS '検証済みの authorization_details の正規化 JSON の SHA-256 ハッシュ値。認可リクエストとの照合に使う';
+COMMENT ON COLUMN authorization_details_approvals.status IS 'pending: 承認待ち / approved: 承認済み / denied: 拒否 / consumed: 認可リクエストで使用済み';
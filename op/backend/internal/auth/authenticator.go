@@ -0,0 +1,114 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// Authenticator は login_id とパスワードでテナントのユーザーを認証する認証ソース。
+// AuthService は登録された順に呼び出し、最初にユーザーまたはエラーを返したものの結果を使う。
+// 扱わないユーザー (存在しない、別の認証ソースで管理されているなど) の場合は (nil, nil) を返して次の認証ソースに委ね、
+// パスワードが一致しない場合は ErrInvalidCredentials を返す
+type Authenticator interface {
+	Authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error)
+}
+
+// PasswordAuthenticator は OP に保存したパスワードのハッシュで認証する。
+// 他のシステムから取り込んだハッシュ (bcrypt など) で認証した場合は、平文のパスワードから argon2id で作り直す
+type PasswordAuthenticator struct {
+	userFinder     UserFinder
+	rehashStore    PasswordRehashStore
+	verifyPassword PasswordVerifyFunc
+	hashPassword   HashPasswordFunc
+	needsRehash    PasswordNeedsRehashFunc
+	// logger はハッシュの作り直しの失敗を記録する。ハンドラと同じ echo のロガーを渡す
+	logger echo.Logger
+}
+
+func NewPasswordAuthenticator(
+	userFinder UserFinder,
+	rehashStore PasswordRehashStore,
+	verifyPassword PasswordVerifyFunc,
+	hashPassword HashPasswordFunc,
+	needsRehash PasswordNeedsRehashFunc,
+	logger echo.Logger,
+) *PasswordAuthenticator {
+	return &PasswordAuthenticator{
+		userFinder:     userFinder,
+		rehashStore:    rehashStore,
+		verifyPassword: verifyPassword,
+		hashPassword:   hashPassword,
+		needsRehash:    needsRehash,
+		logger:         logger,
+	}
+}
+
+func (a *PasswordAuthenticator) Authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error) {
+	// ユーザー検索 (Credentials preload 済み)
+	user, err := a.userFinder.FindByTenantAndLoginID(ctx, tenant.ID, loginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return nil, nil
+	}
+
+	// LDAP ディレクトリに紐付いたユーザーはディレクトリのパスワードでのみ認証する
+	if hasCredential(user.Credentials, model.CredentialTypeLDAP) {
+		return nil, nil
+	}
+	passwordHash := findPasswordHash(user.Credentials)
+	if passwordHash == "" {
+		return nil, nil
+	}
+
+	match, err := a.verifyPassword(ctx, password, passwordHash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to verify password: %w", err)
+	}
+	if !match {
+		return nil, ErrInvalidCredentials
+	}
+
+	if a.needsRehash(passwordHash) {
+		a.rehash(ctx, user.ID, passwordHash, password)
+	}
+	return user, nil
+}
+
+// rehash はパスワードを現在のパラメータでハッシュし直す。
+// 失敗しても次のログインで作り直せるため、ログに残してログインは成功させる
+func (a *PasswordAuthenticator) rehash(ctx context.Context, userID uuid.UUID, passwordHash, password string) {
+	rehashed, err := a.hashPassword(ctx, password)
+	if err != nil {
+		a.logger.Errorf("failed to rehash password for user %s: %v", userID, err)
+		return
+	}
+	// 同時にパスワードが変更された場合は置き換えない
+	if _, err := a.rehashStore.RehashPassword(ctx, userID, passwordHash, rehashed); err != nil {
+		a.logger.Errorf("failed to save rehashed password for user %s: %v", userID, err)
+	}
+}
+
+func findPasswordHash(credentials []model.Credential) string {
+	for _, cred := range credentials {
+		if cred.Type == "password" && cred.PasswordCredential != nil {
+			return cred.PasswordCredential.PasswordHash
+		}
+	}
+	return ""
+}
+
+func hasCredential(credentials []model.Credential, credentialType string) bool {
+	for _, cred := range credentials {
+		if cred.Type == credentialType {
+			return true
+		}
+	}
+	return false
+}
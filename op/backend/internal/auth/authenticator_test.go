@@ -0,0 +1,112 @@
+package auth
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakePasswordRehashStore struct {
+	err      error
+	from, to string
+}
+
+func (f *fakePasswordRehashStore) RehashPassword(_ context.Context, _ uuid.UUID, from, to string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.from, f.to = from, to
+	return true, nil
+}
+
+// テスト用のハッシュは "hash:" + 平文。"legacy:" + 平文は作り直しが必要な古いハッシュとして扱う
+func fakeVerifyPassword(_ context.Context, password, hash string) (bool, error) {
+	return hash == "hash:"+password || hash == "legacy:"+password, nil
+}
+
+func fakeNeedsRehash(hash string) bool {
+	return strings.HasPrefix(hash, "legacy:")
+}
+
+// newTestLogger は w に出力する echo のロガーを返す
+func newTestLogger(w io.Writer) echo.Logger {
+	logger := echo.New().Logger
+	logger.SetOutput(w)
+	return logger
+}
+
+func TestPasswordAuthenticatorRehash(t *testing.T) {
+	errHash := errors.New("hasher is busy")
+	tests := []struct {
+		name     string
+		hashErr  error
+		storeErr error
+		wantTo   string
+		wantLog  string
+	}{
+		{name: "作り直したハッシュを保存する", wantTo: "hash:s3cret"},
+		// 作り直しは次のログインでもできるため、失敗してもログインは成功させ、ロガーに記録する
+		{name: "ハッシュ化の失敗", hashErr: errHash, wantLog: "failed to rehash password"},
+		{name: "保存の失敗", storeErr: errors.New("database is down"), wantLog: "failed to save rehashed password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tenant := &model.Tenant{ID: uuid.New()}
+			user := &model.User{
+				ID: uuid.New(), TenantID: tenant.ID, LoginID: "alice",
+				Credentials: []model.Credential{{Type: "password", PasswordCredential: &model.PasswordCredential{PasswordHash: "legacy:s3cret"}}},
+			}
+			store := &fakePasswordRehashStore{err: tt.storeErr}
+			hash := func(_ context.Context, password string) (string, error) {
+				if tt.hashErr != nil {
+					return "", tt.hashErr
+				}
+				return "hash:" + password, nil
+			}
+			var logs bytes.Buffer
+			a := NewPasswordAuthenticator(newFakeUserStore(user), store, fakeVerifyPassword, hash, fakeNeedsRehash, newTestLogger(&logs))
+
+			got, err := a.Authenticate(context.Background(), tenant, "alice", "s3cret")
+			if err != nil || got == nil || got.ID != user.ID {
+				t.Fatalf("user = %v, err = %v", got, err)
+			}
+			if store.to != tt.wantTo {
+				t.Errorf("rehashed = %q, want %q", store.to, tt.wantTo)
+			}
+			if tt.wantTo != "" && store.from != "legacy:s3cret" {
+				t.Errorf("from = %q", store.from)
+			}
+			if got := logs.String(); (tt.wantLog == "" && got != "") || !strings.Contains(got, tt.wantLog) {
+				t.Errorf("logs = %q, want %q", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestPasswordAuthenticatorVerifyError(t *testing.T) {
+	tenant := &model.Tenant{ID: uuid.New()}
+	user := &model.User{
+		ID: uuid.New(), TenantID: tenant.ID, LoginID: "alice",
+		Credentials: []model.Credential{{Type: "password", PasswordCredential: &model.PasswordCredential{PasswordHash: "hash:s3cret"}}},
+	}
+	hash := func(_ context.Context, password string) (string, error) { return "hash:" + password, nil }
+	a := NewPasswordAuthenticator(newFakeUserStore(user), &fakePasswordRehashStore{}, fakeVerifyPassword, hash, fakeNeedsRehash, newTestLogger(io.Discard))
+	if _, err := a.Authenticate(context.Background(), tenant, "alice", "wrong"); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+
+	// 検証できなかった場合はパスワードの不一致と区別して呼び出し元に返す
+	errBusy := errors.New("hasher is busy")
+	a.verifyPassword = func(context.Context, string, string) (bool, error) { return false, errBusy }
+	if _, err := a.Authenticate(context.Background(), tenant, "alice", "s3cret"); !errors.Is(err, errBusy) {
+		t.Errorf("err = %v, want %v", err, errBusy)
+	}
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
@@ -24,4 +25,158 @@ type SessionStore interface {
 	FindByID(ctx context.Context, id uuid.UUID) (*model.Session, error)
 }
 
-type PasswordVerifyFunc func(password, hash string) (bool, error)
+type PasswordVerifyFunc func(ctx context.Context, password, hash string) (bool, error)
+
+// HashPasswordFunc は平文パスワードを argon2id でハッシュ化する
+type HashPasswordFunc func(ctx context.Context, password string) (string, error)
+
+// PasswordNeedsRehashFunc はハッシュを HashPasswordFunc で作り直すべきか判定する
+type PasswordNeedsRehashFunc func(hash string) bool
+
+// PasswordRehashStore はログイン時に検証したパスワードのハッシュを置き換える
+type PasswordRehashStore interface {
+	// RehashPassword はハッシュが from のままの場合に to で置き換える。置き換えなかった場合は false を返す
+	RehashPassword(ctx context.Context, userID uuid.UUID, from, to string) (bool, error)
+}
+
+// UserEmailStore はメールアドレス確認でユーザーを参照・更新する
+type UserEmailStore interface {
+	FindByID(ctx context.Context, id uuid.UUID) (*model.User, error)
+	MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) (bool, error)
+}
+
+type EmailVerificationTokenStore interface {
+	Create(ctx context.Context, token *model.EmailVerificationToken) error
+	FindLatestByUserID(ctx context.Context, userID uuid.UUID) (*model.EmailVerificationToken, error)
+	DeleteUnusedByUserID(ctx context.Context, userID uuid.UUID) error
+	Consume(ctx context.Context, tokenHash string) (*model.EmailVerificationToken, error)
+}
+
+// MailSender はユーザーにメールを送信する
+type MailSender interface {
+	SendMail(ctx context.Context, msg *model.MailMessage) error
+}
+
+// HashTokenFunc はトークンを保存用のハッシュ値に変換する
+type HashTokenFunc func(token string) string
+
+// UserEmailChangeStore はメールアドレスの変更でユーザーを参照・更新する
+type UserEmailChangeStore interface {
+	FindByID(ctx context.Context, id uuid.UUID) (*model.User, error)
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	ChangeEmail(ctx context.Context, id uuid.UUID, from, to string, verified bool) (bool, error)
+}
+
+type EmailChangeRequestStore interface {
+	Create(ctx context.Context, req *model.EmailChangeRequest) error
+	FindLatestByUserID(ctx context.Context, userID uuid.UUID) (*model.EmailChangeRequest, error)
+	DeletePendingByUserID(ctx context.Context, userID uuid.UUID) error
+	Confirm(ctx context.Context, tokenHash, revertTokenHash string, revertExpiresAt time.Time) (*model.EmailChangeRequest, error)
+	Revert(ctx context.Context, revertTokenHash string) (*model.EmailChangeRequest, error)
+}
+
+type UserAuditLogStore interface {
+	Create(ctx context.Context, entry *model.UserAuditLog) error
+}
+
+// UserTokenRevoker はユーザーのセッション・トークンを失効させる
+type UserTokenRevoker interface {
+	RevokeByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
+}
+
+// UserPhoneStore は SMS による電話番号確認・2要素認証でユーザーを更新する
+type UserPhoneStore interface {
+	MarkPhoneNumberVerified(ctx context.Context, id uuid.UUID, phoneNumber string) (bool, error)
+	UpdateSMSMFAEnabled(ctx context.Context, id uuid.UUID, enabled bool) error
+}
+
+type SMSOTPCodeStore interface {
+	Create(ctx context.Context, code *model.SMSOTPCode) error
+	CountByUserIDSince(ctx context.Context, userID uuid.UUID, since time.Time) (int64, error)
+	FindActiveByUserID(ctx context.Context, userID uuid.UUID, purpose string) (*model.SMSOTPCode, error)
+	FindActiveByChallengeHash(ctx context.Context, challengeHash string) (*model.SMSOTPCode, error)
+	InvalidateActive(ctx context.Context, userID uuid.UUID, purpose string) error
+	RecordAttempt(ctx context.Context, id uuid.UUID, maxAttempts int) (bool, error)
+	Consume(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// SMSSender はユーザーに SMS を送信する
+type SMSSender interface {
+	SendSMS(ctx context.Context, msg *model.SMSMessage) error
+}
+
+// IdentityProviderFinder はテナントが連携する上流の OIDC Provider を検索する
+type IdentityProviderFinder interface {
+	ListActiveByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.IdentityProvider, error)
+	FindByCode(ctx context.Context, tenantID uuid.UUID, code string) (*model.IdentityProvider, error)
+}
+
+type FederationStateStore interface {
+	Create(ctx context.Context, state *model.FederationState) error
+	Consume(ctx context.Context, stateHash string) (*model.FederationState, error)
+}
+
+// FederatedUserStore は上流の OIDC Provider のユーザーをローカルのユーザーに紐付ける
+type FederatedUserStore interface {
+	FindByExternalIdentity(ctx context.Context, identityProviderID uuid.UUID, subject string) (*model.User, error)
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	CreateWithExternalIdentity(ctx context.Context, user *model.User, identityProviderID uuid.UUID, subject string) error
+	LinkExternalIdentity(ctx context.Context, userID, identityProviderID uuid.UUID, subject string) error
+}
+
+// UpstreamOIDCClient は上流の OIDC Provider と認可コードフローを行う
+type UpstreamOIDCClient interface {
+	// AuthorizationURL は上流の認可エンドポイントに params を付けた URL を返す
+	AuthorizationURL(ctx context.Context, issuer string, params url.Values) (string, error)
+	// ExchangeCode は認可コードをトークンに交換し、検証済みの ID トークンのクレームを返す
+	ExchangeCode(ctx context.Context, req *model.UpstreamTokenRequest) (map[string]interface{}, error)
+}
+
+// DecryptSecretFunc は鍵暗号化キーで暗号化したシークレットを復号する
+type DecryptSecretFunc func(encrypted string) (string, error)
+
+// LDAPDirectoryFinder はテナントがパスワード認証を委譲する LDAP ディレクトリを検索する
+type LDAPDirectoryFinder interface {
+	FindActiveByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.LDAPDirectory, error)
+}
+
+// LDAPUserStore は LDAP ディレクトリのエントリをローカルのユーザーに紐付け、属性を同期する
+type LDAPUserStore interface {
+	FindByTenantAndLoginID(ctx context.Context, tenantID uuid.UUID, loginID string) (*model.User, error)
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	FindByLDAPIdentity(ctx context.Context, directoryID uuid.UUID, subject string) (*model.User, error)
+	CreateWithLDAPIdentity(ctx context.Context, user *model.User, directoryID uuid.UUID, subject, dn string) error
+	LinkLDAPIdentity(ctx context.Context, userID, directoryID uuid.UUID, subject, dn string) error
+	UpdateLDAPIdentityDN(ctx context.Context, directoryID uuid.UUID, subject, dn string) error
+	UpdateProfile(ctx context.Context, user *model.User) error
+}
+
+// LDAPGroupStore はディレクトリのグループのメンバーを同期する
+type LDAPGroupStore interface {
+	SyncLDAPMemberships(ctx context.Context, tenantID, directoryID, userID uuid.UUID, groups []model.LDAPGroup) error
+}
+
+// LDAPClient は LDAP ディレクトリでユーザーを検索し、パスワードを検証する
+type LDAPClient interface {
+	// Authenticate はユーザーのエントリとパスワードが正しいかを返す。ユーザーが見つからない場合のエントリは nil
+	Authenticate(ctx context.Context, req *model.LDAPSearchRequest, password string) (*model.LDAPEntry, bool, error)
+}
+
+// UserMigrationHookFinder はテナントが移行元でパスワードを検証する Webhook を検索する
+type UserMigrationHookFinder interface {
+	FindActiveByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.UserMigrationHook, error)
+}
+
+// MigratedUserStore は移行元で検証できたユーザーを作成し、パスワードを設定する
+type MigratedUserStore interface {
+	FindByTenantAndLoginID(ctx context.Context, tenantID uuid.UUID, loginID string) (*model.User, error)
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	CreateWithPassword(ctx context.Context, user *model.User, passwordHash string) error
+	SetPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+}
+
+// UserMigrationClient は移行元で login_id とパスワードを検証する
+type UserMigrationClient interface {
+	// VerifyPassword は検証できたユーザーのプロフィールを返す。パスワードが一致しない場合やユーザーがいない場合は (nil, nil) を返す
+	VerifyPassword(ctx context.Context, req *model.UserMigrationRequest, password string) (*model.MigratedUser, error)
+}
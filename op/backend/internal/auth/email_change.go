@@ -0,0 +1,248 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// emailChangeTokenLifetime は新しいアドレスに送る確認リンクの有効期間
+	emailChangeTokenLifetime = 24 * time.Hour
+	// emailChangeRevertLifetime は変更前のアドレスに送る取り消しリンクの有効期間
+	emailChangeRevertLifetime = 7 * 24 * time.Hour
+	// emailChangeRequestInterval は変更リクエストの間隔の下限
+	emailChangeRequestInterval = time.Minute
+)
+
+// EmailChangeService はメールアドレスの変更を行う。
+// 新しいアドレスで確認してから変更を確定し、変更前のアドレスには取り消し用のリンクを送る。
+type EmailChangeService struct {
+	requestStore        EmailChangeRequestStore
+	userStore           UserEmailChangeStore
+	auditLog            UserAuditLogStore
+	mailSender          MailSender
+	hashToken           HashTokenFunc
+	sessionRevoker      UserTokenRevoker
+	accessTokenRevoker  UserTokenRevoker
+	refreshTokenRevoker UserTokenRevoker
+	pageURL             string
+}
+
+func NewEmailChangeService(
+	requestStore EmailChangeRequestStore,
+	userStore UserEmailChangeStore,
+	auditLog UserAuditLogStore,
+	mailSender MailSender,
+	hashToken HashTokenFunc,
+	sessionRevoker UserTokenRevoker,
+	accessTokenRevoker UserTokenRevoker,
+	refreshTokenRevoker UserTokenRevoker,
+	frontendBaseURL string,
+) *EmailChangeService {
+	return &EmailChangeService{
+		requestStore:        requestStore,
+		userStore:           userStore,
+		auditLog:            auditLog,
+		mailSender:          mailSender,
+		hashToken:           hashToken,
+		sessionRevoker:      sessionRevoker,
+		accessTokenRevoker:  accessTokenRevoker,
+		refreshTokenRevoker: refreshTokenRevoker,
+		pageURL:             frontendBaseURL + "/email-change",
+	}
+}
+
+// RequestChange は新しいメールアドレスに確認メールを送る。確認されるまでユーザーのメールアドレスは変更しない。
+// 未確認の古いリクエストは無効にする。
+func (s *EmailChangeService) RequestChange(ctx context.Context, user *model.User, newEmail, ipAddress string) error {
+	if !isValidEmail(newEmail) {
+		return ErrInvalidEmail
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return ErrEmailUnchanged
+	}
+	if err := s.ensureEmailAvailable(ctx, user, newEmail); err != nil {
+		return err
+	}
+
+	latest, err := s.requestStore.FindLatestByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find latest email change request: %w", err)
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < emailChangeRequestInterval {
+		return ErrEmailChangeThrottled
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change token: %w", err)
+	}
+	if err := s.requestStore.DeletePendingByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to invalidate email change requests: %w", err)
+	}
+	expiresAt := time.Now().Add(emailChangeTokenLifetime)
+	if err := s.requestStore.Create(ctx, &model.EmailChangeRequest{
+		UserID:           user.ID,
+		OldEmail:         user.Email,
+		OldEmailVerified: user.EmailVerified,
+		NewEmail:         newEmail,
+		TokenHash:        s.hashToken(token),
+		ExpiresAt:        expiresAt,
+	}); err != nil {
+		return fmt.Errorf("failed to create email change request: %w", err)
+	}
+
+	link := s.pageURL + "?" + url.Values{"token": {token}}.Encode()
+	if err := s.mailSender.SendMail(ctx, &model.MailMessage{
+		To:      newEmail,
+		Subject: "メールアドレス変更の確認",
+		Body: fmt.Sprintf("メールアドレスをこのアドレスに変更するには、以下のリンクを開いてください。\n\n%s\n\nこのリンクの有効期限は %s です。\nお心当たりのない場合はこのメールを破棄してください。\n",
+			link, expiresAt.Format(time.RFC3339)),
+	}); err != nil {
+		return fmt.Errorf("failed to send email change confirmation mail: %w", err)
+	}
+
+	return s.audit(ctx, user, model.UserAuditEventEmailChangeRequested, model.AuditDetail{"new_email": newEmail}, ipAddress)
+}
+
+// Confirm は新しいアドレスに送ったトークンで変更を確定し、メールアドレスを確認済みにする。
+// 変更前のアドレスには変更の通知と取り消し用のリンクを、変更より先に送る。
+func (s *EmailChangeService) Confirm(ctx context.Context, token, ipAddress string) error {
+	revertToken, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email change revert token: %w", err)
+	}
+	revertExpiresAt := time.Now().Add(emailChangeRevertLifetime)
+
+	req, err := s.requestStore.Confirm(ctx, s.hashToken(token), s.hashToken(revertToken), revertExpiresAt)
+	if err != nil {
+		return fmt.Errorf("failed to confirm email change request: %w", err)
+	}
+	if req == nil {
+		return ErrInvalidEmailChangeToken
+	}
+	user, err := s.userStore.FindByID(ctx, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	// リクエスト後に別の経路でメールアドレスが変更された
+	if user == nil || user.Email != req.OldEmail {
+		return ErrInvalidEmailChangeToken
+	}
+	if err := s.ensureEmailAvailable(ctx, user, req.NewEmail); err != nil {
+		return err
+	}
+
+	link := s.pageURL + "?" + url.Values{"revert_token": {revertToken}}.Encode()
+	if err := s.mailSender.SendMail(ctx, &model.MailMessage{
+		To:      req.OldEmail,
+		Subject: "メールアドレスが変更されました",
+		Body: fmt.Sprintf("アカウントのメールアドレスが %s に変更されました。\n\nお心当たりのない場合は、以下のリンクを開いて変更を取り消してください。取り消すとすべての端末からログアウトされます。\n\n%s\n\nこのリンクの有効期限は %s です。\n",
+			req.NewEmail, link, revertExpiresAt.Format(time.RFC3339)),
+	}); err != nil {
+		return fmt.Errorf("failed to send email change notification: %w", err)
+	}
+
+	ok, err := s.userStore.ChangeEmail(ctx, user.ID, req.OldEmail, req.NewEmail, true)
+	if err != nil {
+		return fmt.Errorf("failed to change email: %w", err)
+	}
+	if !ok {
+		return ErrInvalidEmailChangeToken
+	}
+
+	return s.audit(ctx, user, model.UserAuditEventEmailChanged, model.AuditDetail{"old_email": req.OldEmail, "new_email": req.NewEmail}, ipAddress)
+}
+
+// Revert は変更前のアドレスに送ったトークンで変更を取り消す。
+// 第三者による変更の可能性があるため、ユーザーのセッションとトークンを全て失効させ、未確認の変更リクエストも無効にする。
+func (s *EmailChangeService) Revert(ctx context.Context, revertToken, ipAddress string) error {
+	req, err := s.requestStore.Revert(ctx, s.hashToken(revertToken))
+	if err != nil {
+		return fmt.Errorf("failed to revert email change request: %w", err)
+	}
+	if req == nil {
+		return ErrInvalidEmailChangeToken
+	}
+	user, err := s.userStore.FindByID(ctx, req.UserID)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return ErrInvalidEmailChangeToken
+	}
+	if err := s.ensureEmailAvailable(ctx, user, req.OldEmail); err != nil {
+		return err
+	}
+
+	ok, err := s.userStore.ChangeEmail(ctx, user.ID, req.NewEmail, req.OldEmail, req.OldEmailVerified)
+	if err != nil {
+		return fmt.Errorf("failed to change email: %w", err)
+	}
+	// その後さらに変更された場合は取り消さない
+	if !ok {
+		return ErrInvalidEmailChangeToken
+	}
+
+	if err := s.requestStore.DeletePendingByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to invalidate email change requests: %w", err)
+	}
+	for _, revoker := range []UserTokenRevoker{s.sessionRevoker, s.accessTokenRevoker, s.refreshTokenRevoker} {
+		if _, err := revoker.RevokeByUserID(ctx, user.ID); err != nil {
+			return fmt.Errorf("failed to revoke user tokens: %w", err)
+		}
+	}
+
+	return s.audit(ctx, user, model.UserAuditEventEmailChangeReverted, model.AuditDetail{"old_email": req.NewEmail, "new_email": req.OldEmail}, ipAddress)
+}
+
+// ensureEmailAvailable は email がテナント内の他のユーザーに使われていないことを確認する。
+func (s *EmailChangeService) ensureEmailAvailable(ctx context.Context, user *model.User, email string) error {
+	other, err := s.userStore.FindByTenantAndEmail(ctx, user.TenantID, email)
+	if err != nil {
+		return fmt.Errorf("failed to find user by email: %w", err)
+	}
+	if other != nil && other.ID != user.ID {
+		return ErrEmailAlreadyInUse
+	}
+	return nil
+}
+
+func (s *EmailChangeService) audit(ctx context.Context, user *model.User, event string, detail model.AuditDetail, ipAddress string) error {
+	if err := s.auditLog.Create(ctx, &model.UserAuditLog{
+		TenantID:  user.TenantID,
+		UserID:    user.ID,
+		Event:     event,
+		Detail:    detail,
+		IPAddress: ipAddress,
+	}); err != nil {
+		return fmt.Errorf("failed to record audit log: %w", err)
+	}
+	return nil
+}
+
+// isValidEmail は表示名や <> を含まない単一のメールアドレスか判定する。
+func isValidEmail(email string) bool {
+	if email == "" || len(email) > 255 {
+		return false
+	}
+	addr, err := mail.ParseAddress(email)
+	return err == nil && addr.Address == email
+}
+
+// generateToken はメールのリンクに含める 32 バイトの乱数トークン (hex) を生成する。
+func generateToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,91 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// EmailChangeHandler は OP Frontend のメールアドレス変更画面向けの内部 API を処理する。
+type EmailChangeHandler struct {
+	authSvc     *AuthService
+	userFinder  UserFinder
+	emailChange *EmailChangeService
+}
+
+func NewEmailChangeHandler(authSvc *AuthService, userFinder UserFinder, emailChange *EmailChangeService) *EmailChangeHandler {
+	return &EmailChangeHandler{authSvc: authSvc, userFinder: userFinder, emailChange: emailChange}
+}
+
+type emailChangeRequest struct {
+	NewEmail string `json:"new_email"`
+}
+
+type emailChangeTokenRequest struct {
+	Token string `json:"token"`
+}
+
+// HandleRequest は POST /internal/email/change-request を処理する。
+// ログイン中のユーザーの新しいメールアドレスに確認メールを送信する。
+func (h *EmailChangeHandler) HandleRequest(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	var req emailChangeRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	if err := h.emailChange.RequestChange(c.Request().Context(), user, req.NewEmail, c.RealIP()); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+// HandleConfirm は POST /internal/email/change-confirm を処理する。
+// 新しいアドレスに送ったリンクのトークンで変更を確定する。ログインは不要。
+func (h *EmailChangeHandler) HandleConfirm(c echo.Context) error {
+	var req emailChangeTokenRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	if err := h.emailChange.Confirm(c.Request().Context(), req.Token, c.RealIP()); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "changed"})
+}
+
+// HandleRevert は POST /internal/email/change-revert を処理する。
+// 変更前のアドレスに送ったリンクのトークンで変更を取り消す。ログインは不要。
+func (h *EmailChangeHandler) HandleRevert(c echo.Context) error {
+	var req emailChangeTokenRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	if err := h.emailChange.Revert(c.Request().Context(), req.Token, c.RealIP()); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "reverted"})
+}
+
+func (h *EmailChangeHandler) errorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrInvalidEmail):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_email"})
+	case errors.Is(err, ErrEmailUnchanged):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "email_unchanged"})
+	case errors.Is(err, ErrInvalidEmailChangeToken):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_token"})
+	case errors.Is(err, ErrEmailAlreadyInUse):
+		return c.JSON(http.StatusConflict, map[string]string{"error": "email_already_in_use"})
+	case errors.Is(err, ErrEmailChangeThrottled):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_requests"})
+	}
+	c.Logger().Errorf("email change error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+}
@@ -0,0 +1,279 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/mailer"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeEmailChangeRequestStore struct {
+	requests []*model.EmailChangeRequest
+}
+
+func (f *fakeEmailChangeRequestStore) Create(_ context.Context, req *model.EmailChangeRequest) error {
+	req.ID = uuid.New()
+	req.CreatedAt = time.Now()
+	f.requests = append(f.requests, req)
+	return nil
+}
+
+func (f *fakeEmailChangeRequestStore) FindLatestByUserID(_ context.Context, userID uuid.UUID) (*model.EmailChangeRequest, error) {
+	for i := len(f.requests) - 1; i >= 0; i-- {
+		if f.requests[i].UserID == userID {
+			copied := *f.requests[i]
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeEmailChangeRequestStore) DeletePendingByUserID(_ context.Context, userID uuid.UUID) error {
+	var kept []*model.EmailChangeRequest
+	for _, r := range f.requests {
+		if r.UserID != userID || r.ConfirmedAt != nil {
+			kept = append(kept, r)
+		}
+	}
+	f.requests = kept
+	return nil
+}
+
+func (f *fakeEmailChangeRequestStore) Confirm(_ context.Context, tokenHash, revertTokenHash string, revertExpiresAt time.Time) (*model.EmailChangeRequest, error) {
+	for _, r := range f.requests {
+		if r.TokenHash == tokenHash && r.ConfirmedAt == nil && r.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			r.ConfirmedAt, r.RevertTokenHash, r.RevertExpiresAt = &now, &revertTokenHash, &revertExpiresAt
+			copied := *r
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeEmailChangeRequestStore) Revert(_ context.Context, revertTokenHash string) (*model.EmailChangeRequest, error) {
+	for _, r := range f.requests {
+		if r.RevertTokenHash != nil && *r.RevertTokenHash == revertTokenHash && r.RevertedAt == nil && r.RevertExpiresAt.After(time.Now()) {
+			now := time.Now()
+			r.RevertedAt = &now
+			copied := *r
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeAuditLogStore struct {
+	entries []*model.UserAuditLog
+}
+
+func (f *fakeAuditLogStore) Create(_ context.Context, entry *model.UserAuditLog) error {
+	f.entries = append(f.entries, entry)
+	return nil
+}
+
+func (f *fakeAuditLogStore) events() []string {
+	var events []string
+	for _, e := range f.entries {
+		events = append(events, e.Event)
+	}
+	return events
+}
+
+type fakeTokenRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (f *fakeTokenRevoker) RevokeByUserID(_ context.Context, userID uuid.UUID) (int64, error) {
+	f.revoked = append(f.revoked, userID)
+	return 1, nil
+}
+
+type emailChangeFixture struct {
+	svc      *EmailChangeService
+	requests *fakeEmailChangeRequestStore
+	users    *fakeUserStore
+	audit    *fakeAuditLogStore
+	outbox   *mailer.Outbox
+	revokers []*fakeTokenRevoker
+	user     *model.User
+	other    *model.User
+}
+
+func newEmailChangeFixture() *emailChangeFixture {
+	tenantID := uuid.New()
+	user := &model.User{ID: uuid.New(), TenantID: tenantID, Email: "alice@example.com", EmailVerified: true, UpdatedAt: time.Unix(1700000000, 0)}
+	other := &model.User{ID: uuid.New(), TenantID: tenantID, Email: "bob@example.com"}
+	f := &emailChangeFixture{
+		requests: &fakeEmailChangeRequestStore{},
+		users:    newFakeUserStore(user, other),
+		audit:    &fakeAuditLogStore{},
+		outbox:   newTestOutbox(),
+		revokers: []*fakeTokenRevoker{{}, {}, {}},
+		user:     user,
+		other:    other,
+	}
+	f.svc = NewEmailChangeService(f.requests, f.users, f.audit, f.outbox, fakeHashToken,
+		f.revokers[0], f.revokers[1], f.revokers[2], "https://login.example.com")
+	return f
+}
+
+// current はストアに保存されたユーザーを返す
+func (f *emailChangeFixture) current() *model.User {
+	return f.users.users[f.user.ID]
+}
+
+func TestEmailChangeConfirmAndRevert(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailChangeFixture()
+
+	if err := f.svc.RequestChange(ctx, f.user, "alice@example.net", "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	// 確認されるまでは変更しない
+	if f.current().Email != "alice@example.com" {
+		t.Fatalf("email changed before confirmation")
+	}
+	token := linkParam(t, f.outbox, "alice@example.net", "token")
+	if f.requests.requests[0].TokenHash != fakeHashToken(token) {
+		t.Errorf("token stored in plain text")
+	}
+
+	if err := f.svc.Confirm(ctx, token, "192.0.2.1"); err != nil {
+		t.Fatal(err)
+	}
+	user := f.current()
+	// UserInfo の updated_at に反映されるよう更新日時も変える
+	if user.Email != "alice@example.net" || !user.EmailVerified || !user.UpdatedAt.After(time.Unix(1700000000, 0)) {
+		t.Errorf("user = %+v", user)
+	}
+	if err := f.svc.Confirm(ctx, token, ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("reuse: err = %v", err)
+	}
+
+	// 変更前のアドレスに取り消しリンクを送る
+	revert := linkParam(t, f.outbox, "alice@example.com", "revert_token")
+	if err := f.svc.Revert(ctx, revert, "198.51.100.1"); err != nil {
+		t.Fatal(err)
+	}
+	user = f.current()
+	if user.Email != "alice@example.com" || !user.EmailVerified {
+		t.Errorf("reverted user = %+v", user)
+	}
+	// 取り消すとセッション・アクセストークン・リフレッシュトークンを失効させる
+	for i, r := range f.revokers {
+		if len(r.revoked) != 1 || r.revoked[0] != f.user.ID {
+			t.Errorf("revoker %d: %v", i, r.revoked)
+		}
+	}
+	if err := f.svc.Revert(ctx, revert, ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+		t.Errorf("revert twice: err = %v", err)
+	}
+
+	want := []string{model.UserAuditEventEmailChangeRequested, model.UserAuditEventEmailChanged, model.UserAuditEventEmailChangeReverted}
+	if got := f.audit.events(); len(got) != len(want) || got[0] != want[0] || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("audit = %v", got)
+	}
+	if d := f.audit.entries[1].Detail; d["old_email"] != "alice@example.com" || d["new_email"] != "alice@example.net" || f.audit.entries[2].IPAddress != "198.51.100.1" {
+		t.Errorf("audit entries = %+v", f.audit.entries)
+	}
+}
+
+func TestEmailChangeRequestValidation(t *testing.T) {
+	ctx := context.Background()
+	tests := []struct {
+		name     string
+		newEmail string
+		want     error
+	}{
+		{name: "不正な形式", newEmail: "not-an-email", want: ErrInvalidEmail},
+		{name: "表示名付き", newEmail: "Alice <alice@example.net>", want: ErrInvalidEmail},
+		{name: "大文字小文字のみ異なる", newEmail: "ALICE@example.com", want: ErrEmailUnchanged},
+		{name: "テナント内の他のユーザーが使用中", newEmail: "Bob@example.com", want: ErrEmailAlreadyInUse},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newEmailChangeFixture()
+			if err := f.svc.RequestChange(ctx, f.user, tt.newEmail, ""); !errors.Is(err, tt.want) {
+				t.Errorf("err = %v, want %v", err, tt.want)
+			}
+			if len(f.outbox.Messages("")) != 0 || len(f.requests.requests) != 0 {
+				t.Error("request created")
+			}
+		})
+	}
+
+	t.Run("続けてリクエストできない", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		if err := f.svc.RequestChange(ctx, f.user, "alice@example.org", ""); !errors.Is(err, ErrEmailChangeThrottled) {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestEmailChangeInvalidConfirmation(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("新しいリクエストで古い確認リンクは無効になる", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		first := linkParam(t, f.outbox, "alice@example.net", "token")
+		f.requests.requests[0].CreatedAt = time.Now().Add(-emailChangeRequestInterval)
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.org", "")
+		if err := f.svc.Confirm(ctx, first, ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+			t.Errorf("err = %v", err)
+		}
+		if err := f.svc.Confirm(ctx, linkParam(t, f.outbox, "alice@example.org", "token"), ""); err != nil || f.current().Email != "alice@example.org" {
+			t.Errorf("err = %v, email = %s", err, f.current().Email)
+		}
+	})
+
+	t.Run("期限切れ", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		f.requests.requests[0].ExpiresAt = time.Now().Add(-time.Second)
+		if err := f.svc.Confirm(ctx, linkParam(t, f.outbox, "alice@example.net", "token"), ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("確認までに他のユーザーが同じアドレスを使った", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		f.users.users[f.other.ID].Email = "alice@example.net"
+		if err := f.svc.Confirm(ctx, linkParam(t, f.outbox, "alice@example.net", "token"), ""); !errors.Is(err, ErrEmailAlreadyInUse) {
+			t.Errorf("err = %v", err)
+		}
+		if f.current().Email != "alice@example.com" {
+			t.Error("email changed")
+		}
+	})
+
+	t.Run("リクエスト後に別の経路で変更された", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		f.users.users[f.user.ID].Email = "alice@example.org"
+		if err := f.svc.Confirm(ctx, linkParam(t, f.outbox, "alice@example.net", "token"), ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("変更後にさらに変更された場合は取り消さない", func(t *testing.T) {
+		f := newEmailChangeFixture()
+		_ = f.svc.RequestChange(ctx, f.user, "alice@example.net", "")
+		_ = f.svc.Confirm(ctx, linkParam(t, f.outbox, "alice@example.net", "token"), "")
+		f.users.users[f.user.ID].Email = "alice@example.org"
+		if err := f.svc.Revert(ctx, linkParam(t, f.outbox, "alice@example.com", "revert_token"), ""); !errors.Is(err, ErrInvalidEmailChangeToken) {
+			t.Errorf("err = %v", err)
+		}
+		if f.current().Email != "alice@example.org" || len(f.revokers[0].revoked) != 0 {
+			t.Errorf("email = %s, revoked = %v", f.current().Email, f.revokers[0].revoked)
+		}
+	})
+}
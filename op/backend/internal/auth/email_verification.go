@@ -0,0 +1,116 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// emailVerificationTokenLifetime は確認メールのリンクの有効期間
+	emailVerificationTokenLifetime = 24 * time.Hour
+	// emailVerificationResendInterval はユーザー操作による確認メールの再送間隔の下限
+	emailVerificationResendInterval = time.Minute
+)
+
+// EmailVerificationService はメールアドレス確認用のトークン発行・確認メール送信・確認を行う。
+type EmailVerificationService struct {
+	tokenStore    EmailVerificationTokenStore
+	userStore     UserEmailStore
+	mailSender    MailSender
+	hashToken     HashTokenFunc
+	verifyPageURL string
+}
+
+func NewEmailVerificationService(
+	tokenStore EmailVerificationTokenStore,
+	userStore UserEmailStore,
+	mailSender MailSender,
+	hashToken HashTokenFunc,
+	frontendBaseURL string,
+) *EmailVerificationService {
+	return &EmailVerificationService{
+		tokenStore:    tokenStore,
+		userStore:     userStore,
+		mailSender:    mailSender,
+		hashToken:     hashToken,
+		verifyPageURL: frontendBaseURL + "/verify-email",
+	}
+}
+
+// RequestVerification はユーザー自身の操作で確認メールを送信する。
+// 直近に送信済みの場合は ErrEmailVerificationThrottled を返す (メール爆撃対策)。
+func (s *EmailVerificationService) RequestVerification(ctx context.Context, user *model.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+	latest, err := s.tokenStore.FindLatestByUserID(ctx, user.ID)
+	if err != nil {
+		return fmt.Errorf("failed to find latest email verification token: %w", err)
+	}
+	if latest != nil && time.Since(latest.CreatedAt) < emailVerificationResendInterval {
+		return ErrEmailVerificationThrottled
+	}
+	return s.SendVerification(ctx, user)
+}
+
+// SendVerification は確認メールを送信する。未使用の古いトークンは無効にする。
+func (s *EmailVerificationService) SendVerification(ctx context.Context, user *model.User) error {
+	if user.EmailVerified {
+		return ErrEmailAlreadyVerified
+	}
+
+	token, err := generateToken()
+	if err != nil {
+		return fmt.Errorf("failed to generate email verification token: %w", err)
+	}
+
+	if err := s.tokenStore.DeleteUnusedByUserID(ctx, user.ID); err != nil {
+		return fmt.Errorf("failed to invalidate email verification tokens: %w", err)
+	}
+	expiresAt := time.Now().Add(emailVerificationTokenLifetime)
+	if err := s.tokenStore.Create(ctx, &model.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: s.hashToken(token),
+		ExpiresAt: expiresAt,
+	}); err != nil {
+		return fmt.Errorf("failed to create email verification token: %w", err)
+	}
+
+	link := s.verifyPageURL + "?" + url.Values{"token": {token}}.Encode()
+	msg := &model.MailMessage{
+		To:      user.Email,
+		Subject: "メールアドレスの確認",
+		Body: fmt.Sprintf("以下のリンクを開いてメールアドレスを確認してください。\n\n%s\n\nこのリンクの有効期限は %s です。\nお心当たりのない場合はこのメールを破棄してください。\n",
+			link, expiresAt.Format(time.RFC3339)),
+	}
+	if err := s.mailSender.SendMail(ctx, msg); err != nil {
+		return fmt.Errorf("failed to send email verification mail: %w", err)
+	}
+	return nil
+}
+
+// Verify はトークンを使用済みにしてメールアドレスを確認済みにする。
+// トークンの発行後にメールアドレスが変更されていた場合は無効として扱う。
+func (s *EmailVerificationService) Verify(ctx context.Context, token string) error {
+	record, err := s.tokenStore.Consume(ctx, s.hashToken(token))
+	if err != nil {
+		return fmt.Errorf("failed to consume email verification token: %w", err)
+	}
+	if record == nil {
+		return ErrInvalidEmailVerificationToken
+	}
+
+	ok, err := s.userStore.MarkEmailVerified(ctx, record.UserID, record.Email)
+	if err != nil {
+		return fmt.Errorf("failed to mark email verified: %w", err)
+	}
+	if !ok {
+		return ErrInvalidEmailVerificationToken
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// EmailVerificationHandler は OP Frontend のメールアドレス確認画面向けの内部 API を処理する。
+type EmailVerificationHandler struct {
+	authSvc       *AuthService
+	userFinder    UserFinder
+	emailVerifier *EmailVerificationService
+}
+
+func NewEmailVerificationHandler(authSvc *AuthService, userFinder UserFinder, emailVerifier *EmailVerificationService) *EmailVerificationHandler {
+	return &EmailVerificationHandler{authSvc: authSvc, userFinder: userFinder, emailVerifier: emailVerifier}
+}
+
+type emailVerifyRequest struct {
+	Token string `json:"token"`
+}
+
+// HandleRequest は POST /internal/email/verify-request を処理する。
+// ログイン中のユーザーのメールアドレスに確認メールを送信する。
+func (h *EmailVerificationHandler) HandleRequest(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if err := h.emailVerifier.RequestVerification(c.Request().Context(), user); err != nil {
+		switch {
+		case errors.Is(err, ErrEmailAlreadyVerified):
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "already_verified"})
+		case errors.Is(err, ErrEmailVerificationThrottled):
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_requests"})
+		}
+		c.Logger().Errorf("failed to send email verification: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+// HandleVerify は POST /internal/email/verify を処理する。
+// 確認メールのリンクに含まれるトークンでメールアドレスを確認済みにする。ログインは不要。
+func (h *EmailVerificationHandler) HandleVerify(c echo.Context) error {
+	var req emailVerifyRequest
+	if err := c.Bind(&req); err != nil || req.Token == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	if err := h.emailVerifier.Verify(c.Request().Context(), req.Token); err != nil {
+		if errors.Is(err, ErrInvalidEmailVerificationToken) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_token"})
+		}
+		c.Logger().Errorf("failed to verify email: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{"status": "verified"})
+}
+
+// sessionUser は op_session Cookie のセッションのユーザーを返す。
+// セッションが無効な場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func sessionUser(c echo.Context, authSvc *AuthService, userFinder UserFinder) (*model.User, error) {
+	ctx := c.Request().Context()
+
+	cookie, err := c.Cookie("op_session")
+	if err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "no_session"})
+	}
+	sessionID, err := uuid.Parse(cookie.Value)
+	if err != nil {
+		return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_session"})
+	}
+	session, err := authSvc.ValidateSession(ctx, sessionID)
+	if err != nil {
+		if errors.Is(err, ErrSessionNotFound) || errors.Is(err, ErrSessionExpired) {
+			return nil, c.JSON(http.StatusUnauthorized, map[string]string{"error": "session_expired"})
+		}
+		c.Logger().Errorf("session validation error: %v", err)
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	user, err := userFinder.FindByID(ctx, session.UserID)
+	if err != nil || user == nil {
+		return nil, c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	return user, nil
+}
@@ -0,0 +1,227 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/mailer"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeEmailVerificationTokenStore struct {
+	tokens []*model.EmailVerificationToken
+}
+
+func (f *fakeEmailVerificationTokenStore) Create(_ context.Context, token *model.EmailVerificationToken) error {
+	token.ID = uuid.New()
+	token.CreatedAt = time.Now()
+	f.tokens = append(f.tokens, token)
+	return nil
+}
+
+func (f *fakeEmailVerificationTokenStore) FindLatestByUserID(_ context.Context, userID uuid.UUID) (*model.EmailVerificationToken, error) {
+	for i := len(f.tokens) - 1; i >= 0; i-- {
+		if f.tokens[i].UserID == userID {
+			return f.tokens[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeEmailVerificationTokenStore) DeleteUnusedByUserID(_ context.Context, userID uuid.UUID) error {
+	var kept []*model.EmailVerificationToken
+	for _, t := range f.tokens {
+		if t.UserID != userID || t.UsedAt != nil {
+			kept = append(kept, t)
+		}
+	}
+	f.tokens = kept
+	return nil
+}
+
+func (f *fakeEmailVerificationTokenStore) Consume(_ context.Context, tokenHash string) (*model.EmailVerificationToken, error) {
+	for _, t := range f.tokens {
+		if t.TokenHash == tokenHash && t.UsedAt == nil && t.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			t.UsedAt = &now
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func newTestOutbox() *mailer.Outbox {
+	return mailer.NewOutbox(log.New(io.Discard, "", 0))
+}
+
+// linkParam は to 宛ての最後のメールに含まれるリンクのクエリパラメータ name の値を返す
+func linkParam(t *testing.T, outbox *mailer.Outbox, to, name string) string {
+	t.Helper()
+	msgs := outbox.Messages(to)
+	if len(msgs) == 0 {
+		t.Fatalf("no mail to %s", to)
+	}
+	for _, line := range strings.Split(msgs[len(msgs)-1].Body, "\n") {
+		if !strings.HasPrefix(line, "https://") {
+			continue
+		}
+		u, err := url.Parse(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v := u.Query().Get(name); v != "" {
+			return v
+		}
+	}
+	t.Fatalf("no %s link in mail: %s", name, msgs[len(msgs)-1].Body)
+	return ""
+}
+
+type emailVerificationFixture struct {
+	svc    *EmailVerificationService
+	tokens *fakeEmailVerificationTokenStore
+	users  *fakeUserStore
+	outbox *mailer.Outbox
+	user   *model.User
+}
+
+func newEmailVerificationFixture() *emailVerificationFixture {
+	user := &model.User{ID: uuid.New(), TenantID: uuid.New(), LoginID: "alice", Email: "alice@example.com", Status: "active"}
+	f := &emailVerificationFixture{
+		tokens: &fakeEmailVerificationTokenStore{},
+		users:  newFakeUserStore(user),
+		outbox: newTestOutbox(),
+		user:   user,
+	}
+	f.svc = NewEmailVerificationService(f.tokens, f.users, f.outbox, fakeHashToken, "https://login.example.com")
+	return f
+}
+
+func TestEmailVerification(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailVerificationFixture()
+
+	if err := f.svc.RequestVerification(ctx, f.user); err != nil {
+		t.Fatal(err)
+	}
+	token := linkParam(t, f.outbox, "alice@example.com", "token")
+	// 平文のトークンは保存しない
+	if len(f.tokens.tokens) != 1 || f.tokens.tokens[0].TokenHash != fakeHashToken(token) || f.tokens.tokens[0].Email != "alice@example.com" {
+		t.Fatalf("tokens = %+v", f.tokens.tokens)
+	}
+	// 直近に送信済みなら再送しない
+	if err := f.svc.RequestVerification(ctx, f.user); !errors.Is(err, ErrEmailVerificationThrottled) {
+		t.Errorf("resend: err = %v", err)
+	}
+	if n := len(f.outbox.Messages("")); n != 1 {
+		t.Errorf("sent %d mails", n)
+	}
+
+	if err := f.svc.Verify(ctx, token); err != nil {
+		t.Fatal(err)
+	}
+	if !f.users.users[f.user.ID].EmailVerified {
+		t.Error("email not verified")
+	}
+	// トークンは1回のみ使える
+	if err := f.svc.Verify(ctx, token); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+		t.Errorf("reuse: err = %v", err)
+	}
+	f.user.EmailVerified = true
+	if err := f.svc.RequestVerification(ctx, f.user); !errors.Is(err, ErrEmailAlreadyVerified) {
+		t.Errorf("already verified: err = %v", err)
+	}
+}
+
+func TestEmailVerificationInvalidToken(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("存在しないトークン", func(t *testing.T) {
+		f := newEmailVerificationFixture()
+		if err := f.svc.Verify(ctx, "unknown"); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("期限切れ", func(t *testing.T) {
+		f := newEmailVerificationFixture()
+		if err := f.svc.SendVerification(ctx, f.user); err != nil {
+			t.Fatal(err)
+		}
+		f.tokens.tokens[0].ExpiresAt = time.Now().Add(-time.Second)
+		if err := f.svc.Verify(ctx, linkParam(t, f.outbox, f.user.Email, "token")); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("再送すると古いトークンは使えない", func(t *testing.T) {
+		f := newEmailVerificationFixture()
+		_ = f.svc.SendVerification(ctx, f.user)
+		first := linkParam(t, f.outbox, f.user.Email, "token")
+		_ = f.svc.SendVerification(ctx, f.user)
+		second := linkParam(t, f.outbox, f.user.Email, "token")
+		if err := f.svc.Verify(ctx, first); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+			t.Errorf("first: err = %v", err)
+		}
+		if err := f.svc.Verify(ctx, second); err != nil {
+			t.Errorf("second: err = %v", err)
+		}
+	})
+
+	t.Run("発行後にメールアドレスが変わった", func(t *testing.T) {
+		f := newEmailVerificationFixture()
+		_ = f.svc.SendVerification(ctx, f.user)
+		f.users.users[f.user.ID].Email = "alice@example.net"
+		if err := f.svc.Verify(ctx, linkParam(t, f.outbox, "alice@example.com", "token")); !errors.Is(err, ErrInvalidEmailVerificationToken) {
+			t.Errorf("err = %v", err)
+		}
+		if f.users.users[f.user.ID].EmailVerified {
+			t.Error("new address verified by old token")
+		}
+	})
+}
+
+func TestLoginRequiresVerifiedEmail(t *testing.T) {
+	ctx := context.Background()
+	f := newEmailVerificationFixture()
+	tenant := &model.Tenant{ID: f.user.TenantID, Code: "demo", SessionLifetime: 3600, EmailVerificationPolicy: model.EmailVerificationPolicyLogin}
+	svc, sessions := newTestAuthService(tenant, f.users, f.svc, nil)
+	input := &model.LoginInput{TenantCode: "demo", LoginID: "alice", Password: "correct"}
+
+	// 未確認のユーザーはログインできず、確認メールを送る。続けてログインしても再送しない
+	for i := 0; i < 2; i++ {
+		if _, err := svc.Login(ctx, input); !errors.Is(err, ErrEmailNotVerified) {
+			t.Fatalf("attempt %d: err = %v", i, err)
+		}
+	}
+	if n := len(f.outbox.Messages(f.user.Email)); n != 1 || len(sessions.sessions) != 0 {
+		t.Errorf("mails = %d, sessions = %d", n, len(sessions.sessions))
+	}
+	// パスワードが違う場合は確認状況を明かさない
+	if _, err := svc.Login(ctx, &model.LoginInput{TenantCode: "demo", LoginID: "alice", Password: "wrong"}); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("wrong password: err = %v", err)
+	}
+
+	if err := f.svc.Verify(ctx, linkParam(t, f.outbox, f.user.Email, "token")); err != nil {
+		t.Fatal(err)
+	}
+	out, err := svc.Login(ctx, input)
+	if err != nil || sessions.sessions[out.SessionID] == nil {
+		t.Fatalf("after verification: err = %v", err)
+	}
+
+	// 認可のみ制限するテナントでは未確認でもログインできる
+	f.users.users[f.user.ID].EmailVerified = false
+	tenant.EmailVerificationPolicy = model.EmailVerificationPolicyAuthorization
+	if _, err := svc.Login(ctx, input); err != nil {
+		t.Errorf("authorization policy: err = %v", err)
+	}
+}
@@ -6,4 +6,34 @@ var (
 	ErrInvalidCredentials = errors.New("invalid credentials")
 	ErrSessionNotFound    = errors.New("session not found")
 	ErrSessionExpired     = errors.New("session expired or revoked")
+	ErrEmailNotVerified   = errors.New("email not verified")
+
+	ErrEmailAlreadyVerified          = errors.New("email already verified")
+	ErrEmailVerificationThrottled    = errors.New("email verification requested too frequently")
+	ErrInvalidEmailVerificationToken = errors.New("invalid or expired email verification token")
+
+	ErrInvalidEmail            = errors.New("invalid email address")
+	ErrEmailUnchanged          = errors.New("email is unchanged")
+	ErrEmailAlreadyInUse       = errors.New("email is already in use")
+	ErrEmailChangeThrottled    = errors.New("email change requested too frequently")
+	ErrInvalidEmailChangeToken = errors.New("invalid or expired email change token")
+
+	ErrPhoneNumberNotSet       = errors.New("phone number is not set")
+	ErrPhoneAlreadyVerified    = errors.New("phone number already verified")
+	ErrPhoneNotVerified        = errors.New("phone number is not verified")
+	ErrSMSThrottled            = errors.New("sms requested too frequently")
+	ErrInvalidSMSCode          = errors.New("invalid or expired sms code")
+	ErrSMSCodeAttemptsExceeded = errors.New("too many sms code attempts")
+
+	ErrIdentityProviderNotFound = errors.New("identity provider not found")
+	ErrInvalidFederationState   = errors.New("invalid or expired federation state")
+	ErrFederationFailed         = errors.New("federated authentication failed")
+	ErrFederatedUserNotFound    = errors.New("no user is linked to the federated identity")
+	ErrLoginIDAlreadyInUse      = errors.New("login id is already in use")
+
+	ErrDirectoryUnavailable     = errors.New("ldap directory is unavailable")
+	ErrDirectoryEntryIncomplete = errors.New("ldap directory entry lacks required attributes")
+
+	ErrUserMigrationUnavailable = errors.New("user migration hook is unavailable")
+	ErrMigratedUserIncomplete   = errors.New("migrated user lacks required attributes")
 )
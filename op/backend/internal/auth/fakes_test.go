@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// このファイルはパッケージ内のテストで共有するフェイクとヘルパーをまとめる。
+// 1つのテストでしか使わないフェイクはそのテストのファイルに置く
+
+// fakeHashToken は "hash:" + token を返す
+func fakeHashToken(token string) string { return "hash:" + token }
+
+type fakeTenantFinder struct {
+	tenants []*model.Tenant
+}
+
+func newFakeTenantFinder(tenants ...*model.Tenant) *fakeTenantFinder {
+	return &fakeTenantFinder{tenants: tenants}
+}
+
+func (f *fakeTenantFinder) FindByCode(_ context.Context, code string) (*model.Tenant, error) {
+	for _, t := range f.tenants {
+		if t.Code == code {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+// fakeUserStore は users テーブルをメモリに持つ。DB と同じく検索結果はコピーを返す
+type fakeUserStore struct {
+	users map[uuid.UUID]*model.User
+}
+
+func newFakeUserStore(users ...*model.User) *fakeUserStore {
+	f := &fakeUserStore{users: map[uuid.UUID]*model.User{}}
+	for _, u := range users {
+		f.users[u.ID] = u
+	}
+	return f
+}
+
+func (f *fakeUserStore) FindByID(_ context.Context, id uuid.UUID) (*model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (f *fakeUserStore) FindByTenantAndLoginID(_ context.Context, tenantID uuid.UUID, loginID string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.TenantID == tenantID && u.LoginID == loginID {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) FindByTenantAndEmail(_ context.Context, tenantID uuid.UUID, email string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.TenantID == tenantID && strings.EqualFold(u.Email, email) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) UpdateLastLoginAt(context.Context, uuid.UUID, time.Time) error { return nil }
+
+func (f *fakeUserStore) MarkEmailVerified(_ context.Context, id uuid.UUID, email string) (bool, error) {
+	u, ok := f.users[id]
+	if !ok || u.Email != email {
+		return false, nil
+	}
+	u.EmailVerified = true
+	return true, nil
+}
+
+func (f *fakeUserStore) ChangeEmail(_ context.Context, id uuid.UUID, from, to string, verified bool) (bool, error) {
+	u, ok := f.users[id]
+	if !ok || u.Email != from {
+		return false, nil
+	}
+	u.Email, u.EmailVerified, u.UpdatedAt = to, verified, time.Now()
+	return true, nil
+}
+
+func (f *fakeUserStore) MarkPhoneNumberVerified(_ context.Context, id uuid.UUID, phoneNumber string) (bool, error) {
+	u, ok := f.users[id]
+	if !ok || u.PhoneNumber == nil || *u.PhoneNumber != phoneNumber {
+		return false, nil
+	}
+	u.PhoneNumberVerified = true
+	return true, nil
+}
+
+func (f *fakeUserStore) UpdateSMSMFAEnabled(_ context.Context, id uuid.UUID, enabled bool) error {
+	if u, ok := f.users[id]; ok {
+		u.SMSMFAEnabled = enabled
+	}
+	return nil
+}
+
+type fakeSessionStore struct {
+	sessions map[uuid.UUID]*model.Session
+}
+
+func newFakeSessionStore() *fakeSessionStore {
+	return &fakeSessionStore{sessions: map[uuid.UUID]*model.Session{}}
+}
+
+func (f *fakeSessionStore) Create(_ context.Context, session *model.Session) error {
+	session.ID = uuid.New()
+	f.sessions[session.ID] = session
+	return nil
+}
+
+func (f *fakeSessionStore) FindByID(_ context.Context, id uuid.UUID) (*model.Session, error) {
+	return f.sessions[id], nil
+}
+
+// fakeAuthenticator は password が "correct" の場合に userFinder のユーザーを認証する
+type fakeAuthenticator struct {
+	users *fakeUserStore
+}
+
+func (a *fakeAuthenticator) Authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error) {
+	user, _ := a.users.FindByTenantAndLoginID(ctx, tenant.ID, loginID)
+	if user == nil {
+		return nil, nil
+	}
+	if password != "correct" {
+		return nil, ErrInvalidCredentials
+	}
+	return user, nil
+}
+
+// newTestAuthService は users のユーザーがパスワード "correct" でログインできる AuthService を返す
+func newTestAuthService(tenant *model.Tenant, users *fakeUserStore, emailVerifier *EmailVerificationService, smsOTP *SMSOTPService) (*AuthService, *fakeSessionStore) {
+	sessions := newFakeSessionStore()
+	svc := NewAuthService(newFakeTenantFinder(tenant), users, sessions, []Authenticator{&fakeAuthenticator{users: users}}, emailVerifier, smsOTP)
+	return svc, sessions
+}
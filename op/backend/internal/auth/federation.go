@@ -0,0 +1,349 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// federationStateLifetime は上流での認証にかけられる時間
+const federationStateLifetime = 10 * time.Minute
+
+// federatedAttributeMaxLength は JIT 作成時に上流のクレームから設定する属性の長さの上限 (users テーブルのカラム長)
+var federatedAttributeMaxLength = map[string]int{
+	model.FederatedLoginIDAttribute: 255, "email": 255,
+	"name": 255, "given_name": 255, "family_name": 255, "middle_name": 255, "nickname": 255, "preferred_username": 255,
+	"profile": 2048, "picture": 2048, "website": 2048,
+	"gender": 63, "birthdate": 10, "zoneinfo": 63, "locale": 35, "phone_number": 32,
+}
+
+// phoneNumberRegex は E.164 形式の電話番号 (OIDC Core 1.0 Section 5.1)
+var phoneNumberRegex = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+
+// FederationService は上流の OIDC Provider に認証を委譲する。
+// 上流での認証後、紐付くユーザーでログインする。紐付くユーザーがいなければ既存ユーザーへの紐付けまたは JIT 作成を行う。
+type FederationService struct {
+	authSvc         *AuthService
+	tenantFinder    TenantFinder
+	idpFinder       IdentityProviderFinder
+	stateStore      FederationStateStore
+	userStore       FederatedUserStore
+	upstream        UpstreamOIDCClient
+	hashToken       HashTokenFunc
+	decryptSecret   DecryptSecretFunc
+	callbackBaseURL string
+}
+
+func NewFederationService(
+	authSvc *AuthService,
+	tenantFinder TenantFinder,
+	idpFinder IdentityProviderFinder,
+	stateStore FederationStateStore,
+	userStore FederatedUserStore,
+	upstream UpstreamOIDCClient,
+	hashToken HashTokenFunc,
+	decryptSecret DecryptSecretFunc,
+	baseURL string,
+) *FederationService {
+	return &FederationService{
+		authSvc:         authSvc,
+		tenantFinder:    tenantFinder,
+		idpFinder:       idpFinder,
+		stateStore:      stateStore,
+		userStore:       userStore,
+		upstream:        upstream,
+		hashToken:       hashToken,
+		decryptSecret:   decryptSecret,
+		callbackBaseURL: baseURL + "/internal/federation/",
+	}
+}
+
+// ListProviders はテナントの有効な IdP を返す。テナントが見つからない場合は空を返す。
+func (s *FederationService) ListProviders(ctx context.Context, tenantCode string) ([]model.IdentityProvider, error) {
+	tenant, err := s.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find tenant: %w", err)
+	}
+	if tenant == nil {
+		return nil, nil
+	}
+	return s.idpFinder.ListActiveByTenantID(ctx, tenant.ID)
+}
+
+// Start は上流への認可リクエストの URL と、ブラウザに紐付ける state を返す。
+// 認可コードフローに PKCE (S256) と nonce を付ける (RFC 7636, OIDC Core 1.0 Section 3.1.2.1)
+func (s *FederationService) Start(ctx context.Context, tenantCode, idpCode, redirectAfterLogin string) (string, string, error) {
+	tenant, err := s.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find tenant: %w", err)
+	}
+	if tenant == nil {
+		return "", "", ErrIdentityProviderNotFound
+	}
+	idp, err := s.idpFinder.FindByCode(ctx, tenant.ID, idpCode)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to find identity provider: %w", err)
+	}
+	if idp == nil || idp.Status != "active" {
+		return "", "", ErrIdentityProviderNotFound
+	}
+
+	state, err := generateToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate state: %w", err)
+	}
+	nonce, err := generateToken()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate nonce: %w", err)
+	}
+	codeVerifier, err := generateCodeVerifier()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to generate code verifier: %w", err)
+	}
+
+	if err := s.stateStore.Create(ctx, &model.FederationState{
+		IdentityProviderID: idp.ID,
+		StateHash:          s.hashToken(state),
+		Nonce:              nonce,
+		CodeVerifier:       codeVerifier,
+		RedirectAfterLogin: redirectAfterLogin,
+		ExpiresAt:          time.Now().Add(federationStateLifetime),
+	}); err != nil {
+		return "", "", fmt.Errorf("failed to create federation state: %w", err)
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	authURL, err := s.upstream.AuthorizationURL(ctx, idp.Issuer, url.Values{
+		"response_type":         {"code"},
+		"client_id":             {idp.ClientID},
+		"redirect_uri":          {s.redirectURI(idp)},
+		"scope":                 {strings.Join(idp.Scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {"S256"},
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+	return authURL, state, nil
+}
+
+// Callback は上流からの callback を処理してセッションを作成する。
+// state が有効な場合は、エラー時もログイン画面に戻すため FederationState を返す。
+func (s *FederationService) Callback(ctx context.Context, input *model.FederatedLoginInput) (*model.LoginOutput, *model.FederationState, error) {
+	state, err := s.stateStore.Consume(ctx, s.hashToken(input.State))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to consume federation state: %w", err)
+	}
+	if state == nil {
+		return nil, nil, ErrInvalidFederationState
+	}
+	idp := &state.IdentityProvider
+	if idp.Code != input.IdPCode || idp.Status != "active" {
+		return nil, nil, ErrInvalidFederationState
+	}
+
+	// 上流での認証が拒否・失敗した (RFC 6749 Section 4.1.2.1)
+	if input.Error != "" {
+		return nil, state, fmt.Errorf("%w: upstream returned error %q", ErrFederationFailed, input.Error)
+	}
+	if input.Code == "" {
+		return nil, state, fmt.Errorf("%w: code is missing", ErrFederationFailed)
+	}
+
+	var clientSecret string
+	if idp.ClientSecretEncrypted != nil {
+		clientSecret, err = s.decryptSecret(*idp.ClientSecretEncrypted)
+		if err != nil {
+			return nil, state, fmt.Errorf("failed to decrypt client secret: %w", err)
+		}
+	}
+	claims, err := s.upstream.ExchangeCode(ctx, &model.UpstreamTokenRequest{
+		Issuer:       idp.Issuer,
+		ClientID:     idp.ClientID,
+		ClientSecret: clientSecret,
+		RedirectURI:  s.redirectURI(idp),
+		Code:         input.Code,
+		CodeVerifier: state.CodeVerifier,
+		Nonce:        state.Nonce,
+	})
+	if err != nil {
+		return nil, state, fmt.Errorf("%w: %v", ErrFederationFailed, err)
+	}
+
+	user, err := s.resolveUser(ctx, idp, claims)
+	if err != nil {
+		return nil, state, err
+	}
+	if user.Status != "active" {
+		return nil, state, ErrInvalidCredentials
+	}
+	if err := s.authSvc.requireVerifiedEmail(ctx, &idp.Tenant, user); err != nil {
+		return nil, state, err
+	}
+
+	// 上流での認証方式は分からないため amr は空にする (ID トークンに amr を含めない)
+	output, err := s.authSvc.createSession(ctx, user, &idp.Tenant, []string{}, input.IPAddress, input.UserAgent)
+	if err != nil {
+		return nil, state, err
+	}
+	return output, state, nil
+}
+
+// resolveUser は上流の sub に紐付くユーザーを返す。
+// 紐付くユーザーがいなければ、確認済みのメールアドレスが一致する既存ユーザーへの紐付け (link_by_email)、
+// ユーザーの作成 (jit_provisioning) の順に試す。
+func (s *FederationService) resolveUser(ctx context.Context, idp *model.IdentityProvider, claims map[string]interface{}) (*model.User, error) {
+	subject, _ := claims["sub"].(string)
+	if subject == "" || len(subject) > 255 {
+		return nil, fmt.Errorf("%w: invalid sub", ErrFederationFailed)
+	}
+
+	user, err := s.userStore.FindByExternalIdentity(ctx, idp.ID, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find federated user: %w", err)
+	}
+	if user != nil {
+		return user, nil
+	}
+
+	attrs := federatedAttributes(idp, claims)
+	email := attrs["email"]
+	emailVerified := email != "" && upstreamVerified(idp, claims, "email", "email_verified")
+
+	// 未確認のアドレスで紐付けると、第三者が上流で同じアドレスを登録してアカウントを乗っ取れるため、双方で確認済みの場合に限る
+	if idp.LinkByEmail && emailVerified {
+		existing, err := s.userStore.FindByTenantAndEmail(ctx, idp.TenantID, email)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find user by email: %w", err)
+		}
+		if existing != nil && existing.EmailVerified {
+			if err := s.userStore.LinkExternalIdentity(ctx, existing.ID, idp.ID, subject); err != nil {
+				return nil, fmt.Errorf("failed to link federated user: %w", err)
+			}
+			return existing, nil
+		}
+	}
+
+	if !idp.JITProvisioning {
+		return nil, ErrFederatedUserNotFound
+	}
+	return s.provisionUser(ctx, idp, subject, attrs, emailVerified, claims)
+}
+
+// provisionUser は上流のクレームからユーザーを作成し、sub に紐付ける (JIT プロビジョニング)。
+func (s *FederationService) provisionUser(ctx context.Context, idp *model.IdentityProvider, subject string, attrs map[string]string, emailVerified bool, claims map[string]interface{}) (*model.User, error) {
+	email := attrs["email"]
+	if email == "" {
+		return nil, fmt.Errorf("%w: email claim is required to provision a user", ErrFederationFailed)
+	}
+	other, err := s.userStore.FindByTenantAndEmail(ctx, idp.TenantID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by email: %w", err)
+	}
+	if other != nil {
+		return nil, ErrEmailAlreadyInUse
+	}
+
+	// login_id のクレームがなければ IdP のコードと sub から決める
+	loginID := attrs[model.FederatedLoginIDAttribute]
+	if loginID == "" {
+		loginID = idp.Code + "|" + subject
+		if len(loginID) > federatedAttributeMaxLength[model.FederatedLoginIDAttribute] {
+			return nil, fmt.Errorf("%w: sub is too long to derive login_id", ErrFederationFailed)
+		}
+	}
+	other, err = s.authSvc.userFinder.FindByTenantAndLoginID(ctx, idp.TenantID, loginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by login id: %w", err)
+	}
+	if other != nil {
+		return nil, ErrLoginIDAlreadyInUse
+	}
+
+	user := &model.User{
+		TenantID:      idp.TenantID,
+		LoginID:       loginID,
+		Email:         email,
+		EmailVerified: emailVerified,
+		Status:        "active",
+	}
+	for attr, target := range profileAttributes(user) {
+		if v, ok := attrs[attr]; ok {
+			*target = &v
+		}
+	}
+	if user.PhoneNumber != nil {
+		user.PhoneNumberVerified = upstreamVerified(idp, claims, "phone_number", "phone_number_verified")
+	}
+
+	if err := s.userStore.CreateWithExternalIdentity(ctx, user, idp.ID, subject); err != nil {
+		return nil, fmt.Errorf("failed to provision federated user: %w", err)
+	}
+	user.Tenant = idp.Tenant
+	return user, nil
+}
+
+// profileAttributes は FederatedUserAttributes のうち login_id と email 以外の属性の設定先を返す。
+func profileAttributes(user *model.User) map[string]**string {
+	return map[string]**string{
+		"name": &user.Name, "given_name": &user.GivenName, "family_name": &user.FamilyName,
+		"middle_name": &user.MiddleName, "nickname": &user.Nickname, "preferred_username": &user.PreferredUsername,
+		"profile": &user.Profile, "picture": &user.Picture, "website": &user.Website,
+		"gender": &user.Gender, "birthdate": &user.Birthdate, "zoneinfo": &user.Zoneinfo, "locale": &user.Locale,
+		"phone_number": &user.PhoneNumber,
+	}
+}
+
+func (s *FederationService) redirectURI(idp *model.IdentityProvider) string {
+	return s.callbackBaseURL + url.PathEscape(idp.Code) + "/callback"
+}
+
+// federatedAttributes は IdP のクレームマッピングに従い、上流のクレームからユーザー属性の値を取り出す。
+// 文字列でない値、長すぎる値、形式が正しくないメールアドレス・電話番号は使わない。
+func federatedAttributes(idp *model.IdentityProvider, claims map[string]interface{}) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range model.FederatedUserAttributes {
+		claim := idp.ClaimFor(attr)
+		if claim == "" {
+			continue
+		}
+		v, ok := claims[claim].(string)
+		if !ok || v == "" || len(v) > federatedAttributeMaxLength[attr] {
+			continue
+		}
+		if (attr == "email" && !isValidEmail(v)) || (attr == "phone_number" && !phoneNumberRegex.MatchString(v)) {
+			continue
+		}
+		attrs[attr] = v
+	}
+	return attrs
+}
+
+// upstreamVerified は属性が同名の標準クレームから取り出され、上流が確認済み (xxx_verified = true) としているか判定する。
+// 別のクレームを割り当てた場合、上流の確認済みフラグはその値についてのものではないため信頼しない。
+func upstreamVerified(idp *model.IdentityProvider, claims map[string]interface{}, attr, verifiedClaim string) bool {
+	if idp.ClaimFor(attr) != attr {
+		return false
+	}
+	verified, _ := claims[verifiedClaim].(bool)
+	return verified
+}
+
+// generateCodeVerifier は PKCE の code_verifier (43 文字) を生成する (RFC 7636 Section 4.1)。
+func generateCodeVerifier() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
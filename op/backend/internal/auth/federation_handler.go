@@ -0,0 +1,173 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// federationStateCookie は上流への認可リクエストを開始したブラウザと callback を結び付ける Cookie
+const federationStateCookie = "op_federation_state"
+
+// FederationHandler は上流の OIDC Provider によるログインの内部 API を処理する。
+type FederationHandler struct {
+	federation      *FederationService
+	frontendBaseURL string
+	isSecure        bool
+}
+
+func NewFederationHandler(federation *FederationService, frontendBaseURL string, isSecure bool) *FederationHandler {
+	return &FederationHandler{federation: federation, frontendBaseURL: frontendBaseURL, isSecure: isSecure}
+}
+
+// HandleList は GET /internal/federation/providers を処理する。
+// ログイン画面に表示するテナントの有効な IdP を返す。
+func (h *FederationHandler) HandleList(c echo.Context) error {
+	tenantCode := c.QueryParam("tenant_code")
+	if tenantCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "tenant_code is required"})
+	}
+
+	idps, err := h.federation.ListProviders(c.Request().Context(), tenantCode)
+	if err != nil {
+		c.Logger().Errorf("failed to list identity providers: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	providers := make([]map[string]string, 0, len(idps))
+	for _, idp := range idps {
+		providers = append(providers, map[string]string{"code": idp.Code, "name": idp.Name})
+	}
+	return c.JSON(http.StatusOK, providers)
+}
+
+// HandleStart は GET /internal/federation/:idp/start を処理する。
+// state を Cookie に保存し、上流の認可エンドポイントにリダイレクトする。
+func (h *FederationHandler) HandleStart(c echo.Context) error {
+	tenantCode := c.QueryParam("tenant_code")
+	redirectAfterLogin := c.QueryParam("redirect_after_login")
+	if tenantCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "tenant_code is required"})
+	}
+	if redirectAfterLogin != "" && !isLocalPath(redirectAfterLogin) {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "redirect_after_login must be a relative path"})
+	}
+
+	authURL, state, err := h.federation.Start(c.Request().Context(), tenantCode, c.Param("idp"), redirectAfterLogin)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrIdentityProviderNotFound):
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+		case errors.Is(err, ErrFederationFailed):
+			c.Logger().Warnf("federation start failed: %v", err)
+			return h.redirectToLogin(c, tenantCode, redirectAfterLogin, "federation_failed")
+		}
+		c.Logger().Errorf("federation start error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	c.SetCookie(&http.Cookie{
+		Name:     federationStateCookie,
+		Value:    state,
+		Path:     "/internal/federation/",
+		MaxAge:   int(federationStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   h.isSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// HandleCallback は GET /internal/federation/:idp/callback を処理する。
+// 上流からの認可レスポンスを処理してセッションを作成し、redirect_after_login にリダイレクトする。
+// 失敗した場合はエラーコードを付けてログイン画面に戻す。
+func (h *FederationHandler) HandleCallback(c echo.Context) error {
+	state := c.QueryParam("state")
+	cookie, err := c.Cookie(federationStateCookie)
+	// 別のブラウザで開始された認可レスポンスを受け付けない (CSRF 対策, RFC 6749 Section 10.12)
+	if state == "" || err != nil || cookie.Value != state {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "invalid state"})
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     federationStateCookie,
+		Value:    "",
+		Path:     "/internal/federation/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.isSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	output, fs, err := h.federation.Callback(c.Request().Context(), &model.FederatedLoginInput{
+		IdPCode:   c.Param("idp"),
+		State:     state,
+		Code:      c.QueryParam("code"),
+		Error:     c.QueryParam("error"),
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	})
+	if err != nil {
+		if fs == nil {
+			if errors.Is(err, ErrInvalidFederationState) {
+				return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "invalid state"})
+			}
+			c.Logger().Errorf("federation callback error: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+
+		code := "server_error"
+		switch {
+		case errors.Is(err, ErrFederationFailed):
+			code = "federation_failed"
+		case errors.Is(err, ErrFederatedUserNotFound):
+			code = "account_not_found"
+		case errors.Is(err, ErrEmailAlreadyInUse):
+			code = "email_already_in_use"
+		case errors.Is(err, ErrLoginIDAlreadyInUse):
+			code = "login_id_already_in_use"
+		case errors.Is(err, ErrEmailNotVerified):
+			code = "email_not_verified"
+		case errors.Is(err, ErrInvalidCredentials):
+			code = "invalid_credentials"
+		}
+		if code == "server_error" {
+			c.Logger().Errorf("federation callback error: %v", err)
+		} else {
+			c.Logger().Warnf("federation callback failed: %v", err)
+		}
+		return h.redirectToLogin(c, fs.IdentityProvider.Tenant.Code, fs.RedirectAfterLogin, code)
+	}
+
+	setSessionCookie(c, output, h.isSecure)
+	if fs.RedirectAfterLogin != "" && isLocalPath(fs.RedirectAfterLogin) {
+		return c.Redirect(http.StatusFound, fs.RedirectAfterLogin)
+	}
+	return c.Redirect(http.StatusFound, h.frontendBaseURL+"/")
+}
+
+// redirectToLogin はエラーコードを付けて OP Frontend のログイン画面にリダイレクトする。
+func (h *FederationHandler) redirectToLogin(c echo.Context, tenantCode, redirectAfterLogin, errorCode string) error {
+	loginURL, err := url.Parse(h.frontendBaseURL + "/login")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := loginURL.Query()
+	q.Set("tenant_code", tenantCode)
+	if redirectAfterLogin != "" {
+		q.Set("redirect_after_login", redirectAfterLogin)
+	}
+	q.Set("error", errorCode)
+	loginURL.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, loginURL.String())
+}
+
+// isLocalPath は OP Backend 内の相対パスか判定する。
+// "//" や "/\" で始まる値はブラウザが別ホストとして解釈するため受け付けない (オープンリダイレクト対策)
+func isLocalPath(p string) bool {
+	return strings.HasPrefix(p, "/") && !strings.HasPrefix(p, "//") && !strings.HasPrefix(p, "/\\")
+}
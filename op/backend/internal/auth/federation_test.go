@@ -0,0 +1,486 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/federation"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// upstreamOP は認可コードフロー (PKCE 必須) に対応した上流の OIDC Provider。
+// 認可エンドポイントはユーザーの認証を省略し、claims を持つユーザーとして認可コードを発行する
+type upstreamOP struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// claims は ID トークンに含めるユーザーのクレーム
+	claims map[string]interface{}
+	// override は ID トークンのクレームを上書きする (不正な ID トークンを返す場合に使う)
+	override map[string]interface{}
+	// codes は発行した認可コード → 認可リクエストのパラメータ
+	codes map[string]url.Values
+}
+
+func newUpstreamOP(t *testing.T) *upstreamOP {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	op := &upstreamOP{key: key, codes: map[string]url.Values{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 op.URL,
+			"authorization_endpoint": op.URL + "/authorize",
+			"token_endpoint":         op.URL + "/token",
+			"jwks_uri":               op.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/authorize", func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		code := uuid.NewString()
+		op.codes[code] = q
+		redirect, _ := url.Parse(q.Get("redirect_uri"))
+		redirect.RawQuery = url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+		http.Redirect(w, r, redirect.String(), http.StatusFound)
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		authz, ok := op.codes[r.PostForm.Get("code")]
+		delete(op.codes, r.PostForm.Get("code"))
+		clientID, secret, _ := r.BasicAuth()
+		verifier := sha256.Sum256([]byte(r.PostForm.Get("code_verifier")))
+		if !ok || clientID != authz.Get("client_id") || secret != "upstream-secret" ||
+			r.PostForm.Get("redirect_uri") != authz.Get("redirect_uri") ||
+			base64.RawURLEncoding.EncodeToString(verifier[:]) != authz.Get("code_challenge") {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": op.idToken(t, authz)})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		pub, _ := jwk.Import(&key.PublicKey)
+		_ = pub.Set(jwk.KeyIDKey, "upstream-key")
+		set := jwk.NewSet()
+		_ = set.AddKey(pub)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	op.Server = httptest.NewServer(mux)
+	t.Cleanup(op.Close)
+	return op
+}
+
+func (op *upstreamOP) idToken(t *testing.T, authz url.Values) string {
+	token := jwt.New()
+	claims := map[string]interface{}{
+		"iss":   op.URL,
+		"aud":   authz.Get("client_id"),
+		"nonce": authz.Get("nonce"),
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+	}
+	for _, m := range []map[string]interface{}{op.claims, op.override} {
+		for name, v := range m {
+			claims[name] = v
+		}
+	}
+	for name, v := range claims {
+		_ = token.Set(name, v)
+	}
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, "upstream-key")
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), op.key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Error(err)
+	}
+	return string(signed)
+}
+
+type fakeIdentityProviderFinder struct {
+	idps []*model.IdentityProvider
+}
+
+func (f *fakeIdentityProviderFinder) ListActiveByTenantID(_ context.Context, tenantID uuid.UUID) ([]model.IdentityProvider, error) {
+	var list []model.IdentityProvider
+	for _, idp := range f.idps {
+		if idp.TenantID == tenantID && idp.Status == "active" {
+			list = append(list, *idp)
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeIdentityProviderFinder) FindByCode(_ context.Context, tenantID uuid.UUID, code string) (*model.IdentityProvider, error) {
+	for _, idp := range f.idps {
+		if idp.TenantID == tenantID && idp.Code == code {
+			copied := *idp
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// fakeFederationStateStore は federation_states テーブルをメモリに持つ。Consume は IdP をプリロードする
+type fakeFederationStateStore struct {
+	states []*model.FederationState
+	idps   *fakeIdentityProviderFinder
+}
+
+func (f *fakeFederationStateStore) Create(_ context.Context, state *model.FederationState) error {
+	state.ID = uuid.New()
+	f.states = append(f.states, state)
+	return nil
+}
+
+func (f *fakeFederationStateStore) Consume(_ context.Context, stateHash string) (*model.FederationState, error) {
+	for _, s := range f.states {
+		if s.StateHash == stateHash && s.UsedAt == nil && s.ExpiresAt.After(time.Now()) {
+			now := time.Now()
+			s.UsedAt = &now
+			copied := *s
+			for _, idp := range f.idps.idps {
+				if idp.ID == s.IdentityProviderID {
+					copied.IdentityProvider = *idp
+				}
+			}
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+// fakeFederatedUserStore は上流の sub とユーザーの紐付け (external_idp_credentials) をメモリに持つ
+type fakeFederatedUserStore struct {
+	*fakeUserStore
+	links map[string]uuid.UUID
+}
+
+func federatedLinkKey(identityProviderID uuid.UUID, subject string) string {
+	return identityProviderID.String() + "|" + subject
+}
+
+func (f *fakeFederatedUserStore) FindByExternalIdentity(ctx context.Context, identityProviderID uuid.UUID, subject string) (*model.User, error) {
+	id, ok := f.links[federatedLinkKey(identityProviderID, subject)]
+	if !ok {
+		return nil, nil
+	}
+	return f.FindByID(ctx, id)
+}
+
+func (f *fakeFederatedUserStore) CreateWithExternalIdentity(_ context.Context, user *model.User, identityProviderID uuid.UUID, subject string) error {
+	user.ID = uuid.New()
+	copied := *user
+	f.users[user.ID] = &copied
+	f.links[federatedLinkKey(identityProviderID, subject)] = user.ID
+	return nil
+}
+
+func (f *fakeFederatedUserStore) LinkExternalIdentity(_ context.Context, userID, identityProviderID uuid.UUID, subject string) error {
+	f.links[federatedLinkKey(identityProviderID, subject)] = userID
+	return nil
+}
+
+type federationFixture struct {
+	handler  *FederationHandler
+	upstream *upstreamOP
+	idp      *model.IdentityProvider
+	users    *fakeFederatedUserStore
+	sessions *fakeSessionStore
+	states   *fakeFederationStateStore
+	existing *model.User
+}
+
+func newFederationFixture(t *testing.T) *federationFixture {
+	upstream := newUpstreamOP(t)
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo", SessionLifetime: 3600}
+	secret := "enc:upstream-secret"
+	idp := &model.IdentityProvider{
+		ID: uuid.New(), TenantID: tenant.ID, Code: "upstream", Name: "Upstream",
+		Issuer: upstream.URL, ClientID: "op-client", ClientSecretEncrypted: &secret,
+		Scopes: model.StringSlice{"openid", "email", "profile"}, JITProvisioning: true,
+		Status: "active", Tenant: *tenant,
+	}
+	existing := &model.User{ID: uuid.New(), TenantID: tenant.ID, LoginID: "bob", Email: "bob@example.com", EmailVerified: true, Status: "active", Tenant: *tenant}
+	idps := &fakeIdentityProviderFinder{idps: []*model.IdentityProvider{idp}}
+	f := &federationFixture{
+		upstream: upstream,
+		idp:      idp,
+		users:    &fakeFederatedUserStore{fakeUserStore: newFakeUserStore(existing), links: map[string]uuid.UUID{}},
+		sessions: newFakeSessionStore(),
+		states:   &fakeFederationStateStore{idps: idps},
+		existing: existing,
+	}
+	tenants := newFakeTenantFinder(tenant)
+	authSvc := NewAuthService(tenants, f.users.fakeUserStore, f.sessions, nil, nil, nil)
+	decrypt := func(encrypted string) (string, error) { return strings.TrimPrefix(encrypted, "enc:"), nil }
+	svc := NewFederationService(authSvc, tenants, idps, f.states, f.users, federation.NewClient(), fakeHashToken, decrypt, "https://op.example.com")
+	f.handler = NewFederationHandler(svc, "https://login.example.com", true)
+	return f
+}
+
+// start は /internal/federation/upstream/start を呼び、上流の認可エンドポイントの URL と state の Cookie を返す
+func (f *federationFixture) start(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/internal/federation/upstream/start?tenant_code=demo&redirect_after_login="+url.QueryEscape("/demo/authorize?client_id=rp"), nil)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("idp")
+	c.SetParamValues("upstream")
+	if err := f.handler.HandleStart(c); err != nil {
+		t.Fatal(err)
+	}
+	cookies := rec.Result().Cookies()
+	if rec.Code != http.StatusFound || len(cookies) != 1 || cookies[0].Name != federationStateCookie {
+		t.Fatalf("start: status = %d, cookies = %v", rec.Code, cookies)
+	}
+	return rec.Header().Get("Location"), cookies[0]
+}
+
+// authorizeUpstream は上流の認可エンドポイントにアクセスし、callback の URL を返す
+func (f *federationFixture) authorizeUpstream(t *testing.T, authURL string) *url.URL {
+	t.Helper()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	callback, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || callback.Path != "/internal/federation/upstream/callback" {
+		t.Fatalf("callback = %s", resp.Header.Get("Location"))
+	}
+	return callback
+}
+
+func (f *federationFixture) callback(t *testing.T, callback *url.URL, cookie *http.Cookie) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, callback.RequestURI(), nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("idp")
+	c.SetParamValues("upstream")
+	if err := f.handler.HandleCallback(c); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+// login は上流でのログインを最後まで行い、callback のレスポンスを返す
+func (f *federationFixture) login(t *testing.T) *httptest.ResponseRecorder {
+	t.Helper()
+	authURL, cookie := f.start(t)
+	return f.callback(t, f.authorizeUpstream(t, authURL), cookie)
+}
+
+// sessionUser はログインに成功したレスポンスのセッションのユーザーを返す
+func (f *federationFixture) sessionUser(t *testing.T, rec *httptest.ResponseRecorder) *model.User {
+	t.Helper()
+	if rec.Code != http.StatusFound || rec.Header().Get("Location") != "/demo/authorize?client_id=rp" {
+		t.Fatalf("status = %d, location = %s", rec.Code, rec.Header().Get("Location"))
+	}
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name != "op_session" {
+			continue
+		}
+		session := f.sessions.sessions[uuid.MustParse(cookie.Value)]
+		if session == nil || len(session.AMR) != 0 {
+			t.Fatalf("session = %+v", session)
+		}
+		return f.users.users[session.UserID]
+	}
+	t.Fatal("no session cookie")
+	return nil
+}
+
+// loginError はログイン画面に戻されたレスポンスのエラーコードを返す
+func loginError(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	if rec.Code != http.StatusFound || loc == nil || loc.Host != "login.example.com" || loc.Path != "/login" {
+		t.Fatalf("status = %d, location = %s", rec.Code, rec.Header().Get("Location"))
+	}
+	return loc.Query().Get("error")
+}
+
+func TestFederationStart(t *testing.T) {
+	f := newFederationFixture(t)
+	authURL, cookie := f.start(t)
+
+	q, _ := url.Parse(authURL)
+	params := q.Query()
+	if !strings.HasPrefix(authURL, f.upstream.URL+"/authorize?") || params.Get("state") != cookie.Value ||
+		params.Get("client_id") != "op-client" || params.Get("scope") != "openid email profile" ||
+		params.Get("redirect_uri") != "https://op.example.com/internal/federation/upstream/callback" ||
+		params.Get("code_challenge_method") != "S256" || params.Get("nonce") == "" {
+		t.Errorf("auth url = %s", authURL)
+	}
+	// state は平文で保存しない
+	if s := f.states.states[0]; s.StateHash != fakeHashToken(cookie.Value) || s.Nonce != params.Get("nonce") {
+		t.Errorf("state = %+v", s)
+	}
+	if !cookie.HttpOnly || !cookie.Secure || cookie.Path != "/internal/federation/" {
+		t.Errorf("cookie = %+v", cookie)
+	}
+}
+
+func TestFederationJITProvisioning(t *testing.T) {
+	f := newFederationFixture(t)
+	f.upstream.claims = map[string]interface{}{"sub": "upstream-alice", "email": "alice@example.com", "email_verified": true, "name": "Alice", "phone_number": "+819012345678"}
+
+	user := f.sessionUser(t, f.login(t))
+	if user.LoginID != "upstream|upstream-alice" || user.Email != "alice@example.com" || !user.EmailVerified || user.Name == nil || *user.Name != "Alice" {
+		t.Errorf("user = %+v", user)
+	}
+	// phone_number_verified がない電話番号は未確認とする
+	if user.PhoneNumber == nil || user.PhoneNumberVerified {
+		t.Errorf("phone = %v, verified = %v", user.PhoneNumber, user.PhoneNumberVerified)
+	}
+
+	// 2回目以降は紐付いたユーザーでログインする
+	f.upstream.claims["email"] = "alice@example.net"
+	if again := f.sessionUser(t, f.login(t)); again.ID != user.ID || len(f.users.users) != 2 {
+		t.Errorf("second login: user = %s, users = %d", again.ID, len(f.users.users))
+	}
+
+	// JIT 作成しない IdP では紐付いていない sub でログインできない
+	f.idp.JITProvisioning = false
+	f.upstream.claims = map[string]interface{}{"sub": "upstream-carol", "email": "carol@example.com", "email_verified": true}
+	if code := loginError(t, f.login(t)); code != "account_not_found" {
+		t.Errorf("jit disabled: error = %s", code)
+	}
+}
+
+func TestFederationAccountLinking(t *testing.T) {
+	tests := []struct {
+		name        string
+		linkByEmail bool
+		claims      map[string]interface{}
+		verified    bool
+		wantError   string
+	}{
+		{name: "双方で確認済みのメールアドレスで紐付ける", linkByEmail: true, claims: map[string]interface{}{"email": "Bob@example.com", "email_verified": true}, verified: true},
+		{name: "上流で未確認のアドレスでは紐付けない", linkByEmail: true, claims: map[string]interface{}{"email": "bob@example.com", "email_verified": false}, verified: true, wantError: "email_already_in_use"},
+		{name: "ローカルで未確認のアドレスには紐付けない", linkByEmail: true, claims: map[string]interface{}{"email": "bob@example.com", "email_verified": true}, verified: false, wantError: "email_already_in_use"},
+		{name: "紐付けが無効な IdP", linkByEmail: false, claims: map[string]interface{}{"email": "bob@example.com", "email_verified": true}, verified: true, wantError: "email_already_in_use"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFederationFixture(t)
+			f.idp.LinkByEmail = tt.linkByEmail
+			f.users.users[f.existing.ID].EmailVerified = tt.verified
+			f.upstream.claims = tt.claims
+			f.upstream.claims["sub"] = "upstream-bob"
+
+			rec := f.login(t)
+			if tt.wantError != "" {
+				if code := loginError(t, rec); code != tt.wantError || len(f.users.links) != 0 {
+					t.Errorf("error = %s, links = %v", code, f.users.links)
+				}
+				return
+			}
+			if user := f.sessionUser(t, rec); user.ID != f.existing.ID || f.users.links[federatedLinkKey(f.idp.ID, "upstream-bob")] != f.existing.ID {
+				t.Errorf("user = %s, links = %v", user.ID, f.users.links)
+			}
+		})
+	}
+}
+
+func TestFederationRejectsIDToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		override map[string]interface{}
+	}{
+		{name: "nonce が異なる", override: map[string]interface{}{"nonce": "replayed-nonce"}},
+		{name: "iss が異なる", override: map[string]interface{}{"iss": "https://evil.example.com"}},
+		{name: "aud が異なる", override: map[string]interface{}{"aud": "other-client"}},
+		{name: "期限切れ", override: map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newFederationFixture(t)
+			f.upstream.claims = map[string]interface{}{"sub": "upstream-alice", "email": "alice@example.com", "email_verified": true}
+			f.upstream.override = tt.override
+			if code := loginError(t, f.login(t)); code != "federation_failed" {
+				t.Errorf("error = %s", code)
+			}
+			if len(f.sessions.sessions) != 0 || len(f.users.users) != 1 {
+				t.Errorf("sessions = %d, users = %d", len(f.sessions.sessions), len(f.users.users))
+			}
+		})
+	}
+
+	t.Run("上流が認可を拒否した", func(t *testing.T) {
+		f := newFederationFixture(t)
+		authURL, cookie := f.start(t)
+		callback := f.authorizeUpstream(t, authURL)
+		callback.RawQuery = url.Values{"error": {"access_denied"}, "state": {callback.Query().Get("state")}}.Encode()
+		if code := loginError(t, f.callback(t, callback, cookie)); code != "federation_failed" {
+			t.Errorf("error = %s", code)
+		}
+	})
+}
+
+func TestFederationStateMismatch(t *testing.T) {
+	f := newFederationFixture(t)
+	f.upstream.claims = map[string]interface{}{"sub": "upstream-alice", "email": "alice@example.com"}
+
+	authURL, cookie := f.start(t)
+	callback := f.authorizeUpstream(t, authURL)
+	otherURL, otherCookie := f.start(t)
+	otherCallback := f.authorizeUpstream(t, otherURL)
+
+	// 別のブラウザで開始された認可レスポンス・Cookie のない callback は受け付けない
+	for name, cookie := range map[string]*http.Cookie{"別の state の Cookie": otherCookie, "Cookie なし": nil} {
+		if rec := f.callback(t, callback, cookie); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: status = %d", name, rec.Code)
+		}
+	}
+	// Cookie と一致しても発行していない state は受け付けない
+	forged := *callback
+	forged.RawQuery = url.Values{"code": {callback.Query().Get("code")}, "state": {"forged"}}.Encode()
+	if rec := f.callback(t, &forged, &http.Cookie{Name: federationStateCookie, Value: "forged"}); rec.Code != http.StatusBadRequest {
+		t.Errorf("forged state: status = %d", rec.Code)
+	}
+	// state は他の IdP の callback に使えない
+	wrongIdP := *otherCallback
+	wrongIdP.Path = "/internal/federation/other/callback"
+	req := httptest.NewRequest(http.MethodGet, wrongIdP.RequestURI(), nil)
+	req.AddCookie(otherCookie)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("idp")
+	c.SetParamValues("other")
+	if err := f.handler.HandleCallback(c); err != nil || rec.Code != http.StatusBadRequest {
+		t.Errorf("other idp: status = %d, err = %v", rec.Code, err)
+	}
+
+	if rec := f.callback(t, callback, cookie); rec.Code != http.StatusFound || len(f.sessions.sessions) != 1 {
+		t.Fatalf("status = %d, location = %s", rec.Code, rec.Header().Get("Location"))
+	}
+	// state は1回のみ使える
+	if rec := f.callback(t, callback, cookie); rec.Code != http.StatusBadRequest {
+		t.Errorf("replay: status = %d", rec.Code)
+	}
+}
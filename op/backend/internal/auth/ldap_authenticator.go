@@ -0,0 +1,354 @@
+package auth
+
+import (
+	"context"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// maxLDAPSubjectLength は紐付けに使う id_attribute の値の長さの上限 (ldap_credentials.subject のカラム長)
+const maxLDAPSubjectLength = 255
+
+// LDAPAuthenticator はテナントの LDAP / Active Directory でパスワードを検証する。
+// 認証したエントリに紐付くユーザーがいなければ既存ユーザーへの紐付けまたは JIT 作成を行い、
+// ログインのたびにマッピングした属性とグループのメンバーをディレクトリの値で更新する。
+type LDAPAuthenticator struct {
+	directoryFinder LDAPDirectoryFinder
+	userStore       LDAPUserStore
+	groupStore      LDAPGroupStore
+	client          LDAPClient
+	decryptSecret   DecryptSecretFunc
+}
+
+func NewLDAPAuthenticator(
+	directoryFinder LDAPDirectoryFinder,
+	userStore LDAPUserStore,
+	groupStore LDAPGroupStore,
+	client LDAPClient,
+	decryptSecret DecryptSecretFunc,
+) *LDAPAuthenticator {
+	return &LDAPAuthenticator{
+		directoryFinder: directoryFinder,
+		userStore:       userStore,
+		groupStore:      groupStore,
+		client:          client,
+		decryptSecret:   decryptSecret,
+	}
+}
+
+// Authenticate はディレクトリで login_id のエントリを検索し、そのエントリでパスワードを検証する。
+// テナントにディレクトリがない場合、ディレクトリにエントリがない場合、
+// ディレクトリに紐付いていない同じ login_id のユーザーがいて紐付けを許可していない場合は (nil, nil) を返す。
+func (a *LDAPAuthenticator) Authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error) {
+	directory, err := a.directoryFinder.FindActiveByTenantID(ctx, tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ldap directory: %w", err)
+	}
+	if directory == nil {
+		return nil, nil
+	}
+
+	local, err := a.userStore.FindByTenantAndLoginID(ctx, tenant.ID, loginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if local != nil && !hasCredential(local.Credentials, model.CredentialTypeLDAP) && !directory.LinkByLoginID {
+		return nil, nil
+	}
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	req, err := a.searchRequest(directory, loginID)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok, err := a.client.Authenticate(ctx, req, password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectoryUnavailable, err)
+	}
+	if entry == nil {
+		return nil, nil
+	}
+	if !ok {
+		return nil, ErrInvalidCredentials
+	}
+
+	subject := entrySubject(entry, directory.IDAttribute)
+	if subject == "" {
+		return nil, fmt.Errorf("%w: %s has no usable %s", ErrDirectoryEntryIncomplete, entry.DN, directory.IDAttribute)
+	}
+	attrs := directoryAttributes(directory, entry)
+
+	user, err := a.resolveUser(ctx, directory, local, subject, entry.DN, attrs)
+	if err != nil {
+		return nil, err
+	}
+
+	if directory.GroupSyncEnabled {
+		if err := a.groupStore.SyncLDAPMemberships(ctx, directory.TenantID, directory.ID, user.ID, entryGroups(entry, directory.GroupAttribute)); err != nil {
+			return nil, fmt.Errorf("failed to sync ldap groups: %w", err)
+		}
+	}
+	return user, nil
+}
+
+// resolveUser はエントリに紐付くユーザーを返す。紐付くユーザーがいなければ、
+// login_id が一致する既存ユーザーへの紐付け (link_by_login_id)、ユーザーの作成 (jit_provisioning) の順に試す。
+func (a *LDAPAuthenticator) resolveUser(ctx context.Context, directory *model.LDAPDirectory, local *model.User, subject, dn string, attrs map[string]string) (*model.User, error) {
+	user, err := a.userStore.FindByLDAPIdentity(ctx, directory.ID, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find ldap user: %w", err)
+	}
+	if user != nil {
+		if err := a.userStore.UpdateLDAPIdentityDN(ctx, directory.ID, subject, dn); err != nil {
+			return nil, fmt.Errorf("failed to update ldap identity: %w", err)
+		}
+		return user, a.syncAttributes(ctx, user, attrs)
+	}
+
+	if local != nil {
+		// ディレクトリで login_id が別のエントリに移った場合は、元のエントリに紐付いたユーザーを引き継がない
+		if hasCredential(local.Credentials, model.CredentialTypeLDAP) {
+			return nil, ErrLoginIDAlreadyInUse
+		}
+		if err := a.userStore.LinkLDAPIdentity(ctx, local.ID, directory.ID, subject, dn); err != nil {
+			return nil, fmt.Errorf("failed to link ldap user: %w", err)
+		}
+		return local, a.syncAttributes(ctx, local, attrs)
+	}
+
+	if !directory.JITProvisioning {
+		return nil, ErrInvalidCredentials
+	}
+	return a.provisionUser(ctx, directory, subject, dn, attrs)
+}
+
+// provisionUser はエントリの属性からユーザーを作成し、エントリに紐付ける (JIT プロビジョニング)。
+// ディレクトリのメールアドレスは組織が管理しているため確認済みとする
+func (a *LDAPAuthenticator) provisionUser(ctx context.Context, directory *model.LDAPDirectory, subject, dn string, attrs map[string]string) (*model.User, error) {
+	email, loginID := attrs["email"], attrs[model.FederatedLoginIDAttribute]
+	if email == "" || loginID == "" {
+		return nil, fmt.Errorf("%w: %s has no email or login_id", ErrDirectoryEntryIncomplete, dn)
+	}
+	other, err := a.userStore.FindByTenantAndEmail(ctx, directory.TenantID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by email: %w", err)
+	}
+	if other != nil {
+		return nil, ErrEmailAlreadyInUse
+	}
+	other, err = a.userStore.FindByTenantAndLoginID(ctx, directory.TenantID, loginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by login id: %w", err)
+	}
+	if other != nil {
+		return nil, ErrLoginIDAlreadyInUse
+	}
+
+	user := &model.User{
+		TenantID:      directory.TenantID,
+		LoginID:       loginID,
+		Email:         email,
+		EmailVerified: true,
+		Status:        "active",
+	}
+	for attr, target := range profileAttributes(user) {
+		if v, ok := attrs[attr]; ok {
+			*target = &v
+		}
+	}
+
+	if err := a.userStore.CreateWithLDAPIdentity(ctx, user, directory.ID, subject, dn); err != nil {
+		return nil, fmt.Errorf("failed to provision ldap user: %w", err)
+	}
+	return user, nil
+}
+
+// syncAttributes はマッピングした属性をディレクトリの値で更新する。ディレクトリに値がない属性は変更しない。
+// login_id・メールアドレスが他のユーザーと重複する場合は変更せず、電話番号が変わった場合は未確認に戻して SMS の2要素認証を無効にする
+func (a *LDAPAuthenticator) syncAttributes(ctx context.Context, user *model.User, attrs map[string]string) error {
+	changed := false
+
+	if loginID, ok := attrs[model.FederatedLoginIDAttribute]; ok && loginID != user.LoginID {
+		other, err := a.userStore.FindByTenantAndLoginID(ctx, user.TenantID, loginID)
+		if err != nil {
+			return fmt.Errorf("failed to find user by login id: %w", err)
+		}
+		if other == nil {
+			user.LoginID = loginID
+			changed = true
+		}
+	}
+
+	if email, ok := attrs["email"]; ok && (email != user.Email || !user.EmailVerified) {
+		other, err := a.userStore.FindByTenantAndEmail(ctx, user.TenantID, email)
+		if err != nil {
+			return fmt.Errorf("failed to find user by email: %w", err)
+		}
+		if other == nil || other.ID == user.ID {
+			user.Email = email
+			user.EmailVerified = true
+			changed = true
+		}
+	}
+
+	for attr, target := range profileAttributes(user) {
+		v, ok := attrs[attr]
+		if !ok || (*target != nil && **target == v) {
+			continue
+		}
+		*target = &v
+		changed = true
+		if attr == "phone_number" {
+			user.PhoneNumberVerified = false
+			user.SMSMFAEnabled = false
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	if err := a.userStore.UpdateProfile(ctx, user); err != nil {
+		return fmt.Errorf("failed to sync ldap user attributes: %w", err)
+	}
+	return nil
+}
+
+// searchRequest はディレクトリの設定からユーザーの検索条件を作る。属性はマッピング・紐付け・グループに使うものだけを要求する
+func (a *LDAPAuthenticator) searchRequest(directory *model.LDAPDirectory, loginID string) (*model.LDAPSearchRequest, error) {
+	req := &model.LDAPSearchRequest{
+		Host:       directory.Host,
+		Port:       directory.Port,
+		Security:   directory.Security,
+		SearchBase: directory.UserSearchBase,
+		Filter:     directory.UserSearchFilter,
+		LoginID:    loginID,
+		Attributes: []string{directory.IDAttribute},
+	}
+	if directory.CACertificate != nil {
+		req.CACertificate = *directory.CACertificate
+	}
+	if directory.BindDN != nil {
+		req.BindDN = *directory.BindDN
+	}
+	if directory.BindPasswordEncrypted != nil {
+		password, err := a.decryptSecret(*directory.BindPasswordEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt bind password: %w", err)
+		}
+		req.BindPassword = password
+	}
+	for _, attr := range directory.AttributeMappings {
+		req.Attributes = append(req.Attributes, attr)
+	}
+	if directory.GroupSyncEnabled {
+		req.Attributes = append(req.Attributes, directory.GroupAttribute)
+	}
+	return req, nil
+}
+
+// entrySubject は紐付けに使う id_attribute の値を返す。
+// AD の objectGUID のようなバイナリ値は 16 進数の文字列にする。値がないか長すぎる場合は空文字を返す
+func entrySubject(entry *model.LDAPEntry, attr string) string {
+	v := entry.First(attr)
+	if v == "" {
+		return ""
+	}
+	if !isPrintable(v) {
+		v = hex.EncodeToString([]byte(v))
+	}
+	if len(v) > maxLDAPSubjectLength {
+		return ""
+	}
+	return v
+}
+
+// directoryAttributes はディレクトリの属性マッピングに従い、エントリからユーザー属性の値を取り出す。
+// UTF-8 でない値、長すぎる値、形式が正しくないメールアドレス・電話番号は使わない。
+func directoryAttributes(directory *model.LDAPDirectory, entry *model.LDAPEntry) map[string]string {
+	attrs := map[string]string{}
+	for _, attr := range model.FederatedUserAttributes {
+		name := directory.AttributeMappings[attr]
+		if name == "" {
+			continue
+		}
+		v := strings.TrimSpace(entry.First(name))
+		if v == "" || !isPrintable(v) || len(v) > federatedAttributeMaxLength[attr] {
+			continue
+		}
+		if (attr == "email" && !isValidEmail(v)) || (attr == "phone_number" && !phoneNumberRegex.MatchString(v)) {
+			continue
+		}
+		attrs[attr] = v
+	}
+	return attrs
+}
+
+// entryGroups はエントリの group_attribute の値 (グループの DN) からグループを返す。
+// 表示名は DN の先頭の RDN の値 (CN=Sales,OU=Groups,... の Sales) とし、DN が長すぎるグループは同期しない
+func entryGroups(entry *model.LDAPEntry, attr string) []model.LDAPGroup {
+	seen := map[string]bool{}
+	groups := []model.LDAPGroup{}
+	for _, dn := range entry.Values(attr) {
+		key := strings.ToLower(dn)
+		if dn == "" || len(dn) > 255 || !isPrintable(dn) || seen[key] {
+			continue
+		}
+		seen[key] = true
+		name := firstRDNValue(dn)
+		if name == "" || !isPrintable(name) {
+			name = dn
+		}
+		groups = append(groups, model.LDAPGroup{DN: dn, Name: name})
+	}
+	return groups
+}
+
+// firstRDNValue は DN の文字列表現 (RFC 4514) の先頭の RDN の値を、エスケープを戻して返す。
+// 複数の属性からなる RDN (+ で連結) は最初の属性の値を使う
+func firstRDNValue(dn string) string {
+	eq := strings.IndexByte(dn, '=')
+	if eq < 0 {
+		return ""
+	}
+	var b strings.Builder
+	for i := eq + 1; i < len(dn); i++ {
+		c := dn[i]
+		switch {
+		case c == ',' || c == '+':
+			return strings.TrimSpace(b.String())
+		case c == '\\' && i+1 < len(dn):
+			if i+2 < len(dn) {
+				if v, err := hex.DecodeString(dn[i+1 : i+3]); err == nil {
+					b.Write(v)
+					i += 2
+					continue
+				}
+			}
+			b.WriteByte(dn[i+1])
+			i++
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// isPrintable は UTF-8 の文字列で制御文字を含まないか判定する。
+func isPrintable(s string) bool {
+	if !utf8.ValidString(s) {
+		return false
+	}
+	for _, r := range s {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
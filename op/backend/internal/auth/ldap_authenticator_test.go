@@ -0,0 +1,353 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeLDAPDirectoryFinder struct {
+	directory *model.LDAPDirectory
+}
+
+func (f *fakeLDAPDirectoryFinder) FindActiveByTenantID(_ context.Context, tenantID uuid.UUID) (*model.LDAPDirectory, error) {
+	if f.directory == nil || f.directory.TenantID != tenantID {
+		return nil, nil
+	}
+	copied := *f.directory
+	return &copied, nil
+}
+
+// fakeLDAPUserStore はエントリとユーザーの紐付け (ldap_credentials) をメモリに持つ
+type fakeLDAPUserStore struct {
+	*fakeUserStore
+	links map[string]uuid.UUID
+	dns   map[string]string
+}
+
+func ldapLinkKey(directoryID uuid.UUID, subject string) string {
+	return directoryID.String() + "|" + subject
+}
+
+func (f *fakeLDAPUserStore) FindByLDAPIdentity(ctx context.Context, directoryID uuid.UUID, subject string) (*model.User, error) {
+	id, ok := f.links[ldapLinkKey(directoryID, subject)]
+	if !ok {
+		return nil, nil
+	}
+	return f.FindByID(ctx, id)
+}
+
+func (f *fakeLDAPUserStore) CreateWithLDAPIdentity(ctx context.Context, user *model.User, directoryID uuid.UUID, subject, dn string) error {
+	user.ID = uuid.New()
+	copied := *user
+	f.users[user.ID] = &copied
+	return f.LinkLDAPIdentity(ctx, user.ID, directoryID, subject, dn)
+}
+
+func (f *fakeLDAPUserStore) LinkLDAPIdentity(_ context.Context, userID, directoryID uuid.UUID, subject, dn string) error {
+	u := f.users[userID]
+	u.Credentials = append(u.Credentials, model.Credential{UserID: userID, Type: model.CredentialTypeLDAP})
+	f.links[ldapLinkKey(directoryID, subject)] = userID
+	f.dns[ldapLinkKey(directoryID, subject)] = dn
+	return nil
+}
+
+func (f *fakeLDAPUserStore) UpdateLDAPIdentityDN(_ context.Context, directoryID uuid.UUID, subject, dn string) error {
+	f.dns[ldapLinkKey(directoryID, subject)] = dn
+	return nil
+}
+
+func (f *fakeLDAPUserStore) UpdateProfile(_ context.Context, user *model.User) error {
+	copied := *user
+	f.users[user.ID] = &copied
+	return nil
+}
+
+type fakeLDAPGroupStore struct {
+	groups map[uuid.UUID][]model.LDAPGroup
+}
+
+func (f *fakeLDAPGroupStore) SyncLDAPMemberships(_ context.Context, _, _, userID uuid.UUID, groups []model.LDAPGroup) error {
+	f.groups[userID] = groups
+	return nil
+}
+
+// fakeLDAPClient は login_id ごとのエントリとパスワードを持つディレクトリ
+type fakeLDAPClient struct {
+	entries   map[string]*model.LDAPEntry
+	passwords map[string]string
+	err       error
+	requests  []*model.LDAPSearchRequest
+}
+
+func (f *fakeLDAPClient) Authenticate(_ context.Context, req *model.LDAPSearchRequest, password string) (*model.LDAPEntry, bool, error) {
+	f.requests = append(f.requests, req)
+	if f.err != nil {
+		return nil, false, f.err
+	}
+	entry := f.entries[req.LoginID]
+	if entry == nil {
+		return nil, false, nil
+	}
+	return entry, f.passwords[req.LoginID] == password, nil
+}
+
+type ldapFixture struct {
+	authenticator *LDAPAuthenticator
+	tenant        *model.Tenant
+	directory     *model.LDAPDirectory
+	users         *fakeLDAPUserStore
+	groups        *fakeLDAPGroupStore
+	client        *fakeLDAPClient
+}
+
+func newLDAPFixture() *ldapFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	bindDN, bindPassword := "CN=svc,DC=example,DC=com", "enc:svc-secret"
+	directory := &model.LDAPDirectory{
+		ID: uuid.New(), TenantID: tenant.ID, Host: "ldap.example.com", Port: 636, Security: model.LDAPSecurityLDAPS,
+		BindDN: &bindDN, BindPasswordEncrypted: &bindPassword,
+		UserSearchBase: "DC=example,DC=com", UserSearchFilter: "(sAMAccountName={username})",
+		IDAttribute: "objectGUID", AttributeMappings: model.DefaultLDAPAttributeMappings(),
+		JITProvisioning: true, GroupSyncEnabled: true, GroupAttribute: "memberOf", Status: "active",
+	}
+	f := &ldapFixture{
+		tenant:    tenant,
+		directory: directory,
+		users:     &fakeLDAPUserStore{fakeUserStore: newFakeUserStore(), links: map[string]uuid.UUID{}, dns: map[string]string{}},
+		groups:    &fakeLDAPGroupStore{groups: map[uuid.UUID][]model.LDAPGroup{}},
+		client: &fakeLDAPClient{
+			entries: map[string]*model.LDAPEntry{
+				"alice": {DN: "CN=Alice,OU=Users,DC=example,DC=com", Attributes: map[string][]string{
+					"objectguid":      {"\x01\x02\x03\x04"},
+					"samaccountname":  {"alice"},
+					"mail":            {"alice@example.com"},
+					"displayname":     {"Alice"},
+					"telephonenumber": {"+81300000000"},
+					"memberof":        {"CN=Sales,OU=Groups,DC=example,DC=com", "cn=sales,ou=groups,dc=example,dc=com", "CN=R\\2cD,OU=Groups,DC=example,DC=com"},
+				}},
+			},
+			passwords: map[string]string{"alice": "alice-password"},
+		},
+	}
+	decrypt := func(encrypted string) (string, error) { return strings.TrimPrefix(encrypted, "enc:"), nil }
+	f.authenticator = NewLDAPAuthenticator(&fakeLDAPDirectoryFinder{directory: directory}, f.users, f.groups, f.client, decrypt)
+	return f
+}
+
+func (f *ldapFixture) authenticate(loginID, password string) (*model.User, error) {
+	return f.authenticator.Authenticate(context.Background(), f.tenant, loginID, password)
+}
+
+func TestLDAPAuthenticatorJITProvisioning(t *testing.T) {
+	f := newLDAPFixture()
+	user, err := f.authenticate("alice", "alice-password")
+	if err != nil || user == nil {
+		t.Fatalf("user = %+v, err = %v", user, err)
+	}
+	if user.LoginID != "alice" || user.Email != "alice@example.com" || !user.EmailVerified || user.Name == nil || *user.Name != "Alice" {
+		t.Errorf("user = %+v", user)
+	}
+	// バイナリの objectGUID は 16 進数で紐付ける
+	if f.users.links[ldapLinkKey(f.directory.ID, "01020304")] != user.ID {
+		t.Errorf("links = %v", f.users.links)
+	}
+	want := []model.LDAPGroup{
+		{DN: "CN=Sales,OU=Groups,DC=example,DC=com", Name: "Sales"},
+		{DN: "CN=R\\2cD,OU=Groups,DC=example,DC=com", Name: "R,D"},
+	}
+	if got := f.groups.groups[user.ID]; !reflect.DeepEqual(got, want) {
+		t.Errorf("groups = %+v", got)
+	}
+
+	// サービスアカウントのパスワードは復号し、login_id はエスケープせずに渡す (エスケープは LDAPClient が行う)
+	req := f.client.requests[0]
+	if req.BindPassword != "svc-secret" || req.LoginID != "alice" || req.Filter != f.directory.UserSearchFilter {
+		t.Errorf("request = %+v", req)
+	}
+	for _, attr := range []string{"objectGUID", "mail", "sAMAccountName", "memberOf"} {
+		if !contains(req.Attributes, attr) {
+			t.Errorf("attributes = %v", req.Attributes)
+		}
+	}
+
+	// 2 回目以降は紐付けたユーザーを返す
+	again, err := f.authenticate("alice", "alice-password")
+	if err != nil || again == nil || again.ID != user.ID || len(f.users.users) != 1 {
+		t.Errorf("user = %+v, err = %v", again, err)
+	}
+}
+
+func TestLDAPAuthenticatorRejects(t *testing.T) {
+	tests := []struct {
+		name    string
+		setup   func(f *ldapFixture)
+		loginID string
+		wantErr error
+	}{
+		{name: "パスワード誤り", loginID: "alice", setup: func(f *ldapFixture) { f.client.passwords["alice"] = "other" }, wantErr: ErrInvalidCredentials},
+		{name: "ディレクトリに接続できない", loginID: "alice", setup: func(f *ldapFixture) { f.client.err = errors.New("connection refused") }, wantErr: ErrDirectoryUnavailable},
+		{name: "JIT 作成が無効", loginID: "alice", setup: func(f *ldapFixture) { f.directory.JITProvisioning = false }, wantErr: ErrInvalidCredentials},
+		{
+			name: "id_attribute がない", loginID: "alice",
+			setup:   func(f *ldapFixture) { delete(f.client.entries["alice"].Attributes, "objectguid") },
+			wantErr: ErrDirectoryEntryIncomplete,
+		},
+		{
+			name: "JIT 作成にメールアドレスがない", loginID: "alice",
+			setup:   func(f *ldapFixture) { f.client.entries["alice"].Attributes["mail"] = []string{"not an email"} },
+			wantErr: ErrDirectoryEntryIncomplete,
+		},
+		{
+			name: "メールアドレスが他のユーザーと重複", loginID: "alice",
+			setup: func(f *ldapFixture) {
+				_ = f.users.UpdateProfile(context.Background(), &model.User{ID: uuid.New(), TenantID: f.tenant.ID, LoginID: "other", Email: "ALICE@example.com"})
+			},
+			wantErr: ErrEmailAlreadyInUse,
+		},
+		{
+			name: "login_id が別のエントリに紐付いている", loginID: "alice",
+			setup: func(f *ldapFixture) {
+				_ = f.users.CreateWithLDAPIdentity(context.Background(), &model.User{TenantID: f.tenant.ID, LoginID: "alice"}, f.directory.ID, "old-guid", "CN=Old")
+			},
+			wantErr: ErrLoginIDAlreadyInUse,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newLDAPFixture()
+			tt.setup(f)
+			if user, err := f.authenticate(tt.loginID, "alice-password"); !errors.Is(err, tt.wantErr) || user != nil {
+				t.Errorf("user = %+v, err = %v", user, err)
+			}
+		})
+	}
+}
+
+func TestLDAPAuthenticatorFallsThrough(t *testing.T) {
+	// (nil, nil) はローカルのパスワードで検証させる
+	f := newLDAPFixture()
+	if user, err := f.authenticate("carol", "carol-password"); user != nil || err != nil {
+		t.Errorf("unknown entry: user = %+v, err = %v", user, err)
+	}
+
+	local := &model.User{ID: uuid.New(), TenantID: f.tenant.ID, LoginID: "alice", Email: "alice@example.com"}
+	f.users.users[local.ID] = local
+	f.client.requests = nil
+	if user, err := f.authenticate("alice", "alice-password"); user != nil || err != nil || len(f.client.requests) != 0 {
+		t.Errorf("unlinked local user: user = %+v, err = %v, requests = %d", user, err, len(f.client.requests))
+	}
+
+	if user, err := NewLDAPAuthenticator(&fakeLDAPDirectoryFinder{}, f.users, f.groups, f.client, nil).Authenticate(context.Background(), f.tenant, "alice", "alice-password"); user != nil || err != nil {
+		t.Errorf("no directory: user = %+v, err = %v", user, err)
+	}
+}
+
+func TestLDAPAuthenticatorEmptyPassword(t *testing.T) {
+	f := newLDAPFixture()
+	if user, err := f.authenticate("alice", ""); !errors.Is(err, ErrInvalidCredentials) || user != nil {
+		t.Errorf("user = %+v, err = %v", user, err)
+	}
+	if len(f.client.requests) != 0 {
+		t.Errorf("requests = %d", len(f.client.requests))
+	}
+}
+
+func TestLDAPAuthenticatorLinksByLoginID(t *testing.T) {
+	f := newLDAPFixture()
+	f.directory.LinkByLoginID = true
+	name := "Old Name"
+	local := &model.User{ID: uuid.New(), TenantID: f.tenant.ID, LoginID: "alice", Email: "old@example.com", Name: &name}
+	f.users.users[local.ID] = local
+
+	user, err := f.authenticate("alice", "alice-password")
+	if err != nil || user == nil || user.ID != local.ID {
+		t.Fatalf("user = %+v, err = %v", user, err)
+	}
+	saved := f.users.users[local.ID]
+	if saved.Email != "alice@example.com" || !saved.EmailVerified || *saved.Name != "Alice" || len(f.users.users) != 1 {
+		t.Errorf("saved = %+v", saved)
+	}
+}
+
+func TestLDAPAuthenticatorSyncsAttributes(t *testing.T) {
+	f := newLDAPFixture()
+	user, err := f.authenticate("alice", "alice-password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stored := f.users.users[user.ID]
+	stored.PhoneNumberVerified, stored.SMSMFAEnabled = true, true
+	other := &model.User{ID: uuid.New(), TenantID: f.tenant.ID, LoginID: "taken", Email: "taken@example.com"}
+	f.users.users[other.ID] = other
+
+	// エントリの移動 (DN の変更) と属性の変更
+	entry := f.client.entries["alice"]
+	entry.DN = "CN=Alice,OU=Moved,DC=example,DC=com"
+	entry.Attributes["telephonenumber"] = []string{"+81311111111"}
+	entry.Attributes["mail"] = []string{"taken@example.com"}
+	entry.Attributes["samaccountname"] = []string{"taken"}
+	entry.Attributes["memberof"] = nil
+
+	if _, err := f.authenticate("alice", "alice-password"); err != nil {
+		t.Fatal(err)
+	}
+	saved := f.users.users[user.ID]
+	if *saved.PhoneNumber != "+81311111111" || saved.PhoneNumberVerified || saved.SMSMFAEnabled {
+		t.Errorf("phone: %+v", saved)
+	}
+	// 他のユーザーと重複する login_id・メールアドレスは変えない
+	if saved.LoginID != "alice" || saved.Email != "alice@example.com" {
+		t.Errorf("login_id = %s, email = %s", saved.LoginID, saved.Email)
+	}
+	if f.users.dns[ldapLinkKey(f.directory.ID, "01020304")] != entry.DN {
+		t.Errorf("dn = %s", f.users.dns[ldapLinkKey(f.directory.ID, "01020304")])
+	}
+	if groups := f.groups.groups[user.ID]; len(groups) != 0 {
+		t.Errorf("groups = %+v", groups)
+	}
+}
+
+func TestFirstRDNValue(t *testing.T) {
+	for dn, want := range map[string]string{
+		"CN=Sales,OU=Groups,DC=example,DC=com": "Sales",
+		"cn = Sales Team ,dc=example":          "Sales Team",
+		`CN=Smith\, John,OU=Users`:             "Smith, John",
+		`CN=R\2cD,OU=Groups`:                   "R,D",
+		`CN=\e6\97\a5\e6\9c\ac,OU=Groups`:      "日本",
+		"CN=a+OU=b,DC=example":                 "a",
+		"no-equals":                            "",
+	} {
+		if got := firstRDNValue(dn); got != want {
+			t.Errorf("firstRDNValue(%q) = %q, want %q", dn, got, want)
+		}
+	}
+}
+
+func TestEntrySubject(t *testing.T) {
+	entry := &model.LDAPEntry{Attributes: map[string][]string{
+		"uid":        {"alice"},
+		"objectguid": {"\x00\xffA"},
+		"long":       {strings.Repeat("a", maxLDAPSubjectLength+1)},
+	}}
+	for attr, want := range map[string]string{"uid": "alice", "objectGUID": "00ff41", "long": "", "missing": ""} {
+		if got := entrySubject(entry, attr); got != want {
+			t.Errorf("%s: %q, want %q", attr, got, want)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -3,9 +3,11 @@ package auth
 import (
 	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
 
@@ -48,20 +50,92 @@ func (h *LoginHandler) Handle(c echo.Context) error {
 		if errors.Is(err, ErrInvalidCredentials) {
 			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_credentials"})
 		}
+		if errors.Is(err, ErrEmailNotVerified) {
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "email_not_verified"})
+		}
+		if errors.Is(err, ErrSMSThrottled) {
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_requests"})
+		}
+		// LDAP ディレクトリ・移行元のユーザーの作成の失敗
+		if errors.Is(err, ErrEmailAlreadyInUse) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "email_already_in_use"})
+		}
+		if errors.Is(err, ErrLoginIDAlreadyInUse) {
+			return c.JSON(http.StatusConflict, map[string]string{"error": "login_id_already_in_use"})
+		}
+		if errors.Is(err, ErrDirectoryEntryIncomplete) {
+			c.Logger().Warnf("login error: %v", err)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "account_not_provisioned"})
+		}
+		if errors.Is(err, ErrDirectoryUnavailable) {
+			c.Logger().Errorf("login error: %v", err)
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "directory_unavailable"})
+		}
+		if errors.Is(err, ErrMigratedUserIncomplete) {
+			c.Logger().Warnf("login error: %v", err)
+			return c.JSON(http.StatusForbidden, map[string]string{"error": "account_not_migrated"})
+		}
+		if errors.Is(err, ErrUserMigrationUnavailable) {
+			c.Logger().Errorf("login error: %v", err)
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "migration_unavailable"})
+		}
+		// パスワードのハッシュの計算が混み合っている
+		if errors.Is(err, crypto.ErrPasswordHasherBusy) {
+			c.Logger().Warnf("login error: %v", err)
+			return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "temporarily_unavailable"})
+		}
 		c.Logger().Errorf("login error: %v", err)
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
 	}
 
-	// セッションクッキーを設定
-	cookie := &http.Cookie{
-		Name:     "op_session",
-		Value:    output.SessionID.String(),
-		Path:     "/",
-		HttpOnly: true,
-		Secure:   h.isSecure,
-		SameSite: http.SameSiteLaxMode,
+	// 2要素目が必要な場合はセッションを作成せず、SMS で送ったコードの入力を求める
+	if output.MFAToken != "" {
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"mfa_required":      true,
+			"mfa_method":        "sms",
+			"mfa_token":         output.MFAToken,
+			"phone_number_hint": maskPhoneNumber(*output.User.PhoneNumber),
+		})
+	}
+
+	return h.respondSession(c, output)
+}
+
+type smsLoginRequest struct {
+	MFAToken string `json:"mfa_token"`
+	Code     string `json:"code"`
+}
+
+// HandleSMS は POST /internal/login/sms を処理する。
+// POST /internal/login が返した mfa_token と SMS で送ったコードでログインを完了させる。
+func (h *LoginHandler) HandleSMS(c echo.Context) error {
+	var req smsLoginRequest
+	if err := c.Bind(&req); err != nil || req.MFAToken == "" || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "mfa_token and code are required"})
 	}
-	c.SetCookie(cookie)
+
+	output, err := h.authSvc.CompleteSMSLogin(c.Request().Context(), &model.SMSLoginInput{
+		MFAToken:  req.MFAToken,
+		Code:      req.Code,
+		IPAddress: c.RealIP(),
+		UserAgent: c.Request().UserAgent(),
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidSMSCode), errors.Is(err, ErrInvalidCredentials):
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_code"})
+		case errors.Is(err, ErrSMSCodeAttemptsExceeded):
+			return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_attempts"})
+		}
+		c.Logger().Errorf("sms login error: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	return h.respondSession(c, output)
+}
+
+func (h *LoginHandler) respondSession(c echo.Context, output *model.LoginOutput) error {
+	setSessionCookie(c, output, h.isSecure)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"session_id": output.SessionID.String(),
@@ -72,3 +146,23 @@ func (h *LoginHandler) Handle(c echo.Context) error {
 		},
 	})
 }
+
+// setSessionCookie はセッションクッキーを設定する
+func setSessionCookie(c echo.Context, output *model.LoginOutput, isSecure bool) {
+	c.SetCookie(&http.Cookie{
+		Name:     "op_session",
+		Value:    output.SessionID.String(),
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   isSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+// maskPhoneNumber はコードの送信先を示すため、電話番号の末尾 4 桁以外を伏せる。
+func maskPhoneNumber(phoneNumber string) string {
+	if len(phoneNumber) <= 4 {
+		return strings.Repeat("*", len(phoneNumber))
+	}
+	return strings.Repeat("*", len(phoneNumber)-4) + phoneNumber[len(phoneNumber)-4:]
+}
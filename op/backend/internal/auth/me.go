@@ -51,6 +51,11 @@ func (h *MeHandler) Handle(c echo.Context) error {
 			"id":    user.ID.String(),
 			"name":  user.Name,
 			"email": user.Email,
+			// メールアドレス確認画面の表示に使う
+			"email_verified": user.EmailVerified,
+			// 電話番号確認・SMS による2要素認証の設定画面の表示に使う
+			"phone_number_verified": user.PhoneNumberVerified,
+			"sms_mfa_enabled":       user.SMSMFAEnabled,
 		},
 	})
 }
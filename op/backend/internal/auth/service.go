@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -14,20 +15,27 @@ type AuthService struct {
 	tenantFinder   TenantFinder
 	userFinder     UserFinder
 	sessionStore   SessionStore
-	verifyPassword PasswordVerifyFunc
+	authenticators []Authenticator
+	emailVerifier  *EmailVerificationService
+	smsOTP         *SMSOTPService
 }
 
+// NewAuthService は AuthService を生成する。authenticators はパスワードを検証する認証ソースで、この順に試す
 func NewAuthService(
 	tenantFinder TenantFinder,
 	userFinder UserFinder,
 	sessionStore SessionStore,
-	verifyPassword PasswordVerifyFunc,
+	authenticators []Authenticator,
+	emailVerifier *EmailVerificationService,
+	smsOTP *SMSOTPService,
 ) *AuthService {
 	return &AuthService{
 		tenantFinder:   tenantFinder,
 		userFinder:     userFinder,
 		sessionStore:   sessionStore,
-		verifyPassword: verifyPassword,
+		authenticators: authenticators,
+		emailVerifier:  emailVerifier,
+		smsOTP:         smsOTP,
 	}
 }
 
@@ -41,39 +49,105 @@ func (s *AuthService) Login(ctx context.Context, input *model.LoginInput) (*mode
 		return nil, ErrInvalidCredentials
 	}
 
-	// ユーザー検索 (Credentials preload 済み)
-	user, err := s.userFinder.FindByTenantAndLoginID(ctx, tenant.ID, input.LoginID)
+	// 認証ソースでパスワードを検証する
+	user, err := s.authenticate(ctx, tenant, input.LoginID, input.Password)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find user: %w", err)
+		return nil, err
 	}
-	if user == nil {
+	if user.Status != "active" {
 		return nil, ErrInvalidCredentials
 	}
 
-	if user.Status != "active" {
-		return nil, ErrInvalidCredentials
+	if err := s.requireVerifiedEmail(ctx, tenant, user); err != nil {
+		return nil, err
 	}
 
-	// パスワード検証
-	passwordHash := findPasswordHash(user.Credentials)
-	if passwordHash == "" {
-		return nil, ErrInvalidCredentials
+	// SMS の2要素認証を有効にしているユーザーはコードを送り、セッションは CompleteSMSLogin で作成する
+	if user.SMSMFAEnabled {
+		mfaToken, err := s.smsOTP.StartLogin(ctx, user)
+		if err != nil {
+			// 電話番号が未確認になった場合もパスワードだけではログインさせない
+			if errors.Is(err, ErrPhoneNotVerified) {
+				return nil, ErrInvalidCredentials
+			}
+			return nil, err
+		}
+		return &model.LoginOutput{
+			User:     user,
+			MFAToken: mfaToken,
+		}, nil
 	}
 
-	match, err := s.verifyPassword(input.Password, passwordHash)
+	return s.createSession(ctx, user, tenant, []string{model.AMRPassword}, input.IPAddress, input.UserAgent)
+}
+
+// CompleteSMSLogin は Login で発行した mfa_token と SMS のワンタイムコードを照合してセッションを作成する。
+func (s *AuthService) CompleteSMSLogin(ctx context.Context, input *model.SMSLoginInput) (*model.LoginOutput, error) {
+	user, err := s.smsOTP.CompleteLogin(ctx, input.MFAToken, input.Code)
 	if err != nil {
-		return nil, fmt.Errorf("failed to verify password: %w", err)
+		return nil, err
 	}
-	if !match {
+	if user.Status != "active" {
 		return nil, ErrInvalidCredentials
 	}
+	return s.createSession(ctx, user, &user.Tenant, []string{model.AMRPassword, model.AMRSMS, model.AMRMFA}, input.IPAddress, input.UserAgent)
+}
+
+// VerifyUserPassword はログイン中のユーザーのパスワードを再確認する (2要素認証の無効化など)。
+// ログインと同じ認証ソースで login_id とパスワードを検証し、同じユーザーが認証されたか確認する
+func (s *AuthService) VerifyUserPassword(ctx context.Context, userID uuid.UUID, password string) error {
+	user, err := s.userFinder.FindByID(ctx, userID)
+	if err != nil {
+		return fmt.Errorf("failed to find user: %w", err)
+	}
+	if user == nil {
+		return ErrInvalidCredentials
+	}
+	authenticated, err := s.authenticate(ctx, &user.Tenant, user.LoginID, password)
+	if err != nil {
+		return err
+	}
+	if authenticated.ID != user.ID {
+		return ErrInvalidCredentials
+	}
+	return nil
+}
+
+// authenticate は認証ソースを順に試し、最初にユーザーまたはエラーを返したものの結果を返す。
+// どの認証ソースも扱わないユーザーの場合は ErrInvalidCredentials を返す
+func (s *AuthService) authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error) {
+	for _, a := range s.authenticators {
+		user, err := a.Authenticate(ctx, tenant, loginID, password)
+		if err != nil {
+			return nil, err
+		}
+		if user != nil {
+			return user, nil
+		}
+	}
+	return nil, ErrInvalidCredentials
+}
+
+// requireVerifiedEmail はテナントがログインに確認済みのメールアドレスを要求する場合に確認する。
+// 未確認のユーザーはログインさせず、確認メールを送る (直近に送信済みの場合は再送しない)。
+func (s *AuthService) requireVerifiedEmail(ctx context.Context, tenant *model.Tenant, user *model.User) error {
+	if !tenant.RequiresVerifiedEmailForLogin() || user.EmailVerified {
+		return nil
+	}
+	if err := s.emailVerifier.RequestVerification(ctx, user); err != nil && !errors.Is(err, ErrEmailVerificationThrottled) {
+		return err
+	}
+	return ErrEmailNotVerified
+}
 
+func (s *AuthService) createSession(ctx context.Context, user *model.User, tenant *model.Tenant, amr []string, ipAddress, userAgent string) (*model.LoginOutput, error) {
 	// セッション作成
 	session := &model.Session{
 		UserID:    user.ID,
 		TenantID:  tenant.ID,
-		IPAddress: input.IPAddress,
-		UserAgent: input.UserAgent,
+		IPAddress: ipAddress,
+		UserAgent: userAgent,
+		AMR:       amr,
 		ExpiresAt: time.Now().Add(time.Duration(tenant.SessionLifetime) * time.Second),
 	}
 
@@ -104,12 +178,3 @@ func (s *AuthService) ValidateSession(ctx context.Context, sessionID uuid.UUID)
 	}
 	return session, nil
 }
-
-func findPasswordHash(credentials []model.Credential) string {
-	for _, cred := range credentials {
-		if cred.Type == "password" && cred.PasswordCredential != nil {
-			return cred.PasswordCredential.PasswordHash
-		}
-	}
-	return ""
-}
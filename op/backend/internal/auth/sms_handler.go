@@ -0,0 +1,122 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SMSHandler は OP Frontend の電話番号確認・SMS による2要素認証の設定向けの内部 API を処理する。
+type SMSHandler struct {
+	authSvc    *AuthService
+	userFinder UserFinder
+	smsOTP     *SMSOTPService
+}
+
+func NewSMSHandler(authSvc *AuthService, userFinder UserFinder, smsOTP *SMSOTPService) *SMSHandler {
+	return &SMSHandler{authSvc: authSvc, userFinder: userFinder, smsOTP: smsOTP}
+}
+
+type phoneVerifyRequest struct {
+	Code string `json:"code"`
+}
+
+type smsMFADisableRequest struct {
+	Password string `json:"password"`
+}
+
+// HandleVerifyRequest は POST /internal/phone/verify-request を処理する。
+// ログイン中のユーザーの電話番号に確認コードを送信する。
+func (h *SMSHandler) HandleVerifyRequest(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if err := h.smsOTP.SendPhoneVerification(c.Request().Context(), user); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]string{"status": "sent"})
+}
+
+// HandleVerify は POST /internal/phone/verify を処理する。
+// SMS で送った確認コードでログイン中のユーザーの電話番号を確認済みにする。
+func (h *SMSHandler) HandleVerify(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	var req phoneVerifyRequest
+	if err := c.Bind(&req); err != nil || req.Code == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	if err := h.smsOTP.VerifyPhone(c.Request().Context(), user, req.Code); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "verified"})
+}
+
+// HandleEnableMFA は POST /internal/mfa/sms/enable を処理する。確認済みの電話番号が必要。
+func (h *SMSHandler) HandleEnableMFA(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if err := h.smsOTP.EnableMFA(c.Request().Context(), user); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "enabled"})
+}
+
+// HandleDisableMFA は POST /internal/mfa/sms/disable を処理する。パスワードの再確認が必要。
+func (h *SMSHandler) HandleDisableMFA(c echo.Context) error {
+	user, err := sessionUser(c, h.authSvc, h.userFinder)
+	if err != nil || user == nil {
+		return err
+	}
+
+	var req smsMFADisableRequest
+	if err := c.Bind(&req); err != nil || req.Password == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	ctx := c.Request().Context()
+	if err := h.authSvc.VerifyUserPassword(ctx, user.ID, req.Password); err != nil {
+		return h.errorResponse(c, err)
+	}
+	if err := h.smsOTP.DisableMFA(ctx, user); err != nil {
+		return h.errorResponse(c, err)
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": "disabled"})
+}
+
+func (h *SMSHandler) errorResponse(c echo.Context, err error) error {
+	switch {
+	case errors.Is(err, ErrPhoneNumberNotSet):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "phone_number_not_set"})
+	case errors.Is(err, ErrPhoneAlreadyVerified):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "already_verified"})
+	case errors.Is(err, ErrPhoneNotVerified):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "phone_number_not_verified"})
+	case errors.Is(err, ErrInvalidSMSCode):
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_code"})
+	case errors.Is(err, ErrInvalidCredentials):
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_credentials"})
+	case errors.Is(err, ErrSMSThrottled):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_requests"})
+	case errors.Is(err, ErrSMSCodeAttemptsExceeded):
+		return c.JSON(http.StatusTooManyRequests, map[string]string{"error": "too_many_attempts"})
+	case errors.Is(err, ErrDirectoryUnavailable):
+		c.Logger().Errorf("sms error: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "directory_unavailable"})
+	case errors.Is(err, ErrUserMigrationUnavailable):
+		c.Logger().Errorf("sms error: %v", err)
+		return c.JSON(http.StatusServiceUnavailable, map[string]string{"error": "migration_unavailable"})
+	}
+	c.Logger().Errorf("sms error: %v", err)
+	return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+}
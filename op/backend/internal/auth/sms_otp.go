@@ -0,0 +1,226 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// smsOTPLifetime はワンタイムコードの有効期間
+	smsOTPLifetime = 5 * time.Minute
+	// smsOTPMaxAttempts は 1 つのコードに許容する入力回数
+	smsOTPMaxAttempts = 5
+	// smsOTPResendInterval と smsOTPHourlyLimit はユーザーごとの送信回数の制限 (SMS 送信の濫用対策)。
+	// 再送間隔はユーザーが送信を要求する電話番号の確認にのみ適用し、ログインは1時間あたりの上限のみで制限する
+	smsOTPResendInterval = time.Minute
+	smsOTPHourlyLimit    = 5
+	// smsOTPDigits はコードの桁数
+	smsOTPDigits = 6
+)
+
+// SMSOTPService は SMS のワンタイムコードを送信・照合する。電話番号の確認とログインの2要素目に使う。
+type SMSOTPService struct {
+	codeStore SMSOTPCodeStore
+	userStore UserPhoneStore
+	smsSender SMSSender
+	hashToken HashTokenFunc
+}
+
+func NewSMSOTPService(
+	codeStore SMSOTPCodeStore,
+	userStore UserPhoneStore,
+	smsSender SMSSender,
+	hashToken HashTokenFunc,
+) *SMSOTPService {
+	return &SMSOTPService{
+		codeStore: codeStore,
+		userStore: userStore,
+		smsSender: smsSender,
+		hashToken: hashToken,
+	}
+}
+
+// SendPhoneVerification はユーザーの電話番号に確認コードを送信する。
+func (s *SMSOTPService) SendPhoneVerification(ctx context.Context, user *model.User) error {
+	if user.PhoneNumber == nil {
+		return ErrPhoneNumberNotSet
+	}
+	if user.PhoneNumberVerified {
+		return ErrPhoneAlreadyVerified
+	}
+	recent, err := s.codeStore.CountByUserIDSince(ctx, user.ID, time.Now().Add(-smsOTPResendInterval))
+	if err != nil {
+		return fmt.Errorf("failed to count sms codes: %w", err)
+	}
+	if recent > 0 {
+		return ErrSMSThrottled
+	}
+	return s.send(ctx, user, model.SMSOTPPurposePhoneVerification, nil, "電話番号の確認コード")
+}
+
+// VerifyPhone は確認コードを照合し、電話番号を確認済みにする。
+// コードの送信後に電話番号が変更されていた場合は無効として扱う。
+func (s *SMSOTPService) VerifyPhone(ctx context.Context, user *model.User, code string) error {
+	otp, err := s.codeStore.FindActiveByUserID(ctx, user.ID, model.SMSOTPPurposePhoneVerification)
+	if err != nil {
+		return fmt.Errorf("failed to find sms code: %w", err)
+	}
+	if otp == nil || user.PhoneNumber == nil || otp.PhoneNumber != *user.PhoneNumber {
+		return ErrInvalidSMSCode
+	}
+	if err := s.check(ctx, otp, code); err != nil {
+		return err
+	}
+
+	ok, err := s.userStore.MarkPhoneNumberVerified(ctx, user.ID, otp.PhoneNumber)
+	if err != nil {
+		return fmt.Errorf("failed to mark phone number verified: %w", err)
+	}
+	if !ok {
+		return ErrInvalidSMSCode
+	}
+	return nil
+}
+
+// StartLogin はパスワード認証に成功したユーザーの確認済みの電話番号にログインコードを送り、
+// コードの入力と合わせて提示する mfa_token を返す。
+// ログインをやり直した場合は送信済みのコードを無効にして送り直す (再送間隔の制限はかけない)。
+func (s *SMSOTPService) StartLogin(ctx context.Context, user *model.User) (string, error) {
+	if user.PhoneNumber == nil || !user.PhoneNumberVerified {
+		return "", ErrPhoneNotVerified
+	}
+	challenge, err := generateToken()
+	if err != nil {
+		return "", fmt.Errorf("failed to generate mfa token: %w", err)
+	}
+	challengeHash := s.hashToken(challenge)
+	if err := s.send(ctx, user, model.SMSOTPPurposeLogin, &challengeHash, "ログイン確認コード"); err != nil {
+		return "", err
+	}
+	return challenge, nil
+}
+
+// CompleteLogin は mfa_token とログインコードを照合し、ユーザー (テナントをプリロード済み) を返す。
+func (s *SMSOTPService) CompleteLogin(ctx context.Context, mfaToken, code string) (*model.User, error) {
+	otp, err := s.codeStore.FindActiveByChallengeHash(ctx, s.hashToken(mfaToken))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find sms code: %w", err)
+	}
+	if otp == nil {
+		return nil, ErrInvalidSMSCode
+	}
+	if err := s.check(ctx, otp, code); err != nil {
+		return nil, err
+	}
+
+	user := &otp.User
+	// コードの送信後に電話番号が変更された
+	if user.PhoneNumber == nil || *user.PhoneNumber != otp.PhoneNumber || !user.PhoneNumberVerified {
+		return nil, ErrInvalidSMSCode
+	}
+	return user, nil
+}
+
+// EnableMFA はログインの2要素目に SMS を使うようにする。確認済みの電話番号が必要。
+func (s *SMSOTPService) EnableMFA(ctx context.Context, user *model.User) error {
+	if user.PhoneNumber == nil || !user.PhoneNumberVerified {
+		return ErrPhoneNotVerified
+	}
+	if err := s.userStore.UpdateSMSMFAEnabled(ctx, user.ID, true); err != nil {
+		return fmt.Errorf("failed to enable sms mfa: %w", err)
+	}
+	return nil
+}
+
+// DisableMFA はログインの2要素目に SMS を使わないようにする。パスワードの再確認は呼び出し側で行う。
+func (s *SMSOTPService) DisableMFA(ctx context.Context, user *model.User) error {
+	if err := s.userStore.UpdateSMSMFAEnabled(ctx, user.ID, false); err != nil {
+		return fmt.Errorf("failed to disable sms mfa: %w", err)
+	}
+	return nil
+}
+
+// send は1時間あたりの送信回数の上限を確認し、同じ用途の未使用のコードを無効にしてからコードを発行し、SMS で送信する。
+func (s *SMSOTPService) send(ctx context.Context, user *model.User, purpose string, challengeHash *string, label string) error {
+	hourly, err := s.codeStore.CountByUserIDSince(ctx, user.ID, time.Now().Add(-time.Hour))
+	if err != nil {
+		return fmt.Errorf("failed to count sms codes: %w", err)
+	}
+	if hourly >= smsOTPHourlyLimit {
+		return ErrSMSThrottled
+	}
+	if err := s.codeStore.InvalidateActive(ctx, user.ID, purpose); err != nil {
+		return fmt.Errorf("failed to invalidate sms codes: %w", err)
+	}
+
+	code, err := generateNumericCode(smsOTPDigits)
+	if err != nil {
+		return fmt.Errorf("failed to generate sms code: %w", err)
+	}
+	id := uuid.New()
+	if err := s.codeStore.Create(ctx, &model.SMSOTPCode{
+		ID:            id,
+		UserID:        user.ID,
+		Purpose:       purpose,
+		PhoneNumber:   *user.PhoneNumber,
+		CodeHash:      s.hashCode(id, code),
+		ChallengeHash: challengeHash,
+		ExpiresAt:     time.Now().Add(smsOTPLifetime),
+	}); err != nil {
+		return fmt.Errorf("failed to create sms code: %w", err)
+	}
+
+	if err := s.smsSender.SendSMS(ctx, &model.SMSMessage{
+		To:   *user.PhoneNumber,
+		Body: fmt.Sprintf("%s: %s（%d分間有効）", label, code, int(smsOTPLifetime/time.Minute)),
+	}); err != nil {
+		return fmt.Errorf("failed to send sms: %w", err)
+	}
+	return nil
+}
+
+// check は入力回数を数えてからコードを照合し、一致した場合は使用済みにする。
+func (s *SMSOTPService) check(ctx context.Context, otp *model.SMSOTPCode, code string) error {
+	allowed, err := s.codeStore.RecordAttempt(ctx, otp.ID, smsOTPMaxAttempts)
+	if err != nil {
+		return fmt.Errorf("failed to record sms code attempt: %w", err)
+	}
+	if !allowed {
+		return ErrSMSCodeAttemptsExceeded
+	}
+	if subtle.ConstantTimeCompare([]byte(s.hashCode(otp.ID, code)), []byte(otp.CodeHash)) != 1 {
+		return ErrInvalidSMSCode
+	}
+
+	ok, err := s.codeStore.Consume(ctx, otp.ID)
+	if err != nil {
+		return fmt.Errorf("failed to consume sms code: %w", err)
+	}
+	if !ok {
+		return ErrInvalidSMSCode
+	}
+	return nil
+}
+
+// hashCode はコードのハッシュ値を返す。桁数の少ないコードの事前計算を防ぐため ID をソルトに使う。
+func (s *SMSOTPService) hashCode(id uuid.UUID, code string) string {
+	return s.hashToken(id.String() + ":" + code)
+}
+
+// generateNumericCode は digits 桁の数字のコードを一様に生成する。
+func generateNumericCode(digits int) (string, error) {
+	max := new(big.Int).Exp(big.NewInt(10), big.NewInt(int64(digits)), nil)
+	n, err := rand.Int(rand.Reader, max)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%0*d", digits, n), nil
+}
@@ -0,0 +1,393 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"reflect"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/sms"
+)
+
+// fakeSMSOTPCodeStore は sms_otp_codes テーブルをメモリに持つ。FindActiveByChallengeHash は users からユーザーをプリロードする
+type fakeSMSOTPCodeStore struct {
+	codes []*model.SMSOTPCode
+	users *fakeUserStore
+}
+
+func (f *fakeSMSOTPCodeStore) Create(_ context.Context, code *model.SMSOTPCode) error {
+	code.CreatedAt = time.Now()
+	f.codes = append(f.codes, code)
+	return nil
+}
+
+func (f *fakeSMSOTPCodeStore) CountByUserIDSince(_ context.Context, userID uuid.UUID, since time.Time) (int64, error) {
+	var n int64
+	for _, c := range f.codes {
+		if c.UserID == userID && !c.CreatedAt.Before(since) {
+			n++
+		}
+	}
+	return n, nil
+}
+
+func (f *fakeSMSOTPCodeStore) active(c *model.SMSOTPCode) bool {
+	return c.ConsumedAt == nil && c.ExpiresAt.After(time.Now())
+}
+
+func (f *fakeSMSOTPCodeStore) FindActiveByUserID(_ context.Context, userID uuid.UUID, purpose string) (*model.SMSOTPCode, error) {
+	for i := len(f.codes) - 1; i >= 0; i-- {
+		if c := f.codes[i]; c.UserID == userID && c.Purpose == purpose && f.active(c) {
+			copied := *c
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeSMSOTPCodeStore) FindActiveByChallengeHash(ctx context.Context, challengeHash string) (*model.SMSOTPCode, error) {
+	for _, c := range f.codes {
+		if c.ChallengeHash != nil && *c.ChallengeHash == challengeHash && f.active(c) {
+			copied := *c
+			user, _ := f.users.FindByID(ctx, c.UserID)
+			copied.User = *user
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeSMSOTPCodeStore) InvalidateActive(_ context.Context, userID uuid.UUID, purpose string) error {
+	now := time.Now()
+	for _, c := range f.codes {
+		if c.UserID == userID && c.Purpose == purpose && c.ConsumedAt == nil {
+			c.ConsumedAt = &now
+		}
+	}
+	return nil
+}
+
+func (f *fakeSMSOTPCodeStore) find(id uuid.UUID) *model.SMSOTPCode {
+	for _, c := range f.codes {
+		if c.ID == id {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeSMSOTPCodeStore) RecordAttempt(_ context.Context, id uuid.UUID, maxAttempts int) (bool, error) {
+	c := f.find(id)
+	if c == nil || c.Attempts >= maxAttempts || c.ConsumedAt != nil {
+		return false, nil
+	}
+	c.Attempts++
+	return true, nil
+}
+
+func (f *fakeSMSOTPCodeStore) Consume(_ context.Context, id uuid.UUID) (bool, error) {
+	c := f.find(id)
+	if c == nil || c.ConsumedAt != nil {
+		return false, nil
+	}
+	now := time.Now()
+	c.ConsumedAt = &now
+	return true, nil
+}
+
+var smsCodePattern = regexp.MustCompile(`: ([0-9]{6})（`)
+
+// smsCode は to 宛ての最後の SMS に含まれるコードを返す
+func smsCode(t *testing.T, outbox *sms.Outbox, to string) string {
+	t.Helper()
+	msgs := outbox.Messages(to)
+	if len(msgs) == 0 {
+		t.Fatalf("no sms to %s", to)
+	}
+	m := smsCodePattern.FindStringSubmatch(msgs[len(msgs)-1].Body)
+	if m == nil {
+		t.Fatalf("no code in sms: %s", msgs[len(msgs)-1].Body)
+	}
+	return m[1]
+}
+
+// wrongCode は code と異なる6桁のコードを返す
+func wrongCode(code string) string {
+	if code == "000000" {
+		return "000001"
+	}
+	return "000000"
+}
+
+type smsOTPFixture struct {
+	svc    *SMSOTPService
+	codes  *fakeSMSOTPCodeStore
+	users  *fakeUserStore
+	outbox *sms.Outbox
+	user   *model.User
+	phone  string
+}
+
+func newSMSOTPFixture() *smsOTPFixture {
+	phone := "+819012345678"
+	user := &model.User{ID: uuid.New(), TenantID: uuid.New(), LoginID: "alice", Status: "active", PhoneNumber: &phone}
+	users := newFakeUserStore(user)
+	f := &smsOTPFixture{
+		codes:  &fakeSMSOTPCodeStore{users: users},
+		users:  users,
+		outbox: sms.NewOutbox(log.New(io.Discard, "", 0)),
+		user:   user,
+		phone:  phone,
+	}
+	f.svc = NewSMSOTPService(f.codes, f.users, f.outbox, fakeHashToken)
+	return f
+}
+
+// allowResend は送信回数の制限にかからないよう発行済みのコードの送信日時を過去にずらす
+func (f *smsOTPFixture) allowResend() {
+	for _, c := range f.codes.codes {
+		c.CreatedAt = c.CreatedAt.Add(-time.Hour)
+	}
+}
+
+func TestSMSPhoneVerification(t *testing.T) {
+	ctx := context.Background()
+	f := newSMSOTPFixture()
+
+	if err := f.svc.SendPhoneVerification(ctx, f.user); err != nil {
+		t.Fatal(err)
+	}
+	code := smsCode(t, f.outbox, f.phone)
+	// 平文のコードは保存しない
+	if c := f.codes.codes[0]; c.CodeHash != fakeHashToken(c.ID.String()+":"+code) || c.Purpose != model.SMSOTPPurposePhoneVerification {
+		t.Fatalf("code = %+v", c)
+	}
+
+	if err := f.svc.VerifyPhone(ctx, f.user, wrongCode(code)); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("wrong code: err = %v", err)
+	}
+	if err := f.svc.VerifyPhone(ctx, f.user, code); err != nil {
+		t.Fatal(err)
+	}
+	if !f.users.users[f.user.ID].PhoneNumberVerified {
+		t.Error("phone number not verified")
+	}
+	// コードは1回のみ使える
+	if err := f.svc.VerifyPhone(ctx, f.user, code); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("reuse: err = %v", err)
+	}
+
+	f.user.PhoneNumberVerified = true
+	if err := f.svc.SendPhoneVerification(ctx, f.user); !errors.Is(err, ErrPhoneAlreadyVerified) {
+		t.Errorf("already verified: err = %v", err)
+	}
+	if err := f.svc.SendPhoneVerification(ctx, &model.User{ID: uuid.New()}); !errors.Is(err, ErrPhoneNumberNotSet) {
+		t.Errorf("no phone number: err = %v", err)
+	}
+}
+
+func TestSMSPhoneVerificationInvalid(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("入力回数の上限", func(t *testing.T) {
+		f := newSMSOTPFixture()
+		_ = f.svc.SendPhoneVerification(ctx, f.user)
+		code := smsCode(t, f.outbox, f.phone)
+		for i := 0; i < smsOTPMaxAttempts; i++ {
+			if err := f.svc.VerifyPhone(ctx, f.user, wrongCode(code)); !errors.Is(err, ErrInvalidSMSCode) {
+				t.Fatalf("attempt %d: err = %v", i, err)
+			}
+		}
+		// 上限に達した後は正しいコードでも受け付けない
+		if err := f.svc.VerifyPhone(ctx, f.user, code); !errors.Is(err, ErrSMSCodeAttemptsExceeded) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("期限切れ", func(t *testing.T) {
+		f := newSMSOTPFixture()
+		_ = f.svc.SendPhoneVerification(ctx, f.user)
+		f.codes.codes[0].ExpiresAt = time.Now().Add(-time.Second)
+		if err := f.svc.VerifyPhone(ctx, f.user, smsCode(t, f.outbox, f.phone)); !errors.Is(err, ErrInvalidSMSCode) {
+			t.Errorf("err = %v", err)
+		}
+	})
+
+	t.Run("送信後に電話番号が変わった", func(t *testing.T) {
+		f := newSMSOTPFixture()
+		_ = f.svc.SendPhoneVerification(ctx, f.user)
+		other := "+819087654321"
+		f.user.PhoneNumber = &other
+		f.users.users[f.user.ID].PhoneNumber = &other
+		if err := f.svc.VerifyPhone(ctx, f.user, smsCode(t, f.outbox, f.phone)); !errors.Is(err, ErrInvalidSMSCode) {
+			t.Errorf("err = %v", err)
+		}
+		if f.users.users[f.user.ID].PhoneNumberVerified {
+			t.Error("new number verified by old code")
+		}
+	})
+
+	t.Run("ログイン用のコードでは確認できない", func(t *testing.T) {
+		f := newSMSOTPFixture()
+		f.user.PhoneNumberVerified = true
+		_, _ = f.svc.StartLogin(ctx, f.user)
+		f.user.PhoneNumberVerified = false
+		if err := f.svc.VerifyPhone(ctx, f.user, smsCode(t, f.outbox, f.phone)); !errors.Is(err, ErrInvalidSMSCode) {
+			t.Errorf("err = %v", err)
+		}
+	})
+}
+
+func TestSMSSendThrottle(t *testing.T) {
+	ctx := context.Background()
+	f := newSMSOTPFixture()
+
+	_ = f.svc.SendPhoneVerification(ctx, f.user)
+	if err := f.svc.SendPhoneVerification(ctx, f.user); !errors.Is(err, ErrSMSThrottled) {
+		t.Errorf("resend within interval: err = %v", err)
+	}
+
+	// 1時間あたりの上限
+	for _, c := range f.codes.codes {
+		c.CreatedAt = time.Now().Add(-2 * smsOTPResendInterval)
+	}
+	for i := 1; i < smsOTPHourlyLimit; i++ {
+		f.codes.codes = append(f.codes.codes, &model.SMSOTPCode{ID: uuid.New(), UserID: f.user.ID, CreatedAt: time.Now().Add(-2 * smsOTPResendInterval)})
+	}
+	if err := f.svc.SendPhoneVerification(ctx, f.user); !errors.Is(err, ErrSMSThrottled) {
+		t.Errorf("hourly limit: err = %v", err)
+	}
+	if n := len(f.outbox.Messages("")); n != 1 {
+		t.Errorf("sent %d sms", n)
+	}
+
+	f.allowResend()
+	if err := f.svc.SendPhoneVerification(ctx, f.user); err != nil {
+		t.Errorf("after an hour: err = %v", err)
+	}
+}
+
+func TestSMSLogin(t *testing.T) {
+	ctx := context.Background()
+	f := newSMSOTPFixture()
+	f.user.PhoneNumberVerified = true
+	f.user.SMSMFAEnabled = true
+	tenant := &model.Tenant{ID: f.user.TenantID, Code: "demo", SessionLifetime: 3600}
+	f.user.Tenant = *tenant
+	svc, sessions := newTestAuthService(tenant, f.users, nil, f.svc)
+	input := &model.LoginInput{TenantCode: "demo", LoginID: "alice", Password: "correct"}
+
+	// パスワードだけではセッションを作らない
+	out, err := svc.Login(ctx, input)
+	if err != nil || out.MFAToken == "" || len(sessions.sessions) != 0 {
+		t.Fatalf("out = %+v, err = %v", out, err)
+	}
+	code := smsCode(t, f.outbox, f.phone)
+	if c := f.codes.codes[0]; c.Purpose != model.SMSOTPPurposeLogin || *c.ChallengeHash != fakeHashToken(out.MFAToken) {
+		t.Fatalf("code = %+v", c)
+	}
+
+	if _, err := svc.CompleteSMSLogin(ctx, &model.SMSLoginInput{MFAToken: "unknown", Code: code}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("unknown mfa token: err = %v", err)
+	}
+	if _, err := svc.CompleteSMSLogin(ctx, &model.SMSLoginInput{MFAToken: out.MFAToken, Code: wrongCode(code)}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("wrong code: err = %v", err)
+	}
+	done, err := svc.CompleteSMSLogin(ctx, &model.SMSLoginInput{MFAToken: out.MFAToken, Code: code})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session := sessions.sessions[done.SessionID]
+	if session == nil || !reflect.DeepEqual([]string(session.AMR), []string{model.AMRPassword, model.AMRSMS, model.AMRMFA}) {
+		t.Errorf("session = %+v", session)
+	}
+	if _, err := svc.CompleteSMSLogin(ctx, &model.SMSLoginInput{MFAToken: out.MFAToken, Code: code}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("reuse: err = %v", err)
+	}
+
+	// ログイン中に電話番号が変更・未確認になった場合は完了させない
+	f.allowResend()
+	out, _ = svc.Login(ctx, input)
+	code = smsCode(t, f.outbox, f.phone)
+	f.users.users[f.user.ID].PhoneNumberVerified = false
+	if _, err := svc.CompleteSMSLogin(ctx, &model.SMSLoginInput{MFAToken: out.MFAToken, Code: code}); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("unverified after start: err = %v", err)
+	}
+	// 電話番号が未確認のユーザーはパスワードだけでログインできない
+	f.allowResend()
+	if _, err := svc.Login(ctx, input); !errors.Is(err, ErrInvalidCredentials) {
+		t.Errorf("unverified phone: err = %v", err)
+	}
+}
+
+func TestSMSLoginRestart(t *testing.T) {
+	ctx := context.Background()
+	f := newSMSOTPFixture()
+	f.user.PhoneNumberVerified = true
+
+	// ログインを中断してすぐにやり直しても再送間隔の制限にかけず、新しいコードを送る
+	first, err := f.svc.StartLogin(ctx, f.user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstCode := smsCode(t, f.outbox, f.phone)
+	second, err := f.svc.StartLogin(ctx, f.user)
+	if err != nil {
+		t.Fatalf("restart: err = %v", err)
+	}
+	secondCode := smsCode(t, f.outbox, f.phone)
+	if n := len(f.outbox.Messages(f.phone)); n != 2 {
+		t.Fatalf("sent %d sms", n)
+	}
+
+	// 前のログインの mfa_token とコードは使えない
+	if _, err := f.svc.CompleteLogin(ctx, first, firstCode); !errors.Is(err, ErrInvalidSMSCode) {
+		t.Errorf("previous login: err = %v", err)
+	}
+	if user, err := f.svc.CompleteLogin(ctx, second, secondCode); err != nil || user.ID != f.user.ID {
+		t.Errorf("user = %v, err = %v", user, err)
+	}
+
+	// 1時間あたりの上限はログインにも適用する
+	for i := 2; i < smsOTPHourlyLimit; i++ {
+		if _, err := f.svc.StartLogin(ctx, f.user); err != nil {
+			t.Fatalf("login %d: err = %v", i+1, err)
+		}
+	}
+	if _, err := f.svc.StartLogin(ctx, f.user); !errors.Is(err, ErrSMSThrottled) {
+		t.Errorf("hourly limit: err = %v", err)
+	}
+}
+
+func TestSMSMFASettings(t *testing.T) {
+	ctx := context.Background()
+	f := newSMSOTPFixture()
+
+	if err := f.svc.EnableMFA(ctx, f.user); !errors.Is(err, ErrPhoneNotVerified) {
+		t.Errorf("unverified: err = %v", err)
+	}
+	f.user.PhoneNumberVerified = true
+	if err := f.svc.EnableMFA(ctx, f.user); err != nil || !f.users.users[f.user.ID].SMSMFAEnabled {
+		t.Errorf("enable: err = %v", err)
+	}
+	if err := f.svc.DisableMFA(ctx, f.user); err != nil || f.users.users[f.user.ID].SMSMFAEnabled {
+		t.Errorf("disable: err = %v", err)
+	}
+}
+
+func TestGenerateNumericCode(t *testing.T) {
+	pattern := regexp.MustCompile(`^[0-9]{6}$`)
+	for i := 0; i < 100; i++ {
+		code, err := generateNumericCode(smsOTPDigits)
+		if err != nil || !pattern.MatchString(code) {
+			t.Fatalf("code = %q, err = %v", code, err)
+		}
+	}
+}
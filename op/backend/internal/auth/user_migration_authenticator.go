@@ -0,0 +1,178 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// UserMigrationAuthenticator はローカルにパスワードのないユーザーを、移行元のシステムの Webhook で認証する (段階的な移行)。
+// 検証できたらパスワードを argon2id でハッシュ化して保存し、ユーザーがいなければ移行元のプロフィールで作成する。
+// 以降のログインは PasswordAuthenticator で認証される
+type UserMigrationAuthenticator struct {
+	hookFinder    UserMigrationHookFinder
+	userStore     MigratedUserStore
+	client        UserMigrationClient
+	hashPassword  HashPasswordFunc
+	decryptSecret DecryptSecretFunc
+}
+
+func NewUserMigrationAuthenticator(
+	hookFinder UserMigrationHookFinder,
+	userStore MigratedUserStore,
+	client UserMigrationClient,
+	hashPassword HashPasswordFunc,
+	decryptSecret DecryptSecretFunc,
+) *UserMigrationAuthenticator {
+	return &UserMigrationAuthenticator{
+		hookFinder:    hookFinder,
+		userStore:     userStore,
+		client:        client,
+		hashPassword:  hashPassword,
+		decryptSecret: decryptSecret,
+	}
+}
+
+// Authenticate は移行元で login_id とパスワードを検証する。
+// テナントに有効な Webhook がない場合、ユーザーがパスワードまたは LDAP ディレクトリの紐付けを持つ場合、
+// ユーザーが有効でない場合は (nil, nil) を返す。
+func (a *UserMigrationAuthenticator) Authenticate(ctx context.Context, tenant *model.Tenant, loginID, password string) (*model.User, error) {
+	hook, err := a.hookFinder.FindActiveByTenantID(ctx, tenant.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user migration hook: %w", err)
+	}
+	if hook == nil {
+		return nil, nil
+	}
+
+	local, err := a.userStore.FindByTenantAndLoginID(ctx, tenant.ID, loginID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	if local != nil && (local.Status != "active" || findPasswordHash(local.Credentials) != "" || hasCredential(local.Credentials, model.CredentialTypeLDAP)) {
+		return nil, nil
+	}
+	if password == "" {
+		return nil, ErrInvalidCredentials
+	}
+
+	req, err := a.migrationRequest(hook, tenant, loginID)
+	if err != nil {
+		return nil, err
+	}
+	migrated, err := a.client.VerifyPassword(ctx, req, password)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUserMigrationUnavailable, err)
+	}
+	if migrated == nil {
+		return nil, ErrInvalidCredentials
+	}
+
+	passwordHash, err := a.hashPassword(ctx, password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	// 既存のユーザー (SCIM で作成されたなど) はプロフィールを変更せず、パスワードだけ設定する
+	if local != nil {
+		if err := a.userStore.SetPassword(ctx, local.ID, passwordHash); err != nil {
+			return nil, fmt.Errorf("failed to set migrated password: %w", err)
+		}
+		return local, nil
+	}
+	return a.createUser(ctx, tenant, loginID, migrated, passwordHash)
+}
+
+// createUser は移行元のプロフィールでユーザーを作成する。メールアドレスの確認状態は移行元の値を引き継ぐ
+func (a *UserMigrationAuthenticator) createUser(ctx context.Context, tenant *model.Tenant, loginID string, migrated *model.MigratedUser, passwordHash string) (*model.User, error) {
+	attrs := migratedAttributes(migrated)
+	email := attrs["email"]
+	if email == "" {
+		return nil, fmt.Errorf("%w: %s has no valid email", ErrMigratedUserIncomplete, loginID)
+	}
+	if len(loginID) > federatedAttributeMaxLength[model.FederatedLoginIDAttribute] || !isPrintable(loginID) {
+		return nil, fmt.Errorf("%w: invalid login_id", ErrMigratedUserIncomplete)
+	}
+	other, err := a.userStore.FindByTenantAndEmail(ctx, tenant.ID, email)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find user by email: %w", err)
+	}
+	if other != nil {
+		return nil, ErrEmailAlreadyInUse
+	}
+
+	user := &model.User{
+		TenantID:      tenant.ID,
+		LoginID:       loginID,
+		Email:         email,
+		EmailVerified: migrated.EmailVerified,
+		Status:        "active",
+	}
+	for attr, target := range profileAttributes(user) {
+		if v, ok := attrs[attr]; ok {
+			*target = &v
+		}
+	}
+	user.PhoneNumberVerified = user.PhoneNumber != nil && migrated.PhoneNumberVerified
+
+	if err := a.userStore.CreateWithPassword(ctx, user, passwordHash); err != nil {
+		return nil, fmt.Errorf("failed to create migrated user: %w", err)
+	}
+	return user, nil
+}
+
+// migrationRequest は Webhook の設定から移行元の呼び出しの設定を作る。シークレットは復号する
+func (a *UserMigrationAuthenticator) migrationRequest(hook *model.UserMigrationHook, tenant *model.Tenant, loginID string) (*model.UserMigrationRequest, error) {
+	req := &model.UserMigrationRequest{
+		HookID:     hook.ID,
+		URL:        hook.URL,
+		AuthMethod: hook.AuthMethod,
+		Timeout:    time.Duration(hook.TimeoutMS) * time.Millisecond,
+		TenantCode: tenant.Code,
+		LoginID:    loginID,
+	}
+	if hook.CACertificate != nil {
+		req.CACertificate = *hook.CACertificate
+	}
+	switch hook.AuthMethod {
+	case model.UserMigrationHookAuthHMAC:
+		if hook.HMACSecretEncrypted == nil {
+			return nil, fmt.Errorf("user migration hook has no hmac secret")
+		}
+		secret, err := a.decryptSecret(*hook.HMACSecretEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt hmac secret: %w", err)
+		}
+		req.HMACSecret = secret
+	case model.UserMigrationHookAuthMTLS:
+		if hook.ClientCertificate == nil || hook.ClientPrivateKeyEncrypted == nil {
+			return nil, fmt.Errorf("user migration hook has no client certificate")
+		}
+		key, err := a.decryptSecret(*hook.ClientPrivateKeyEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt client private key: %w", err)
+		}
+		req.ClientCertificate = *hook.ClientCertificate
+		req.ClientPrivateKey = key
+	}
+	return req, nil
+}
+
+// migratedAttributes は移行元のプロフィールからユーザー属性の値を取り出す。
+// UTF-8 でない値、長すぎる値、形式が正しくないメールアドレス・電話番号は使わない。
+func migratedAttributes(migrated *model.MigratedUser) map[string]string {
+	attrs := map[string]string{}
+	for attr, v := range migrated.Attributes {
+		maxLength, ok := federatedAttributeMaxLength[attr]
+		if !ok || attr == model.FederatedLoginIDAttribute || v == "" || !isPrintable(v) || len(v) > maxLength {
+			continue
+		}
+		if (attr == "email" && !isValidEmail(v)) || (attr == "phone_number" && !phoneNumberRegex.MatchString(v)) {
+			continue
+		}
+		attrs[attr] = v
+	}
+	return attrs
+}
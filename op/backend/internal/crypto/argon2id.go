@@ -10,63 +10,119 @@ import (
 	"golang.org/x/crypto/argon2"
 )
 
-// argon2id パラメータ (OWASP 推奨)
+// argon2id の鍵長・ソルト長。パラメータは Argon2Params で設定する
 const (
-	argon2Time    = 3
-	argon2Memory  = 64 * 1024 // 64 MB
-	argon2Threads = 4
 	argon2KeyLen  = 32
 	argon2SaltLen = 16
+
+	// 検証する argon2id ハッシュのパラメータの上限
+	maxArgon2Memory = 1024 * 1024 // 1 GB
+	maxArgon2Time   = 16
 )
 
-// HashPassword は argon2id でパスワードをハッシュする。
-// 出力形式: $argon2id$v=19$m=65536,t=3,p=4$<salt>$<hash>
-func HashPassword(password string) (string, error) {
+// Argon2Params は argon2id でハッシュ化するときのパラメータ (RFC 9106 Section 3.1)
+type Argon2Params struct {
+	// Memory はメモリサイズ (KiB)
+	Memory  uint32
+	Time    uint32
+	Threads uint8
+}
+
+// DefaultArgon2Params は OWASP 推奨のパラメータ (64 MiB, t=3, p=4)
+func DefaultArgon2Params() Argon2Params {
+	return Argon2Params{Memory: 64 * 1024, Time: 3, Threads: 4}
+}
+
+// Validate はパラメータが検証できる範囲にあるか確認する。
+// 上限は取り込んだハッシュの検証と同じで、これを超えるハッシュは VerifyPassword で扱えない
+func (p Argon2Params) Validate() error {
+	if p.Threads < 1 {
+		return fmt.Errorf("argon2id threads must be at least 1")
+	}
+	if p.Time < 1 || p.Time > maxArgon2Time {
+		return fmt.Errorf("argon2id time must be between 1 and %d", maxArgon2Time)
+	}
+	if p.Memory < 8*uint32(p.Threads) || p.Memory > maxArgon2Memory {
+		return fmt.Errorf("argon2id memory must be between %d and %d KiB", 8*uint32(p.Threads), maxArgon2Memory)
+	}
+	return nil
+}
+
+func (p Argon2Params) String() string {
+	return fmt.Sprintf("m=%d,t=%d,p=%d", p.Memory, p.Time, p.Threads)
+}
+
+// hashArgon2id は argon2id でパスワードをハッシュする。
+// 出力形式: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func hashArgon2id(password string, p Argon2Params) (string, error) {
 	salt := make([]byte, argon2SaltLen)
 	if _, err := rand.Read(salt); err != nil {
 		return "", fmt.Errorf("failed to generate salt: %w", err)
 	}
 
-	hash := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	hash := argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, argon2KeyLen)
 
-	return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+	return fmt.Sprintf("$argon2id$v=%d$%s$%s$%s",
 		argon2.Version,
-		argon2Memory, argon2Time, argon2Threads,
+		p,
 		base64.RawStdEncoding.EncodeToString(salt),
 		base64.RawStdEncoding.EncodeToString(hash),
 	), nil
 }
 
-// VerifyPassword は argon2id ハッシュとパスワードを検証する。
-func VerifyPassword(password, encoded string) (bool, error) {
+// argon2idHash は PHC 文字列形式の argon2id ハッシュ。
+// 出力形式: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+type argon2idHash struct {
+	memory  uint32
+	time    uint32
+	threads uint8
+	salt    []byte
+	hash    []byte
+}
+
+func parseArgon2id(encoded string) (*argon2idHash, error) {
 	parts := strings.Split(encoded, "$")
-	if len(parts) != 6 {
-		return false, fmt.Errorf("invalid hash format")
+	if len(parts) != 6 || parts[1] != "argon2id" {
+		return nil, fmt.Errorf("invalid hash format")
 	}
 
-	if parts[1] != "argon2id" {
-		return false, fmt.Errorf("unsupported algorithm: %s", parts[1])
+	var version int
+	if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2 version: %s", parts[2])
 	}
 
-	var memory uint32
-	var time uint32
-	var threads uint8
-	_, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads)
-	if err != nil {
-		return false, fmt.Errorf("failed to parse parameters: %w", err)
+	h := &argon2idHash{}
+	if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
 	}
-
-	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
-	if err != nil {
-		return false, fmt.Errorf("failed to decode salt: %w", err)
+	// 取り込んだハッシュで検証が極端に重くならないよう上限を設ける
+	if h.memory < 8*uint32(h.threads) || h.memory > maxArgon2Memory || h.time < 1 || h.time > maxArgon2Time || h.threads < 1 {
+		return nil, fmt.Errorf("argon2id parameters out of range: %s", parts[3])
 	}
 
-	expectedHash, err := base64.RawStdEncoding.DecodeString(parts[5])
-	if err != nil {
-		return false, fmt.Errorf("failed to decode hash: %w", err)
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
 	}
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return nil, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	if len(h.hash) < 16 {
+		return nil, fmt.Errorf("argon2id hash is too short")
+	}
+	return h, nil
+}
 
-	hash := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(expectedHash)))
+func (h *argon2idHash) algorithm() string { return PasswordAlgorithmArgon2id }
+
+func (h *argon2idHash) verify(password string) bool {
+	hash := argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(len(h.hash)))
+	return subtle.ConstantTimeCompare(hash, h.hash) == 1
+}
 
-	return subtle.ConstantTimeCompare(hash, expectedHash) == 1, nil
+// weakerThan はハッシュのパラメータ・鍵長・ソルト長のいずれかが target より小さいか判定する。
+// target より強いハッシュは作り直さない (パラメータを下げた場合にログインのたびに作り直さないため)
+func (h *argon2idHash) weakerThan(target Argon2Params) bool {
+	return h.memory < target.Memory || h.time < target.Time || h.threads < target.Threads ||
+		len(h.hash) < argon2KeyLen || len(h.salt) < argon2SaltLen
 }
@@ -0,0 +1,161 @@
+package crypto
+
+import (
+	"crypto/pbkdf2"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/crypto/scrypt"
+)
+
+// 他のシステムから取り込んだハッシュのパラメータの上限。
+// 検証はログインのたびに行うため、極端に重いパラメータのハッシュは取り込ませない
+const (
+	maxBcryptCost       = 16
+	maxPBKDF2Iterations = 10_000_000
+	maxScryptMemory     = 1 << 30 // 128 * N * r バイト
+	maxScryptP          = 16
+)
+
+// bcryptHash は Modular Crypt Format の bcrypt ハッシュ ($2a$ / $2b$ / $2y$)。
+type bcryptHash struct {
+	encoded []byte
+}
+
+func parseBcrypt(encoded string) (*bcryptHash, error) {
+	cost, err := bcrypt.Cost([]byte(encoded))
+	if err != nil {
+		return nil, fmt.Errorf("invalid bcrypt hash: %w", err)
+	}
+	if cost > maxBcryptCost {
+		return nil, fmt.Errorf("bcrypt cost out of range: %d", cost)
+	}
+	// $2y$ は PHP の crypt の識別子で、$2b$ と同じアルゴリズム
+	return &bcryptHash{encoded: []byte(encoded)}, nil
+}
+
+func (h *bcryptHash) algorithm() string { return PasswordAlgorithmBcrypt }
+
+func (h *bcryptHash) verify(password string) bool {
+	return bcrypt.CompareHashAndPassword(h.encoded, []byte(password)) == nil
+}
+
+// pbkdf2Hash は passlib の形式の PBKDF2 ハッシュ。
+// 形式: $pbkdf2-<digest>$<rounds>$<salt>$<hash> (salt と hash は "+" を "." に置き換えた base64、パディングなし)
+type pbkdf2Hash struct {
+	name       string
+	digest     func() hash.Hash
+	iterations int
+	salt       []byte
+	hash       []byte
+}
+
+func parsePBKDF2(encoded string) (*pbkdf2Hash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid pbkdf2 hash format")
+	}
+
+	h := &pbkdf2Hash{}
+	switch parts[1] {
+	case "pbkdf2":
+		// passlib は digest のない識別子を HMAC-SHA1 に使う
+		h.name, h.digest = PasswordAlgorithmPBKDF2SHA1, sha1.New
+	case "pbkdf2-sha256":
+		h.name, h.digest = PasswordAlgorithmPBKDF2SHA256, sha256.New
+	case "pbkdf2-sha512":
+		h.name, h.digest = PasswordAlgorithmPBKDF2SHA512, sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported pbkdf2 digest: %s", parts[1])
+	}
+
+	iterations, err := strconv.Atoi(parts[2])
+	if err != nil || iterations < 1 || iterations > maxPBKDF2Iterations {
+		return nil, fmt.Errorf("pbkdf2 rounds out of range: %s", parts[2])
+	}
+	h.iterations = iterations
+
+	if h.salt, err = decodeAdaptedBase64(parts[3]); err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	if h.hash, err = decodeAdaptedBase64(parts[4]); err != nil {
+		return nil, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	if len(h.hash) < 16 {
+		return nil, fmt.Errorf("pbkdf2 hash is too short")
+	}
+	return h, nil
+}
+
+func (h *pbkdf2Hash) algorithm() string { return h.name }
+
+func (h *pbkdf2Hash) verify(password string) bool {
+	key, err := pbkdf2.Key(h.digest, password, h.salt, h.iterations, len(h.hash))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, h.hash) == 1
+}
+
+// scryptHash は passlib の形式の scrypt ハッシュ。
+// 形式: $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> (salt と hash は base64、パディングなし)
+type scryptHash struct {
+	n, r, p int
+	salt    []byte
+	hash    []byte
+}
+
+func parseScrypt(encoded string) (*scryptHash, error) {
+	parts := strings.Split(encoded, "$")
+	if len(parts) != 5 {
+		return nil, fmt.Errorf("invalid scrypt hash format")
+	}
+
+	var ln, r, p int
+	if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &ln, &r, &p); err != nil {
+		return nil, fmt.Errorf("failed to parse parameters: %w", err)
+	}
+	if ln < 1 || ln > 24 || r < 1 || p < 1 || p > maxScryptP || 128*r > maxScryptMemory>>ln {
+		return nil, fmt.Errorf("scrypt parameters out of range: %s", parts[2])
+	}
+
+	h := &scryptHash{n: 1 << ln, r: r, p: p}
+	var err error
+	if h.salt, err = decodeAdaptedBase64(parts[3]); err != nil {
+		return nil, fmt.Errorf("failed to decode salt: %w", err)
+	}
+	if h.hash, err = decodeAdaptedBase64(parts[4]); err != nil {
+		return nil, fmt.Errorf("failed to decode hash: %w", err)
+	}
+	if len(h.hash) < 16 {
+		return nil, fmt.Errorf("scrypt hash is too short")
+	}
+	return h, nil
+}
+
+func (h *scryptHash) algorithm() string { return PasswordAlgorithmScrypt }
+
+func (h *scryptHash) verify(password string) bool {
+	key, err := scrypt.Key([]byte(password), h.salt, h.n, h.r, h.p, len(h.hash))
+	if err != nil {
+		return false
+	}
+	return subtle.ConstantTimeCompare(key, h.hash) == 1
+}
+
+// decodeAdaptedBase64 は passlib の ab64 ("+" の代わりに ".") と通常の base64 を、パディングの有無にかかわらず復号する
+func decodeAdaptedBase64(s string) ([]byte, error) {
+	s = strings.TrimRight(strings.ReplaceAll(s, ".", "+"), "=")
+	if s == "" {
+		return nil, fmt.Errorf("empty value")
+	}
+	return base64.RawStdEncoding.DecodeString(s)
+}
@@ -0,0 +1,145 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// パスワードハッシュのアルゴリズム (password_credentials.algorithm に記録する値)
+const (
+	PasswordAlgorithmArgon2id     = "argon2id"
+	PasswordAlgorithmBcrypt       = "bcrypt"
+	PasswordAlgorithmPBKDF2SHA1   = "pbkdf2-sha1"
+	PasswordAlgorithmPBKDF2SHA256 = "pbkdf2-sha256"
+	PasswordAlgorithmPBKDF2SHA512 = "pbkdf2-sha512"
+	PasswordAlgorithmScrypt       = "scrypt"
+)
+
+// ErrPasswordHasherBusy は同時に計算する数が上限に達したまま待ち時間を過ぎたことを示す。
+// 一時的な過負荷なので、呼び出し元は 503 (temporarily_unavailable) として扱う
+var ErrPasswordHasherBusy = errors.New("password hasher is busy")
+
+// defaultPasswordHashMaxWait は計算の空きを待つ時間の上限
+const defaultPasswordHashMaxWait = 5 * time.Second
+
+// passwordHash は符号化されたパスワードハッシュを解析したもの
+type passwordHash interface {
+	algorithm() string
+	verify(password string) bool
+}
+
+// parsePasswordHash はハッシュの形式を先頭の識別子で判別して解析する。
+// HashPassword の argon2id のほか、他のシステムから取り込んだ bcrypt・PBKDF2・scrypt のハッシュを扱う
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	switch {
+	case strings.HasPrefix(encoded, "$argon2id$"):
+		return parseArgon2id(encoded)
+	case strings.HasPrefix(encoded, "$2a$"), strings.HasPrefix(encoded, "$2b$"), strings.HasPrefix(encoded, "$2y$"):
+		return parseBcrypt(encoded)
+	case strings.HasPrefix(encoded, "$pbkdf2"):
+		return parsePBKDF2(encoded)
+	case strings.HasPrefix(encoded, "$scrypt$"):
+		return parseScrypt(encoded)
+	}
+	return nil, fmt.Errorf("unsupported password hash format")
+}
+
+// PasswordHashAlgorithm はハッシュの形式を検証してアルゴリズムを返す。対応する形式:
+//   - argon2id: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash> (PHC 文字列形式)
+//   - bcrypt: $2a$ / $2b$ / $2y$<cost>$<salt+hash>
+//   - PBKDF2: $pbkdf2 (HMAC-SHA1) / $pbkdf2-sha256 / $pbkdf2-sha512$<rounds>$<salt>$<hash> (passlib の形式)
+//   - scrypt: $scrypt$ln=<log2 N>,r=<r>,p=<p>$<salt>$<hash> (passlib の形式)
+func PasswordHashAlgorithm(encoded string) (string, error) {
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return "", err
+	}
+	return h.algorithm(), nil
+}
+
+// PasswordHasher はパスワードを設定されたパラメータの argon2id でハッシュ化し、検証する。
+// ハッシュ化と検証は 1 回で数十 MiB のメモリを使うため、同時に計算する数を上限までに制限し、
+// 上限に達している間は空くまで待つ (ログインが集中したときにメモリを使い切らないため)。
+// 待つのはリクエストの ctx が終わるか defaultPasswordHashMaxWait を過ぎるまでで、過ぎた場合は ErrPasswordHasherBusy を返す
+type PasswordHasher struct {
+	params  Argon2Params
+	sem     chan struct{}
+	maxWait time.Duration
+}
+
+// NewPasswordHasher は PasswordHasher を生成する。maxConcurrent は同時に計算する数の上限
+func NewPasswordHasher(params Argon2Params, maxConcurrent int) (*PasswordHasher, error) {
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	if maxConcurrent < 1 {
+		return nil, fmt.Errorf("max concurrent password hashing must be at least 1")
+	}
+	return &PasswordHasher{params: params, sem: make(chan struct{}, maxConcurrent), maxWait: defaultPasswordHashMaxWait}, nil
+}
+
+// Params はハッシュ化に使う argon2id のパラメータを返す
+func (p *PasswordHasher) Params() Argon2Params {
+	return p.params
+}
+
+// acquire は計算の空きを待つ。ctx が終わった場合は ctx のエラー、待ち時間を過ぎた場合は ErrPasswordHasherBusy を返す
+func (p *PasswordHasher) acquire(ctx context.Context) error {
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	default:
+	}
+	timer := time.NewTimer(p.maxWait)
+	defer timer.Stop()
+	select {
+	case p.sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return ErrPasswordHasherBusy
+	}
+}
+
+func (p *PasswordHasher) release() {
+	<-p.sem
+}
+
+// HashPassword は argon2id でパスワードをハッシュする。
+// 出力形式: $argon2id$v=19$m=<memory>,t=<time>,p=<threads>$<salt>$<hash>
+func (p *PasswordHasher) HashPassword(ctx context.Context, password string) (string, error) {
+	if err := p.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer p.release()
+	return hashArgon2id(password, p.params)
+}
+
+// VerifyPassword はハッシュとパスワードを検証する。対応する形式は PasswordHashAlgorithm を参照。
+// 格納されたハッシュのパラメータで計算するため、古いパラメータの argon2id も検証できる
+func (p *PasswordHasher) VerifyPassword(ctx context.Context, password, encoded string) (bool, error) {
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, err
+	}
+	if err := p.acquire(ctx); err != nil {
+		return false, err
+	}
+	defer p.release()
+	return h.verify(password), nil
+}
+
+// NeedsRehash は HashPassword で作り直すべきハッシュか判定する。
+// argon2id 以外のハッシュと、パラメータが設定より弱い argon2id はログインの成功時に作り直す
+func (p *PasswordHasher) NeedsRehash(encoded string) bool {
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return true
+	}
+	a, ok := h.(*argon2idHash)
+	return !ok || a.weakerThan(p.params)
+}
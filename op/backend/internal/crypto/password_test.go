@@ -0,0 +1,199 @@
+package crypto
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testPassword は他のシステムのハッシュのテストベクタのパスワード。
+// PBKDF2・scrypt のベクタは Python の hashlib で、bcrypt のベクタは jBCrypt のテストから取った
+const testPassword = "correct horse"
+
+var foreignHashVectors = []struct {
+	name      string
+	encoded   string
+	password  string
+	algorithm string
+}{
+	{name: "bcrypt $2a$", encoded: "$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i", password: "abc", algorithm: PasswordAlgorithmBcrypt},
+	{name: "bcrypt $2b$", encoded: "$2b$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i", password: "abc", algorithm: PasswordAlgorithmBcrypt},
+	{name: "bcrypt $2y$", encoded: "$2y$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i", password: "abc", algorithm: PasswordAlgorithmBcrypt},
+	{name: "bcrypt 空のパスワード", encoded: "$2a$06$DCq7YPn5Rq63x1Lad4cll.TV4S6ytwfsfvkgY8jIucDrjc8deX1s.", password: "", algorithm: PasswordAlgorithmBcrypt},
+	{name: "PBKDF2-SHA1", encoded: "$pbkdf2$1000$8PHy8/T19vf4.fr7/P3./w$.5QX0J046SbyM4zUrpWK1an1PdA", password: testPassword, algorithm: PasswordAlgorithmPBKDF2SHA1},
+	{name: "PBKDF2-SHA256", encoded: "$pbkdf2-sha256$1000$8PHy8/T19vf4.fr7/P3./w$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU", password: testPassword, algorithm: PasswordAlgorithmPBKDF2SHA256},
+	{name: "PBKDF2-SHA512", encoded: "$pbkdf2-sha512$1000$8PHy8/T19vf4.fr7/P3./w$xqu1f3FSdHbs02z9AocIk0UpBf6S9uqlRTfcS56zkPqTxPMhm.kj4D.e8E9DRlH/uXf/Pe1g59vHIM0MwcvtiA", password: testPassword, algorithm: PasswordAlgorithmPBKDF2SHA512},
+	{name: "PBKDF2 通常の base64 とパディング", encoded: "$pbkdf2-sha256$1000$8PHy8/T19vf4+fr7/P3+/w==$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU=", password: testPassword, algorithm: PasswordAlgorithmPBKDF2SHA256},
+	{name: "scrypt", encoded: "$scrypt$ln=10,r=8,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw", password: testPassword, algorithm: PasswordAlgorithmScrypt},
+	{name: "scrypt の ab64", encoded: "$scrypt$ln=10,r=8,p=1$8PHy8/T19vf4.fr7/P3./w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB.xWehuw", password: testPassword, algorithm: PasswordAlgorithmScrypt},
+}
+
+func TestVerifyForeignPasswordHash(t *testing.T) {
+	hasher := newTestPasswordHasher(t)
+	for _, tt := range foreignHashVectors {
+		t.Run(tt.name, func(t *testing.T) {
+			algorithm, err := PasswordHashAlgorithm(tt.encoded)
+			if err != nil || algorithm != tt.algorithm {
+				t.Fatalf("algorithm = %q, err = %v", algorithm, err)
+			}
+			if ok, err := hasher.VerifyPassword(context.Background(), tt.password, tt.encoded); !ok || err != nil {
+				t.Errorf("correct password: ok = %v, err = %v", ok, err)
+			}
+			for _, wrong := range []string{tt.password + "x", strings.ToUpper(tt.password), ""} {
+				if wrong == tt.password {
+					continue
+				}
+				if ok, _ := hasher.VerifyPassword(context.Background(), wrong, tt.encoded); ok {
+					t.Errorf("%q accepted", wrong)
+				}
+			}
+			// 取り込んだハッシュはログインの成功時に argon2id で作り直す
+			if !hasher.NeedsRehash(tt.encoded) {
+				t.Error("NeedsRehash = false")
+			}
+		})
+	}
+}
+
+func TestPasswordHashAlgorithmErrors(t *testing.T) {
+	tests := map[string]string{
+		"空":                          "",
+		"平文":                         "correct horse",
+		"MD5 crypt":                  "$1$saltsalt$2vnaRpHa6Jxjz5n83ok8Z0",
+		"bcrypt の cost が大きい":         "$2a$17$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+		"bcrypt の cost が小さい":         "$2a$03$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i",
+		"bcrypt が短い":                 "$2a$06$If6bvum7DFjUnE9p2uDeDu",
+		"pbkdf2-sha1 の識別子":           "$pbkdf2-sha1$1000$8PHy8/T19vf4.fr7/P3./w$.5QX0J046SbyM4zUrpWK1an1PdA",
+		"pbkdf2 の未知の digest":         "$pbkdf2-md5$1000$8PHy8/T19vf4.fr7/P3./w$.5QX0J046SbyM4zUrpWK1an1PdA",
+		"pbkdf2 の要素が足りない":            "$pbkdf2-sha256$1000$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU",
+		"pbkdf2 の rounds が 0":        "$pbkdf2-sha256$0$8PHy8/T19vf4.fr7/P3./w$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU",
+		"pbkdf2 の rounds が大きい":       "$pbkdf2-sha256$10000001$8PHy8/T19vf4.fr7/P3./w$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU",
+		"pbkdf2 の rounds が数値でない":     "$pbkdf2-sha256$1e3$8PHy8/T19vf4.fr7/P3./w$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU",
+		"pbkdf2 の salt が空":           "$pbkdf2-sha256$1000$$JwgcbJZlHBoE0uPBMy9SZsCsIJXsYLx/8XwH97PVwMU",
+		"pbkdf2 の hash が短い":          "$pbkdf2-sha256$1000$8PHy8/T19vf4.fr7/P3./w$JwgcbJZlHBoE0uPBMy9S",
+		"pbkdf2 の hash が base64 でない": "$pbkdf2-sha256$1000$8PHy8/T19vf4.fr7/P3./w$!!!!",
+		"scrypt の ln が 0":            "$scrypt$ln=0,r=8,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw",
+		"scrypt の ln が大きい":           "$scrypt$ln=25,r=1,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw",
+		"scrypt のメモリが大きい":            "$scrypt$ln=21,r=8,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw",
+		"scrypt の p が大きい":            "$scrypt$ln=10,r=8,p=17$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw",
+		"scrypt のパラメータの形式":           "$scrypt$n=1024,r=8,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKlENqV7JZ3kUPnS38wnsDB+xWehuw",
+		"scrypt の hash が短い":          "$scrypt$ln=10,r=8,p=1$8PHy8/T19vf4+fr7/P3+/w$2rA3VVxBi7JUdeKl",
+		"argon2id の version":         "$argon2id$v=16$m=1024,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$RdescudvJCsgt3ub+b+dWRWJTmaaJObGRdescudvJCs",
+		"argon2id のメモリが大きい":          "$argon2id$v=19$m=2097152,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$RdescudvJCsgt3ub+b+dWRWJTmaaJObGRdescudvJCs",
+		"argon2id の time が大きい":       "$argon2id$v=19$m=1024,t=17,p=1$c29tZXNhbHRzb21lc2FsdA$RdescudvJCsgt3ub+b+dWRWJTmaaJObGRdescudvJCs",
+		"argon2id の hash が短い":        "$argon2id$v=19$m=1024,t=1,p=1$c29tZXNhbHRzb21lc2FsdA$RdescudvJCsgt3ub",
+	}
+	hasher := newTestPasswordHasher(t)
+	for name, encoded := range tests {
+		t.Run(name, func(t *testing.T) {
+			if algorithm, err := PasswordHashAlgorithm(encoded); err == nil {
+				t.Errorf("algorithm = %s", algorithm)
+			}
+			if ok, err := hasher.VerifyPassword(context.Background(), testPassword, encoded); ok || err == nil {
+				t.Errorf("ok = %v, err = %v", ok, err)
+			}
+		})
+	}
+}
+
+func newTestPasswordHasher(t *testing.T) *PasswordHasher {
+	h, err := NewPasswordHasher(Argon2Params{Memory: 1024, Time: 1, Threads: 1}, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h
+}
+
+func TestPasswordHasher(t *testing.T) {
+	hasher := newTestPasswordHasher(t)
+	encoded, err := hasher.HashPassword(context.Background(), testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(encoded, "$argon2id$v=19$m=1024,t=1,p=1$") {
+		t.Errorf("encoded = %s", encoded)
+	}
+	if ok, err := hasher.VerifyPassword(context.Background(), testPassword, encoded); !ok || err != nil {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+	if ok, _ := hasher.VerifyPassword(context.Background(), "wrong", encoded); ok {
+		t.Error("wrong password accepted")
+	}
+	if hasher.NeedsRehash(encoded) {
+		t.Error("NeedsRehash = true for current parameters")
+	}
+
+	// 設定より弱いパラメータのハッシュは作り直し、強いハッシュは作り直さない
+	stronger, _ := NewPasswordHasher(Argon2Params{Memory: 2048, Time: 1, Threads: 1}, 1)
+	if !stronger.NeedsRehash(encoded) {
+		t.Error("NeedsRehash = false for weaker parameters")
+	}
+	if ok, _ := stronger.VerifyPassword(context.Background(), testPassword, encoded); !ok {
+		t.Error("hash with old parameters was not verified")
+	}
+	strongerHash, _ := stronger.HashPassword(context.Background(), testPassword)
+	if hasher.NeedsRehash(strongerHash) {
+		t.Error("NeedsRehash = true for stronger parameters")
+	}
+	if !hasher.NeedsRehash("invalid") {
+		t.Error("NeedsRehash = false for invalid hash")
+	}
+}
+
+func TestPasswordHasherWait(t *testing.T) {
+	hasher := newTestPasswordHasher(t)
+	hasher.maxWait = 50 * time.Millisecond
+	// 上限まで計算中の状態にする
+	for range cap(hasher.sem) {
+		hasher.sem <- struct{}{}
+	}
+
+	if _, err := hasher.HashPassword(context.Background(), testPassword); !errors.Is(err, ErrPasswordHasherBusy) {
+		t.Errorf("hash: err = %v, want ErrPasswordHasherBusy", err)
+	}
+	encoded := foreignHashVectors[0].encoded
+	if _, err := hasher.VerifyPassword(context.Background(), "abc", encoded); !errors.Is(err, ErrPasswordHasherBusy) {
+		t.Errorf("verify: err = %v, want ErrPasswordHasherBusy", err)
+	}
+
+	// 待ち時間より先にリクエストが終わった場合は ctx のエラーを返す
+	hasher.maxWait = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := hasher.HashPassword(ctx, testPassword); !errors.Is(err, context.Canceled) {
+		t.Errorf("canceled: err = %v", err)
+	}
+
+	// 空きができれば待っていた計算を始める
+	done := make(chan error, 1)
+	go func() {
+		_, err := hasher.HashPassword(context.Background(), testPassword)
+		done <- err
+	}()
+	<-hasher.sem
+	if err := <-done; err != nil {
+		t.Errorf("after release: err = %v", err)
+	}
+	if len(hasher.sem) != cap(hasher.sem)-1 {
+		t.Errorf("slots in use = %d, want %d", len(hasher.sem), cap(hasher.sem)-1)
+	}
+}
+
+func TestNewPasswordHasherValidation(t *testing.T) {
+	for name, params := range map[string]Argon2Params{
+		"threads が 0": {Memory: 1024, Time: 1, Threads: 0},
+		"time が 0":    {Memory: 1024, Time: 0, Threads: 1},
+		"time が大きい":   {Memory: 1024, Time: 17, Threads: 1},
+		"memory が小さい": {Memory: 31, Time: 1, Threads: 4},
+		"memory が大きい": {Memory: maxArgon2Memory + 1, Time: 1, Threads: 1},
+	} {
+		if _, err := NewPasswordHasher(params, 1); err == nil {
+			t.Errorf("%s: accepted", name)
+		}
+	}
+	if _, err := NewPasswordHasher(DefaultArgon2Params(), 0); err == nil {
+		t.Error("maxConcurrent 0 accepted")
+	}
+}
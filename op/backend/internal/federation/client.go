@@ -0,0 +1,322 @@
+// Package federation は上流の OIDC Provider との認可コードフロー (auth.UpstreamOIDCClient) を実装する。
+package federation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lestrrat-go/httprc/v3"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// metadataCacheTTL は上流の Discovery メタデータをキャッシュする期間
+	metadataCacheTTL = time.Hour
+	// jwksCacheTTL は上流の JWKS をキャッシュする期間。期限が来るとバックグラウンドで取得し直す
+	jwksCacheTTL = time.Hour
+	// jwksRefetchInterval は未知の kid による JWKS の取得し直しの最短間隔
+	jwksRefetchInterval = time.Minute
+	// maxResponseBytes は上流から読み込むレスポンスの上限
+	maxResponseBytes = 1024 * 1024
+	// idTokenSkew は ID トークンの exp / iat 検証で許容する時計のずれ
+	idTokenSkew = 30 * time.Second
+)
+
+// jwksFetchTimeout は JWKS の取得を待つ時間の上限。
+// キャッシュは取得に成功するまで準備完了にならないため、取得に失敗した場合はこの時間が経ってからエラーになる
+var jwksFetchTimeout = 10 * time.Second
+
+// idTokenSigningAlgsSupported は上流の ID トークンとして受け付ける署名アルゴリズム。
+// client_secret を鍵にする HS* と署名なし (none) は受け付けない
+var idTokenSigningAlgsSupported = map[string]bool{
+	"RS256": true, "RS384": true, "RS512": true,
+	"PS256": true, "PS384": true, "PS512": true,
+	"ES256": true, "ES384": true, "ES512": true,
+	"EdDSA": true,
+}
+
+// ErrUpstream は上流との通信や上流が返した値の検証に失敗したことを示す
+var ErrUpstream = errors.New("upstream identity provider error")
+
+// providerMetadata は上流の Discovery メタデータのうち使う項目 (OIDC Discovery 1.0 Section 3)
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+type cachedMetadata struct {
+	metadata  *providerMetadata
+	fetchedAt time.Time
+}
+
+// Client は上流の OIDC Provider の Discovery・トークンエンドポイント・JWKS を呼び出す。
+type Client struct {
+	httpClient *http.Client
+
+	mu       sync.Mutex
+	metadata map[string]cachedMetadata
+
+	// jwksMu は JWKS の取得を直列化する。jwks は最初に使うときに開始する
+	jwksMu        sync.Mutex
+	jwks          *jwk.Cache
+	jwksFetchedAt map[string]time.Time
+}
+
+// NewClient は Client を生成する。
+func NewClient() *Client {
+	return &Client{
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+		metadata:   map[string]cachedMetadata{},
+
+		jwksFetchedAt: map[string]time.Time{},
+	}
+}
+
+// AuthorizationURL は上流の認可エンドポイントに params を付けた URL を返す。
+func (c *Client) AuthorizationURL(ctx context.Context, issuer string, params url.Values) (string, error) {
+	meta, err := c.discover(ctx, issuer)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(meta.AuthorizationEndpoint)
+	if err != nil {
+		return "", fmt.Errorf("%w: invalid authorization_endpoint", ErrUpstream)
+	}
+	q := u.Query()
+	for name, values := range params {
+		q[name] = values
+	}
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// ExchangeCode は認可コードをトークンに交換し、検証済みの ID トークンのクレームを返す。
+// 仕様参照: OIDC Core 1.0 Section 3.1.3
+func (c *Client) ExchangeCode(ctx context.Context, req *model.UpstreamTokenRequest) (map[string]interface{}, error) {
+	meta, err := c.discover(ctx, req.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	rawIDToken, err := c.requestToken(ctx, meta, req)
+	if err != nil {
+		return nil, err
+	}
+	return c.verifyIDToken(ctx, meta, req, rawIDToken)
+}
+
+// discover は {issuer}/.well-known/openid-configuration からメタデータを取得する。
+// 取得した issuer が設定値と完全に一致しない場合は使わない (MUST: OIDC Discovery 1.0 Section 4.3)
+func (c *Client) discover(ctx context.Context, issuer string) (*providerMetadata, error) {
+	c.mu.Lock()
+	cached, ok := c.metadata[issuer]
+	c.mu.Unlock()
+	if ok && time.Since(cached.fetchedAt) < metadataCacheTTL {
+		return cached.metadata, nil
+	}
+
+	var meta providerMetadata
+	if err := c.getJSON(ctx, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &meta); err != nil {
+		return nil, err
+	}
+	if meta.Issuer != issuer {
+		return nil, fmt.Errorf("%w: issuer in discovery document does not match", ErrUpstream)
+	}
+	if meta.AuthorizationEndpoint == "" || meta.TokenEndpoint == "" || meta.JWKSURI == "" {
+		return nil, fmt.Errorf("%w: discovery document is missing required endpoints", ErrUpstream)
+	}
+
+	c.mu.Lock()
+	c.metadata[issuer] = cachedMetadata{metadata: &meta, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return &meta, nil
+}
+
+// requestToken はトークンエンドポイントで認可コードを交換し、ID トークンを返す。
+// client_secret がある場合は client_secret_basic で認証する (RFC 6749 Section 2.3.1)
+func (c *Client) requestToken(ctx context.Context, meta *providerMetadata, req *model.UpstreamTokenRequest) (string, error) {
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {req.Code},
+		"redirect_uri":  {req.RedirectURI},
+		"code_verifier": {req.CodeVerifier},
+	}
+	if req.ClientSecret == "" {
+		form.Set("client_id", req.ClientID)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, meta.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	httpReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	httpReq.Header.Set("Accept", "application/json")
+	if req.ClientSecret != "" {
+		httpReq.SetBasicAuth(url.QueryEscape(req.ClientID), url.QueryEscape(req.ClientSecret))
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return "", fmt.Errorf("%w: token request failed: %v", ErrUpstream, err)
+	}
+	defer resp.Body.Close()
+
+	var body struct {
+		IDToken string `json:"id_token"`
+		Error   string `json:"error"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&body); err != nil {
+		return "", fmt.Errorf("%w: invalid token response (status %d)", ErrUpstream, resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%w: token endpoint returned status %d: %s", ErrUpstream, resp.StatusCode, body.Error)
+	}
+	if body.IDToken == "" {
+		return "", fmt.Errorf("%w: token response has no id_token", ErrUpstream)
+	}
+	return body.IDToken, nil
+}
+
+// verifyIDToken は ID トークンを上流の JWKS で検証し、クレームを返す。
+// iss・aud・azp・exp・iat・nonce を検証する (OIDC Core 1.0 Section 3.1.3.7)
+func (c *Client) verifyIDToken(ctx context.Context, meta *providerMetadata, req *model.UpstreamTokenRequest, raw string) (map[string]interface{}, error) {
+	msg, err := jws.Parse([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("%w: id_token must be a signed JWT", ErrUpstream)
+	}
+	sigs := msg.Signatures()
+	if len(sigs) != 1 {
+		return nil, fmt.Errorf("%w: id_token must have exactly one signature", ErrUpstream)
+	}
+	alg, ok := sigs[0].ProtectedHeaders().Algorithm()
+	if !ok || !idTokenSigningAlgsSupported[alg.String()] {
+		return nil, fmt.Errorf("%w: unsupported id_token signing algorithm", ErrUpstream)
+	}
+
+	kid, _ := sigs[0].ProtectedHeaders().KeyID()
+	set, err := c.keySet(ctx, meta.JWKSURI, kid)
+	if err != nil {
+		return nil, fmt.Errorf("%w: failed to fetch jwks: %v", ErrUpstream, err)
+	}
+
+	token, err := jwt.Parse([]byte(raw),
+		jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithIssuer(req.Issuer),
+		jwt.WithAudience(req.ClientID),
+		jwt.WithAcceptableSkew(idTokenSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("%w: id_token verification failed: %v", ErrUpstream, err)
+	}
+	if _, ok := token.Expiration(); !ok {
+		return nil, fmt.Errorf("%w: id_token must have exp", ErrUpstream)
+	}
+	if _, ok := token.IssuedAt(); !ok {
+		return nil, fmt.Errorf("%w: id_token must have iat", ErrUpstream)
+	}
+	if sub, ok := token.Subject(); !ok || sub == "" {
+		return nil, fmt.Errorf("%w: id_token must have sub", ErrUpstream)
+	}
+
+	// aud が複数の場合、azp は自身の client_id であること
+	if aud, _ := token.Audience(); len(aud) > 1 {
+		var azp string
+		if err := token.Get("azp", &azp); err != nil || azp != req.ClientID {
+			return nil, fmt.Errorf("%w: azp does not match client_id", ErrUpstream)
+		}
+	}
+
+	var nonce string
+	if err := token.Get("nonce", &nonce); err != nil || nonce != req.Nonce {
+		return nil, fmt.Errorf("%w: nonce does not match", ErrUpstream)
+	}
+
+	claims := map[string]interface{}{}
+	for _, name := range token.Keys() {
+		var v interface{}
+		if err := token.Get(name, &v); err != nil {
+			return nil, fmt.Errorf("%w: failed to read claim %s: %v", ErrUpstream, name, err)
+		}
+		claims[name] = v
+	}
+	return claims, nil
+}
+
+// keySet は上流の JWKS をキャッシュから返す。
+// kid がキャッシュに無い場合は上流が鍵をローテーションしたとみなして取得し直す (OIDC Core 1.0 Section 10.1.1)
+func (c *Client) keySet(ctx context.Context, jwksURI, kid string) (jwk.Set, error) {
+	c.jwksMu.Lock()
+	defer c.jwksMu.Unlock()
+
+	if c.jwks == nil {
+		// キャッシュの取得処理はリクエストをまたいで動くため、リクエストのコンテキストでは開始しない
+		cache, err := jwk.NewCache(context.Background(), httprc.NewClient())
+		if err != nil {
+			return nil, err
+		}
+		c.jwks = cache
+	}
+
+	if set, err := c.jwks.Lookup(ctx, jwksURI); err == nil {
+		if _, ok := set.LookupKeyID(kid); ok || kid == "" {
+			return set, nil
+		}
+		// 未知の kid を使った ID トークンで上流へのリクエストを増やさないよう、取得し直しは間隔を空ける
+		if time.Since(c.jwksFetchedAt[jwksURI]) < jwksRefetchInterval {
+			return set, nil
+		}
+	}
+
+	// 取得し直しは登録し直して行う。httprc の Refresh は取得に失敗するとワーカーが停止するため使わない
+	if c.jwks.IsRegistered(ctx, jwksURI) {
+		if err := c.jwks.Unregister(ctx, jwksURI); err != nil {
+			return nil, err
+		}
+	}
+	fetchCtx, cancel := context.WithTimeout(ctx, jwksFetchTimeout)
+	defer cancel()
+	if err := c.jwks.Register(fetchCtx, jwksURI,
+		jwk.WithHTTPClient(c.httpClient),
+		jwk.WithConstantInterval(jwksCacheTTL),
+	); err != nil {
+		// 取得できなかった JWKS をバックグラウンドで取得し続けないよう登録を外す
+		_ = c.jwks.Unregister(ctx, jwksURI)
+		return nil, err
+	}
+	c.jwksFetchedAt[jwksURI] = time.Now()
+	return c.jwks.Lookup(ctx, jwksURI)
+}
+
+func (c *Client) getJSON(ctx context.Context, uri string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("%w: failed to fetch %s: %v", ErrUpstream, uri, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: %s returned status %d", ErrUpstream, uri, resp.StatusCode)
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(v); err != nil {
+		return fmt.Errorf("%w: invalid json from %s: %v", ErrUpstream, uri, err)
+	}
+	return nil
+}
@@ -0,0 +1,293 @@
+package federation
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// testUpstream は Discovery・トークンエンドポイント・JWKS を返す上流の OIDC Provider
+type testUpstream struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+	// kid は JWKS で公開する鍵と ID トークンのヘッダの kid
+	kid string
+	// jwksRequests は JWKS の取得回数
+	jwksRequests int
+	// jwksStatus が 0 以外の場合、JWKS エンドポイントはこのステータスを返す
+	jwksStatus int
+	// issuer は Discovery で返す issuer。空の場合はサーバーの URL
+	issuer string
+	// idToken はトークンエンドポイントが返す ID トークン
+	idToken string
+	// tokenForm・tokenAuth はトークンエンドポイントが受け取ったリクエスト
+	tokenForm url.Values
+	tokenAuth [2]string
+}
+
+func newTestUpstream(t *testing.T) *testUpstream {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u := &testUpstream{key: key, kid: "upstream-key"}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		issuer := u.issuer
+		if issuer == "" {
+			issuer = u.URL
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 issuer,
+			"authorization_endpoint": u.URL + "/authorize",
+			"token_endpoint":         u.URL + "/token",
+			"jwks_uri":               u.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = r.ParseForm()
+		u.tokenForm = r.PostForm
+		u.tokenAuth[0], u.tokenAuth[1], _ = r.BasicAuth()
+		w.Header().Set("Content-Type", "application/json")
+		if u.idToken == "" {
+			w.WriteHeader(http.StatusBadRequest)
+			_ = json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]string{"access_token": "at", "token_type": "Bearer", "id_token": u.idToken})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		u.jwksRequests++
+		if u.jwksStatus != 0 {
+			w.WriteHeader(u.jwksStatus)
+			return
+		}
+		pub, _ := jwk.Import(&u.key.PublicKey)
+		_ = pub.Set(jwk.KeyIDKey, u.kid)
+		set := jwk.NewSet()
+		_ = set.AddKey(pub)
+		_ = json.NewEncoder(w).Encode(set)
+	})
+	u.Server = httptest.NewServer(mux)
+	t.Cleanup(u.Close)
+	return u
+}
+
+// claims は正しい ID トークンのクレームを返す
+func (u *testUpstream) claims() map[string]interface{} {
+	return map[string]interface{}{
+		"iss":   u.URL,
+		"aud":   "op-client",
+		"sub":   "upstream-user",
+		"nonce": "n-0S6_WzA2Mj",
+		"iat":   time.Now().Unix(),
+		"exp":   time.Now().Add(5 * time.Minute).Unix(),
+		"email": "alice@example.com",
+	}
+}
+
+func (u *testUpstream) sign(t *testing.T, claims map[string]interface{}, alg jwa.SignatureAlgorithm, key interface{}) string {
+	t.Helper()
+	token := jwt.New()
+	for name, v := range claims {
+		if err := token.Set(name, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, u.kid)
+	signed, err := jwt.Sign(token, jwt.WithKey(alg, key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+func (u *testUpstream) tokenRequest() *model.UpstreamTokenRequest {
+	return &model.UpstreamTokenRequest{
+		Issuer:       u.URL,
+		ClientID:     "op-client",
+		ClientSecret: "s3cret",
+		RedirectURI:  "https://op.example.com/internal/federation/upstream/callback",
+		Code:         "upstream-code",
+		CodeVerifier: "verifier",
+		Nonce:        "n-0S6_WzA2Mj",
+	}
+}
+
+func TestExchangeCode(t *testing.T) {
+	u := newTestUpstream(t)
+	u.idToken = u.sign(t, u.claims(), jwa.RS256(), u.key)
+
+	claims, err := NewClient().ExchangeCode(context.Background(), u.tokenRequest())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims["sub"] != "upstream-user" || claims["email"] != "alice@example.com" {
+		t.Errorf("claims = %v", claims)
+	}
+	// client_secret_basic で認証し、PKCE の code_verifier を送る
+	if u.tokenAuth != [2]string{"op-client", "s3cret"} || u.tokenForm.Get("client_id") != "" {
+		t.Errorf("auth = %v, form = %v", u.tokenAuth, u.tokenForm)
+	}
+	if u.tokenForm.Get("code") != "upstream-code" || u.tokenForm.Get("code_verifier") != "verifier" || u.tokenForm.Get("grant_type") != "authorization_code" {
+		t.Errorf("form = %v", u.tokenForm)
+	}
+
+	// 公開クライアントは client_id をフォームで送る
+	req := u.tokenRequest()
+	req.ClientSecret = ""
+	if _, err := NewClient().ExchangeCode(context.Background(), req); err != nil || u.tokenForm.Get("client_id") != "op-client" || u.tokenAuth[0] != "" {
+		t.Errorf("public client: err = %v, form = %v", err, u.tokenForm)
+	}
+}
+
+func TestExchangeCodeRejectsIDToken(t *testing.T) {
+	u := newTestUpstream(t)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	with := func(name string, v interface{}) map[string]interface{} {
+		c := u.claims()
+		if v == nil {
+			delete(c, name)
+		} else {
+			c[name] = v
+		}
+		return c
+	}
+
+	tests := []struct {
+		name    string
+		idToken func() string
+	}{
+		{name: "iss が異なる", idToken: func() string { return u.sign(t, with("iss", "https://evil.example.com"), jwa.RS256(), u.key) }},
+		{name: "aud が異なる", idToken: func() string { return u.sign(t, with("aud", "other-client"), jwa.RS256(), u.key) }},
+		{name: "aud が複数で azp なし", idToken: func() string {
+			return u.sign(t, with("aud", []string{"op-client", "other-client"}), jwa.RS256(), u.key)
+		}},
+		{name: "azp が異なる", idToken: func() string {
+			c := with("aud", []string{"op-client", "other-client"})
+			c["azp"] = "other-client"
+			return u.sign(t, c, jwa.RS256(), u.key)
+		}},
+		{name: "nonce が異なる", idToken: func() string { return u.sign(t, with("nonce", "replayed"), jwa.RS256(), u.key) }},
+		{name: "nonce なし", idToken: func() string { return u.sign(t, with("nonce", nil), jwa.RS256(), u.key) }},
+		{name: "期限切れ", idToken: func() string { return u.sign(t, with("exp", time.Now().Add(-time.Hour).Unix()), jwa.RS256(), u.key) }},
+		{name: "exp なし", idToken: func() string { return u.sign(t, with("exp", nil), jwa.RS256(), u.key) }},
+		{name: "iat なし", idToken: func() string { return u.sign(t, with("iat", nil), jwa.RS256(), u.key) }},
+		{name: "sub なし", idToken: func() string { return u.sign(t, with("sub", nil), jwa.RS256(), u.key) }},
+		{name: "JWKS にない鍵で署名", idToken: func() string { return u.sign(t, u.claims(), jwa.RS256(), otherKey) }},
+		{name: "client_secret を鍵にした HS256", idToken: func() string { return u.sign(t, u.claims(), jwa.HS256(), []byte("s3cret-s3cret-s3cret-s3cret-s3cr")) }},
+		{name: "JWT でない", idToken: func() string { return "not-a-jwt" }},
+		{name: "トークンエンドポイントのエラー", idToken: func() string { return "" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u.idToken = tt.idToken()
+			if _, err := NewClient().ExchangeCode(context.Background(), u.tokenRequest()); !errors.Is(err, ErrUpstream) {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+
+	// 自身の client_id を azp に持つ複数の aud は受け付ける
+	c := with("aud", []string{"op-client", "other-client"})
+	c["azp"] = "op-client"
+	u.idToken = u.sign(t, c, jwa.RS256(), u.key)
+	if _, err := NewClient().ExchangeCode(context.Background(), u.tokenRequest()); err != nil {
+		t.Errorf("azp: err = %v", err)
+	}
+}
+
+func TestJWKSCache(t *testing.T) {
+	u := newTestUpstream(t)
+	c := NewClient()
+
+	// 2回目以降はキャッシュした JWKS で検証する
+	for i := 0; i < 2; i++ {
+		u.idToken = u.sign(t, u.claims(), jwa.RS256(), u.key)
+		if _, err := c.ExchangeCode(context.Background(), u.tokenRequest()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if u.jwksRequests != 1 {
+		t.Fatalf("jwks requests = %d, want 1", u.jwksRequests)
+	}
+
+	// 上流が鍵をローテーションした直後は、取得し直しの最短間隔が過ぎるまで取得しない
+	rotated, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	u.key, u.kid = rotated, "rotated-key"
+	u.idToken = u.sign(t, u.claims(), jwa.RS256(), u.key)
+	if _, err := c.ExchangeCode(context.Background(), u.tokenRequest()); !errors.Is(err, ErrUpstream) || u.jwksRequests != 1 {
+		t.Fatalf("within refetch interval: err = %v, jwks requests = %d", err, u.jwksRequests)
+	}
+
+	// 最短間隔が過ぎていれば未知の kid で取得し直す
+	jwksURI := u.URL + "/jwks"
+	c.jwksFetchedAt[jwksURI] = time.Now().Add(-jwksRefetchInterval)
+	if _, err := c.ExchangeCode(context.Background(), u.tokenRequest()); err != nil {
+		t.Fatalf("after rotation: %v", err)
+	}
+	if u.jwksRequests != 2 {
+		t.Errorf("jwks requests = %d, want 2", u.jwksRequests)
+	}
+}
+
+func TestJWKSFetchFailure(t *testing.T) {
+	orig := jwksFetchTimeout
+	jwksFetchTimeout = 200 * time.Millisecond
+	defer func() { jwksFetchTimeout = orig }()
+
+	u := newTestUpstream(t)
+	c := NewClient()
+	u.idToken = u.sign(t, u.claims(), jwa.RS256(), u.key)
+
+	u.jwksStatus = http.StatusInternalServerError
+	if _, err := c.ExchangeCode(context.Background(), u.tokenRequest()); !errors.Is(err, ErrUpstream) {
+		t.Fatalf("err = %v", err)
+	}
+
+	// 取得に失敗した JWKS はキャッシュに残らず、次の callback で取得し直す
+	u.jwksStatus = 0
+	if _, err := c.ExchangeCode(context.Background(), u.tokenRequest()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	u := newTestUpstream(t)
+
+	authURL, err := NewClient().AuthorizationURL(context.Background(), u.URL, url.Values{"state": {"xyz"}, "client_id": {"op-client"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(authURL, u.URL+"/authorize?") || !strings.Contains(authURL, "state=xyz") {
+		t.Errorf("authURL = %s", authURL)
+	}
+
+	// Discovery の issuer が設定値と完全に一致しない場合は使わない
+	for _, issuer := range []string{u.URL + "/", "https://evil.example.com"} {
+		u.issuer = issuer
+		if _, err := NewClient().AuthorizationURL(context.Background(), u.URL, nil); !errors.Is(err, ErrUpstream) {
+			t.Errorf("issuer %s: err = %v", issuer, err)
+		}
+	}
+}
@@ -0,0 +1,319 @@
+// Package jsonschema は authorization_details の型定義とユーザーのカスタム属性の定義に使う JSON Schema (draft 2020-12) のサブセットを実装する。
+// 対応するキーワード: type, enum, const, required, properties, additionalProperties, items,
+// minItems, maxItems, minLength, maxLength, pattern, minimum, maximum。
+// 注釈 ($schema, $id, title, description, examples) は無視する。それ以外のキーワードは登録時にエラーにする。
+package jsonschema
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"sort"
+	"unicode/utf8"
+)
+
+// Schema は検証済みのスキーマ
+type Schema struct {
+	types                []string
+	enum                 []interface{}
+	constValue           interface{}
+	hasConst             bool
+	required             []string
+	properties           map[string]*Schema
+	additionalProperties *Schema
+	noAdditional         bool
+	items                *Schema
+	minItems, maxItems   *int
+	minLength, maxLength *int
+	pattern              *regexp.Regexp
+	minimum, maximum     *float64
+}
+
+var annotationKeywords = map[string]bool{
+	"$schema": true, "$id": true, "title": true, "description": true, "examples": true,
+}
+
+var validTypes = map[string]bool{
+	"object": true, "array": true, "string": true, "number": true, "integer": true, "boolean": true, "null": true,
+}
+
+// Parse は JSON Schema を解析する。未対応のキーワードを含む場合はエラーを返す
+func Parse(raw []byte) (*Schema, error) {
+	var v interface{}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("schema must be valid JSON")
+	}
+	return compile(v, "#")
+}
+
+func compile(v interface{}, path string) (*Schema, error) {
+	m, ok := v.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("%s: schema must be an object", path)
+	}
+
+	s := &Schema{}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		value := m[k]
+		at := path + "/" + k
+		switch k {
+		case "type":
+			switch t := value.(type) {
+			case string:
+				s.types = []string{t}
+			case []interface{}:
+				for _, item := range t {
+					name, ok := item.(string)
+					if !ok {
+						return nil, fmt.Errorf("%s: must be a string or an array of strings", at)
+					}
+					s.types = append(s.types, name)
+				}
+			default:
+				return nil, fmt.Errorf("%s: must be a string or an array of strings", at)
+			}
+			for _, t := range s.types {
+				if !validTypes[t] {
+					return nil, fmt.Errorf("%s: unknown type %q", at, t)
+				}
+			}
+		case "enum":
+			values, ok := value.([]interface{})
+			if !ok || len(values) == 0 {
+				return nil, fmt.Errorf("%s: must be a non-empty array", at)
+			}
+			s.enum = values
+		case "const":
+			s.constValue, s.hasConst = value, true
+		case "required":
+			values, ok := value.([]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: must be an array of strings", at)
+			}
+			for _, item := range values {
+				name, ok := item.(string)
+				if !ok {
+					return nil, fmt.Errorf("%s: must be an array of strings", at)
+				}
+				s.required = append(s.required, name)
+			}
+		case "properties":
+			props, ok := value.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("%s: must be an object", at)
+			}
+			s.properties = make(map[string]*Schema, len(props))
+			for name, sub := range props {
+				compiled, err := compile(sub, at+"/"+name)
+				if err != nil {
+					return nil, err
+				}
+				s.properties[name] = compiled
+			}
+		case "additionalProperties":
+			if b, ok := value.(bool); ok {
+				s.noAdditional = !b
+				continue
+			}
+			compiled, err := compile(value, at)
+			if err != nil {
+				return nil, err
+			}
+			s.additionalProperties = compiled
+		case "items":
+			compiled, err := compile(value, at)
+			if err != nil {
+				return nil, err
+			}
+			s.items = compiled
+		case "minItems", "maxItems", "minLength", "maxLength":
+			n, ok := nonNegativeInt(value)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a non-negative integer", at)
+			}
+			switch k {
+			case "minItems":
+				s.minItems = &n
+			case "maxItems":
+				s.maxItems = &n
+			case "minLength":
+				s.minLength = &n
+			case "maxLength":
+				s.maxLength = &n
+			}
+		case "pattern":
+			p, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a string", at)
+			}
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid regular expression", at)
+			}
+			s.pattern = re
+		case "minimum", "maximum":
+			n, ok := value.(float64)
+			if !ok {
+				return nil, fmt.Errorf("%s: must be a number", at)
+			}
+			if k == "minimum" {
+				s.minimum = &n
+			} else {
+				s.maximum = &n
+			}
+		default:
+			if !annotationKeywords[k] {
+				return nil, fmt.Errorf("%s: unsupported keyword", at)
+			}
+		}
+	}
+	return s, nil
+}
+
+func nonNegativeInt(v interface{}) (int, bool) {
+	n, ok := v.(float64)
+	if !ok || n < 0 || n != math.Trunc(n) {
+		return 0, false
+	}
+	return int(n), true
+}
+
+// Validate は encoding/json でデコードした値がスキーマに適合するか検証する
+func (s *Schema) Validate(instance interface{}) error {
+	return s.validate(instance, "")
+}
+
+func (s *Schema) validate(v interface{}, path string) error {
+	at := path
+	if at == "" {
+		at = "(root)"
+	}
+
+	if len(s.types) > 0 && !s.matchesType(v) {
+		return fmt.Errorf("%s: must be of type %v", at, s.types)
+	}
+	if s.hasConst && !jsonEqual(s.constValue, v) {
+		return fmt.Errorf("%s: must be %v", at, s.constValue)
+	}
+	if s.enum != nil {
+		found := false
+		for _, e := range s.enum {
+			if jsonEqual(e, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s: must be one of %v", at, s.enum)
+		}
+	}
+
+	switch value := v.(type) {
+	case map[string]interface{}:
+		for _, name := range s.required {
+			if _, ok := value[name]; !ok {
+				return fmt.Errorf("%s: %s is required", at, name)
+			}
+		}
+		names := make([]string, 0, len(value))
+		for name := range value {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			sub, ok := s.properties[name]
+			switch {
+			case ok:
+			case s.additionalProperties != nil:
+				sub = s.additionalProperties
+			case s.noAdditional:
+				return fmt.Errorf("%s: %s is not allowed", at, name)
+			default:
+				continue
+			}
+			if err := sub.validate(value[name], path+"/"+name); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		if s.minItems != nil && len(value) < *s.minItems {
+			return fmt.Errorf("%s: must have at least %d items", at, *s.minItems)
+		}
+		if s.maxItems != nil && len(value) > *s.maxItems {
+			return fmt.Errorf("%s: must have at most %d items", at, *s.maxItems)
+		}
+		if s.items != nil {
+			for i, item := range value {
+				if err := s.items.validate(item, fmt.Sprintf("%s/%d", path, i)); err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		n := utf8.RuneCountInString(value)
+		if s.minLength != nil && n < *s.minLength {
+			return fmt.Errorf("%s: must be at least %d characters", at, *s.minLength)
+		}
+		if s.maxLength != nil && n > *s.maxLength {
+			return fmt.Errorf("%s: must be at most %d characters", at, *s.maxLength)
+		}
+		if s.pattern != nil && !s.pattern.MatchString(value) {
+			return fmt.Errorf("%s: must match pattern %s", at, s.pattern.String())
+		}
+	case float64:
+		if s.minimum != nil && value < *s.minimum {
+			return fmt.Errorf("%s: must be >= %v", at, *s.minimum)
+		}
+		if s.maximum != nil && value > *s.maximum {
+			return fmt.Errorf("%s: must be <= %v", at, *s.maximum)
+		}
+	}
+	return nil
+}
+
+func (s *Schema) matchesType(v interface{}) bool {
+	for _, t := range s.types {
+		switch value := v.(type) {
+		case map[string]interface{}:
+			if t == "object" {
+				return true
+			}
+		case []interface{}:
+			if t == "array" {
+				return true
+			}
+		case string:
+			if t == "string" {
+				return true
+			}
+		case float64:
+			if t == "number" || (t == "integer" && value == math.Trunc(value)) {
+				return true
+			}
+		case bool:
+			if t == "boolean" {
+				return true
+			}
+		case nil:
+			if t == "null" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// jsonEqual は JSON 表現で比較する (オブジェクトのキー順はエンコード時に揃う)
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
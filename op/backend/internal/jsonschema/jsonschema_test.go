@@ -0,0 +1,171 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// paymentSchema は authorization_details の型定義の例 (RFC 9396 Section 2 の payment_initiation)
+const paymentSchema = `{
+	"$schema": "https://json-schema.org/draft/2020-12/schema",
+	"title": "payment_initiation",
+	"type": "object",
+	"required": ["type", "instructedAmount"],
+	"properties": {
+		"type": {"const": "payment_initiation"},
+		"actions": {"type": "array", "items": {"enum": ["initiate", "status", "cancel"]}, "minItems": 1, "maxItems": 3},
+		"locations": {"type": "array", "items": {"type": "string", "pattern": "^https://"}},
+		"instructedAmount": {
+			"type": "object",
+			"required": ["currency", "amount"],
+			"properties": {
+				"currency": {"type": "string", "minLength": 3, "maxLength": 3},
+				"amount": {"type": "string", "pattern": "^[0-9]+(\\.[0-9]{1,2})?$"}
+			},
+			"additionalProperties": false
+		},
+		"creditorName": {"type": ["string", "null"]},
+		"priority": {"type": "integer", "minimum": 1, "maximum": 5}
+	},
+	"additionalProperties": {"type": "string"}
+}`
+
+func mustParse(t *testing.T, raw string) *Schema {
+	t.Helper()
+	s, err := Parse([]byte(raw))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	return s
+}
+
+func decode(t *testing.T, raw string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(raw), &v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestValidate(t *testing.T) {
+	schema := mustParse(t, paymentSchema)
+	tests := []struct {
+		name     string
+		instance string
+		wantErr  string
+	}{
+		{name: "適合", instance: `{"type":"payment_initiation","actions":["initiate"],"locations":["https://bank.example.com"],"instructedAmount":{"currency":"EUR","amount":"123.50"},"creditorName":null,"priority":3,"note":"x"}`},
+		{name: "最小の適合", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"}}`},
+		{name: "ルートの型", instance: `[]`, wantErr: "(root): must be of type [object]"},
+		{name: "required", instance: `{"type":"payment_initiation"}`, wantErr: "(root): instructedAmount is required"},
+		{name: "const", instance: `{"type":"account_information","instructedAmount":{"currency":"EUR","amount":"1"}}`, wantErr: "/type: must be payment_initiation"},
+		{name: "enum", instance: `{"type":"payment_initiation","actions":["refund"],"instructedAmount":{"currency":"EUR","amount":"1"}}`, wantErr: "/actions/0: must be one of"},
+		{name: "minItems", instance: `{"type":"payment_initiation","actions":[],"instructedAmount":{"currency":"EUR","amount":"1"}}`, wantErr: "/actions: must have at least 1 items"},
+		{name: "maxItems", instance: `{"type":"payment_initiation","actions":["initiate","status","cancel","status"],"instructedAmount":{"currency":"EUR","amount":"1"}}`, wantErr: "/actions: must have at most 3 items"},
+		{name: "items の pattern", instance: `{"type":"payment_initiation","locations":["http://bank.example.com"],"instructedAmount":{"currency":"EUR","amount":"1"}}`, wantErr: "/locations/0: must match pattern"},
+		{name: "入れ子の required", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR"}}`, wantErr: "/instructedAmount: amount is required"},
+		{name: "入れ子の型", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":1}}`, wantErr: "/instructedAmount/amount: must be of type [string]"},
+		{name: "入れ子の minLength", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EU","amount":"1"}}`, wantErr: "/instructedAmount/currency: must be at least 3 characters"},
+		{name: "maxLength は文字数で数える", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"円円円円","amount":"1"}}`, wantErr: "/instructedAmount/currency: must be at most 3 characters"},
+		{name: "additionalProperties: false", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1","fee":"0"}}`, wantErr: "/instructedAmount: fee is not allowed"},
+		{name: "additionalProperties のスキーマ", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"},"note":1}`, wantErr: "/note: must be of type [string]"},
+		{name: "複数の型", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"},"creditorName":1}`, wantErr: "/creditorName: must be of type [string null]"},
+		{name: "integer に小数", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"},"priority":1.5}`, wantErr: "/priority: must be of type [integer]"},
+		{name: "minimum", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"},"priority":0}`, wantErr: "/priority: must be >= 1"},
+		{name: "maximum", instance: `{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"},"priority":6}`, wantErr: "/priority: must be <= 5"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := schema.Validate(decode(t, tt.instance))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.HasPrefix(err.Error(), tt.wantErr) {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTypes(t *testing.T) {
+	tests := []struct {
+		schemaType string
+		valid      []string
+		invalid    []string
+	}{
+		{schemaType: "object", valid: []string{`{}`}, invalid: []string{`[]`, `null`, `"{}"`}},
+		{schemaType: "array", valid: []string{`[]`, `[1,"a"]`}, invalid: []string{`{}`, `"[]"`}},
+		{schemaType: "string", valid: []string{`""`, `"a"`}, invalid: []string{`1`, `null`}},
+		{schemaType: "number", valid: []string{`1`, `1.5`, `-0`}, invalid: []string{`"1"`, `true`}},
+		{schemaType: "integer", valid: []string{`1`, `-3`, `2.0`}, invalid: []string{`1.5`, `"1"`}},
+		{schemaType: "boolean", valid: []string{`true`, `false`}, invalid: []string{`0`, `"true"`}},
+		{schemaType: "null", valid: []string{`null`}, invalid: []string{`0`, `""`, `{}`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schemaType, func(t *testing.T) {
+			schema := mustParse(t, `{"type":"`+tt.schemaType+`"}`)
+			for _, v := range tt.valid {
+				if err := schema.Validate(decode(t, v)); err != nil {
+					t.Errorf("%s: unexpected error: %v", v, err)
+				}
+			}
+			for _, v := range tt.invalid {
+				if err := schema.Validate(decode(t, v)); err == nil {
+					t.Errorf("%s: accepted", v)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateEnumComparesJSON(t *testing.T) {
+	// enum と const はオブジェクトのキー順・数値の表記によらず JSON の値で比較する
+	schema := mustParse(t, `{"enum":[{"a":1,"b":[true,null]},"x",2]}`)
+	for _, v := range []string{`{"b":[true,null],"a":1}`, `"x"`, `2.0`} {
+		if err := schema.Validate(decode(t, v)); err != nil {
+			t.Errorf("%s: unexpected error: %v", v, err)
+		}
+	}
+	for _, v := range []string{`{"a":1}`, `"X"`, `3`, `null`} {
+		if err := schema.Validate(decode(t, v)); err == nil {
+			t.Errorf("%s: accepted", v)
+		}
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		schema  string
+		wantErr string
+	}{
+		{name: "JSON でない", schema: `{"type":`, wantErr: "schema must be valid JSON"},
+		{name: "オブジェクトでない", schema: `[]`, wantErr: "#: schema must be an object"},
+		{name: "未知の型", schema: `{"type":"date"}`, wantErr: `#/type: unknown type "date"`},
+		{name: "型が文字列でない", schema: `{"type":["string",1]}`, wantErr: "#/type: must be a string or an array of strings"},
+		{name: "空の enum", schema: `{"enum":[]}`, wantErr: "#/enum: must be a non-empty array"},
+		{name: "required が文字列の配列でない", schema: `{"required":["a",1]}`, wantErr: "#/required: must be an array of strings"},
+		{name: "properties がオブジェクトでない", schema: `{"properties":[]}`, wantErr: "#/properties: must be an object"},
+		{name: "入れ子のスキーマの誤り", schema: `{"properties":{"a":{"properties":{"b":{"type":"uuid"}}}}}`, wantErr: `#/properties/a/properties/b/type: unknown type "uuid"`},
+		{name: "additionalProperties の誤り", schema: `{"additionalProperties":"no"}`, wantErr: "#/additionalProperties: schema must be an object"},
+		{name: "items の誤り", schema: `{"items":{"minItems":-1}}`, wantErr: "#/items/minItems: must be a non-negative integer"},
+		{name: "maxLength が小数", schema: `{"maxLength":1.5}`, wantErr: "#/maxLength: must be a non-negative integer"},
+		{name: "pattern が正規表現でない", schema: `{"pattern":"("}`, wantErr: "#/pattern: invalid regular expression"},
+		{name: "minimum が数値でない", schema: `{"minimum":"1"}`, wantErr: "#/minimum: must be a number"},
+		{name: "未対応のキーワード", schema: `{"type":"string","format":"email"}`, wantErr: "#/format: unsupported keyword"},
+		{name: "参照は未対応", schema: `{"properties":{"a":{"$ref":"#/$defs/a"}}}`, wantErr: "#/properties/a/$ref: unsupported keyword"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Parse([]byte(tt.schema))
+			if err == nil || err.Error() != tt.wantErr {
+				t.Errorf("err = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
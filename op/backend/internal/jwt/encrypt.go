@@ -0,0 +1,80 @@
+package jwt
+
+import (
+	"fmt"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// 暗号化に対応する鍵管理アルゴリズムと、その鍵種別
+var keyEncryptionKeyTypes = map[string]jwa.KeyType{
+	"RSA-OAEP-256": jwa.RSA(),
+	"ECDH-ES":      jwa.EC(),
+}
+
+// 暗号化に対応するコンテンツ暗号化アルゴリズム
+var contentEncryptionAlgs = map[string]jwa.ContentEncryptionAlgorithm{
+	"A256GCM": jwa.A256GCM(),
+}
+
+// EncryptJWT は payload をクライアントが登録した jwks の公開鍵で暗号化し、JWE Compact Serialization で返す。
+// 署名済み JWT を入れ子にする場合は contentType に "JWT" を指定する (OIDC Core 1.0 Section 16.14)
+func EncryptJWT(rawJWKS, alg, enc string, payload []byte, contentType string) (string, error) {
+	kty, ok := keyEncryptionKeyTypes[alg]
+	if !ok {
+		return "", fmt.Errorf("unsupported key encryption algorithm: %s", alg)
+	}
+	contentAlg, ok := contentEncryptionAlgs[enc]
+	if !ok {
+		return "", fmt.Errorf("unsupported content encryption algorithm: %s", enc)
+	}
+	keyAlg, _ := jwa.LookupKeyEncryptionAlgorithm(alg)
+
+	set, err := jwk.ParseString(rawJWKS)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse client jwks: %w", err)
+	}
+	key, err := findEncryptionKey(set, alg, kty)
+	if err != nil {
+		return "", err
+	}
+
+	hdrs := jwe.NewHeaders()
+	if kid, ok := key.KeyID(); ok {
+		_ = hdrs.Set(jwe.KeyIDKey, kid)
+	}
+	if contentType != "" {
+		_ = hdrs.Set(jwe.ContentTypeKey, contentType)
+	}
+
+	encrypted, err := jwe.Encrypt(payload,
+		jwe.WithKey(keyAlg, key),
+		jwe.WithContentEncryption(contentAlg),
+		jwe.WithProtectedHeaders(hdrs),
+		jwe.WithCompact(),
+	)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt token: %w", err)
+	}
+	return string(encrypted), nil
+}
+
+// findEncryptionKey は鍵種別が一致し、use / alg が暗号化に使えることを示す鍵を選ぶ
+func findEncryptionKey(set jwk.Set, alg string, kty jwa.KeyType) (jwk.Key, error) {
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if key.KeyType() != kty {
+			continue
+		}
+		if use, ok := key.KeyUsage(); ok && use != "enc" {
+			continue
+		}
+		if keyAlg, ok := key.Algorithm(); ok && keyAlg.String() != alg {
+			continue
+		}
+		return key, nil
+	}
+	return nil, fmt.Errorf("client jwks has no key for %s", alg)
+}
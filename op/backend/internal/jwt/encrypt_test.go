@@ -0,0 +1,144 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwe"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+)
+
+// clientJWKS は秘密鍵に対応する公開鍵を attrs 付きで並べた jwks を返す
+func clientJWKS(t *testing.T, keys ...publicKeySpec) string {
+	t.Helper()
+	set := jwk.NewSet()
+	for _, spec := range keys {
+		key, err := jwk.PublicKeyOf(spec.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, value := range spec.attrs {
+			if err := key.Set(name, value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := set.AddKey(key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+type publicKeySpec struct {
+	key   crypto.PrivateKey
+	attrs map[string]interface{}
+}
+
+func TestEncryptJWT(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 署名用の鍵を先に置き、use で暗号化用の鍵が選ばれることを確かめる
+	jwks := clientJWKS(t,
+		publicKeySpec{key: sigKey, attrs: map[string]interface{}{jwk.KeyIDKey: "sig", jwk.KeyUsageKey: "sig"}},
+		publicKeySpec{key: rsaKey, attrs: map[string]interface{}{jwk.KeyIDKey: "rsa-enc", jwk.KeyUsageKey: "enc"}},
+		publicKeySpec{key: ecKey, attrs: map[string]interface{}{jwk.KeyIDKey: "ec-enc", jwk.AlgorithmKey: "ECDH-ES"}},
+	)
+
+	tests := []struct {
+		name    string
+		alg     string
+		priv    crypto.PrivateKey
+		keyAlg  jwa.KeyEncryptionAlgorithm
+		wantKid string
+	}{
+		{name: "RSA-OAEP-256", alg: "RSA-OAEP-256", priv: rsaKey, keyAlg: jwa.RSA_OAEP_256(), wantKid: "rsa-enc"},
+		{name: "ECDH-ES", alg: "ECDH-ES", priv: ecKey, keyAlg: jwa.ECDH_ES(), wantKid: "ec-enc"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := []byte("header.payload.signature")
+			encrypted, err := EncryptJWT(jwks, tt.alg, "A256GCM", payload, "JWT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := strings.Count(encrypted, "."); n != 4 {
+				t.Fatalf("not a compact JWE: %d dots", n)
+			}
+
+			msg, err := jwe.Parse([]byte(encrypted))
+			if err != nil {
+				t.Fatal(err)
+			}
+			hdrs := msg.ProtectedHeaders()
+			if kid, _ := hdrs.KeyID(); kid != tt.wantKid {
+				t.Errorf("kid = %q, want %q", kid, tt.wantKid)
+			}
+			if cty, _ := hdrs.ContentType(); cty != "JWT" {
+				t.Errorf("cty = %q, want JWT", cty)
+			}
+			if enc, _ := hdrs.ContentEncryption(); enc != jwa.A256GCM() {
+				t.Errorf("enc = %v", enc)
+			}
+
+			decrypted, err := jwe.Decrypt([]byte(encrypted), jwe.WithKey(tt.keyAlg, tt.priv))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(decrypted) != string(payload) {
+				t.Errorf("decrypted = %q", decrypted)
+			}
+		})
+	}
+}
+
+func TestEncryptJWTErrors(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sigOnly := clientJWKS(t, publicKeySpec{key: rsaKey, attrs: map[string]interface{}{jwk.KeyUsageKey: "sig"}})
+	rsaOnly := clientJWKS(t, publicKeySpec{key: rsaKey})
+	otherAlg := clientJWKS(t, publicKeySpec{key: rsaKey, attrs: map[string]interface{}{jwk.AlgorithmKey: "RSA-OAEP"}})
+
+	tests := []struct {
+		name string
+		jwks string
+		alg  string
+		enc  string
+	}{
+		{name: "未対応の alg", jwks: rsaOnly, alg: "RSA1_5", enc: "A256GCM"},
+		{name: "未対応の enc", jwks: rsaOnly, alg: "RSA-OAEP-256", enc: "A128CBC-HS256"},
+		{name: "署名用の鍵しか無い", jwks: sigOnly, alg: "RSA-OAEP-256", enc: "A256GCM"},
+		{name: "鍵種別が合わない", jwks: rsaOnly, alg: "ECDH-ES", enc: "A256GCM"},
+		{name: "鍵の alg が合わない", jwks: otherAlg, alg: "RSA-OAEP-256", enc: "A256GCM"},
+		{name: "不正な jwks", jwks: "{", alg: "RSA-OAEP-256", enc: "A256GCM"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := EncryptJWT(tt.jwks, tt.alg, tt.enc, []byte("{}"), ""); err == nil {
+				t.Fatal("expected error")
+			}
+		})
+	}
+}
@@ -5,10 +5,13 @@ import (
 	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/hex"
 	"encoding/pem"
 	"fmt"
+	"math/big"
 	"time"
 
 	"github.com/lestrrat-go/jwx/v3/jwa"
@@ -118,22 +121,68 @@ func (s *KeyService) GetActiveSigningKey(ctx context.Context) (string, crypto.Pr
 		return "", nil, fmt.Errorf("no active signing key found")
 	}
 
+	privateKey, err := s.decryptPrivateKey(key)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return key.KID, privateKey, nil
+}
+
+// GetActiveSigningCertificate は有効な署名鍵と、その公開鍵の自己署名証明書を返す。
+// SAML のメタデータ・署名 (KeyInfo) では公開鍵を X.509 証明書で示すため、鍵の作成日時と kid から証明書を決定的に生成する
+// (PKCS #1 v1.5 署名は乱数を使わないため、同じ鍵からは常に同じ証明書になる)。
+func (s *KeyService) GetActiveSigningCertificate(ctx context.Context) (*x509.Certificate, *rsa.PrivateKey, error) {
+	key, err := s.signKeyRepo.FindActive(ctx)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find active key: %w", err)
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("no active signing key found")
+	}
+
+	privateKey, err := s.decryptPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	kidHash := sha256.Sum256([]byte(key.KID))
+	template := &x509.Certificate{
+		SerialNumber:          new(big.Int).SetBytes(kidHash[:16]),
+		Subject:               pkix.Name{CommonName: "oidc-demo signing key " + key.KID},
+		NotBefore:             key.CreatedAt.UTC().Truncate(time.Second),
+		NotAfter:              key.CreatedAt.UTC().Truncate(time.Second).AddDate(10, 0, 0),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &privateKey.PublicKey, privateKey)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate: %w", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+	}
+	return cert, privateKey, nil
+}
+
+// decryptPrivateKey は暗号化して保存した秘密鍵を復号する。
+func (s *KeyService) decryptPrivateKey(key *model.SignKey) (*rsa.PrivateKey, error) {
 	privPEM, err := infra_crypto.Decrypt(key.PrivateKeyRef, s.encKey)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to decrypt private key: %w", err)
+		return nil, fmt.Errorf("failed to decrypt private key: %w", err)
 	}
 
 	block, _ := pem.Decode(privPEM)
 	if block == nil {
-		return "", nil, fmt.Errorf("failed to decode PEM block")
+		return nil, fmt.Errorf("failed to decode PEM block")
 	}
 
 	privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes)
 	if err != nil {
-		return "", nil, fmt.Errorf("failed to parse private key: %w", err)
+		return nil, fmt.Errorf("failed to parse private key: %w", err)
 	}
-
-	return key.KID, privateKey, nil
+	return privateKey, nil
 }
 
 func (s *KeyService) GetJWKSet(ctx context.Context) (jwk.Set, error) {
@@ -176,3 +225,18 @@ func (s *KeyService) GetJWKSet(ctx context.Context) (jwk.Set, error) {
 
 	return set, nil
 }
+
+// EncryptSecret は client_secret 等の共有シークレットを鍵暗号化キーで暗号化する。
+// client_secret_jwt (HS256) の検証には平文が必要なため、ハッシュとは別に保存する。
+func (s *KeyService) EncryptSecret(plaintext string) (string, error) {
+	return infra_crypto.Encrypt([]byte(plaintext), s.encKey)
+}
+
+// DecryptSecret は EncryptSecret で暗号化されたシークレットを復号する。
+func (s *KeyService) DecryptSecret(encrypted string) (string, error) {
+	plaintext, err := infra_crypto.Decrypt(encrypted, s.encKey)
+	if err != nil {
+		return "", err
+	}
+	return string(plaintext), nil
+}
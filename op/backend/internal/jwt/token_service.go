@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/base64"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"time"
 
@@ -49,6 +50,12 @@ func (s *TokenService) SignIDToken(ctx context.Context, claims *model.IDTokenCla
 	if claims.ATHash != "" {
 		builder = builder.Claim("at_hash", claims.ATHash)
 	}
+	if len(claims.AMR) > 0 {
+		builder = builder.Claim("amr", claims.AMR)
+	}
+	for name, value := range claims.Extra {
+		builder = builder.Claim(name, value)
+	}
 
 	token, err := builder.Build()
 	if err != nil {
@@ -66,6 +73,71 @@ func (s *TokenService) SignIDToken(ctx context.Context, claims *model.IDTokenCla
 	return jti, string(signed), nil
 }
 
+// SignUserInfo は UserInfo レスポンスを署名済み JWT にする。iss と aud を含める (SHOULD: OIDC Core 1.0 Section 5.3.2)
+func (s *TokenService) SignUserInfo(ctx context.Context, issuer, audience string, claims map[string]interface{}) (string, error) {
+	kid, privKey, err := s.keySvc.GetActiveSigningKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	builder := jwt.NewBuilder().
+		Issuer(issuer).
+		Audience([]string{audience}).
+		IssuedAt(time.Now())
+	for name, value := range claims {
+		builder = builder.Claim(name, value)
+	}
+
+	token, err := builder.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build userinfo response: %w", err)
+	}
+
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, kid)
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), privKey, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign userinfo response: %w", err)
+	}
+
+	return string(signed), nil
+}
+
+// SignAuthorizationResponse は認可レスポンスのパラメータを JWT として署名する。
+// iss / aud / exp は必須 (MUST: JARM Section 2.1)
+func (s *TokenService) SignAuthorizationResponse(ctx context.Context, issuer, audience string, params map[string]interface{}, lifetime time.Duration) (string, error) {
+	kid, privKey, err := s.keySvc.GetActiveSigningKey(ctx)
+	if err != nil {
+		return "", fmt.Errorf("failed to get signing key: %w", err)
+	}
+
+	now := time.Now()
+	builder := jwt.NewBuilder().
+		Issuer(issuer).
+		Audience([]string{audience}).
+		IssuedAt(now).
+		Expiration(now.Add(lifetime))
+	for name, value := range params {
+		builder = builder.Claim(name, value)
+	}
+
+	token, err := builder.Build()
+	if err != nil {
+		return "", fmt.Errorf("failed to build authorization response: %w", err)
+	}
+
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, kid)
+
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), privKey, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		return "", fmt.Errorf("failed to sign authorization response: %w", err)
+	}
+
+	return string(signed), nil
+}
+
 func (s *TokenService) SignAccessToken(ctx context.Context, claims *model.AccessTokenClaims, lifetime time.Duration) (string, string, error) {
 	kid, privKey, err := s.keySvc.GetActiveSigningKey(ctx)
 	if err != nil {
@@ -75,7 +147,7 @@ func (s *TokenService) SignAccessToken(ctx context.Context, claims *model.Access
 	now := time.Now()
 	jti := uuid.New().String()
 
-	token, err := jwt.NewBuilder().
+	builder := jwt.NewBuilder().
 		Issuer(claims.Issuer).
 		Subject(claims.Subject).
 		Audience([]string{claims.Audience}).
@@ -83,8 +155,28 @@ func (s *TokenService) SignAccessToken(ctx context.Context, claims *model.Access
 		Expiration(now.Add(lifetime)).
 		JwtID(jti).
 		Claim("scope", claims.Scope).
-		Claim("sid", claims.SessionID).
-		Build()
+		Claim("sid", claims.SessionID)
+
+	// 証明書バインド (RFC 8705 Section 3.1)
+	if claims.CertThumbprint != "" {
+		builder = builder.Claim("cnf", map[string]string{"x5t#S256": claims.CertThumbprint})
+	}
+
+	// トークン交換 (RFC 8693 Section 4)
+	if claims.ClientID != "" {
+		builder = builder.Claim("client_id", claims.ClientID)
+	}
+	if claims.Actor != nil {
+		builder = builder.Claim("act", claims.Actor)
+	}
+	if claims.MayAct != nil {
+		builder = builder.Claim("may_act", claims.MayAct)
+	}
+	if len(claims.AuthorizationDetails) > 0 {
+		builder = builder.Claim("authorization_details", claims.AuthorizationDetails)
+	}
+
+	token, err := builder.Build()
 	if err != nil {
 		return "", "", fmt.Errorf("failed to build access token: %w", err)
 	}
@@ -122,6 +214,7 @@ func (s *TokenService) ValidateAccessToken(ctx context.Context, tokenString stri
 	}
 
 	jti, _ := token.JwtID()
+	iss, _ := token.Issuer()
 	sub, _ := token.Subject()
 
 	var scope string
@@ -130,15 +223,19 @@ func (s *TokenService) ValidateAccessToken(ctx context.Context, tokenString stri
 	var sid string
 	_ = token.Get("sid", &sid)
 
+	var cnf map[string]interface{}
+	_ = token.Get("cnf", &cnf)
+	certThumbprint, _ := cnf["x5t#S256"].(string)
+
 	aud, _ := token.Audience()
 	clientID := ""
 	if len(aud) > 0 {
 		clientID = aud[0]
 	}
-
-	subUUID, err := uuid.Parse(sub)
-	if err != nil {
-		return nil, fmt.Errorf("invalid subject in access token: %w", err)
+	// トークン交換で発行したトークンは aud が下流サービスを指すため client_id クレームを優先する
+	var clientIDClaim string
+	if err := token.Get("client_id", &clientIDClaim); err == nil && clientIDClaim != "" {
+		clientID = clientIDClaim
 	}
 
 	sessionUUID, err := uuid.Parse(sid)
@@ -147,14 +244,70 @@ func (s *TokenService) ValidateAccessToken(ctx context.Context, tokenString stri
 	}
 
 	return &model.AccessTokenResult{
-		JTI:       jti,
-		Subject:   subUUID,
-		ClientID:  clientID,
-		Scope:     scope,
-		SessionID: sessionUUID,
+		JTI:            jti,
+		Issuer:         iss,
+		Subject:        sub,
+		ClientID:       clientID,
+		Scope:          scope,
+		SessionID:      sessionUUID,
+		CertThumbprint: certThumbprint,
+		Actor:          actorClaim(token, "act"),
+		MayAct:         actorClaim(token, "may_act"),
 	}, nil
 }
 
+// ValidateIDToken は自身が発行した ID トークンの署名と有効期限を検証する。
+// アクセストークンと区別するため auth_time を持ち sid を持たないことを確認する。
+func (s *TokenService) ValidateIDToken(ctx context.Context, tokenString string) (*model.IDTokenResult, error) {
+	jwkSet, err := s.keySvc.GetJWKSet(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get JWK set: %w", err)
+	}
+
+	token, err := jwt.Parse([]byte(tokenString), jwt.WithKeySet(jwkSet))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse/verify ID token: %w", err)
+	}
+
+	if !token.Has("auth_time") || token.Has("sid") {
+		return nil, fmt.Errorf("token is not an ID token")
+	}
+
+	jti, _ := token.JwtID()
+	iss, _ := token.Issuer()
+	sub, _ := token.Subject()
+
+	aud, _ := token.Audience()
+	clientID := ""
+	if len(aud) > 0 {
+		clientID = aud[0]
+	}
+
+	return &model.IDTokenResult{
+		JTI:      jti,
+		Issuer:   iss,
+		Subject:  sub,
+		ClientID: clientID,
+	}, nil
+}
+
+// actorClaim は act / may_act クレームを読み取る。存在しない・形式が不正な場合は nil
+func actorClaim(token jwt.Token, name string) *model.ActorClaim {
+	var raw map[string]interface{}
+	if err := token.Get(name, &raw); err != nil {
+		return nil
+	}
+	b, err := json.Marshal(raw)
+	if err != nil {
+		return nil
+	}
+	var actor model.ActorClaim
+	if err := json.Unmarshal(b, &actor); err != nil {
+		return nil
+	}
+	return &actor
+}
+
 // ComputeATHash は at_hash を計算する (OIDC Core 1.0 Section 3.1.3.6)
 // RS256 の場合、SHA-256 の左半分を base64url エンコード
 func ComputeATHash(accessToken string) string {
@@ -0,0 +1,164 @@
+package jwt
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// fakeKeyProvider は1つの RSA 鍵で署名し、その公開鍵を JWK Set として返す
+type fakeKeyProvider struct {
+	kid string
+	key *rsa.PrivateKey
+}
+
+func newFakeKeyProvider(t *testing.T) *fakeKeyProvider {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeKeyProvider{kid: "test-kid", key: key}
+}
+
+func (p *fakeKeyProvider) GetActiveSigningKey(context.Context) (string, crypto.PrivateKey, error) {
+	return p.kid, p.key, nil
+}
+
+func (p *fakeKeyProvider) GetJWKSet(context.Context) (jwk.Set, error) {
+	pub, err := jwk.PublicKeyOf(p.key)
+	if err != nil {
+		return nil, err
+	}
+	_ = pub.Set(jwk.KeyIDKey, p.kid)
+	_ = pub.Set(jwk.AlgorithmKey, jwa.RS256())
+	set := jwk.NewSet()
+	_ = set.AddKey(pub)
+	return set, nil
+}
+
+func TestSignUserInfo(t *testing.T) {
+	keys := newFakeKeyProvider(t)
+	svc := NewTokenService(keys)
+
+	signed, err := svc.SignUserInfo(context.Background(), "https://op.example.com/demo", "client-a", map[string]interface{}{
+		"sub":   "user-1",
+		"email": "alice@example.com",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := jws.Parse([]byte(signed))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid, _ := msg.Signatures()[0].ProtectedHeaders().KeyID(); kid != keys.kid {
+		t.Errorf("kid = %q", kid)
+	}
+
+	set, _ := keys.GetJWKSet(context.Background())
+	token, err := jwt.Parse([]byte(signed), jwt.WithKeySet(set))
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss, _ := token.Issuer()
+	aud, _ := token.Audience()
+	sub, _ := token.Subject()
+	var email string
+	_ = token.Get("email", &email)
+	if iss != "https://op.example.com/demo" || len(aud) != 1 || aud[0] != "client-a" || sub != "user-1" || email != "alice@example.com" {
+		t.Errorf("iss = %q, aud = %v, sub = %q, email = %q", iss, aud, sub, email)
+	}
+
+	// 別の鍵では検証できない
+	other := newFakeKeyProvider(t)
+	otherSet, _ := other.GetJWKSet(context.Background())
+	if _, err := jwt.Parse([]byte(signed), jwt.WithKeySet(otherSet)); err == nil {
+		t.Error("verified with unrelated key")
+	}
+}
+
+func TestValidateIDTokenRejectsAccessToken(t *testing.T) {
+	svc := NewTokenService(newFakeKeyProvider(t))
+	ctx := context.Background()
+
+	_, idToken, err := svc.SignIDToken(ctx, &model.IDTokenClaims{
+		Issuer:   "https://op.example.com/demo",
+		Subject:  "user-1",
+		Audience: "client-a",
+		AuthTime: time.Now(),
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, accessToken, err := svc.SignAccessToken(ctx, &model.AccessTokenClaims{
+		Issuer:    "https://op.example.com/demo",
+		Subject:   "user-1",
+		Audience:  "client-a",
+		Scope:     "openid",
+		SessionID: uuid.New().String(),
+		ClientID:  "client-a",
+	}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := svc.ValidateIDToken(ctx, idToken)
+	if err != nil || result.Subject != "user-1" || result.ClientID != "client-a" {
+		t.Fatalf("ValidateIDToken = %+v, %v", result, err)
+	}
+	if _, err := svc.ValidateIDToken(ctx, accessToken); err == nil {
+		t.Error("access token accepted as ID token")
+	}
+	if _, err := svc.ValidateAccessToken(ctx, idToken); err == nil {
+		t.Error("ID token accepted as access token")
+	}
+
+	expiredSvc := NewTokenService(newFakeKeyProvider(t))
+	_, expired, _ := expiredSvc.SignIDToken(ctx, &model.IDTokenClaims{Issuer: "x", Subject: "user-1", Audience: "client-a", AuthTime: time.Now()}, -time.Minute)
+	if _, err := expiredSvc.ValidateIDToken(ctx, expired); err == nil {
+		t.Error("expired ID token accepted")
+	}
+}
+
+func TestSignAuthorizationResponse(t *testing.T) {
+	keys := newFakeKeyProvider(t)
+	svc := NewTokenService(keys)
+
+	signed, err := svc.SignAuthorizationResponse(context.Background(), "https://op.example.com/demo", "client-a", map[string]interface{}{
+		"code":  "abc",
+		"state": "xyz",
+	}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set, _ := keys.GetJWKSet(context.Background())
+	token, err := jwt.Parse([]byte(signed), jwt.WithKeySet(set), jwt.WithIssuer("https://op.example.com/demo"), jwt.WithAudience("client-a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// exp は必須 (JARM Section 2.1)
+	exp, ok := token.Expiration()
+	if !ok || time.Until(exp) > 10*time.Minute || time.Until(exp) < 9*time.Minute {
+		t.Errorf("exp = %v", exp)
+	}
+	var code, state string
+	_ = token.Get("code", &code)
+	_ = token.Get("state", &state)
+	if code != "abc" || state != "xyz" {
+		t.Errorf("code = %q, state = %q", code, state)
+	}
+}
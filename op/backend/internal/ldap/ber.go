@@ -0,0 +1,210 @@
+package ldap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// タグのクラスと構造化のフラグ (X.690 Section 8.1.2)
+const (
+	classUniversal   byte = 0x00
+	classApplication byte = 0x40
+	classContext     byte = 0x80
+	constructed      byte = 0x20
+)
+
+// UNIVERSAL のタグ (X.680 Section 8.4)
+const (
+	tagBoolean     = classUniversal | 0x01
+	tagInteger     = classUniversal | 0x02
+	tagOctetString = classUniversal | 0x04
+	tagEnumerated  = classUniversal | 0x0a
+	tagSequence    = classUniversal | constructed | 0x10
+	tagSet         = classUniversal | constructed | 0x11
+)
+
+// maxMessageBytes は受け付ける LDAP メッセージの上限
+const maxMessageBytes = 1 << 20
+
+// packet は BER で符号化する値 (X.690)。構造化された値は children、それ以外は value を持つ。
+// LDAP は定長形式のみ使う (RFC 4511 Section 5.1)
+type packet struct {
+	tag      byte
+	value    []byte
+	children []*packet
+}
+
+func (p *packet) isConstructed() bool { return p.tag&constructed != 0 }
+
+// child は i 番目の要素を返す。ない場合は nil を返す
+func (p *packet) child(i int) *packet {
+	if i < 0 || i >= len(p.children) {
+		return nil
+	}
+	return p.children[i]
+}
+
+func newConstructed(tag byte, children ...*packet) *packet {
+	return &packet{tag: tag | constructed, children: children}
+}
+
+func newString(tag byte, s string) *packet {
+	return &packet{tag: tag, value: []byte(s)}
+}
+
+// newInteger は INTEGER・ENUMERATED を 2 の補数の最小のバイト数で符号化する (X.690 Section 8.3)
+func newInteger(tag byte, n int64) *packet {
+	b := []byte{byte(n)}
+	for n > 127 || n < -128 {
+		n >>= 8
+		b = append([]byte{byte(n)}, b...)
+	}
+	return &packet{tag: tag, value: b}
+}
+
+func newBoolean(v bool) *packet {
+	if v {
+		return &packet{tag: tagBoolean, value: []byte{0xff}}
+	}
+	return &packet{tag: tagBoolean, value: []byte{0x00}}
+}
+
+// encode は TLV のバイト列を返す。
+func (p *packet) encode() []byte {
+	content := p.value
+	if p.isConstructed() {
+		content = nil
+		for _, c := range p.children {
+			content = append(content, c.encode()...)
+		}
+	}
+	out := append([]byte{p.tag}, encodeLength(len(content))...)
+	return append(out, content...)
+}
+
+// encodeLength は長さを短形式 (127 以下) または長形式で符号化する (X.690 Section 8.1.3)
+func encodeLength(n int) []byte {
+	if n < 0x80 {
+		return []byte{byte(n)}
+	}
+	var b []byte
+	for ; n > 0; n >>= 8 {
+		b = append([]byte{byte(n)}, b...)
+	}
+	return append([]byte{0x80 | byte(len(b))}, b...)
+}
+
+// int は INTEGER・ENUMERATED の値を返す。
+func (p *packet) int() (int64, error) {
+	if p.isConstructed() || len(p.value) == 0 || len(p.value) > 8 {
+		return 0, fmt.Errorf("invalid integer")
+	}
+	n := int64(int8(p.value[0]))
+	for _, b := range p.value[1:] {
+		n = n<<8 | int64(b)
+	}
+	return n, nil
+}
+
+func (p *packet) str() string { return string(p.value) }
+
+// readPacket はストリームから 1 つの値を読み込む。
+func readPacket(r *bufio.Reader) (*packet, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, err
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return nil, err
+	}
+	return parseContent(tag, content)
+}
+
+func readLength(r io.ByteReader) (int, error) {
+	first, err := r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	if first < 0x80 {
+		return int(first), nil
+	}
+	// 不定長形式 (0x80) は使わない
+	n := int(first & 0x7f)
+	if n == 0 || n > 4 {
+		return 0, fmt.Errorf("unsupported length encoding")
+	}
+	length := 0
+	for i := 0; i < n; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		length = length<<8 | int(b)
+	}
+	if length > maxMessageBytes {
+		return 0, fmt.Errorf("message is too large")
+	}
+	return length, nil
+}
+
+// decodePacket はバイト列の先頭の値を読み込み、読み込んだバイト数を返す。
+func decodePacket(data []byte) (*packet, int, error) {
+	if len(data) < 2 {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	r := &byteReader{data: data[1:]}
+	length, err := readLength(r)
+	if err != nil {
+		return nil, 0, err
+	}
+	start := 1 + r.pos
+	if length > len(data)-start {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	p, err := parseContent(data[0], data[start:start+length])
+	if err != nil {
+		return nil, 0, err
+	}
+	return p, start + length, nil
+}
+
+func parseContent(tag byte, content []byte) (*packet, error) {
+	// 高いタグ番号 (31 以上) は LDAP では使わない
+	if tag&0x1f == 0x1f {
+		return nil, fmt.Errorf("unsupported tag")
+	}
+	p := &packet{tag: tag}
+	if !p.isConstructed() {
+		p.value = content
+		return p, nil
+	}
+	for len(content) > 0 {
+		child, n, err := decodePacket(content)
+		if err != nil {
+			return nil, err
+		}
+		p.children = append(p.children, child)
+		content = content[n:]
+	}
+	return p, nil
+}
+
+type byteReader struct {
+	data []byte
+	pos  int
+}
+
+func (r *byteReader) ReadByte() (byte, error) {
+	if r.pos >= len(r.data) {
+		return 0, io.ErrUnexpectedEOF
+	}
+	b := r.data[r.pos]
+	r.pos++
+	return b, nil
+}
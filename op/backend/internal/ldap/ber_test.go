@@ -0,0 +1,158 @@
+package ldap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestEncodeInteger(t *testing.T) {
+	tests := []struct {
+		n    int64
+		want string
+	}{
+		{n: 0, want: "020100"},
+		{n: 127, want: "02017f"},
+		{n: 128, want: "02020080"},
+		{n: 256, want: "02020100"},
+		{n: -1, want: "0201ff"},
+		{n: -128, want: "020180"},
+		{n: -129, want: "0202ff7f"},
+		{n: math.MaxInt64, want: "02087fffffffffffffff"},
+		{n: math.MinInt64, want: "02088000000000000000"},
+	}
+	for _, tt := range tests {
+		p := newInteger(tagInteger, tt.n)
+		if got := hex.EncodeToString(p.encode()); got != tt.want {
+			t.Errorf("%d: encoded %s, want %s", tt.n, got, tt.want)
+		}
+		decoded, _, err := decodePacket(p.encode())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n, err := decoded.int(); err != nil || n != tt.n {
+			t.Errorf("%d: decoded %d, %v", tt.n, n, err)
+		}
+	}
+}
+
+func TestEncodeLength(t *testing.T) {
+	for n, want := range map[int]string{
+		0:      "00",
+		127:    "7f",
+		128:    "8180",
+		255:    "81ff",
+		256:    "820100",
+		65536:  "83010000",
+		100000: "830186a0",
+	} {
+		if got := hex.EncodeToString(encodeLength(n)); got != want {
+			t.Errorf("%d: %s, want %s", n, got, want)
+		}
+	}
+}
+
+func TestPacketRoundTrip(t *testing.T) {
+	// 匿名の BindRequest (messageID 1, version 3) の既知の符号化
+	anonymousBind := newConstructed(tagSequence,
+		newInteger(tagInteger, 1),
+		newConstructed(opBindRequest,
+			newInteger(tagInteger, protocolVersion),
+			newString(tagOctetString, ""),
+			newString(classContext|0, ""),
+		),
+	)
+	if got := hex.EncodeToString(anonymousBind.encode()); got != "300c020101600702010304008000" {
+		t.Errorf("anonymous bind = %s", got)
+	}
+
+	packets := map[string]*packet{
+		"匿名の bind": anonymousBind,
+		"長形式の長さ": newConstructed(tagSequence,
+			newString(tagOctetString, strings.Repeat("a", 200)),
+			newString(tagOctetString, strings.Repeat("b", 70000)),
+		),
+		"入れ子の構造": newConstructed(tagSequence,
+			newInteger(tagInteger, 7),
+			newConstructed(opSearchResultEntry,
+				newString(tagOctetString, "CN=Alice,DC=example,DC=com"),
+				newConstructed(tagSequence,
+					newConstructed(tagSequence,
+						newString(tagOctetString, "mail"),
+						newConstructed(tagSet, newString(tagOctetString, "alice@example.com")),
+					),
+				),
+			),
+		),
+		"空の構造":    newConstructed(tagSequence, newBoolean(true), newConstructed(tagSet)),
+		"値のない操作":  newConstructed(tagSequence, newInteger(tagInteger, 2), &packet{tag: opUnbindRequest}),
+		"バイナリの値":  newString(tagOctetString, "\x00\xff\x80"),
+		"ENUM の値": newInteger(tagEnumerated, resultInvalidCredentials),
+	}
+	for name, p := range packets {
+		t.Run(name, func(t *testing.T) {
+			encoded := p.encode()
+
+			decoded, n, err := decodePacket(append(encoded, 0x30, 0x00))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n != len(encoded) {
+				t.Errorf("read %d bytes, want %d", n, len(encoded))
+			}
+			if !bytes.Equal(decoded.encode(), encoded) {
+				t.Error("decodePacket did not round-trip")
+			}
+
+			read, err := readPacket(bufio.NewReader(bytes.NewReader(encoded)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(read.encode(), encoded) {
+				t.Error("readPacket did not round-trip")
+			}
+		})
+	}
+}
+
+func TestDecodePacketErrors(t *testing.T) {
+	tests := map[string]string{
+		"空":          "",
+		"長さがない":      "04",
+		"値が足りない":     "040561",
+		"不定長形式":      "0480610000",
+		"長さのバイト数が多い": "04850000000001",
+		"上限を超える長さ":   "04840fffffff",
+		"高いタグ番号":     "1f0100",
+		"子の値が足りない":   "3003040561",
+		"子の長さが足りない":  "300104",
+	}
+	for name, data := range tests {
+		t.Run(name, func(t *testing.T) {
+			b, _ := hex.DecodeString(data)
+			if p, _, err := decodePacket(b); err == nil {
+				t.Errorf("decoded %+v", p)
+			}
+			if len(b) > 0 {
+				if p, err := readPacket(bufio.NewReader(bytes.NewReader(b))); err == nil {
+					t.Errorf("read %+v", p)
+				}
+			}
+		})
+	}
+}
+
+func TestPacketInt(t *testing.T) {
+	for name, p := range map[string]*packet{
+		"空":       {tag: tagInteger},
+		"9 バイト":   {tag: tagInteger, value: make([]byte, 9)},
+		"構造化された値": newConstructed(tagSequence, newInteger(tagInteger, 1)),
+	} {
+		if n, err := p.int(); err == nil {
+			t.Errorf("%s: %d", name, n)
+		}
+	}
+}
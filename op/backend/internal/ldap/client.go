@@ -0,0 +1,416 @@
+// Package ldap は LDAP / Active Directory でのユーザーの検索とパスワードの検証 (auth.LDAPClient) を実装する。
+// LDAPv3 (RFC 4511) のうち bind・search・StartTLS だけを扱う
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// defaultTimeout は 1 回の処理 (接続からユーザーの bind まで) の上限
+	defaultTimeout = 10 * time.Second
+	// searchSizeLimit は検索で受け取るエントリ数の上限。2 件以上見つかった場合はユーザーを特定できないとして扱う
+	searchSizeLimit = 2
+	// startTLSOID は StartTLS 拡張操作の名前 (RFC 4511 Section 4.14.1)
+	startTLSOID = "1.3.6.1.4.1.1466.20037"
+	// protocolVersion は BindRequest の version (RFC 4511 Section 4.2)
+	protocolVersion = 3
+)
+
+// プロトコル操作のタグ (RFC 4511 Section 4.2 - 4.14)
+const (
+	opBindRequest           = classApplication | constructed | 0
+	opBindResponse          = classApplication | constructed | 1
+	opUnbindRequest         = classApplication | 2
+	opSearchRequest         = classApplication | constructed | 3
+	opSearchResultEntry     = classApplication | constructed | 4
+	opSearchResultDone      = classApplication | constructed | 5
+	opSearchResultReference = classApplication | constructed | 19
+	opExtendedRequest       = classApplication | constructed | 23
+	opExtendedResponse      = classApplication | constructed | 24
+)
+
+// 結果コード (RFC 4511 Appendix A)
+const (
+	resultSuccess            = 0
+	resultSizeLimitExceeded  = 4
+	resultInvalidCredentials = 49
+)
+
+// SearchRequest の scope と derefAliases (RFC 4511 Section 4.5.1)
+const (
+	scopeWholeSubtree = 2
+	derefNever        = 0
+)
+
+// ErrDirectory はディレクトリとの通信やディレクトリが返した値の検証に失敗したことを示す
+var ErrDirectory = errors.New("ldap directory error")
+
+// Client はディレクトリに接続してユーザーを検索し、パスワードを検証する。接続は処理ごとに作り直す
+type Client struct {
+	timeout time.Duration
+}
+
+// NewClient は Client を生成する。
+func NewClient() *Client {
+	return &Client{timeout: defaultTimeout}
+}
+
+// ValidateFilter は検索フィルタのテンプレートが {username} を含み、RFC 4515 の形式であるか検証する。
+func (c *Client) ValidateFilter(template string) error {
+	if !strings.Contains(template, UsernamePlaceholder) {
+		return fmt.Errorf("filter must contain %s", UsernamePlaceholder)
+	}
+	if _, err := compileFilter(userFilter(template, "user")); err != nil {
+		return fmt.Errorf("invalid filter: %v", err)
+	}
+	return nil
+}
+
+// Lookup はサービスアカウントで bind してユーザーを検索する。見つからない場合は nil を返す。
+func (c *Client) Lookup(ctx context.Context, req *model.LDAPSearchRequest) (*model.LDAPEntry, error) {
+	cn, err := c.dial(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	defer cn.close()
+	return cn.lookup(req)
+}
+
+// Authenticate はユーザーを検索し、見つかったエントリの DN と password で bind する。
+// ユーザーが見つからない場合は nil を返す。パスワードが正しいかは 2 番目の戻り値で返す
+func (c *Client) Authenticate(ctx context.Context, req *model.LDAPSearchRequest, password string) (*model.LDAPEntry, bool, error) {
+	// パスワードが空の bind は認証なしの bind として成功しうるため送らない (RFC 4513 Section 5.1.2)
+	if password == "" {
+		return nil, false, fmt.Errorf("%w: password is empty", ErrDirectory)
+	}
+
+	cn, err := c.dial(ctx, req)
+	if err != nil {
+		return nil, false, err
+	}
+	defer cn.close()
+
+	entry, err := cn.lookup(req)
+	if err != nil || entry == nil {
+		return nil, false, err
+	}
+
+	result, err := cn.bind(entry.DN, password)
+	if err != nil {
+		return nil, false, err
+	}
+	switch result.code {
+	case resultSuccess:
+		return entry, true, nil
+	case resultInvalidCredentials:
+		return entry, false, nil
+	default:
+		return nil, false, fmt.Errorf("%w: user bind failed: %s", ErrDirectory, result)
+	}
+}
+
+// dial はディレクトリに TLS で接続する。StartTLS の場合は平文で接続してから TLS に切り替える
+func (c *Client) dial(ctx context.Context, req *model.LDAPSearchRequest) (*conn, error) {
+	tlsConfig, err := newTLSConfig(req)
+	if err != nil {
+		return nil, err
+	}
+
+	deadline := time.Now().Add(c.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	dialer := &net.Dialer{Deadline: deadline}
+	raw, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(req.Host, strconv.Itoa(req.Port)))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectory, err)
+	}
+	if err := raw.SetDeadline(deadline); err != nil {
+		raw.Close()
+		return nil, fmt.Errorf("%w: %v", ErrDirectory, err)
+	}
+
+	cn := &conn{netConn: raw, reader: bufio.NewReader(raw)}
+	// 処理の途中でリクエストがキャンセルされた場合は読み書きを打ち切る
+	cn.stop = context.AfterFunc(ctx, func() { raw.SetDeadline(time.Now()) })
+
+	abort := func() {
+		cn.stop()
+		raw.Close()
+	}
+
+	switch req.Security {
+	case model.LDAPSecurityLDAPS:
+	case model.LDAPSecurityStartTLS:
+		if err := cn.startTLS(); err != nil {
+			abort()
+			return nil, err
+		}
+	default:
+		abort()
+		return nil, fmt.Errorf("%w: unsupported security %q", ErrDirectory, req.Security)
+	}
+
+	tlsConn := tls.Client(raw, tlsConfig)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		abort()
+		return nil, fmt.Errorf("%w: tls handshake failed: %v", ErrDirectory, err)
+	}
+	cn.netConn = tlsConn
+	cn.reader = bufio.NewReader(tlsConn)
+	return cn, nil
+}
+
+// newTLSConfig はサーバー証明書をホスト名と CA 証明書 (未設定の場合はシステムの信頼ストア) で検証する設定を返す。
+func newTLSConfig(req *model.LDAPSearchRequest) (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: req.Host,
+		MinVersion: tls.VersionTLS12,
+	}
+	if req.CACertificate != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(req.CACertificate)) {
+			return nil, fmt.Errorf("%w: invalid ca certificate", ErrDirectory)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// conn はディレクトリとの 1 本の接続。操作は 1 つずつ順に行う
+type conn struct {
+	netConn   net.Conn
+	reader    *bufio.Reader
+	messageID int64
+	stop      func() bool
+}
+
+// result は操作の結果 (RFC 4511 Section 4.1.9)
+type result struct {
+	code    int64
+	message string
+}
+
+func (r *result) String() string {
+	if r.message == "" {
+		return fmt.Sprintf("result code %d", r.code)
+	}
+	return fmt.Sprintf("result code %d (%s)", r.code, r.message)
+}
+
+// close は UnbindRequest を送って接続を閉じる (RFC 4511 Section 4.3)。
+func (c *conn) close() {
+	_, _ = c.send(&packet{tag: opUnbindRequest})
+	c.stop()
+	c.netConn.Close()
+}
+
+func (c *conn) send(op *packet) (int64, error) {
+	c.messageID++
+	msg := newConstructed(tagSequence, newInteger(tagInteger, c.messageID), op)
+	if _, err := c.netConn.Write(msg.encode()); err != nil {
+		return 0, fmt.Errorf("%w: %v", ErrDirectory, err)
+	}
+	return c.messageID, nil
+}
+
+// receive は messageID への応答のプロトコル操作を読み込む (RFC 4511 Section 4.1.1)。
+func (c *conn) receive(messageID int64) (*packet, error) {
+	msg, err := readPacket(c.reader)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectory, err)
+	}
+	if msg.tag != tagSequence || len(msg.children) < 2 {
+		return nil, fmt.Errorf("%w: malformed message", ErrDirectory)
+	}
+	id, err := msg.children[0].int()
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed message id", ErrDirectory)
+	}
+	// messageID 0 は Notice of Disconnection などの一方的な通知 (RFC 4511 Section 4.4)
+	if id != messageID {
+		return nil, fmt.Errorf("%w: unexpected message id %d", ErrDirectory, id)
+	}
+	return msg.children[1], nil
+}
+
+// parseResult は LDAPResult の resultCode と diagnosticMessage を読み込む。
+func parseResult(op *packet) (*result, error) {
+	code, message := op.child(0), op.child(2)
+	if code == nil || code.tag != tagEnumerated || message == nil {
+		return nil, fmt.Errorf("%w: malformed result", ErrDirectory)
+	}
+	n, err := code.int()
+	if err != nil {
+		return nil, fmt.Errorf("%w: malformed result code", ErrDirectory)
+	}
+	return &result{code: n, message: message.str()}, nil
+}
+
+// startTLS は StartTLS 拡張操作を行う。成功後に呼び出し元で TLS のハンドシェイクを行う
+func (c *conn) startTLS() error {
+	id, err := c.send(newConstructed(opExtendedRequest, newString(classContext|0, startTLSOID)))
+	if err != nil {
+		return err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return err
+	}
+	if op.tag != opExtendedResponse {
+		return fmt.Errorf("%w: unexpected response to StartTLS", ErrDirectory)
+	}
+	res, err := parseResult(op)
+	if err != nil {
+		return err
+	}
+	if res.code != resultSuccess {
+		return fmt.Errorf("%w: StartTLS failed: %s", ErrDirectory, res)
+	}
+	// TLS に切り替える前のデータが残っていてはならない
+	if c.reader.Buffered() > 0 {
+		return fmt.Errorf("%w: unexpected data after StartTLS", ErrDirectory)
+	}
+	return nil
+}
+
+// bind は簡易認証で bind する (RFC 4511 Section 4.2)。
+func (c *conn) bind(dn, password string) (*result, error) {
+	id, err := c.send(newConstructed(opBindRequest,
+		newInteger(tagInteger, protocolVersion),
+		newString(tagOctetString, dn),
+		newString(classContext|0, password),
+	))
+	if err != nil {
+		return nil, err
+	}
+	op, err := c.receive(id)
+	if err != nil {
+		return nil, err
+	}
+	if op.tag != opBindResponse {
+		return nil, fmt.Errorf("%w: unexpected response to bind", ErrDirectory)
+	}
+	return parseResult(op)
+}
+
+// lookup はサービスアカウント (BindDN が空の場合は匿名) で bind し、login_id でユーザーを 1 件検索する。
+func (c *conn) lookup(req *model.LDAPSearchRequest) (*model.LDAPEntry, error) {
+	if req.BindDN != "" && req.BindPassword == "" {
+		return nil, fmt.Errorf("%w: bind password is not set", ErrDirectory)
+	}
+	res, err := c.bind(req.BindDN, req.BindPassword)
+	if err != nil {
+		return nil, err
+	}
+	if res.code != resultSuccess {
+		return nil, fmt.Errorf("%w: service account bind failed: %s", ErrDirectory, res)
+	}
+
+	entries, err := c.search(req.SearchBase, userFilter(req.Filter, req.LoginID), req.Attributes)
+	if err != nil {
+		return nil, err
+	}
+	switch len(entries) {
+	case 0:
+		return nil, nil
+	case 1:
+		return entries[0], nil
+	default:
+		return nil, fmt.Errorf("%w: filter matched more than one entry", ErrDirectory)
+	}
+}
+
+// search は base 以下のサブツリーを検索する (RFC 4511 Section 4.5)。他のサーバーへの参照はたどらない
+func (c *conn) search(base, filter string, attributes []string) ([]*model.LDAPEntry, error) {
+	f, err := compileFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrDirectory, err)
+	}
+	attrs := newConstructed(tagSequence)
+	for _, attr := range attributes {
+		attrs.children = append(attrs.children, newString(tagOctetString, attr))
+	}
+
+	id, err := c.send(newConstructed(opSearchRequest,
+		newString(tagOctetString, base),
+		newInteger(tagEnumerated, scopeWholeSubtree),
+		newInteger(tagEnumerated, derefNever),
+		newInteger(tagInteger, searchSizeLimit),
+		newInteger(tagInteger, int64(defaultTimeout/time.Second)),
+		newBoolean(false),
+		f,
+		attrs,
+	))
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []*model.LDAPEntry
+	for {
+		op, err := c.receive(id)
+		if err != nil {
+			return nil, err
+		}
+		switch op.tag {
+		case opSearchResultEntry:
+			entry, err := parseEntry(op)
+			if err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		case opSearchResultReference:
+		case opSearchResultDone:
+			res, err := parseResult(op)
+			if err != nil {
+				return nil, err
+			}
+			switch res.code {
+			case resultSuccess:
+				return entries, nil
+			case resultSizeLimitExceeded:
+				return nil, fmt.Errorf("%w: filter matched more than one entry", ErrDirectory)
+			default:
+				return nil, fmt.Errorf("%w: search failed: %s", ErrDirectory, res)
+			}
+		default:
+			return nil, fmt.Errorf("%w: unexpected response to search", ErrDirectory)
+		}
+	}
+}
+
+// parseEntry は SearchResultEntry を読み込む (RFC 4511 Section 4.5.2)。
+// 属性名は小文字にそろえ、AD の範囲指定 (member;range=0-1499 など) のオプションは取り除く
+func parseEntry(op *packet) (*model.LDAPEntry, error) {
+	name, attrs := op.child(0), op.child(1)
+	if name == nil || attrs == nil || attrs.tag != tagSequence {
+		return nil, fmt.Errorf("%w: malformed search result entry", ErrDirectory)
+	}
+	entry := &model.LDAPEntry{DN: name.str(), Attributes: map[string][]string{}}
+	for _, attr := range attrs.children {
+		desc, vals := attr.child(0), attr.child(1)
+		if desc == nil || vals == nil || vals.tag != tagSet {
+			return nil, fmt.Errorf("%w: malformed attribute", ErrDirectory)
+		}
+		key := strings.ToLower(desc.str())
+		if i := strings.IndexByte(key, ';'); i >= 0 {
+			key = key[:i]
+		}
+		for _, v := range vals.children {
+			entry.Attributes[key] = append(entry.Attributes[key], v.str())
+		}
+	}
+	return entry, nil
+}
@@ -0,0 +1,449 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	testServiceDN       = "CN=svc,DC=example,DC=com"
+	testServicePassword = "svc-secret"
+	testUserFilter      = "(&(objectClass=user)(sAMAccountName={username}))"
+)
+
+// resultProtocolError は StartTLS を拒否するときの結果コード (RFC 4511 Appendix A)
+const resultProtocolError = 2
+
+type testEntry struct {
+	dn       string
+	password string
+	attrs    map[string][]string
+}
+
+// testDirectory はテスト用の LDAP サーバー。bind・search・StartTLS だけに応答する
+type testDirectory struct {
+	t        *testing.T
+	ln       net.Listener
+	tls      *tls.Config
+	caPEM    string
+	security string
+	entries  []*testEntry
+	// startTLSResult は StartTLS に返す結果コード
+	startTLSResult int64
+
+	mu      sync.Mutex
+	conns   int
+	binds   []string
+	filters []string
+}
+
+func newTestDirectory(t *testing.T, security string) *testDirectory {
+	cert, caPEM := newTestCertificate(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	d := &testDirectory{
+		t:        t,
+		ln:       ln,
+		tls:      &tls.Config{Certificates: []tls.Certificate{cert}},
+		caPEM:    caPEM,
+		security: security,
+		entries: []*testEntry{
+			{
+				dn:       "CN=Alice,OU=Users,DC=example,DC=com",
+				password: "alice-password",
+				attrs: map[string][]string{
+					"objectClass":    {"top", "person", "user"},
+					"sAMAccountName": {"alice"},
+					"mail":           {"alice@example.com"},
+					"objectGUID":     {"\x01\x02\x03\x04"},
+				},
+			},
+			{
+				dn:       "CN=Bob,OU=Users,DC=example,DC=com",
+				password: "bob-password",
+				attrs: map[string][]string{
+					"objectClass":    {"top", "person", "user"},
+					"sAMAccountName": {"bob"},
+					"mail":           {"bob@example.com"},
+				},
+			},
+		},
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			d.mu.Lock()
+			d.conns++
+			d.mu.Unlock()
+			go d.serve(c)
+		}
+	}()
+	return d
+}
+
+// newTestCertificate は 127.0.0.1 の自己署名証明書と、それを信頼する CA 証明書 (PEM) を返す
+func newTestCertificate(t *testing.T) (tls.Certificate, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "ldap.example.com"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key},
+		string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
+
+func (d *testDirectory) request(loginID string) *model.LDAPSearchRequest {
+	return &model.LDAPSearchRequest{
+		Host:          "127.0.0.1",
+		Port:          d.ln.Addr().(*net.TCPAddr).Port,
+		Security:      d.security,
+		CACertificate: d.caPEM,
+		BindDN:        testServiceDN,
+		BindPassword:  testServicePassword,
+		SearchBase:    "DC=example,DC=com",
+		Filter:        testUserFilter,
+		LoginID:       loginID,
+		Attributes:    []string{"objectGUID", "mail"},
+	}
+}
+
+func (d *testDirectory) serve(raw net.Conn) {
+	defer raw.Close()
+	var c net.Conn = raw
+	if d.security == model.LDAPSecurityLDAPS {
+		c = tls.Server(raw, d.tls)
+	}
+	r := bufio.NewReader(c)
+	for {
+		msg, err := readPacket(r)
+		if err != nil {
+			return
+		}
+		id, err := msg.child(0).int()
+		if err != nil {
+			return
+		}
+		op := msg.child(1)
+		switch op.tag {
+		case opExtendedRequest:
+			d.reply(c, id, ldapResult(opExtendedResponse, d.startTLSResult))
+			if d.startTLSResult != resultSuccess {
+				continue
+			}
+			c = tls.Server(raw, d.tls)
+			r = bufio.NewReader(c)
+		case opBindRequest:
+			d.reply(c, id, ldapResult(opBindResponse, d.bind(op.child(1).str(), op.child(2).str())))
+		case opSearchRequest:
+			for _, e := range d.search(op.child(6)) {
+				d.reply(c, id, e)
+			}
+			d.reply(c, id, ldapResult(opSearchResultDone, resultSuccess))
+		default:
+			return
+		}
+	}
+}
+
+func (d *testDirectory) reply(c net.Conn, id int64, op *packet) {
+	msg := newConstructed(tagSequence, newInteger(tagInteger, id), op)
+	if _, err := c.Write(msg.encode()); err != nil {
+		d.t.Logf("write: %v", err)
+	}
+}
+
+func ldapResult(tag byte, code int64) *packet {
+	return newConstructed(tag, newInteger(tagEnumerated, code), newString(tagOctetString, ""), newString(tagOctetString, ""))
+}
+
+func (d *testDirectory) bind(dn, password string) int64 {
+	d.mu.Lock()
+	d.binds = append(d.binds, dn)
+	d.mu.Unlock()
+	if dn == testServiceDN && password == testServicePassword {
+		return resultSuccess
+	}
+	for _, e := range d.entries {
+		if strings.EqualFold(e.dn, dn) && e.password == password {
+			return resultSuccess
+		}
+	}
+	return resultInvalidCredentials
+}
+
+func (d *testDirectory) search(filter *packet) []*packet {
+	d.mu.Lock()
+	d.filters = append(d.filters, formatFilter(filter))
+	d.mu.Unlock()
+	var results []*packet
+	for _, e := range d.entries {
+		if !matchTestFilter(filter, e) {
+			continue
+		}
+		attrs := newConstructed(tagSequence)
+		for name, values := range e.attrs {
+			set := newConstructed(tagSet)
+			for _, v := range values {
+				set.children = append(set.children, newString(tagOctetString, v))
+			}
+			attrs.children = append(attrs.children, newConstructed(tagSequence, newString(tagOctetString, name), set))
+		}
+		results = append(results, newConstructed(opSearchResultEntry, newString(tagOctetString, e.dn), attrs))
+	}
+	return results
+}
+
+// matchTestFilter は and・or・not・等価比較・存在だけを評価する
+func matchTestFilter(f *packet, e *testEntry) bool {
+	values := func(name string) []string {
+		for k, v := range e.attrs {
+			if strings.EqualFold(k, name) {
+				return v
+			}
+		}
+		return nil
+	}
+	switch f.tag &^ constructed {
+	case filterAnd:
+		for _, c := range f.children {
+			if !matchTestFilter(c, e) {
+				return false
+			}
+		}
+		return true
+	case filterOr:
+		for _, c := range f.children {
+			if matchTestFilter(c, e) {
+				return true
+			}
+		}
+		return false
+	case filterNot:
+		return !matchTestFilter(f.child(0), e)
+	case filterPresent:
+		return values(f.str()) != nil
+	case filterEqualityMatch:
+		for _, v := range values(f.child(0).str()) {
+			if strings.EqualFold(v, f.child(1).str()) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (d *testDirectory) stats() (int, []string, []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.conns, append([]string(nil), d.binds...), append([]string(nil), d.filters...)
+}
+
+func TestClientAuthenticate(t *testing.T) {
+	for _, security := range []string{model.LDAPSecurityLDAPS, model.LDAPSecurityStartTLS} {
+		t.Run(security, func(t *testing.T) {
+			d := newTestDirectory(t, security)
+			entry, ok, err := NewClient().Authenticate(context.Background(), d.request("alice"), "alice-password")
+			if err != nil || !ok {
+				t.Fatalf("ok = %v, err = %v", ok, err)
+			}
+			if entry.DN != "CN=Alice,OU=Users,DC=example,DC=com" || entry.First("mail") != "alice@example.com" || entry.First("objectguid") != "\x01\x02\x03\x04" {
+				t.Errorf("entry = %+v", entry)
+			}
+			_, binds, filters := d.stats()
+			if len(binds) != 2 || binds[0] != testServiceDN || binds[1] != entry.DN {
+				t.Errorf("binds = %v", binds)
+			}
+			if len(filters) != 1 || filters[0] != `(& (= objectClass "user") (= sAMAccountName "alice"))` {
+				t.Errorf("filters = %v", filters)
+			}
+		})
+	}
+}
+
+func TestClientAuthenticateBadPassword(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityLDAPS)
+	// エントリは返すがパスワードは正しくない
+	entry, ok, err := NewClient().Authenticate(context.Background(), d.request("alice"), "bob-password")
+	if err != nil || ok || entry == nil || entry.DN != "CN=Alice,OU=Users,DC=example,DC=com" {
+		t.Errorf("entry = %+v, ok = %v, err = %v", entry, ok, err)
+	}
+}
+
+func TestClientAuthenticateUserNotFound(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityLDAPS)
+	entry, ok, err := NewClient().Authenticate(context.Background(), d.request("carol"), "carol-password")
+	if err != nil || ok || entry != nil {
+		t.Errorf("entry = %+v, ok = %v, err = %v", entry, ok, err)
+	}
+	// 見つからないユーザーの bind はしない
+	if _, binds, _ := d.stats(); len(binds) != 1 {
+		t.Errorf("binds = %v", binds)
+	}
+}
+
+func TestClientAuthenticateFilterInjection(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityLDAPS)
+	// エスケープしなければ全ユーザーや他のユーザーに一致する login_id
+	loginIDs := []string{"*", "a*", "*)(sAMAccountName=alice", "bob)(|(sAMAccountName=*)"}
+	for _, loginID := range loginIDs {
+		entry, ok, err := NewClient().Authenticate(context.Background(), d.request(loginID), "alice-password")
+		if err != nil || ok || entry != nil {
+			t.Errorf("%q: entry = %+v, ok = %v, err = %v", loginID, entry, ok, err)
+		}
+	}
+	_, _, filters := d.stats()
+	for i, loginID := range loginIDs {
+		if want := fmt.Sprintf(`(& (= objectClass "user") (= sAMAccountName %q))`, loginID); filters[i] != want {
+			t.Errorf("filter = %s, want %s", filters[i], want)
+		}
+	}
+}
+
+func TestClientAuthenticateEmptyPassword(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityLDAPS)
+	// 空のパスワードでの bind は認証なしの bind として成功しうるため、接続せずに拒否する
+	if _, ok, err := NewClient().Authenticate(context.Background(), d.request("alice"), ""); ok || !errors.Is(err, ErrDirectory) {
+		t.Errorf("ok = %v, err = %v", ok, err)
+	}
+	if conns, _, _ := d.stats(); conns != 0 {
+		t.Errorf("connected %d times", conns)
+	}
+}
+
+func TestClientAuthenticateDirectoryErrors(t *testing.T) {
+	tests := []struct {
+		name     string
+		security string
+		setup    func(d *testDirectory, req *model.LDAPSearchRequest)
+		want     string
+	}{
+		{
+			name:     "StartTLS の拒否",
+			security: model.LDAPSecurityStartTLS,
+			setup:    func(d *testDirectory, _ *model.LDAPSearchRequest) { d.startTLSResult = resultProtocolError },
+			want:     "StartTLS failed",
+		},
+		{
+			name:     "StartTLS 後に信頼できない証明書",
+			security: model.LDAPSecurityStartTLS,
+			setup: func(d *testDirectory, req *model.LDAPSearchRequest) {
+				_, req.CACertificate = newTestCertificate(d.t)
+			},
+			want: "tls handshake failed",
+		},
+		{
+			name:     "信頼できない証明書",
+			security: model.LDAPSecurityLDAPS,
+			setup: func(d *testDirectory, req *model.LDAPSearchRequest) {
+				_, req.CACertificate = newTestCertificate(d.t)
+			},
+			want: "tls handshake failed",
+		},
+		{
+			name:     "証明書のホスト名が一致しない",
+			security: model.LDAPSecurityLDAPS,
+			setup:    func(_ *testDirectory, req *model.LDAPSearchRequest) { req.Host = "localhost" },
+			want:     "tls handshake failed",
+		},
+		{
+			name:     "不正な CA 証明書",
+			security: model.LDAPSecurityLDAPS,
+			setup:    func(_ *testDirectory, req *model.LDAPSearchRequest) { req.CACertificate = "not a certificate" },
+			want:     "invalid ca certificate",
+		},
+		{
+			name:     "平文の接続",
+			security: model.LDAPSecurityLDAPS,
+			setup:    func(_ *testDirectory, req *model.LDAPSearchRequest) { req.Security = "none" },
+			want:     "unsupported security",
+		},
+		{
+			name:     "サービスアカウントのパスワード誤り",
+			security: model.LDAPSecurityLDAPS,
+			setup:    func(_ *testDirectory, req *model.LDAPSearchRequest) { req.BindPassword = "wrong" },
+			want:     "service account bind failed",
+		},
+		{
+			name:     "サービスアカウントのパスワード未設定",
+			security: model.LDAPSecurityLDAPS,
+			setup:    func(_ *testDirectory, req *model.LDAPSearchRequest) { req.BindPassword = "" },
+			want:     "bind password is not set",
+		},
+		{
+			name:     "複数のエントリに一致",
+			security: model.LDAPSecurityLDAPS,
+			setup: func(_ *testDirectory, req *model.LDAPSearchRequest) {
+				req.Filter, req.LoginID = "(objectClass={username})", "user"
+			},
+			want: "more than one entry",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d := newTestDirectory(t, tt.security)
+			req := d.request("alice")
+			tt.setup(d, req)
+			entry, ok, err := NewClient().Authenticate(context.Background(), req, "alice-password")
+			if !errors.Is(err, ErrDirectory) || !strings.Contains(err.Error(), tt.want) || ok || entry != nil {
+				t.Errorf("entry = %+v, ok = %v, err = %v", entry, ok, err)
+			}
+		})
+	}
+}
+
+func TestClientLookup(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityStartTLS)
+	entry, err := NewClient().Lookup(context.Background(), d.request("BOB"))
+	if err != nil || entry == nil || entry.DN != "CN=Bob,OU=Users,DC=example,DC=com" {
+		t.Fatalf("entry = %+v, err = %v", entry, err)
+	}
+	if _, binds, _ := d.stats(); len(binds) != 1 || binds[0] != testServiceDN {
+		t.Errorf("binds = %v", binds)
+	}
+}
+
+func TestClientCanceled(t *testing.T) {
+	d := newTestDirectory(t, model.LDAPSecurityLDAPS)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := NewClient().Authenticate(ctx, d.request("alice"), "alice-password"); !errors.Is(err, ErrDirectory) {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+)
+
+// UsernamePlaceholder は検索フィルタのうちログイン時に入力された login_id で置き換える部分
+const UsernamePlaceholder = "{username}"
+
+// maxFilterDepth はフィルタの入れ子の上限
+const maxFilterDepth = 16
+
+// Filter の CHOICE のタグ (RFC 4511 Section 4.5.1)
+const (
+	filterAnd            = classContext | 0
+	filterOr             = classContext | 1
+	filterNot            = classContext | 2
+	filterEqualityMatch  = classContext | 3
+	filterSubstrings     = classContext | 4
+	filterGreaterOrEqual = classContext | 5
+	filterLessOrEqual    = classContext | 6
+	filterPresent        = classContext | 7
+	filterApproxMatch    = classContext | 8
+)
+
+// SubstringFilter の CHOICE のタグ
+const (
+	substringInitial = classContext | 0
+	substringAny     = classContext | 1
+	substringFinal   = classContext | 2
+)
+
+// EscapeFilter はフィルタの値として扱うよう、特別な意味を持つ文字をエスケープする (RFC 4515 Section 3)。
+func EscapeFilter(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '*', '(', ')', '\\', 0:
+			fmt.Fprintf(&b, "\\%02x", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// userFilter は検索フィルタのテンプレートの {username} をエスケープした login_id で置き換える。
+func userFilter(template, loginID string) string {
+	return strings.ReplaceAll(template, UsernamePlaceholder, EscapeFilter(loginID))
+}
+
+// compileFilter は文字列表現の検索フィルタ (RFC 4515) を BER の Filter に変換する。
+// 拡張一致 (:=) には対応しない
+func compileFilter(s string) (*packet, error) {
+	p := &filterParser{s: strings.TrimSpace(s)}
+	f, err := p.parseFilter(0)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.s) {
+		return nil, fmt.Errorf("unexpected characters after filter")
+	}
+	return f, nil
+}
+
+type filterParser struct {
+	s   string
+	pos int
+}
+
+func (p *filterParser) peek() byte {
+	if p.pos >= len(p.s) {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *filterParser) expect(c byte) error {
+	if p.peek() != c {
+		return fmt.Errorf("expected %q at position %d", c, p.pos)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseFilter(depth int) (*packet, error) {
+	if depth > maxFilterDepth {
+		return nil, fmt.Errorf("filter is nested too deeply")
+	}
+	if err := p.expect('('); err != nil {
+		return nil, err
+	}
+
+	var f *packet
+	var err error
+	switch p.peek() {
+	case '&':
+		p.pos++
+		f, err = p.parseList(filterAnd, depth)
+	case '|':
+		p.pos++
+		f, err = p.parseList(filterOr, depth)
+	case '!':
+		p.pos++
+		var inner *packet
+		if inner, err = p.parseFilter(depth + 1); err == nil {
+			f = newConstructed(filterNot, inner)
+		}
+	default:
+		f, err = p.parseItem()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.expect(')'); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func (p *filterParser) parseList(tag byte, depth int) (*packet, error) {
+	list := newConstructed(tag)
+	for p.peek() == '(' {
+		f, err := p.parseFilter(depth + 1)
+		if err != nil {
+			return nil, err
+		}
+		list.children = append(list.children, f)
+	}
+	if len(list.children) == 0 {
+		return nil, fmt.Errorf("empty filter list at position %d", p.pos)
+	}
+	return list, nil
+}
+
+// parseItem は attr=value 形式の比較を読み込む。値の ")" は \29 とエスケープされているため、次の ")" までが比較になる
+func (p *filterParser) parseItem() (*packet, error) {
+	end := strings.IndexByte(p.s[p.pos:], ')')
+	if end < 0 {
+		return nil, fmt.Errorf("unterminated filter")
+	}
+	item := p.s[p.pos : p.pos+end]
+	p.pos += end
+
+	eq := strings.IndexByte(item, '=')
+	if eq <= 0 {
+		return nil, fmt.Errorf("invalid filter item %q", item)
+	}
+	attr, value := item[:eq], item[eq+1:]
+	tag := filterEqualityMatch
+	switch attr[len(attr)-1] {
+	case '~':
+		tag, attr = filterApproxMatch, attr[:len(attr)-1]
+	case '>':
+		tag, attr = filterGreaterOrEqual, attr[:len(attr)-1]
+	case '<':
+		tag, attr = filterLessOrEqual, attr[:len(attr)-1]
+	case ':':
+		return nil, fmt.Errorf("extensible match is not supported")
+	}
+	if !isAttributeDescription(attr) {
+		return nil, fmt.Errorf("invalid attribute description %q", attr)
+	}
+
+	if tag == filterEqualityMatch && value == "*" {
+		return newString(filterPresent, attr), nil
+	}
+	if tag == filterEqualityMatch && strings.Contains(value, "*") {
+		return substringFilter(attr, value)
+	}
+	assertion, err := unescapeValue(value)
+	if err != nil {
+		return nil, err
+	}
+	return newConstructed(tag, newString(tagOctetString, attr), newString(tagOctetString, assertion)), nil
+}
+
+// substringFilter は initial*any*...*final 形式の値を SubstringFilter にする。
+func substringFilter(attr, value string) (*packet, error) {
+	parts := strings.Split(value, "*")
+	substrings := newConstructed(tagSequence)
+	for i, part := range parts {
+		if part == "" {
+			// 先頭と末尾以外の "**" は認めない
+			if i != 0 && i != len(parts)-1 {
+				return nil, fmt.Errorf("invalid substring filter %q", value)
+			}
+			continue
+		}
+		s, err := unescapeValue(part)
+		if err != nil {
+			return nil, err
+		}
+		tag := substringAny
+		switch i {
+		case 0:
+			tag = substringInitial
+		case len(parts) - 1:
+			tag = substringFinal
+		}
+		substrings.children = append(substrings.children, newString(tag, s))
+	}
+	return newConstructed(filterSubstrings, newString(tagOctetString, attr), substrings), nil
+}
+
+// unescapeValue は \XX 形式のエスケープを元のバイトに戻す (RFC 4515 Section 3)。
+func unescapeValue(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			if i+2 >= len(s) {
+				return "", fmt.Errorf("invalid escape in filter value")
+			}
+			hi, ok1 := fromHex(s[i+1])
+			lo, ok2 := fromHex(s[i+2])
+			if !ok1 || !ok2 {
+				return "", fmt.Errorf("invalid escape in filter value")
+			}
+			b.WriteByte(hi<<4 | lo)
+			i += 2
+		case '(', ')', '*', 0:
+			return "", fmt.Errorf("unescaped %q in filter value", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+func fromHex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// isAttributeDescription は属性の記述 (名前または OID とオプション) の形式か判定する (RFC 4512 Section 2.5)
+func isAttributeDescription(s string) bool {
+	if s == "" {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '-' || c == '.' || c == ';') {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,148 @@
+package ldap
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// formatFilter は Filter を S 式の文字列にする
+func formatFilter(p *packet) string {
+	switch p.tag &^ constructed {
+	case filterAnd, filterOr, filterNot:
+		op := map[byte]string{filterAnd: "&", filterOr: "|", filterNot: "!"}[p.tag&^constructed]
+		parts := []string{op}
+		for _, c := range p.children {
+			parts = append(parts, formatFilter(c))
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	case filterPresent:
+		return fmt.Sprintf("(present %s)", p.str())
+	case filterSubstrings:
+		parts := []string{"substr", p.child(0).str()}
+		for _, s := range p.child(1).children {
+			kind := map[byte]string{substringInitial: "initial", substringAny: "any", substringFinal: "final"}[s.tag]
+			parts = append(parts, fmt.Sprintf("%s:%q", kind, s.str()))
+		}
+		return "(" + strings.Join(parts, " ") + ")"
+	}
+	op := map[byte]string{filterEqualityMatch: "=", filterGreaterOrEqual: ">=", filterLessOrEqual: "<=", filterApproxMatch: "~="}[p.tag&^constructed]
+	return fmt.Sprintf("(%s %s %q)", op, p.child(0).str(), p.child(1).str())
+}
+
+func TestEscapeFilter(t *testing.T) {
+	for s, want := range map[string]string{
+		"alice":            "alice",
+		"*":                `\2a`,
+		"a(b)c":            `a\28b\29c`,
+		`dom\user`:         `dom\5cuser`,
+		"a\x00b":           `a\00b`,
+		"日本語":              "日本語",
+		"*)(uid=*))(|(a=*": `\2a\29\28uid=\2a\29\29\28|\28a=\2a`,
+	} {
+		if got := EscapeFilter(s); got != want {
+			t.Errorf("EscapeFilter(%q) = %s, want %s", s, got, want)
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: "(uid=alice)", want: `(= uid "alice")`},
+		{filter: " (uid=alice) ", want: `(= uid "alice")`},
+		{filter: "(&(objectClass=person)(|(uid=a)(mail=a@example.com)))", want: `(& (= objectClass "person") (| (= uid "a") (= mail "a@example.com")))`},
+		{filter: "(!(cn=x))", want: `(! (= cn "x"))`},
+		{filter: "(cn=*)", want: "(present cn)"},
+		{filter: "(cn=a*b*c)", want: `(substr cn initial:"a" any:"b" final:"c")`},
+		{filter: "(cn=*b*)", want: `(substr cn any:"b")`},
+		{filter: "(cn=a\\2a*)", want: `(substr cn initial:"a*")`},
+		{filter: "(grade>=5)", want: `(>= grade "5")`},
+		{filter: "(grade<=5)", want: `(<= grade "5")`},
+		{filter: "(cn~=alice)", want: `(~= cn "alice")`},
+		{filter: "(cn=a\\2ab)", want: `(= cn "a*b")`},
+		{filter: "(cn=\\28\\29\\5C)", want: `(= cn "()\\")`},
+		{filter: "(objectGUID=\\00\\ff)", want: `(= objectGUID "\x00\xff")`},
+		{filter: "(cn;lang-ja=x)", want: `(= cn;lang-ja "x")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			f, err := compileFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatFilter(f); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		"",
+		"uid=alice",
+		"(uid=alice",
+		"(uid=alice))",
+		"(uid=alice)(uid=bob)",
+		"(&)",
+		"(|)",
+		"(!)",
+		"(=alice)",
+		"(u id=alice)",
+		"(cn:dn:=alice)",
+		"(userAccountControl:1.2.840.113556.1.4.803:=2)",
+		"(cn=a**b)",
+		"(cn=a(b)",
+		"(cn=\\2)",
+		"(cn=\\zz)",
+		strings.Repeat("(!", maxFilterDepth+2) + "(a=b)" + strings.Repeat(")", maxFilterDepth+2),
+	} {
+		if f, err := compileFilter(filter); err == nil {
+			t.Errorf("%q: compiled %s", filter, formatFilter(f))
+		}
+	}
+}
+
+func TestUserFilterEscapesLoginID(t *testing.T) {
+	template := "(&(objectClass=user)(sAMAccountName={username}))"
+	// login_id はフィルタの構造を変えず、そのままの値の等価比較になる
+	for _, loginID := range []string{
+		"alice",
+		"*",
+		"alice*",
+		"*)(objectClass=*",
+		"admin)(|(sAMAccountName=*)",
+		`alice\2a`,
+		"alice\x00",
+		"{username}",
+	} {
+		f, err := compileFilter(userFilter(template, loginID))
+		if err != nil {
+			t.Errorf("%q: %v", loginID, err)
+			continue
+		}
+		want := fmt.Sprintf(`(& (= objectClass "user") (= sAMAccountName %q))`, loginID)
+		if got := formatFilter(f); got != want {
+			t.Errorf("%q: got %s", loginID, got)
+		}
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	c := NewClient()
+	if err := c.ValidateFilter("(&(objectClass=user)(sAMAccountName={username}))"); err != nil {
+		t.Error(err)
+	}
+	for _, template := range []string{
+		"(sAMAccountName=alice)",
+		"(sAMAccountName={username}",
+		"(sAMAccountName:dn:={username})",
+	} {
+		if err := c.ValidateFilter(template); err == nil {
+			t.Errorf("%q accepted", template)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+// Package mailer はメール送信 (auth.MailSender) の実装を提供する。
+package mailer
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// outboxCapacity は Outbox が保持するメールの上限。超えた分は古いものから捨てる
+const outboxCapacity = 100
+
+// Outbox は送信したメールをメモリに保持してログに出力する。SMTP サーバーのないローカル開発・テスト用で、
+// ログに出た確認 URL をブラウザで開くとメールを受け取ったユーザーとして操作できる。
+type Outbox struct {
+	logger *log.Logger
+
+	mu       sync.Mutex
+	messages []model.MailMessage
+}
+
+// NewOutbox は Outbox を生成する。
+func NewOutbox(logger *log.Logger) *Outbox {
+	return &Outbox{logger: logger}
+}
+
+// SendMail はメールを Outbox に追加し、内容をログに出力する。
+func (o *Outbox) SendMail(_ context.Context, msg *model.MailMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, *msg)
+	if len(o.messages) > outboxCapacity {
+		o.messages = o.messages[len(o.messages)-outboxCapacity:]
+	}
+	o.logger.Printf("[mail] to=%s subject=%q\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// Messages は to 宛てに送信したメールを送信順に返す。to が空の場合は全てのメールを返す。
+func (o *Outbox) Messages(to string) []model.MailMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var out []model.MailMessage
+	for _, msg := range o.messages {
+		if to == "" || msg.To == to {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
@@ -0,0 +1,38 @@
+package mailer
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+func TestOutbox(t *testing.T) {
+	o := NewOutbox(log.New(io.Discard, "", 0))
+	for i := 0; i < outboxCapacity+1; i++ {
+		to := "alice@example.com"
+		if i%2 == 1 {
+			to = "bob@example.com"
+		}
+		if err := o.SendMail(context.Background(), &model.MailMessage{To: to, Subject: fmt.Sprint(i)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 上限を超えた分は古いものから捨てる
+	all := o.Messages("")
+	if len(all) != outboxCapacity || all[0].Subject != "1" || all[len(all)-1].Subject != fmt.Sprint(outboxCapacity) {
+		t.Errorf("messages = %d, first = %q", len(all), all[0].Subject)
+	}
+	for _, msg := range o.Messages("bob@example.com") {
+		if msg.To != "bob@example.com" {
+			t.Errorf("message to %s", msg.To)
+		}
+	}
+	if n := len(o.Messages("bob@example.com")); n != outboxCapacity/2 {
+		t.Errorf("bob = %d", n)
+	}
+}
@@ -0,0 +1,272 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// APIResourceHandler は API リソース (RFC 8707) の管理エンドポイントを処理する。
+type APIResourceHandler struct {
+	resourceStore APIResourceStore
+	tenantStore   TenantStore
+}
+
+// NewAPIResourceHandler は APIResourceHandler を生成する。
+func NewAPIResourceHandler(resourceStore APIResourceStore, tenantStore TenantStore) *APIResourceHandler {
+	return &APIResourceHandler{
+		resourceStore: resourceStore,
+		tenantStore:   tenantStore,
+	}
+}
+
+type createAPIResourceRequest struct {
+	Identifier string   `json:"identifier"`
+	Name       string   `json:"name"`
+	Scopes     []string `json:"scopes"`
+	// AccessTokenLifetime は省略時にテナントの設定を使う
+	AccessTokenLifetime *int `json:"access_token_lifetime,omitempty"`
+}
+
+// updateAPIResourceRequest は発行済みトークンの aud と整合しなくなるため identifier の変更を受け付けない。
+type updateAPIResourceRequest struct {
+	Name   *string  `json:"name,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	// AccessTokenLifetime に 0 を指定するとテナントの設定に戻す
+	AccessTokenLifetime *int `json:"access_token_lifetime,omitempty"`
+}
+
+type apiResourceResponse struct {
+	ID                  string   `json:"id"`
+	TenantID            string   `json:"tenant_id"`
+	Identifier          string   `json:"identifier"`
+	Name                string   `json:"name"`
+	Scopes              []string `json:"scopes"`
+	AccessTokenLifetime *int     `json:"access_token_lifetime,omitempty"`
+	CreatedAt           string   `json:"created_at"`
+	UpdatedAt           string   `json:"updated_at"`
+}
+
+func toAPIResourceResponse(r *model.APIResource) apiResourceResponse {
+	return apiResourceResponse{
+		ID:                  r.ID.String(),
+		TenantID:            r.TenantID.String(),
+		Identifier:          r.Identifier,
+		Name:                r.Name,
+		Scopes:              nonNilStrings(r.Scopes),
+		AccessTokenLifetime: r.AccessTokenLifetime,
+		CreatedAt:           r.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:           r.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/api-resources を処理する。
+func (h *APIResourceHandler) HandleList(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	resources, err := h.resourceStore.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to list api resources: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]apiResourceResponse, len(resources))
+	for i, r := range resources {
+		data[i] = toAPIResourceResponse(&r)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/api-resources を処理する。
+func (h *APIResourceHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	var req createAPIResourceRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if err := validateResourceIdentifier(req.Identifier); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if req.Name == "" || len(req.Name) > 255 {
+		return badRequest(c, "name is required and must be at most 255 characters")
+	}
+	if err := validateResourceScopes(req.Scopes); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateLifetimes(req.AccessTokenLifetime); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	// 重複チェック
+	existing, err := h.resourceStore.FindByIdentifier(ctx, tenantID, req.Identifier)
+	if err != nil {
+		c.Logger().Errorf("failed to check api resource identifier: %v", err)
+		return serverError(c)
+	}
+	if existing != nil {
+		return conflict(c, "api resource identifier already exists")
+	}
+
+	resource := &model.APIResource{
+		TenantID:            tenantID,
+		Identifier:          req.Identifier,
+		Name:                req.Name,
+		Scopes:              model.StringSlice(req.Scopes),
+		AccessTokenLifetime: req.AccessTokenLifetime,
+	}
+	if err := h.resourceStore.Create(ctx, resource); err != nil {
+		c.Logger().Errorf("failed to create api resource: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, toAPIResourceResponse(resource))
+}
+
+// HandleGet は GET /management/v1/api-resources/:id を処理する。
+func (h *APIResourceHandler) HandleGet(c echo.Context) error {
+	resource, err := h.findResource(c)
+	if err != nil || resource == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toAPIResourceResponse(resource))
+}
+
+// HandleUpdate は PUT /management/v1/api-resources/:id を処理する。
+func (h *APIResourceHandler) HandleUpdate(c echo.Context) error {
+	resource, err := h.findResource(c)
+	if err != nil || resource == nil {
+		return err
+	}
+
+	var req updateAPIResourceRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" || len(*req.Name) > 255 {
+			return badRequest(c, "name must be 1-255 characters")
+		}
+		resource.Name = *req.Name
+	}
+	if req.Scopes != nil {
+		if err := validateResourceScopes(req.Scopes); err != nil {
+			return badRequest(c, err.Error())
+		}
+		resource.Scopes = model.StringSlice(req.Scopes)
+	}
+	if req.AccessTokenLifetime != nil {
+		switch {
+		case *req.AccessTokenLifetime == 0:
+			resource.AccessTokenLifetime = nil
+		case *req.AccessTokenLifetime < 0:
+			return badRequest(c, "lifetime values must be positive")
+		default:
+			resource.AccessTokenLifetime = req.AccessTokenLifetime
+		}
+	}
+
+	if err := h.resourceStore.Update(c.Request().Context(), resource); err != nil {
+		c.Logger().Errorf("failed to update api resource: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toAPIResourceResponse(resource))
+}
+
+// HandleDelete は DELETE /management/v1/api-resources/:id を処理する。
+// 発行済みのアクセストークンは失効させない (有効期限まで aud として有効)。
+func (h *APIResourceHandler) HandleDelete(c echo.Context) error {
+	resource, err := h.findResource(c)
+	if err != nil || resource == nil {
+		return err
+	}
+
+	if err := h.resourceStore.Delete(c.Request().Context(), resource.ID); err != nil {
+		c.Logger().Errorf("failed to delete api resource: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findResource はパスの :id で API リソースを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *APIResourceHandler) findResource(c echo.Context) (*model.APIResource, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid api resource id format")
+	}
+
+	resource, err := h.resourceStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find api resource: %v", err)
+		return nil, serverError(c)
+	}
+	if resource == nil {
+		return nil, notFound(c, "api resource not found")
+	}
+	return resource, nil
+}
+
+// validateResourceIdentifier はリソース識別子が絶対 URI でフラグメントを含まないことを検証する (MUST: RFC 8707 Section 2)。
+func validateResourceIdentifier(identifier string) error {
+	if identifier == "" || len(identifier) > 2048 {
+		return fmt.Errorf("identifier is required and must be at most 2048 characters")
+	}
+	parsed, err := url.Parse(identifier)
+	if err != nil || !parsed.IsAbs() {
+		return fmt.Errorf("identifier must be an absolute URI")
+	}
+	if parsed.Fragment != "" || strings.Contains(identifier, "#") {
+		return fmt.Errorf("identifier must not contain a fragment")
+	}
+	return nil
+}
+
+// validateResourceScopes はスコープが空白を含まない単一の値であることを検証する。
+func validateResourceScopes(scopes []string) error {
+	for _, s := range scopes {
+		if s == "" || len(s) > 255 || strings.ContainsAny(s, " \t\n") {
+			return fmt.Errorf("scopes must contain single scope values of at most 255 characters")
+		}
+	}
+	return nil
+}
@@ -17,6 +17,8 @@ type AdminUserFinder interface {
 	FindByID(ctx context.Context, id uuid.UUID) (*model.AdminUser, error)
 	// UpdateLastLoginAt は最終ログイン日時を更新する。
 	UpdateLastLoginAt(ctx context.Context, id uuid.UUID, t time.Time) error
+	// RehashPassword はパスワードのハッシュが from のままの場合に to で置き換える。置き換えなかった場合は false を返す
+	RehashPassword(ctx context.Context, id uuid.UUID, from, to string) (bool, error)
 }
 
 // AdminSessionStore は管理者セッションの永続化を管理する。
@@ -30,4 +32,7 @@ type AdminSessionStore interface {
 }
 
 // PasswordVerifyFunc は平文パスワードをハッシュと照合する。
-type PasswordVerifyFunc func(password, hash string) (bool, error)
+type PasswordVerifyFunc func(ctx context.Context, password, hash string) (bool, error)
+
+// PasswordNeedsRehashFunc はハッシュを HashPasswordFunc で作り直すべきか判定する
+type PasswordNeedsRehashFunc func(hash string) bool
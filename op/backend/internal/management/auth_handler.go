@@ -6,6 +6,8 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
 )
 
 const adminCookieName = "op_admin_session"
@@ -51,6 +53,10 @@ func (h *AdminAuthHandler) HandleLogin(c echo.Context) error {
 		if errors.Is(err, ErrAdminInvalidCredentials) {
 			return errorJSON(c, http.StatusUnauthorized, "invalid_credentials", "login ID or password is incorrect")
 		}
+		if errors.Is(err, crypto.ErrPasswordHasherBusy) {
+			c.Logger().Warnf("admin login error: %v", err)
+			return errorJSON(c, http.StatusServiceUnavailable, "temporarily_unavailable", "too many concurrent logins, retry later")
+		}
 		c.Logger().Errorf("admin login error: %v", err)
 		return serverError(c)
 	}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
 
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
@@ -27,6 +28,10 @@ type AdminAuthService struct {
 	userFinder     AdminUserFinder
 	sessionStore   AdminSessionStore
 	verifyPassword PasswordVerifyFunc
+	hashPassword   HashPasswordFunc
+	needsRehash    PasswordNeedsRehashFunc
+	// logger はハッシュの作り直しの失敗を記録する。ハンドラと同じ echo のロガーを渡す
+	logger echo.Logger
 }
 
 // NewAdminAuthService は AdminAuthService を生成する。
@@ -34,11 +39,17 @@ func NewAdminAuthService(
 	userFinder AdminUserFinder,
 	sessionStore AdminSessionStore,
 	verifyPassword PasswordVerifyFunc,
+	hashPassword HashPasswordFunc,
+	needsRehash PasswordNeedsRehashFunc,
+	logger echo.Logger,
 ) *AdminAuthService {
 	return &AdminAuthService{
 		userFinder:     userFinder,
 		sessionStore:   sessionStore,
 		verifyPassword: verifyPassword,
+		hashPassword:   hashPassword,
+		needsRehash:    needsRehash,
+		logger:         logger,
 	}
 }
 
@@ -56,7 +67,7 @@ func (s *AdminAuthService) Login(ctx context.Context, loginID, password, ipAddre
 		return nil, nil, ErrAdminInvalidCredentials
 	}
 
-	match, err := s.verifyPassword(password, user.PasswordHash)
+	match, err := s.verifyPassword(ctx, password, user.PasswordHash)
 	if err != nil {
 		return nil, nil, fmt.Errorf("failed to verify password: %w", err)
 	}
@@ -64,6 +75,10 @@ func (s *AdminAuthService) Login(ctx context.Context, loginID, password, ipAddre
 		return nil, nil, ErrAdminInvalidCredentials
 	}
 
+	if s.needsRehash(user.PasswordHash) {
+		s.rehash(ctx, user, password)
+	}
+
 	session := &model.AdminSession{
 		AdminUserID: user.ID,
 		IPAddress:   ipAddress,
@@ -81,6 +96,20 @@ func (s *AdminAuthService) Login(ctx context.Context, loginID, password, ipAddre
 	return session, user, nil
 }
 
+// rehash は管理者のパスワードを現在のパラメータでハッシュし直す。
+// 失敗しても次のログインで作り直せるため、ログに残してログインは成功させる
+func (s *AdminAuthService) rehash(ctx context.Context, user *model.AdminUser, password string) {
+	rehashed, err := s.hashPassword(ctx, password)
+	if err != nil {
+		s.logger.Errorf("failed to rehash password for admin user %s: %v", user.ID, err)
+		return
+	}
+	// 同時にパスワードが変更された場合は置き換えない
+	if _, err := s.userFinder.RehashPassword(ctx, user.ID, user.PasswordHash, rehashed); err != nil {
+		s.logger.Errorf("failed to save rehashed password for admin user %s: %v", user.ID, err)
+	}
+}
+
 // RevokeSession は指定 ID の管理者セッションを失効させる。
 func (s *AdminAuthService) RevokeSession(ctx context.Context, sessionID uuid.UUID) error {
 	return s.sessionStore.Revoke(ctx, sessionID)
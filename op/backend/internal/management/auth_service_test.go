@@ -0,0 +1,164 @@
+package management
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeAdminUserStore struct {
+	user       *model.AdminUser
+	rehashErr  error
+	rehashedTo string
+}
+
+func (f *fakeAdminUserStore) FindByLoginID(_ context.Context, loginID string) (*model.AdminUser, error) {
+	if f.user == nil || f.user.LoginID != loginID {
+		return nil, nil
+	}
+	copied := *f.user
+	return &copied, nil
+}
+
+func (f *fakeAdminUserStore) FindByID(_ context.Context, id uuid.UUID) (*model.AdminUser, error) {
+	if f.user == nil || f.user.ID != id {
+		return nil, nil
+	}
+	copied := *f.user
+	return &copied, nil
+}
+
+func (f *fakeAdminUserStore) UpdateLastLoginAt(context.Context, uuid.UUID, time.Time) error {
+	return nil
+}
+
+func (f *fakeAdminUserStore) RehashPassword(_ context.Context, _ uuid.UUID, from, to string) (bool, error) {
+	if f.rehashErr != nil {
+		return false, f.rehashErr
+	}
+	if f.user.PasswordHash != from {
+		return false, nil
+	}
+	f.user.PasswordHash, f.rehashedTo = to, to
+	return true, nil
+}
+
+type fakeAdminSessionStore struct {
+	sessions []*model.AdminSession
+}
+
+func (f *fakeAdminSessionStore) Create(_ context.Context, session *model.AdminSession) error {
+	session.ID = uuid.New()
+	f.sessions = append(f.sessions, session)
+	return nil
+}
+
+func (f *fakeAdminSessionStore) FindByID(_ context.Context, id uuid.UUID) (*model.AdminSession, error) {
+	for _, s := range f.sessions {
+		if s.ID == id {
+			copied := *s
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAdminSessionStore) Revoke(context.Context, uuid.UUID) error { return nil }
+
+func TestAdminLoginRehash(t *testing.T) {
+	// テスト用のハッシュは "hash:" + 平文。"legacy:" + 平文は作り直しが必要な古いハッシュとして扱う
+	verify := func(_ context.Context, p, h string) (bool, error) { return h == "hash:"+p || h == "legacy:"+p, nil }
+	needsRehash := func(h string) bool { return strings.HasPrefix(h, "legacy:") }
+
+	tests := []struct {
+		name      string
+		hashErr   error
+		rehashErr error
+		wantHash  string
+		wantLog   string
+	}{
+		{name: "作り直したハッシュを保存する", wantHash: "hash:s3cret"},
+		// 作り直しは次のログインでもできるため、失敗してもログインは成功させ、ロガーに記録する
+		{name: "ハッシュ化の失敗", hashErr: errors.New("hasher is busy"), wantHash: "legacy:s3cret", wantLog: "failed to rehash password"},
+		{name: "保存の失敗", rehashErr: errors.New("database is down"), wantHash: "legacy:s3cret", wantLog: "failed to save rehashed password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users := &fakeAdminUserStore{
+				user:      &model.AdminUser{ID: uuid.New(), LoginID: "admin", PasswordHash: "legacy:s3cret", Status: "active"},
+				rehashErr: tt.rehashErr,
+			}
+			sessions := &fakeAdminSessionStore{}
+			hash := func(_ context.Context, p string) (string, error) {
+				if tt.hashErr != nil {
+					return "", tt.hashErr
+				}
+				return "hash:" + p, nil
+			}
+			var logs bytes.Buffer
+			logger := echo.New().Logger
+			logger.SetOutput(&logs)
+			svc := NewAdminAuthService(users, sessions, verify, hash, needsRehash, logger)
+
+			session, user, err := svc.Login(context.Background(), "admin", "s3cret", "192.0.2.1", "test")
+			if err != nil || session == nil || user == nil {
+				t.Fatalf("session = %v, user = %v, err = %v", session, user, err)
+			}
+			if len(sessions.sessions) != 1 {
+				t.Errorf("sessions = %d", len(sessions.sessions))
+			}
+			if users.user.PasswordHash != tt.wantHash {
+				t.Errorf("password hash = %q, want %q", users.user.PasswordHash, tt.wantHash)
+			}
+			if got := logs.String(); (tt.wantLog == "" && got != "") || !strings.Contains(got, tt.wantLog) {
+				t.Errorf("logs = %q, want %q", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestAdminLoginRejects(t *testing.T) {
+	errBusy := errors.New("hasher is busy")
+	tests := []struct {
+		name     string
+		status   string
+		password string
+		verify   PasswordVerifyFunc
+		wantErr  error
+	}{
+		{name: "パスワードの不一致", status: "active", password: "wrong", wantErr: ErrAdminInvalidCredentials},
+		{name: "無効なユーザー", status: "disabled", password: "s3cret", wantErr: ErrAdminInvalidCredentials},
+		{
+			name: "検証できない場合はパスワードの不一致と区別する", status: "active", password: "s3cret",
+			verify:  func(context.Context, string, string) (bool, error) { return false, errBusy },
+			wantErr: errBusy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			verify := tt.verify
+			if verify == nil {
+				verify = func(_ context.Context, p, h string) (bool, error) { return h == "hash:"+p, nil }
+			}
+			users := &fakeAdminUserStore{user: &model.AdminUser{ID: uuid.New(), LoginID: "admin", PasswordHash: "hash:s3cret", Status: tt.status}}
+			sessions := &fakeAdminSessionStore{}
+			hash := func(_ context.Context, p string) (string, error) { return "hash:" + p, nil }
+			svc := NewAdminAuthService(users, sessions, verify, hash, func(string) bool { return false }, echo.New().Logger)
+
+			if _, _, err := svc.Login(context.Background(), "admin", tt.password, "192.0.2.1", "test"); !errors.Is(err, tt.wantErr) {
+				t.Errorf("err = %v, want %v", err, tt.wantErr)
+			}
+			if len(sessions.sessions) != 0 {
+				t.Errorf("sessions = %d", len(sessions.sessions))
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/jsonschema"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// AuthorizationDetailTypeHandler は authorization_details の type (RFC 9396) の管理エンドポイントを処理する。
+type AuthorizationDetailTypeHandler struct {
+	typeStore   AuthorizationDetailTypeStore
+	tenantStore TenantStore
+}
+
+// NewAuthorizationDetailTypeHandler は AuthorizationDetailTypeHandler を生成する。
+func NewAuthorizationDetailTypeHandler(typeStore AuthorizationDetailTypeStore, tenantStore TenantStore) *AuthorizationDetailTypeHandler {
+	return &AuthorizationDetailTypeHandler{
+		typeStore:   typeStore,
+		tenantStore: tenantStore,
+	}
+}
+
+type createAuthorizationDetailTypeRequest struct {
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+}
+
+// updateAuthorizationDetailTypeRequest は発行済みトークンの authorization_details と整合しなくなるため type の変更を受け付けない。
+type updateAuthorizationDetailTypeRequest struct {
+	Description *string         `json:"description,omitempty"`
+	Schema      json.RawMessage `json:"schema,omitempty"`
+}
+
+type authorizationDetailTypeResponse struct {
+	ID          string          `json:"id"`
+	TenantID    string          `json:"tenant_id"`
+	Type        string          `json:"type"`
+	Description string          `json:"description"`
+	Schema      json.RawMessage `json:"schema"`
+	CreatedAt   string          `json:"created_at"`
+	UpdatedAt   string          `json:"updated_at"`
+}
+
+func toAuthorizationDetailTypeResponse(t *model.AuthorizationDetailType) authorizationDetailTypeResponse {
+	return authorizationDetailTypeResponse{
+		ID:          t.ID.String(),
+		TenantID:    t.TenantID.String(),
+		Type:        t.Type,
+		Description: t.Description,
+		Schema:      json.RawMessage(t.Schema),
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:   t.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/authorization-detail-types を処理する。
+func (h *AuthorizationDetailTypeHandler) HandleList(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	types, err := h.typeStore.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to list authorization detail types: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]authorizationDetailTypeResponse, len(types))
+	for i, t := range types {
+		data[i] = toAuthorizationDetailTypeResponse(&t)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/authorization-detail-types を処理する。
+func (h *AuthorizationDetailTypeHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	var req createAuthorizationDetailTypeRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Type == "" || len(req.Type) > 255 {
+		return badRequest(c, "type is required and must be at most 255 characters")
+	}
+	if err := validateDetailTypeSchema(req.Schema); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	// 重複チェック
+	existing, err := h.typeStore.FindByType(ctx, tenantID, req.Type)
+	if err != nil {
+		c.Logger().Errorf("failed to check authorization detail type: %v", err)
+		return serverError(c)
+	}
+	if existing != nil {
+		return conflict(c, "authorization detail type already exists")
+	}
+
+	detailType := &model.AuthorizationDetailType{
+		TenantID:    tenantID,
+		Type:        req.Type,
+		Description: req.Description,
+		Schema:      string(req.Schema),
+	}
+	if err := h.typeStore.Create(ctx, detailType); err != nil {
+		c.Logger().Errorf("failed to create authorization detail type: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, toAuthorizationDetailTypeResponse(detailType))
+}
+
+// HandleGet は GET /management/v1/authorization-detail-types/:id を処理する。
+func (h *AuthorizationDetailTypeHandler) HandleGet(c echo.Context) error {
+	detailType, err := h.findType(c)
+	if err != nil || detailType == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toAuthorizationDetailTypeResponse(detailType))
+}
+
+// HandleUpdate は PUT /management/v1/authorization-detail-types/:id を処理する。
+// スキーマの変更は以後の認可リクエストにのみ適用する (発行済みの認可は再検証しない)。
+func (h *AuthorizationDetailTypeHandler) HandleUpdate(c echo.Context) error {
+	detailType, err := h.findType(c)
+	if err != nil || detailType == nil {
+		return err
+	}
+
+	var req updateAuthorizationDetailTypeRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Description != nil {
+		detailType.Description = *req.Description
+	}
+	if req.Schema != nil {
+		if err := validateDetailTypeSchema(req.Schema); err != nil {
+			return badRequest(c, err.Error())
+		}
+		detailType.Schema = string(req.Schema)
+	}
+
+	if err := h.typeStore.Update(c.Request().Context(), detailType); err != nil {
+		c.Logger().Errorf("failed to update authorization detail type: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toAuthorizationDetailTypeResponse(detailType))
+}
+
+// HandleDelete は DELETE /management/v1/authorization-detail-types/:id を処理する。
+// 発行済みのトークンは失効させない。以後この type を含む認可リクエストは拒否される。
+func (h *AuthorizationDetailTypeHandler) HandleDelete(c echo.Context) error {
+	detailType, err := h.findType(c)
+	if err != nil || detailType == nil {
+		return err
+	}
+
+	if err := h.typeStore.Delete(c.Request().Context(), detailType.ID); err != nil {
+		c.Logger().Errorf("failed to delete authorization detail type: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findType はパスの :id で type を検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *AuthorizationDetailTypeHandler) findType(c echo.Context) (*model.AuthorizationDetailType, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid authorization detail type id format")
+	}
+
+	detailType, err := h.typeStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find authorization detail type: %v", err)
+		return nil, serverError(c)
+	}
+	if detailType == nil {
+		return nil, notFound(c, "authorization detail type not found")
+	}
+	return detailType, nil
+}
+
+// validateDetailTypeSchema は schema が対応するキーワードだけで書かれた JSON Schema であることを検証する。
+func validateDetailTypeSchema(raw json.RawMessage) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("schema is required")
+	}
+	if _, err := jsonschema.Parse(raw); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	return nil
+}
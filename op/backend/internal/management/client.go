@@ -1,13 +1,18 @@
 package management
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v3/jwk"
 
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
@@ -16,6 +21,18 @@ var validGrantTypes = map[string]bool{
 	"authorization_code": true,
 	"refresh_token":      true,
 	"client_credentials": true,
+	"urn:ietf:params:oauth:grant-type:device_code":    true,
+	"urn:ietf:params:oauth:grant-type:token-exchange": true,
+	"urn:openid:params:grant-type:ciba":               true,
+}
+
+// cibaGrantType は CIBA のグラントタイプ (CIBA Core 1.0 Section 4)
+const cibaGrantType = "urn:openid:params:grant-type:ciba"
+
+// validBackchannelDeliveryModes は対応する CIBA のトークン受け渡し方式。push は未対応
+var validBackchannelDeliveryModes = map[string]bool{
+	model.BackchannelDeliveryModePoll: true,
+	model.BackchannelDeliveryModePing: true,
 }
 
 var validResponseTypes = map[string]bool{
@@ -23,16 +40,174 @@ var validResponseTypes = map[string]bool{
 }
 
 var validAuthMethods = map[string]bool{
-	"client_secret_basic": true,
-	"client_secret_post":  true,
-	"none":                true,
+	"client_secret_basic":         true,
+	"client_secret_post":          true,
+	"client_secret_jwt":           true,
+	"private_key_jwt":             true,
+	"tls_client_auth":             true,
+	"self_signed_tls_client_auth": true,
+	"none":                        true,
+}
+
+// tlsClientAuthMetadata は Mutual-TLS (RFC 8705) 関連のクライアントメタデータ。作成・更新リクエストで共通。
+type tlsClientAuthMetadata struct {
+	TLSClientAuthSubjectDN                *string `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS                   *string `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI                   *string `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP                    *string `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail                 *string `json:"tls_client_auth_san_email,omitempty"`
+	TLSClientCertificateBoundAccessTokens *bool   `json:"tls_client_certificate_bound_access_tokens,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空文字はクリアとして扱う。
+func (m *tlsClientAuthMetadata) applyTo(client *model.Client) {
+	for _, f := range []struct {
+		src *string
+		dst **string
+	}{
+		{m.TLSClientAuthSubjectDN, &client.TLSClientAuthSubjectDN},
+		{m.TLSClientAuthSANDNS, &client.TLSClientAuthSANDNS},
+		{m.TLSClientAuthSANURI, &client.TLSClientAuthSANURI},
+		{m.TLSClientAuthSANIP, &client.TLSClientAuthSANIP},
+		{m.TLSClientAuthSANEmail, &client.TLSClientAuthSANEmail},
+	} {
+		if f.src == nil {
+			continue
+		}
+		if *f.src == "" {
+			*f.dst = nil
+		} else {
+			v := *f.src
+			*f.dst = &v
+		}
+	}
+	if m.TLSClientCertificateBoundAccessTokens != nil {
+		client.TLSClientCertificateBoundAccessTokens = *m.TLSClientCertificateBoundAccessTokens
+	}
+}
+
+// tokenExchangePolicy はトークン交換 (RFC 8693) で許可する audience とスコープ。作成・更新リクエストで共通。
+type tokenExchangePolicy struct {
+	TokenExchangeAudiences []string `json:"token_exchange_audiences,omitempty"`
+	TokenExchangeScopes    []string `json:"token_exchange_scopes,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空配列はクリアとして扱う。
+func (p *tokenExchangePolicy) applyTo(client *model.Client) {
+	if p.TokenExchangeAudiences != nil {
+		client.TokenExchangeAudiences = model.StringSlice(p.TokenExchangeAudiences)
+	}
+	if p.TokenExchangeScopes != nil {
+		client.TokenExchangeScopes = model.StringSlice(p.TokenExchangeScopes)
+	}
+}
+
+// scopePolicy はクライアントが要求できるスコープと、scope 省略時に使うスコープ。作成・更新リクエストで共通。
+type scopePolicy struct {
+	AllowedScopes []string `json:"allowed_scopes,omitempty"`
+	DefaultScopes []string `json:"default_scopes,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空配列はクリアとして扱う。
+func (p *scopePolicy) applyTo(client *model.Client) {
+	if p.AllowedScopes != nil {
+		client.AllowedScopes = model.StringSlice(p.AllowedScopes)
+	}
+	if p.DefaultScopes != nil {
+		client.DefaultScopes = model.StringSlice(p.DefaultScopes)
+	}
+}
+
+// 署名・暗号化に対応するアルゴリズム (OIDC Registration 1.0 Section 2)
+var (
+	validUserInfoSigningAlgs = map[string]bool{"RS256": true}
+	validEncryptionAlgs      = map[string]string{"RSA-OAEP-256": "RSA", "ECDH-ES": "EC"}
+	validEncryptionEncs      = map[string]bool{"A256GCM": true}
+)
+
+// responseProtectionMetadata は ID トークン・UserInfo レスポンスの署名・暗号化の設定。作成・更新リクエストで共通。
+type responseProtectionMetadata struct {
+	UserInfoSignedResponseAlg    *string `json:"userinfo_signed_response_alg,omitempty"`
+	IDTokenEncryptedResponseAlg  *string `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedResponseEnc  *string `json:"id_token_encrypted_response_enc,omitempty"`
+	UserInfoEncryptedResponseAlg *string `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncryptedResponseEnc *string `json:"userinfo_encrypted_response_enc,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空文字はクリアとして扱う。
+func (m *responseProtectionMetadata) applyTo(client *model.Client) {
+	for _, f := range []struct {
+		src *string
+		dst **string
+	}{
+		{m.UserInfoSignedResponseAlg, &client.UserInfoSignedResponseAlg},
+		{m.IDTokenEncryptedResponseAlg, &client.IDTokenEncryptedResponseAlg},
+		{m.IDTokenEncryptedResponseEnc, &client.IDTokenEncryptedResponseEnc},
+		{m.UserInfoEncryptedResponseAlg, &client.UserInfoEncryptedResponseAlg},
+		{m.UserInfoEncryptedResponseEnc, &client.UserInfoEncryptedResponseEnc},
+	} {
+		if f.src == nil {
+			continue
+		}
+		if *f.src == "" {
+			*f.dst = nil
+		} else {
+			v := *f.src
+			*f.dst = &v
+		}
+	}
+}
+
+// requestObjectMetadata はリクエストオブジェクト (RFC 9101) に関する設定。作成・更新リクエストで共通。
+type requestObjectMetadata struct {
+	RequestURIs                []string `json:"request_uris,omitempty"`
+	RequireSignedRequestObject *bool    `json:"require_signed_request_object,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空配列はクリアとして扱う。
+func (m *requestObjectMetadata) applyTo(client *model.Client) {
+	if m.RequestURIs != nil {
+		client.RequestURIs = model.StringSlice(m.RequestURIs)
+	}
+	if m.RequireSignedRequestObject != nil {
+		client.RequireSignedRequestObject = *m.RequireSignedRequestObject
+	}
+}
+
+// backchannelMetadata は CIBA (CIBA Core 1.0 Section 4) のクライアントメタデータ。作成・更新リクエストで共通。
+type backchannelMetadata struct {
+	BackchannelTokenDeliveryMode          *string `json:"backchannel_token_delivery_mode,omitempty"`
+	BackchannelClientNotificationEndpoint *string `json:"backchannel_client_notification_endpoint,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。空文字はクリアとして扱う。
+func (m *backchannelMetadata) applyTo(client *model.Client) {
+	for _, f := range []struct {
+		src *string
+		dst **string
+	}{
+		{m.BackchannelTokenDeliveryMode, &client.BackchannelTokenDeliveryMode},
+		{m.BackchannelClientNotificationEndpoint, &client.BackchannelClientNotificationEndpoint},
+	} {
+		if f.src == nil {
+			continue
+		}
+		if *f.src == "" {
+			*f.dst = nil
+		} else {
+			v := *f.src
+			*f.dst = &v
+		}
+	}
 }
 
 // ClientHandler はクライアント管理の CRUD エンドポイントを処理する。
 type ClientHandler struct {
-	clientStore  ClientStore
-	tenantStore  TenantStore
-	hashPassword HashPasswordFunc
+	clientStore           ClientStore
+	tenantStore           TenantStore
+	hashPassword          HashPasswordFunc
+	encryptSecret         EncryptSecretFunc
+	fetchSectorIdentifier FetchSectorIdentifierFunc
 }
 
 // NewClientHandler は ClientHandler を生成する。
@@ -40,72 +215,144 @@ func NewClientHandler(
 	clientStore ClientStore,
 	tenantStore TenantStore,
 	hashPassword HashPasswordFunc,
+	encryptSecret EncryptSecretFunc,
+	fetchSectorIdentifier FetchSectorIdentifierFunc,
 ) *ClientHandler {
 	return &ClientHandler{
-		clientStore:  clientStore,
-		tenantStore:  tenantStore,
-		hashPassword: hashPassword,
+		clientStore:           clientStore,
+		tenantStore:           tenantStore,
+		hashPassword:          hashPassword,
+		encryptSecret:         encryptSecret,
+		fetchSectorIdentifier: fetchSectorIdentifier,
 	}
 }
 
 type createClientRequest struct {
-	Name                    string   `json:"name"`
-	GrantTypes              []string `json:"grant_types"`
-	ResponseTypes           []string `json:"response_types"`
-	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
-	RequirePKCE             *bool    `json:"require_pkce,omitempty"`
-	RedirectURIs            []string `json:"redirect_uris,omitempty"`
-	PostLogoutRedirectURIs  []string `json:"post_logout_redirect_uris,omitempty"`
-	FrontchannelLogoutURI   *string  `json:"frontchannel_logout_uri,omitempty"`
-	BackchannelLogoutURI    *string  `json:"backchannel_logout_uri,omitempty"`
+	Name                    string          `json:"name"`
+	GrantTypes              []string        `json:"grant_types"`
+	ResponseTypes           []string        `json:"response_types"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method"`
+	RequirePKCE             *bool           `json:"require_pkce,omitempty"`
+	RedirectURIs            []string        `json:"redirect_uris,omitempty"`
+	PostLogoutRedirectURIs  []string        `json:"post_logout_redirect_uris,omitempty"`
+	FrontchannelLogoutURI   *string         `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutURI    *string         `json:"backchannel_logout_uri,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                 *string         `json:"jwks_uri,omitempty"`
+	tlsClientAuthMetadata
+	tokenExchangePolicy
+	scopePolicy
+	subjectTypeMetadata
+	responseProtectionMetadata
+	requestObjectMetadata
+	backchannelMetadata
 }
 
 type updateClientRequest struct {
-	Name                    *string  `json:"name,omitempty"`
-	GrantTypes              []string `json:"grant_types,omitempty"`
-	ResponseTypes           []string `json:"response_types,omitempty"`
-	TokenEndpointAuthMethod *string  `json:"token_endpoint_auth_method,omitempty"`
-	RequirePKCE             *bool    `json:"require_pkce,omitempty"`
-	FrontchannelLogoutURI   *string  `json:"frontchannel_logout_uri,omitempty"`
-	BackchannelLogoutURI    *string  `json:"backchannel_logout_uri,omitempty"`
+	Name                    *string         `json:"name,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod *string         `json:"token_endpoint_auth_method,omitempty"`
+	RequirePKCE             *bool           `json:"require_pkce,omitempty"`
+	FrontchannelLogoutURI   *string         `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutURI    *string         `json:"backchannel_logout_uri,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                 *string         `json:"jwks_uri,omitempty"`
+	tlsClientAuthMetadata
+	tokenExchangePolicy
+	scopePolicy
+	subjectTypeMetadata
+	responseProtectionMetadata
+	requestObjectMetadata
+	backchannelMetadata
 }
 
 type clientResponse struct {
-	ID                      string   `json:"id"`
-	TenantID                string   `json:"tenant_id"`
-	ClientID                string   `json:"client_id"`
-	Name                    string   `json:"name"`
-	GrantTypes              []string `json:"grant_types"`
-	ResponseTypes           []string `json:"response_types"`
-	TokenEndpointAuthMethod string   `json:"token_endpoint_auth_method"`
-	RequirePKCE             bool     `json:"require_pkce"`
-	FrontchannelLogoutURI   *string  `json:"frontchannel_logout_uri,omitempty"`
-	BackchannelLogoutURI    *string  `json:"backchannel_logout_uri,omitempty"`
-	Status                  string   `json:"status"`
-	CreatedAt               string   `json:"created_at"`
-	UpdatedAt               string   `json:"updated_at"`
+	ID                                    string          `json:"id"`
+	TenantID                              string          `json:"tenant_id"`
+	ClientID                              string          `json:"client_id"`
+	Name                                  string          `json:"name"`
+	GrantTypes                            []string        `json:"grant_types"`
+	ResponseTypes                         []string        `json:"response_types"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method"`
+	RequirePKCE                           bool            `json:"require_pkce"`
+	FrontchannelLogoutURI                 *string         `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutURI                  *string         `json:"backchannel_logout_uri,omitempty"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                               *string         `json:"jwks_uri,omitempty"`
+	TLSClientAuthSubjectDN                *string         `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS                   *string         `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI                   *string         `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP                    *string         `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail                 *string         `json:"tls_client_auth_san_email,omitempty"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens"`
+	TokenExchangeAudiences                []string        `json:"token_exchange_audiences"`
+	TokenExchangeScopes                   []string        `json:"token_exchange_scopes"`
+	AllowedScopes                         []string        `json:"allowed_scopes"`
+	DefaultScopes                         []string        `json:"default_scopes"`
+	SubjectType                           string          `json:"subject_type"`
+	SectorIdentifierURI                   *string         `json:"sector_identifier_uri,omitempty"`
+	UserInfoSignedResponseAlg             *string         `json:"userinfo_signed_response_alg,omitempty"`
+	IDTokenEncryptedResponseAlg           *string         `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedResponseEnc           *string         `json:"id_token_encrypted_response_enc,omitempty"`
+	UserInfoEncryptedResponseAlg          *string         `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncryptedResponseEnc          *string         `json:"userinfo_encrypted_response_enc,omitempty"`
+	RequestURIs                           []string        `json:"request_uris"`
+	RequireSignedRequestObject            bool            `json:"require_signed_request_object"`
+	BackchannelTokenDeliveryMode          *string         `json:"backchannel_token_delivery_mode,omitempty"`
+	BackchannelClientNotificationEndpoint *string         `json:"backchannel_client_notification_endpoint,omitempty"`
+	Status                                string          `json:"status"`
+	CreatedAt                             string          `json:"created_at"`
+	UpdatedAt                             string          `json:"updated_at"`
 }
 
 type clientCreateResponse struct {
 	clientResponse
-	ClientSecret string `json:"client_secret"`
+	ClientSecret string `json:"client_secret,omitempty"`
 }
 
 func toClientResponse(c *model.Client) clientResponse {
+	var jwks json.RawMessage
+	if c.JWKS != nil {
+		jwks = json.RawMessage(*c.JWKS)
+	}
 	return clientResponse{
-		ID:                      c.ID.String(),
-		TenantID:                c.TenantID.String(),
-		ClientID:                c.ClientID,
-		Name:                    c.Name,
-		GrantTypes:              []string(c.GrantTypes),
-		ResponseTypes:           []string(c.ResponseTypes),
-		TokenEndpointAuthMethod: c.TokenEndpointAuthMethod,
-		RequirePKCE:             c.RequirePKCE,
-		FrontchannelLogoutURI:   c.FrontchannelLogoutURI,
-		BackchannelLogoutURI:    c.BackchannelLogoutURI,
-		Status:                  c.Status,
-		CreatedAt:               c.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:               c.UpdatedAt.Format(time.RFC3339),
+		ID:                                    c.ID.String(),
+		TenantID:                              c.TenantID.String(),
+		ClientID:                              c.ClientID,
+		Name:                                  c.Name,
+		GrantTypes:                            []string(c.GrantTypes),
+		ResponseTypes:                         []string(c.ResponseTypes),
+		TokenEndpointAuthMethod:               c.TokenEndpointAuthMethod,
+		RequirePKCE:                           c.RequirePKCE,
+		FrontchannelLogoutURI:                 c.FrontchannelLogoutURI,
+		BackchannelLogoutURI:                  c.BackchannelLogoutURI,
+		JWKS:                                  jwks,
+		JWKSURI:                               c.JWKSURI,
+		TLSClientAuthSubjectDN:                c.TLSClientAuthSubjectDN,
+		TLSClientAuthSANDNS:                   c.TLSClientAuthSANDNS,
+		TLSClientAuthSANURI:                   c.TLSClientAuthSANURI,
+		TLSClientAuthSANIP:                    c.TLSClientAuthSANIP,
+		TLSClientAuthSANEmail:                 c.TLSClientAuthSANEmail,
+		TLSClientCertificateBoundAccessTokens: c.TLSClientCertificateBoundAccessTokens,
+		TokenExchangeAudiences:                nonNilStrings(c.TokenExchangeAudiences),
+		TokenExchangeScopes:                   nonNilStrings(c.TokenExchangeScopes),
+		AllowedScopes:                         nonNilStrings(c.AllowedScopes),
+		DefaultScopes:                         nonNilStrings(c.DefaultScopes),
+		SubjectType:                           c.SubjectType,
+		SectorIdentifierURI:                   c.SectorIdentifierURI,
+		UserInfoSignedResponseAlg:             c.UserInfoSignedResponseAlg,
+		IDTokenEncryptedResponseAlg:           c.IDTokenEncryptedResponseAlg,
+		IDTokenEncryptedResponseEnc:           c.IDTokenEncryptedResponseEnc,
+		UserInfoEncryptedResponseAlg:          c.UserInfoEncryptedResponseAlg,
+		UserInfoEncryptedResponseEnc:          c.UserInfoEncryptedResponseEnc,
+		RequestURIs:                           nonNilStrings(c.RequestURIs),
+		RequireSignedRequestObject:            c.RequireSignedRequestObject,
+		BackchannelTokenDeliveryMode:          c.BackchannelTokenDeliveryMode,
+		BackchannelClientNotificationEndpoint: c.BackchannelClientNotificationEndpoint,
+		Status:                                c.Status,
+		CreatedAt:                             c.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:                             c.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -168,57 +415,9 @@ func (h *ClientHandler) HandleCreate(c echo.Context) error {
 		return badRequest(c, "invalid request body")
 	}
 
-	if req.Name == "" || len(req.Name) > 255 {
-		return badRequest(c, "name is required and must be at most 255 characters")
-	}
-	if len(req.GrantTypes) == 0 {
-		return badRequest(c, "grant_types is required")
-	}
-	for _, gt := range req.GrantTypes {
-		if !validGrantTypes[gt] {
-			return badRequest(c, "unsupported grant_type: "+gt)
-		}
-	}
-	if len(req.ResponseTypes) == 0 {
-		return badRequest(c, "response_types is required")
-	}
-	for _, rt := range req.ResponseTypes {
-		if !validResponseTypes[rt] {
-			return badRequest(c, "unsupported response_type: "+rt)
-		}
-	}
 	if req.TokenEndpointAuthMethod == "" {
 		req.TokenEndpointAuthMethod = "client_secret_basic"
 	}
-	if !validAuthMethods[req.TokenEndpointAuthMethod] {
-		return badRequest(c, "unsupported token_endpoint_auth_method: "+req.TokenEndpointAuthMethod)
-	}
-	for _, uri := range req.RedirectURIs {
-		if err := validateRedirectURI(uri); err != nil {
-			return badRequest(c, err.Error())
-		}
-	}
-	for _, uri := range req.PostLogoutRedirectURIs {
-		if err := validateRedirectURI(uri); err != nil {
-			return badRequest(c, err.Error())
-		}
-	}
-
-	clientID, err := generateClientID()
-	if err != nil {
-		c.Logger().Errorf("failed to generate client_id: %v", err)
-		return serverError(c)
-	}
-	clientSecret, err := generateClientSecret()
-	if err != nil {
-		c.Logger().Errorf("failed to generate client_secret: %v", err)
-		return serverError(c)
-	}
-	secretHash, err := h.hashPassword(clientSecret)
-	if err != nil {
-		c.Logger().Errorf("failed to hash client_secret: %v", err)
-		return serverError(c)
-	}
 
 	requirePKCE := true
 	if req.RequirePKCE != nil {
@@ -227,8 +426,6 @@ func (h *ClientHandler) HandleCreate(c echo.Context) error {
 
 	client := &model.Client{
 		TenantID:                tenantID,
-		ClientID:                clientID,
-		ClientSecretHash:        secretHash,
 		Name:                    req.Name,
 		GrantTypes:              model.StringSlice(req.GrantTypes),
 		ResponseTypes:           model.StringSlice(req.ResponseTypes),
@@ -236,8 +433,47 @@ func (h *ClientHandler) HandleCreate(c echo.Context) error {
 		RequirePKCE:             requirePKCE,
 		FrontchannelLogoutURI:   req.FrontchannelLogoutURI,
 		BackchannelLogoutURI:    req.BackchannelLogoutURI,
+		JWKS:                    rawJWKSToPtr(req.JWKS),
+		JWKSURI:                 req.JWKSURI,
+		SubjectType:             model.SubjectTypePublic,
 		Status:                  "active",
 	}
+	req.tlsClientAuthMetadata.applyTo(client)
+	req.tokenExchangePolicy.applyTo(client)
+	req.scopePolicy.applyTo(client)
+	req.subjectTypeMetadata.applyTo(client)
+	req.responseProtectionMetadata.applyTo(client)
+	req.requestObjectMetadata.applyTo(client)
+	req.backchannelMetadata.applyTo(client)
+
+	for _, uri := range append(append([]string{}, req.RedirectURIs...), req.PostLogoutRedirectURIs...) {
+		if err := validateRedirectURI(uri); err != nil {
+			return badRequest(c, err.Error())
+		}
+	}
+	if err := validateClientMetadata(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateSubjectType(ctx, client, req.RedirectURIs, h.fetchSectorIdentifier); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	clientID, err := generateClientID()
+	if err != nil {
+		c.Logger().Errorf("failed to generate client_id: %v", err)
+		return serverError(c)
+	}
+	client.ClientID = clientID
+
+	// 公開クライアント / private_key_jwt にはシークレットを発行しない
+	var clientSecret string
+	if client.UsesClientSecret() {
+		clientSecret, err = issueClientSecret(ctx, client, h.hashPassword, h.encryptSecret)
+		if err != nil {
+			c.Logger().Errorf("failed to issue client_secret: %v", err)
+			return serverError(c)
+		}
+	}
 
 	// Redirect URI を関連として設定
 	for _, uri := range req.RedirectURIs {
@@ -369,6 +605,59 @@ func (h *ClientHandler) HandleUpdate(c echo.Context) error {
 	if req.BackchannelLogoutURI != nil {
 		client.BackchannelLogoutURI = req.BackchannelLogoutURI
 	}
+	if req.JWKS != nil {
+		client.JWKS = rawJWKSToPtr(req.JWKS)
+	}
+	if req.JWKSURI != nil {
+		client.JWKSURI = req.JWKSURI
+		if *req.JWKSURI == "" {
+			client.JWKSURI = nil
+		}
+	}
+
+	req.tlsClientAuthMetadata.applyTo(client)
+	req.tokenExchangePolicy.applyTo(client)
+	req.scopePolicy.applyTo(client)
+	req.subjectTypeMetadata.applyTo(client)
+	req.responseProtectionMetadata.applyTo(client)
+	req.requestObjectMetadata.applyTo(client)
+	req.backchannelMetadata.applyTo(client)
+
+	if err := validateClientAuthentication(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateTokenExchangePolicy(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateScopePolicy(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateResponseProtection(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateRequestObject(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateBackchannel(client); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if req.subjectTypeMetadata.specified() {
+		// セクターの検証には登録済みのリダイレクト URI が必要
+		withURIs, err := h.clientStore.FindByIDWithRelations(ctx, id)
+		if err != nil || withURIs == nil {
+			c.Logger().Errorf("failed to load redirect URIs: %v", err)
+			return serverError(c)
+		}
+		if err := validateSubjectType(ctx, client, redirectURIStrings(withURIs.RedirectURIs), h.fetchSectorIdentifier); err != nil {
+			return badRequest(c, err.Error())
+		}
+	}
+
+	// シークレットを使わない認証方式に変更された場合は既存のシークレットを破棄する
+	if !client.UsesClientSecret() {
+		client.ClientSecretHash = nil
+		client.ClientSecretEncrypted = nil
+	}
 
 	if err := h.clientStore.Update(ctx, client); err != nil {
 		c.Logger().Errorf("failed to update client: %v", err)
@@ -421,19 +710,17 @@ func (h *ClientHandler) HandleRotateSecret(c echo.Context) error {
 	if client == nil {
 		return notFound(c, "client not found")
 	}
-
-	newSecret, err := generateClientSecret()
-	if err != nil {
-		c.Logger().Errorf("failed to generate new secret: %v", err)
-		return serverError(c)
+	if !client.UsesClientSecret() {
+		return badRequest(c, "client does not use a client_secret for token_endpoint_auth_method: "+client.TokenEndpointAuthMethod)
 	}
-	newHash, err := h.hashPassword(newSecret)
+
+	newSecret, err := issueClientSecret(ctx, client, h.hashPassword, h.encryptSecret)
 	if err != nil {
-		c.Logger().Errorf("failed to hash new secret: %v", err)
+		c.Logger().Errorf("failed to issue new secret: %v", err)
 		return serverError(c)
 	}
 
-	if err := h.clientStore.UpdateSecretHash(ctx, id, newHash); err != nil {
+	if err := h.clientStore.UpdateSecret(ctx, id, *client.ClientSecretHash, *client.ClientSecretEncrypted); err != nil {
 		c.Logger().Errorf("failed to update secret: %v", err)
 		return serverError(c)
 	}
@@ -444,6 +731,190 @@ func (h *ClientHandler) HandleRotateSecret(c echo.Context) error {
 	})
 }
 
+// validateClientMetadata は新規クライアントのメタデータを検証する。管理 API と動的クライアント登録で共通。
+// リダイレクト URI は許可するスキームが異なるため呼び出し元で検証する。
+func validateClientMetadata(client *model.Client) error {
+	if client.Name == "" || len(client.Name) > 255 {
+		return fmt.Errorf("name is required and must be at most 255 characters")
+	}
+	if len(client.GrantTypes) == 0 {
+		return fmt.Errorf("grant_types is required")
+	}
+	for _, gt := range client.GrantTypes {
+		if !validGrantTypes[gt] {
+			return fmt.Errorf("unsupported grant_type: %s", gt)
+		}
+	}
+	if len(client.ResponseTypes) == 0 {
+		return fmt.Errorf("response_types is required")
+	}
+	for _, rt := range client.ResponseTypes {
+		if !validResponseTypes[rt] {
+			return fmt.Errorf("unsupported response_type: %s", rt)
+		}
+	}
+	if !validAuthMethods[client.TokenEndpointAuthMethod] {
+		return fmt.Errorf("unsupported token_endpoint_auth_method: %s", client.TokenEndpointAuthMethod)
+	}
+	if err := validateTokenExchangePolicy(client); err != nil {
+		return err
+	}
+	if err := validateScopePolicy(client); err != nil {
+		return err
+	}
+	if err := validateResponseProtection(client); err != nil {
+		return err
+	}
+	if err := validateRequestObject(client); err != nil {
+		return err
+	}
+	if err := validateBackchannel(client); err != nil {
+		return err
+	}
+	return validateClientAuthentication(client)
+}
+
+// validateBackchannel は CIBA のメタデータを検証する。
+// CIBA は機密クライアントのみが使え、ping モードでは https の通知先が必須 (CIBA Core 1.0 Section 4)。
+func validateBackchannel(client *model.Client) error {
+	mode := client.BackchannelTokenDeliveryMode
+	if !client.HasGrantType(cibaGrantType) {
+		if mode != nil || client.BackchannelClientNotificationEndpoint != nil {
+			return fmt.Errorf("backchannel metadata requires the %s grant type", cibaGrantType)
+		}
+		return nil
+	}
+	if client.IsPublic() {
+		return fmt.Errorf("%s grant requires a confidential client", cibaGrantType)
+	}
+	if mode == nil || !validBackchannelDeliveryModes[*mode] {
+		return fmt.Errorf("backchannel_token_delivery_mode must be poll or ping")
+	}
+	if endpoint := client.BackchannelClientNotificationEndpoint; endpoint != nil {
+		parsed, err := url.Parse(*endpoint)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.Fragment != "" {
+			return fmt.Errorf("backchannel_client_notification_endpoint must be an https URL without a fragment")
+		}
+	}
+	if *mode == model.BackchannelDeliveryModePing && client.BackchannelClientNotificationEndpoint == nil {
+		return fmt.Errorf("backchannel_client_notification_endpoint is required for ping mode")
+	}
+	return nil
+}
+
+// validateRequestObject は request_uris と署名付きリクエストオブジェクトの要求を検証する。
+// request_uris は https の絶対 URI に限る (OIDC Registration 1.0 Section 2)。署名検証には公開鍵の登録が必要。
+func validateRequestObject(client *model.Client) error {
+	for _, uri := range client.RequestURIs {
+		parsed, err := url.Parse(uri)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("request_uris must be https URLs: %s", uri)
+		}
+	}
+	if (client.RequireSignedRequestObject || len(client.RequestURIs) > 0) && client.JWKS == nil && client.JWKSURI == nil {
+		return fmt.Errorf("request objects require jwks or jwks_uri")
+	}
+	return nil
+}
+
+// validateResponseProtection は署名・暗号化アルゴリズムと、暗号化に使う公開鍵が jwks に登録されていることを検証する。
+// *_enc の省略時の既定値 A128CBC-HS256 (OIDC Registration 1.0 Section 2) には対応しないため明示を求める。
+func validateResponseProtection(client *model.Client) error {
+	if alg := client.UserInfoSignedResponseAlg; alg != nil && !validUserInfoSigningAlgs[*alg] {
+		return fmt.Errorf("unsupported userinfo_signed_response_alg: %s", *alg)
+	}
+
+	for _, p := range []struct {
+		name     string
+		alg, enc *string
+	}{
+		{"id_token_encrypted_response", client.IDTokenEncryptedResponseAlg, client.IDTokenEncryptedResponseEnc},
+		{"userinfo_encrypted_response", client.UserInfoEncryptedResponseAlg, client.UserInfoEncryptedResponseEnc},
+	} {
+		if p.alg == nil {
+			if p.enc != nil {
+				return fmt.Errorf("%s_enc requires %s_alg", p.name, p.name)
+			}
+			continue
+		}
+		kty, ok := validEncryptionAlgs[*p.alg]
+		if !ok {
+			return fmt.Errorf("unsupported %s_alg: %s", p.name, *p.alg)
+		}
+		if p.enc == nil || !validEncryptionEncs[*p.enc] {
+			return fmt.Errorf("%s_enc must be A256GCM", p.name)
+		}
+		if !hasEncryptionKey(client.JWKS, kty) {
+			return fmt.Errorf("%s_alg %s requires a %s encryption key in jwks", p.name, *p.alg, kty)
+		}
+	}
+	return nil
+}
+
+// hasEncryptionKey は jwks に暗号化に使える指定種別の鍵が含まれるか判定する。
+func hasEncryptionKey(raw *string, kty string) bool {
+	if raw == nil {
+		return false
+	}
+	set, err := jwk.ParseString(*raw)
+	if err != nil {
+		return false
+	}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		if key.KeyType().String() != kty {
+			continue
+		}
+		if use, ok := key.KeyUsage(); ok && use != "enc" {
+			continue
+		}
+		return true
+	}
+	return false
+}
+
+// validateTokenExchangePolicy はトークン交換ポリシーの値を検証する。
+func validateTokenExchangePolicy(client *model.Client) error {
+	for _, aud := range client.TokenExchangeAudiences {
+		if aud == "" || len(aud) > 2048 {
+			return fmt.Errorf("token_exchange_audiences must contain non-empty values of at most 2048 characters")
+		}
+	}
+	for _, scope := range client.TokenExchangeScopes {
+		if scope == "" || strings.ContainsAny(scope, " \t\n") {
+			return fmt.Errorf("token_exchange_scopes must contain single scope values")
+		}
+	}
+	return nil
+}
+
+// validateScopePolicy は allowed_scopes と default_scopes を検証する。
+// スコープが定義済みかは認可リクエスト時に判定する (API リソースのスコープも指定できるため)。
+// default_scopes は scope を省略した認可リクエストにそのまま使うため openid を含み、allowed_scopes の範囲内であること。
+func validateScopePolicy(client *model.Client) error {
+	for _, scope := range append(append([]string{}, client.AllowedScopes...), client.DefaultScopes...) {
+		if !scopeTokenRegex.MatchString(scope) {
+			return fmt.Errorf("invalid scope value: %q", scope)
+		}
+	}
+	if len(client.DefaultScopes) == 0 {
+		return nil
+	}
+	hasOpenID := false
+	for _, scope := range client.DefaultScopes {
+		if scope == "openid" {
+			hasOpenID = true
+		}
+		if !client.AllowsScope(scope) {
+			return fmt.Errorf("default_scopes must be included in allowed_scopes: %s", scope)
+		}
+	}
+	if !hasOpenID {
+		return fmt.Errorf("default_scopes must include openid")
+	}
+	return nil
+}
+
 // validateRedirectURI は URI が有効でフラグメントを含まないことを検証する（RFC 6749 Section 3.1.2）。
 func validateRedirectURI(uri string) error {
 	parsed, err := url.Parse(uri)
@@ -458,3 +929,121 @@ func validateRedirectURI(uri string) error {
 	}
 	return nil
 }
+
+// issueClientSecret は新しい client_secret を生成し、ハッシュと暗号文をクライアントに設定する。平文を返す。
+func issueClientSecret(ctx context.Context, client *model.Client, hashPassword HashPasswordFunc, encryptSecret EncryptSecretFunc) (string, error) {
+	secret, err := generateClientSecret()
+	if err != nil {
+		return "", err
+	}
+	hash, err := hashPassword(ctx, secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash client_secret: %w", err)
+	}
+	// client_secret_jwt の HS256 検証用に暗号化した平文も保持する
+	encrypted, err := encryptSecret(secret)
+	if err != nil {
+		return "", fmt.Errorf("failed to encrypt client_secret: %w", err)
+	}
+	client.ClientSecretHash = &hash
+	client.ClientSecretEncrypted = &encrypted
+	return secret, nil
+}
+
+// validateClientAuthentication は token_endpoint_auth_method と関連メタデータの整合性を検証する。
+func validateClientAuthentication(client *model.Client) error {
+	jwks, jwksURI := client.JWKS, client.JWKSURI
+
+	switch client.TokenEndpointAuthMethod {
+	case "none":
+		// 公開クライアントは PKCE 必須 (RFC 9700 Section 2.1.1)
+		if !client.RequirePKCE {
+			return fmt.Errorf("require_pkce must be true for token_endpoint_auth_method none")
+		}
+		if client.HasGrantType("client_credentials") {
+			return fmt.Errorf("client_credentials grant requires client authentication")
+		}
+	case "private_key_jwt", "self_signed_tls_client_auth":
+		if (jwks == nil) == (jwksURI == nil) {
+			return fmt.Errorf("exactly one of jwks or jwks_uri is required for %s", client.TokenEndpointAuthMethod)
+		}
+	case "tls_client_auth":
+		// 証明書の照合に使う値はちょうど1つ (MUST: RFC 8705 Section 2.1.2)
+		set := 0
+		for _, v := range []*string{
+			client.TLSClientAuthSubjectDN, client.TLSClientAuthSANDNS, client.TLSClientAuthSANURI,
+			client.TLSClientAuthSANIP, client.TLSClientAuthSANEmail,
+		} {
+			if v != nil {
+				set++
+			}
+		}
+		if set != 1 {
+			return fmt.Errorf("exactly one of tls_client_auth_subject_dn, tls_client_auth_san_dns, tls_client_auth_san_uri, tls_client_auth_san_ip, tls_client_auth_san_email is required for tls_client_auth")
+		}
+		if client.TLSClientAuthSANIP != nil && net.ParseIP(*client.TLSClientAuthSANIP) == nil {
+			return fmt.Errorf("tls_client_auth_san_ip must be a valid IP address")
+		}
+	}
+
+	if jwks != nil {
+		if err := validateClientJWKS(*jwks); err != nil {
+			return err
+		}
+	}
+	if jwksURI != nil {
+		parsed, err := url.Parse(*jwksURI)
+		if err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+			return fmt.Errorf("jwks_uri must be an absolute http(s) URL")
+		}
+	}
+	return nil
+}
+
+// validateClientJWKS はクライアントが登録する JWK Set が非対称鍵の公開鍵のみで構成されていることを検証する。
+func validateClientJWKS(raw string) error {
+	set, err := jwk.ParseString(raw)
+	if err != nil {
+		return fmt.Errorf("jwks must be a valid JWK Set")
+	}
+	if set.Len() == 0 {
+		return fmt.Errorf("jwks must contain at least one key")
+	}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		isPrivate, err := jwk.IsPrivateKey(key)
+		if err != nil {
+			// 対称鍵 (oct) は IsPrivateKey がエラーを返す
+			return fmt.Errorf("jwks must not contain symmetric keys")
+		}
+		if isPrivate {
+			return fmt.Errorf("jwks must not contain private keys")
+		}
+	}
+	return nil
+}
+
+// nonNilStrings は JSON で null ではなく空配列を返すために nil を空スライスに変換する。
+func redirectURIStrings(uris []model.RedirectURI) []string {
+	s := make([]string, len(uris))
+	for i, u := range uris {
+		s[i] = u.URI
+	}
+	return s
+}
+
+func nonNilStrings(s []string) []string {
+	if s == nil {
+		return []string{}
+	}
+	return s
+}
+
+// rawJWKSToPtr は JSON リクエストの jwks を保存用の文字列に変換する。空または null の場合は nil。
+func rawJWKSToPtr(raw json.RawMessage) *string {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	s := string(raw)
+	return &s
+}
@@ -33,12 +33,162 @@ type ClientStore interface {
 	FindByIDWithRelations(ctx context.Context, id uuid.UUID) (*model.Client, error)
 	// Update はクライアントの変更を保存する。
 	Update(ctx context.Context, client *model.Client) error
-	// UpdateSecretHash は client_secret_hash フィールドのみを更新する。
-	UpdateSecretHash(ctx context.Context, id uuid.UUID, hash string) error
+	// UpdateSecret は client_secret_hash と client_secret_encrypted フィールドのみを更新する。
+	UpdateSecret(ctx context.Context, id uuid.UUID, hash, encrypted string) error
 	// SoftDelete はクライアントの status を "disabled" に設定して論理削除する。
 	SoftDelete(ctx context.Context, id uuid.UUID) error
 }
 
+// RegisteredClientStore は動的クライアント登録 (RFC 7591 / RFC 7592) 向けのクライアント永続化操作を定義する。
+type RegisteredClientStore interface {
+	// FindByClientIDWithRedirectURIs は client_id でクライアントを検索し、リダイレクト URI をプリロードして返す。見つからない場合は (nil, nil) を返す。
+	FindByClientIDWithRedirectURIs(ctx context.Context, clientID string) (*model.Client, error)
+	// Create は新しいクライアントを永続化する。
+	Create(ctx context.Context, client *model.Client) error
+	// UpdateWithRedirectURIs はクライアントの変更を保存し、リダイレクト URI を置き換える。
+	UpdateWithRedirectURIs(ctx context.Context, client *model.Client) error
+	// SoftDelete はクライアントの status を "disabled" に設定して論理削除する。
+	SoftDelete(ctx context.Context, id uuid.UUID) error
+}
+
+// InitialAccessTokenStore は動的クライアント登録用の初期アクセストークンの永続化操作を定義する。
+type InitialAccessTokenStore interface {
+	// ListByTenantID はテナントに属する初期アクセストークンを返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.InitialAccessToken, error)
+	// Create は新しい初期アクセストークンを永続化する。
+	Create(ctx context.Context, token *model.InitialAccessToken) error
+	// FindValidByHash は有効期限内の初期アクセストークンをハッシュ値で検索する。見つからない場合は (nil, nil) を返す。
+	FindValidByHash(ctx context.Context, tenantID uuid.UUID, tokenHash string) (*model.InitialAccessToken, error)
+	// FindByID は UUID で初期アクセストークンを検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.InitialAccessToken, error)
+	// Delete は初期アクセストークンを削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// SCIMTokenStore は SCIM のプロビジョニング API 用の Bearer トークンの永続化操作を定義する。
+type SCIMTokenStore interface {
+	// ListByTenantID はテナントに属するトークンを返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.SCIMToken, error)
+	// Create は新しいトークンを永続化する。
+	Create(ctx context.Context, token *model.SCIMToken) error
+	// FindByID は UUID でトークンを検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.SCIMToken, error)
+	// Delete はトークンを削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// APIResourceStore は API リソース (RFC 8707) の永続化操作を定義する。
+type APIResourceStore interface {
+	// ListByTenantID はテナントに属する API リソースを返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.APIResource, error)
+	// Create は新しい API リソースを永続化する。
+	Create(ctx context.Context, resource *model.APIResource) error
+	// FindByID は UUID で API リソースを検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.APIResource, error)
+	// FindByIdentifier はテナント内でリソース識別子が一致する API リソースを検索する。見つからない場合は (nil, nil) を返す。
+	FindByIdentifier(ctx context.Context, tenantID uuid.UUID, identifier string) (*model.APIResource, error)
+	// Update は API リソースの変更を保存する。
+	Update(ctx context.Context, resource *model.APIResource) error
+	// Delete は API リソースを削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// AuthorizationDetailTypeStore は authorization_details の type (RFC 9396) の永続化操作を定義する。
+type AuthorizationDetailTypeStore interface {
+	// ListByTenantID はテナントに属する type を返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.AuthorizationDetailType, error)
+	// Create は新しい type を永続化する。
+	Create(ctx context.Context, detailType *model.AuthorizationDetailType) error
+	// FindByID は UUID で type を検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.AuthorizationDetailType, error)
+	// FindByType はテナント内で type の値が一致するものを検索する。見つからない場合は (nil, nil) を返す。
+	FindByType(ctx context.Context, tenantID uuid.UUID, detailType string) (*model.AuthorizationDetailType, error)
+	// Update は type の変更を保存する。
+	Update(ctx context.Context, detailType *model.AuthorizationDetailType) error
+	// Delete は type を削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// ScopeDefinitionStore はテナントのカスタムスコープ定義の永続化操作を定義する。
+type ScopeDefinitionStore interface {
+	// ListByTenantID はテナントに属するスコープ定義を返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.ScopeDefinition, error)
+	// Create は新しいスコープ定義を永続化する。
+	Create(ctx context.Context, def *model.ScopeDefinition) error
+	// FindByID は UUID でスコープ定義を検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.ScopeDefinition, error)
+	// FindByName はテナント内で名前が一致するスコープ定義を検索する。見つからない場合は (nil, nil) を返す。
+	FindByName(ctx context.Context, tenantID uuid.UUID, name string) (*model.ScopeDefinition, error)
+	// Update はスコープ定義の変更を保存する。
+	Update(ctx context.Context, def *model.ScopeDefinition) error
+	// Delete はスコープ定義を削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// IdentityProviderStore はテナントが連携する上流の OIDC Provider の永続化操作を定義する。
+type IdentityProviderStore interface {
+	// ListByTenantID はテナントに属する IdP を返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.IdentityProvider, error)
+	// Create は新しい IdP を永続化する。
+	Create(ctx context.Context, provider *model.IdentityProvider) error
+	// FindByID は UUID で IdP を検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.IdentityProvider, error)
+	// FindByCode はテナント内でコードが一致する IdP を検索する。見つからない場合は (nil, nil) を返す。
+	FindByCode(ctx context.Context, tenantID uuid.UUID, code string) (*model.IdentityProvider, error)
+	// Update は IdP の変更を保存する。
+	Update(ctx context.Context, provider *model.IdentityProvider) error
+	// Delete は IdP を削除する。紐付け (external_idp_credentials) も削除される。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// LDAPDirectoryStore はテナントのパスワード認証を委譲する LDAP ディレクトリの永続化操作を定義する。
+type LDAPDirectoryStore interface {
+	// FindByTenantID はテナントのディレクトリを検索する。見つからない場合は (nil, nil) を返す。
+	FindByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.LDAPDirectory, error)
+	// Create は新しいディレクトリを永続化する。
+	Create(ctx context.Context, directory *model.LDAPDirectory) error
+	// Update はディレクトリの変更を保存する。
+	Update(ctx context.Context, directory *model.LDAPDirectory) error
+	// Delete はディレクトリを削除する。紐付け (ldap_credentials) も削除される。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// LDAPDirectoryClient は LDAP ディレクトリの設定の検証と接続テストを行う。
+type LDAPDirectoryClient interface {
+	// ValidateFilter はユーザー検索フィルタのテンプレートを検証する。
+	ValidateFilter(template string) error
+	// Lookup はユーザーのエントリを検索する。見つからない場合は (nil, nil) を返す。
+	Lookup(ctx context.Context, req *model.LDAPSearchRequest) (*model.LDAPEntry, error)
+}
+
+// UserMigrationHookStore は移行元でパスワードを検証する Webhook の永続化操作を定義する。
+type UserMigrationHookStore interface {
+	// FindByTenantID はテナントの Webhook を検索する。見つからない場合は (nil, nil) を返す。
+	FindByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.UserMigrationHook, error)
+	// Create は新しい Webhook を永続化する。
+	Create(ctx context.Context, hook *model.UserMigrationHook) error
+	// Update は Webhook の変更を保存する。
+	Update(ctx context.Context, hook *model.UserMigrationHook) error
+	// Delete は Webhook を削除する。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
+// SAMLServiceProviderStore はテナントの SAML IdP に登録された SP の永続化操作を定義する。
+type SAMLServiceProviderStore interface {
+	// ListByTenantID はテナントに属する SP を返す。
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.SAMLServiceProvider, error)
+	// Create は新しい SP を永続化する。
+	Create(ctx context.Context, sp *model.SAMLServiceProvider) error
+	// FindByID は UUID で SP を検索する。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.SAMLServiceProvider, error)
+	// FindByEntityID はテナント内で entityID が一致する SP を検索する。見つからない場合は (nil, nil) を返す。
+	FindByEntityID(ctx context.Context, tenantID uuid.UUID, entityID string) (*model.SAMLServiceProvider, error)
+	// Update は SP の変更を保存する。
+	Update(ctx context.Context, sp *model.SAMLServiceProvider) error
+	// Delete は SP を削除する。SAML セッション (saml_sessions) も削除される。
+	Delete(ctx context.Context, id uuid.UUID) error
+}
+
 // RedirectURIStore はリダイレクト URI の永続化操作を定義する。
 type RedirectURIStore interface {
 	// ListByClientID はクライアントに属する全てのリダイレクト URI を返す。
@@ -103,11 +253,65 @@ type RefreshTokenRevoker interface {
 	RevokeByUserID(ctx context.Context, userID uuid.UUID) (int64, error)
 }
 
+// SubjectResolver は発行済みの sub からユーザーを逆引きする。
+type SubjectResolver interface {
+	// ResolveSubject は sub に対応するユーザー ID を返す。見つからない場合は (nil, nil) を返す。
+	ResolveSubject(ctx context.Context, tenantID uuid.UUID, subject string) (*uuid.UUID, error)
+}
+
 // HashPasswordFunc は平文パスワードを argon2id でハッシュ化する。
-type HashPasswordFunc func(password string) (string, error)
+type HashPasswordFunc func(ctx context.Context, password string) (string, error)
+
+// PasswordHashAlgorithmFunc はパスワードのハッシュの形式を検証し、アルゴリズムを返す。
+type PasswordHashAlgorithmFunc func(hash string) (string, error)
+
+// EncryptSecretFunc は client_secret を鍵暗号化キー (AES-256-GCM) で暗号化する。
+type EncryptSecretFunc func(plaintext string) (string, error)
+
+// DecryptSecretFunc は EncryptSecretFunc で暗号化した値を復号する。
+type DecryptSecretFunc func(ciphertext string) (string, error)
+
+// FetchSectorIdentifierFunc は sector_identifier_uri の文書を取得し、含まれるリダイレクト URI の一覧を返す。
+type FetchSectorIdentifierFunc func(ctx context.Context, uri string) ([]string, error)
+
+// HashTokenFunc はトークンを保存用に SHA-256 でハッシュ化する (hex エンコード)。
+type HashTokenFunc func(token string) string
 
 // KeyRotator は新しい署名鍵を生成し、既存の鍵を無効化する。
 type KeyRotator interface {
 	// RotateKey は新しい有効な署名鍵を作成し、既存の有効な鍵を全て無効化する。
 	RotateKey(ctx context.Context) (*model.SignKey, error)
 }
+
+// UserStore は管理機能向けのエンドユーザー永続化操作を定義する。
+type UserStore interface {
+	// FindByID は UUID でユーザーを検索する。テナントもプリロードする。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.User, error)
+	// UpdateProfile はユーザーのプロフィールとカスタム属性を保存する。
+	UpdateProfile(ctx context.Context, user *model.User) error
+	// MarkEmailVerified は email が現在のメールアドレスと一致する場合に確認済みにする。一致しない場合は false を返す。
+	MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) (bool, error)
+}
+
+// UserTransferStore はユーザーの一括インポート・エクスポートの永続化操作を定義する。
+type UserTransferStore interface {
+	// FindByTenantAndLoginID はテナント内で login_id が一致するユーザーを検索する。削除済みのユーザーも返す。見つからない場合は (nil, nil) を返す。
+	FindByTenantAndLoginID(ctx context.Context, tenantID uuid.UUID, loginID string) (*model.User, error)
+	// FindByTenantAndEmail はテナント内でメールアドレスが一致するユーザーを大文字小文字を区別せず検索する。見つからない場合は (nil, nil) を返す。
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	// SaveImported はユーザーを作成または更新し、passwordHash が空でなければパスワードを置き換える。
+	SaveImported(ctx context.Context, user *model.User, passwordHash, algorithm string) error
+	// ListForExport は削除されていないユーザーを ID の昇順で after の次から limit 件返す。パスワードもプリロードする。
+	ListForExport(ctx context.Context, tenantID, after uuid.UUID, limit int) ([]model.User, error)
+}
+
+// UserAuditLogStore はユーザーの監査ログの参照操作を定義する。
+type UserAuditLogStore interface {
+	// ListByUserID はユーザーの監査ログを新しい順に返す。
+	ListByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]model.UserAuditLog, int64, error)
+}
+
+// EmailVerificationSender はユーザーにメールアドレスの確認メールを送信する。
+type EmailVerificationSender interface {
+	SendVerification(ctx context.Context, user *model.User) error
+}
@@ -0,0 +1,99 @@
+package management
+
+import (
+	"context"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// このファイルはパッケージ内のテストで共有するフェイクとヘルパーをまとめる。
+// 1つのテストでしか使わないフェイクはそのテストのファイルに置く
+
+type fakeTenantStore struct {
+	tenants []*model.Tenant
+}
+
+func newFakeTenantStore(tenants ...*model.Tenant) *fakeTenantStore {
+	return &fakeTenantStore{tenants: tenants}
+}
+
+func (f *fakeTenantStore) List(context.Context, int, int) ([]model.Tenant, int64, error) {
+	return nil, 0, nil
+}
+
+func (f *fakeTenantStore) Create(context.Context, *model.Tenant) error { return nil }
+
+func (f *fakeTenantStore) FindByID(_ context.Context, id uuid.UUID) (*model.Tenant, error) {
+	for _, t := range f.tenants {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTenantStore) FindByCode(_ context.Context, code string) (*model.Tenant, error) {
+	for _, t := range f.tenants {
+		if t.Code == code {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTenantStore) Update(context.Context, *model.Tenant) error { return nil }
+
+// fakeUserStore は users テーブルをメモリに持つ。DB と同じく検索結果はコピーを返す
+type fakeUserStore struct {
+	users map[uuid.UUID]*model.User
+	saved *model.User
+}
+
+func newFakeUserStore(users ...*model.User) *fakeUserStore {
+	f := &fakeUserStore{users: map[uuid.UUID]*model.User{}}
+	for _, u := range users {
+		f.users[u.ID] = u
+	}
+	return f
+}
+
+func (f *fakeUserStore) FindByID(_ context.Context, id uuid.UUID) (*model.User, error) {
+	u, ok := f.users[id]
+	if !ok {
+		return nil, nil
+	}
+	copied := *u
+	return &copied, nil
+}
+
+func (f *fakeUserStore) FindByTenantAndLoginID(_ context.Context, tenantID uuid.UUID, loginID string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.TenantID == tenantID && u.LoginID == loginID {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) FindByTenantAndEmail(_ context.Context, tenantID uuid.UUID, email string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.TenantID == tenantID && strings.EqualFold(u.Email, email) {
+			copied := *u
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeUserStore) UpdateProfile(_ context.Context, user *model.User) error {
+	f.saved = user
+	return nil
+}
+
+func (f *fakeUserStore) MarkEmailVerified(context.Context, uuid.UUID, string) (bool, error) {
+	return true, nil
+}
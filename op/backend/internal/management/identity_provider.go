@@ -0,0 +1,388 @@
+package management
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// IdentityProviderHandler はテナントが連携する上流の OIDC Provider の管理エンドポイントを処理する。
+type IdentityProviderHandler struct {
+	idpStore      IdentityProviderStore
+	tenantStore   TenantStore
+	encryptSecret EncryptSecretFunc
+}
+
+// NewIdentityProviderHandler は IdentityProviderHandler を生成する。
+func NewIdentityProviderHandler(idpStore IdentityProviderStore, tenantStore TenantStore, encryptSecret EncryptSecretFunc) *IdentityProviderHandler {
+	return &IdentityProviderHandler{
+		idpStore:      idpStore,
+		tenantStore:   tenantStore,
+		encryptSecret: encryptSecret,
+	}
+}
+
+type createIdentityProviderRequest struct {
+	Code            string            `json:"code"`
+	Name            string            `json:"name"`
+	Issuer          string            `json:"issuer"`
+	ClientID        string            `json:"client_id"`
+	ClientSecret    string            `json:"client_secret"`
+	Scopes          []string          `json:"scopes"`
+	ClaimMappings   map[string]string `json:"claim_mappings"`
+	JITProvisioning *bool             `json:"jit_provisioning,omitempty"`
+	LinkByEmail     bool              `json:"link_by_email"`
+}
+
+// updateIdentityProviderRequest は callback の URL が変わるため code の変更を受け付けない。
+// client_secret に空文字を指定すると削除する (公開クライアントとして PKCE のみで認証する)。
+type updateIdentityProviderRequest struct {
+	Name            *string           `json:"name,omitempty"`
+	Issuer          *string           `json:"issuer,omitempty"`
+	ClientID        *string           `json:"client_id,omitempty"`
+	ClientSecret    *string           `json:"client_secret,omitempty"`
+	Scopes          []string          `json:"scopes,omitempty"`
+	ClaimMappings   map[string]string `json:"claim_mappings,omitempty"`
+	JITProvisioning *bool             `json:"jit_provisioning,omitempty"`
+	LinkByEmail     *bool             `json:"link_by_email,omitempty"`
+	Status          *string           `json:"status,omitempty"`
+}
+
+// identityProviderResponse は client_secret を返さず、設定済みかどうかのみ返す。
+type identityProviderResponse struct {
+	ID              string            `json:"id"`
+	TenantID        string            `json:"tenant_id"`
+	Code            string            `json:"code"`
+	Name            string            `json:"name"`
+	Issuer          string            `json:"issuer"`
+	ClientID        string            `json:"client_id"`
+	ClientSecretSet bool              `json:"client_secret_set"`
+	Scopes          []string          `json:"scopes"`
+	ClaimMappings   map[string]string `json:"claim_mappings"`
+	JITProvisioning bool              `json:"jit_provisioning"`
+	LinkByEmail     bool              `json:"link_by_email"`
+	Status          string            `json:"status"`
+	CreatedAt       string            `json:"created_at"`
+	UpdatedAt       string            `json:"updated_at"`
+}
+
+func toIdentityProviderResponse(p *model.IdentityProvider) identityProviderResponse {
+	mappings := map[string]string(p.ClaimMappings)
+	if mappings == nil {
+		mappings = map[string]string{}
+	}
+	return identityProviderResponse{
+		ID:              p.ID.String(),
+		TenantID:        p.TenantID.String(),
+		Code:            p.Code,
+		Name:            p.Name,
+		Issuer:          p.Issuer,
+		ClientID:        p.ClientID,
+		ClientSecretSet: p.ClientSecretEncrypted != nil,
+		Scopes:          p.Scopes,
+		ClaimMappings:   mappings,
+		JITProvisioning: p.JITProvisioning,
+		LinkByEmail:     p.LinkByEmail,
+		Status:          p.Status,
+		CreatedAt:       p.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       p.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/identity-providers を処理する。
+func (h *IdentityProviderHandler) HandleList(c echo.Context) error {
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	idps, err := h.idpStore.ListByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to list identity providers: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]identityProviderResponse, len(idps))
+	for i, p := range idps {
+		data[i] = toIdentityProviderResponse(&p)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/identity-providers を処理する。
+func (h *IdentityProviderHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req createIdentityProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if !tenantCodeRegex.MatchString(req.Code) {
+		return badRequest(c, "code must be 3-63 characters of lowercase letters, digits and hyphens")
+	}
+	if req.Name == "" || len(req.Name) > 255 {
+		return badRequest(c, "name is required and must be at most 255 characters")
+	}
+	if req.ClientID == "" || len(req.ClientID) > 255 {
+		return badRequest(c, "client_id is required and must be at most 255 characters")
+	}
+	if err := validateIssuer(req.Issuer); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if req.Scopes == nil {
+		req.Scopes = []string{"openid", "email", "profile"}
+	}
+	if err := validateIdPScopes(req.Scopes); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateIdPClaimMappings(req.ClaimMappings); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	// 重複チェック
+	existing, err := h.idpStore.FindByCode(ctx, tenant.ID, req.Code)
+	if err != nil {
+		c.Logger().Errorf("failed to check identity provider: %v", err)
+		return serverError(c)
+	}
+	if existing != nil {
+		return conflict(c, "identity provider already exists")
+	}
+
+	idp := &model.IdentityProvider{
+		TenantID:        tenant.ID,
+		Code:            req.Code,
+		Name:            req.Name,
+		Issuer:          req.Issuer,
+		ClientID:        req.ClientID,
+		Scopes:          model.StringSlice(req.Scopes),
+		ClaimMappings:   model.IdPClaimMappings(req.ClaimMappings),
+		JITProvisioning: req.JITProvisioning == nil || *req.JITProvisioning,
+		LinkByEmail:     req.LinkByEmail,
+		Status:          "active",
+	}
+	if req.ClientSecret != "" {
+		if err := h.setClientSecret(idp, req.ClientSecret); err != nil {
+			c.Logger().Errorf("failed to encrypt client secret: %v", err)
+			return serverError(c)
+		}
+	}
+	if err := h.idpStore.Create(ctx, idp); err != nil {
+		c.Logger().Errorf("failed to create identity provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, toIdentityProviderResponse(idp))
+}
+
+// HandleGet は GET /management/v1/identity-providers/:id を処理する。
+func (h *IdentityProviderHandler) HandleGet(c echo.Context) error {
+	idp, err := h.findIdentityProvider(c)
+	if err != nil || idp == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toIdentityProviderResponse(idp))
+}
+
+// HandleUpdate は PUT /management/v1/identity-providers/:id を処理する。
+// 既存の紐付けは変更しない。claim_mappings の変更は以後に JIT 作成するユーザーに反映される。
+func (h *IdentityProviderHandler) HandleUpdate(c echo.Context) error {
+	idp, err := h.findIdentityProvider(c)
+	if err != nil || idp == nil {
+		return err
+	}
+
+	var req updateIdentityProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" || len(*req.Name) > 255 {
+			return badRequest(c, "name must be 1-255 characters")
+		}
+		idp.Name = *req.Name
+	}
+	if req.Issuer != nil {
+		if err := validateIssuer(*req.Issuer); err != nil {
+			return badRequest(c, err.Error())
+		}
+		idp.Issuer = *req.Issuer
+	}
+	if req.ClientID != nil {
+		if *req.ClientID == "" || len(*req.ClientID) > 255 {
+			return badRequest(c, "client_id must be 1-255 characters")
+		}
+		idp.ClientID = *req.ClientID
+	}
+	if req.Scopes != nil {
+		if err := validateIdPScopes(req.Scopes); err != nil {
+			return badRequest(c, err.Error())
+		}
+		idp.Scopes = model.StringSlice(req.Scopes)
+	}
+	if req.ClaimMappings != nil {
+		if err := validateIdPClaimMappings(req.ClaimMappings); err != nil {
+			return badRequest(c, err.Error())
+		}
+		idp.ClaimMappings = model.IdPClaimMappings(req.ClaimMappings)
+	}
+	if req.JITProvisioning != nil {
+		idp.JITProvisioning = *req.JITProvisioning
+	}
+	if req.LinkByEmail != nil {
+		idp.LinkByEmail = *req.LinkByEmail
+	}
+	if req.Status != nil {
+		if *req.Status != "active" && *req.Status != "disabled" {
+			return badRequest(c, "status must be active or disabled")
+		}
+		idp.Status = *req.Status
+	}
+	if req.ClientSecret != nil {
+		if *req.ClientSecret == "" {
+			idp.ClientSecretEncrypted = nil
+		} else if err := h.setClientSecret(idp, *req.ClientSecret); err != nil {
+			c.Logger().Errorf("failed to encrypt client secret: %v", err)
+			return serverError(c)
+		}
+	}
+
+	if err := h.idpStore.Update(c.Request().Context(), idp); err != nil {
+		c.Logger().Errorf("failed to update identity provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toIdentityProviderResponse(idp))
+}
+
+// HandleDelete は DELETE /management/v1/identity-providers/:id を処理する。
+// 紐付けも削除する。ユーザーは削除しないが、他の認証手段がなければログインできなくなる。
+func (h *IdentityProviderHandler) HandleDelete(c echo.Context) error {
+	idp, err := h.findIdentityProvider(c)
+	if err != nil || idp == nil {
+		return err
+	}
+
+	if err := h.idpStore.Delete(c.Request().Context(), idp.ID); err != nil {
+		c.Logger().Errorf("failed to delete identity provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *IdentityProviderHandler) setClientSecret(idp *model.IdentityProvider, secret string) error {
+	encrypted, err := h.encryptSecret(secret)
+	if err != nil {
+		return err
+	}
+	idp.ClientSecretEncrypted = &encrypted
+	return nil
+}
+
+// findTenant は ID でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *IdentityProviderHandler) findTenant(c echo.Context, rawID string) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// findIdentityProvider はパスの :id で IdP を検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *IdentityProviderHandler) findIdentityProvider(c echo.Context) (*model.IdentityProvider, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid identity provider id format")
+	}
+
+	idp, err := h.idpStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find identity provider: %v", err)
+		return nil, serverError(c)
+	}
+	if idp == nil {
+		return nil, notFound(c, "identity provider not found")
+	}
+	return idp, nil
+}
+
+// validateIssuer は issuer を検証する。クエリ・フラグメントを含まない https の URL に限る (OIDC Discovery 1.0 Section 2)。
+// 開発用にループバックアドレスのみ http を許可する。
+func validateIssuer(issuer string) error {
+	parsed, err := url.Parse(issuer)
+	if err != nil || parsed.Host == "" || parsed.RawQuery != "" || parsed.Fragment != "" || len(issuer) > 2048 {
+		return fmt.Errorf("issuer must be an absolute URL without query or fragment")
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("issuer must use https")
+}
+
+// validateIdPScopes は上流に要求するスコープを検証する。ID トークンを得るため openid が必須。
+func validateIdPScopes(scopes []string) error {
+	hasOpenID := false
+	seen := map[string]bool{}
+	for _, scope := range scopes {
+		if !scopeTokenRegex.MatchString(scope) {
+			return fmt.Errorf("invalid scope: %q", scope)
+		}
+		if seen[scope] {
+			return fmt.Errorf("duplicate scope: %s", scope)
+		}
+		seen[scope] = true
+		hasOpenID = hasOpenID || scope == "openid"
+	}
+	if !hasOpenID {
+		return fmt.Errorf("scopes must include openid")
+	}
+	return nil
+}
+
+// validateIdPClaimMappings はクレームマッピングを検証する。キーは上流のクレームを割り当てられるユーザー属性に限る。
+func validateIdPClaimMappings(mappings map[string]string) error {
+	attributes := map[string]bool{}
+	for _, name := range model.FederatedUserAttributes {
+		attributes[name] = true
+	}
+	for attr, claim := range mappings {
+		if !attributes[attr] {
+			return fmt.Errorf("claim_mappings refers to an unknown attribute: %s", attr)
+		}
+		if claim == "" || len(claim) > 255 {
+			return fmt.Errorf("claim_mappings.%s must be 1-255 characters", attr)
+		}
+	}
+	return nil
+}
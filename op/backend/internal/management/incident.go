@@ -1,6 +1,8 @@
 package management
 
 import (
+	"context"
+	"fmt"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -12,6 +14,8 @@ type IncidentHandler struct {
 	sessionRevoker      SessionRevoker
 	accessTokenRevoker  AccessTokenRevoker
 	refreshTokenRevoker RefreshTokenRevoker
+	subjectResolver     SubjectResolver
+	userRevoker         *UserRevoker
 }
 
 // NewIncidentHandler は IncidentHandler を生成する。
@@ -19,20 +23,60 @@ func NewIncidentHandler(
 	sessionRevoker SessionRevoker,
 	accessTokenRevoker AccessTokenRevoker,
 	refreshTokenRevoker RefreshTokenRevoker,
+	subjectResolver SubjectResolver,
 ) *IncidentHandler {
 	return &IncidentHandler{
 		sessionRevoker:      sessionRevoker,
 		accessTokenRevoker:  accessTokenRevoker,
 		refreshTokenRevoker: refreshTokenRevoker,
+		subjectResolver:     subjectResolver,
+		userRevoker:         NewUserRevoker(sessionRevoker, accessTokenRevoker, refreshTokenRevoker),
 	}
 }
 
+// UserRevoker はユーザーのセッション・アクセストークン・リフレッシュトークンを失効させる。
+// インシデント対応と SCIM によるユーザーの無効化・削除で共用する。
+type UserRevoker struct {
+	sessionRevoker      SessionRevoker
+	accessTokenRevoker  AccessTokenRevoker
+	refreshTokenRevoker RefreshTokenRevoker
+}
+
+// NewUserRevoker は UserRevoker を生成する。
+func NewUserRevoker(sessionRevoker SessionRevoker, accessTokenRevoker AccessTokenRevoker, refreshTokenRevoker RefreshTokenRevoker) *UserRevoker {
+	return &UserRevoker{
+		sessionRevoker:      sessionRevoker,
+		accessTokenRevoker:  accessTokenRevoker,
+		refreshTokenRevoker: refreshTokenRevoker,
+	}
+}
+
+// RevokeUser はユーザーの有効なセッションとトークンを全て失効させ、それぞれの件数を返す。
+func (r *UserRevoker) RevokeUser(ctx context.Context, userID uuid.UUID) (sessions, accessTokens, refreshTokens int64, err error) {
+	sessions, err = r.sessionRevoker.RevokeByUserID(ctx, userID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to revoke user sessions: %w", err)
+	}
+	accessTokens, err = r.accessTokenRevoker.RevokeByUserID(ctx, userID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to revoke user access tokens: %w", err)
+	}
+	refreshTokens, err = r.refreshTokenRevoker.RevokeByUserID(ctx, userID)
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("failed to revoke user refresh tokens: %w", err)
+	}
+	return sessions, accessTokens, refreshTokens, nil
+}
+
 type revokeTenantRequest struct {
 	TenantID string `json:"tenant_id"`
 }
 
+// revokeUserRequest は user_id か、RP から報告された sub と tenant_id の組でユーザーを指定する。
 type revokeUserRequest struct {
-	UserID string `json:"user_id"`
+	UserID   string `json:"user_id,omitempty"`
+	Subject  string `json:"subject,omitempty"`
+	TenantID string `json:"tenant_id,omitempty"`
 }
 
 type revokeResponse struct {
@@ -118,24 +162,35 @@ func (h *IncidentHandler) HandleRevokeUser(c echo.Context) error {
 		return badRequest(c, "invalid request body")
 	}
 
-	userID, err := uuid.Parse(req.UserID)
-	if err != nil {
-		return badRequest(c, "invalid user_id format")
+	var userID uuid.UUID
+	switch {
+	case req.UserID != "":
+		parsed, err := uuid.Parse(req.UserID)
+		if err != nil {
+			return badRequest(c, "invalid user_id format")
+		}
+		userID = parsed
+	case req.Subject != "":
+		tenantID, err := uuid.Parse(req.TenantID)
+		if err != nil {
+			return badRequest(c, "tenant_id is required with subject")
+		}
+		resolved, err := h.subjectResolver.ResolveSubject(ctx, tenantID, req.Subject)
+		if err != nil {
+			c.Logger().Errorf("failed to resolve subject: %v", err)
+			return serverError(c)
+		}
+		if resolved == nil {
+			return notFound(c, "subject not found")
+		}
+		userID = *resolved
+	default:
+		return badRequest(c, "user_id or subject is required")
 	}
 
-	sessions, err := h.sessionRevoker.RevokeByUserID(ctx, userID)
-	if err != nil {
-		c.Logger().Errorf("failed to revoke user sessions: %v", err)
-		return serverError(c)
-	}
-	accessTokens, err := h.accessTokenRevoker.RevokeByUserID(ctx, userID)
-	if err != nil {
-		c.Logger().Errorf("failed to revoke user access tokens: %v", err)
-		return serverError(c)
-	}
-	refreshTokens, err := h.refreshTokenRevoker.RevokeByUserID(ctx, userID)
+	sessions, accessTokens, refreshTokens, err := h.userRevoker.RevokeUser(ctx, userID)
 	if err != nil {
-		c.Logger().Errorf("failed to revoke user refresh tokens: %v", err)
+		c.Logger().Errorf("failed to revoke user: %v", err)
 		return serverError(c)
 	}
 
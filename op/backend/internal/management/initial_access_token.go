@@ -0,0 +1,180 @@
+package management
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// InitialAccessTokenHandler は動的クライアント登録用の初期アクセストークン管理エンドポイントを処理する。
+type InitialAccessTokenHandler struct {
+	tokenStore  InitialAccessTokenStore
+	tenantStore TenantStore
+	hashToken   HashTokenFunc
+}
+
+// NewInitialAccessTokenHandler は InitialAccessTokenHandler を生成する。
+func NewInitialAccessTokenHandler(tokenStore InitialAccessTokenStore, tenantStore TenantStore, hashToken HashTokenFunc) *InitialAccessTokenHandler {
+	return &InitialAccessTokenHandler{
+		tokenStore:  tokenStore,
+		tenantStore: tenantStore,
+		hashToken:   hashToken,
+	}
+}
+
+type createInitialAccessTokenRequest struct {
+	Description *string `json:"description,omitempty"`
+	// ExpiresIn は有効期間 (秒)。省略時は無期限
+	ExpiresIn *int `json:"expires_in,omitempty"`
+}
+
+type initialAccessTokenResponse struct {
+	ID          string  `json:"id"`
+	TenantID    string  `json:"tenant_id"`
+	Description *string `json:"description,omitempty"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+type initialAccessTokenCreateResponse struct {
+	initialAccessTokenResponse
+	Token string `json:"token"`
+}
+
+func toInitialAccessTokenResponse(t *model.InitialAccessToken) initialAccessTokenResponse {
+	var expiresAt *string
+	if t.ExpiresAt != nil {
+		s := t.ExpiresAt.Format(time.RFC3339)
+		expiresAt = &s
+	}
+	return initialAccessTokenResponse{
+		ID:          t.ID.String(),
+		TenantID:    t.TenantID.String(),
+		Description: t.Description,
+		ExpiresAt:   expiresAt,
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/initial-access-tokens を処理する。
+func (h *InitialAccessTokenHandler) HandleList(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	tokens, err := h.tokenStore.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to list initial access tokens: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]initialAccessTokenResponse, len(tokens))
+	for i, t := range tokens {
+		data[i] = toInitialAccessTokenResponse(&t)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/initial-access-tokens を処理する。
+// トークンの平文はこのレスポンスでのみ返す。
+func (h *InitialAccessTokenHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	var req createInitialAccessTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+	if req.Description != nil && len(*req.Description) > 255 {
+		return badRequest(c, "description must be at most 255 characters")
+	}
+	if req.ExpiresIn != nil && *req.ExpiresIn <= 0 {
+		return badRequest(c, "expires_in must be positive")
+	}
+
+	plaintext, err := generateAccessToken()
+	if err != nil {
+		c.Logger().Errorf("failed to generate initial access token: %v", err)
+		return serverError(c)
+	}
+
+	token := &model.InitialAccessToken{
+		TenantID:    tenantID,
+		TokenHash:   h.hashToken(plaintext),
+		Description: req.Description,
+	}
+	if req.ExpiresIn != nil {
+		expiresAt := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := h.tokenStore.Create(ctx, token); err != nil {
+		c.Logger().Errorf("failed to create initial access token: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, initialAccessTokenCreateResponse{
+		initialAccessTokenResponse: toInitialAccessTokenResponse(token),
+		Token:                      plaintext,
+	})
+}
+
+// HandleDelete は DELETE /management/v1/tenants/:tenant_id/initial-access-tokens/:token_id を処理する。
+func (h *InitialAccessTokenHandler) HandleDelete(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+	tokenID, err := uuid.Parse(c.Param("token_id"))
+	if err != nil {
+		return badRequest(c, "invalid token_id format")
+	}
+
+	token, err := h.tokenStore.FindByID(ctx, tokenID)
+	if err != nil {
+		c.Logger().Errorf("failed to find initial access token: %v", err)
+		return serverError(c)
+	}
+	if token == nil || token.TenantID != tenantID {
+		return notFound(c, "initial access token not found")
+	}
+
+	if err := h.tokenStore.Delete(ctx, tokenID); err != nil {
+		c.Logger().Errorf("failed to delete initial access token: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
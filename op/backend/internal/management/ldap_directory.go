@@ -0,0 +1,452 @@
+package management
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// LDAP ディレクトリの既定値 (Active Directory)
+const (
+	defaultLDAPUserSearchFilter = "(&(objectClass=user)(sAMAccountName={username}))"
+	defaultLDAPIDAttribute      = "objectGUID"
+	defaultLDAPGroupAttribute   = "memberOf"
+)
+
+// ldapAttributeRegex は属性名または OID (RFC 4512 Section 2.5)
+var ldapAttributeRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.-]{0,254}$`)
+
+// LDAPDirectoryHandler はテナントのパスワード認証を委譲する LDAP ディレクトリの管理エンドポイントを処理する。
+type LDAPDirectoryHandler struct {
+	directoryStore LDAPDirectoryStore
+	tenantStore    TenantStore
+	client         LDAPDirectoryClient
+	encryptSecret  EncryptSecretFunc
+	decryptSecret  DecryptSecretFunc
+}
+
+// NewLDAPDirectoryHandler は LDAPDirectoryHandler を生成する。
+func NewLDAPDirectoryHandler(directoryStore LDAPDirectoryStore, tenantStore TenantStore, client LDAPDirectoryClient, encryptSecret EncryptSecretFunc, decryptSecret DecryptSecretFunc) *LDAPDirectoryHandler {
+	return &LDAPDirectoryHandler{
+		directoryStore: directoryStore,
+		tenantStore:    tenantStore,
+		client:         client,
+		encryptSecret:  encryptSecret,
+		decryptSecret:  decryptSecret,
+	}
+}
+
+// putLDAPDirectoryRequest はディレクトリの設定全体を表す。省略した項目は既定値になる。
+// bind_password は省略すると現在の値を維持し、空文字を指定すると削除する。
+type putLDAPDirectoryRequest struct {
+	Name              string            `json:"name"`
+	Host              string            `json:"host"`
+	Port              int               `json:"port"`
+	Security          string            `json:"security"`
+	CACertificate     string            `json:"ca_certificate"`
+	BindDN            string            `json:"bind_dn"`
+	BindPassword      *string           `json:"bind_password,omitempty"`
+	UserSearchBase    string            `json:"user_search_base"`
+	UserSearchFilter  string            `json:"user_search_filter"`
+	IDAttribute       string            `json:"id_attribute"`
+	AttributeMappings map[string]string `json:"attribute_mappings"`
+	JITProvisioning   *bool             `json:"jit_provisioning,omitempty"`
+	LinkByLoginID     bool              `json:"link_by_login_id"`
+	GroupSyncEnabled  bool              `json:"group_sync_enabled"`
+	GroupAttribute    string            `json:"group_attribute"`
+	Status            string            `json:"status"`
+}
+
+// ldapDirectoryResponse は bind_password を返さず、設定済みかどうかのみ返す。
+type ldapDirectoryResponse struct {
+	ID                string            `json:"id"`
+	TenantID          string            `json:"tenant_id"`
+	Name              string            `json:"name"`
+	Host              string            `json:"host"`
+	Port              int               `json:"port"`
+	Security          string            `json:"security"`
+	CACertificate     *string           `json:"ca_certificate"`
+	BindDN            *string           `json:"bind_dn"`
+	BindPasswordSet   bool              `json:"bind_password_set"`
+	UserSearchBase    string            `json:"user_search_base"`
+	UserSearchFilter  string            `json:"user_search_filter"`
+	IDAttribute       string            `json:"id_attribute"`
+	AttributeMappings map[string]string `json:"attribute_mappings"`
+	JITProvisioning   bool              `json:"jit_provisioning"`
+	LinkByLoginID     bool              `json:"link_by_login_id"`
+	GroupSyncEnabled  bool              `json:"group_sync_enabled"`
+	GroupAttribute    string            `json:"group_attribute"`
+	Status            string            `json:"status"`
+	CreatedAt         string            `json:"created_at"`
+	UpdatedAt         string            `json:"updated_at"`
+}
+
+func toLDAPDirectoryResponse(d *model.LDAPDirectory) ldapDirectoryResponse {
+	mappings := map[string]string(d.AttributeMappings)
+	if mappings == nil {
+		mappings = map[string]string{}
+	}
+	return ldapDirectoryResponse{
+		ID:                d.ID.String(),
+		TenantID:          d.TenantID.String(),
+		Name:              d.Name,
+		Host:              d.Host,
+		Port:              d.Port,
+		Security:          d.Security,
+		CACertificate:     d.CACertificate,
+		BindDN:            d.BindDN,
+		BindPasswordSet:   d.BindPasswordEncrypted != nil,
+		UserSearchBase:    d.UserSearchBase,
+		UserSearchFilter:  d.UserSearchFilter,
+		IDAttribute:       d.IDAttribute,
+		AttributeMappings: mappings,
+		JITProvisioning:   d.JITProvisioning,
+		LinkByLoginID:     d.LinkByLoginID,
+		GroupSyncEnabled:  d.GroupSyncEnabled,
+		GroupAttribute:    d.GroupAttribute,
+		Status:            d.Status,
+		CreatedAt:         d.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:         d.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleGet は GET /management/v1/tenants/:tenant_id/ldap-directory を処理する。
+func (h *LDAPDirectoryHandler) HandleGet(c echo.Context) error {
+	directory, err := h.findDirectory(c)
+	if err != nil || directory == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toLDAPDirectoryResponse(directory))
+}
+
+// HandlePut は PUT /management/v1/tenants/:tenant_id/ldap-directory を処理する。
+// ディレクトリがなければ作成し (201)、あれば設定を置き換える (200)。既存の紐付けは変更しない。
+func (h *LDAPDirectoryHandler) HandlePut(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req putLDAPDirectoryRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	directory, err := h.directoryStore.FindByTenantID(ctx, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to find ldap directory: %v", err)
+		return serverError(c)
+	}
+	status := http.StatusOK
+	if directory == nil {
+		directory = &model.LDAPDirectory{TenantID: tenant.ID}
+		status = http.StatusCreated
+	}
+
+	if err := h.applyRequest(directory, &req); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if req.BindPassword != nil {
+		if *req.BindPassword == "" {
+			directory.BindPasswordEncrypted = nil
+		} else {
+			encrypted, err := h.encryptSecret(*req.BindPassword)
+			if err != nil {
+				c.Logger().Errorf("failed to encrypt bind password: %v", err)
+				return serverError(c)
+			}
+			directory.BindPasswordEncrypted = &encrypted
+		}
+	}
+	if directory.BindDN != nil && directory.BindPasswordEncrypted == nil {
+		return badRequest(c, "bind_password is required when bind_dn is set")
+	}
+
+	if status == http.StatusCreated {
+		err = h.directoryStore.Create(ctx, directory)
+	} else {
+		err = h.directoryStore.Update(ctx, directory)
+	}
+	if err != nil {
+		c.Logger().Errorf("failed to save ldap directory: %v", err)
+		return serverError(c)
+	}
+	return c.JSON(status, toLDAPDirectoryResponse(directory))
+}
+
+// HandleDelete は DELETE /management/v1/tenants/:tenant_id/ldap-directory を処理する。
+// 紐付けも削除する。ユーザーは削除しないが、パスワードが設定されていなければログインできなくなる。
+func (h *LDAPDirectoryHandler) HandleDelete(c echo.Context) error {
+	directory, err := h.findDirectory(c)
+	if err != nil || directory == nil {
+		return err
+	}
+	if err := h.directoryStore.Delete(c.Request().Context(), directory.ID); err != nil {
+		c.Logger().Errorf("failed to delete ldap directory: %v", err)
+		return serverError(c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+type testLDAPDirectoryRequest struct {
+	LoginID string `json:"login_id"`
+}
+
+// testLDAPDirectoryResponse は接続テストの結果。ディレクトリのエラーは success = false と error で返す
+type testLDAPDirectoryResponse struct {
+	Success    bool                `json:"success"`
+	Error      string              `json:"error,omitempty"`
+	DN         string              `json:"dn,omitempty"`
+	Attributes map[string][]string `json:"attributes,omitempty"`
+}
+
+// HandleTest は POST /management/v1/tenants/:tenant_id/ldap-directory/test を処理する。
+// 保存済みの設定でサービスアカウントの bind と login_id のユーザーの検索を行い、見つかったエントリの属性を返す。
+// パスワードは検証しない。UTF-8 でない値 (objectGUID など) は 16 進数の文字列で返す
+func (h *LDAPDirectoryHandler) HandleTest(c echo.Context) error {
+	directory, err := h.findDirectory(c)
+	if err != nil || directory == nil {
+		return err
+	}
+
+	var req testLDAPDirectoryRequest
+	if err := c.Bind(&req); err != nil || req.LoginID == "" {
+		return badRequest(c, "login_id is required")
+	}
+
+	searchReq := &model.LDAPSearchRequest{
+		Host:       directory.Host,
+		Port:       directory.Port,
+		Security:   directory.Security,
+		SearchBase: directory.UserSearchBase,
+		Filter:     directory.UserSearchFilter,
+		LoginID:    req.LoginID,
+		Attributes: []string{"*"},
+	}
+	if directory.CACertificate != nil {
+		searchReq.CACertificate = *directory.CACertificate
+	}
+	if directory.BindDN != nil {
+		searchReq.BindDN = *directory.BindDN
+	}
+	if directory.BindPasswordEncrypted != nil {
+		password, err := h.decryptSecret(*directory.BindPasswordEncrypted)
+		if err != nil {
+			c.Logger().Errorf("failed to decrypt bind password: %v", err)
+			return serverError(c)
+		}
+		searchReq.BindPassword = password
+	}
+
+	entry, err := h.client.Lookup(c.Request().Context(), searchReq)
+	if err != nil {
+		return c.JSON(http.StatusOK, testLDAPDirectoryResponse{Error: err.Error()})
+	}
+	if entry == nil {
+		return c.JSON(http.StatusOK, testLDAPDirectoryResponse{Error: "user not found"})
+	}
+
+	attributes := map[string][]string{}
+	for name, values := range entry.Attributes {
+		for _, v := range values {
+			if !utf8.ValidString(v) {
+				v = hex.EncodeToString([]byte(v))
+			}
+			attributes[name] = append(attributes[name], v)
+		}
+	}
+	return c.JSON(http.StatusOK, testLDAPDirectoryResponse{Success: true, DN: entry.DN, Attributes: attributes})
+}
+
+// applyRequest はリクエストを検証してディレクトリに設定する。bind_password は呼び出し元で扱う
+func (h *LDAPDirectoryHandler) applyRequest(d *model.LDAPDirectory, req *putLDAPDirectoryRequest) error {
+	if req.Name == "" || len(req.Name) > 255 {
+		return fmt.Errorf("name is required and must be at most 255 characters")
+	}
+	if req.Host == "" || len(req.Host) > 255 || strings.ContainsAny(req.Host, "/ \t") {
+		return fmt.Errorf("host must be a host name or IP address")
+	}
+
+	if req.Security == "" {
+		req.Security = model.LDAPSecurityLDAPS
+	}
+	if req.Port == 0 {
+		// 既定のポート (RFC 4516 Section 2, LDAPS は IANA 登録の 636)
+		req.Port = 636
+		if req.Security == model.LDAPSecurityStartTLS {
+			req.Port = 389
+		}
+	}
+	if req.Security != model.LDAPSecurityLDAPS && req.Security != model.LDAPSecurityStartTLS {
+		return fmt.Errorf("security must be ldaps or starttls")
+	}
+	if req.Port < 1 || req.Port > 65535 {
+		return fmt.Errorf("port must be 1-65535")
+	}
+	if req.CACertificate != "" {
+		if err := validateCACertificate(req.CACertificate); err != nil {
+			return err
+		}
+	}
+	if len(req.BindDN) > 1024 {
+		return fmt.Errorf("bind_dn must be at most 1024 characters")
+	}
+	if req.UserSearchBase == "" || len(req.UserSearchBase) > 1024 {
+		return fmt.Errorf("user_search_base is required and must be at most 1024 characters")
+	}
+
+	if req.UserSearchFilter == "" {
+		req.UserSearchFilter = defaultLDAPUserSearchFilter
+	}
+	if len(req.UserSearchFilter) > 1024 {
+		return fmt.Errorf("user_search_filter must be at most 1024 characters")
+	}
+	if err := h.client.ValidateFilter(req.UserSearchFilter); err != nil {
+		return fmt.Errorf("user_search_filter: %v", err)
+	}
+
+	if req.IDAttribute == "" {
+		req.IDAttribute = defaultLDAPIDAttribute
+	}
+	if !ldapAttributeRegex.MatchString(req.IDAttribute) {
+		return fmt.Errorf("invalid id_attribute")
+	}
+	if req.GroupAttribute == "" {
+		req.GroupAttribute = defaultLDAPGroupAttribute
+	}
+	if !ldapAttributeRegex.MatchString(req.GroupAttribute) {
+		return fmt.Errorf("invalid group_attribute")
+	}
+
+	mappings := model.DefaultLDAPAttributeMappings()
+	if req.AttributeMappings != nil {
+		mappings = model.LDAPAttributeMappings(req.AttributeMappings)
+	}
+	if err := validateLDAPAttributeMappings(mappings); err != nil {
+		return err
+	}
+	jit := req.JITProvisioning == nil || *req.JITProvisioning
+	if jit && (mappings[model.FederatedLoginIDAttribute] == "" || mappings["email"] == "") {
+		return fmt.Errorf("attribute_mappings must include login_id and email when jit_provisioning is enabled")
+	}
+
+	if req.Status == "" {
+		req.Status = "active"
+	}
+	if req.Status != "active" && req.Status != "disabled" {
+		return fmt.Errorf("status must be active or disabled")
+	}
+
+	d.Name = req.Name
+	d.Host = req.Host
+	d.Port = req.Port
+	d.Security = req.Security
+	d.CACertificate = nil
+	if req.CACertificate != "" {
+		d.CACertificate = &req.CACertificate
+	}
+	d.BindDN = nil
+	if req.BindDN != "" {
+		d.BindDN = &req.BindDN
+	}
+	d.UserSearchBase = req.UserSearchBase
+	d.UserSearchFilter = req.UserSearchFilter
+	d.IDAttribute = req.IDAttribute
+	d.AttributeMappings = mappings
+	d.JITProvisioning = jit
+	d.LinkByLoginID = req.LinkByLoginID
+	d.GroupSyncEnabled = req.GroupSyncEnabled
+	d.GroupAttribute = req.GroupAttribute
+	d.Status = req.Status
+	return nil
+}
+
+// findTenant はパスの :tenant_id でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *LDAPDirectoryHandler) findTenant(c echo.Context) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// findDirectory はテナントのディレクトリを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *LDAPDirectoryHandler) findDirectory(c echo.Context) (*model.LDAPDirectory, error) {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return nil, err
+	}
+
+	directory, err := h.directoryStore.FindByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to find ldap directory: %v", err)
+		return nil, serverError(c)
+	}
+	if directory == nil {
+		return nil, notFound(c, "ldap directory not found")
+	}
+	return directory, nil
+}
+
+// validateCACertificate は PEM の CERTIFICATE ブロックを 1 つ以上含み、すべて X.509 証明書として読み込めるか検証する。
+func validateCACertificate(data string) error {
+	rest := []byte(data)
+	count := 0
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			return fmt.Errorf("ca_certificate must contain only PEM certificates")
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err != nil {
+			return fmt.Errorf("ca_certificate contains an invalid certificate")
+		}
+		count++
+	}
+	if count == 0 || strings.TrimSpace(string(rest)) != "" {
+		return fmt.Errorf("ca_certificate must be PEM encoded certificates")
+	}
+	return nil
+}
+
+// validateLDAPAttributeMappings はユーザー属性 → ディレクトリの属性名のマッピングを検証する。
+func validateLDAPAttributeMappings(mappings map[string]string) error {
+	attributes := map[string]bool{}
+	for _, name := range model.FederatedUserAttributes {
+		attributes[name] = true
+	}
+	for attr, name := range mappings {
+		if !attributes[attr] {
+			return fmt.Errorf("attribute_mappings refers to an unknown attribute: %s", attr)
+		}
+		if !ldapAttributeRegex.MatchString(name) {
+			return fmt.Errorf("attribute_mappings.%s must be an attribute name", attr)
+		}
+	}
+	return nil
+}
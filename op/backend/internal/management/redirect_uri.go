@@ -18,15 +18,17 @@ type redirectURIResponse struct {
 
 // RedirectURIHandler はリダイレクト URI 管理エンドポイントを処理する。
 type RedirectURIHandler struct {
-	redirectURIStore RedirectURIStore
-	clientStore      ClientStore
+	redirectURIStore      RedirectURIStore
+	clientStore           ClientStore
+	fetchSectorIdentifier FetchSectorIdentifierFunc
 }
 
 // NewRedirectURIHandler は RedirectURIHandler を生成する。
-func NewRedirectURIHandler(redirectURIStore RedirectURIStore, clientStore ClientStore) *RedirectURIHandler {
+func NewRedirectURIHandler(redirectURIStore RedirectURIStore, clientStore ClientStore, fetchSectorIdentifier FetchSectorIdentifierFunc) *RedirectURIHandler {
 	return &RedirectURIHandler{
-		redirectURIStore: redirectURIStore,
-		clientStore:      clientStore,
+		redirectURIStore:      redirectURIStore,
+		clientStore:           clientStore,
+		fetchSectorIdentifier: fetchSectorIdentifier,
 	}
 }
 
@@ -97,6 +99,19 @@ func (h *RedirectURIHandler) HandleCreate(c echo.Context) error {
 		return badRequest(c, err.Error())
 	}
 
+	// 追加後もセクターの条件を満たすこと (pairwise sub が変わらないようにする)
+	if client.IsPairwise() || client.SectorIdentifierURI != nil {
+		existing, err := h.redirectURIStore.ListByClientID(ctx, clientDBID)
+		if err != nil {
+			c.Logger().Errorf("failed to list redirect URIs: %v", err)
+			return serverError(c)
+		}
+		uris := append(redirectURIStrings(existing), req.URI)
+		if err := validateSubjectType(ctx, client, uris, h.fetchSectorIdentifier); err != nil {
+			return badRequest(c, err.Error())
+		}
+	}
+
 	uri := &model.RedirectURI{
 		ClientDBID: clientDBID,
 		URI:        req.URI,
@@ -0,0 +1,559 @@
+package management
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+var errInvalidRegistrationToken = errors.New("invalid registration access token")
+
+// RegistrationHandler は動的クライアント登録エンドポイントを処理する。
+// 仕様参照: RFC 7591 (登録), RFC 7592 (登録済みクライアントの参照・更新・削除)
+type RegistrationHandler struct {
+	tenantStore             TenantStore
+	clientStore             RegisteredClientStore
+	initialAccessTokenStore InitialAccessTokenStore
+	hashPassword            HashPasswordFunc
+	verifyPassword          PasswordVerifyFunc
+	encryptSecret           EncryptSecretFunc
+	hashToken               HashTokenFunc
+	fetchSectorIdentifier   FetchSectorIdentifierFunc
+	issuerBaseURL           string
+}
+
+// NewRegistrationHandler は RegistrationHandler を生成する。
+func NewRegistrationHandler(
+	tenantStore TenantStore,
+	clientStore RegisteredClientStore,
+	initialAccessTokenStore InitialAccessTokenStore,
+	hashPassword HashPasswordFunc,
+	verifyPassword PasswordVerifyFunc,
+	encryptSecret EncryptSecretFunc,
+	hashToken HashTokenFunc,
+	fetchSectorIdentifier FetchSectorIdentifierFunc,
+	issuerBaseURL string,
+) *RegistrationHandler {
+	return &RegistrationHandler{
+		tenantStore:             tenantStore,
+		clientStore:             clientStore,
+		initialAccessTokenStore: initialAccessTokenStore,
+		hashPassword:            hashPassword,
+		verifyPassword:          verifyPassword,
+		encryptSecret:           encryptSecret,
+		hashToken:               hashToken,
+		fetchSectorIdentifier:   fetchSectorIdentifier,
+		issuerBaseURL:           issuerBaseURL,
+	}
+}
+
+// registrationRequest は RFC 7591 Section 2 のクライアントメタデータ
+type registrationRequest struct {
+	// ClientID / ClientSecret は RFC 7592 の更新リクエストでのみ使用する
+	ClientID                string          `json:"client_id,omitempty"`
+	ClientSecret            string          `json:"client_secret,omitempty"`
+	ClientName              string          `json:"client_name,omitempty"`
+	RedirectURIs            []string        `json:"redirect_uris,omitempty"`
+	GrantTypes              []string        `json:"grant_types,omitempty"`
+	ResponseTypes           []string        `json:"response_types,omitempty"`
+	TokenEndpointAuthMethod string          `json:"token_endpoint_auth_method,omitempty"`
+	JWKS                    json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                 *string         `json:"jwks_uri,omitempty"`
+	PostLogoutRedirectURIs  []string        `json:"post_logout_redirect_uris,omitempty"`
+	FrontchannelLogoutURI   *string         `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutURI    *string         `json:"backchannel_logout_uri,omitempty"`
+	// Scope はクライアントが要求できるスコープ (スペース区切り)。省略時は制限しない (RFC 7591 Section 2)
+	Scope string `json:"scope,omitempty"`
+	tlsClientAuthMetadata
+	subjectTypeMetadata
+	responseProtectionMetadata
+	requestObjectMetadata
+	backchannelMetadata
+}
+
+// registrationResponse は RFC 7591 Section 3.2.1 / RFC 7592 Section 3 のクライアント情報レスポンス
+type registrationResponse struct {
+	ClientID                              string          `json:"client_id"`
+	ClientSecret                          string          `json:"client_secret,omitempty"`
+	ClientIDIssuedAt                      int64           `json:"client_id_issued_at"`
+	ClientSecretExpiresAt                 *int64          `json:"client_secret_expires_at,omitempty"`
+	RegistrationAccessToken               string          `json:"registration_access_token,omitempty"`
+	RegistrationClientURI                 string          `json:"registration_client_uri"`
+	ClientName                            string          `json:"client_name"`
+	RedirectURIs                          []string        `json:"redirect_uris"`
+	GrantTypes                            []string        `json:"grant_types"`
+	ResponseTypes                         []string        `json:"response_types"`
+	TokenEndpointAuthMethod               string          `json:"token_endpoint_auth_method"`
+	JWKS                                  json.RawMessage `json:"jwks,omitempty"`
+	JWKSURI                               *string         `json:"jwks_uri,omitempty"`
+	PostLogoutRedirectURIs                []string        `json:"post_logout_redirect_uris,omitempty"`
+	FrontchannelLogoutURI                 *string         `json:"frontchannel_logout_uri,omitempty"`
+	BackchannelLogoutURI                  *string         `json:"backchannel_logout_uri,omitempty"`
+	TLSClientAuthSubjectDN                *string         `json:"tls_client_auth_subject_dn,omitempty"`
+	TLSClientAuthSANDNS                   *string         `json:"tls_client_auth_san_dns,omitempty"`
+	TLSClientAuthSANURI                   *string         `json:"tls_client_auth_san_uri,omitempty"`
+	TLSClientAuthSANIP                    *string         `json:"tls_client_auth_san_ip,omitempty"`
+	TLSClientAuthSANEmail                 *string         `json:"tls_client_auth_san_email,omitempty"`
+	TLSClientCertificateBoundAccessTokens bool            `json:"tls_client_certificate_bound_access_tokens"`
+	SubjectType                           string          `json:"subject_type"`
+	SectorIdentifierURI                   *string         `json:"sector_identifier_uri,omitempty"`
+	UserInfoSignedResponseAlg             *string         `json:"userinfo_signed_response_alg,omitempty"`
+	IDTokenEncryptedResponseAlg           *string         `json:"id_token_encrypted_response_alg,omitempty"`
+	IDTokenEncryptedResponseEnc           *string         `json:"id_token_encrypted_response_enc,omitempty"`
+	UserInfoEncryptedResponseAlg          *string         `json:"userinfo_encrypted_response_alg,omitempty"`
+	UserInfoEncryptedResponseEnc          *string         `json:"userinfo_encrypted_response_enc,omitempty"`
+	RequestURIs                           []string        `json:"request_uris,omitempty"`
+	RequireSignedRequestObject            bool            `json:"require_signed_request_object"`
+	BackchannelTokenDeliveryMode          *string         `json:"backchannel_token_delivery_mode,omitempty"`
+	BackchannelClientNotificationEndpoint *string         `json:"backchannel_client_notification_endpoint,omitempty"`
+	Scope                                 string          `json:"scope,omitempty"`
+}
+
+// HandleRegister は POST /{tenant_code}/register を処理する
+// 仕様参照: RFC 7591 Section 3
+func (h *RegistrationHandler) HandleRegister(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.tenantStore.FindByCode(ctx, c.Param("tenant_code"))
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil || !tenant.RegistrationEnabled() {
+		return notFound(c, "registration endpoint not found")
+	}
+
+	// 初期アクセストークンによる登録制限 (RFC 7591 Section 3)
+	if tenant.RegistrationPolicy == model.RegistrationPolicyInitialAccessToken {
+		token := bearerToken(c)
+		if token == "" {
+			return invalidToken(c)
+		}
+		iat, err := h.initialAccessTokenStore.FindValidByHash(ctx, tenant.ID, h.hashToken(token))
+		if err != nil {
+			c.Logger().Errorf("failed to find initial access token: %v", err)
+			return serverError(c)
+		}
+		if iat == nil {
+			return invalidToken(c)
+		}
+	}
+
+	var req registrationRequest
+	if err := c.Bind(&req); err != nil {
+		return registrationError(c, "invalid_client_metadata", "invalid request body")
+	}
+
+	clientID, err := generateClientID()
+	if err != nil {
+		c.Logger().Errorf("failed to generate client_id: %v", err)
+		return serverError(c)
+	}
+
+	client := &model.Client{
+		TenantID:    tenant.ID,
+		ClientID:    clientID,
+		RequirePKCE: true,
+		Status:      "active",
+	}
+	if errCode, err := req.applyMetadata(ctx, client, nil, h.fetchSectorIdentifier); err != nil {
+		return registrationError(c, errCode, err.Error())
+	}
+
+	var clientSecret string
+	if client.UsesClientSecret() {
+		clientSecret, err = issueClientSecret(ctx, client, h.hashPassword, h.encryptSecret)
+		if err != nil {
+			c.Logger().Errorf("failed to issue client_secret: %v", err)
+			return serverError(c)
+		}
+	}
+
+	registrationAccessToken, err := generateAccessToken()
+	if err != nil {
+		c.Logger().Errorf("failed to generate registration access token: %v", err)
+		return serverError(c)
+	}
+	tokenHash := h.hashToken(registrationAccessToken)
+	client.RegistrationAccessTokenHash = &tokenHash
+
+	if err := h.clientStore.Create(ctx, client); err != nil {
+		c.Logger().Errorf("failed to create client: %v", err)
+		return serverError(c)
+	}
+
+	resp := h.toRegistrationResponse(tenant, client)
+	resp.ClientSecret = clientSecret
+	resp.RegistrationAccessToken = registrationAccessToken
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusCreated, resp)
+}
+
+// HandleRead は GET /{tenant_code}/register/:client_id を処理する
+// 仕様参照: RFC 7592 Section 2.1
+func (h *RegistrationHandler) HandleRead(c echo.Context) error {
+	tenant, client, err := h.authenticate(c)
+	if err != nil {
+		return registrationAuthError(c, err)
+	}
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, h.toRegistrationResponse(tenant, client))
+}
+
+// HandleUpdate は PUT /{tenant_code}/register/:client_id を処理する。
+// リクエストに含まれないメタデータは削除またはデフォルト値に戻す。
+// 仕様参照: RFC 7592 Section 2.2
+func (h *RegistrationHandler) HandleUpdate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, client, err := h.authenticate(c)
+	if err != nil {
+		return registrationAuthError(c, err)
+	}
+
+	var req registrationRequest
+	if err := c.Bind(&req); err != nil {
+		return registrationError(c, "invalid_client_metadata", "invalid request body")
+	}
+
+	// client_id は必須かつ一致すること (MUST: RFC 7592 Section 2.2)
+	if req.ClientID != client.ClientID {
+		return registrationError(c, "invalid_client_metadata", "client_id does not match")
+	}
+	// client_secret を含める場合は現在のシークレットと一致すること (MUST: RFC 7592 Section 2.2)
+	if req.ClientSecret != "" {
+		if client.ClientSecretHash == nil {
+			return registrationError(c, "invalid_client_metadata", "client_secret does not match")
+		}
+		ok, err := h.verifyPassword(ctx, req.ClientSecret, *client.ClientSecretHash)
+		if err != nil {
+			c.Logger().Errorf("failed to verify client_secret: %v", err)
+			return serverError(c)
+		}
+		if !ok {
+			return registrationError(c, "invalid_client_metadata", "client_secret does not match")
+		}
+	}
+
+	// 全メタデータを置き換えるため、登録時と同じ初期状態から組み立てる。
+	// 要求できるスコープは登録時または管理者が設定した範囲から広げられない。default_scopes は管理者だけが変更する
+	scopePolicy := client.AllowedScopes
+	client.RedirectURIs = nil
+	client.PostLogoutRedirectURIs = nil
+	client.JWKS = nil
+	client.JWKSURI = nil
+	client.FrontchannelLogoutURI = nil
+	client.BackchannelLogoutURI = nil
+	client.TLSClientAuthSubjectDN = nil
+	client.TLSClientAuthSANDNS = nil
+	client.TLSClientAuthSANURI = nil
+	client.TLSClientAuthSANIP = nil
+	client.TLSClientAuthSANEmail = nil
+	client.TLSClientCertificateBoundAccessTokens = false
+	client.SectorIdentifierURI = nil
+	client.UserInfoSignedResponseAlg = nil
+	client.IDTokenEncryptedResponseAlg = nil
+	client.IDTokenEncryptedResponseEnc = nil
+	client.UserInfoEncryptedResponseAlg = nil
+	client.UserInfoEncryptedResponseEnc = nil
+	client.RequestURIs = nil
+	client.RequireSignedRequestObject = false
+	client.BackchannelTokenDeliveryMode = nil
+	client.BackchannelClientNotificationEndpoint = nil
+	if errCode, err := req.applyMetadata(ctx, client, scopePolicy, h.fetchSectorIdentifier); err != nil {
+		return registrationError(c, errCode, err.Error())
+	}
+
+	var clientSecret string
+	switch {
+	case !client.UsesClientSecret():
+		client.ClientSecretHash = nil
+		client.ClientSecretEncrypted = nil
+	case client.ClientSecretHash == nil:
+		// シークレットを使う認証方式に変更された場合のみ新規発行する
+		var err error
+		clientSecret, err = issueClientSecret(ctx, client, h.hashPassword, h.encryptSecret)
+		if err != nil {
+			c.Logger().Errorf("failed to issue client_secret: %v", err)
+			return serverError(c)
+		}
+	}
+
+	if err := h.clientStore.UpdateWithRedirectURIs(ctx, client); err != nil {
+		c.Logger().Errorf("failed to update client: %v", err)
+		return serverError(c)
+	}
+
+	resp := h.toRegistrationResponse(tenant, client)
+	resp.ClientSecret = clientSecret
+
+	c.Response().Header().Set("Cache-Control", "no-store")
+	return c.JSON(http.StatusOK, resp)
+}
+
+// HandleDelete は DELETE /{tenant_code}/register/:client_id を処理する
+// 仕様参照: RFC 7592 Section 2.3
+func (h *RegistrationHandler) HandleDelete(c echo.Context) error {
+	_, client, err := h.authenticate(c)
+	if err != nil {
+		return registrationAuthError(c, err)
+	}
+
+	if err := h.clientStore.SoftDelete(c.Request().Context(), client.ID); err != nil {
+		c.Logger().Errorf("failed to delete client: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// authenticate は登録アクセストークンを検証し、対象のテナントとクライアントを返す。
+// クライアントが存在しない場合もトークン不正と区別せず errInvalidRegistrationToken を返す (MUST: RFC 7592 Section 3)
+func (h *RegistrationHandler) authenticate(c echo.Context) (*model.Tenant, *model.Client, error) {
+	ctx := c.Request().Context()
+
+	token := bearerToken(c)
+	if token == "" {
+		return nil, nil, errInvalidRegistrationToken
+	}
+
+	tenant, err := h.tenantStore.FindByCode(ctx, c.Param("tenant_code"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find tenant: %w", err)
+	}
+	if tenant == nil {
+		return nil, nil, errInvalidRegistrationToken
+	}
+
+	client, err := h.clientStore.FindByClientIDWithRedirectURIs(ctx, c.Param("client_id"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find client: %w", err)
+	}
+	if client == nil || client.TenantID != tenant.ID || client.Status != "active" || client.RegistrationAccessTokenHash == nil {
+		return nil, nil, errInvalidRegistrationToken
+	}
+	if subtle.ConstantTimeCompare([]byte(h.hashToken(token)), []byte(*client.RegistrationAccessTokenHash)) != 1 {
+		return nil, nil, errInvalidRegistrationToken
+	}
+
+	return tenant, client, nil
+}
+
+// applyMetadata はリクエストのメタデータをデフォルト値を補ってクライアントに設定し、検証する。
+// scope は scopePolicy (空の場合は制限なし) の範囲に絞る。
+// 不正な場合は RFC 7591 Section 3.2.2 のエラーコードとともにエラーを返す。
+func (req *registrationRequest) applyMetadata(ctx context.Context, client *model.Client, scopePolicy []string, fetchSectorIdentifier FetchSectorIdentifierFunc) (string, error) {
+	// 省略時のデフォルト値 (RFC 7591 Section 2)
+	if len(req.GrantTypes) == 0 {
+		req.GrantTypes = []string{"authorization_code"}
+	}
+	if len(req.ResponseTypes) == 0 {
+		req.ResponseTypes = []string{"code"}
+	}
+	if req.TokenEndpointAuthMethod == "" {
+		req.TokenEndpointAuthMethod = "client_secret_basic"
+	}
+	if req.ClientName == "" {
+		req.ClientName = client.ClientID
+	}
+	if req.SubjectType == nil {
+		subjectType := model.SubjectTypePublic
+		req.SubjectType = &subjectType
+	}
+
+	client.Name = req.ClientName
+	client.GrantTypes = model.StringSlice(req.GrantTypes)
+	client.ResponseTypes = model.StringSlice(req.ResponseTypes)
+	client.TokenEndpointAuthMethod = req.TokenEndpointAuthMethod
+	client.JWKS = rawJWKSToPtr(req.JWKS)
+	client.JWKSURI = req.JWKSURI
+	client.FrontchannelLogoutURI = req.FrontchannelLogoutURI
+	client.BackchannelLogoutURI = req.BackchannelLogoutURI
+	req.tlsClientAuthMetadata.applyTo(client)
+	req.subjectTypeMetadata.applyTo(client)
+	req.responseProtectionMetadata.applyTo(client)
+	req.requestObjectMetadata.applyTo(client)
+	req.backchannelMetadata.applyTo(client)
+	allowedScopes, err := restrictRegisteredScopes(strings.Fields(req.Scope), scopePolicy)
+	if err != nil {
+		return "invalid_client_metadata", err
+	}
+	client.AllowedScopes = model.StringSlice(allowedScopes)
+
+	// 認可コードフローではリダイレクト URI の事前登録が必須 (MUST: RFC 6749 Section 3.1.2.2)
+	if client.HasGrantType("authorization_code") && len(req.RedirectURIs) == 0 {
+		return "invalid_redirect_uri", fmt.Errorf("redirect_uris is required for authorization_code grant")
+	}
+	for _, uri := range append(append([]string{}, req.RedirectURIs...), req.PostLogoutRedirectURIs...) {
+		if err := validateRegisteredRedirectURI(uri); err != nil {
+			return "invalid_redirect_uri", err
+		}
+	}
+	if err := validateClientMetadata(client); err != nil {
+		return "invalid_client_metadata", err
+	}
+	if err := validateSubjectType(ctx, client, req.RedirectURIs, fetchSectorIdentifier); err != nil {
+		return "invalid_client_metadata", err
+	}
+
+	for _, uri := range req.RedirectURIs {
+		client.RedirectURIs = append(client.RedirectURIs, model.RedirectURI{URI: uri})
+	}
+	for _, uri := range req.PostLogoutRedirectURIs {
+		client.PostLogoutRedirectURIs = append(client.PostLogoutRedirectURIs, model.PostLogoutRedirectURI{URI: uri})
+	}
+	return "", nil
+}
+
+// validateRegisteredRedirectURI は動的クライアント登録のリダイレクト URI を検証する。
+// 管理者を介さずに登録されるため、スキームは https、ループバックアドレスの http、
+// ネイティブアプリのリバースドメイン名形式のプライベートスキーム (RFC 8252 Section 7) に限る。
+// javascript: などを登録させない (form_post の送信先や Location にそのまま使うため)
+func validateRegisteredRedirectURI(uri string) error {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("invalid URI: %s", uri)
+	}
+	if parsed.Fragment != "" {
+		return fmt.Errorf("redirect URI must not contain a fragment: %s", uri)
+	}
+	switch parsed.Scheme {
+	case "https":
+		if parsed.Host != "" {
+			return nil
+		}
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	default:
+		// プライベートスキームは "." を含むリバースドメイン名 (com.example.app:/callback)
+		if strings.Contains(parsed.Scheme, ".") && parsed.Opaque == "" {
+			return nil
+		}
+	}
+	return fmt.Errorf("redirect URI must use https, http on a loopback address or a private-use URI scheme: %s", uri)
+}
+
+// restrictRegisteredScopes は要求されたスコープを policy の範囲に絞る。
+// policy が空の場合は要求どおり、scope を省略した場合は policy のままにする。
+// 範囲内のスコープが 1 つもない場合は、制限なし (空) にならないようエラーにする
+func restrictRegisteredScopes(requested, policy []string) ([]string, error) {
+	if len(policy) == 0 {
+		return requested, nil
+	}
+	if len(requested) == 0 {
+		return policy, nil
+	}
+	allowed := map[string]bool{}
+	for _, scope := range policy {
+		allowed[scope] = true
+	}
+	var scopes []string
+	for _, scope := range requested {
+		if allowed[scope] {
+			scopes = append(scopes, scope)
+		}
+	}
+	if len(scopes) == 0 {
+		return nil, fmt.Errorf("none of the requested scopes are allowed for this client")
+	}
+	return scopes, nil
+}
+
+func (h *RegistrationHandler) toRegistrationResponse(tenant *model.Tenant, client *model.Client) registrationResponse {
+	var jwks json.RawMessage
+	if client.JWKS != nil {
+		jwks = json.RawMessage(*client.JWKS)
+	}
+	redirectURIs := make([]string, len(client.RedirectURIs))
+	for i, ru := range client.RedirectURIs {
+		redirectURIs[i] = ru.URI
+	}
+	var postLogoutURIs []string
+	for _, ru := range client.PostLogoutRedirectURIs {
+		postLogoutURIs = append(postLogoutURIs, ru.URI)
+	}
+
+	resp := registrationResponse{
+		ClientID:                              client.ClientID,
+		ClientIDIssuedAt:                      client.CreatedAt.Unix(),
+		RegistrationClientURI:                 h.issuerBaseURL + "/" + tenant.Code + "/register/" + client.ClientID,
+		ClientName:                            client.Name,
+		RedirectURIs:                          redirectURIs,
+		GrantTypes:                            []string(client.GrantTypes),
+		ResponseTypes:                         []string(client.ResponseTypes),
+		TokenEndpointAuthMethod:               client.TokenEndpointAuthMethod,
+		JWKS:                                  jwks,
+		JWKSURI:                               client.JWKSURI,
+		PostLogoutRedirectURIs:                postLogoutURIs,
+		FrontchannelLogoutURI:                 client.FrontchannelLogoutURI,
+		BackchannelLogoutURI:                  client.BackchannelLogoutURI,
+		TLSClientAuthSubjectDN:                client.TLSClientAuthSubjectDN,
+		TLSClientAuthSANDNS:                   client.TLSClientAuthSANDNS,
+		TLSClientAuthSANURI:                   client.TLSClientAuthSANURI,
+		TLSClientAuthSANIP:                    client.TLSClientAuthSANIP,
+		TLSClientAuthSANEmail:                 client.TLSClientAuthSANEmail,
+		TLSClientCertificateBoundAccessTokens: client.TLSClientCertificateBoundAccessTokens,
+		SubjectType:                           client.SubjectType,
+		SectorIdentifierURI:                   client.SectorIdentifierURI,
+		UserInfoSignedResponseAlg:             client.UserInfoSignedResponseAlg,
+		IDTokenEncryptedResponseAlg:           client.IDTokenEncryptedResponseAlg,
+		IDTokenEncryptedResponseEnc:           client.IDTokenEncryptedResponseEnc,
+		UserInfoEncryptedResponseAlg:          client.UserInfoEncryptedResponseAlg,
+		UserInfoEncryptedResponseEnc:          client.UserInfoEncryptedResponseEnc,
+		RequestURIs:                           client.RequestURIs,
+		RequireSignedRequestObject:            client.RequireSignedRequestObject,
+		BackchannelTokenDeliveryMode:          client.BackchannelTokenDeliveryMode,
+		BackchannelClientNotificationEndpoint: client.BackchannelClientNotificationEndpoint,
+		Scope:                                 strings.Join(client.AllowedScopes, " "),
+	}
+	// client_secret を発行したクライアントでは必須。0 は無期限 (RFC 7591 Section 3.2.1)
+	if client.UsesClientSecret() {
+		var never int64
+		resp.ClientSecretExpiresAt = &never
+	}
+	return resp
+}
+
+// registrationError は RFC 7591 Section 3.2.2 形式のエラーレスポンスを返す。
+func registrationError(c echo.Context, errCode, description string) error {
+	return errorJSON(c, http.StatusBadRequest, errCode, description)
+}
+
+// registrationAuthError は authenticate のエラーをレスポンスに変換する。
+func registrationAuthError(c echo.Context, err error) error {
+	if errors.Is(err, errInvalidRegistrationToken) {
+		return invalidToken(c)
+	}
+	c.Logger().Errorf("failed to authenticate registration access token: %v", err)
+	return serverError(c)
+}
+
+// invalidToken は初期アクセストークン / 登録アクセストークンが不正な場合の 401 を返す (RFC 6750 Section 3)
+func invalidToken(c echo.Context) error {
+	c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+	return errorJSON(c, http.StatusUnauthorized, "invalid_token", "")
+}
+
+// bearerToken は Authorization ヘッダから Bearer トークンを取り出す。ない場合は空文字。
+func bearerToken(c echo.Context) string {
+	authHeader := c.Request().Header.Get("Authorization")
+	if !strings.HasPrefix(authHeader, "Bearer ") {
+		return ""
+	}
+	return strings.TrimPrefix(authHeader, "Bearer ")
+}
@@ -0,0 +1,286 @@
+package management
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeRegisteredClientStore struct {
+	clients map[string]*model.Client
+}
+
+func (f *fakeRegisteredClientStore) FindByClientIDWithRedirectURIs(_ context.Context, clientID string) (*model.Client, error) {
+	c, ok := f.clients[clientID]
+	if !ok {
+		return nil, nil
+	}
+	copied := *c
+	return &copied, nil
+}
+func (f *fakeRegisteredClientStore) Create(_ context.Context, client *model.Client) error {
+	f.clients[client.ClientID] = client
+	return nil
+}
+func (f *fakeRegisteredClientStore) UpdateWithRedirectURIs(_ context.Context, client *model.Client) error {
+	f.clients[client.ClientID] = client
+	return nil
+}
+func (f *fakeRegisteredClientStore) SoftDelete(_ context.Context, id uuid.UUID) error {
+	for _, c := range f.clients {
+		if c.ID == id {
+			c.Status = "disabled"
+		}
+	}
+	return nil
+}
+
+type fakeInitialAccessTokenStore struct {
+	valid map[string]bool
+}
+
+func (f *fakeInitialAccessTokenStore) ListByTenantID(context.Context, uuid.UUID) ([]model.InitialAccessToken, error) {
+	return nil, nil
+}
+func (f *fakeInitialAccessTokenStore) Create(context.Context, *model.InitialAccessToken) error {
+	return nil
+}
+func (f *fakeInitialAccessTokenStore) FindValidByHash(_ context.Context, _ uuid.UUID, hash string) (*model.InitialAccessToken, error) {
+	if f.valid[hash] {
+		return &model.InitialAccessToken{}, nil
+	}
+	return nil, nil
+}
+func (f *fakeInitialAccessTokenStore) FindByID(context.Context, uuid.UUID) (*model.InitialAccessToken, error) {
+	return nil, nil
+}
+func (f *fakeInitialAccessTokenStore) Delete(context.Context, uuid.UUID) error { return nil }
+
+// テスト用のハッシュは接頭辞を付けた平文
+func fakeHashToken(token string) string { return "sha:" + token }
+
+type registrationFixture struct {
+	handler *RegistrationHandler
+	tenant  *model.Tenant
+	clients *fakeRegisteredClientStore
+}
+
+func newRegistrationFixture(policy string) *registrationFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo", RegistrationPolicy: policy}
+	clients := &fakeRegisteredClientStore{clients: map[string]*model.Client{}}
+	h := NewRegistrationHandler(
+		newFakeTenantStore(tenant),
+		clients,
+		&fakeInitialAccessTokenStore{valid: map[string]bool{"sha:iat": true}},
+		func(_ context.Context, p string) (string, error) { return "hash:" + p, nil },
+		func(_ context.Context, p, h string) (bool, error) { return h == "hash:"+p, nil },
+		func(p string) (string, error) { return "enc:" + p, nil },
+		fakeHashToken,
+		func(context.Context, string) ([]string, error) { return nil, nil },
+		"https://op.example.com",
+	)
+	return &registrationFixture{handler: h, tenant: tenant, clients: clients}
+}
+
+// addClient は登録アクセストークン "rat" で管理できるクライアントを追加する
+func (f *registrationFixture) addClient(allowed, defaults []string) *model.Client {
+	tokenHash := fakeHashToken("rat")
+	client := &model.Client{
+		ID:                          uuid.New(),
+		TenantID:                    f.tenant.ID,
+		ClientID:                    "dyn-client",
+		Name:                        "dyn",
+		GrantTypes:                  model.StringSlice{"authorization_code"},
+		ResponseTypes:               model.StringSlice{"code"},
+		TokenEndpointAuthMethod:     "none",
+		RequirePKCE:                 true,
+		SubjectType:                 model.SubjectTypePublic,
+		RedirectURIs:                []model.RedirectURI{{URI: "https://rp.example.com/cb"}},
+		RegistrationAccessTokenHash: &tokenHash,
+		AllowedScopes:               model.StringSlice(allowed),
+		DefaultScopes:               model.StringSlice(defaults),
+		Status:                      "active",
+	}
+	f.clients.clients[client.ClientID] = client
+	return client
+}
+
+func (f *registrationFixture) do(method, path, token string, body interface{}, handle func(*RegistrationHandler, echo.Context) error) (*httptest.ResponseRecorder, map[string]interface{}) {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(method, path, strings.NewReader(string(raw)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("tenant_code", "client_id")
+	c.SetParamValues("demo", "dyn-client")
+	if err := handle(f.handler, c); err != nil {
+		panic(err)
+	}
+	var resp map[string]interface{}
+	_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+	return rec, resp
+}
+
+func TestRegisterInitialAccessToken(t *testing.T) {
+	body := map[string]interface{}{"redirect_uris": []string{"https://rp.example.com/cb"}, "token_endpoint_auth_method": "none"}
+	register := (*RegistrationHandler).HandleRegister
+
+	f := newRegistrationFixture(model.RegistrationPolicyInitialAccessToken)
+	if rec, _ := f.do(http.MethodPost, "/demo/register", "", body, register); rec.Code != http.StatusUnauthorized {
+		t.Errorf("without token: status = %d, want 401", rec.Code)
+	}
+	if rec, _ := f.do(http.MethodPost, "/demo/register", "wrong", body, register); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+	rec, resp := f.do(http.MethodPost, "/demo/register", "iat", body, register)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("valid token: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if resp["registration_access_token"] == "" || !strings.HasPrefix(resp["registration_client_uri"].(string), "https://op.example.com/demo/register/") {
+		t.Errorf("unexpected response: %v", resp)
+	}
+
+	f = newRegistrationFixture(model.RegistrationPolicyDisabled)
+	if rec, _ := f.do(http.MethodPost, "/demo/register", "", body, register); rec.Code != http.StatusNotFound {
+		t.Errorf("disabled: status = %d, want 404", rec.Code)
+	}
+}
+
+func TestRegisterValidation(t *testing.T) {
+	register := (*RegistrationHandler).HandleRegister
+	tests := []struct {
+		name    string
+		body    map[string]interface{}
+		wantErr string
+	}{
+		{name: "authorization_code に redirect_uris がない", body: map[string]interface{}{}, wantErr: "invalid_redirect_uri"},
+		{name: "フラグメントを含む redirect_uri", body: map[string]interface{}{"redirect_uris": []string{"https://rp.example.com/cb#x"}}, wantErr: "invalid_redirect_uri"},
+		{name: "javascript スキーム", body: map[string]interface{}{"redirect_uris": []string{"javascript://x/%0aalert(1)"}}, wantErr: "invalid_redirect_uri"},
+		{name: "data スキーム", body: map[string]interface{}{"redirect_uris": []string{"data://x/text/html,<script>alert(1)</script>"}}, wantErr: "invalid_redirect_uri"},
+		{name: "vbscript スキーム", body: map[string]interface{}{"redirect_uris": []string{"vbscript://x/msgbox(1)"}}, wantErr: "invalid_redirect_uri"},
+		{name: "ループバック以外の http", body: map[string]interface{}{"redirect_uris": []string{"http://rp.example.com/cb"}}, wantErr: "invalid_redirect_uri"},
+		{name: "ホストのない https", body: map[string]interface{}{"redirect_uris": []string{"https:/cb"}}, wantErr: "invalid_redirect_uri"},
+		{name: "リバースドメイン名でないカスタムスキーム", body: map[string]interface{}{"redirect_uris": []string{"myapp://callback"}}, wantErr: "invalid_redirect_uri"},
+		{name: "javascript の post_logout_redirect_uri", body: map[string]interface{}{"redirect_uris": []string{"https://rp.example.com/cb"}, "post_logout_redirect_uris": []string{"javascript://x/%0aalert(1)"}}, wantErr: "invalid_redirect_uri"},
+		{name: "不正な scope", body: map[string]interface{}{"redirect_uris": []string{"https://rp.example.com/cb"}, "scope": "openid \"bad\""}, wantErr: "invalid_client_metadata"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRegistrationFixture(model.RegistrationPolicyOpen)
+			rec, resp := f.do(http.MethodPost, "/demo/register", "", tt.body, register)
+			if rec.Code != http.StatusBadRequest || resp["error"] != tt.wantErr {
+				t.Errorf("status = %d, body = %s, want error %s", rec.Code, rec.Body, tt.wantErr)
+			}
+		})
+	}
+
+	for _, uri := range []string{"http://localhost:8080/cb", "http://127.0.0.1:51004/cb", "http://[::1]/cb", "com.example.app:/oauth2redirect"} {
+		t.Run("許可する "+uri, func(t *testing.T) {
+			f := newRegistrationFixture(model.RegistrationPolicyOpen)
+			body := map[string]interface{}{"redirect_uris": []string{uri}, "token_endpoint_auth_method": "none"}
+			if rec, _ := f.do(http.MethodPost, "/demo/register", "", body, register); rec.Code != http.StatusCreated {
+				t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+		})
+	}
+
+	f := newRegistrationFixture(model.RegistrationPolicyOpen)
+	rec, resp := f.do(http.MethodPost, "/demo/register", "", map[string]interface{}{"redirect_uris": []string{"https://rp.example.com/cb"}}, register)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	// client_secret_basic が既定で、client_secret を発行する
+	if resp["token_endpoint_auth_method"] != "client_secret_basic" || resp["client_secret"] == nil {
+		t.Errorf("unexpected response: %v", resp)
+	}
+}
+
+func TestRegistrationUpdateScopePolicy(t *testing.T) {
+	update := (*RegistrationHandler).HandleUpdate
+	base := func(scope string) map[string]interface{} {
+		return map[string]interface{}{
+			"client_id":                  "dyn-client",
+			"redirect_uris":              []string{"https://rp.example.com/cb"},
+			"token_endpoint_auth_method": "none",
+			"scope":                      scope,
+		}
+	}
+
+	tests := []struct {
+		name         string
+		allowed      []string
+		defaults     []string
+		scope        string
+		wantAllowed  []string
+		wantDefaults []string
+		wantErr      bool
+	}{
+		{name: "範囲外のスコープは除く", allowed: []string{"openid", "profile"}, scope: "openid profile email admin", wantAllowed: []string{"openid", "profile"}},
+		{name: "範囲内に絞れる", allowed: []string{"openid", "profile", "email"}, scope: "openid email", wantAllowed: []string{"openid", "email"}},
+		{name: "scope の省略は範囲を変えない", allowed: []string{"openid", "profile"}, scope: "", wantAllowed: []string{"openid", "profile"}},
+		{name: "範囲内のスコープがない", allowed: []string{"openid"}, scope: "admin", wantErr: true},
+		{name: "制限のないクライアント", allowed: nil, scope: "openid email", wantAllowed: []string{"openid", "email"}},
+		{name: "default_scopes は変更しない", allowed: []string{"openid", "profile", "email"}, defaults: []string{"openid", "profile"}, scope: "openid profile", wantAllowed: []string{"openid", "profile"}, wantDefaults: []string{"openid", "profile"}},
+		{name: "default_scopes より狭くできない", allowed: []string{"openid", "profile"}, defaults: []string{"openid", "profile"}, scope: "openid", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newRegistrationFixture(model.RegistrationPolicyOpen)
+			f.addClient(tt.allowed, tt.defaults)
+			rec, _ := f.do(http.MethodPut, "/demo/register/dyn-client", "rat", base(tt.scope), update)
+			if tt.wantErr {
+				if rec.Code != http.StatusBadRequest {
+					t.Fatalf("status = %d, want 400", rec.Code)
+				}
+				if got := []string(f.clients.clients["dyn-client"].AllowedScopes); !reflect.DeepEqual(got, tt.allowed) {
+					t.Errorf("allowed scopes changed to %v", got)
+				}
+				return
+			}
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			saved := f.clients.clients["dyn-client"]
+			if got := []string(saved.AllowedScopes); !reflect.DeepEqual(got, tt.wantAllowed) {
+				t.Errorf("allowed scopes = %v, want %v", got, tt.wantAllowed)
+			}
+			if got := []string(saved.DefaultScopes); !reflect.DeepEqual(got, tt.wantDefaults) {
+				t.Errorf("default scopes = %v, want %v", got, tt.wantDefaults)
+			}
+		})
+	}
+}
+
+func TestRegistrationUpdateAuthentication(t *testing.T) {
+	update := (*RegistrationHandler).HandleUpdate
+	body := map[string]interface{}{"client_id": "dyn-client", "redirect_uris": []string{"https://rp.example.com/cb"}, "token_endpoint_auth_method": "none"}
+
+	f := newRegistrationFixture(model.RegistrationPolicyOpen)
+	f.addClient(nil, nil)
+	if rec, _ := f.do(http.MethodPut, "/demo/register/dyn-client", "wrong", body, update); rec.Code != http.StatusUnauthorized {
+		t.Errorf("wrong token: status = %d, want 401", rec.Code)
+	}
+	mismatch := map[string]interface{}{"client_id": "other", "redirect_uris": []string{"https://rp.example.com/cb"}}
+	if rec, _ := f.do(http.MethodPut, "/demo/register/dyn-client", "rat", mismatch, update); rec.Code != http.StatusBadRequest {
+		t.Errorf("client_id mismatch: status = %d, want 400", rec.Code)
+	}
+	if rec, _ := f.do(http.MethodDelete, "/demo/register/dyn-client", "rat", nil, (*RegistrationHandler).HandleDelete); rec.Code != http.StatusNoContent {
+		t.Fatalf("delete: status = %d", rec.Code)
+	}
+	// 削除したクライアントのトークンは使えない
+	if rec, _ := f.do(http.MethodGet, "/demo/register/dyn-client", "rat", nil, (*RegistrationHandler).HandleRead); rec.Code != http.StatusUnauthorized {
+		t.Errorf("read after delete: status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,366 @@
+package management
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// SAMLServiceProviderHandler はテナントの SAML IdP に登録する Service Provider の管理エンドポイントを処理する。
+type SAMLServiceProviderHandler struct {
+	spStore     SAMLServiceProviderStore
+	tenantStore TenantStore
+}
+
+// NewSAMLServiceProviderHandler は SAMLServiceProviderHandler を生成する。
+func NewSAMLServiceProviderHandler(spStore SAMLServiceProviderStore, tenantStore TenantStore) *SAMLServiceProviderHandler {
+	return &SAMLServiceProviderHandler{
+		spStore:     spStore,
+		tenantStore: tenantStore,
+	}
+}
+
+type createSAMLServiceProviderRequest struct {
+	EntityID               string                       `json:"entity_id"`
+	Name                   string                       `json:"name"`
+	ACSURL                 string                       `json:"acs_url"`
+	SLOURL                 string                       `json:"slo_url"`
+	NameIDFormat           string                       `json:"name_id_format"`
+	AttributeMappings      []model.SAMLAttributeMapping `json:"attribute_mappings"`
+	IdPInitiatedSSOEnabled bool                         `json:"idp_initiated_sso_enabled"`
+}
+
+// updateSAMLServiceProviderRequest は SP 側の設定と整合しなくなるため entity_id の変更を受け付けない。
+// slo_url に空文字を指定すると削除する (SLO に参加しない)。
+type updateSAMLServiceProviderRequest struct {
+	Name                   *string                      `json:"name,omitempty"`
+	ACSURL                 *string                      `json:"acs_url,omitempty"`
+	SLOURL                 *string                      `json:"slo_url,omitempty"`
+	NameIDFormat           *string                      `json:"name_id_format,omitempty"`
+	AttributeMappings      []model.SAMLAttributeMapping `json:"attribute_mappings,omitempty"`
+	IdPInitiatedSSOEnabled *bool                        `json:"idp_initiated_sso_enabled,omitempty"`
+	Status                 *string                      `json:"status,omitempty"`
+}
+
+type samlServiceProviderResponse struct {
+	ID                     string                       `json:"id"`
+	TenantID               string                       `json:"tenant_id"`
+	EntityID               string                       `json:"entity_id"`
+	Name                   string                       `json:"name"`
+	ACSURL                 string                       `json:"acs_url"`
+	SLOURL                 *string                      `json:"slo_url"`
+	NameIDFormat           string                       `json:"name_id_format"`
+	AttributeMappings      []model.SAMLAttributeMapping `json:"attribute_mappings"`
+	IdPInitiatedSSOEnabled bool                         `json:"idp_initiated_sso_enabled"`
+	Status                 string                       `json:"status"`
+	CreatedAt              string                       `json:"created_at"`
+	UpdatedAt              string                       `json:"updated_at"`
+}
+
+func toSAMLServiceProviderResponse(sp *model.SAMLServiceProvider) samlServiceProviderResponse {
+	mappings := []model.SAMLAttributeMapping(sp.AttributeMappings)
+	if mappings == nil {
+		mappings = []model.SAMLAttributeMapping{}
+	}
+	return samlServiceProviderResponse{
+		ID:                     sp.ID.String(),
+		TenantID:               sp.TenantID.String(),
+		EntityID:               sp.EntityID,
+		Name:                   sp.Name,
+		ACSURL:                 sp.ACSURL,
+		SLOURL:                 sp.SLOURL,
+		NameIDFormat:           sp.NameIDFormat,
+		AttributeMappings:      mappings,
+		IdPInitiatedSSOEnabled: sp.IdPInitiatedSSOEnabled,
+		Status:                 sp.Status,
+		CreatedAt:              sp.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:              sp.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/saml-service-providers を処理する。
+func (h *SAMLServiceProviderHandler) HandleList(c echo.Context) error {
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	sps, err := h.spStore.ListByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to list saml service providers: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]samlServiceProviderResponse, len(sps))
+	for i, sp := range sps {
+		data[i] = toSAMLServiceProviderResponse(&sp)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/saml-service-providers を処理する。
+func (h *SAMLServiceProviderHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req createSAMLServiceProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.EntityID == "" || len(req.EntityID) > 1024 {
+		return badRequest(c, "entity_id is required and must be at most 1024 characters")
+	}
+	if req.Name == "" || len(req.Name) > 255 {
+		return badRequest(c, "name is required and must be at most 255 characters")
+	}
+	if err := validateSAMLEndpointURL("acs_url", req.ACSURL); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if req.SLOURL != "" {
+		if err := validateSAMLEndpointURL("slo_url", req.SLOURL); err != nil {
+			return badRequest(c, err.Error())
+		}
+	}
+	if req.NameIDFormat == "" {
+		req.NameIDFormat = model.SAMLNameIDFormatEmailAddress
+	}
+	if err := validateSAMLNameIDFormat(req.NameIDFormat); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := validateSAMLAttributeMappings(tenant, req.AttributeMappings); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	// 重複チェック
+	existing, err := h.spStore.FindByEntityID(ctx, tenant.ID, req.EntityID)
+	if err != nil {
+		c.Logger().Errorf("failed to check saml service provider: %v", err)
+		return serverError(c)
+	}
+	if existing != nil {
+		return conflict(c, "saml service provider already exists")
+	}
+
+	sp := &model.SAMLServiceProvider{
+		TenantID:               tenant.ID,
+		EntityID:               req.EntityID,
+		Name:                   req.Name,
+		ACSURL:                 req.ACSURL,
+		NameIDFormat:           req.NameIDFormat,
+		AttributeMappings:      model.SAMLAttributeMappings(req.AttributeMappings),
+		IdPInitiatedSSOEnabled: req.IdPInitiatedSSOEnabled,
+		Status:                 "active",
+	}
+	if req.SLOURL != "" {
+		sp.SLOURL = &req.SLOURL
+	}
+	if err := h.spStore.Create(ctx, sp); err != nil {
+		c.Logger().Errorf("failed to create saml service provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, toSAMLServiceProviderResponse(sp))
+}
+
+// HandleGet は GET /management/v1/saml-service-providers/:id を処理する。
+func (h *SAMLServiceProviderHandler) HandleGet(c echo.Context) error {
+	sp, err := h.findServiceProvider(c)
+	if err != nil || sp == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toSAMLServiceProviderResponse(sp))
+}
+
+// HandleUpdate は PUT /management/v1/saml-service-providers/:id を処理する。
+// name_id_format・attribute_mappings の変更は以後に発行するアサーションに反映される。
+func (h *SAMLServiceProviderHandler) HandleUpdate(c echo.Context) error {
+	sp, err := h.findServiceProvider(c)
+	if err != nil || sp == nil {
+		return err
+	}
+
+	var req updateSAMLServiceProviderRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Name != nil {
+		if *req.Name == "" || len(*req.Name) > 255 {
+			return badRequest(c, "name must be 1-255 characters")
+		}
+		sp.Name = *req.Name
+	}
+	if req.ACSURL != nil {
+		if err := validateSAMLEndpointURL("acs_url", *req.ACSURL); err != nil {
+			return badRequest(c, err.Error())
+		}
+		sp.ACSURL = *req.ACSURL
+	}
+	if req.SLOURL != nil {
+		if *req.SLOURL == "" {
+			sp.SLOURL = nil
+		} else {
+			if err := validateSAMLEndpointURL("slo_url", *req.SLOURL); err != nil {
+				return badRequest(c, err.Error())
+			}
+			sp.SLOURL = req.SLOURL
+		}
+	}
+	if req.NameIDFormat != nil {
+		if err := validateSAMLNameIDFormat(*req.NameIDFormat); err != nil {
+			return badRequest(c, err.Error())
+		}
+		sp.NameIDFormat = *req.NameIDFormat
+	}
+	if req.AttributeMappings != nil {
+		tenant, err := h.findTenant(c, sp.TenantID.String())
+		if err != nil || tenant == nil {
+			return err
+		}
+		if err := validateSAMLAttributeMappings(tenant, req.AttributeMappings); err != nil {
+			return badRequest(c, err.Error())
+		}
+		sp.AttributeMappings = model.SAMLAttributeMappings(req.AttributeMappings)
+	}
+	if req.IdPInitiatedSSOEnabled != nil {
+		sp.IdPInitiatedSSOEnabled = *req.IdPInitiatedSSOEnabled
+	}
+	if req.Status != nil {
+		if *req.Status != "active" && *req.Status != "disabled" {
+			return badRequest(c, "status must be active or disabled")
+		}
+		sp.Status = *req.Status
+	}
+
+	if err := h.spStore.Update(c.Request().Context(), sp); err != nil {
+		c.Logger().Errorf("failed to update saml service provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toSAMLServiceProviderResponse(sp))
+}
+
+// HandleDelete は DELETE /management/v1/saml-service-providers/:id を処理する。
+// OP のセッションは失効させない。SP 側のセッションは SP の設定で終了させる。
+func (h *SAMLServiceProviderHandler) HandleDelete(c echo.Context) error {
+	sp, err := h.findServiceProvider(c)
+	if err != nil || sp == nil {
+		return err
+	}
+
+	if err := h.spStore.Delete(c.Request().Context(), sp.ID); err != nil {
+		c.Logger().Errorf("failed to delete saml service provider: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findTenant は ID でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *SAMLServiceProviderHandler) findTenant(c echo.Context, rawID string) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// findServiceProvider はパスの :id で SP を検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *SAMLServiceProviderHandler) findServiceProvider(c echo.Context) (*model.SAMLServiceProvider, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid saml service provider id format")
+	}
+
+	sp, err := h.spStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find saml service provider: %v", err)
+		return nil, serverError(c)
+	}
+	if sp == nil {
+		return nil, notFound(c, "saml service provider not found")
+	}
+	return sp, nil
+}
+
+// validateSAMLEndpointURL は SP のエンドポイントの URL を検証する。フラグメントを含まない https の URL に限る。
+// 開発用にループバックアドレスのみ http を許可する。
+func validateSAMLEndpointURL(field, raw string) error {
+	parsed, err := url.Parse(raw)
+	if err != nil || parsed.Host == "" || parsed.Fragment != "" || len(raw) > 2048 {
+		return fmt.Errorf("%s must be an absolute URL without fragment", field)
+	}
+	switch parsed.Scheme {
+	case "https":
+		return nil
+	case "http":
+		host := parsed.Hostname()
+		if ip := net.ParseIP(host); host == "localhost" || (ip != nil && ip.IsLoopback()) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s must use https", field)
+}
+
+func validateSAMLNameIDFormat(format string) error {
+	for _, f := range model.SAMLNameIDFormats {
+		if f == format {
+			return nil
+		}
+	}
+	return fmt.Errorf("unsupported name_id_format: %s", format)
+}
+
+// validateSAMLAttributeMappings は属性マッピングを検証する。
+// attribute は login_id・標準クレーム名・テナントのスキーマに定義されたカスタム属性名に限る。
+func validateSAMLAttributeMappings(tenant *model.Tenant, mappings []model.SAMLAttributeMapping) error {
+	attributes := map[string]bool{model.FederatedLoginIDAttribute: true}
+	for _, name := range model.StandardUserClaims {
+		attributes[name] = true
+	}
+	for _, name := range tenant.UserAttributeNames() {
+		attributes[name] = true
+	}
+
+	seen := map[string]bool{}
+	for _, m := range mappings {
+		if m.Name == "" || len(m.Name) > 255 {
+			return fmt.Errorf("attribute_mappings.name must be 1-255 characters")
+		}
+		if seen[m.Name] {
+			return fmt.Errorf("duplicate attribute name: %s", m.Name)
+		}
+		seen[m.Name] = true
+		if !attributes[m.Attribute] {
+			return fmt.Errorf("attribute_mappings refers to an unknown attribute: %s", m.Attribute)
+		}
+		switch m.NameFormat {
+		case "", "basic", "uri", "unspecified":
+		default:
+			return fmt.Errorf("attribute_mappings.name_format must be basic, uri or unspecified")
+		}
+	}
+	return nil
+}
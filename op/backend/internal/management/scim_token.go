@@ -0,0 +1,184 @@
+package management
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// SCIMTokenHandler は SCIM のプロビジョニング API 用の Bearer トークン管理エンドポイントを処理する。
+type SCIMTokenHandler struct {
+	tokenStore  SCIMTokenStore
+	tenantStore TenantStore
+	hashToken   HashTokenFunc
+}
+
+// NewSCIMTokenHandler は SCIMTokenHandler を生成する。
+func NewSCIMTokenHandler(tokenStore SCIMTokenStore, tenantStore TenantStore, hashToken HashTokenFunc) *SCIMTokenHandler {
+	return &SCIMTokenHandler{
+		tokenStore:  tokenStore,
+		tenantStore: tenantStore,
+		hashToken:   hashToken,
+	}
+}
+
+type createSCIMTokenRequest struct {
+	Description *string `json:"description,omitempty"`
+	// ExpiresIn は有効期間 (秒)。省略時は無期限
+	ExpiresIn *int `json:"expires_in,omitempty"`
+}
+
+type scimTokenResponse struct {
+	ID          string  `json:"id"`
+	TenantID    string  `json:"tenant_id"`
+	Description *string `json:"description,omitempty"`
+	ExpiresAt   *string `json:"expires_at,omitempty"`
+	LastUsedAt  *string `json:"last_used_at,omitempty"`
+	CreatedAt   string  `json:"created_at"`
+}
+
+type scimTokenCreateResponse struct {
+	scimTokenResponse
+	Token string `json:"token"`
+}
+
+func toSCIMTokenResponse(t *model.SCIMToken) scimTokenResponse {
+	resp := scimTokenResponse{
+		ID:          t.ID.String(),
+		TenantID:    t.TenantID.String(),
+		Description: t.Description,
+		CreatedAt:   t.CreatedAt.Format(time.RFC3339),
+	}
+	if t.ExpiresAt != nil {
+		s := t.ExpiresAt.Format(time.RFC3339)
+		resp.ExpiresAt = &s
+	}
+	if t.LastUsedAt != nil {
+		s := t.LastUsedAt.Format(time.RFC3339)
+		resp.LastUsedAt = &s
+	}
+	return resp
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/scim-tokens を処理する。
+func (h *SCIMTokenHandler) HandleList(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	tokens, err := h.tokenStore.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to list scim tokens: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]scimTokenResponse, len(tokens))
+	for i, t := range tokens {
+		data[i] = toSCIMTokenResponse(&t)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/scim-tokens を処理する。
+// トークンの平文はこのレスポンスでのみ返す。
+func (h *SCIMTokenHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	var req createSCIMTokenRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+	if req.Description != nil && len(*req.Description) > 255 {
+		return badRequest(c, "description must be at most 255 characters")
+	}
+	if req.ExpiresIn != nil && *req.ExpiresIn <= 0 {
+		return badRequest(c, "expires_in must be positive")
+	}
+
+	plaintext, err := generateAccessToken()
+	if err != nil {
+		c.Logger().Errorf("failed to generate scim token: %v", err)
+		return serverError(c)
+	}
+
+	token := &model.SCIMToken{
+		TenantID:    tenantID,
+		TokenHash:   h.hashToken(plaintext),
+		Description: req.Description,
+	}
+	if req.ExpiresIn != nil {
+		expiresAt := time.Now().Add(time.Duration(*req.ExpiresIn) * time.Second)
+		token.ExpiresAt = &expiresAt
+	}
+
+	if err := h.tokenStore.Create(ctx, token); err != nil {
+		c.Logger().Errorf("failed to create scim token: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, scimTokenCreateResponse{
+		scimTokenResponse: toSCIMTokenResponse(token),
+		Token:             plaintext,
+	})
+}
+
+// HandleDelete は DELETE /management/v1/tenants/:tenant_id/scim-tokens/:token_id を処理する。
+func (h *SCIMTokenHandler) HandleDelete(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+	tokenID, err := uuid.Parse(c.Param("token_id"))
+	if err != nil {
+		return badRequest(c, "invalid token_id format")
+	}
+
+	token, err := h.tokenStore.FindByID(ctx, tokenID)
+	if err != nil {
+		c.Logger().Errorf("failed to find scim token: %v", err)
+		return serverError(c)
+	}
+	if token == nil || token.TenantID != tenantID {
+		return notFound(c, "scim token not found")
+	}
+
+	if err := h.tokenStore.Delete(ctx, tokenID); err != nil {
+		c.Logger().Errorf("failed to delete scim token: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
@@ -0,0 +1,276 @@
+package management
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// scopeTokenRegex は scope-token に使える文字列 (RFC 6749 Section 3.3)
+var scopeTokenRegex = regexp.MustCompile(`^[\x21\x23-\x5B\x5D-\x7E]{1,255}$`)
+
+// ScopeHandler はテナントのカスタムスコープ定義の管理エンドポイントを処理する。
+type ScopeHandler struct {
+	scopeStore  ScopeDefinitionStore
+	tenantStore TenantStore
+}
+
+// NewScopeHandler は ScopeHandler を生成する。
+func NewScopeHandler(scopeStore ScopeDefinitionStore, tenantStore TenantStore) *ScopeHandler {
+	return &ScopeHandler{
+		scopeStore:  scopeStore,
+		tenantStore: tenantStore,
+	}
+}
+
+type createScopeRequest struct {
+	Name            string                    `json:"name"`
+	Description     string                    `json:"description"`
+	ConsentRequired bool                      `json:"consent_required"`
+	Claims          []model.ScopeClaimMapping `json:"claims"`
+}
+
+// updateScopeRequest はクライアントの allowed_scopes や同意の記録と整合しなくなるため name の変更を受け付けない。
+type updateScopeRequest struct {
+	Description     *string                   `json:"description,omitempty"`
+	ConsentRequired *bool                     `json:"consent_required,omitempty"`
+	Claims          []model.ScopeClaimMapping `json:"claims,omitempty"`
+}
+
+type scopeResponse struct {
+	ID              string                    `json:"id"`
+	TenantID        string                    `json:"tenant_id"`
+	Name            string                    `json:"name"`
+	Description     string                    `json:"description"`
+	ConsentRequired bool                      `json:"consent_required"`
+	Claims          []model.ScopeClaimMapping `json:"claims"`
+	CreatedAt       string                    `json:"created_at"`
+	UpdatedAt       string                    `json:"updated_at"`
+}
+
+func toScopeResponse(d *model.ScopeDefinition) scopeResponse {
+	claims := []model.ScopeClaimMapping(d.Claims)
+	if claims == nil {
+		claims = []model.ScopeClaimMapping{}
+	}
+	return scopeResponse{
+		ID:              d.ID.String(),
+		TenantID:        d.TenantID.String(),
+		Name:            d.Name,
+		Description:     d.Description,
+		ConsentRequired: d.ConsentRequired,
+		Claims:          claims,
+		CreatedAt:       d.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:       d.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleList は GET /management/v1/tenants/:tenant_id/scopes を処理する。
+func (h *ScopeHandler) HandleList(c echo.Context) error {
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	defs, err := h.scopeStore.ListByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to list scope definitions: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]scopeResponse, len(defs))
+	for i, d := range defs {
+		data[i] = toScopeResponse(&d)
+	}
+
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleCreate は POST /management/v1/tenants/:tenant_id/scopes を処理する。
+func (h *ScopeHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.findTenant(c, c.Param("tenant_id"))
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req createScopeRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if !scopeTokenRegex.MatchString(req.Name) {
+		return badRequest(c, "name must be a valid scope token of at most 255 characters")
+	}
+	if model.IsStandardScope(req.Name) {
+		return badRequest(c, "name conflicts with a standard scope")
+	}
+	if err := validateScopeClaims(tenant, req.Claims); err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	// 重複チェック
+	existing, err := h.scopeStore.FindByName(ctx, tenant.ID, req.Name)
+	if err != nil {
+		c.Logger().Errorf("failed to check scope definition: %v", err)
+		return serverError(c)
+	}
+	if existing != nil {
+		return conflict(c, "scope already exists")
+	}
+
+	def := &model.ScopeDefinition{
+		TenantID:        tenant.ID,
+		Name:            req.Name,
+		Description:     req.Description,
+		ConsentRequired: req.ConsentRequired,
+		Claims:          model.ScopeClaimMappings(req.Claims),
+	}
+	if err := h.scopeStore.Create(ctx, def); err != nil {
+		c.Logger().Errorf("failed to create scope definition: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusCreated, toScopeResponse(def))
+}
+
+// HandleGet は GET /management/v1/scopes/:id を処理する。
+func (h *ScopeHandler) HandleGet(c echo.Context) error {
+	def, err := h.findScope(c)
+	if err != nil || def == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toScopeResponse(def))
+}
+
+// HandleUpdate は PUT /management/v1/scopes/:id を処理する。
+// 発行済みのトークンは変更しない。クレームの変更は以後の ID トークン・UserInfo に反映される。
+func (h *ScopeHandler) HandleUpdate(c echo.Context) error {
+	def, err := h.findScope(c)
+	if err != nil || def == nil {
+		return err
+	}
+
+	var req updateScopeRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if req.Description != nil {
+		def.Description = *req.Description
+	}
+	if req.ConsentRequired != nil {
+		def.ConsentRequired = *req.ConsentRequired
+	}
+	if req.Claims != nil {
+		tenant, err := h.findTenant(c, def.TenantID.String())
+		if err != nil || tenant == nil {
+			return err
+		}
+		if err := validateScopeClaims(tenant, req.Claims); err != nil {
+			return badRequest(c, err.Error())
+		}
+		def.Claims = model.ScopeClaimMappings(req.Claims)
+	}
+
+	if err := h.scopeStore.Update(c.Request().Context(), def); err != nil {
+		c.Logger().Errorf("failed to update scope definition: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toScopeResponse(def))
+}
+
+// HandleDelete は DELETE /management/v1/scopes/:id を処理する。
+// 発行済みのトークンは失効させない。以後このスコープを含む認可リクエストは invalid_scope になる。
+func (h *ScopeHandler) HandleDelete(c echo.Context) error {
+	def, err := h.findScope(c)
+	if err != nil || def == nil {
+		return err
+	}
+
+	if err := h.scopeStore.Delete(c.Request().Context(), def.ID); err != nil {
+		c.Logger().Errorf("failed to delete scope definition: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// findTenant は ID でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *ScopeHandler) findTenant(c echo.Context, rawID string) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// findScope はパスの :id でスコープ定義を検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *ScopeHandler) findScope(c echo.Context) (*model.ScopeDefinition, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid scope id format")
+	}
+
+	def, err := h.scopeStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find scope definition: %v", err)
+		return nil, serverError(c)
+	}
+	if def == nil {
+		return nil, notFound(c, "scope not found")
+	}
+	return def, nil
+}
+
+// validateScopeClaims はクレームの定義を検証する。
+// クレーム名は標準クレームや OP が設定するクレームと衝突してはならない。
+// attribute は標準クレームかテナントで定義されたカスタム属性に限る。
+func validateScopeClaims(tenant *model.Tenant, claims []model.ScopeClaimMapping) error {
+	attributes := map[string]bool{}
+	for _, name := range model.StandardUserClaims {
+		attributes[name] = true
+	}
+	for _, name := range tenant.UserAttributeNames() {
+		attributes[name] = true
+	}
+
+	seen := map[string]bool{}
+	for _, m := range claims {
+		if !userAttributeNameRegex.MatchString(m.Claim) {
+			return fmt.Errorf("invalid claim name: %q", m.Claim)
+		}
+		if model.IsReservedClaimName(m.Claim) {
+			return fmt.Errorf("claim name %q conflicts with a reserved claim", m.Claim)
+		}
+		if seen[m.Claim] {
+			return fmt.Errorf("duplicate claim: %s", m.Claim)
+		}
+		seen[m.Claim] = true
+
+		if (m.Attribute == "") == (m.Value == nil) {
+			return fmt.Errorf("claim %s must have exactly one of attribute or value", m.Claim)
+		}
+		if m.Attribute != "" && !attributes[m.Attribute] {
+			return fmt.Errorf("claim %s refers to an unknown attribute: %s", m.Claim, m.Attribute)
+		}
+	}
+	return nil
+}
@@ -23,3 +23,22 @@ func generateClientSecret() (string, error) {
 	}
 	return hex.EncodeToString(b), nil
 }
+
+// generateAccessToken generates a random bearer token string (64 hex chars = 32 bytes)
+// used for registration access tokens, initial access tokens and SCIM tokens.
+func generateAccessToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate access token: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generatePairwiseSalt generates a random per-tenant salt for pairwise subject identifiers (64 hex chars = 32 bytes).
+func generatePairwiseSalt() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("failed to generate pairwise salt: %w", err)
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,154 @@
+package management
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// subjectTypeMetadata は sub の種別 (OIDC Core 1.0 Section 8) に関するクライアントメタデータ。作成・更新リクエストで共通。
+type subjectTypeMetadata struct {
+	SubjectType         *string `json:"subject_type,omitempty"`
+	SectorIdentifierURI *string `json:"sector_identifier_uri,omitempty"`
+}
+
+// applyTo は指定されたフィールドのみクライアントに反映する。sector_identifier_uri の空文字はクリアとして扱う。
+func (m *subjectTypeMetadata) applyTo(client *model.Client) {
+	if m.SubjectType != nil {
+		client.SubjectType = *m.SubjectType
+	}
+	if m.SectorIdentifierURI != nil {
+		if *m.SectorIdentifierURI == "" {
+			client.SectorIdentifierURI = nil
+		} else {
+			v := *m.SectorIdentifierURI
+			client.SectorIdentifierURI = &v
+		}
+	}
+}
+
+// specified はリクエストに sub の種別に関するフィールドが含まれるか判定する。
+func (m *subjectTypeMetadata) specified() bool {
+	return m.SubjectType != nil || m.SectorIdentifierURI != nil
+}
+
+// validateSubjectType は sub の種別とセクターの設定を検証する。
+// sector_identifier_uri は https で、取得した文書に全てのリダイレクト URI が含まれること (MUST: OIDC Registration 1.0 Section 5)。
+// sector_identifier_uri が無い pairwise クライアントはリダイレクト URI のホストが1つに定まること (OIDC Core 1.0 Section 8.1)。
+func validateSubjectType(ctx context.Context, client *model.Client, redirectURIs []string, fetch FetchSectorIdentifierFunc) error {
+	switch client.SubjectType {
+	case model.SubjectTypePublic, model.SubjectTypePairwise:
+	default:
+		return fmt.Errorf("unsupported subject_type: %s", client.SubjectType)
+	}
+
+	if client.SectorIdentifierURI != nil {
+		parsed, err := url.Parse(*client.SectorIdentifierURI)
+		if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+			return fmt.Errorf("sector_identifier_uri must be an https URL")
+		}
+		listed, err := fetch(ctx, *client.SectorIdentifierURI)
+		if err != nil {
+			return err
+		}
+		for _, uri := range redirectURIs {
+			if !containsString(listed, uri) {
+				return fmt.Errorf("redirect_uri %s is not listed in sector_identifier_uri", uri)
+			}
+		}
+		return nil
+	}
+
+	if !client.IsPairwise() {
+		return nil
+	}
+	if len(redirectURIs) == 0 {
+		return fmt.Errorf("sector_identifier_uri is required for pairwise clients without redirect_uris")
+	}
+	host := redirectHost(redirectURIs[0])
+	for _, uri := range redirectURIs[1:] {
+		if redirectHost(uri) != host {
+			return fmt.Errorf("sector_identifier_uri is required when redirect_uris have multiple hosts")
+		}
+	}
+	return nil
+}
+
+func redirectHost(uri string) string {
+	parsed, err := url.Parse(uri)
+	if err != nil {
+		return ""
+	}
+	return parsed.Hostname()
+}
+
+func containsString(values []string, v string) bool {
+	for _, s := range values {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+// SubjectHandler は発行済みの sub からユーザーを逆引きする管理エンドポイントを処理する。
+type SubjectHandler struct {
+	tenantStore     TenantStore
+	subjectResolver SubjectResolver
+}
+
+// NewSubjectHandler は SubjectHandler を生成する。
+func NewSubjectHandler(tenantStore TenantStore, subjectResolver SubjectResolver) *SubjectHandler {
+	return &SubjectHandler{
+		tenantStore:     tenantStore,
+		subjectResolver: subjectResolver,
+	}
+}
+
+type subjectResponse struct {
+	TenantID string `json:"tenant_id"`
+	Subject  string `json:"subject"`
+	UserID   string `json:"user_id"`
+}
+
+// HandleGet は GET /management/v1/tenants/:tenant_id/subjects/:subject を処理する。
+// public sub はユーザー ID そのものとして扱う。
+func (h *SubjectHandler) HandleGet(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(ctx, tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return serverError(c)
+	}
+	if tenant == nil {
+		return notFound(c, "tenant not found")
+	}
+
+	subject := c.Param("subject")
+	userID, err := h.subjectResolver.ResolveSubject(ctx, tenantID, subject)
+	if err != nil {
+		c.Logger().Errorf("failed to resolve subject: %v", err)
+		return serverError(c)
+	}
+	if userID == nil {
+		return notFound(c, "subject not found")
+	}
+
+	return c.JSON(http.StatusOK, subjectResponse{
+		TenantID: tenantID.String(),
+		Subject:  subject,
+		UserID:   userID.String(),
+	})
+}
@@ -14,6 +14,18 @@ import (
 
 var tenantCodeRegex = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{1,61}[a-z0-9]$`)
 
+var validRegistrationPolicies = map[string]bool{
+	model.RegistrationPolicyDisabled:           true,
+	model.RegistrationPolicyInitialAccessToken: true,
+	model.RegistrationPolicyOpen:               true,
+}
+
+var validEmailVerificationPolicies = map[string]bool{
+	model.EmailVerificationPolicyOptional:      true,
+	model.EmailVerificationPolicyLogin:         true,
+	model.EmailVerificationPolicyAuthorization: true,
+}
+
 // TenantHandler はテナント管理の CRUD エンドポイントを処理する。
 type TenantHandler struct {
 	tenantStore TenantStore
@@ -25,49 +37,57 @@ func NewTenantHandler(store TenantStore) *TenantHandler {
 }
 
 type createTenantRequest struct {
-	Code                 string `json:"code"`
-	Name                 string `json:"name"`
-	SessionLifetime      *int   `json:"session_lifetime,omitempty"`
-	AuthCodeLifetime     *int   `json:"auth_code_lifetime,omitempty"`
-	AccessTokenLifetime  *int   `json:"access_token_lifetime,omitempty"`
-	RefreshTokenLifetime *int   `json:"refresh_token_lifetime,omitempty"`
-	IDTokenLifetime      *int   `json:"id_token_lifetime,omitempty"`
+	Code                    string `json:"code"`
+	Name                    string `json:"name"`
+	SessionLifetime         *int   `json:"session_lifetime,omitempty"`
+	AuthCodeLifetime        *int   `json:"auth_code_lifetime,omitempty"`
+	AccessTokenLifetime     *int   `json:"access_token_lifetime,omitempty"`
+	RefreshTokenLifetime    *int   `json:"refresh_token_lifetime,omitempty"`
+	IDTokenLifetime         *int   `json:"id_token_lifetime,omitempty"`
+	RegistrationPolicy      string `json:"registration_policy,omitempty"`
+	EmailVerificationPolicy string `json:"email_verification_policy,omitempty"`
 }
 
 type updateTenantRequest struct {
-	Name                 *string `json:"name,omitempty"`
-	SessionLifetime      *int    `json:"session_lifetime,omitempty"`
-	AuthCodeLifetime     *int    `json:"auth_code_lifetime,omitempty"`
-	AccessTokenLifetime  *int    `json:"access_token_lifetime,omitempty"`
-	RefreshTokenLifetime *int    `json:"refresh_token_lifetime,omitempty"`
-	IDTokenLifetime      *int    `json:"id_token_lifetime,omitempty"`
+	Name                    *string `json:"name,omitempty"`
+	SessionLifetime         *int    `json:"session_lifetime,omitempty"`
+	AuthCodeLifetime        *int    `json:"auth_code_lifetime,omitempty"`
+	AccessTokenLifetime     *int    `json:"access_token_lifetime,omitempty"`
+	RefreshTokenLifetime    *int    `json:"refresh_token_lifetime,omitempty"`
+	IDTokenLifetime         *int    `json:"id_token_lifetime,omitempty"`
+	RegistrationPolicy      *string `json:"registration_policy,omitempty"`
+	EmailVerificationPolicy *string `json:"email_verification_policy,omitempty"`
 }
 
 type tenantResponse struct {
-	ID                   string `json:"id"`
-	Code                 string `json:"code"`
-	Name                 string `json:"name"`
-	SessionLifetime      int    `json:"session_lifetime"`
-	AuthCodeLifetime     int    `json:"auth_code_lifetime"`
-	AccessTokenLifetime  int    `json:"access_token_lifetime"`
-	RefreshTokenLifetime int    `json:"refresh_token_lifetime"`
-	IDTokenLifetime      int    `json:"id_token_lifetime"`
-	CreatedAt            string `json:"created_at"`
-	UpdatedAt            string `json:"updated_at"`
+	ID                      string `json:"id"`
+	Code                    string `json:"code"`
+	Name                    string `json:"name"`
+	SessionLifetime         int    `json:"session_lifetime"`
+	AuthCodeLifetime        int    `json:"auth_code_lifetime"`
+	AccessTokenLifetime     int    `json:"access_token_lifetime"`
+	RefreshTokenLifetime    int    `json:"refresh_token_lifetime"`
+	IDTokenLifetime         int    `json:"id_token_lifetime"`
+	RegistrationPolicy      string `json:"registration_policy"`
+	EmailVerificationPolicy string `json:"email_verification_policy"`
+	CreatedAt               string `json:"created_at"`
+	UpdatedAt               string `json:"updated_at"`
 }
 
 func toTenantResponse(t *model.Tenant) tenantResponse {
 	return tenantResponse{
-		ID:                   t.ID.String(),
-		Code:                 t.Code,
-		Name:                 t.Name,
-		SessionLifetime:      t.SessionLifetime,
-		AuthCodeLifetime:     t.AuthCodeLifetime,
-		AccessTokenLifetime:  t.AccessTokenLifetime,
-		RefreshTokenLifetime: t.RefreshTokenLifetime,
-		IDTokenLifetime:      t.IDTokenLifetime,
-		CreatedAt:            t.CreatedAt.Format(time.RFC3339),
-		UpdatedAt:            t.UpdatedAt.Format(time.RFC3339),
+		ID:                      t.ID.String(),
+		Code:                    t.Code,
+		Name:                    t.Name,
+		SessionLifetime:         t.SessionLifetime,
+		AuthCodeLifetime:        t.AuthCodeLifetime,
+		AccessTokenLifetime:     t.AccessTokenLifetime,
+		RefreshTokenLifetime:    t.RefreshTokenLifetime,
+		IDTokenLifetime:         t.IDTokenLifetime,
+		RegistrationPolicy:      t.RegistrationPolicy,
+		EmailVerificationPolicy: t.EmailVerificationPolicy,
+		CreatedAt:               t.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:               t.UpdatedAt.Format(time.RFC3339),
 	}
 }
 
@@ -117,6 +137,18 @@ func (h *TenantHandler) HandleCreate(c echo.Context) error {
 	if err := validateLifetimes(req.SessionLifetime, req.AuthCodeLifetime, req.AccessTokenLifetime, req.RefreshTokenLifetime, req.IDTokenLifetime); err != nil {
 		return badRequest(c, err.Error())
 	}
+	if req.RegistrationPolicy == "" {
+		req.RegistrationPolicy = model.RegistrationPolicyDisabled
+	}
+	if !validRegistrationPolicies[req.RegistrationPolicy] {
+		return badRequest(c, "unsupported registration_policy: "+req.RegistrationPolicy)
+	}
+	if req.EmailVerificationPolicy == "" {
+		req.EmailVerificationPolicy = model.EmailVerificationPolicyOptional
+	}
+	if !validEmailVerificationPolicies[req.EmailVerificationPolicy] {
+		return badRequest(c, "unsupported email_verification_policy: "+req.EmailVerificationPolicy)
+	}
 
 	// 重複チェック
 	existing, err := h.tenantStore.FindByCode(ctx, req.Code)
@@ -128,14 +160,23 @@ func (h *TenantHandler) HandleCreate(c echo.Context) error {
 		return conflict(c, "tenant code already exists")
 	}
 
+	pairwiseSalt, err := generatePairwiseSalt()
+	if err != nil {
+		c.Logger().Errorf("failed to generate pairwise salt: %v", err)
+		return serverError(c)
+	}
+
 	tenant := &model.Tenant{
-		Code:                 req.Code,
-		Name:                 req.Name,
-		SessionLifetime:      orDefault(req.SessionLifetime, 3600),
-		AuthCodeLifetime:     orDefault(req.AuthCodeLifetime, 60),
-		AccessTokenLifetime:  orDefault(req.AccessTokenLifetime, 3600),
-		RefreshTokenLifetime: orDefault(req.RefreshTokenLifetime, 2592000),
-		IDTokenLifetime:      orDefault(req.IDTokenLifetime, 3600),
+		Code:                    req.Code,
+		Name:                    req.Name,
+		SessionLifetime:         orDefault(req.SessionLifetime, 3600),
+		AuthCodeLifetime:        orDefault(req.AuthCodeLifetime, 60),
+		AccessTokenLifetime:     orDefault(req.AccessTokenLifetime, 3600),
+		RefreshTokenLifetime:    orDefault(req.RefreshTokenLifetime, 2592000),
+		IDTokenLifetime:         orDefault(req.IDTokenLifetime, 3600),
+		RegistrationPolicy:      req.RegistrationPolicy,
+		EmailVerificationPolicy: req.EmailVerificationPolicy,
+		PairwiseSalt:            pairwiseSalt,
 	}
 
 	if err := h.tenantStore.Create(ctx, tenant); err != nil {
@@ -216,6 +257,18 @@ func (h *TenantHandler) HandleUpdate(c echo.Context) error {
 	if req.IDTokenLifetime != nil {
 		tenant.IDTokenLifetime = *req.IDTokenLifetime
 	}
+	if req.RegistrationPolicy != nil {
+		if !validRegistrationPolicies[*req.RegistrationPolicy] {
+			return badRequest(c, "unsupported registration_policy: "+*req.RegistrationPolicy)
+		}
+		tenant.RegistrationPolicy = *req.RegistrationPolicy
+	}
+	if req.EmailVerificationPolicy != nil {
+		if !validEmailVerificationPolicies[*req.EmailVerificationPolicy] {
+			return badRequest(c, "unsupported email_verification_policy: "+*req.EmailVerificationPolicy)
+		}
+		tenant.EmailVerificationPolicy = *req.EmailVerificationPolicy
+	}
 
 	if err := h.tenantStore.Update(ctx, tenant); err != nil {
 		c.Logger().Errorf("failed to update tenant: %v", err)
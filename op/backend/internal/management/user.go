@@ -0,0 +1,412 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/jsonschema"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+var (
+	// birthdateRegex は YYYY-MM-DD または YYYY。年を伏せる場合は 0000-MM-DD (OIDC Core 1.0 Section 5.1)
+	birthdateRegex = regexp.MustCompile(`^\d{4}(-\d{2}-\d{2})?$`)
+	// phoneNumberRegex は E.164 形式の電話番号 (OIDC Core 1.0 Section 5.1)
+	phoneNumberRegex = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	// zoneinfoRegex は tz database のタイムゾーン名 (例: Asia/Tokyo)
+	zoneinfoRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+	// localeRegex は BCP47 の言語タグ。OIDC Core ではアンダースコア区切りも許容される
+	localeRegex = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+)
+
+// UserHandler はエンドユーザーのプロフィール管理エンドポイントを処理する。
+type UserHandler struct {
+	userStore          UserStore
+	auditLogStore      UserAuditLogStore
+	verificationSender EmailVerificationSender
+}
+
+// NewUserHandler は UserHandler を生成する。
+func NewUserHandler(userStore UserStore, auditLogStore UserAuditLogStore, verificationSender EmailVerificationSender) *UserHandler {
+	return &UserHandler{userStore: userStore, auditLogStore: auditLogStore, verificationSender: verificationSender}
+}
+
+// updateUserProfileRequest は指定された項目のみ更新する。文字列項目は空文字で未設定に戻す。
+// address と custom_attributes は null で未設定に戻す。custom_attributes は全体を置き換える。
+type updateUserProfileRequest struct {
+	Name                *string         `json:"name,omitempty"`
+	GivenName           *string         `json:"given_name,omitempty"`
+	FamilyName          *string         `json:"family_name,omitempty"`
+	MiddleName          *string         `json:"middle_name,omitempty"`
+	Nickname            *string         `json:"nickname,omitempty"`
+	PreferredUsername   *string         `json:"preferred_username,omitempty"`
+	Profile             *string         `json:"profile,omitempty"`
+	Picture             *string         `json:"picture,omitempty"`
+	Website             *string         `json:"website,omitempty"`
+	Gender              *string         `json:"gender,omitempty"`
+	Birthdate           *string         `json:"birthdate,omitempty"`
+	Zoneinfo            *string         `json:"zoneinfo,omitempty"`
+	Locale              *string         `json:"locale,omitempty"`
+	PhoneNumber         *string         `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool           `json:"phone_number_verified,omitempty"`
+	SMSMFAEnabled       *bool           `json:"sms_mfa_enabled,omitempty"`
+	Address             json.RawMessage `json:"address,omitempty"`
+	CustomAttributes    json.RawMessage `json:"custom_attributes,omitempty"`
+}
+
+type userResponse struct {
+	ID                  string               `json:"id"`
+	TenantID            string               `json:"tenant_id"`
+	LoginID             string               `json:"login_id"`
+	Email               string               `json:"email"`
+	EmailVerified       bool                 `json:"email_verified"`
+	Status              string               `json:"status"`
+	Name                *string              `json:"name"`
+	GivenName           *string              `json:"given_name"`
+	FamilyName          *string              `json:"family_name"`
+	MiddleName          *string              `json:"middle_name"`
+	Nickname            *string              `json:"nickname"`
+	PreferredUsername   *string              `json:"preferred_username"`
+	Profile             *string              `json:"profile"`
+	Picture             *string              `json:"picture"`
+	Website             *string              `json:"website"`
+	Gender              *string              `json:"gender"`
+	Birthdate           *string              `json:"birthdate"`
+	Zoneinfo            *string              `json:"zoneinfo"`
+	Locale              *string              `json:"locale"`
+	PhoneNumber         *string              `json:"phone_number"`
+	PhoneNumberVerified bool                 `json:"phone_number_verified"`
+	SMSMFAEnabled       bool                 `json:"sms_mfa_enabled"`
+	Address             *model.Address       `json:"address"`
+	ExternalID          *string              `json:"external_id"`
+	CustomAttributes    model.UserAttributes `json:"custom_attributes"`
+	LastLoginAt         *string              `json:"last_login_at"`
+	CreatedAt           string               `json:"created_at"`
+	UpdatedAt           string               `json:"updated_at"`
+}
+
+type userAuditLogResponse struct {
+	ID        string            `json:"id"`
+	Event     string            `json:"event"`
+	Detail    map[string]string `json:"detail"`
+	IPAddress string            `json:"ip_address"`
+	CreatedAt string            `json:"created_at"`
+}
+
+func toUserResponse(u *model.User) userResponse {
+	resp := userResponse{
+		ID:                  u.ID.String(),
+		TenantID:            u.TenantID.String(),
+		LoginID:             u.LoginID,
+		Email:               u.Email,
+		EmailVerified:       u.EmailVerified,
+		Status:              u.Status,
+		Name:                u.Name,
+		GivenName:           u.GivenName,
+		FamilyName:          u.FamilyName,
+		MiddleName:          u.MiddleName,
+		Nickname:            u.Nickname,
+		PreferredUsername:   u.PreferredUsername,
+		Profile:             u.Profile,
+		Picture:             u.Picture,
+		Website:             u.Website,
+		Gender:              u.Gender,
+		Birthdate:           u.Birthdate,
+		Zoneinfo:            u.Zoneinfo,
+		Locale:              u.Locale,
+		PhoneNumber:         u.PhoneNumber,
+		PhoneNumberVerified: u.PhoneNumberVerified,
+		SMSMFAEnabled:       u.SMSMFAEnabled,
+		Address:             u.Address,
+		ExternalID:          u.ExternalID,
+		CustomAttributes:    u.CustomAttributes,
+		CreatedAt:           u.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:           u.UpdatedAt.Format(time.RFC3339),
+	}
+	if resp.CustomAttributes == nil {
+		resp.CustomAttributes = model.UserAttributes{}
+	}
+	if u.LastLoginAt != nil {
+		s := u.LastLoginAt.Format(time.RFC3339)
+		resp.LastLoginAt = &s
+	}
+	return resp
+}
+
+// HandleGet は GET /management/v1/users/:id を処理する。
+func (h *UserHandler) HandleGet(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// HandleUpdate は PUT /management/v1/users/:id を処理する。
+// custom_attributes はテナントの UserAttributeSchema で検証する。
+func (h *UserHandler) HandleUpdate(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	var req updateUserProfileRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	fields := []struct {
+		name     string
+		value    *string
+		target   **string
+		maxLen   int
+		validate func(string) bool
+	}{
+		{"name", req.Name, &user.Name, 255, nil},
+		{"given_name", req.GivenName, &user.GivenName, 255, nil},
+		{"family_name", req.FamilyName, &user.FamilyName, 255, nil},
+		{"middle_name", req.MiddleName, &user.MiddleName, 255, nil},
+		{"nickname", req.Nickname, &user.Nickname, 255, nil},
+		{"preferred_username", req.PreferredUsername, &user.PreferredUsername, 255, nil},
+		{"profile", req.Profile, &user.Profile, 2048, isHTTPURL},
+		{"picture", req.Picture, &user.Picture, 2048, isHTTPURL},
+		{"website", req.Website, &user.Website, 2048, isHTTPURL},
+		{"gender", req.Gender, &user.Gender, 63, nil},
+		{"birthdate", req.Birthdate, &user.Birthdate, 10, isValidBirthdate},
+		{"zoneinfo", req.Zoneinfo, &user.Zoneinfo, 63, zoneinfoRegex.MatchString},
+		{"locale", req.Locale, &user.Locale, 35, localeRegex.MatchString},
+		{"phone_number", req.PhoneNumber, &user.PhoneNumber, 32, phoneNumberRegex.MatchString},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if *f.value == "" {
+			*f.target = nil
+			continue
+		}
+		if len(*f.value) > f.maxLen || (f.validate != nil && !f.validate(*f.value)) {
+			return badRequest(c, fmt.Sprintf("invalid %s", f.name))
+		}
+		v := *f.value
+		*f.target = &v
+	}
+
+	// 電話番号を変更した場合は検証済みフラグを引き継がない
+	if req.PhoneNumber != nil {
+		user.PhoneNumberVerified = false
+	}
+	if req.PhoneNumberVerified != nil {
+		if *req.PhoneNumberVerified && user.PhoneNumber == nil {
+			return badRequest(c, "phone_number_verified requires phone_number")
+		}
+		user.PhoneNumberVerified = *req.PhoneNumberVerified
+	}
+	if user.PhoneNumber == nil {
+		user.PhoneNumberVerified = false
+	}
+	// SMS の2要素認証は確認済みの電話番号が必要。電話番号が未確認になった場合は無効にする
+	if req.SMSMFAEnabled != nil {
+		if *req.SMSMFAEnabled && !user.PhoneNumberVerified {
+			return badRequest(c, "sms_mfa_enabled requires verified phone_number")
+		}
+		user.SMSMFAEnabled = *req.SMSMFAEnabled
+	}
+	if !user.PhoneNumberVerified {
+		user.SMSMFAEnabled = false
+	}
+
+	if req.Address != nil {
+		var address *model.Address
+		if err := json.Unmarshal(req.Address, &address); err != nil {
+			return badRequest(c, "invalid address")
+		}
+		if address.IsEmpty() {
+			address = nil
+		}
+		user.Address = address
+	}
+
+	if req.CustomAttributes != nil {
+		var attrs model.UserAttributes
+		if err := json.Unmarshal(req.CustomAttributes, &attrs); err != nil {
+			return badRequest(c, "custom_attributes must be an object")
+		}
+		if err := validateCustomAttributes(&user.Tenant, attrs); err != nil {
+			return badRequest(c, err.Error())
+		}
+		user.CustomAttributes = attrs
+	}
+
+	if err := h.userStore.UpdateProfile(c.Request().Context(), user); err != nil {
+		c.Logger().Errorf("failed to update user profile: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// HandleResendEmailVerification は POST /management/v1/users/:id/resend-email-verification を処理する。
+// ユーザー自身による再送と異なり送信間隔を制限しない。未使用の確認メールのリンクは無効になる。
+func (h *UserHandler) HandleResendEmailVerification(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	if user.EmailVerified {
+		return conflict(c, "email is already verified")
+	}
+
+	if err := h.verificationSender.SendVerification(c.Request().Context(), user); err != nil {
+		c.Logger().Errorf("failed to send email verification: %v", err)
+		return serverError(c)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// HandleVerifyEmail は POST /management/v1/users/:id/verify-email を処理する。
+// 確認メールを介さずにメールアドレスを確認済みにする。
+func (h *UserHandler) HandleVerifyEmail(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	if !user.EmailVerified {
+		ok, err := h.userStore.MarkEmailVerified(c.Request().Context(), user.ID, user.Email)
+		if err != nil {
+			c.Logger().Errorf("failed to mark email verified: %v", err)
+			return serverError(c)
+		}
+		if !ok {
+			// 同時にメールアドレスが変更された
+			return conflict(c, "email was changed concurrently")
+		}
+		user.EmailVerified = true
+	}
+
+	return c.JSON(http.StatusOK, toUserResponse(user))
+}
+
+// HandleListAuditLogs は GET /management/v1/users/:id/audit-logs を処理する。
+func (h *UserHandler) HandleListAuditLogs(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+
+	p := parsePagination(c)
+	entries, total, err := h.auditLogStore.ListByUserID(c.Request().Context(), user.ID, p.Limit, p.Offset)
+	if err != nil {
+		c.Logger().Errorf("failed to list user audit logs: %v", err)
+		return serverError(c)
+	}
+
+	data := make([]userAuditLogResponse, len(entries))
+	for i, e := range entries {
+		detail := map[string]string(e.Detail)
+		if detail == nil {
+			detail = map[string]string{}
+		}
+		data[i] = userAuditLogResponse{
+			ID:        e.ID.String(),
+			Event:     e.Event,
+			Detail:    detail,
+			IPAddress: e.IPAddress,
+			CreatedAt: e.CreatedAt.Format(time.RFC3339),
+		}
+	}
+
+	return c.JSON(http.StatusOK, ListResponse[userAuditLogResponse]{
+		Data:       data,
+		TotalCount: total,
+	})
+}
+
+// findUser はパスの :id でユーザーを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *UserHandler) findUser(c echo.Context) (*model.User, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid user id format")
+	}
+
+	user, err := h.userStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find user: %v", err)
+		return nil, serverError(c)
+	}
+	if user == nil {
+		return nil, notFound(c, "user not found")
+	}
+	return user, nil
+}
+
+// validateCustomAttributes はカスタム属性がテナントのスキーマに適合することを検証する。
+// スキーマの additionalProperties に関わらず、properties に定義されていない属性は受け付けない。
+func validateCustomAttributes(tenant *model.Tenant, attrs model.UserAttributes) error {
+	if len(attrs) == 0 && tenant.UserAttributeSchema == nil {
+		return nil
+	}
+	if tenant.UserAttributeSchema == nil {
+		return fmt.Errorf("tenant does not define custom attributes")
+	}
+
+	defined := map[string]bool{}
+	for _, name := range tenant.UserAttributeNames() {
+		defined[name] = true
+	}
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if !defined[name] {
+			return fmt.Errorf("custom attribute %q is not defined", name)
+		}
+	}
+
+	schema, err := jsonschema.Parse([]byte(*tenant.UserAttributeSchema))
+	if err != nil {
+		return fmt.Errorf("tenant user attribute schema is invalid: %v", err)
+	}
+	instance := map[string]interface{}(attrs)
+	if instance == nil {
+		instance = map[string]interface{}{}
+	}
+	if err := schema.Validate(instance); err != nil {
+		return fmt.Errorf("invalid custom_attributes: %v", err)
+	}
+	return nil
+}
+
+// isHTTPURL は http(s) の絶対 URL か判定する。
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+// isValidBirthdate は birthdate が YYYY-MM-DD、0000-MM-DD または YYYY 形式の実在する日付か判定する。
+func isValidBirthdate(s string) bool {
+	if !birthdateRegex.MatchString(s) {
+		return false
+	}
+	if len(s) == 4 {
+		return s != "0000"
+	}
+	// 年を伏せた 0000-MM-DD はうるう年で月日を検証する
+	if s[:4] == "0000" {
+		s = "2000" + s[4:]
+	}
+	_, err := time.Parse("2006-01-02", s)
+	return err == nil
+}
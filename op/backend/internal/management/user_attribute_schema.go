@@ -0,0 +1,142 @@
+package management
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/jsonschema"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// userAttributeNameRegex はカスタム属性名 (= クレーム名) に使える文字列
+var userAttributeNameRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_]{0,62}$`)
+
+// UserAttributeSchemaHandler はテナントのカスタムユーザー属性の定義を管理する。
+type UserAttributeSchemaHandler struct {
+	tenantStore TenantStore
+}
+
+// NewUserAttributeSchemaHandler は UserAttributeSchemaHandler を生成する。
+func NewUserAttributeSchemaHandler(tenantStore TenantStore) *UserAttributeSchemaHandler {
+	return &UserAttributeSchemaHandler{tenantStore: tenantStore}
+}
+
+// userAttributeSchemaRequest は schema に null を指定するとカスタム属性の定義を削除する。
+type userAttributeSchemaRequest struct {
+	Schema json.RawMessage `json:"schema"`
+}
+
+type userAttributeSchemaResponse struct {
+	TenantID   string          `json:"tenant_id"`
+	Schema     json.RawMessage `json:"schema"`
+	Attributes []string        `json:"attributes"`
+}
+
+func toUserAttributeSchemaResponse(t *model.Tenant) userAttributeSchemaResponse {
+	resp := userAttributeSchemaResponse{
+		TenantID:   t.ID.String(),
+		Schema:     json.RawMessage("null"),
+		Attributes: t.UserAttributeNames(),
+	}
+	if t.UserAttributeSchema != nil {
+		resp.Schema = json.RawMessage(*t.UserAttributeSchema)
+	}
+	if resp.Attributes == nil {
+		resp.Attributes = []string{}
+	}
+	return resp
+}
+
+// HandleGet は GET /management/v1/tenants/:tenant_id/user-attribute-schema を処理する。
+func (h *UserAttributeSchemaHandler) HandleGet(c echo.Context) error {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toUserAttributeSchemaResponse(tenant))
+}
+
+// HandleUpdate は PUT /management/v1/tenants/:tenant_id/user-attribute-schema を処理する。
+// 既存ユーザーの属性値は再検証しない。定義から外れた属性はクレームとして返さなくなる。
+func (h *UserAttributeSchemaHandler) HandleUpdate(c echo.Context) error {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req userAttributeSchemaRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	if len(req.Schema) == 0 || string(req.Schema) == "null" {
+		tenant.UserAttributeSchema = nil
+	} else {
+		if err := validateUserAttributeSchema(req.Schema); err != nil {
+			return badRequest(c, err.Error())
+		}
+		schema := string(req.Schema)
+		tenant.UserAttributeSchema = &schema
+	}
+
+	if err := h.tenantStore.Update(c.Request().Context(), tenant); err != nil {
+		c.Logger().Errorf("failed to update user attribute schema: %v", err)
+		return serverError(c)
+	}
+
+	return c.JSON(http.StatusOK, toUserAttributeSchemaResponse(tenant))
+}
+
+// findTenant はパスの :tenant_id でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *UserAttributeSchemaHandler) findTenant(c echo.Context) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// validateUserAttributeSchema はスキーマが type: object で properties を持ち、
+// 属性名が標準クレームや OP が設定するクレームと衝突しないことを検証する。
+func validateUserAttributeSchema(raw json.RawMessage) error {
+	if _, err := jsonschema.Parse(raw); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+
+	var schema struct {
+		Type       interface{}                `json:"type"`
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal(raw, &schema); err != nil {
+		return fmt.Errorf("invalid schema: %v", err)
+	}
+	if schema.Type != "object" {
+		return fmt.Errorf("schema type must be \"object\"")
+	}
+	if len(schema.Properties) == 0 {
+		return fmt.Errorf("schema must define properties")
+	}
+	for name := range schema.Properties {
+		if !userAttributeNameRegex.MatchString(name) {
+			return fmt.Errorf("invalid attribute name: %q", name)
+		}
+		if model.IsReservedClaimName(name) {
+			return fmt.Errorf("attribute name %q conflicts with a reserved claim", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package management
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// defaultUserMigrationTimeoutMS は移行元の呼び出しのタイムアウトの既定値
+	defaultUserMigrationTimeoutMS = 5000
+	// maxUserMigrationTimeoutMS はログインの応答を待たせすぎないためのタイムアウトの上限
+	maxUserMigrationTimeoutMS = 30000
+	// minUserMigrationHMACSecretLength は HMAC-SHA256 の鍵の最小の長さ (ハッシュ長)
+	minUserMigrationHMACSecretLength = 32
+)
+
+// UserMigrationHookHandler は移行元でパスワードを検証する Webhook の管理エンドポイントを処理する。
+type UserMigrationHookHandler struct {
+	hookStore     UserMigrationHookStore
+	tenantStore   TenantStore
+	encryptSecret EncryptSecretFunc
+	decryptSecret DecryptSecretFunc
+}
+
+// NewUserMigrationHookHandler は UserMigrationHookHandler を生成する。
+func NewUserMigrationHookHandler(hookStore UserMigrationHookStore, tenantStore TenantStore, encryptSecret EncryptSecretFunc, decryptSecret DecryptSecretFunc) *UserMigrationHookHandler {
+	return &UserMigrationHookHandler{
+		hookStore:     hookStore,
+		tenantStore:   tenantStore,
+		encryptSecret: encryptSecret,
+		decryptSecret: decryptSecret,
+	}
+}
+
+// putUserMigrationHookRequest は Webhook の設定全体を表す。省略した項目は既定値になる。
+// hmac_secret・client_private_key は省略すると現在の値を維持する。
+type putUserMigrationHookRequest struct {
+	URL               string  `json:"url"`
+	AuthMethod        string  `json:"auth_method"`
+	HMACSecret        *string `json:"hmac_secret,omitempty"`
+	ClientCertificate string  `json:"client_certificate"`
+	ClientPrivateKey  *string `json:"client_private_key,omitempty"`
+	CACertificate     string  `json:"ca_certificate"`
+	TimeoutMS         int     `json:"timeout_ms"`
+	Status            string  `json:"status"`
+}
+
+// userMigrationHookResponse は hmac_secret・client_private_key を返さず、設定済みかどうかのみ返す。
+type userMigrationHookResponse struct {
+	ID                  string  `json:"id"`
+	TenantID            string  `json:"tenant_id"`
+	URL                 string  `json:"url"`
+	AuthMethod          string  `json:"auth_method"`
+	HMACSecretSet       bool    `json:"hmac_secret_set"`
+	ClientCertificate   *string `json:"client_certificate"`
+	ClientPrivateKeySet bool    `json:"client_private_key_set"`
+	CACertificate       *string `json:"ca_certificate"`
+	TimeoutMS           int     `json:"timeout_ms"`
+	Status              string  `json:"status"`
+	CreatedAt           string  `json:"created_at"`
+	UpdatedAt           string  `json:"updated_at"`
+}
+
+func toUserMigrationHookResponse(h *model.UserMigrationHook) userMigrationHookResponse {
+	return userMigrationHookResponse{
+		ID:                  h.ID.String(),
+		TenantID:            h.TenantID.String(),
+		URL:                 h.URL,
+		AuthMethod:          h.AuthMethod,
+		HMACSecretSet:       h.HMACSecretEncrypted != nil,
+		ClientCertificate:   h.ClientCertificate,
+		ClientPrivateKeySet: h.ClientPrivateKeyEncrypted != nil,
+		CACertificate:       h.CACertificate,
+		TimeoutMS:           h.TimeoutMS,
+		Status:              h.Status,
+		CreatedAt:           h.CreatedAt.Format(time.RFC3339),
+		UpdatedAt:           h.UpdatedAt.Format(time.RFC3339),
+	}
+}
+
+// HandleGet は GET /management/v1/tenants/:tenant_id/user-migration-hook を処理する。
+func (h *UserMigrationHookHandler) HandleGet(c echo.Context) error {
+	hook, err := h.findHook(c)
+	if err != nil || hook == nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, toUserMigrationHookResponse(hook))
+}
+
+// HandlePut は PUT /management/v1/tenants/:tenant_id/user-migration-hook を処理する。
+// Webhook がなければ作成し (201)、あれば設定を置き換える (200)。
+// 移行が完了したら status を disabled にするか削除すると、移行元を呼び出さなくなる。
+func (h *UserMigrationHookHandler) HandlePut(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+
+	var req putUserMigrationHookRequest
+	if err := c.Bind(&req); err != nil {
+		return badRequest(c, "invalid request body")
+	}
+
+	hook, err := h.hookStore.FindByTenantID(ctx, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to find user migration hook: %v", err)
+		return serverError(c)
+	}
+	status := http.StatusOK
+	if hook == nil {
+		hook = &model.UserMigrationHook{TenantID: tenant.ID}
+		status = http.StatusCreated
+	}
+
+	if err := applyUserMigrationHookRequest(hook, &req); err != nil {
+		return badRequest(c, err.Error())
+	}
+	// mTLS の秘密鍵を省略した場合は、保存済みの鍵で証明書との組を検証する
+	clientPrivateKey := ""
+	if req.ClientPrivateKey != nil {
+		clientPrivateKey = *req.ClientPrivateKey
+	} else if hook.AuthMethod == model.UserMigrationHookAuthMTLS && hook.ClientPrivateKeyEncrypted != nil {
+		clientPrivateKey, err = h.decryptSecret(*hook.ClientPrivateKeyEncrypted)
+		if err != nil {
+			c.Logger().Errorf("failed to decrypt client private key: %v", err)
+			return serverError(c)
+		}
+	}
+	if err := validateUserMigrationHookSecrets(hook, &req, clientPrivateKey); err != nil {
+		return badRequest(c, err.Error())
+	}
+	if err := h.applySecrets(hook, &req, clientPrivateKey); err != nil {
+		c.Logger().Errorf("failed to encrypt user migration hook secret: %v", err)
+		return serverError(c)
+	}
+
+	if status == http.StatusCreated {
+		err = h.hookStore.Create(ctx, hook)
+	} else {
+		err = h.hookStore.Update(ctx, hook)
+	}
+	if err != nil {
+		c.Logger().Errorf("failed to save user migration hook: %v", err)
+		return serverError(c)
+	}
+	return c.JSON(status, toUserMigrationHookResponse(hook))
+}
+
+// HandleDelete は DELETE /management/v1/tenants/:tenant_id/user-migration-hook を処理する。
+// 移行済みのユーザーはローカルのパスワードでログインできる。
+func (h *UserMigrationHookHandler) HandleDelete(c echo.Context) error {
+	hook, err := h.findHook(c)
+	if err != nil || hook == nil {
+		return err
+	}
+	if err := h.hookStore.Delete(c.Request().Context(), hook.ID); err != nil {
+		c.Logger().Errorf("failed to delete user migration hook: %v", err)
+		return serverError(c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// applyUserMigrationHookRequest はリクエストを検証して Webhook に設定する。シークレットは applySecrets で扱う
+func applyUserMigrationHookRequest(hook *model.UserMigrationHook, req *putUserMigrationHookRequest) error {
+	// パスワードを送るため https のみ許可する
+	parsed, err := url.Parse(req.URL)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" || parsed.User != nil || parsed.Fragment != "" || len(req.URL) > 2048 {
+		return fmt.Errorf("url must be an absolute https URL without userinfo or fragment")
+	}
+
+	if req.AuthMethod == "" {
+		req.AuthMethod = model.UserMigrationHookAuthHMAC
+	}
+	if req.AuthMethod != model.UserMigrationHookAuthHMAC && req.AuthMethod != model.UserMigrationHookAuthMTLS {
+		return fmt.Errorf("auth_method must be hmac or mtls")
+	}
+	if req.CACertificate != "" {
+		if err := validateCACertificate(req.CACertificate); err != nil {
+			return err
+		}
+	}
+
+	if req.TimeoutMS == 0 {
+		req.TimeoutMS = defaultUserMigrationTimeoutMS
+	}
+	if req.TimeoutMS < 100 || req.TimeoutMS > maxUserMigrationTimeoutMS {
+		return fmt.Errorf("timeout_ms must be 100-%d", maxUserMigrationTimeoutMS)
+	}
+
+	if req.Status == "" {
+		req.Status = "active"
+	}
+	if req.Status != "active" && req.Status != "disabled" {
+		return fmt.Errorf("status must be active or disabled")
+	}
+
+	hook.URL = req.URL
+	hook.AuthMethod = req.AuthMethod
+	hook.CACertificate = nil
+	if req.CACertificate != "" {
+		hook.CACertificate = &req.CACertificate
+	}
+	hook.TimeoutMS = req.TimeoutMS
+	hook.Status = req.Status
+	return nil
+}
+
+// validateUserMigrationHookSecrets は認証方式に必要なシークレットがそろっているか検証する。
+// clientPrivateKey はリクエストまたは保存済みの mTLS の秘密鍵 (復号済み)
+func validateUserMigrationHookSecrets(hook *model.UserMigrationHook, req *putUserMigrationHookRequest, clientPrivateKey string) error {
+	switch hook.AuthMethod {
+	case model.UserMigrationHookAuthHMAC:
+		if req.HMACSecret != nil && len(*req.HMACSecret) < minUserMigrationHMACSecretLength {
+			return fmt.Errorf("hmac_secret must be at least %d characters", minUserMigrationHMACSecretLength)
+		}
+		if req.HMACSecret == nil && hook.HMACSecretEncrypted == nil {
+			return fmt.Errorf("hmac_secret is required when auth_method is hmac")
+		}
+	case model.UserMigrationHookAuthMTLS:
+		if req.ClientCertificate == "" || clientPrivateKey == "" {
+			return fmt.Errorf("client_certificate and client_private_key are required when auth_method is mtls")
+		}
+		if _, err := tls.X509KeyPair([]byte(req.ClientCertificate), []byte(clientPrivateKey)); err != nil {
+			return fmt.Errorf("client_certificate and client_private_key must be a PEM certificate and its private key")
+		}
+	}
+	return nil
+}
+
+// applySecrets は認証方式のシークレットを暗号化して設定し、使わない方式のシークレットは削除する
+func (h *UserMigrationHookHandler) applySecrets(hook *model.UserMigrationHook, req *putUserMigrationHookRequest, clientPrivateKey string) error {
+	switch hook.AuthMethod {
+	case model.UserMigrationHookAuthHMAC:
+		hook.ClientCertificate = nil
+		hook.ClientPrivateKeyEncrypted = nil
+		if req.HMACSecret != nil {
+			encrypted, err := h.encryptSecret(*req.HMACSecret)
+			if err != nil {
+				return err
+			}
+			hook.HMACSecretEncrypted = &encrypted
+		}
+	case model.UserMigrationHookAuthMTLS:
+		hook.HMACSecretEncrypted = nil
+		hook.ClientCertificate = &req.ClientCertificate
+		if req.ClientPrivateKey != nil {
+			encrypted, err := h.encryptSecret(clientPrivateKey)
+			if err != nil {
+				return err
+			}
+			hook.ClientPrivateKeyEncrypted = &encrypted
+		}
+	}
+	return nil
+}
+
+// findTenant はパスの :tenant_id でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *UserMigrationHookHandler) findTenant(c echo.Context) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
+
+// findHook はテナントの Webhook を検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *UserMigrationHookHandler) findHook(c echo.Context) (*model.UserMigrationHook, error) {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return nil, err
+	}
+
+	hook, err := h.hookStore.FindByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to find user migration hook: %v", err)
+		return nil, serverError(c)
+	}
+	if hook == nil {
+		return nil, notFound(c, "user migration hook not found")
+	}
+	return hook, nil
+}
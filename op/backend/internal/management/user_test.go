@@ -0,0 +1,214 @@
+package management
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const testUserAttributeSchema = `{
+	"type": "object",
+	"properties": {
+		"employee_number": {"type": "string", "pattern": "^E[0-9]{5}$"},
+		"department": {"type": "string"},
+		"grade": {"type": "integer", "minimum": 1}
+	}
+}`
+
+func TestValidateUserAttributeSchema(t *testing.T) {
+	if err := validateUserAttributeSchema(json.RawMessage(testUserAttributeSchema)); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		schema string
+	}{
+		{name: "JSON でない", schema: `{`},
+		{name: "object でない", schema: `{"type":"string"}`},
+		{name: "properties なし", schema: `{"type":"object"}`},
+		{name: "属性名が数字で始まる", schema: `{"type":"object","properties":{"1st":{"type":"string"}}}`},
+		{name: "属性名に記号", schema: `{"type":"object","properties":{"dept-code":{"type":"string"}}}`},
+		{name: "属性名が長すぎる", schema: `{"type":"object","properties":{"` + strings.Repeat("a", 64) + `":{"type":"string"}}}`},
+		{name: "標準クレームと衝突", schema: `{"type":"object","properties":{"email":{"type":"string"}}}`},
+		{name: "OP が設定するクレームと衝突", schema: `{"type":"object","properties":{"sid":{"type":"string"}}}`},
+		{name: "authorization_details と衝突", schema: `{"type":"object","properties":{"authorization_details":{"type":"string"}}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateUserAttributeSchema(json.RawMessage(tt.schema)); err == nil {
+				t.Error("accepted")
+			}
+		})
+	}
+}
+
+func TestValidateCustomAttributes(t *testing.T) {
+	schema := testUserAttributeSchema
+	tenant := &model.Tenant{UserAttributeSchema: &schema}
+
+	tests := []struct {
+		name    string
+		tenant  *model.Tenant
+		attrs   model.UserAttributes
+		wantErr bool
+	}{
+		{name: "適合", tenant: tenant, attrs: model.UserAttributes{"employee_number": "E00001", "grade": float64(3)}},
+		{name: "空", tenant: tenant, attrs: model.UserAttributes{}},
+		{name: "定義されていない属性", tenant: tenant, attrs: model.UserAttributes{"nickname2": "x"}, wantErr: true},
+		{name: "パターン不一致", tenant: tenant, attrs: model.UserAttributes{"employee_number": "X1"}, wantErr: true},
+		{name: "型不一致", tenant: tenant, attrs: model.UserAttributes{"grade": "3"}, wantErr: true},
+		{name: "最小値未満", tenant: tenant, attrs: model.UserAttributes{"grade": float64(0)}, wantErr: true},
+		{name: "スキーマのないテナントで空", tenant: &model.Tenant{}, attrs: nil},
+		{name: "スキーマのないテナントで指定", tenant: &model.Tenant{}, attrs: model.UserAttributes{"department": "x"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateCustomAttributes(tt.tenant, tt.attrs); (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+
+	// additionalProperties を許可したスキーマでも properties にない属性は受け付けない
+	open := `{"type":"object","additionalProperties":true,"properties":{"department":{"type":"string"}}}`
+	if err := validateCustomAttributes(&model.Tenant{UserAttributeSchema: &open}, model.UserAttributes{"other": "x"}); err == nil {
+		t.Error("undefined attribute accepted with additionalProperties")
+	}
+}
+
+func TestIsValidBirthdate(t *testing.T) {
+	for s, want := range map[string]bool{
+		"1990-04-01": true,
+		"1990":       true,
+		"0000-04-01": true,
+		"0000-02-29": true,
+		"1999-02-29": false,
+		"1990-13-01": false,
+		"0000":       false,
+		"90-04-01":   false,
+		"1990/04/01": false,
+		"":           false,
+	} {
+		if got := isValidBirthdate(s); got != want {
+			t.Errorf("isValidBirthdate(%q) = %v, want %v", s, got, want)
+		}
+	}
+}
+
+func newUserHandlerFixture() (*UserHandler, *fakeUserStore, *model.User) {
+	schema := testUserAttributeSchema
+	phone := "+819000000000"
+	user := &model.User{
+		ID:                  uuid.New(),
+		Email:               "alice@example.com",
+		PhoneNumber:         &phone,
+		PhoneNumberVerified: true,
+		SMSMFAEnabled:       true,
+		CustomAttributes:    model.UserAttributes{"department": "sales"},
+		Tenant:              model.Tenant{UserAttributeSchema: &schema},
+	}
+	store := newFakeUserStore(user)
+	return NewUserHandler(store, nil, nil), store, user
+}
+
+func updateUser(h *UserHandler, id uuid.UUID, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/management/v1/users/"+id.String(), strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id.String())
+	if err := h.HandleUpdate(c); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func TestUserHandlerUpdateProfile(t *testing.T) {
+	h, store, user := newUserHandlerFixture()
+
+	rec := updateUser(h, user.ID, `{"name":"Alice","picture":"https://example.com/a.png","birthdate":"0000-04-01","zoneinfo":"Asia/Tokyo","locale":"ja_JP","address":{"country":"JP"}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	saved := store.saved
+	if saved.Name == nil || *saved.Name != "Alice" || saved.Birthdate == nil || saved.Address == nil || saved.Address.Country != "JP" {
+		t.Errorf("saved = %+v", saved)
+	}
+	// 指定しなかった項目は変えない
+	if !saved.PhoneNumberVerified || saved.CustomAttributes["department"] != "sales" {
+		t.Errorf("untouched fields changed: %+v", saved)
+	}
+
+	// 空文字・null で未設定に戻す
+	rec = updateUser(h, user.ID, `{"name":"","address":null,"custom_attributes":null}`)
+	if rec.Code != http.StatusOK || store.saved.Name != nil || store.saved.Address != nil || store.saved.CustomAttributes != nil {
+		t.Errorf("status = %d, saved = %+v", rec.Code, store.saved)
+	}
+
+	tests := []struct {
+		name string
+		body string
+	}{
+		{name: "URL でない picture", body: `{"picture":"javascript:alert(1)"}`},
+		{name: "相対 URL の website", body: `{"website":"/home"}`},
+		{name: "存在しない日付", body: `{"birthdate":"2001-02-29"}`},
+		{name: "E.164 でない電話番号", body: `{"phone_number":"090-0000-0000"}`},
+		{name: "不正な locale", body: `{"locale":"japanese!"}`},
+		{name: "長すぎる name", body: `{"name":"` + strings.Repeat("a", 256) + `"}`},
+		{name: "電話番号なしで確認済み", body: `{"phone_number":"","phone_number_verified":true}`},
+		{name: "未確認の電話番号で SMS 2要素認証", body: `{"phone_number":"+819011111111","sms_mfa_enabled":true}`},
+		{name: "address がオブジェクトでない", body: `{"address":"Tokyo"}`},
+		{name: "custom_attributes がオブジェクトでない", body: `{"custom_attributes":["x"]}`},
+		{name: "スキーマに適合しない custom_attributes", body: `{"custom_attributes":{"grade":0}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store.saved = nil
+			if rec := updateUser(h, user.ID, tt.body); rec.Code != http.StatusBadRequest || store.saved != nil {
+				t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+		})
+	}
+}
+
+func TestUserHandlerUpdatePhoneNumber(t *testing.T) {
+	h, store, user := newUserHandlerFixture()
+
+	// 電話番号を変えたら確認済みフラグと SMS の2要素認証を引き継がない
+	if rec := updateUser(h, user.ID, `{"phone_number":"+819011111111"}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if store.saved.PhoneNumberVerified || store.saved.SMSMFAEnabled {
+		t.Errorf("saved = %+v", store.saved)
+	}
+
+	// 電話番号を消すと確認済みフラグも消える
+	if rec := updateUser(h, user.ID, `{"phone_number":""}`); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if store.saved.PhoneNumber != nil || store.saved.PhoneNumberVerified || store.saved.SMSMFAEnabled {
+		t.Errorf("saved = %+v", store.saved)
+	}
+
+	// 同時に確認済みにすれば SMS の2要素認証を有効にできる
+	rec := updateUser(h, user.ID, `{"phone_number":"+819022222222","phone_number_verified":true,"sms_mfa_enabled":true}`)
+	if rec.Code != http.StatusOK || !store.saved.PhoneNumberVerified || !store.saved.SMSMFAEnabled {
+		t.Errorf("status = %d, saved = %+v", rec.Code, store.saved)
+	}
+}
+
+func TestUserHandlerUnknownUser(t *testing.T) {
+	h, _, _ := newUserHandlerFixture()
+	if rec := updateUser(h, uuid.New(), `{"name":"x"}`); rec.Code != http.StatusNotFound {
+		t.Errorf("status = %d", rec.Code)
+	}
+}
@@ -0,0 +1,614 @@
+package management
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// ユーザーの一括インポート・エクスポートのファイル形式
+const (
+	UserFileFormatCSV   = "csv"
+	UserFileFormatJSONL = "jsonl"
+)
+
+const (
+	// maxUserImportErrors は結果に含める行ごとのエラーの上限
+	maxUserImportErrors = 1000
+	// maxUserFileLineBytes は JSONL の 1 行の上限
+	maxUserFileLineBytes = 1 << 20
+	// userExportBatchSize はエクスポートで 1 回に読み込むユーザー数
+	userExportBatchSize = 500
+)
+
+// ErrInvalidUserFile はファイル全体を読み込めないこと (CSV のヘッダの誤りなど) を示す
+var ErrInvalidUserFile = errors.New("invalid user file")
+
+// UserRecord はインポート・エクスポートするユーザー 1 件。項目名は CSV の列名と JSONL のキーに使う。
+// nil の項目は変更しない。文字列の項目は空文字で未設定に戻す (login_id・email・status・password_hash を除く)
+type UserRecord struct {
+	LoginID             *string `json:"login_id,omitempty"`
+	Email               *string `json:"email,omitempty"`
+	EmailVerified       *bool   `json:"email_verified,omitempty"`
+	Status              *string `json:"status,omitempty"`
+	Name                *string `json:"name,omitempty"`
+	GivenName           *string `json:"given_name,omitempty"`
+	FamilyName          *string `json:"family_name,omitempty"`
+	MiddleName          *string `json:"middle_name,omitempty"`
+	Nickname            *string `json:"nickname,omitempty"`
+	PreferredUsername   *string `json:"preferred_username,omitempty"`
+	Profile             *string `json:"profile,omitempty"`
+	Picture             *string `json:"picture,omitempty"`
+	Website             *string `json:"website,omitempty"`
+	Gender              *string `json:"gender,omitempty"`
+	Birthdate           *string `json:"birthdate,omitempty"`
+	Zoneinfo            *string `json:"zoneinfo,omitempty"`
+	Locale              *string `json:"locale,omitempty"`
+	PhoneNumber         *string `json:"phone_number,omitempty"`
+	PhoneNumberVerified *bool   `json:"phone_number_verified,omitempty"`
+	ExternalID          *string `json:"external_id,omitempty"`
+	// PasswordHash は argon2id・bcrypt・PBKDF2・scrypt のハッシュ (crypto.PasswordHashAlgorithm を参照)
+	PasswordHash *string `json:"password_hash,omitempty"`
+}
+
+// userRecordColumns は CSV の列の順序
+var userRecordColumns = []string{
+	"login_id", "email", "email_verified", "status",
+	"name", "given_name", "family_name", "middle_name", "nickname", "preferred_username",
+	"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale",
+	"phone_number", "phone_number_verified", "external_id", "password_hash",
+}
+
+// field は列名に対応する項目を返す。*string または *bool のポインタ
+func (r *UserRecord) field(column string) interface{} {
+	switch column {
+	case "login_id":
+		return &r.LoginID
+	case "email":
+		return &r.Email
+	case "email_verified":
+		return &r.EmailVerified
+	case "status":
+		return &r.Status
+	case "name":
+		return &r.Name
+	case "given_name":
+		return &r.GivenName
+	case "family_name":
+		return &r.FamilyName
+	case "middle_name":
+		return &r.MiddleName
+	case "nickname":
+		return &r.Nickname
+	case "preferred_username":
+		return &r.PreferredUsername
+	case "profile":
+		return &r.Profile
+	case "picture":
+		return &r.Picture
+	case "website":
+		return &r.Website
+	case "gender":
+		return &r.Gender
+	case "birthdate":
+		return &r.Birthdate
+	case "zoneinfo":
+		return &r.Zoneinfo
+	case "locale":
+		return &r.Locale
+	case "phone_number":
+		return &r.PhoneNumber
+	case "phone_number_verified":
+		return &r.PhoneNumberVerified
+	case "external_id":
+		return &r.ExternalID
+	case "password_hash":
+		return &r.PasswordHash
+	}
+	return nil
+}
+
+// UserRecordError は 1 行を読み込めないことを示す。続けて次の行を読み込める
+type UserRecordError struct {
+	Line int
+	Err  error
+}
+
+func (e *UserRecordError) Error() string { return fmt.Sprintf("line %d: %v", e.Line, e.Err) }
+
+// UserRecordReader は CSV または JSONL のファイルからユーザーを 1 件ずつ読み込む。
+// CSV は 1 行目をヘッダとし、ファイルに含まれない列の項目は変更しない。空のセルは空文字として扱う (真偽値の列は変更しない)
+type UserRecordReader struct {
+	format  string
+	csv     *csv.Reader
+	columns []string
+	scanner *bufio.Scanner
+	line    int
+}
+
+// NewUserRecordReader は UserRecordReader を生成する。CSV の場合はヘッダを読み込んで検証する。
+func NewUserRecordReader(r io.Reader, format string) (*UserRecordReader, error) {
+	br := bufio.NewReader(r)
+	// 表計算ソフトが付ける UTF-8 の BOM を読み飛ばす
+	if bom, err := br.Peek(3); err == nil && bytes.Equal(bom, []byte("\xef\xbb\xbf")) {
+		br.Discard(3)
+	}
+
+	switch format {
+	case UserFileFormatCSV:
+		cr := csv.NewReader(br)
+		cr.FieldsPerRecord = -1
+		header, err := cr.Read()
+		if err != nil {
+			return nil, fmt.Errorf("%w: failed to read csv header: %v", ErrInvalidUserFile, err)
+		}
+		seen := map[string]bool{}
+		hasLoginID := false
+		for i, column := range header {
+			column = strings.TrimSpace(column)
+			header[i] = column
+			if (&UserRecord{}).field(column) == nil {
+				return nil, fmt.Errorf("%w: unknown column %q", ErrInvalidUserFile, column)
+			}
+			if seen[column] {
+				return nil, fmt.Errorf("%w: duplicate column %q", ErrInvalidUserFile, column)
+			}
+			seen[column] = true
+			hasLoginID = hasLoginID || column == "login_id"
+		}
+		if !hasLoginID {
+			return nil, fmt.Errorf("%w: login_id column is required", ErrInvalidUserFile)
+		}
+		return &UserRecordReader{format: format, csv: cr, columns: header}, nil
+	case UserFileFormatJSONL:
+		scanner := bufio.NewScanner(br)
+		scanner.Buffer(make([]byte, 0, 64*1024), maxUserFileLineBytes)
+		return &UserRecordReader{format: format, scanner: scanner}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidUserFile, format)
+}
+
+// Next は次のユーザーとその行番号を返す。終端では io.EOF を返す。
+// 行の形式の誤りは *UserRecordError を返し、それ以外のエラーの後は読み込めない
+func (r *UserRecordReader) Next() (*UserRecord, int, error) {
+	if r.format == UserFileFormatCSV {
+		return r.nextCSV()
+	}
+	return r.nextJSONL()
+}
+
+func (r *UserRecordReader) nextCSV() (*UserRecord, int, error) {
+	row, err := r.csv.Read()
+	if err == io.EOF {
+		return nil, 0, io.EOF
+	}
+	var parseErr *csv.ParseError
+	if errors.As(err, &parseErr) {
+		return nil, parseErr.StartLine, &UserRecordError{Line: parseErr.StartLine, Err: parseErr.Err}
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	line, _ := r.csv.FieldPos(0)
+	if len(row) != len(r.columns) {
+		return nil, line, &UserRecordError{Line: line, Err: fmt.Errorf("expected %d fields, got %d", len(r.columns), len(row))}
+	}
+
+	rec := &UserRecord{}
+	for i, column := range r.columns {
+		value := row[i]
+		switch f := rec.field(column).(type) {
+		case **string:
+			*f = &value
+		case **bool:
+			if value == "" {
+				continue
+			}
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return nil, line, &UserRecordError{Line: line, Err: fmt.Errorf("%s must be true or false", column)}
+			}
+			*f = &b
+		}
+	}
+	return rec, line, nil
+}
+
+func (r *UserRecordReader) nextJSONL() (*UserRecord, int, error) {
+	for r.scanner.Scan() {
+		r.line++
+		data := bytes.TrimSpace(r.scanner.Bytes())
+		if len(data) == 0 {
+			continue
+		}
+		dec := json.NewDecoder(bytes.NewReader(data))
+		dec.DisallowUnknownFields()
+		rec := &UserRecord{}
+		if err := dec.Decode(rec); err != nil {
+			return nil, r.line, &UserRecordError{Line: r.line, Err: fmt.Errorf("invalid json: %v", err)}
+		}
+		return rec, r.line, nil
+	}
+	if err := r.scanner.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%w: line %d: %v", ErrInvalidUserFile, r.line+1, err)
+	}
+	return nil, 0, io.EOF
+}
+
+// UserRecordWriter はユーザーを CSV または JSONL で書き出す。
+type UserRecordWriter struct {
+	csv  *csv.Writer
+	json *json.Encoder
+}
+
+// NewUserRecordWriter は UserRecordWriter を生成する。CSV の場合はヘッダを書き出す。
+func NewUserRecordWriter(w io.Writer, format string) (*UserRecordWriter, error) {
+	switch format {
+	case UserFileFormatCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(userRecordColumns); err != nil {
+			return nil, err
+		}
+		return &UserRecordWriter{csv: cw}, nil
+	case UserFileFormatJSONL:
+		return &UserRecordWriter{json: json.NewEncoder(w)}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported format %q", ErrInvalidUserFile, format)
+}
+
+// Write はユーザーを 1 件書き出す。
+func (w *UserRecordWriter) Write(rec *UserRecord) error {
+	if w.json != nil {
+		return w.json.Encode(rec)
+	}
+	row := make([]string, len(userRecordColumns))
+	for i, column := range userRecordColumns {
+		switch f := rec.field(column).(type) {
+		case **string:
+			if *f != nil {
+				row[i] = **f
+			}
+		case **bool:
+			if *f != nil {
+				row[i] = strconv.FormatBool(**f)
+			}
+		}
+	}
+	return w.csv.Write(row)
+}
+
+// Flush はバッファに残ったデータを書き出す。
+func (w *UserRecordWriter) Flush() error {
+	if w.csv == nil {
+		return nil
+	}
+	w.csv.Flush()
+	return w.csv.Error()
+}
+
+// UserImportError はインポートできなかった行
+type UserImportError struct {
+	Line    int    `json:"line"`
+	LoginID string `json:"login_id,omitempty"`
+	Error   string `json:"error"`
+}
+
+// UserImportResult はインポートの結果。errors は先頭の maxUserImportErrors 件まで
+type UserImportResult struct {
+	DryRun          bool              `json:"dry_run"`
+	Total           int               `json:"total"`
+	Created         int               `json:"created"`
+	Updated         int               `json:"updated"`
+	Failed          int               `json:"failed"`
+	Errors          []UserImportError `json:"errors"`
+	ErrorsTruncated bool              `json:"errors_truncated"`
+}
+
+func (r *UserImportResult) addError(line int, loginID string, err error) {
+	r.Failed++
+	if len(r.Errors) >= maxUserImportErrors {
+		r.ErrorsTruncated = true
+		return
+	}
+	r.Errors = append(r.Errors, UserImportError{Line: line, LoginID: loginID, Error: err.Error()})
+}
+
+// userImportState はインポート中のファイル内の重複を検出する
+type userImportState struct {
+	loginIDs map[string]bool
+	// emails は小文字にしたメールアドレス → そのメールアドレスを使う行の login_id
+	emails map[string]string
+}
+
+// UserTransferService はユーザーの一括インポート・エクスポートを行う。管理 API と CLI で共用する。
+type UserTransferService struct {
+	userStore             UserTransferStore
+	userRevoker           *UserRevoker
+	passwordHashAlgorithm PasswordHashAlgorithmFunc
+}
+
+// NewUserTransferService は UserTransferService を生成する。
+func NewUserTransferService(userStore UserTransferStore, userRevoker *UserRevoker, passwordHashAlgorithm PasswordHashAlgorithmFunc) *UserTransferService {
+	return &UserTransferService{
+		userStore:             userStore,
+		userRevoker:           userRevoker,
+		passwordHashAlgorithm: passwordHashAlgorithm,
+	}
+}
+
+// Import はファイルのユーザーを login_id でテナントのユーザーと突き合わせ、なければ作成し、あれば更新する。
+// 行ごとに検証・保存し、エラーの行は結果に含めて続行する。dryRun の場合は検証のみ行い保存しない。
+// ファイル全体の形式の誤りは ErrInvalidUserFile を返す。保存に失敗した場合は中断する (それまでの行は保存済み)
+func (s *UserTransferService) Import(ctx context.Context, tenant *model.Tenant, r *UserRecordReader, dryRun bool) (*UserImportResult, error) {
+	result := &UserImportResult{DryRun: dryRun, Errors: []UserImportError{}}
+	state := &userImportState{loginIDs: map[string]bool{}, emails: map[string]string{}}
+
+	for {
+		rec, line, err := r.Next()
+		if err == io.EOF {
+			return result, nil
+		}
+		var recErr *UserRecordError
+		if errors.As(err, &recErr) {
+			result.Total++
+			result.addError(recErr.Line, "", recErr.Err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		result.Total++
+		created, err := s.importRecord(ctx, tenant, rec, state, dryRun)
+		if err != nil {
+			var rowErr *userImportRowError
+			if !errors.As(err, &rowErr) {
+				return nil, err
+			}
+			loginID := ""
+			if rec.LoginID != nil {
+				loginID = *rec.LoginID
+			}
+			result.addError(line, loginID, rowErr)
+			continue
+		}
+		if created {
+			result.Created++
+		} else {
+			result.Updated++
+		}
+	}
+}
+
+// userImportRowError は行の内容の誤り。それ以外のエラーはインポートを中断する
+type userImportRowError struct {
+	msg string
+}
+
+func (e *userImportRowError) Error() string { return e.msg }
+
+func rowErrorf(format string, args ...interface{}) error {
+	return &userImportRowError{msg: fmt.Sprintf(format, args...)}
+}
+
+// importRecord は 1 件を検証して保存し、作成した場合は true を返す。
+func (s *UserTransferService) importRecord(ctx context.Context, tenant *model.Tenant, rec *UserRecord, state *userImportState, dryRun bool) (bool, error) {
+	if rec.LoginID == nil || *rec.LoginID == "" {
+		return false, rowErrorf("login_id is required")
+	}
+	loginID := *rec.LoginID
+	if len(loginID) > 255 || strings.TrimSpace(loginID) != loginID {
+		return false, rowErrorf("invalid login_id")
+	}
+	if state.loginIDs[loginID] {
+		return false, rowErrorf("login_id appears more than once in the file")
+	}
+	state.loginIDs[loginID] = true
+
+	user, err := s.userStore.FindByTenantAndLoginID(ctx, tenant.ID, loginID)
+	if err != nil {
+		return false, fmt.Errorf("failed to find user: %w", err)
+	}
+	create := user == nil
+	if create {
+		if rec.Email == nil || *rec.Email == "" {
+			return false, rowErrorf("email is required")
+		}
+		user = &model.User{TenantID: tenant.ID, LoginID: loginID, Status: "active"}
+	} else if user.Status == "deleted" {
+		return false, rowErrorf("login_id belongs to a deleted user")
+	}
+	before := *user
+
+	if rec.Email != nil && *rec.Email != "" {
+		addr, err := mail.ParseAddress(*rec.Email)
+		if err != nil || addr.Address != *rec.Email || len(*rec.Email) > 255 {
+			return false, rowErrorf("invalid email")
+		}
+		user.Email = *rec.Email
+	}
+	if !create && !strings.EqualFold(user.Email, before.Email) {
+		user.EmailVerified = false
+	}
+	if rec.EmailVerified != nil {
+		user.EmailVerified = *rec.EmailVerified
+	}
+
+	if rec.Status != nil && *rec.Status != "" {
+		if *rec.Status != "active" && *rec.Status != "disabled" {
+			return false, rowErrorf("status must be active or disabled")
+		}
+		user.Status = *rec.Status
+	}
+
+	fields := []struct {
+		name     string
+		value    *string
+		target   **string
+		maxLen   int
+		validate func(string) bool
+	}{
+		{"name", rec.Name, &user.Name, 255, nil},
+		{"given_name", rec.GivenName, &user.GivenName, 255, nil},
+		{"family_name", rec.FamilyName, &user.FamilyName, 255, nil},
+		{"middle_name", rec.MiddleName, &user.MiddleName, 255, nil},
+		{"nickname", rec.Nickname, &user.Nickname, 255, nil},
+		{"preferred_username", rec.PreferredUsername, &user.PreferredUsername, 255, nil},
+		{"profile", rec.Profile, &user.Profile, 2048, isHTTPURL},
+		{"picture", rec.Picture, &user.Picture, 2048, isHTTPURL},
+		{"website", rec.Website, &user.Website, 2048, isHTTPURL},
+		{"gender", rec.Gender, &user.Gender, 63, nil},
+		{"birthdate", rec.Birthdate, &user.Birthdate, 10, isValidBirthdate},
+		{"zoneinfo", rec.Zoneinfo, &user.Zoneinfo, 63, zoneinfoRegex.MatchString},
+		{"locale", rec.Locale, &user.Locale, 35, localeRegex.MatchString},
+		{"phone_number", rec.PhoneNumber, &user.PhoneNumber, 32, phoneNumberRegex.MatchString},
+		{"external_id", rec.ExternalID, &user.ExternalID, 255, nil},
+	}
+	for _, f := range fields {
+		if f.value == nil {
+			continue
+		}
+		if *f.value == "" {
+			*f.target = nil
+			continue
+		}
+		if len(*f.value) > f.maxLen || (f.validate != nil && !f.validate(*f.value)) {
+			return false, rowErrorf("invalid %s", f.name)
+		}
+		v := *f.value
+		*f.target = &v
+	}
+
+	// 電話番号を変更した場合は検証済みフラグを引き継がない
+	if !equalStringPtr(user.PhoneNumber, before.PhoneNumber) {
+		user.PhoneNumberVerified = false
+	}
+	if rec.PhoneNumberVerified != nil {
+		if *rec.PhoneNumberVerified && user.PhoneNumber == nil {
+			return false, rowErrorf("phone_number_verified requires phone_number")
+		}
+		user.PhoneNumberVerified = *rec.PhoneNumberVerified
+	}
+	if user.PhoneNumber == nil {
+		user.PhoneNumberVerified = false
+	}
+	if !user.PhoneNumberVerified {
+		user.SMSMFAEnabled = false
+	}
+
+	passwordHash, algorithm := "", ""
+	if rec.PasswordHash != nil && *rec.PasswordHash != "" {
+		if len(*rec.PasswordHash) > 512 {
+			return false, rowErrorf("invalid password_hash")
+		}
+		algorithm, err = s.passwordHashAlgorithm(*rec.PasswordHash)
+		if err != nil {
+			return false, rowErrorf("invalid password_hash: %v", err)
+		}
+		passwordHash = *rec.PasswordHash
+	}
+
+	// メールアドレスはテナント内で一意 (大文字小文字を区別しない)。ファイル内の後の行で解放される場合も重複として扱う
+	email := strings.ToLower(user.Email)
+	if owner, ok := state.emails[email]; ok && owner != loginID {
+		return false, rowErrorf("email is used by another row in the file")
+	}
+	state.emails[email] = loginID
+	if create || !strings.EqualFold(user.Email, before.Email) {
+		existing, err := s.userStore.FindByTenantAndEmail(ctx, tenant.ID, user.Email)
+		if err != nil {
+			return false, fmt.Errorf("failed to find user by email: %w", err)
+		}
+		if existing != nil && existing.ID != user.ID {
+			return false, rowErrorf("email is already in use")
+		}
+	}
+
+	if dryRun {
+		return create, nil
+	}
+	if err := s.userStore.SaveImported(ctx, user, passwordHash, algorithm); err != nil {
+		return false, fmt.Errorf("failed to save user %s: %w", loginID, err)
+	}
+	if !create && before.Status == "active" && user.Status == "disabled" {
+		if _, _, _, err := s.userRevoker.RevokeUser(ctx, user.ID); err != nil {
+			return false, err
+		}
+	}
+	return create, nil
+}
+
+// Export はテナントの削除されていないユーザーを書き出し、件数を返す。
+// includePasswordHash が false の場合は password_hash を書き出さない
+func (s *UserTransferService) Export(ctx context.Context, tenant *model.Tenant, w *UserRecordWriter, includePasswordHash bool) (int, error) {
+	count := 0
+	after := uuid.Nil
+	for {
+		users, err := s.userStore.ListForExport(ctx, tenant.ID, after, userExportBatchSize)
+		if err != nil {
+			return count, fmt.Errorf("failed to list users: %w", err)
+		}
+		for i := range users {
+			if err := w.Write(toUserRecord(&users[i], includePasswordHash)); err != nil {
+				return count, err
+			}
+			count++
+		}
+		if len(users) < userExportBatchSize {
+			return count, w.Flush()
+		}
+		after = users[len(users)-1].ID
+	}
+}
+
+func toUserRecord(u *model.User, includePasswordHash bool) *UserRecord {
+	rec := &UserRecord{
+		LoginID:             &u.LoginID,
+		Email:               &u.Email,
+		EmailVerified:       &u.EmailVerified,
+		Status:              &u.Status,
+		Name:                u.Name,
+		GivenName:           u.GivenName,
+		FamilyName:          u.FamilyName,
+		MiddleName:          u.MiddleName,
+		Nickname:            u.Nickname,
+		PreferredUsername:   u.PreferredUsername,
+		Profile:             u.Profile,
+		Picture:             u.Picture,
+		Website:             u.Website,
+		Gender:              u.Gender,
+		Birthdate:           u.Birthdate,
+		Zoneinfo:            u.Zoneinfo,
+		Locale:              u.Locale,
+		PhoneNumber:         u.PhoneNumber,
+		PhoneNumberVerified: &u.PhoneNumberVerified,
+		ExternalID:          u.ExternalID,
+	}
+	if includePasswordHash {
+		for _, cred := range u.Credentials {
+			if cred.Type == "password" && cred.PasswordCredential != nil {
+				rec.PasswordHash = &cred.PasswordCredential.PasswordHash
+			}
+		}
+	}
+	return rec
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,113 @@
+package management
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// UserTransferHandler はユーザーの一括インポート・エクスポートのエンドポイントを処理する。
+type UserTransferHandler struct {
+	transferSvc *UserTransferService
+	tenantStore TenantStore
+}
+
+// NewUserTransferHandler は UserTransferHandler を生成する。
+func NewUserTransferHandler(transferSvc *UserTransferService, tenantStore TenantStore) *UserTransferHandler {
+	return &UserTransferHandler{transferSvc: transferSvc, tenantStore: tenantStore}
+}
+
+// HandleImport は POST /management/v1/tenants/:tenant_id/users/import を処理する。
+// リクエストボディの CSV または JSONL (format クエリ) を読み込みながら 1 行ずつ取り込み、結果を返す。
+// dry_run=true の場合は検証のみ行う。
+func (h *UserTransferHandler) HandleImport(c echo.Context) error {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+	format, err := userFileFormat(c)
+	if err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	reader, err := NewUserRecordReader(c.Request().Body, format)
+	if err != nil {
+		return badRequest(c, err.Error())
+	}
+	result, err := h.transferSvc.Import(c.Request().Context(), tenant, reader, c.QueryParam("dry_run") == "true")
+	if err != nil {
+		if errors.Is(err, ErrInvalidUserFile) {
+			return badRequest(c, err.Error())
+		}
+		c.Logger().Errorf("failed to import users: %v", err)
+		return serverError(c)
+	}
+	return c.JSON(http.StatusOK, result)
+}
+
+// HandleExport は GET /management/v1/tenants/:tenant_id/users/export を処理する。
+// インポートと同じ形式で書き出す。password_hash は include_password_hash=true の場合のみ含める。
+func (h *UserTransferHandler) HandleExport(c echo.Context) error {
+	tenant, err := h.findTenant(c)
+	if err != nil || tenant == nil {
+		return err
+	}
+	format, err := userFileFormat(c)
+	if err != nil {
+		return badRequest(c, err.Error())
+	}
+
+	contentType := "text/csv; charset=utf-8"
+	if format == UserFileFormatJSONL {
+		contentType = "application/x-ndjson"
+	}
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	res.Header().Set(echo.HeaderContentDisposition, fmt.Sprintf(`attachment; filename="users-%s.%s"`, tenant.Code, format))
+	res.WriteHeader(http.StatusOK)
+
+	writer, err := NewUserRecordWriter(res, format)
+	if err == nil {
+		_, err = h.transferSvc.Export(c.Request().Context(), tenant, writer, c.QueryParam("include_password_hash") == "true")
+	}
+	if err != nil {
+		// ステータスは送信済みのため、ログに残して途中で打ち切る
+		c.Logger().Errorf("failed to export users: %v", err)
+	}
+	return nil
+}
+
+// userFileFormat は format クエリのファイル形式を返す。省略時は csv。
+func userFileFormat(c echo.Context) (string, error) {
+	switch format := c.QueryParam("format"); format {
+	case "", UserFileFormatCSV:
+		return UserFileFormatCSV, nil
+	case UserFileFormatJSONL:
+		return UserFileFormatJSONL, nil
+	default:
+		return "", fmt.Errorf("format must be csv or jsonl")
+	}
+}
+
+// findTenant はパスの :tenant_id でテナントを検索する。見つからない場合はエラーレスポンスを書き込んで (nil, nil) を返す。
+func (h *UserTransferHandler) findTenant(c echo.Context) (*model.Tenant, error) {
+	tenantID, err := uuid.Parse(c.Param("tenant_id"))
+	if err != nil {
+		return nil, badRequest(c, "invalid tenant_id format")
+	}
+
+	tenant, err := h.tenantStore.FindByID(c.Request().Context(), tenantID)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, serverError(c)
+	}
+	if tenant == nil {
+		return nil, notFound(c, "tenant not found")
+	}
+	return tenant, nil
+}
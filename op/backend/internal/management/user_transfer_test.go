@@ -0,0 +1,357 @@
+package management
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// readAllRecords は全ての行を読み込み、行番号 → ユーザー または エラー を返す
+func readAllRecords(t *testing.T, r *UserRecordReader) (map[int]*UserRecord, map[int]error) {
+	t.Helper()
+	records, errs := map[int]*UserRecord{}, map[int]error{}
+	for {
+		rec, line, err := r.Next()
+		if err == io.EOF {
+			return records, errs
+		}
+		var recErr *UserRecordError
+		if errors.As(err, &recErr) {
+			errs[line] = err
+			continue
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		records[line] = rec
+	}
+}
+
+func strPtr(s string) *string { return &s }
+
+func boolPtr(b bool) *bool { return &b }
+
+func TestUserRecordReaderCSV(t *testing.T) {
+	file := "\xef\xbb\xbf login_id ,email,email_verified,name\n" +
+		"alice,alice@example.com,true,Alice\n" +
+		"bob,bob@example.com,,\"Bob\nSmith\"\n" +
+		"carol,carol@example.com,yes,Carol\n" +
+		"dave,dave@example.com\n" +
+		"erin,erin@example.com,FALSE,\n"
+	r, err := NewUserRecordReader(strings.NewReader(file), UserFileFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, errs := readAllRecords(t, r)
+
+	want := map[int]*UserRecord{
+		2: {LoginID: strPtr("alice"), Email: strPtr("alice@example.com"), EmailVerified: boolPtr(true), Name: strPtr("Alice")},
+		// 真偽値の空のセルは変更しない
+		3: {LoginID: strPtr("bob"), Email: strPtr("bob@example.com"), Name: strPtr("Bob\nSmith")},
+		// 空の文字列のセルは未設定に戻す
+		7: {LoginID: strPtr("erin"), Email: strPtr("erin@example.com"), EmailVerified: boolPtr(false), Name: strPtr("")},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v", records)
+	}
+	if len(errs) != 2 || errs[5] == nil || errs[6] == nil {
+		t.Errorf("errors = %v", errs)
+	}
+}
+
+func TestNewUserRecordReaderErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		format string
+		file   string
+	}{
+		{name: "空のファイル", format: UserFileFormatCSV, file: ""},
+		{name: "未知の列", format: UserFileFormatCSV, file: "login_id,password\n"},
+		{name: "列の重複", format: UserFileFormatCSV, file: "login_id,email,email\n"},
+		{name: "login_id の列がない", format: UserFileFormatCSV, file: "email\n"},
+		{name: "未知の形式", format: "xml", file: "<users/>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewUserRecordReader(strings.NewReader(tt.file), tt.format); !errors.Is(err, ErrInvalidUserFile) {
+				t.Errorf("err = %v", err)
+			}
+		})
+	}
+}
+
+func TestUserRecordReaderJSONL(t *testing.T) {
+	file := `{"login_id":"alice","email_verified":true,"phone_number":""}` + "\n" +
+		"\n" +
+		`{"login_id":"bob","password":"x"}` + "\n" +
+		`{"login_id":` + "\n" +
+		`  {"login_id":"carol"}  ` + "\n"
+	r, err := NewUserRecordReader(strings.NewReader(file), UserFileFormatJSONL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, errs := readAllRecords(t, r)
+	want := map[int]*UserRecord{
+		1: {LoginID: strPtr("alice"), EmailVerified: boolPtr(true), PhoneNumber: strPtr("")},
+		5: {LoginID: strPtr("carol")},
+	}
+	if !reflect.DeepEqual(records, want) {
+		t.Errorf("records = %v", records)
+	}
+	if len(errs) != 2 || errs[3] == nil || errs[4] == nil {
+		t.Errorf("errors = %v", errs)
+	}
+
+	// 上限を超える行はファイル全体のエラー
+	r, _ = NewUserRecordReader(strings.NewReader(`{"login_id":"`+strings.Repeat("a", maxUserFileLineBytes)+`"}`), UserFileFormatJSONL)
+	if _, _, err := r.Next(); !errors.Is(err, ErrInvalidUserFile) {
+		t.Errorf("long line: err = %v", err)
+	}
+}
+
+func TestUserRecordRoundTrip(t *testing.T) {
+	phone := "+819000000000"
+	user := &model.User{
+		LoginID: "alice", Email: "alice@example.com", EmailVerified: true, Status: "active",
+		Name: strPtr("Alice, \"A\"\nLiddell"), PhoneNumber: &phone, PhoneNumberVerified: true,
+		Credentials: []model.Credential{{Type: "password", PasswordCredential: &model.PasswordCredential{PasswordHash: "$argon2id$v=19$m=1024,t=1,p=1$c2FsdA$aGFzaA"}}},
+	}
+	for _, format := range []string{UserFileFormatCSV, UserFileFormatJSONL} {
+		t.Run(format, func(t *testing.T) {
+			var buf bytes.Buffer
+			w, err := NewUserRecordWriter(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Write(toUserRecord(user, true)); err != nil {
+				t.Fatal(err)
+			}
+			if err := w.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			r, err := NewUserRecordReader(&buf, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			rec, _, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *rec.LoginID != "alice" || *rec.Name != *user.Name || !*rec.PhoneNumberVerified || *rec.PasswordHash != user.Credentials[0].PasswordCredential.PasswordHash {
+				t.Errorf("record = %+v", rec)
+			}
+		})
+	}
+
+	if rec := toUserRecord(user, false); rec.PasswordHash != nil {
+		t.Error("password hash exported")
+	}
+}
+
+// fakeUserTransferStore は users テーブルに加えて、保存したパスワードのハッシュをメモリに持つ
+type fakeUserTransferStore struct {
+	*fakeUserStore
+	passwords map[uuid.UUID]string
+}
+
+func (f *fakeUserTransferStore) SaveImported(_ context.Context, user *model.User, passwordHash, algorithm string) error {
+	if user.ID == uuid.Nil {
+		user.ID = uuid.New()
+	}
+	copied := *user
+	f.users[user.ID] = &copied
+	if passwordHash != "" {
+		f.passwords[user.ID] = algorithm + ":" + passwordHash
+	}
+	return nil
+}
+
+func (f *fakeUserTransferStore) ListForExport(_ context.Context, tenantID, after uuid.UUID, limit int) ([]model.User, error) {
+	var users []model.User
+	for _, u := range f.users {
+		if u.TenantID == tenantID && u.Status != "deleted" {
+			users = append(users, *u)
+		}
+	}
+	sort.Slice(users, func(i, j int) bool { return users[i].ID.String() < users[j].ID.String() })
+	var page []model.User
+	for _, u := range users {
+		if u.ID.String() > after.String() && len(page) < limit {
+			page = append(page, u)
+		}
+	}
+	return page, nil
+}
+
+// fakeUserRevoker はセッションとトークンを失効させたユーザーを記録する
+type fakeUserRevoker struct {
+	revoked []uuid.UUID
+}
+
+func (f *fakeUserRevoker) RevokeAll(context.Context) (int64, error) { return 0, nil }
+
+func (f *fakeUserRevoker) RevokeByTenantID(context.Context, uuid.UUID) (int64, error) { return 0, nil }
+
+func (f *fakeUserRevoker) RevokeByUserID(_ context.Context, userID uuid.UUID) (int64, error) {
+	f.revoked = append(f.revoked, userID)
+	return 1, nil
+}
+
+type userImportFixture struct {
+	svc     *UserTransferService
+	tenant  *model.Tenant
+	store   *fakeUserTransferStore
+	revoker *fakeUserRevoker
+	bob     *model.User
+}
+
+func newUserImportFixture() *userImportFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	phone := "+819000000000"
+	bob := &model.User{
+		ID: uuid.New(), TenantID: tenant.ID, LoginID: "bob", Email: "bob@example.com", EmailVerified: true, Status: "active",
+		PhoneNumber: &phone, PhoneNumberVerified: true, SMSMFAEnabled: true,
+	}
+	deleted := &model.User{ID: uuid.New(), TenantID: tenant.ID, LoginID: "gone", Email: "gone@example.com", Status: "deleted"}
+	f := &userImportFixture{
+		tenant:  tenant,
+		store:   &fakeUserTransferStore{fakeUserStore: newFakeUserStore(bob, deleted), passwords: map[uuid.UUID]string{}},
+		revoker: &fakeUserRevoker{},
+		bob:     bob,
+	}
+	f.svc = NewUserTransferService(f.store, NewUserRevoker(f.revoker, f.revoker, f.revoker), crypto.PasswordHashAlgorithm)
+	return f
+}
+
+func (f *userImportFixture) importCSV(t *testing.T, file string, dryRun bool) *UserImportResult {
+	t.Helper()
+	r, err := NewUserRecordReader(strings.NewReader(file), UserFileFormatCSV)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := f.svc.Import(context.Background(), f.tenant, r, dryRun)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+const testImportBcryptHash = "$2a$06$If6bvum7DFjUnE9p2uDeDu0YHzrHM6tf.iqN8.yx.jNN1ILEf7h0i"
+
+func TestUserImport(t *testing.T) {
+	f := newUserImportFixture()
+	file := "login_id,email,status,phone_number,password_hash\n" +
+		"alice,alice@example.com,,," + testImportBcryptHash + "\n" +
+		"bob,BOB@example.com,disabled,+819011111111,\n"
+	result := f.importCSV(t, file, false)
+	if result.Total != 2 || result.Created != 1 || result.Updated != 1 || result.Failed != 0 {
+		t.Fatalf("result = %+v", result)
+	}
+
+	alice, _ := f.store.FindByTenantAndLoginID(context.Background(), f.tenant.ID, "alice")
+	if alice == nil || alice.Status != "active" || alice.EmailVerified || f.store.passwords[alice.ID] != crypto.PasswordAlgorithmBcrypt+":"+testImportBcryptHash {
+		t.Errorf("alice = %+v, password = %s", alice, f.store.passwords[alice.ID])
+	}
+
+	// メールアドレスの大文字小文字だけの変更は確認済みのまま。電話番号を変えたら確認済みと SMS の2要素認証を引き継がない
+	bob := f.store.users[f.bob.ID]
+	if bob.Email != "BOB@example.com" || !bob.EmailVerified || bob.Status != "disabled" || *bob.PhoneNumber != "+819011111111" || bob.PhoneNumberVerified || bob.SMSMFAEnabled {
+		t.Errorf("bob = %+v", bob)
+	}
+	if _, ok := f.store.passwords[bob.ID]; ok {
+		t.Error("bob's password replaced")
+	}
+	// 無効にしたユーザーのセッションとトークンを失効させる
+	if len(f.revoker.revoked) != 3 || f.revoker.revoked[0] != bob.ID {
+		t.Errorf("revoked = %v", f.revoker.revoked)
+	}
+}
+
+func TestUserImportEmailChangeResetsVerification(t *testing.T) {
+	f := newUserImportFixture()
+	result := f.importCSV(t, "login_id,email\nbob,robert@example.com\n", false)
+	if result.Updated != 1 || f.store.users[f.bob.ID].EmailVerified {
+		t.Errorf("result = %+v, bob = %+v", result, f.store.users[f.bob.ID])
+	}
+	if len(f.revoker.revoked) != 0 {
+		t.Errorf("revoked = %v", f.revoker.revoked)
+	}
+}
+
+func TestUserImportRowErrors(t *testing.T) {
+	f := newUserImportFixture()
+	file := "login_id,email,email_verified,status,birthdate,phone_number,phone_number_verified,password_hash\n" +
+		"alice,alice@example.com,,,,,,\n" + // 2: 作成
+		"alice,alice2@example.com,,,,,,\n" + // 3: login_id の重複
+		"carol,ALICE@example.com,,,,,,\n" + // 4: ファイル内でメールアドレスの重複
+		"dave,bob@example.com,,,,,,\n" + // 5: 既存のユーザーとメールアドレスの重複
+		"gone,gone@example.com,,,,,,\n" + // 6: 削除済みのユーザー
+		"erin,,,,,,,\n" + // 7: 作成にメールアドレスがない
+		"frank,frank@example.com,,locked,,,,\n" + // 8: 不正な status
+		"grace,grace@example.com,,,1999-02-29,,,\n" + // 9: 存在しない日付
+		"heidi,heidi@example.com,,,,,true,\n" + // 10: 電話番号なしで確認済み
+		"ivan,ivan@example.com,,,,,,$pbkdf2-sha1$1000$c2FsdA$aGFzaA\n" + // 11: 未対応のハッシュ
+		"judy,Judy <judy@example.com>,,,,,,\n" + // 12: 不正なメールアドレス
+		" kim,kim@example.com,,,,,,\n" + // 13: 前後に空白のある login_id
+		",nobody@example.com,,,,,,\n" + // 14: login_id がない
+		"mallory,mallory@example.com,maybe,,,,,\n" + // 15: 真偽値でない
+		"bob,bob@example.com,,,,090-0000-0000,,\n" // 16: E.164 でない電話番号
+	for _, dryRun := range []bool{true, false} {
+		result := f.importCSV(t, file, dryRun)
+		if result.DryRun != dryRun || result.Total != 15 || result.Created != 1 || result.Failed != 14 {
+			t.Fatalf("result = %+v", result)
+		}
+		var lines []int
+		for _, e := range result.Errors {
+			lines = append(lines, e.Line)
+		}
+		if want := []int{3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}; !reflect.DeepEqual(lines, want) {
+			t.Errorf("lines = %v", lines)
+		}
+		if result.Errors[0].LoginID != "alice" || result.Errors[0].Error != "login_id appears more than once in the file" {
+			t.Errorf("errors[0] = %+v", result.Errors[0])
+		}
+		if dryRun && len(f.store.users) != 2 {
+			t.Errorf("dry run saved users: %d", len(f.store.users))
+		}
+	}
+	if len(f.store.users) != 3 || *f.store.users[f.bob.ID].PhoneNumber != "+819000000000" {
+		t.Errorf("users = %d", len(f.store.users))
+	}
+}
+
+func TestUserImportErrorsTruncated(t *testing.T) {
+	f := newUserImportFixture()
+	var b strings.Builder
+	b.WriteString("login_id,email\n")
+	for i := 0; i < maxUserImportErrors+5; i++ {
+		b.WriteString("gone,gone@example.com\n")
+	}
+	result := f.importCSV(t, b.String(), true)
+	if result.Failed != maxUserImportErrors+5 || len(result.Errors) != maxUserImportErrors || !result.ErrorsTruncated {
+		t.Errorf("failed = %d, errors = %d, truncated = %v", result.Failed, len(result.Errors), result.ErrorsTruncated)
+	}
+}
+
+func TestUserExport(t *testing.T) {
+	f := newUserImportFixture()
+	var buf bytes.Buffer
+	w, _ := NewUserRecordWriter(&buf, UserFileFormatJSONL)
+	n, err := f.svc.Export(context.Background(), f.tenant, w, false)
+	if err != nil || n != 1 {
+		t.Fatalf("n = %d, err = %v", n, err)
+	}
+	if got := buf.String(); !strings.Contains(got, `"login_id":"bob"`) || strings.Contains(got, "gone") {
+		t.Errorf("exported %s", got)
+	}
+}
@@ -0,0 +1,87 @@
+package migrationhook
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	// breakerFailureThreshold はサーキットを開く連続した失敗の回数
+	breakerFailureThreshold = 5
+	// breakerOpenDuration はサーキットを開いてから試しに 1 回呼び出すまでの期間
+	breakerOpenDuration = 30 * time.Second
+)
+
+// ErrCircuitOpen は移行元の失敗が続いているため呼び出さなかったことを示す
+var ErrCircuitOpen = errors.New("user migration hook circuit is open")
+
+// breaker は 1 つの Webhook のサーキットブレーカーの状態
+type breaker struct {
+	failures  int
+	openUntil time.Time
+	// probing は開いた後に試しの呼び出しを 1 つだけ通している間 true
+	probing bool
+}
+
+// breakers は Webhook ごとのサーキットブレーカー。成功した Webhook の状態は持たない
+type breakers struct {
+	mu    sync.Mutex
+	state map[uuid.UUID]*breaker
+}
+
+func newBreakers() *breakers {
+	return &breakers{state: map[uuid.UUID]*breaker{}}
+}
+
+// allow は呼び出してよいか判定する。開いている間は ErrCircuitOpen を返し、
+// 期間が過ぎたら次の 1 回だけ通す (half-open)。その結果で閉じるか再び開く
+func (b *breakers) allow(id uuid.UUID) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.state[id]
+	if s == nil || s.failures < breakerFailureThreshold {
+		return nil
+	}
+	if time.Now().Before(s.openUntil) || s.probing {
+		return ErrCircuitOpen
+	}
+	s.probing = true
+	return nil
+}
+
+// success は呼び出しが成功したことを記録し、状態を消す
+func (b *breakers) success(id uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.state, id)
+}
+
+// failure は呼び出しが失敗したことを記録する。失敗が続いた場合は開く
+func (b *breakers) failure(id uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := b.state[id]
+	if s == nil {
+		s = &breaker{}
+		b.state[id] = s
+	}
+	s.probing = false
+	s.failures++
+	if s.failures >= breakerFailureThreshold {
+		s.openUntil = time.Now().Add(breakerOpenDuration)
+	}
+}
+
+// release は結果が分からない呼び出し (呼び出し元の中断) を記録しない。試しの呼び出しだった場合は次の呼び出しで改めて試す
+func (b *breakers) release(id uuid.UUID) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if s := b.state[id]; s != nil {
+		s.probing = false
+	}
+}
@@ -0,0 +1,84 @@
+package migrationhook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func TestBreakers(t *testing.T) {
+	b := newBreakers()
+	id, other := uuid.New(), uuid.New()
+
+	// 閾値に達するまでは閉じている
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		if err := b.allow(id); err != nil {
+			t.Fatalf("failure %d: %v", i, err)
+		}
+		b.failure(id)
+	}
+	if err := b.allow(id); err != nil {
+		t.Fatal(err)
+	}
+	b.failure(id)
+
+	// 開いている間は呼び出さない。他の Webhook には影響しない
+	if err := b.allow(id); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("open: err = %v", err)
+	}
+	if err := b.allow(other); err != nil {
+		t.Errorf("other hook: %v", err)
+	}
+
+	// 期間が過ぎたら 1 回だけ試す (half-open)
+	b.state[id].openUntil = time.Now().Add(-time.Second)
+	if err := b.allow(id); err != nil {
+		t.Fatalf("half-open: %v", err)
+	}
+	if err := b.allow(id); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("second call while probing: err = %v", err)
+	}
+
+	// 試しの呼び出しが失敗したら再び開く
+	b.failure(id)
+	if err := b.allow(id); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("after failed probe: err = %v", err)
+	}
+	if until := b.state[id].openUntil; time.Until(until) < breakerOpenDuration-time.Second {
+		t.Errorf("openUntil = %v", until)
+	}
+
+	// 中断された試しの呼び出しは記録せず、次の呼び出しで改めて試す
+	b.state[id].openUntil = time.Now().Add(-time.Second)
+	if err := b.allow(id); err != nil {
+		t.Fatal(err)
+	}
+	b.release(id)
+	if err := b.allow(id); err != nil {
+		t.Fatalf("after release: %v", err)
+	}
+
+	// 成功したら閉じる
+	b.success(id)
+	if err := b.allow(id); err != nil {
+		t.Errorf("after success: %v", err)
+	}
+	if _, ok := b.state[id]; ok {
+		t.Error("state kept after success")
+	}
+}
+
+func TestBreakersSuccessResetsFailures(t *testing.T) {
+	b := newBreakers()
+	id := uuid.New()
+	for i := 0; i < breakerFailureThreshold-1; i++ {
+		b.failure(id)
+	}
+	b.success(id)
+	b.failure(id)
+	if err := b.allow(id); err != nil {
+		t.Errorf("err = %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package migrationhook は移行元のシステムで login_id とパスワードを検証する Webhook の呼び出し (auth.UserMigrationClient) を実装する。
+//
+// リクエストは https の POST で、ボディは {"tenant", "login_id", "password"} の JSON。
+// 移行元は検証できた場合に 200 と {"user": {...}} (OIDC の標準クレーム名のプロフィール) を返し、
+// パスワードが一致しない場合やユーザーがいない場合は 401 または 404 を返す
+package migrationhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// maxResponseBytes は移行元から読み込むレスポンスの上限
+	maxResponseBytes = 64 * 1024
+	// TimestampHeader は署名した時刻 (UNIX 秒)。移行元はリプレイを防ぐために古いリクエストを拒否する
+	TimestampHeader = "X-Migration-Timestamp"
+	// SignatureHeader は "sha256=" に続けて HMAC-SHA256(secret, timestamp + "." + body) の 16 進数を付けたもの
+	SignatureHeader = "X-Migration-Signature"
+)
+
+// ErrHook は移行元との通信や移行元が返した値の検証に失敗したことを示す
+var ErrHook = errors.New("user migration hook error")
+
+// checkRequest は移行元に送るリクエストボディ
+type checkRequest struct {
+	Tenant   string `json:"tenant"`
+	LoginID  string `json:"login_id"`
+	Password string `json:"password"`
+}
+
+// checkResponse は検証できた場合のレスポンスボディ。user の値は文字列 (email_verified・phone_number_verified は真偽値)
+type checkResponse struct {
+	User map[string]interface{} `json:"user"`
+}
+
+// Client は移行元のエンドポイントを呼び出す。Webhook ごとにサーキットブレーカーの状態を持ち、
+// 失敗が続いた場合はしばらく呼び出さずに ErrCircuitOpen を返す
+type Client struct {
+	breakers *breakers
+}
+
+// NewClient は Client を生成する。
+func NewClient() *Client {
+	return &Client{breakers: newBreakers()}
+}
+
+// VerifyPassword は移行元で login_id とパスワードを検証し、検証できた場合はユーザーのプロフィールを返す。
+// パスワードが一致しない場合とユーザーがいない場合は (nil, nil) を返す
+func (c *Client) VerifyPassword(ctx context.Context, req *model.UserMigrationRequest, password string) (*model.MigratedUser, error) {
+	if err := c.breakers.allow(req.HookID); err != nil {
+		return nil, err
+	}
+	user, err := c.call(ctx, req, password)
+	switch {
+	case err == nil:
+		c.breakers.success(req.HookID)
+	case ctx.Err() != nil:
+		// 呼び出し元がリクエストを中断した場合は移行元の失敗として数えない
+		c.breakers.release(req.HookID)
+	default:
+		c.breakers.failure(req.HookID)
+	}
+	return user, err
+}
+
+func (c *Client) call(ctx context.Context, req *model.UserMigrationRequest, password string) (*model.MigratedUser, error) {
+	httpClient, err := newHTTPClient(req)
+	if err != nil {
+		return nil, err
+	}
+	defer httpClient.CloseIdleConnections()
+
+	body, err := json.Marshal(checkRequest{Tenant: req.TenantCode, LoginID: req.LoginID, Password: password})
+	if err != nil {
+		return nil, err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, req.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHook, err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Accept", "application/json")
+	if req.AuthMethod == model.UserMigrationHookAuthHMAC {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		httpReq.Header.Set(TimestampHeader, timestamp)
+		httpReq.Header.Set(SignatureHeader, "sha256="+Sign(req.HMACSecret, timestamp, body))
+	}
+
+	res, err := httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrHook, err)
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusOK:
+	case http.StatusUnauthorized, http.StatusNotFound:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%w: unexpected status %d", ErrHook, res.StatusCode)
+	}
+
+	var parsed checkResponse
+	if err := json.NewDecoder(io.LimitReader(res.Body, maxResponseBytes)).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("%w: invalid response: %v", ErrHook, err)
+	}
+	if parsed.User == nil {
+		return nil, fmt.Errorf("%w: response has no user", ErrHook)
+	}
+	return toMigratedUser(parsed.User), nil
+}
+
+// Sign はリクエストボディの署名 HMAC-SHA256(secret, timestamp + "." + body) を 16 進数で返す。
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// newHTTPClient は Webhook の設定で HTTP クライアントを作る。
+// パスワードを送るため、リダイレクトには従わない (307 / 308 ではボディが転送先に再送される)
+func newHTTPClient(req *model.UserMigrationRequest) (*http.Client, error) {
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if req.CACertificate != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(req.CACertificate)) {
+			return nil, fmt.Errorf("%w: invalid ca certificate", ErrHook)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if req.AuthMethod == model.UserMigrationHookAuthMTLS {
+		cert, err := tls.X509KeyPair([]byte(req.ClientCertificate), []byte(req.ClientPrivateKey))
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid client certificate: %v", ErrHook, err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &http.Client{
+		Timeout:   req.Timeout,
+		Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: tlsConfig},
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}, nil
+}
+
+// toMigratedUser はレスポンスの user から文字列の属性と確認済みフラグを取り出す。型が違う値は使わない
+func toMigratedUser(user map[string]interface{}) *model.MigratedUser {
+	migrated := &model.MigratedUser{Attributes: map[string]string{}}
+	for _, attr := range model.FederatedUserAttributes {
+		if attr == model.FederatedLoginIDAttribute {
+			continue
+		}
+		if v, ok := user[attr].(string); ok {
+			migrated.Attributes[attr] = v
+		}
+	}
+	migrated.EmailVerified, _ = user["email_verified"].(bool)
+	migrated.PhoneNumberVerified, _ = user["phone_number_verified"].(bool)
+	return migrated
+}
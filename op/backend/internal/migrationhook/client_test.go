@@ -0,0 +1,279 @@
+package migrationhook
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// testHook は移行元のエンドポイント。handler を差し替えて応答を変える
+type testHook struct {
+	*httptest.Server
+	handler atomic.Value
+	calls   atomic.Int32
+}
+
+func newTestHook(t *testing.T, clientCA *x509.CertPool) *testHook {
+	h := &testHook{}
+	h.Server = httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		h.calls.Add(1)
+		h.handler.Load().(http.HandlerFunc)(w, r)
+	}))
+	if clientCA != nil {
+		h.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCA}
+	}
+	h.StartTLS()
+	t.Cleanup(h.Close)
+	h.respond(http.StatusOK, `{"user":{"email":"alice@example.com"}}`)
+	return h
+}
+
+func (h *testHook) respond(status int, body string) {
+	h.handler.Store(respondWith(status, body))
+}
+
+func respondWith(status int, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func (h *testHook) request() *model.UserMigrationRequest {
+	return &model.UserMigrationRequest{
+		HookID:        uuid.New(),
+		URL:           h.URL + "/verify",
+		AuthMethod:    model.UserMigrationHookAuthHMAC,
+		HMACSecret:    "hook-secret",
+		CACertificate: string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: h.Certificate().Raw})),
+		Timeout:       5 * time.Second,
+		TenantCode:    "demo",
+		LoginID:       "alice",
+	}
+}
+
+func TestSign(t *testing.T) {
+	// python3 -c 'import hmac,hashlib; print(hmac.new(b"hook-secret", b"1700000000." + b"{\"tenant\":\"demo\"}", hashlib.sha256).hexdigest())'
+	if got := Sign("hook-secret", "1700000000", []byte(`{"tenant":"demo"}`)); got != "d0ae8addc26e0d1e9cf1fe5fd859f519d0fb0c1424cfb07e7e9fcd9976b5260a" {
+		t.Errorf("Sign = %s", got)
+	}
+}
+
+func TestVerifyPassword(t *testing.T) {
+	h := newTestHook(t, nil)
+	req := h.request()
+	var received checkRequest
+	h.handler.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		timestamp := r.Header.Get(TimestampHeader)
+		if r.Method != http.MethodPost || r.URL.Path != "/verify" || r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("request = %s %s", r.Method, r.URL)
+		}
+		if r.Header.Get(SignatureHeader) != "sha256="+Sign("hook-secret", timestamp, body) {
+			t.Errorf("signature = %s", r.Header.Get(SignatureHeader))
+		}
+		if ts, _ := strconv.ParseInt(timestamp, 10, 64); time.Since(time.Unix(ts, 0)) > time.Minute {
+			t.Errorf("timestamp = %s", timestamp)
+		}
+		_ = json.Unmarshal(body, &received)
+		io.WriteString(w, `{"user":{"login_id":"mallory","email":"alice@example.com","email_verified":true,"name":"Alice","phone_number":"+819000000000","phone_number_verified":"true","locale":42}}`)
+	}))
+
+	user, err := NewClient().VerifyPassword(context.Background(), req, "p@ss")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if received != (checkRequest{Tenant: "demo", LoginID: "alice", Password: "p@ss"}) {
+		t.Errorf("received = %+v", received)
+	}
+	// login_id と型の違う値は使わない
+	want := map[string]string{"email": "alice@example.com", "name": "Alice", "phone_number": "+819000000000"}
+	if len(user.Attributes) != len(want) || !user.EmailVerified || user.PhoneNumberVerified {
+		t.Errorf("user = %+v", user)
+	}
+	for k, v := range want {
+		if user.Attributes[k] != v {
+			t.Errorf("%s = %q", k, user.Attributes[k])
+		}
+	}
+}
+
+func TestVerifyPasswordResponses(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		wantErr bool
+	}{
+		{name: "パスワードが一致しない", handler: respondWith(http.StatusUnauthorized, "")},
+		{name: "ユーザーがいない", handler: respondWith(http.StatusNotFound, "")},
+		{name: "移行元のエラー", handler: respondWith(http.StatusInternalServerError, ""), wantErr: true},
+		{
+			name: "リダイレクトには従わない",
+			handler: func(w http.ResponseWriter, r *http.Request) {
+				http.Redirect(w, r, "/elsewhere", http.StatusTemporaryRedirect)
+			},
+			wantErr: true,
+		},
+		{name: "JSON でない", handler: respondWith(http.StatusOK, "OK"), wantErr: true},
+		{name: "user がない", handler: respondWith(http.StatusOK, `{"ok":true}`), wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHook(t, nil)
+			h.handler.Store(tt.handler)
+			user, err := NewClient().VerifyPassword(context.Background(), h.request(), "p@ss")
+			if user != nil || (err != nil) != tt.wantErr || (tt.wantErr && !errors.Is(err, ErrHook)) {
+				t.Errorf("user = %+v, err = %v", user, err)
+			}
+			if h.calls.Load() != 1 {
+				t.Errorf("calls = %d", h.calls.Load())
+			}
+		})
+	}
+}
+
+func TestVerifyPasswordTLS(t *testing.T) {
+	clientCert, clientKey, clientPool := newTestClientCertificate(t)
+	h := newTestHook(t, clientPool)
+
+	req := h.request()
+	req.AuthMethod, req.HMACSecret = model.UserMigrationHookAuthMTLS, ""
+	req.ClientCertificate, req.ClientPrivateKey = clientCert, clientKey
+	if user, err := NewClient().VerifyPassword(context.Background(), req, "p@ss"); err != nil || user == nil {
+		t.Fatalf("mtls: user = %+v, err = %v", user, err)
+	}
+
+	tests := []struct {
+		name   string
+		modify func(req *model.UserMigrationRequest)
+	}{
+		{name: "クライアント証明書なし", modify: func(req *model.UserMigrationRequest) { req.AuthMethod = model.UserMigrationHookAuthHMAC }},
+		{name: "不正なクライアント証明書", modify: func(req *model.UserMigrationRequest) { req.ClientPrivateKey = "invalid" }},
+		{name: "信頼できないサーバー証明書", modify: func(req *model.UserMigrationRequest) { req.CACertificate = clientCert }},
+		{name: "不正な CA 証明書", modify: func(req *model.UserMigrationRequest) { req.CACertificate = "invalid" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := h.request()
+			req.AuthMethod, req.ClientCertificate, req.ClientPrivateKey = model.UserMigrationHookAuthMTLS, clientCert, clientKey
+			tt.modify(req)
+			if user, err := NewClient().VerifyPassword(context.Background(), req, "p@ss"); !errors.Is(err, ErrHook) || user != nil {
+				t.Errorf("user = %+v, err = %v", user, err)
+			}
+		})
+	}
+}
+
+// newTestClientCertificate は自己署名のクライアント証明書と秘密鍵 (PEM)、それを信頼するプールを返す
+func newTestClientCertificate(t *testing.T) (string, string, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "op"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+		string(pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})),
+		pool
+}
+
+func TestVerifyPasswordTimeout(t *testing.T) {
+	h := newTestHook(t, nil)
+	release := make(chan struct{})
+	defer close(release)
+	h.handler.Store(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	req := h.request()
+	req.Timeout = 50 * time.Millisecond
+	if _, err := NewClient().VerifyPassword(context.Background(), req, "p@ss"); !errors.Is(err, ErrHook) {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestVerifyPasswordCircuitBreaker(t *testing.T) {
+	h := newTestHook(t, nil)
+	h.respond(http.StatusServiceUnavailable, "")
+	c := NewClient()
+	req := h.request()
+
+	for i := 0; i < breakerFailureThreshold; i++ {
+		if _, err := c.VerifyPassword(context.Background(), req, "p@ss"); !errors.Is(err, ErrHook) {
+			t.Fatalf("call %d: err = %v", i, err)
+		}
+	}
+	// 失敗が続いたら移行元を呼び出さない
+	if _, err := c.VerifyPassword(context.Background(), req, "p@ss"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("err = %v", err)
+	}
+	if h.calls.Load() != breakerFailureThreshold {
+		t.Errorf("calls = %d", h.calls.Load())
+	}
+
+	// パスワードの不一致 (401) は失敗として数えない
+	h.respond(http.StatusUnauthorized, "")
+	req = h.request()
+	for i := 0; i < breakerFailureThreshold+1; i++ {
+		if user, err := c.VerifyPassword(context.Background(), req, "wrong"); user != nil || err != nil {
+			t.Fatalf("call %d: user = %+v, err = %v", i, user, err)
+		}
+	}
+}
+
+func TestVerifyPasswordCanceled(t *testing.T) {
+	h := newTestHook(t, nil)
+	c := NewClient()
+	req := h.request()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	// 呼び出し元の中断は移行元の失敗として数えない
+	for i := 0; i < breakerFailureThreshold+1; i++ {
+		if _, err := c.VerifyPassword(ctx, req, "p@ss"); err == nil || errors.Is(err, ErrCircuitOpen) {
+			t.Fatalf("call %d: err = %v", i, err)
+		}
+	}
+	if s := c.breakers.state[req.HookID]; s != nil && s.failures > 0 {
+		t.Errorf("failures = %d", s.failures)
+	}
+}
@@ -14,6 +14,12 @@ type AccessToken struct {
 	Scope     string     `gorm:"type:varchar(1024);not null"`
 	ExpiresAt time.Time  `gorm:"not null"`
 	RevokedAt *time.Time
+	// Resource は aud に設定した resource。nil の場合は aud がクライアント
+	Resource *string `gorm:"type:varchar(2048)"`
+	// Claims は認可時の claims パラメータ。UserInfo で userinfo メンバーを参照する
+	Claims *ClaimsRequest `gorm:"type:jsonb"`
+	// AuthorizationDetails はアクセストークンに含めた authorization_details (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb"`
 
 	Session Session `gorm:"foreignKey:SessionID"`
 	Client  Client  `gorm:"foreignKey:ClientID"`
@@ -8,12 +8,48 @@ type AccessTokenClaims struct {
 	Audience  string
 	Scope     string
 	SessionID string
+	// CertThumbprint は証明書バインド時の cnf.x5t#S256 (RFC 8705 Section 3.1)
+	CertThumbprint string
+	// ClientID は client_id クレーム。Audience がクライアント以外を指すトークン交換時に設定する (RFC 8693 Section 4.3)
+	ClientID string
+	// Actor は act クレーム (RFC 8693 Section 4.1)
+	Actor *ActorClaim
+	// MayAct は may_act クレーム (RFC 8693 Section 4.4)
+	MayAct *ActorClaim
+	// AuthorizationDetails は authorization_details クレーム (RFC 9396 Section 9.1)
+	AuthorizationDetails AuthorizationDetails
 }
 
 type AccessTokenResult struct {
 	JTI       string
-	Subject   uuid.UUID
+	Issuer    string
+	Subject   string
 	ClientID  string
 	Scope     string
 	SessionID uuid.UUID
+	// CertThumbprint は cnf.x5t#S256。証明書バインドされていないトークンでは空
+	CertThumbprint string
+	Actor          *ActorClaim
+	MayAct         *ActorClaim
+}
+
+// ActorClaim は act / may_act クレームの値。委任が連鎖した場合は Actor に前段のアクターを入れ子で持つ
+type ActorClaim struct {
+	Subject  string      `json:"sub,omitempty"`
+	ClientID string      `json:"client_id,omitempty"`
+	Actor    *ActorClaim `json:"act,omitempty"`
+}
+
+// Matches は may_act で許可された主体と一致するか判定する。指定された識別子のみ比較する
+func (a *ActorClaim) Matches(other *ActorClaim) bool {
+	if a.Subject == "" && a.ClientID == "" {
+		return false
+	}
+	if a.Subject != "" && a.Subject != other.Subject {
+		return false
+	}
+	if a.ClientID != "" && a.ClientID != other.ClientID {
+		return false
+	}
+	return true
 }
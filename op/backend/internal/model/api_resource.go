@@ -0,0 +1,44 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// APIResource はテナントに登録された API リソースを表す (RFC 8707)。
+type APIResource struct {
+	ID         uuid.UUID   `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID   uuid.UUID   `gorm:"type:uuid;not null;index"`
+	Identifier string      `gorm:"type:varchar(2048);not null"`
+	Name       string      `gorm:"type:varchar(255);not null"`
+	Scopes     StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	// AccessTokenLifetime はアクセストークンの有効期間 (秒)。nil の場合はテナントの設定を使う
+	AccessTokenLifetime *int
+	CreatedAt           time.Time
+	UpdatedAt           time.Time
+}
+
+func (APIResource) TableName() string { return "api_resources" }
+
+// FilterScopes は scopes のうちこのリソースで許可されたものだけを返す
+func (r *APIResource) FilterScopes(scopes []string) []string {
+	var filtered []string
+	for _, s := range scopes {
+		for _, allowed := range r.Scopes {
+			if s == allowed {
+				filtered = append(filtered, s)
+				break
+			}
+		}
+	}
+	return filtered
+}
+
+// TokenLifetime はこのリソース向けアクセストークンの有効期間 (秒) を返す
+func (r *APIResource) TokenLifetime(tenant *Tenant) int {
+	if r.AccessTokenLifetime != nil {
+		return *r.AccessTokenLifetime
+	}
+	return tenant.AccessTokenLifetime
+}
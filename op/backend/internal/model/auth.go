@@ -13,4 +13,25 @@ type LoginInput struct {
 type LoginOutput struct {
 	SessionID uuid.UUID
 	User      *User
+	// MFAToken は2要素目が必要な場合に返す。セッションは作成せず、SMSLoginInput で完了させる
+	MFAToken string
+}
+
+// SMSLoginInput は SMS のワンタイムコードでログインを完了させる入力
+type SMSLoginInput struct {
+	MFAToken  string
+	Code      string
+	IPAddress string
+	UserAgent string
+}
+
+// FederatedLoginInput は上流の OIDC Provider からの callback の入力
+type FederatedLoginInput struct {
+	IdPCode string
+	State   string
+	Code    string
+	// Error は上流が返した error パラメータ
+	Error     string
+	IPAddress string
+	UserAgent string
 }
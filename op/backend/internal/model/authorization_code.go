@@ -18,6 +18,12 @@ type AuthorizationCode struct {
 	CodeChallengeMethod *string    `gorm:"type:varchar(31)"`
 	ExpiresAt           time.Time  `gorm:"not null"`
 	UsedAt              *time.Time
+	// Resources は認可リクエストで指定された resource (RFC 8707)
+	Resources StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	// Claims は認可リクエストの claims パラメータ (OIDC Core 1.0 Section 5.5)
+	Claims *ClaimsRequest `gorm:"type:jsonb"`
+	// AuthorizationDetails は認可リクエストの authorization_details (RFC 9396)
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb"`
 
 	Session Session `gorm:"foreignKey:SessionID"`
 	Client  Client  `gorm:"foreignKey:ClientID"`
@@ -0,0 +1,22 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// AuthorizationDetailType はテナントに登録された authorization_details の type (RFC 9396 Section 2)。
+type AuthorizationDetailType struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Type     string    `gorm:"type:varchar(255);not null"`
+	// Description は同意・確認画面でユーザーに示す説明
+	Description string `gorm:"type:text;not null;default:''"`
+	// Schema は要素を検証する JSON Schema
+	Schema    string `gorm:"type:jsonb;not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (AuthorizationDetailType) TableName() string { return "authorization_detail_types" }
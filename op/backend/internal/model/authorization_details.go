@@ -0,0 +1,102 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// AuthorizationDetails は authorization_details (RFC 9396)。各要素は type を持つ JSON オブジェクト。JSONB カラムにそのまま保存する
+type AuthorizationDetails []map[string]interface{}
+
+// Types は含まれる type の一覧を重複なしで返す
+func (d AuthorizationDetails) Types() []string {
+	var types []string
+	seen := map[string]bool{}
+	for _, detail := range d {
+		t, _ := detail["type"].(string)
+		if t != "" && !seen[t] {
+			seen[t] = true
+			types = append(types, t)
+		}
+	}
+	return types
+}
+
+// Covers は requested の全要素が d のいずれかの要素に含まれるか判定する。
+// 含まれるとは、フィールドの組が同じで、値が一致 (配列は部分集合) すること。
+// フィールドの省略は制約の解除 (範囲の拡大) になり得るため認めない
+func (d AuthorizationDetails) Covers(requested AuthorizationDetails) bool {
+	for _, r := range requested {
+		covered := false
+		for _, g := range d {
+			if jsonContains(map[string]interface{}(g), map[string]interface{}(r)) {
+				covered = true
+				break
+			}
+		}
+		if !covered {
+			return false
+		}
+	}
+	return true
+}
+
+// jsonContains は requested が granted 以下の範囲に収まるか判定する
+func jsonContains(granted, requested interface{}) bool {
+	switch r := requested.(type) {
+	case map[string]interface{}:
+		g, ok := granted.(map[string]interface{})
+		if !ok || len(g) != len(r) {
+			return false
+		}
+		for k, rv := range r {
+			gv, ok := g[k]
+			if !ok || !jsonContains(gv, rv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		g, ok := granted.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, rv := range r {
+			found := false
+			for _, gv := range g {
+				if jsonContains(gv, rv) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+		return true
+	default:
+		return jsonEqual(granted, requested)
+	}
+}
+
+func (d AuthorizationDetails) Value() (driver.Value, error) {
+	if d == nil {
+		return nil, nil
+	}
+	return json.Marshal(d)
+}
+
+func (d *AuthorizationDetails) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("AuthorizationDetails.Scan: unsupported type %T", value)
+	}
+}
@@ -0,0 +1,36 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// authorization_details の承認状態
+const (
+	AuthorizationDetailsApprovalStatusPending  = "pending"
+	AuthorizationDetailsApprovalStatusApproved = "approved"
+	AuthorizationDetailsApprovalStatusDenied   = "denied"
+	AuthorizationDetailsApprovalStatusConsumed = "consumed"
+)
+
+// AuthorizationDetailsApproval は認可リクエストの authorization_details に対するユーザーの承認を表す (RFC 9396 Section 7)。
+// 承認画面には OP が保持する内容を示すため、リクエストのパラメータを画面側で解釈させない。
+type AuthorizationDetailsApproval struct {
+	ID                   uuid.UUID            `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	SessionID            uuid.UUID            `gorm:"type:uuid;not null"`
+	ClientID             uuid.UUID            `gorm:"type:uuid;not null"`
+	DetailsHash          string               `gorm:"type:varchar(64);not null"`
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb;not null"`
+	Status               string               `gorm:"type:varchar(16);not null;default:'pending'"`
+	ExpiresAt            time.Time            `gorm:"not null"`
+	CreatedAt            time.Time
+
+	Client Client `gorm:"foreignKey:ClientID"`
+}
+
+func (AuthorizationDetailsApproval) TableName() string { return "authorization_details_approvals" }
+
+func (a *AuthorizationDetailsApproval) IsExpired() bool {
+	return a.ExpiresAt.Before(time.Now())
+}
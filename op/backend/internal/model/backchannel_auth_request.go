@@ -0,0 +1,63 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CIBA のトークン受け渡し方式 (CIBA Core 1.0 Section 5)
+const (
+	BackchannelDeliveryModePoll = "poll"
+	BackchannelDeliveryModePing = "ping"
+)
+
+// バックチャネル認証リクエストの状態
+const (
+	BackchannelAuthStatusPending  = "pending"
+	BackchannelAuthStatusApproved = "approved"
+	BackchannelAuthStatusDenied   = "denied"
+	BackchannelAuthStatusConsumed = "consumed"
+)
+
+// BackchannelAuthRequest は CIBA のバックチャネル認証リクエストを表す (CIBA Core 1.0 Section 7)。
+type BackchannelAuthRequest struct {
+	ID                               uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ClientID                         uuid.UUID  `gorm:"type:uuid;not null"`
+	UserID                           uuid.UUID  `gorm:"type:uuid;not null"`
+	AuthReqIDHash                    string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	AuthReqIDEncrypted               *string    `gorm:"type:text"`
+	Scope                            string     `gorm:"type:varchar(1024);not null;default:''"`
+	BindingMessage                   *string    `gorm:"type:varchar(255)"`
+	DeliveryMode                     string     `gorm:"type:varchar(8);not null"`
+	ClientNotificationTokenEncrypted *string    `gorm:"type:text"`
+	Status                           string     `gorm:"type:varchar(16);not null;default:'pending'"`
+	SessionID                        *uuid.UUID `gorm:"type:uuid"`
+	PollInterval                     int        `gorm:"not null;default:5"`
+	LastPolledAt                     *time.Time
+	ExpiresAt                        time.Time `gorm:"not null"`
+	CreatedAt                        time.Time
+
+	Client  Client   `gorm:"foreignKey:ClientID"`
+	Session *Session `gorm:"foreignKey:SessionID"`
+}
+
+func (BackchannelAuthRequest) TableName() string { return "backchannel_auth_requests" }
+
+func (r *BackchannelAuthRequest) IsExpired() bool {
+	return r.ExpiresAt.Before(time.Now())
+}
+
+// AuthenticationDeviceNotification は認証デバイスへ送る承認依頼の内容
+type AuthenticationDeviceNotification struct {
+	TenantCode     string
+	UserID         uuid.UUID
+	LoginID        string
+	Email          string
+	ClientName     string
+	Scope          string
+	BindingMessage string
+	// ApprovalURL はユーザーが承認画面を開く URL
+	ApprovalURL string
+	ExpiresAt   time.Time
+}
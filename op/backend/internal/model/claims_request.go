@@ -0,0 +1,61 @@
+package model
+
+import (
+	"bytes"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// ClaimsRequest は claims リクエストパラメータ (OIDC Core 1.0 Section 5.5)。JSONB カラムにそのまま保存する
+type ClaimsRequest struct {
+	UserInfo map[string]*ClaimRequest `json:"userinfo,omitempty"`
+	IDToken  map[string]*ClaimRequest `json:"id_token,omitempty"`
+}
+
+// ClaimRequest は個別クレームの要求。値を指定しない要求 (null) は nil で表す
+type ClaimRequest struct {
+	Essential bool          `json:"essential,omitempty"`
+	Value     interface{}   `json:"value,omitempty"`
+	Values    []interface{} `json:"values,omitempty"`
+}
+
+// Accepts は value / values の指定に実際の値が一致するか判定する。指定が無い場合は常に true
+func (r *ClaimRequest) Accepts(actual interface{}) bool {
+	if r == nil || (r.Value == nil && r.Values == nil) {
+		return true
+	}
+	if r.Value != nil {
+		return jsonEqual(r.Value, actual)
+	}
+	for _, v := range r.Values {
+		if jsonEqual(v, actual) {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonEqual は JSON 表現で比較する (数値はデコード後 float64 になるため型を揃える)
+func jsonEqual(a, b interface{}) bool {
+	ja, errA := json.Marshal(a)
+	jb, errB := json.Marshal(b)
+	return errA == nil && errB == nil && bytes.Equal(ja, jb)
+}
+
+func (c ClaimsRequest) Value() (driver.Value, error) {
+	return json.Marshal(c)
+}
+
+func (c *ClaimsRequest) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, c)
+	case string:
+		return json.Unmarshal([]byte(v), c)
+	default:
+		return fmt.Errorf("ClaimsRequest.Scan: unsupported type %T", value)
+	}
+}
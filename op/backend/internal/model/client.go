@@ -4,6 +4,7 @@ import (
 	"database/sql/driver"
 	"encoding/json"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/google/uuid"
@@ -32,7 +33,8 @@ type Client struct {
 	ID                      uuid.UUID   `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
 	TenantID                uuid.UUID   `gorm:"type:uuid;not null;index"`
 	ClientID                string      `gorm:"type:varchar(255);uniqueIndex;not null"`
-	ClientSecretHash        string      `gorm:"type:varchar(512);not null"`
+	ClientSecretHash        *string     `gorm:"type:varchar(512)"`
+	ClientSecretEncrypted   *string     `gorm:"type:text"`
 	Name                    string      `gorm:"type:varchar(255);not null"`
 	GrantTypes              StringSlice `gorm:"type:jsonb;not null"`
 	ResponseTypes           StringSlice `gorm:"type:jsonb;not null"`
@@ -40,6 +42,48 @@ type Client struct {
 	RequirePKCE             bool        `gorm:"not null;default:true"`
 	FrontchannelLogoutURI   *string     `gorm:"type:varchar(2048)"`
 	BackchannelLogoutURI    *string     `gorm:"type:varchar(2048)"`
+	JWKS                    *string     `gorm:"column:jwks;type:text"`
+	JWKSURI                 *string     `gorm:"column:jwks_uri;type:varchar(2048)"`
+
+	// RFC 8705 Mutual-TLS クライアント認証
+	TLSClientAuthSubjectDN                *string `gorm:"column:tls_client_auth_subject_dn;type:varchar(1024)"`
+	TLSClientAuthSANDNS                   *string `gorm:"column:tls_client_auth_san_dns;type:varchar(255)"`
+	TLSClientAuthSANURI                   *string `gorm:"column:tls_client_auth_san_uri;type:varchar(2048)"`
+	TLSClientAuthSANIP                    *string `gorm:"column:tls_client_auth_san_ip;type:varchar(45)"`
+	TLSClientAuthSANEmail                 *string `gorm:"column:tls_client_auth_san_email;type:varchar(255)"`
+	TLSClientCertificateBoundAccessTokens bool    `gorm:"column:tls_client_certificate_bound_access_tokens;not null;default:false"`
+
+	// RFC 7592 登録アクセストークン (動的クライアント登録で作成された場合のみ)
+	RegistrationAccessTokenHash *string `gorm:"type:varchar(64)"`
+
+	// OIDC Core 1.0 Section 8 の sub の種別
+	SubjectType         string  `gorm:"type:varchar(15);not null;default:'public'"`
+	SectorIdentifierURI *string `gorm:"type:varchar(2048)"`
+
+	// レスポンスの署名・暗号化 (OIDC Registration 1.0 Section 2)。NULL の場合は署名・暗号化しない
+	UserInfoSignedResponseAlg    *string `gorm:"column:userinfo_signed_response_alg;type:varchar(31)"`
+	IDTokenEncryptedResponseAlg  *string `gorm:"column:id_token_encrypted_response_alg;type:varchar(31)"`
+	IDTokenEncryptedResponseEnc  *string `gorm:"column:id_token_encrypted_response_enc;type:varchar(31)"`
+	UserInfoEncryptedResponseAlg *string `gorm:"column:userinfo_encrypted_response_alg;type:varchar(31)"`
+	UserInfoEncryptedResponseEnc *string `gorm:"column:userinfo_encrypted_response_enc;type:varchar(31)"`
+
+	// RFC 9101 リクエストオブジェクト
+	RequestURIs                StringSlice `gorm:"column:request_uris;type:jsonb;not null;default:'[]'"`
+	RequireSignedRequestObject bool        `gorm:"not null;default:false"`
+
+	// CIBA のトークン受け渡し方式 (CIBA Core 1.0 Section 4)。NULL の場合は CIBA を使わない
+	BackchannelTokenDeliveryMode          *string `gorm:"type:varchar(8)"`
+	BackchannelClientNotificationEndpoint *string `gorm:"type:varchar(2048)"`
+
+	// 要求できるスコープ。空の場合はテナントで利用できる全てのスコープ
+	AllowedScopes StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	// scope を省略した認可リクエストに使うスコープ (RFC 6749 Section 3.3)
+	DefaultScopes StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+
+	// RFC 8693 トークン交換のポリシー
+	TokenExchangeAudiences StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	TokenExchangeScopes    StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+
 	Status                  string      `gorm:"type:varchar(31);not null;default:'active'"`
 	CreatedAt               time.Time
 	UpdatedAt               time.Time
@@ -60,3 +104,67 @@ func (c *Client) HasGrantType(grantType string) bool {
 	}
 	return false
 }
+
+// AllowsScope はクライアントが scope を要求できるか判定する。openid は常に許可する
+func (c *Client) AllowsScope(scope string) bool {
+	if len(c.AllowedScopes) == 0 || scope == "openid" {
+		return true
+	}
+	for _, s := range c.AllowedScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsPublic はクライアントがシークレットを持たない公開クライアント (SPA / ネイティブアプリ) か判定する
+func (c *Client) IsPublic() bool {
+	return c.TokenEndpointAuthMethod == "none"
+}
+
+// sub の種別 (OIDC Core 1.0 Section 8)
+const (
+	SubjectTypePublic   = "public"
+	SubjectTypePairwise = "pairwise"
+)
+
+// IsPairwise はクライアントに pairwise sub を発行するか判定する
+func (c *Client) IsPairwise() bool {
+	return c.SubjectType == SubjectTypePairwise
+}
+
+// SectorIdentifier は pairwise sub の計算に使うセクター識別子を返す。
+// sector_identifier_uri があればそのホスト名、無ければリダイレクト URI のホスト名 (OIDC Core 1.0 Section 8.1)。
+// リダイレクト URI から求める場合は RedirectURIs がロードされている必要がある。
+func (c *Client) SectorIdentifier() string {
+	if c.SectorIdentifierURI != nil {
+		return hostOf(*c.SectorIdentifierURI)
+	}
+	if len(c.RedirectURIs) > 0 {
+		return hostOf(c.RedirectURIs[0].URI)
+	}
+	return ""
+}
+
+func hostOf(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// UsesMTLS は登録された認証方式が Mutual-TLS (RFC 8705) か判定する
+func (c *Client) UsesMTLS() bool {
+	return c.TokenEndpointAuthMethod == "tls_client_auth" || c.TokenEndpointAuthMethod == "self_signed_tls_client_auth"
+}
+
+// UsesClientSecret は登録された認証方式が client_secret を必要とするか判定する
+func (c *Client) UsesClientSecret() bool {
+	switch c.TokenEndpointAuthMethod {
+	case "client_secret_basic", "client_secret_post", "client_secret_jwt":
+		return true
+	}
+	return false
+}
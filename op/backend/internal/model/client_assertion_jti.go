@@ -0,0 +1,18 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ClientAssertionJTI は使用済みクライアントアサーションの jti を表す (RFC 7523 Section 3)。
+type ClientAssertionJTI struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ClientID  uuid.UUID `gorm:"type:uuid;not null"`
+	JTI       string    `gorm:"column:jti;type:varchar(255);not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	CreatedAt time.Time
+}
+
+func (ClientAssertionJTI) TableName() string { return "client_assertion_jtis" }
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// デバイス認可リクエストの状態
+const (
+	DeviceCodeStatusPending  = "pending"
+	DeviceCodeStatusApproved = "approved"
+	DeviceCodeStatusDenied   = "denied"
+	DeviceCodeStatusConsumed = "consumed"
+)
+
+// DeviceCode はデバイス認可リクエストを表す (RFC 8628 Section 3.2)。
+type DeviceCode struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	ClientID       uuid.UUID  `gorm:"type:uuid;not null"`
+	DeviceCodeHash string     `gorm:"type:varchar(64);uniqueIndex;not null"`
+	UserCode       string     `gorm:"type:varchar(16);uniqueIndex;not null"`
+	Scope          string     `gorm:"type:varchar(1024);not null;default:''"`
+	Status         string     `gorm:"type:varchar(16);not null;default:'pending'"`
+	SessionID      *uuid.UUID `gorm:"type:uuid"`
+	PollInterval   int        `gorm:"not null;default:5"`
+	LastPolledAt   *time.Time
+	ExpiresAt      time.Time `gorm:"not null"`
+	CreatedAt      time.Time
+
+	Client  Client   `gorm:"foreignKey:ClientID"`
+	Session *Session `gorm:"foreignKey:SessionID"`
+}
+
+func (DeviceCode) TableName() string { return "device_codes" }
+
+func (dc *DeviceCode) IsExpired() bool {
+	return dc.ExpiresAt.Before(time.Now())
+}
+
+// DeviceUserCodeFailure は user_code の入力失敗を表す (RFC 8628 Section 5.1)。
+type DeviceUserCodeFailure struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	SessionID uuid.UUID `gorm:"type:uuid;not null"`
+	IPAddress string    `gorm:"type:varchar(45);not null"`
+	CreatedAt time.Time
+}
+
+func (DeviceUserCodeFailure) TableName() string { return "device_user_code_failures" }
@@ -0,0 +1,26 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailChangeRequest はメールアドレスの変更リクエストを表す。
+// 新しいアドレスで確認すると変更を確定し、変更前のアドレスに取り消し用のリンクを送る。
+type EmailChangeRequest struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID           uuid.UUID `gorm:"type:uuid;not null;index"`
+	OldEmail         string    `gorm:"type:varchar(255);not null"`
+	OldEmailVerified bool      `gorm:"not null"`
+	NewEmail         string    `gorm:"type:varchar(255);not null"`
+	TokenHash        string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt        time.Time `gorm:"not null"`
+	ConfirmedAt      *time.Time
+	RevertTokenHash  *string `gorm:"type:varchar(64);uniqueIndex"`
+	RevertExpiresAt  *time.Time
+	RevertedAt       *time.Time
+	CreatedAt        time.Time
+}
+
+func (EmailChangeRequest) TableName() string { return "email_change_requests" }
@@ -0,0 +1,27 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// EmailVerificationToken はメールアドレス確認用のワンタイムトークンを表す。平文のトークンは保存しない。
+type EmailVerificationToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Email     string    `gorm:"type:varchar(255);not null"`
+	TokenHash string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+}
+
+func (EmailVerificationToken) TableName() string { return "email_verification_tokens" }
+
+// MailMessage は送信するメール (テキスト形式)
+type MailMessage struct {
+	To      string
+	Subject string
+	Body    string
+}
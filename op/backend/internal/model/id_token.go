@@ -12,8 +12,12 @@ type IDToken struct {
 	SessionID uuid.UUID `gorm:"type:uuid;not null"`
 	ClientID  uuid.UUID `gorm:"type:uuid;not null"`
 	Nonce     *string   `gorm:"type:varchar(255)"`
+	// Scope は発行元の認可で付与されたスコープ。nil の場合はトークン交換に使えない (スコープ記録前に発行されたトークン)
+	Scope     *string   `gorm:"type:varchar(1024)"`
 	ExpiresAt time.Time `gorm:"not null"`
 	CreatedAt time.Time
+
+	Session Session `gorm:"foreignKey:SessionID"`
 }
 
 func (IDToken) TableName() string { return "id_tokens" }
@@ -9,4 +9,15 @@ type IDTokenClaims struct {
 	Nonce    *string
 	AuthTime time.Time
 	ATHash   string
+	// AMR は認証に使った方式 (OIDC Core 1.0 Section 2, RFC 8176)
+	AMR []string
+	// Extra は claims パラメータやスコープに応じて追加するユーザークレーム
+	Extra map[string]interface{}
+}
+
+type IDTokenResult struct {
+	JTI      string
+	Issuer   string
+	Subject  string
+	ClientID string
 }
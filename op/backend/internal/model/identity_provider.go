@@ -0,0 +1,128 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CredentialTypeOIDCProvider は上流の OIDC Provider による認証を表す credentials.type
+const CredentialTypeOIDCProvider = "oidc_provider"
+
+// FederatedLoginIDAttribute は ClaimMappings で login_id に使うクレームを指定するキー
+const FederatedLoginIDAttribute = "login_id"
+
+// FederatedUserAttributes は ClaimMappings で上流のクレームを割り当てられるユーザー属性 (文字列のもの)
+var FederatedUserAttributes = []string{
+	FederatedLoginIDAttribute, "email", "name", "given_name", "family_name", "middle_name", "nickname", "preferred_username",
+	"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "phone_number",
+}
+
+// IdentityProvider はテナントが連携する上流の OIDC Provider。
+type IdentityProvider struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;index"`
+	// Code は /internal/federation/{code}/start・callback のパスに使う識別子
+	Code                  string      `gorm:"type:varchar(63);not null"`
+	Name                  string      `gorm:"type:varchar(255);not null"`
+	Issuer                string      `gorm:"type:varchar(2048);not null"`
+	ClientID              string      `gorm:"type:varchar(255);not null"`
+	ClientSecretEncrypted *string     `gorm:"type:text"`
+	Scopes                StringSlice `gorm:"type:jsonb;not null"`
+	// ClaimMappings はユーザー属性 → 上流のクレーム名
+	ClaimMappings IdPClaimMappings `gorm:"type:jsonb;not null;default:'{}'"`
+	// JITProvisioning は紐付くユーザーがいなければ初回ログイン時に作成するか
+	JITProvisioning bool `gorm:"not null;default:true"`
+	// LinkByEmail は確認済みのメールアドレスが一致する既存ユーザーに紐付けるか
+	LinkByEmail bool   `gorm:"not null;default:false"`
+	Status      string `gorm:"type:varchar(31);not null;default:'active'"`
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	Tenant Tenant `gorm:"foreignKey:TenantID"`
+}
+
+func (IdentityProvider) TableName() string { return "identity_providers" }
+
+// ClaimFor はユーザー属性の値に使う上流のクレーム名を返す。
+// マッピングがなければ標準クレームは同名のクレームを使い、それ以外は空文字を返す
+func (p *IdentityProvider) ClaimFor(attribute string) string {
+	if claim, ok := p.ClaimMappings[attribute]; ok {
+		return claim
+	}
+	for _, name := range StandardUserClaims {
+		if name == attribute {
+			return attribute
+		}
+	}
+	return ""
+}
+
+// IdPClaimMappings は JSONB カラムを map[string]string としてマッピングする
+type IdPClaimMappings map[string]string
+
+func (m IdPClaimMappings) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *IdPClaimMappings) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("IdPClaimMappings.Scan: unsupported type %T", value)
+	}
+}
+
+// ExternalIdPCredential は上流の OIDC Provider のユーザーとの紐付け
+type ExternalIdPCredential struct {
+	ID                 uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	CredentialID       uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	IdentityProviderID uuid.UUID `gorm:"type:uuid;not null"`
+	// ProviderSubject は上流の ID トークンの sub
+	ProviderSubject string `gorm:"type:varchar(255);not null"`
+	CreatedAt       time.Time
+}
+
+func (ExternalIdPCredential) TableName() string { return "external_idp_credentials" }
+
+// FederationState は上流への認可リクエストの状態。callback で 1 回だけ使う
+type FederationState struct {
+	ID                 uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	IdentityProviderID uuid.UUID `gorm:"type:uuid;not null"`
+	StateHash          string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Nonce              string    `gorm:"type:varchar(64);not null"`
+	CodeVerifier       string    `gorm:"type:varchar(128);not null"`
+	// RedirectAfterLogin はログイン後に戻る OP Backend の相対パス
+	RedirectAfterLogin string    `gorm:"type:varchar(4096);not null;default:''"`
+	ExpiresAt          time.Time `gorm:"not null"`
+	UsedAt             *time.Time
+	CreatedAt          time.Time
+
+	IdentityProvider IdentityProvider `gorm:"foreignKey:IdentityProviderID"`
+}
+
+func (FederationState) TableName() string { return "federation_states" }
+
+// UpstreamTokenRequest は上流のトークンエンドポイントで認可コードを交換するための値
+type UpstreamTokenRequest struct {
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURI  string
+	Code         string
+	CodeVerifier string
+	// Nonce は ID トークンの nonce と照合する値
+	Nonce string
+}
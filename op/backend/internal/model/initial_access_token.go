@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// InitialAccessToken は動的クライアント登録用の初期アクセストークンを表す (RFC 7591 Section 3)。
+type InitialAccessToken struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash   string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Description *string   `gorm:"type:varchar(255)"`
+	ExpiresAt   *time.Time
+	CreatedAt   time.Time
+}
+
+func (InitialAccessToken) TableName() string { return "initial_access_tokens" }
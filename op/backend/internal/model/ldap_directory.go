@@ -0,0 +1,147 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// CredentialTypeLDAP は LDAP ディレクトリによる認証を表す credentials.type
+const CredentialTypeLDAP = "ldap"
+
+// LDAP ディレクトリへの接続方式。平文の接続には対応しない
+const (
+	// LDAPSecurityLDAPS は接続時から TLS を使う
+	LDAPSecurityLDAPS = "ldaps"
+	// LDAPSecurityStartTLS は StartTLS 拡張操作で TLS に切り替える (RFC 4511 Section 4.14)
+	LDAPSecurityStartTLS = "starttls"
+)
+
+// LDAPDirectory はテナントのパスワード認証を委譲する LDAP / Active Directory。テナントごとに 1 つ
+type LDAPDirectory struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	Name     string    `gorm:"type:varchar(255);not null"`
+	Host     string    `gorm:"type:varchar(255);not null"`
+	Port     int       `gorm:"not null"`
+	Security string    `gorm:"type:varchar(31);not null;default:'ldaps'"`
+	// CACertificate はサーバー証明書を検証する CA 証明書 (PEM)。nil の場合はシステムの信頼ストアを使う
+	CACertificate *string `gorm:"type:text"`
+	// BindDN はユーザーの検索に使うサービスアカウント。nil の場合は匿名で検索する
+	BindDN                *string `gorm:"column:bind_dn;type:varchar(1024)"`
+	BindPasswordEncrypted *string `gorm:"type:text"`
+	UserSearchBase        string  `gorm:"type:varchar(1024);not null"`
+	// UserSearchFilter は検索フィルタ (RFC 4515)。{username} をエスケープした login_id で置き換える
+	UserSearchFilter string `gorm:"type:varchar(1024);not null"`
+	// IDAttribute はユーザーとの紐付けに使う変わらない属性 (AD の objectGUID など)
+	IDAttribute string `gorm:"column:id_attribute;type:varchar(255);not null"`
+	// AttributeMappings はユーザー属性 → ディレクトリの属性名
+	AttributeMappings LDAPAttributeMappings `gorm:"type:jsonb;not null;default:'{}'"`
+	// JITProvisioning は紐付くユーザーがいなければ初回ログイン時に作成するか
+	JITProvisioning bool `gorm:"not null;default:true"`
+	// LinkByLoginID は login_id が一致する既存ユーザーに紐付けるか
+	LinkByLoginID bool `gorm:"column:link_by_login_id;not null;default:false"`
+	// GroupSyncEnabled はログイン時に GroupAttribute のグループ DN からグループのメンバーを同期するか
+	GroupSyncEnabled bool   `gorm:"not null;default:false"`
+	GroupAttribute   string `gorm:"type:varchar(255);not null"`
+	Status           string `gorm:"type:varchar(31);not null;default:'active'"`
+	CreatedAt        time.Time
+	UpdatedAt        time.Time
+}
+
+func (LDAPDirectory) TableName() string { return "ldap_directories" }
+
+// DefaultLDAPAttributeMappings は Active Directory の一般的な属性を使う既定のマッピングを返す
+func DefaultLDAPAttributeMappings() LDAPAttributeMappings {
+	return LDAPAttributeMappings{
+		FederatedLoginIDAttribute: "sAMAccountName",
+		"email":                   "mail",
+		"name":                    "displayName",
+		"given_name":              "givenName",
+		"family_name":             "sn",
+		"phone_number":            "telephoneNumber",
+	}
+}
+
+// LDAPAttributeMappings は JSONB カラムを map[string]string としてマッピングする
+type LDAPAttributeMappings map[string]string
+
+func (m LDAPAttributeMappings) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *LDAPAttributeMappings) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("LDAPAttributeMappings.Scan: unsupported type %T", value)
+	}
+}
+
+// LDAPCredential は LDAP ディレクトリのエントリとの紐付け
+type LDAPCredential struct {
+	ID              uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	CredentialID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	LDAPDirectoryID uuid.UUID `gorm:"column:ldap_directory_id;type:uuid;not null"`
+	// Subject はエントリの IDAttribute の値
+	Subject string `gorm:"type:varchar(255);not null"`
+	// DN は最後にログインしたときのエントリの DN
+	DN        string `gorm:"column:dn;type:varchar(1024);not null"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (LDAPCredential) TableName() string { return "ldap_credentials" }
+
+// LDAPSearchRequest はディレクトリでユーザーを検索する条件。BindPassword は復号済みの値
+type LDAPSearchRequest struct {
+	Host          string
+	Port          int
+	Security      string
+	CACertificate string
+	BindDN        string
+	BindPassword  string
+	SearchBase    string
+	// Filter は検索フィルタのテンプレート。{username} を LoginID で置き換える
+	Filter     string
+	LoginID    string
+	Attributes []string
+}
+
+// LDAPEntry はディレクトリのエントリ。属性名は小文字にそろえる (LDAP の属性名は大文字小文字を区別しない)
+type LDAPEntry struct {
+	DN         string
+	Attributes map[string][]string
+}
+
+// Values は属性の値をすべて返す。
+func (e *LDAPEntry) Values(name string) []string {
+	return e.Attributes[strings.ToLower(name)]
+}
+
+// First は属性の最初の値を返す。属性がなければ空文字を返す。
+func (e *LDAPEntry) First(name string) string {
+	if values := e.Values(name); len(values) > 0 {
+		return values[0]
+	}
+	return ""
+}
+
+// LDAPGroup はユーザーが所属するディレクトリのグループ。Name は DN の先頭の RDN の値 (CN など)
+type LDAPGroup struct {
+	DN   string
+	Name string
+}
@@ -0,0 +1,19 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// PairwiseSubject は発行済みの pairwise sub とユーザーの対応を表す。sub からの逆引きに使う。
+type PairwiseSubject struct {
+	ID               uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID         uuid.UUID `gorm:"type:uuid;not null"`
+	SectorIdentifier string    `gorm:"type:varchar(255);not null"`
+	UserID           uuid.UUID `gorm:"type:uuid;not null;index"`
+	Subject          string    `gorm:"type:varchar(64);not null"`
+	CreatedAt        time.Time
+}
+
+func (PairwiseSubject) TableName() string { return "pairwise_subjects" }
@@ -15,6 +15,14 @@ type RefreshToken struct {
 	ExpiresAt       time.Time  `gorm:"not null"`
 	RevokedAt       *time.Time
 	ReuseDetectedAt *time.Time
+	// Scope は認可されたスコープ全体。nil の場合は紐付くアクセストークンのスコープを使う (リソース指標導入前に発行されたトークン)
+	Scope *string `gorm:"type:varchar(1024)"`
+	// Resources は認可された resource (RFC 8707)
+	Resources StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	// Claims は認可時の claims パラメータ。リフレッシュ後のアクセストークンに引き継ぐ
+	Claims *ClaimsRequest `gorm:"type:jsonb"`
+	// AuthorizationDetails は認可された authorization_details。リフレッシュ時はこの範囲内でのみ絞り込める
+	AuthorizationDetails AuthorizationDetails `gorm:"type:jsonb"`
 
 	Parent      *RefreshToken `gorm:"foreignKey:ParentID"`
 	Session     Session       `gorm:"foreignKey:SessionID"`
@@ -0,0 +1,113 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// アサーションの NameID の形式 (SAML Core 2.0 Section 8.3)
+const (
+	SAMLNameIDFormatEmailAddress = "urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress"
+	SAMLNameIDFormatPersistent   = "urn:oasis:names:tc:SAML:2.0:nameid-format:persistent"
+	SAMLNameIDFormatTransient    = "urn:oasis:names:tc:SAML:2.0:nameid-format:transient"
+	SAMLNameIDFormatUnspecified  = "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"
+)
+
+// SAMLNameIDFormats は SP に設定できる NameID の形式
+var SAMLNameIDFormats = []string{
+	SAMLNameIDFormatEmailAddress, SAMLNameIDFormatPersistent, SAMLNameIDFormatTransient, SAMLNameIDFormatUnspecified,
+}
+
+// 属性名の形式 (SAML Core 2.0 Section 8.2)
+const (
+	SAMLAttrNameFormatBasic       = "urn:oasis:names:tc:SAML:2.0:attrname-format:basic"
+	SAMLAttrNameFormatURI         = "urn:oasis:names:tc:SAML:2.0:attrname-format:uri"
+	SAMLAttrNameFormatUnspecified = "urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"
+)
+
+// SAMLServiceProvider はテナントの SAML 2.0 IdP に登録された Service Provider。
+type SAMLServiceProvider struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;index"`
+	EntityID string    `gorm:"type:varchar(1024);not null"`
+	Name     string    `gorm:"type:varchar(255);not null"`
+	// ACSURL は AssertionConsumerService の URL (HTTP-POST バインディング)
+	ACSURL string `gorm:"column:acs_url;type:varchar(2048);not null"`
+	// SLOURL は SingleLogoutService の URL (HTTP-Redirect バインディング)。nil の場合は SLO に参加しない
+	SLOURL            *string               `gorm:"column:slo_url;type:varchar(2048)"`
+	NameIDFormat      string                `gorm:"type:varchar(255);not null"`
+	AttributeMappings SAMLAttributeMappings `gorm:"type:jsonb;not null;default:'[]'"`
+	// IdPInitiatedSSOEnabled は AuthnRequest なしでアサーションを送ることを許可するか
+	IdPInitiatedSSOEnabled bool   `gorm:"column:idp_initiated_sso_enabled;not null;default:false"`
+	Status                 string `gorm:"type:varchar(31);not null;default:'active'"`
+	CreatedAt              time.Time
+	UpdatedAt              time.Time
+}
+
+func (SAMLServiceProvider) TableName() string { return "saml_service_providers" }
+
+// SAMLAttributeMapping はアサーションに含める属性。Attribute はユーザー属性名 (標準クレーム名・カスタム属性名・login_id)
+type SAMLAttributeMapping struct {
+	Name      string `json:"name"`
+	Attribute string `json:"attribute"`
+	// NameFormat は basic / uri / unspecified。省略時は basic
+	NameFormat string `json:"name_format,omitempty"`
+}
+
+// NameFormatURI は属性名の形式の URI を返す
+func (m SAMLAttributeMapping) NameFormatURI() string {
+	switch m.NameFormat {
+	case "uri":
+		return SAMLAttrNameFormatURI
+	case "unspecified":
+		return SAMLAttrNameFormatUnspecified
+	default:
+		return SAMLAttrNameFormatBasic
+	}
+}
+
+// SAMLAttributeMappings は JSONB カラムを []SAMLAttributeMapping としてマッピングする
+type SAMLAttributeMappings []SAMLAttributeMapping
+
+func (m SAMLAttributeMappings) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *SAMLAttributeMappings) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("SAMLAttributeMappings.Scan: unsupported type %T", value)
+	}
+}
+
+// SAMLSession は OP のセッションでアサーションを発行した SP。SingleLogout の伝播先になる
+type SAMLSession struct {
+	ID                uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	SessionID         uuid.UUID `gorm:"type:uuid;not null"`
+	ServiceProviderID uuid.UUID `gorm:"type:uuid;not null"`
+	NameID            string    `gorm:"type:varchar(255);not null"`
+	SessionIndex      string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	// LogoutRequestID と LogoutRelayState は SP から受けた LogoutRequest の値。伝播の完了後の LogoutResponse に使う
+	LogoutRequestID  *string `gorm:"type:varchar(255)"`
+	LogoutRelayState *string `gorm:"type:varchar(1024)"`
+	LoggedOutAt      *time.Time
+	CreatedAt        time.Time
+
+	ServiceProvider SAMLServiceProvider `gorm:"foreignKey:ServiceProviderID"`
+}
+
+func (SAMLSession) TableName() string { return "saml_sessions" }
@@ -0,0 +1,48 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SCIMToken は SCIM 2.0 のプロビジョニング API を呼び出すための Bearer トークンを表す (RFC 7644 Section 2)。
+type SCIMToken struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash   string    `gorm:"type:varchar(64);uniqueIndex;not null"`
+	Description *string   `gorm:"type:varchar(255)"`
+	ExpiresAt   *time.Time
+	LastUsedAt  *time.Time
+	CreatedAt   time.Time
+}
+
+func (SCIMToken) TableName() string { return "scim_tokens" }
+
+// Group は SCIM でプロビジョニングされるユーザーのグループを表す (RFC 7643 Section 4.2)。
+// UpdatedAt はメンバーの変更でも更新する
+type Group struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID    uuid.UUID `gorm:"type:uuid;not null"`
+	DisplayName string    `gorm:"type:varchar(255);not null"`
+	ExternalID  *string   `gorm:"type:varchar(255)"`
+	// LDAPDirectoryID は LDAP ディレクトリから同期したグループのディレクトリ。ExternalID にグループの DN を持つ
+	LDAPDirectoryID *uuid.UUID `gorm:"column:ldap_directory_id;type:uuid"`
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	Members []GroupMember `gorm:"foreignKey:GroupID"`
+}
+
+func (Group) TableName() string { return "groups" }
+
+// GroupMember はグループのメンバーのユーザー。
+type GroupMember struct {
+	GroupID   uuid.UUID `gorm:"type:uuid;primaryKey"`
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	CreatedAt time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (GroupMember) TableName() string { return "group_members" }
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// StandardScopes は OP が組み込みで提供するスコープ (OIDC Core 1.0 Section 5.4, 11)。テナントは同名のスコープを定義できない
+var StandardScopes = []string{"openid", "profile", "email", "phone", "address", "offline_access"}
+
+// IsStandardScope は組み込みのスコープか判定する
+func IsStandardScope(scope string) bool {
+	for _, s := range StandardScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// ScopeDefinition はテナントが定義したカスタムスコープ。
+type ScopeDefinition struct {
+	ID       uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID uuid.UUID `gorm:"type:uuid;not null;index"`
+	Name     string    `gorm:"type:varchar(255);not null"`
+	// Description は同意画面でユーザーに示す説明
+	Description string `gorm:"type:text;not null;default:''"`
+	// ConsentRequired はクライアントごとにユーザーの同意を得てから認可するか
+	ConsentRequired bool `gorm:"not null;default:false"`
+	// Claims はスコープ要求時に返すクレーム
+	Claims    ScopeClaimMappings `gorm:"type:jsonb;not null;default:'[]'"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (ScopeDefinition) TableName() string { return "scope_definitions" }
+
+// ScopeClaimMapping はスコープから返すクレーム1つ分の定義。Attribute と Value はどちらか一方を指定する
+type ScopeClaimMapping struct {
+	Claim string `json:"claim"`
+	// Attribute はクレームの値にするユーザー属性 (標準クレーム名またはカスタム属性名)
+	Attribute string `json:"attribute,omitempty"`
+	// Value は固定値
+	Value interface{} `json:"value,omitempty"`
+}
+
+// ScopeClaimMappings は JSONB カラムを []ScopeClaimMapping としてマッピングする
+type ScopeClaimMappings []ScopeClaimMapping
+
+func (m ScopeClaimMappings) Value() (driver.Value, error) {
+	if m == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal(m)
+}
+
+func (m *ScopeClaimMappings) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*m = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, m)
+	case string:
+		return json.Unmarshal([]byte(v), m)
+	default:
+		return fmt.Errorf("ScopeClaimMappings.Scan: unsupported type %T", value)
+	}
+}
+
+// UserConsent はユーザーがクライアントに同意したスコープ。consent_required のスコープのみ記録する
+type UserConsent struct {
+	ID        uuid.UUID   `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID    uuid.UUID   `gorm:"type:uuid;not null"`
+	ClientID  uuid.UUID   `gorm:"type:uuid;not null"`
+	Scopes    StringSlice `gorm:"type:jsonb;not null;default:'[]'"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
+func (UserConsent) TableName() string { return "user_consents" }
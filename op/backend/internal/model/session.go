@@ -12,6 +12,8 @@ type Session struct {
 	TenantID  uuid.UUID  `gorm:"type:uuid;not null"`
 	IPAddress string     `gorm:"type:varchar(45);not null"`
 	UserAgent string     `gorm:"type:text;not null;default:''"`
+	// AMR は認証に使った方式 (RFC 8176)
+	AMR StringSlice `gorm:"type:jsonb;not null"`
 	ExpiresAt time.Time  `gorm:"not null"`
 	RevokedAt *time.Time
 	CreatedAt time.Time
@@ -23,6 +25,13 @@ type Session struct {
 
 func (Session) TableName() string { return "sessions" }
 
+// 認証方式 (RFC 8176 Section 2)
+const (
+	AMRPassword = "pwd"
+	AMRSMS      = "sms"
+	AMRMFA      = "mfa"
+)
+
 func (s *Session) IsValid() bool {
 	return s.RevokedAt == nil && s.ExpiresAt.After(time.Now())
 }
@@ -0,0 +1,38 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// SMS ワンタイムコードの用途
+const (
+	SMSOTPPurposePhoneVerification = "phone_verification"
+	SMSOTPPurposeLogin             = "login"
+)
+
+// SMSOTPCode は SMS で送信したワンタイムコードを表す。平文のコードは保存しない。
+type SMSOTPCode struct {
+	ID          uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null"`
+	Purpose     string    `gorm:"type:varchar(31);not null"`
+	PhoneNumber string    `gorm:"type:varchar(32);not null"`
+	CodeHash    string    `gorm:"type:varchar(64);not null"`
+	// ChallengeHash は login の場合にパスワード認証後に発行した mfa_token のハッシュ
+	ChallengeHash *string   `gorm:"type:varchar(64);uniqueIndex"`
+	Attempts      int       `gorm:"not null;default:0"`
+	ExpiresAt     time.Time `gorm:"not null"`
+	ConsumedAt    *time.Time
+	CreatedAt     time.Time
+
+	User User `gorm:"foreignKey:UserID"`
+}
+
+func (SMSOTPCode) TableName() string { return "sms_otp_codes" }
+
+// SMSMessage は送信する SMS
+type SMSMessage struct {
+	To   string
+	Body string
+}
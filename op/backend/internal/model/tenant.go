@@ -1,6 +1,8 @@
 package model
 
 import (
+	"encoding/json"
+	"sort"
 	"time"
 
 	"github.com/google/uuid"
@@ -15,8 +17,77 @@ type Tenant struct {
 	AccessTokenLifetime  int       `gorm:"not null;default:3600"`
 	RefreshTokenLifetime int       `gorm:"not null;default:2592000"`
 	IDTokenLifetime      int       `gorm:"not null;default:3600"`
+	RegistrationPolicy   string    `gorm:"type:varchar(31);not null;default:'disabled'"`
+	// PairwiseSalt は pairwise sub の計算に使う秘密の値。作成後は変更しない
+	PairwiseSalt string `gorm:"type:varchar(64);not null;<-:create"`
+	// UserAttributeSchema はユーザーのカスタム属性を定義する JSON Schema。nil の場合はカスタム属性なし
+	UserAttributeSchema *string `gorm:"type:jsonb"`
+	// EmailVerificationPolicy はメールアドレス未確認ユーザーのログイン・認可を制限するか
+	EmailVerificationPolicy string `gorm:"type:varchar(31);not null;default:'optional'"`
 	CreatedAt            time.Time
 	UpdatedAt            time.Time
+
+	// ScopeDefinitions はクレームの組み立て時のみプリロードする
+	ScopeDefinitions []ScopeDefinition `gorm:"foreignKey:TenantID"`
 }
 
 func (Tenant) TableName() string { return "tenants" }
+
+// 動的クライアント登録 (RFC 7591) の受付方針
+const (
+	RegistrationPolicyDisabled           = "disabled"
+	RegistrationPolicyInitialAccessToken = "initial_access_token"
+	RegistrationPolicyOpen               = "open"
+)
+
+// RegistrationEnabled は動的クライアント登録を受け付けるか判定する
+func (t *Tenant) RegistrationEnabled() bool {
+	return t.RegistrationPolicy == RegistrationPolicyInitialAccessToken || t.RegistrationPolicy == RegistrationPolicyOpen
+}
+
+// メールアドレス未確認ユーザーの扱い
+const (
+	EmailVerificationPolicyOptional      = "optional"
+	EmailVerificationPolicyLogin         = "login"
+	EmailVerificationPolicyAuthorization = "authorization"
+)
+
+// RequiresVerifiedEmailForLogin はメールアドレス未確認のユーザーのログインを拒否するか判定する
+func (t *Tenant) RequiresVerifiedEmailForLogin() bool {
+	return t.EmailVerificationPolicy == EmailVerificationPolicyLogin
+}
+
+// RequiresVerifiedEmailForAuthorization はメールアドレス未確認のユーザーへの認可を拒否するか判定する。
+// login の場合もログイン後にメールアドレスが変更されることがあるため認可時に確認する
+func (t *Tenant) RequiresVerifiedEmailForAuthorization() bool {
+	return t.EmailVerificationPolicy == EmailVerificationPolicyLogin || t.EmailVerificationPolicy == EmailVerificationPolicyAuthorization
+}
+
+// UserAttributeNames は UserAttributeSchema の properties に定義されたカスタム属性名をソートして返す
+func (t *Tenant) UserAttributeNames() []string {
+	if t.UserAttributeSchema == nil {
+		return nil
+	}
+	var schema struct {
+		Properties map[string]json.RawMessage `json:"properties"`
+	}
+	if err := json.Unmarshal([]byte(*t.UserAttributeSchema), &schema); err != nil {
+		return nil
+	}
+	names := make([]string, 0, len(schema.Properties))
+	for name := range schema.Properties {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ScopeDefinition は name のカスタムスコープ定義を返す。ScopeDefinitions がロードされている必要がある
+func (t *Tenant) ScopeDefinition(name string) *ScopeDefinition {
+	for i := range t.ScopeDefinitions {
+		if t.ScopeDefinitions[i].Name == name {
+			return &t.ScopeDefinitions[i]
+		}
+	}
+	return nil
+}
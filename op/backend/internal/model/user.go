@@ -18,6 +18,29 @@ type User struct {
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 
+	// OIDC 標準クレーム (OIDC Core 1.0 Section 5.1)
+	GivenName           *string  `gorm:"type:varchar(255)"`
+	FamilyName          *string  `gorm:"type:varchar(255)"`
+	MiddleName          *string  `gorm:"type:varchar(255)"`
+	Nickname            *string  `gorm:"type:varchar(255)"`
+	PreferredUsername   *string  `gorm:"type:varchar(255)"`
+	Profile             *string  `gorm:"type:varchar(2048)"`
+	Picture             *string  `gorm:"type:varchar(2048)"`
+	Website             *string  `gorm:"type:varchar(2048)"`
+	Gender              *string  `gorm:"type:varchar(63)"`
+	Birthdate           *string  `gorm:"type:varchar(10)"`
+	Zoneinfo            *string  `gorm:"type:varchar(63)"`
+	Locale              *string  `gorm:"type:varchar(35)"`
+	PhoneNumber         *string  `gorm:"type:varchar(32)"`
+	PhoneNumberVerified bool     `gorm:"not null;default:false"`
+	Address             *Address `gorm:"type:jsonb"`
+	// CustomAttributes はテナントの UserAttributeSchema で定義されたカスタム属性
+	CustomAttributes UserAttributes `gorm:"type:jsonb;not null;default:'{}'"`
+	// SMSMFAEnabled はログイン時に SMS のワンタイムコードを2要素目として要求するか
+	SMSMFAEnabled bool `gorm:"not null;default:false"`
+	// ExternalID は SCIM クライアント (人事システムなど) での識別子 (RFC 7643 Section 3.1)
+	ExternalID *string `gorm:"type:varchar(255)"`
+
 	Tenant      Tenant       `gorm:"foreignKey:TenantID"`
 	Credentials []Credential `gorm:"foreignKey:UserID"`
 }
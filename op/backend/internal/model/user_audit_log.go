@@ -0,0 +1,54 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// ユーザーの監査ログのイベント
+const (
+	UserAuditEventEmailChangeRequested = "email_change_requested"
+	UserAuditEventEmailChanged         = "email_changed"
+	UserAuditEventEmailChangeReverted  = "email_change_reverted"
+)
+
+// UserAuditLog はユーザーのアカウント情報の変更履歴を表す。
+type UserAuditLog struct {
+	ID        uuid.UUID   `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID  uuid.UUID   `gorm:"type:uuid;not null"`
+	UserID    uuid.UUID   `gorm:"type:uuid;not null"`
+	Event     string      `gorm:"type:varchar(63);not null"`
+	Detail    AuditDetail `gorm:"type:jsonb;not null;default:'{}'"`
+	IPAddress string      `gorm:"type:varchar(45);not null;default:''"`
+	CreatedAt time.Time
+}
+
+func (UserAuditLog) TableName() string { return "user_audit_logs" }
+
+// AuditDetail は監査ログのイベントの内容 (変更前後の値など)
+type AuditDetail map[string]string
+
+func (d AuditDetail) Value() (driver.Value, error) {
+	if d == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(d)
+}
+
+func (d *AuditDetail) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*d = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, d)
+	case string:
+		return json.Unmarshal([]byte(v), d)
+	default:
+		return fmt.Errorf("AuditDetail.Scan: unsupported type %T", value)
+	}
+}
@@ -0,0 +1,59 @@
+package model
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// 移行元のエンドポイントに対するリクエストの認証方式。どちらも https で呼び出す
+const (
+	// UserMigrationHookAuthHMAC はリクエストボディに HMAC-SHA256 の署名を付ける
+	UserMigrationHookAuthHMAC = "hmac"
+	// UserMigrationHookAuthMTLS はクライアント証明書で TLS 接続する
+	UserMigrationHookAuthMTLS = "mtls"
+)
+
+// UserMigrationHook はローカルにパスワードのないユーザーのログイン時に、
+// 移行元のシステムで login_id とパスワードを検証する Webhook。テナントごとに 1 つ
+type UserMigrationHook struct {
+	ID                  uuid.UUID `gorm:"type:uuid;primaryKey;default:gen_random_uuid()"`
+	TenantID            uuid.UUID `gorm:"type:uuid;not null;uniqueIndex"`
+	URL                 string    `gorm:"column:url;type:varchar(2048);not null"`
+	AuthMethod          string    `gorm:"type:varchar(31);not null;default:'hmac'"`
+	HMACSecretEncrypted *string   `gorm:"column:hmac_secret_encrypted;type:text"`
+	// ClientCertificate は mTLS のクライアント証明書 (PEM)
+	ClientCertificate         *string `gorm:"type:text"`
+	ClientPrivateKeyEncrypted *string `gorm:"type:text"`
+	// CACertificate はサーバー証明書を検証する CA 証明書 (PEM)。nil の場合はシステムの信頼ストアを使う
+	CACertificate *string `gorm:"type:text"`
+	TimeoutMS     int     `gorm:"column:timeout_ms;not null;default:5000"`
+	Status        string  `gorm:"type:varchar(31);not null;default:'active'"`
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+}
+
+func (UserMigrationHook) TableName() string { return "user_migration_hooks" }
+
+// UserMigrationRequest は移行元で login_id とパスワードを検証する呼び出しの設定。HMACSecret・ClientPrivateKey は復号済みの値
+type UserMigrationRequest struct {
+	// HookID はサーキットブレーカーの状態を Webhook ごとに分けるために使う
+	HookID            uuid.UUID
+	URL               string
+	AuthMethod        string
+	HMACSecret        string
+	ClientCertificate string
+	ClientPrivateKey  string
+	CACertificate     string
+	Timeout           time.Duration
+	TenantCode        string
+	LoginID           string
+}
+
+// MigratedUser は移行元が返したユーザーのプロフィール。
+// Attributes は FederatedUserAttributes のうち移行元が返した値 (login_id を除く)
+type MigratedUser struct {
+	Attributes          map[string]string
+	EmailVerified       bool
+	PhoneNumberVerified bool
+}
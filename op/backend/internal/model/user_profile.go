@@ -0,0 +1,91 @@
+package model
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// StandardUserClaims は OP が提供する OIDC 標準のユーザークレーム (OIDC Core 1.0 Section 5.1)。sub は含めない
+var StandardUserClaims = []string{
+	"name", "given_name", "family_name", "middle_name", "nickname", "preferred_username",
+	"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	"email", "email_verified", "phone_number", "phone_number_verified", "address",
+}
+
+// reservedClaimNames はカスタム属性名に使えないクレーム名。標準クレームと、ID トークン・アクセストークンで OP が設定するクレーム
+var reservedClaimNames = map[string]bool{
+	"sub": true, "iss": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+	"auth_time": true, "nonce": true, "acr": true, "amr": true, "azp": true, "at_hash": true, "c_hash": true,
+	"sid": true, "cnf": true, "act": true, "may_act": true, "scope": true, "client_id": true,
+	"authorization_details": true, "_claim_names": true, "_claim_sources": true,
+}
+
+// IsReservedClaimName はカスタム属性名として使えないクレーム名か判定する
+func IsReservedClaimName(name string) bool {
+	if reservedClaimNames[name] {
+		return true
+	}
+	for _, c := range StandardUserClaims {
+		if c == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Address は address クレームの値 (OIDC Core 1.0 Section 5.1.1)
+type Address struct {
+	Formatted     string `json:"formatted,omitempty"`
+	StreetAddress string `json:"street_address,omitempty"`
+	Locality      string `json:"locality,omitempty"`
+	Region        string `json:"region,omitempty"`
+	PostalCode    string `json:"postal_code,omitempty"`
+	Country       string `json:"country,omitempty"`
+}
+
+// IsEmpty はいずれの項目も設定されていないか判定する
+func (a *Address) IsEmpty() bool {
+	return a == nil || *a == Address{}
+}
+
+func (a Address) Value() (driver.Value, error) {
+	return json.Marshal(a)
+}
+
+func (a *Address) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	default:
+		return fmt.Errorf("Address.Scan: unsupported type %T", value)
+	}
+}
+
+// UserAttributes はユーザーのカスタム属性。キーは属性名 (= クレーム名)
+type UserAttributes map[string]interface{}
+
+func (a UserAttributes) Value() (driver.Value, error) {
+	if a == nil {
+		return []byte("{}"), nil
+	}
+	return json.Marshal(a)
+}
+
+func (a *UserAttributes) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		return json.Unmarshal(v, a)
+	case string:
+		return json.Unmarshal([]byte(v), a)
+	default:
+		return fmt.Errorf("UserAttributes.Scan: unsupported type %T", value)
+	}
+}
@@ -0,0 +1,28 @@
+// Package notifier は CIBA の認証デバイス通知 (oidc.AuthenticationDeviceNotifier) の実装を提供する。
+package notifier
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// LogNotifier は承認依頼をログに出力する。ローカル開発・テスト用の認証デバイスの代替で、
+// ログに出た approval_url をブラウザで開くとユーザーとして承認できる。
+type LogNotifier struct {
+	logger *log.Logger
+}
+
+// NewLogNotifier は LogNotifier を生成する。
+func NewLogNotifier(logger *log.Logger) *LogNotifier {
+	return &LogNotifier{logger: logger}
+}
+
+// NotifyAuthenticationRequest は承認依頼の内容をログに出力する。
+func (n *LogNotifier) NotifyAuthenticationRequest(_ context.Context, req *model.AuthenticationDeviceNotification) error {
+	n.logger.Printf("[ciba] authentication request: tenant=%s login_id=%s client=%q scope=%q binding_message=%q approval_url=%s expires_at=%s",
+		req.TenantCode, req.LoginID, req.ClientName, req.Scope, req.BindingMessage, req.ApprovalURL, req.ExpiresAt.Format(time.RFC3339))
+	return nil
+}
@@ -0,0 +1,66 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/jsonschema"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// decodeAuthorizationDetails は authorization_details パラメータを JSON 配列として解析する。指定が無い場合は nil を返す。
+// 各要素は文字列の type を持つオブジェクトであること (MUST: RFC 9396 Section 2)
+func decodeAuthorizationDetails(raw string) (model.AuthorizationDetails, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var details model.AuthorizationDetails
+	if err := json.Unmarshal([]byte(raw), &details); err != nil {
+		return nil, fmt.Errorf("%w: authorization_details must be a JSON array of objects", ErrInvalidAuthorizationDetails)
+	}
+	if len(details) == 0 {
+		return nil, fmt.Errorf("%w: authorization_details must not be empty", ErrInvalidAuthorizationDetails)
+	}
+	for i, detail := range details {
+		if t, ok := detail["type"].(string); !ok || t == "" {
+			return nil, fmt.Errorf("%w: authorization_details[%d] must have a type", ErrInvalidAuthorizationDetails, i)
+		}
+	}
+	return details, nil
+}
+
+// parseAuthorizationDetails は authorization_details を解析し、各要素をテナントに登録された type のスキーマで検証する。
+// 未登録の type やスキーマに適合しない要素は invalid_authorization_details とする (RFC 9396 Section 5)
+func parseAuthorizationDetails(ctx context.Context, finder AuthorizationDetailTypeFinder, tenantID uuid.UUID, raw string) (model.AuthorizationDetails, error) {
+	details, err := decodeAuthorizationDetails(raw)
+	if err != nil || details == nil {
+		return nil, err
+	}
+
+	schemas := map[string]*jsonschema.Schema{}
+	for i, detail := range details {
+		t := detail["type"].(string)
+		schema, ok := schemas[t]
+		if !ok {
+			registered, err := finder.FindByType(ctx, tenantID, t)
+			if err != nil {
+				return nil, fmt.Errorf("failed to find authorization detail type: %w", err)
+			}
+			if registered == nil {
+				return nil, fmt.Errorf("%w: unknown authorization_details type: %s", ErrInvalidAuthorizationDetails, t)
+			}
+			if schema, err = jsonschema.Parse([]byte(registered.Schema)); err != nil {
+				return nil, fmt.Errorf("invalid schema for authorization detail type %s: %w", t, err)
+			}
+			schemas[t] = schema
+		}
+		if err := schema.Validate(map[string]interface{}(detail)); err != nil {
+			return nil, fmt.Errorf("%w: authorization_details[%d]: %v", ErrInvalidAuthorizationDetails, i, err)
+		}
+	}
+	return details, nil
+}
@@ -0,0 +1,140 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// authorizationDetailsApprovalLifetime は authorization_details の承認待ち・承認済みの記録の有効期間
+const authorizationDetailsApprovalLifetime = 10 * time.Minute
+
+// authorizationDetailsHash は authorization_details を正規化した JSON (オブジェクトのキー順) の SHA-256 ハッシュ値を返す。
+// 承認した内容と認可リクエストの内容が同じか照合するために使う
+func authorizationDetailsHash(details model.AuthorizationDetails) (string, error) {
+	raw, err := json.Marshal(details)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// AuthorizationDetailsApprovalHandler は OP Frontend の authorization_details 承認画面向けの内部 API を処理する。
+// 画面に示す内容は OP が保持する検証済みの値のみとし、承認は承認を求めたログインセッションからのみ受け付ける。
+type AuthorizationDetailsApprovalHandler struct {
+	tenantFinder     TenantFinder
+	approvalStore    AuthorizationDetailsApprovalStore
+	sessionValidator SessionValidator
+}
+
+func NewAuthorizationDetailsApprovalHandler(
+	tenantFinder TenantFinder,
+	approvalStore AuthorizationDetailsApprovalStore,
+	sessionValidator SessionValidator,
+) *AuthorizationDetailsApprovalHandler {
+	return &AuthorizationDetailsApprovalHandler{
+		tenantFinder:     tenantFinder,
+		approvalStore:    approvalStore,
+		sessionValidator: sessionValidator,
+	}
+}
+
+type authorizationDetailsApprovalResponse struct {
+	ClientName           string                     `json:"client_name"`
+	AuthorizationDetails model.AuthorizationDetails `json:"authorization_details"`
+	ExpiresAt            string                     `json:"expires_at"`
+}
+
+type authorizationDetailsDecideRequest struct {
+	TenantCode string `json:"tenant_code"`
+	ApprovalID string `json:"approval_id"`
+	Approved   bool   `json:"approved"`
+}
+
+// HandleGet は GET /internal/authorization-details を処理する。
+// 承認待ちの authorization_details とクライアント名を返す。
+func (h *AuthorizationDetailsApprovalHandler) HandleGet(c echo.Context) error {
+	approval, status, errCode := h.resolve(c, c.QueryParam("tenant_code"), c.QueryParam("approval_id"))
+	if errCode != "" {
+		return c.JSON(status, map[string]string{"error": errCode})
+	}
+	if approval.Status != model.AuthorizationDetailsApprovalStatusPending {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request_not_pending"})
+	}
+	return c.JSON(http.StatusOK, authorizationDetailsApprovalResponse{
+		ClientName:           approval.Client.Name,
+		AuthorizationDetails: approval.AuthorizationDetails,
+		ExpiresAt:            approval.ExpiresAt.Format(time.RFC3339),
+	})
+}
+
+// HandleDecide は POST /internal/authorization-details/decide を処理する。
+// ユーザーの承認・拒否を記録する。結果は認可リクエストをやり直した際に使われる。
+func (h *AuthorizationDetailsApprovalHandler) HandleDecide(c echo.Context) error {
+	var req authorizationDetailsDecideRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+	approval, status, errCode := h.resolve(c, req.TenantCode, req.ApprovalID)
+	if errCode != "" {
+		return c.JSON(status, map[string]string{"error": errCode})
+	}
+
+	ok, err := h.approvalStore.Decide(c.Request().Context(), approval.ID, req.Approved)
+	if err != nil {
+		c.Logger().Errorf("failed to decide authorization details approval: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if !ok {
+		// 同時に別の画面で処理された、または期限切れになった
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request_not_pending"})
+	}
+
+	result := model.AuthorizationDetailsApprovalStatusDenied
+	if req.Approved {
+		result = model.AuthorizationDetailsApprovalStatusApproved
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": result})
+}
+
+// resolve はログインセッションを検証し、そのセッションが承認を求められた記録を返す。
+// 失敗した場合は HTTP ステータスとエラーコードを返す
+func (h *AuthorizationDetailsApprovalHandler) resolve(c echo.Context, tenantCode, rawID string) (*model.AuthorizationDetailsApproval, int, string) {
+	ctx := c.Request().Context()
+
+	approvalID, err := uuid.Parse(rawID)
+	if err != nil || tenantCode == "" {
+		return nil, http.StatusBadRequest, "invalid_request"
+	}
+
+	session := sessionFromCookie(c, h.sessionValidator)
+	if session == nil {
+		return nil, http.StatusUnauthorized, "no_session"
+	}
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return nil, http.StatusInternalServerError, "server_error"
+	}
+	if tenant == nil || session.TenantID != tenant.ID {
+		return nil, http.StatusUnauthorized, "invalid_session"
+	}
+
+	approval, err := h.approvalStore.FindByID(ctx, approvalID)
+	if err != nil {
+		c.Logger().Errorf("failed to find authorization details approval: %v", err)
+		return nil, http.StatusInternalServerError, "server_error"
+	}
+	// 別のセッションの記録は存在しないものとして扱う
+	if approval == nil || approval.SessionID != session.ID || approval.IsExpired() {
+		return nil, http.StatusNotFound, "not_found"
+	}
+	return approval, 0, ""
+}
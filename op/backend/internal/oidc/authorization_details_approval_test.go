@@ -0,0 +1,245 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const paymentDetails = `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"123.50"}}]`
+
+func TestAuthorizationDetailsHash(t *testing.T) {
+	var a, b, c model.AuthorizationDetails
+	_ = json.Unmarshal([]byte(`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"}}]`), &a)
+	_ = json.Unmarshal([]byte(`[{"instructedAmount":{"amount":"1","currency":"EUR"},"type":"payment_initiation"}]`), &b)
+	_ = json.Unmarshal([]byte(`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"2"}}]`), &c)
+
+	ha, _ := authorizationDetailsHash(a)
+	hb, _ := authorizationDetailsHash(b)
+	hc, _ := authorizationDetailsHash(c)
+	// キーの順序は結果に影響しない
+	if ha != hb {
+		t.Errorf("hash depends on key order: %s != %s", ha, hb)
+	}
+	if ha == hc {
+		t.Error("different details share a hash")
+	}
+	if len(ha) != 64 {
+		t.Errorf("hash = %q", ha)
+	}
+}
+
+func TestAuthorizeRequiresDetailsApproval(t *testing.T) {
+	f := newAuthorizeFixture()
+
+	t.Run("prompt=none は consent_required", func(t *testing.T) {
+		rec := f.authorize(url.Values{"authorization_details": {paymentDetails}, "prompt": {"none"}})
+		if q := location(rec).Query(); q.Get("error") != "consent_required" || q.Get("state") != "xyz" {
+			t.Fatalf("status = %d, location = %s", rec.Code, location(rec))
+		}
+		if len(f.codes.codes) != 0 || len(f.approvals.approvals) != 0 {
+			t.Errorf("codes = %d, approvals = %d", len(f.codes.codes), len(f.approvals.approvals))
+		}
+	})
+
+	// 承認画面へ。画面には approval_id のみ渡し、内容はクエリに含めない
+	rec := f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	loc := location(rec)
+	if rec.Code != http.StatusFound || loc.Host != "login.example.com" || loc.Path != "/authorization-details" {
+		t.Fatalf("status = %d, location = %s", rec.Code, loc)
+	}
+	if loc.Query().Has("authorization_details") || strings.Contains(loc.RawQuery, "instructedAmount=") {
+		t.Errorf("details leaked into the approval page URL: %s", loc)
+	}
+	approvalID, err := uuid.Parse(loc.Query().Get("approval_id"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	approval := f.approvals.approvals[approvalID]
+	if approval == nil || approval.SessionID != f.session.ID || approval.ClientID != f.client.ID || approval.Status != model.AuthorizationDetailsApprovalStatusPending {
+		t.Fatalf("approval = %+v", approval)
+	}
+	if after, _ := url.Parse(loc.Query().Get("redirect_after_approval")); after == nil || after.Query().Get("authorization_details") != paymentDetails {
+		t.Errorf("redirect_after_approval = %q", loc.Query().Get("redirect_after_approval"))
+	}
+
+	// 承認前にやり直しても同じ承認待ちの記録を使う
+	rec = f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	if location(rec).Query().Get("approval_id") != approvalID.String() || len(f.approvals.approvals) != 1 {
+		t.Errorf("location = %s, approvals = %d", location(rec), len(f.approvals.approvals))
+	}
+
+	// 承認した内容と異なる authorization_details には使えない
+	_, _ = f.approvals.Decide(context.Background(), approvalID, true)
+	other := strings.Replace(paymentDetails, "123.50", "9999.00", 1)
+	rec = f.authorize(url.Values{"authorization_details": {other}})
+	if location(rec).Path != "/authorization-details" || len(f.codes.codes) != 0 {
+		t.Fatalf("other details: location = %s", location(rec))
+	}
+
+	// 承認後は認可コードを発行し、承認は1回で使い切る
+	rec = f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	if code := location(rec).Query().Get("code"); code == "" || len(f.codes.codes) != 1 {
+		t.Fatalf("status = %d, location = %s", rec.Code, location(rec))
+	}
+	if got := f.codes.codes[0].AuthorizationDetails; len(got) != 1 || got[0]["type"] != "payment_initiation" {
+		t.Errorf("code details = %v", got)
+	}
+	if approval.Status != model.AuthorizationDetailsApprovalStatusConsumed {
+		t.Errorf("approval status = %s", approval.Status)
+	}
+	rec = f.authorize(url.Values{"authorization_details": {paymentDetails}, "prompt": {"none"}})
+	if location(rec).Query().Get("error") != "consent_required" || len(f.codes.codes) != 1 {
+		t.Errorf("reused approval: location = %s", location(rec))
+	}
+}
+
+func TestAuthorizeDeniedDetails(t *testing.T) {
+	f := newAuthorizeFixture()
+	rec := f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	approvalID, _ := uuid.Parse(location(rec).Query().Get("approval_id"))
+	_, _ = f.approvals.Decide(context.Background(), approvalID, false)
+
+	rec = f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	if location(rec).Query().Get("error") != "access_denied" || len(f.codes.codes) != 0 {
+		t.Fatalf("location = %s", location(rec))
+	}
+	// 拒否も1回で使い切り、次の認可リクエストでは改めて承認を求める
+	rec = f.authorize(url.Values{"authorization_details": {paymentDetails}})
+	if id := location(rec).Query().Get("approval_id"); id == "" || id == approvalID.String() {
+		t.Errorf("location = %s", location(rec))
+	}
+}
+
+func TestAuthorizeWithoutDetailsSkipsApproval(t *testing.T) {
+	f := newAuthorizeFixture()
+	rec := f.authorize(url.Values{"prompt": {"none"}})
+	if location(rec).Query().Get("code") == "" || len(f.approvals.approvals) != 0 {
+		t.Errorf("location = %s", location(rec))
+	}
+}
+
+// detailsApprovalFixture はログイン済みのセッションと承認待ちの記録を用意する
+type detailsApprovalFixture struct {
+	handler  *AuthorizationDetailsApprovalHandler
+	store    *fakeDetailsApprovalStore
+	session  *model.Session
+	other    *model.Session
+	approval *model.AuthorizationDetailsApproval
+}
+
+func newDetailsApprovalFixture() *detailsApprovalFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	session := newTestSession(tenant)
+	other := newTestSession(tenant)
+	var details model.AuthorizationDetails
+	_ = json.Unmarshal([]byte(paymentDetails), &details)
+
+	store := newFakeDetailsApprovalStore()
+	approval := &model.AuthorizationDetailsApproval{
+		SessionID:            session.ID,
+		ClientID:             uuid.New(),
+		AuthorizationDetails: details,
+		Status:               model.AuthorizationDetailsApprovalStatusPending,
+		ExpiresAt:            time.Now().Add(time.Minute),
+		Client:               model.Client{Name: "Bank"},
+	}
+	_ = store.Create(context.Background(), approval)
+
+	h := NewAuthorizationDetailsApprovalHandler(
+		newFakeTenantFinder(tenant),
+		store,
+		newFakeSessionValidator(session, other),
+	)
+	return &detailsApprovalFixture{handler: h, store: store, session: session, other: other, approval: approval}
+}
+
+func (f *detailsApprovalFixture) get(session *model.Session, approvalID string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, "/internal/authorization-details?tenant_code=demo&approval_id="+approvalID, nil)
+	if session != nil {
+		req.AddCookie(&http.Cookie{Name: "op_session", Value: session.ID.String()})
+	}
+	rec := httptest.NewRecorder()
+	if err := f.handler.HandleGet(echo.New().NewContext(req, rec)); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func (f *detailsApprovalFixture) decide(session *model.Session, approved bool) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(map[string]interface{}{"tenant_code": "demo", "approval_id": f.approval.ID.String(), "approved": approved})
+	req := httptest.NewRequest(http.MethodPost, "/internal/authorization-details/decide", strings.NewReader(string(raw)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.AddCookie(&http.Cookie{Name: "op_session", Value: session.ID.String()})
+	rec := httptest.NewRecorder()
+	if err := f.handler.HandleDecide(echo.New().NewContext(req, rec)); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func TestAuthorizationDetailsApprovalHandler(t *testing.T) {
+	f := newDetailsApprovalFixture()
+
+	rec := f.get(f.session, f.approval.ID.String())
+	var body authorizationDetailsApprovalResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if body.ClientName != "Bank" || len(body.AuthorizationDetails) != 1 || body.AuthorizationDetails[0]["type"] != "payment_initiation" {
+		t.Errorf("body = %+v", body)
+	}
+
+	errorTests := []struct {
+		name    string
+		session *model.Session
+		id      string
+		status  int
+	}{
+		{name: "セッションなし", session: nil, id: f.approval.ID.String(), status: http.StatusUnauthorized},
+		{name: "別のセッション", session: f.other, id: f.approval.ID.String(), status: http.StatusNotFound},
+		{name: "存在しない", session: f.session, id: uuid.NewString(), status: http.StatusNotFound},
+		{name: "ID が不正", session: f.session, id: "x", status: http.StatusBadRequest},
+	}
+	for _, tt := range errorTests {
+		t.Run(tt.name, func(t *testing.T) {
+			if rec := f.get(tt.session, tt.id); rec.Code != tt.status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.status)
+			}
+		})
+	}
+
+	// 別のセッションからは承認できない
+	if rec := f.decide(f.other, true); rec.Code != http.StatusNotFound || f.approval.Status != model.AuthorizationDetailsApprovalStatusPending {
+		t.Fatalf("other session: status = %d, approval = %s", rec.Code, f.approval.Status)
+	}
+
+	if rec := f.decide(f.session, true); rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"approved"`) {
+		t.Fatalf("decide: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	// 決定は1回のみ
+	if rec := f.decide(f.session, false); rec.Code != http.StatusBadRequest || f.approval.Status != model.AuthorizationDetailsApprovalStatusApproved {
+		t.Errorf("second decide: status = %d, approval = %s", rec.Code, f.approval.Status)
+	}
+	if rec := f.get(f.session, f.approval.ID.String()); rec.Code != http.StatusBadRequest {
+		t.Errorf("get after decide: status = %d", rec.Code)
+	}
+
+	t.Run("期限切れ", func(t *testing.T) {
+		f := newDetailsApprovalFixture()
+		f.approval.ExpiresAt = time.Now().Add(-time.Second)
+		if rec := f.decide(f.session, true); rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d", rec.Code)
+		}
+	})
+}
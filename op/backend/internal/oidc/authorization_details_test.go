@@ -0,0 +1,68 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+)
+
+const paymentInitiationSchema = `{
+	"type": "object",
+	"required": ["type", "instructedAmount"],
+	"properties": {
+		"type": {"const": "payment_initiation"},
+		"instructedAmount": {
+			"type": "object",
+			"required": ["currency", "amount"],
+			"properties": {"currency": {"type": "string"}, "amount": {"type": "string"}}
+		}
+	}
+}`
+
+func TestParseAuthorizationDetails(t *testing.T) {
+	finder := &fakeDetailTypeFinder{types: map[string]string{"payment_initiation": paymentInitiationSchema}}
+
+	details, err := parseAuthorizationDetails(context.Background(), finder, uuid.New(),
+		`[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"123.50"}}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details) != 1 || details[0]["type"] != "payment_initiation" {
+		t.Errorf("details = %v", details)
+	}
+
+	if details, err := parseAuthorizationDetails(context.Background(), finder, uuid.New(), ""); details != nil || err != nil {
+		t.Errorf("empty parameter = %v, %v", details, err)
+	}
+
+	tests := []struct {
+		name string
+		raw  string
+	}{
+		{name: "JSON でない", raw: `payment_initiation`},
+		{name: "配列でない", raw: `{"type":"payment_initiation"}`},
+		{name: "空の配列", raw: `[]`},
+		{name: "type なし", raw: `[{"instructedAmount":{"currency":"EUR","amount":"1"}}]`},
+		{name: "type が文字列でない", raw: `[{"type":1}]`},
+		{name: "未登録の type", raw: `[{"type":"account_information"}]`},
+		{name: "スキーマに適合しない", raw: `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR"}}]`},
+		{name: "2つ目の要素が不正", raw: `[{"type":"payment_initiation","instructedAmount":{"currency":"EUR","amount":"1"}},{"type":"payment_initiation"}]`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := parseAuthorizationDetails(context.Background(), finder, uuid.New(), tt.raw); !errors.Is(err, ErrInvalidAuthorizationDetails) {
+				t.Fatalf("err = %v, want ErrInvalidAuthorizationDetails", err)
+			}
+		})
+	}
+
+	t.Run("登録されたスキーマが壊れている場合はサーバーエラー", func(t *testing.T) {
+		broken := &fakeDetailTypeFinder{types: map[string]string{"payment_initiation": `{"type":`}}
+		_, err := parseAuthorizationDetails(context.Background(), broken, uuid.New(), `[{"type":"payment_initiation"}]`)
+		if err == nil || errors.Is(err, ErrInvalidAuthorizationDetails) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
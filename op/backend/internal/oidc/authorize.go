@@ -3,6 +3,8 @@ package oidc
 import (
 	"crypto/rand"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"net/http"
 	"net/url"
 	"strings"
@@ -15,26 +17,53 @@ import (
 )
 
 type AuthorizeHandler struct {
-	tenantFinder     TenantFinder
-	clientFinder     ClientFinder
-	authCodeStore    AuthorizationCodeStore
-	sessionValidator SessionValidator
-	loginPageURL     string
+	tenantFinder      TenantFinder
+	clientFinder      ClientFinder
+	authCodeStore     AuthorizationCodeStore
+	apiResourceFinder APIResourceFinder
+	detailTypeFinder  AuthorizationDetailTypeFinder
+	detailsApprovals  AuthorizationDetailsApprovalStore
+	scopeFinder       ScopeDefinitionFinder
+	consentStore      UserConsentStore
+	subjectMapper     *SubjectMapper
+	requestObjects    *RequestObjectResolver
+	sessionValidator  SessionValidator
+	responseSigner    AuthorizationResponseSigner
+	issuerBaseURL     string
+	loginPageURL      string
 }
 
 func NewAuthorizeHandler(
 	tenantFinder TenantFinder,
 	clientFinder ClientFinder,
 	authCodeStore AuthorizationCodeStore,
+	apiResourceFinder APIResourceFinder,
+	detailTypeFinder AuthorizationDetailTypeFinder,
+	detailsApprovals AuthorizationDetailsApprovalStore,
+	scopeFinder ScopeDefinitionFinder,
+	consentStore UserConsentStore,
+	subjectMapper *SubjectMapper,
+	requestObjects *RequestObjectResolver,
 	sessionValidator SessionValidator,
+	responseSigner AuthorizationResponseSigner,
+	issuerBaseURL string,
 	loginPageURL string,
 ) *AuthorizeHandler {
 	return &AuthorizeHandler{
-		tenantFinder:     tenantFinder,
-		clientFinder:     clientFinder,
-		authCodeStore:    authCodeStore,
-		sessionValidator: sessionValidator,
-		loginPageURL:     loginPageURL,
+		tenantFinder:      tenantFinder,
+		clientFinder:      clientFinder,
+		authCodeStore:     authCodeStore,
+		apiResourceFinder: apiResourceFinder,
+		detailTypeFinder:  detailTypeFinder,
+		detailsApprovals:  detailsApprovals,
+		scopeFinder:       scopeFinder,
+		consentStore:      consentStore,
+		subjectMapper:     subjectMapper,
+		requestObjects:    requestObjects,
+		sessionValidator:  sessionValidator,
+		responseSigner:    responseSigner,
+		issuerBaseURL:     issuerBaseURL,
+		loginPageURL:      loginPageURL,
 	}
 }
 
@@ -53,21 +82,8 @@ func (h *AuthorizeHandler) Handle(c echo.Context) error {
 		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
 	}
 
-	// リクエストパラメータ取得
-	responseType := c.QueryParam("response_type")
+	// client_id はリクエストオブジェクトの検証鍵を決めるためクエリから取得する (RFC 9101 Section 5)
 	clientID := c.QueryParam("client_id")
-	redirectURI := c.QueryParam("redirect_uri")
-	scope := c.QueryParam("scope")
-	state := c.QueryParam("state")
-	nonce := c.QueryParam("nonce")
-	codeChallenge := c.QueryParam("code_challenge")
-	codeChallengeMethod := c.QueryParam("code_challenge_method")
-	prompt := c.QueryParam("prompt")
-
-	// response_type 検証 (MUST: "code" のみ)
-	if responseType != "code" {
-		return errorResponseDirect(c, "unsupported_response_type", "only response_type=code is supported")
-	}
 
 	// client_id 検証
 	if clientID == "" {
@@ -87,6 +103,34 @@ func (h *AuthorizeHandler) Handle(c echo.Context) error {
 		return errorResponseDirect(c, "invalid_request", "client does not belong to this tenant")
 	}
 
+	// リクエストオブジェクトがある場合はその中のパラメータのみを使う (MUST: RFC 9101 Section 6.3)
+	params := c.QueryParams()
+	request, requestURI := c.QueryParam("request"), c.QueryParam("request_uri")
+	if request != "" || requestURI != "" {
+		resolved, err := h.requestObjects.Resolve(ctx, client, tenantCode, request, requestURI)
+		if err != nil {
+			return requestObjectError(c, err)
+		}
+		params = resolved
+	} else if client.RequireSignedRequestObject {
+		return errorResponseDirect(c, "invalid_request", "signed request object is required")
+	}
+
+	// リクエストパラメータ取得
+	responseType := params.Get("response_type")
+	redirectURI := params.Get("redirect_uri")
+	scope := params.Get("scope")
+	state := params.Get("state")
+	nonce := params.Get("nonce")
+	codeChallenge := params.Get("code_challenge")
+	codeChallengeMethod := params.Get("code_challenge_method")
+	prompt := params.Get("prompt")
+
+	// response_type 検証 (MUST: "code" のみ)
+	if responseType != "code" {
+		return errorResponseDirect(c, "unsupported_response_type", "only response_type=code is supported")
+	}
+
 	// redirect_uri 完全一致検証 (MUST: RFC 6749 Section 3.1.2.3)
 	// 検証失敗時はリダイレクトしない（MUST: RFC 6749 Section 4.1.2.1）
 	if redirectURI == "" {
@@ -96,57 +140,188 @@ func (h *AuthorizeHandler) Handle(c echo.Context) error {
 		return errorResponseDirect(c, "invalid_request", "redirect_uri mismatch")
 	}
 
-	// ここから先はエラーをredirect_uriにリダイレクトで返す
+	// ここから先はエラーをredirect_uriにresponse_modeに従って返す
+	ar := &authorizationResponse{
+		redirectURI:  redirectURI,
+		responseMode: params.Get("response_mode"),
+		state:        state,
+		issuer:       h.issuerBaseURL + "/" + tenantCode,
+		clientID:     client.ClientID,
+	}
+	if ar.responseMode != "" && !isSupportedResponseMode(ar.responseMode) {
+		ar.responseMode = ""
+		return h.sendError(c, ar, "invalid_request", "unsupported response_mode")
+	}
+
+	// scope 省略時はクライアントの既定のスコープを使う (RFC 6749 Section 3.3)
+	if scope == "" && len(client.DefaultScopes) > 0 {
+		scope = strings.Join(client.DefaultScopes, " ")
+	}
 
 	// scope 検証 ("openid" 必須)
-	scopes := strings.Split(scope, " ")
+	scopes := strings.Fields(scope)
 	if !containsScope(scopes, "openid") {
-		return errorRedirect(c, redirectURI, state, "invalid_scope", "openid scope is required")
+		return h.sendError(c, ar, "invalid_scope", "openid scope is required")
+	}
+
+	// テナントで利用でき、クライアントに許可されたスコープのみ受け付ける
+	availableScopes, err := loadTenantScopes(ctx, h.scopeFinder, h.apiResourceFinder, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to load scopes: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if err := availableScopes.validate(client, scopes); err != nil {
+		return h.sendError(c, ar, "invalid_scope", err.Error())
 	}
 
 	// grant_type サポート確認
 	if !client.HasGrantType("authorization_code") {
-		return errorRedirect(c, redirectURI, state, "unauthorized_client", "client does not support authorization_code grant")
+		return h.sendError(c, ar, "unauthorized_client", "client does not support authorization_code grant")
+	}
+
+	// resource 検証: テナントに登録された API リソースのみ受け付ける (RFC 8707 Section 2)
+	resources, ok := parseResourceParams(params["resource"])
+	if !ok {
+		return h.sendError(c, ar, "invalid_target", "resource must be an absolute URI without a fragment")
+	}
+	for _, identifier := range resources {
+		resource, err := h.apiResourceFinder.FindByIdentifier(ctx, tenant.ID, identifier)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if resource == nil {
+			return h.sendError(c, ar, "invalid_target", "unknown resource: "+identifier)
+		}
+	}
+
+	// claims パラメータ検証 (OIDC Core 1.0 Section 5.5)
+	claimsReq, err := parseClaimsParam(params.Get("claims"))
+	if err != nil {
+		return h.sendError(c, ar, "invalid_request", err.Error())
+	}
+
+	// authorization_details 検証: テナントに登録された type のスキーマに適合すること (RFC 9396 Section 2)
+	details, err := parseAuthorizationDetails(ctx, h.detailTypeFinder, tenant.ID, params.Get("authorization_details"))
+	if err != nil {
+		if errors.Is(err, ErrInvalidAuthorizationDetails) {
+			return h.sendError(c, ar, ErrInvalidAuthorizationDetails.Error(), strings.TrimPrefix(err.Error(), ErrInvalidAuthorizationDetails.Error()+": "))
+		}
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
 	}
 
-	// PKCE 検証
-	if client.RequirePKCE {
+	// PKCE 検証 (公開クライアントは設定に関わらず必須)
+	if client.RequirePKCE || client.IsPublic() {
 		if codeChallenge == "" {
-			return errorRedirect(c, redirectURI, state, "invalid_request", "code_challenge is required")
+			return h.sendError(c, ar, "invalid_request", "code_challenge is required")
 		}
 		if codeChallengeMethod != "S256" {
-			return errorRedirect(c, redirectURI, state, "invalid_request", "only S256 code_challenge_method is supported")
+			return h.sendError(c, ar, "invalid_request", "only S256 code_challenge_method is supported")
 		}
 	}
 
 	// セッション確認
-	var sessionID *uuid.UUID
+	var session *model.Session
 	if cookie, err := c.Cookie("op_session"); err == nil {
 		if sid, err := uuid.Parse(cookie.Value); err == nil {
-			session, err := h.sessionValidator.ValidateSession(ctx, sid)
-			if err == nil && session != nil {
+			s, err := h.sessionValidator.ValidateSession(ctx, sid)
+			if err == nil && s != nil {
 				// テナントが一致するか確認
-				if session.TenantID == tenant.ID {
-					sessionID = &session.ID
+				if s.TenantID == tenant.ID {
+					session = s
 				}
 			}
 		}
 	}
 
 	// prompt パラメータ処理
-	if prompt == "none" && sessionID == nil {
-		return errorRedirect(c, redirectURI, state, "login_required", "")
+	if prompt == "none" && session == nil {
+		return h.sendError(c, ar, "login_required", "")
 	}
 
 	if prompt == "login" {
-		sessionID = nil // 再認証を要求
+		session = nil // 再認証を要求
 	}
 
 	// セッションがなければログインページにリダイレクト
-	if sessionID == nil {
+	if session == nil {
 		return h.redirectToLogin(c, tenantCode)
 	}
 
+	// sub の値が要求された場合は認証済みユーザーと一致する場合のみ成功させる (MUST: OIDC Core 1.0 Section 3.1.2.2)
+	if cr := requestedSubject(claimsReq); cr != nil {
+		subject, err := h.subjectMapper.Subject(ctx, client, session.UserID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if !cr.Accepts(subject) {
+			return h.sendError(c, ar, "login_required", "requested sub does not match the authenticated user")
+		}
+	}
+
+	// テナントがメールアドレスの確認を求める場合、未確認のユーザーは確認画面へ
+	if emailVerificationPending(session) {
+		if prompt == "none" {
+			return h.sendError(c, ar, "interaction_required", "email address is not verified")
+		}
+		return h.redirectToEmailVerification(c, tenantCode)
+	}
+
+	// 同意が必要なスコープのうち未同意のものがあれば同意画面へ (OIDC Core 1.0 Section 3.1.2.4)
+	consent, err := h.consentStore.FindByUserAndClient(ctx, session.UserID, client.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if pending := availableScopes.consentRequired(scopes, consent); len(pending) > 0 {
+		if prompt == "none" {
+			return h.sendError(c, ar, "consent_required", "")
+		}
+		return h.redirectToConsent(c, tenantCode, client, pending)
+	}
+	// claims パラメータで要求されたクレームは、クレームを定義するスコープが許可・同意されたもののみ認可する
+	claimsReq = availableScopes.filterClaimsRequest(claimsReq, tenant, client, consent)
+
+	// authorization_details はユーザーが OP の画面で内容を確認して承認したもののみ認可する (RFC 9396 Section 7)。
+	// 承認は同じセッション・クライアント・内容の認可リクエストで1回だけ使える
+	if len(details) > 0 {
+		detailsHash, err := authorizationDetailsHash(details)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		approval, err := h.detailsApprovals.FindLatest(ctx, session.ID, client.ID, detailsHash)
+		if err != nil {
+			c.Logger().Errorf("failed to find authorization details approval: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if approval == nil || approval.Status == model.AuthorizationDetailsApprovalStatusPending {
+			if prompt == "none" {
+				return h.sendError(c, ar, "consent_required", "authorization_details require user approval")
+			}
+			if approval == nil {
+				approval = &model.AuthorizationDetailsApproval{
+					SessionID:            session.ID,
+					ClientID:             client.ID,
+					DetailsHash:          detailsHash,
+					AuthorizationDetails: details,
+					Status:               model.AuthorizationDetailsApprovalStatusPending,
+					ExpiresAt:            time.Now().Add(authorizationDetailsApprovalLifetime),
+				}
+				if err := h.detailsApprovals.Create(ctx, approval); err != nil {
+					c.Logger().Errorf("failed to create authorization details approval: %v", err)
+					return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+				}
+			}
+			return h.redirectToDetailsApproval(c, tenantCode, approval.ID)
+		}
+		consumed, err := h.detailsApprovals.MarkConsumed(ctx, approval.ID)
+		if err != nil {
+			c.Logger().Errorf("failed to consume authorization details approval: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if !consumed || approval.Status != model.AuthorizationDetailsApprovalStatusApproved {
+			return h.sendError(c, ar, "access_denied", "authorization_details were not approved")
+		}
+	}
+
 	// 認可コード発行
 	codeBytes := make([]byte, 32)
 	if _, err := rand.Read(codeBytes); err != nil {
@@ -168,35 +343,26 @@ func (h *AuthorizeHandler) Handle(c echo.Context) error {
 	}
 
 	authCode := &model.AuthorizationCode{
-		SessionID:           *sessionID,
-		ClientID:            client.ID,
-		Code:                code,
-		RedirectURI:         redirectURI,
-		Scope:               scope,
-		Nonce:               noncePtr,
-		CodeChallenge:       challengePtr,
-		CodeChallengeMethod: methodPtr,
-		ExpiresAt:           time.Now().Add(time.Duration(tenant.AuthCodeLifetime) * time.Second),
+		SessionID:            session.ID,
+		ClientID:             client.ID,
+		Code:                 code,
+		RedirectURI:          redirectURI,
+		Scope:                scope,
+		Nonce:                noncePtr,
+		CodeChallenge:        challengePtr,
+		CodeChallengeMethod:  methodPtr,
+		Resources:            model.StringSlice(resources),
+		Claims:               claimsReq,
+		AuthorizationDetails: details,
+		ExpiresAt:            time.Now().Add(time.Duration(tenant.AuthCodeLifetime) * time.Second),
 	}
 
 	if err := h.authCodeStore.Create(ctx, authCode); err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
 	}
 
-	// redirect_uri に認可コードとstateを付与してリダイレクト
-	redirectURL, err := url.Parse(redirectURI)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
-	}
-
-	q := redirectURL.Query()
-	q.Set("code", code)
-	if state != "" {
-		q.Set("state", state)
-	}
-	redirectURL.RawQuery = q.Encode()
-
-	return c.Redirect(http.StatusFound, redirectURL.String())
+	// redirect_uri に認可コードとstateを付与して返す
+	return h.send(c, ar, url.Values{"code": {code}})
 }
 
 // redirectToLogin はログインページにリダイレクトする。
@@ -216,6 +382,82 @@ func (h *AuthorizeHandler) redirectToLogin(c echo.Context, tenantCode string) er
 	return c.Redirect(http.StatusFound, loginURL.String())
 }
 
+// redirectToEmailVerification はメールアドレス確認画面にリダイレクトする。
+// 確認後に認可リクエストをやり直すため、現在の authorize URL を redirect_after_verify パラメータに含める。
+func (h *AuthorizeHandler) redirectToEmailVerification(c echo.Context, tenantCode string) error {
+	verifyURL, err := url.Parse(h.loginPageURL + "/verify-email")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := verifyURL.Query()
+	q.Set("tenant_code", tenantCode)
+	q.Set("redirect_after_verify", c.Request().URL.String())
+	verifyURL.RawQuery = q.Encode()
+
+	return c.Redirect(http.StatusFound, verifyURL.String())
+}
+
+// emailVerificationPending はテナントがメールアドレスの確認を認可の条件とし、セッションのユーザーが未確認か判定する。
+// セッションのユーザーとテナントがプリロードされている必要がある
+func emailVerificationPending(session *model.Session) bool {
+	return session.Tenant.RequiresVerifiedEmailForAuthorization() && !session.User.EmailVerified
+}
+
+// consentScope は同意画面に示すスコープ
+type consentScope struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// consentScopes は同意が必要なスコープの定義を画面に示す形にする
+func consentScopes(pending []*model.ScopeDefinition) []consentScope {
+	scopes := make([]consentScope, len(pending))
+	for i, def := range pending {
+		scopes[i] = consentScope{Name: def.Name, Description: def.Description}
+	}
+	return scopes
+}
+
+// redirectToConsent は同意画面にリダイレクトする。
+// 同意後に認可リクエストをやり直すため、現在の authorize URL を redirect_after_consent パラメータに含める。
+func (h *AuthorizeHandler) redirectToConsent(c echo.Context, tenantCode string, client *model.Client, pending []*model.ScopeDefinition) error {
+	consentURL, err := url.Parse(h.loginPageURL + "/consent")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	raw, err := json.Marshal(consentScopes(pending))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	q := consentURL.Query()
+	q.Set("tenant_code", tenantCode)
+	q.Set("client_id", client.ClientID)
+	q.Set("client_name", client.Name)
+	q.Set("scopes", string(raw))
+	q.Set("redirect_after_consent", c.Request().URL.String())
+	consentURL.RawQuery = q.Encode()
+
+	return c.Redirect(http.StatusFound, consentURL.String())
+}
+
+// redirectToDetailsApproval は authorization_details の承認画面にリダイレクトする。
+// 画面は approval_id で OP が保持する内容を取得して示す。承認後に認可リクエストをやり直すため、現在の authorize URL を redirect_after_approval パラメータに含める。
+func (h *AuthorizeHandler) redirectToDetailsApproval(c echo.Context, tenantCode string, approvalID uuid.UUID) error {
+	approvalURL, err := url.Parse(h.loginPageURL + "/authorization-details")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := approvalURL.Query()
+	q.Set("tenant_code", tenantCode)
+	q.Set("approval_id", approvalID.String())
+	q.Set("redirect_after_approval", c.Request().URL.String())
+	approvalURL.RawQuery = q.Encode()
+
+	return c.Redirect(http.StatusFound, approvalURL.String())
+}
+
 func isRegisteredRedirectURI(registeredURIs []model.RedirectURI, uri string) bool {
 	for _, r := range registeredURIs {
 		if r.URI == uri {
@@ -244,22 +486,12 @@ func errorResponseDirect(c echo.Context, errCode, errDescription string) error {
 	return c.JSON(http.StatusBadRequest, body)
 }
 
-// errorRedirect は redirect_uri にエラーをクエリパラメータとしてリダイレクトする。
-func errorRedirect(c echo.Context, redirectURI, state, errCode, errDescription string) error {
-	u, err := url.Parse(redirectURI)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
-	}
-
-	q := u.Query()
-	q.Set("error", errCode)
-	if errDescription != "" {
-		q.Set("error_description", errDescription)
-	}
-	if state != "" {
-		q.Set("state", state)
+// requestObjectError はリクエストオブジェクトの検証失敗を返す。
+// オブジェクト内の redirect_uri は信頼できないためリダイレクトしない
+func requestObjectError(c echo.Context, err error) error {
+	code := ErrInvalidRequestObject
+	if errors.Is(err, ErrInvalidRequestURI) {
+		code = ErrInvalidRequestURI
 	}
-	u.RawQuery = q.Encode()
-
-	return c.Redirect(http.StatusFound, u.String())
+	return errorResponseDirect(c, code.Error(), strings.TrimPrefix(err.Error(), code.Error()+": "))
 }
@@ -0,0 +1,177 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeAuthCodeStore struct {
+	codes []*model.AuthorizationCode
+}
+
+func (f *fakeAuthCodeStore) Create(_ context.Context, code *model.AuthorizationCode) error {
+	f.codes = append(f.codes, code)
+	return nil
+}
+
+func (f *fakeAuthCodeStore) FindByCode(_ context.Context, code string) (*model.AuthorizationCode, error) {
+	for _, c := range f.codes {
+		if c.Code == code {
+			return c, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAuthCodeStore) MarkAsUsed(context.Context, uuid.UUID) error { return nil }
+
+// authorizeFixture はログイン済みのセッションと認可コードフローを使う機密クライアントを用意する
+type authorizeFixture struct {
+	handler   *AuthorizeHandler
+	tenant    *model.Tenant
+	client    *model.Client
+	session   *model.Session
+	scopes    *fakeScopeDefinitionFinder
+	consents  *fakeUserConsentStore
+	codes     *fakeAuthCodeStore
+	approvals *fakeDetailsApprovalStore
+}
+
+func newAuthorizeFixture() *authorizeFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo", AuthCodeLifetime: 60}
+	client := &model.Client{
+		ID:                      uuid.New(),
+		TenantID:                tenant.ID,
+		ClientID:                "rp",
+		Name:                    "RP",
+		Status:                  "active",
+		TokenEndpointAuthMethod: "client_secret_basic",
+		GrantTypes:              model.StringSlice{"authorization_code"},
+		RedirectURIs:            []model.RedirectURI{{URI: "https://rp.example.com/cb"}},
+	}
+	session := newTestSession(tenant)
+	f := &authorizeFixture{
+		tenant:    tenant,
+		client:    client,
+		session:   session,
+		scopes:    &fakeScopeDefinitionFinder{},
+		consents:  &fakeUserConsentStore{consents: map[uuid.UUID]*model.UserConsent{}},
+		codes:     &fakeAuthCodeStore{},
+		approvals: newFakeDetailsApprovalStore(),
+	}
+	f.handler = NewAuthorizeHandler(
+		newFakeTenantFinder(tenant),
+		newFakeClientFinder(client),
+		f.codes,
+		&fakeAPIResourceFinder{},
+		&fakeDetailTypeFinder{types: map[string]string{"payment_initiation": paymentInitiationSchema}},
+		f.approvals,
+		f.scopes,
+		f.consents,
+		nil,
+		NewRequestObjectResolver(testIssuerBaseURL),
+		newFakeSessionValidator(session),
+		&fakeResponseSigner{},
+		testIssuerBaseURL,
+		"https://login.example.com",
+	)
+	return f
+}
+
+// authorize は params に必須のパラメータを補って GET /demo/authorize を呼ぶ
+func (f *authorizeFixture) authorize(params url.Values) *httptest.ResponseRecorder {
+	q := url.Values{
+		"client_id":     {f.client.ClientID},
+		"response_type": {"code"},
+		"redirect_uri":  {"https://rp.example.com/cb"},
+		"scope":         {"openid"},
+		"state":         {"xyz"},
+	}
+	for k, v := range params {
+		q[k] = v
+	}
+	req := httptest.NewRequest(http.MethodGet, "/demo/authorize?"+q.Encode(), nil)
+	req.AddCookie(&http.Cookie{Name: "op_session", Value: f.session.ID.String()})
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("tenant_code")
+	c.SetParamValues(f.tenant.Code)
+	if err := f.handler.Handle(c); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func TestAuthorizeConsent(t *testing.T) {
+	f := newAuthorizeFixture()
+	f.scopes.defs = []model.ScopeDefinition{{TenantID: f.tenant.ID, Name: "payments", Description: "支払い", ConsentRequired: true}}
+	params := url.Values{"scope": {"openid payments"}}
+
+	if q := location(f.authorize(url.Values{"scope": {"openid payments"}, "prompt": {"none"}})).Query(); q.Get("error") != "consent_required" {
+		t.Fatalf("prompt=none: %v", q)
+	}
+	loc := location(f.authorize(params))
+	if loc.Path != "/consent" || !strings.Contains(loc.Query().Get("scopes"), `"payments"`) {
+		t.Fatalf("location = %s", loc)
+	}
+
+	_ = f.consents.Save(context.Background(), &model.UserConsent{UserID: f.session.UserID, ClientID: f.client.ID, Scopes: model.StringSlice{"payments"}})
+	if code := location(f.authorize(params)).Query().Get("code"); code == "" || f.codes.codes[0].Scope != "openid payments" {
+		t.Errorf("code = %q", code)
+	}
+
+	// クライアントに許可されていないスコープは同意があっても拒否する
+	f.client.AllowedScopes = model.StringSlice{"email"}
+	if q := location(f.authorize(params)).Query(); q.Get("error") != "invalid_scope" {
+		t.Errorf("disallowed scope: %v", q)
+	}
+}
+
+func TestAuthorizeFiltersClaimsRequest(t *testing.T) {
+	f := newAuthorizeFixture()
+	schema := `{"type":"object","properties":{"department":{"type":"string"}}}`
+	f.tenant.UserAttributeSchema = &schema
+	f.scopes.defs = []model.ScopeDefinition{{TenantID: f.tenant.ID, Name: "employee", ConsentRequired: true, Claims: model.ScopeClaimMappings{{Claim: "dept", Attribute: "department"}}}}
+	f.client.AllowedScopes = model.StringSlice{"openid", "email", "employee"}
+	params := url.Values{"claims": {`{"userinfo":{"email":null,"phone_number":null,"department":null},"id_token":{"acr":null,"department":{"essential":true}}}`}}
+
+	// クライアントに許可されていないスコープ (phone) と未同意のスコープ (employee) のクレームは保存しない
+	if code := location(f.authorize(params)).Query().Get("code"); code == "" {
+		t.Fatal("no code")
+	}
+	claims := f.codes.codes[0].Claims
+	if got := sortedClaimNames(claims.UserInfo); !reflect.DeepEqual(got, []string{"email"}) {
+		t.Errorf("userinfo = %v", got)
+	}
+	if got := sortedClaimNames(claims.IDToken); !reflect.DeepEqual(got, []string{"acr"}) {
+		t.Errorf("id_token = %v", got)
+	}
+
+	// 同意後は同意したスコープの属性を要求できる
+	_ = f.consents.Save(context.Background(), &model.UserConsent{UserID: f.session.UserID, ClientID: f.client.ID, Scopes: model.StringSlice{"employee"}})
+	f.authorize(params)
+	claims = f.codes.codes[1].Claims
+	if got := sortedClaimNames(claims.UserInfo); !reflect.DeepEqual(got, []string{"department", "email"}) {
+		t.Errorf("userinfo after consent = %v", got)
+	}
+}
+
+func sortedClaimNames(m map[string]*model.ClaimRequest) []string {
+	var names []string
+	for name := range m {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,279 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// cibaPingTimeout は ping 通知の HTTP タイムアウト
+const cibaPingTimeout = 10 * time.Second
+
+// BackchannelApprovalHandler は OP Frontend の CIBA 承認画面向けの内部 API と、クライアントへの ping 通知を処理する。
+// ユーザーは既存の op_session でログイン済みであることを前提とする。
+type BackchannelApprovalHandler struct {
+	tenantFinder      TenantFinder
+	requestStore      BackchannelAuthRequestStore
+	scopeFinder       ScopeDefinitionFinder
+	apiResourceFinder APIResourceFinder
+	consentStore      UserConsentStore
+	sessionValidator  SessionValidator
+	decryptSecret     DecryptSecretFunc
+	httpClient        *http.Client
+	frontendBaseURL   string
+}
+
+func NewBackchannelApprovalHandler(
+	tenantFinder TenantFinder,
+	requestStore BackchannelAuthRequestStore,
+	scopeFinder ScopeDefinitionFinder,
+	apiResourceFinder APIResourceFinder,
+	consentStore UserConsentStore,
+	sessionValidator SessionValidator,
+	decryptSecret DecryptSecretFunc,
+	frontendBaseURL string,
+) *BackchannelApprovalHandler {
+	return &BackchannelApprovalHandler{
+		tenantFinder:      tenantFinder,
+		requestStore:      requestStore,
+		scopeFinder:       scopeFinder,
+		apiResourceFinder: apiResourceFinder,
+		consentStore:      consentStore,
+		sessionValidator:  sessionValidator,
+		decryptSecret:     decryptSecret,
+		httpClient:        &http.Client{Timeout: cibaPingTimeout},
+		frontendBaseURL:   frontendBaseURL,
+	}
+}
+
+type cibaDecideRequest struct {
+	TenantCode string `json:"tenant_code"`
+	RequestID  string `json:"request_id"`
+	Approved   bool   `json:"approved"`
+}
+
+type cibaPendingRequestResponse struct {
+	ID             string  `json:"id"`
+	ClientName     string  `json:"client_name"`
+	Scope          string  `json:"scope"`
+	BindingMessage *string `json:"binding_message,omitempty"`
+	ExpiresAt      string  `json:"expires_at"`
+	// ConsentScopes は承認によって同意を記録する、同意が必要で未同意のスコープ
+	ConsentScopes []consentScope `json:"consent_scopes"`
+}
+
+// HandleApproval は GET /{tenant_code}/ciba を処理する。
+// 認証デバイスへの通知に含める URL。ログイン済みであれば OP Frontend の承認画面へ、未ログインであればログイン画面へリダイレクトする。
+func (h *BackchannelApprovalHandler) HandleApproval(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	target := "/ciba"
+	if session := h.currentSession(c); session == nil || session.TenantID != tenant.ID {
+		target = "/login"
+	}
+
+	redirectURL, err := url.Parse(h.frontendBaseURL + target)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := redirectURL.Query()
+	q.Set("tenant_code", tenantCode)
+	if target == "/login" {
+		q.Set("redirect_after_login", c.Request().URL.String())
+	}
+	redirectURL.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, redirectURL.String())
+}
+
+// HandleList は GET /internal/ciba/requests を処理する。
+// ログイン中のユーザー宛ての承認待ちリクエストを返す。binding_message は消費デバイスの表示との照合に使う。
+func (h *BackchannelApprovalHandler) HandleList(c echo.Context) error {
+	tenantCode := c.QueryParam("tenant_code")
+	if tenantCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+	session, errCode := h.tenantSession(c, tenantCode)
+	if errCode != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": errCode})
+	}
+
+	reqs, err := h.requestStore.ListPendingByUserID(c.Request().Context(), session.UserID)
+	if err != nil {
+		c.Logger().Errorf("failed to list backchannel auth requests: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	data := make([]cibaPendingRequestResponse, 0, len(reqs))
+	for _, r := range reqs {
+		if r.Client.TenantID != session.TenantID {
+			continue
+		}
+		pending, _, err := approvalConsent(c.Request().Context(), h.scopeFinder, h.apiResourceFinder, h.consentStore, &r.Client, session.UserID, r.Scope)
+		if errors.Is(err, ErrInvalidScope) {
+			// クライアントに許可されなくなったスコープを含むリクエストは承認できないため示さない
+			continue
+		}
+		if err != nil {
+			c.Logger().Errorf("failed to check consent: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		data = append(data, cibaPendingRequestResponse{
+			ID:             r.ID.String(),
+			ClientName:     r.Client.Name,
+			Scope:          r.Scope,
+			BindingMessage: r.BindingMessage,
+			ExpiresAt:      r.ExpiresAt.Format(time.RFC3339),
+			ConsentScopes:  consentScopes(pending),
+		})
+	}
+	return c.JSON(http.StatusOK, data)
+}
+
+// HandleDecide は POST /internal/ciba/decide を処理する。
+// ユーザーの承認・拒否を記録し、ping モードのクライアントには結果が出たことを通知する。
+// 承認は画面に示した同意が必要なスコープへの同意を兼ね、同意として記録する (OIDC Core 1.0 Section 3.1.2.4)。
+func (h *BackchannelApprovalHandler) HandleDecide(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req cibaDecideRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+	requestID, err := uuid.Parse(req.RequestID)
+	if err != nil || req.TenantCode == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	session, errCode := h.tenantSession(c, req.TenantCode)
+	if errCode != "" {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": errCode})
+	}
+	// 拒否はメールアドレスの確認状況に関わらず受け付ける
+	if req.Approved && emailVerificationPending(session) {
+		return c.JSON(http.StatusForbidden, map[string]string{"error": "email_not_verified"})
+	}
+
+	authReq, err := h.requestStore.FindByID(ctx, requestID)
+	if err != nil {
+		c.Logger().Errorf("failed to find backchannel auth request: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	// 別のユーザー宛てのリクエストは存在しないものとして扱う
+	if authReq == nil || authReq.UserID != session.UserID || authReq.Client.TenantID != session.TenantID {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	if req.Approved {
+		pending, consent, err := approvalConsent(ctx, h.scopeFinder, h.apiResourceFinder, h.consentStore, &authReq.Client, session.UserID, authReq.Scope)
+		if errors.Is(err, ErrInvalidScope) {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+		}
+		if err != nil {
+			c.Logger().Errorf("failed to check consent: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if err := saveConsent(ctx, h.consentStore, session.UserID, &authReq.Client, pending, consent); err != nil {
+			c.Logger().Errorf("failed to save consent: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+	}
+
+	ok, err := h.requestStore.Decide(ctx, authReq.ID, session.ID, req.Approved)
+	if err != nil {
+		c.Logger().Errorf("failed to decide backchannel auth request: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if !ok {
+		// 同時に別の画面で処理された、または期限切れになった
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "request_not_pending"})
+	}
+
+	// ping 通知の失敗はユーザーの操作結果に影響させない。クライアントはポーリングでも結果を取得できる
+	if authReq.DeliveryMode == model.BackchannelDeliveryModePing {
+		if err := h.ping(ctx, authReq); err != nil {
+			c.Logger().Errorf("failed to send CIBA ping notification: %v", err)
+		}
+	}
+
+	status := model.BackchannelAuthStatusDenied
+	if req.Approved {
+		status = model.BackchannelAuthStatusApproved
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": status})
+}
+
+// ping はクライアント通知エンドポイントへ auth_req_id を送る。
+// client_notification_token を Bearer トークンとして使う (MUST: CIBA Core 1.0 Section 10.2)
+func (h *BackchannelApprovalHandler) ping(ctx context.Context, authReq *model.BackchannelAuthRequest) error {
+	endpoint := authReq.Client.BackchannelClientNotificationEndpoint
+	if endpoint == nil || authReq.AuthReqIDEncrypted == nil || authReq.ClientNotificationTokenEncrypted == nil {
+		return fmt.Errorf("ping notification is not configured")
+	}
+	authReqID, err := h.decryptSecret(*authReq.AuthReqIDEncrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt auth_req_id: %w", err)
+	}
+	token, err := h.decryptSecret(*authReq.ClientNotificationTokenEncrypted)
+	if err != nil {
+		return fmt.Errorf("failed to decrypt client_notification_token: %w", err)
+	}
+
+	body, err := json.Marshal(map[string]string{"auth_req_id": authReqID})
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, *endpoint, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	httpReq.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := h.httpClient.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	// クライアントは 204 を返す (SHOULD)。2xx 以外は失敗として記録する
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("client notification endpoint returned %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// currentSession は op_session Cookie のセッションを返す。ログインしていない場合は nil を返す。
+func (h *BackchannelApprovalHandler) currentSession(c echo.Context) *model.Session {
+	return sessionFromCookie(c, h.sessionValidator)
+}
+
+// tenantSession はテナントのログインセッションを検証する。失敗した場合はエラーコードを返す。
+func (h *BackchannelApprovalHandler) tenantSession(c echo.Context, tenantCode string) (*model.Session, string) {
+	session := h.currentSession(c)
+	if session == nil {
+		return nil, "no_session"
+	}
+	tenant, err := h.tenantFinder.FindByCode(c.Request().Context(), tenantCode)
+	if err != nil || tenant == nil || session.TenantID != tenant.ID {
+		return nil, "invalid_session"
+	}
+	return session, ""
+}
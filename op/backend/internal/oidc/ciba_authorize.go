@@ -0,0 +1,277 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// cibaGrantType は CIBA のトークンリクエストの grant_type (CIBA Core 1.0 Section 10.1)
+	cibaGrantType = "urn:openid:params:grant-type:ciba"
+	// cibaRequestLifetime は auth_req_id の有効期間の上限。requested_expiry で短くできる
+	cibaRequestLifetime = 10 * time.Minute
+	// cibaPollInterval はポーリング間隔の初期値（秒） (CIBA Core 1.0 Section 7.3)
+	cibaPollInterval = 5
+	// bindingMessageMaxLength は binding_message の最大文字数。認証デバイスに収まる短い文言に限る (CIBA Core 1.0 Section 7.1)
+	bindingMessageMaxLength = 64
+	// clientNotificationTokenMaxLength は client_notification_token の最大長 (CIBA Core 1.0 Section 7.1.1)
+	clientNotificationTokenMaxLength = 1024
+)
+
+// cibaDeliveryModesSupported は対応するトークン受け渡し方式。push は未対応
+var cibaDeliveryModesSupported = []string{model.BackchannelDeliveryModePoll, model.BackchannelDeliveryModePing}
+
+// BackchannelAuthenticationHandler は CIBA のバックチャネル認証エンドポイントを処理する。
+type BackchannelAuthenticationHandler struct {
+	tenantFinder        TenantFinder
+	clientAuthenticator *ClientAuthenticator
+	requestStore        BackchannelAuthRequestStore
+	scopeFinder         ScopeDefinitionFinder
+	apiResourceFinder   APIResourceFinder
+	userFinder          UserFinder
+	loginIDFinder       UserLoginIDFinder
+	subjectMapper       *SubjectMapper
+	tokenValidator      TokenValidator
+	notifier            AuthenticationDeviceNotifier
+	sha256Hex           SHA256HexFunc
+	encryptSecret       EncryptSecretFunc
+	issuerBaseURL       string
+}
+
+func NewBackchannelAuthenticationHandler(
+	tenantFinder TenantFinder,
+	clientAuthenticator *ClientAuthenticator,
+	requestStore BackchannelAuthRequestStore,
+	scopeFinder ScopeDefinitionFinder,
+	apiResourceFinder APIResourceFinder,
+	userFinder UserFinder,
+	loginIDFinder UserLoginIDFinder,
+	subjectMapper *SubjectMapper,
+	tokenValidator TokenValidator,
+	notifier AuthenticationDeviceNotifier,
+	sha256Hex SHA256HexFunc,
+	encryptSecret EncryptSecretFunc,
+	issuerBaseURL string,
+) *BackchannelAuthenticationHandler {
+	return &BackchannelAuthenticationHandler{
+		tenantFinder:        tenantFinder,
+		clientAuthenticator: clientAuthenticator,
+		requestStore:        requestStore,
+		scopeFinder:         scopeFinder,
+		apiResourceFinder:   apiResourceFinder,
+		userFinder:          userFinder,
+		loginIDFinder:       loginIDFinder,
+		subjectMapper:       subjectMapper,
+		tokenValidator:      tokenValidator,
+		notifier:            notifier,
+		sha256Hex:           sha256Hex,
+		encryptSecret:       encryptSecret,
+		issuerBaseURL:       issuerBaseURL,
+	}
+}
+
+// BackchannelAuthenticationResponse はバックチャネル認証レスポンス (CIBA Core 1.0 Section 7.3)
+type BackchannelAuthenticationResponse struct {
+	AuthReqID string `json:"auth_req_id"`
+	ExpiresIn int    `json:"expires_in"`
+	Interval  int    `json:"interval"`
+}
+
+// HandleAuthorize は POST /{tenant_code}/bc-authorize を処理する
+// 仕様参照: CIBA Core 1.0 Section 7.1 - 7.3, 13
+func (h *BackchannelAuthenticationHandler) HandleAuthorize(c echo.Context) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, c.Param("tenant_code"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	// CIBA は機密クライアントのみ (CIBA Core 1.0 Section 7.1)
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
+	}
+	if client.TenantID != tenant.ID {
+		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client does not belong to this tenant")
+	}
+	if client.IsPublic() || !client.HasGrantType(cibaGrantType) || client.BackchannelTokenDeliveryMode == nil {
+		return tokenError(c, http.StatusBadRequest, "unauthorized_client", "client is not registered for CIBA")
+	}
+	deliveryMode := *client.BackchannelTokenDeliveryMode
+
+	scope := c.FormValue("scope")
+	if !containsScope(strings.Fields(scope), "openid") {
+		return tokenError(c, http.StatusBadRequest, "invalid_scope", "scope must include openid")
+	}
+	availableScopes, err := loadTenantScopes(ctx, h.scopeFinder, h.apiResourceFinder, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to load tenant scopes: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+	if err := availableScopes.validate(client, strings.Fields(scope)); err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+
+	// ヒントはちょうど1つ指定する (MUST: CIBA Core 1.0 Section 7.1)
+	loginHint := c.FormValue("login_hint")
+	idTokenHint := c.FormValue("id_token_hint")
+	if c.FormValue("login_hint_token") != "" {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "login_hint_token is not supported")
+	}
+	if (loginHint == "") == (idTokenHint == "") {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "exactly one of login_hint or id_token_hint is required")
+	}
+
+	bindingMessage := c.FormValue("binding_message")
+	if !isValidBindingMessage(bindingMessage) {
+		return tokenError(c, http.StatusBadRequest, "invalid_binding_message", "")
+	}
+
+	notificationToken := c.FormValue("client_notification_token")
+	if deliveryMode == model.BackchannelDeliveryModePing {
+		if notificationToken == "" || len(notificationToken) > clientNotificationTokenMaxLength {
+			return tokenError(c, http.StatusBadRequest, "invalid_request", "client_notification_token is required for ping mode")
+		}
+	}
+
+	lifetime := cibaRequestLifetime
+	if raw := c.FormValue("requested_expiry"); raw != "" {
+		seconds, err := strconv.Atoi(raw)
+		if err != nil || seconds <= 0 {
+			return tokenError(c, http.StatusBadRequest, "invalid_request", "requested_expiry must be a positive integer")
+		}
+		lifetime = min(lifetime, time.Duration(seconds)*time.Second)
+	}
+
+	var user *model.User
+	if loginHint != "" {
+		user, err = h.loginIDFinder.FindByTenantAndLoginID(ctx, tenant.ID, loginHint)
+	} else {
+		user, err = h.userFromIDTokenHint(ctx, tenant, client, idTokenHint)
+	}
+	if err != nil {
+		c.Logger().Errorf("failed to resolve CIBA user: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+	if user == nil || user.TenantID != tenant.ID || user.Status != "active" {
+		return tokenError(c, http.StatusBadRequest, "unknown_user_id", "")
+	}
+
+	authReqID, err := generateAuthReqID()
+	if err != nil {
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	req := &model.BackchannelAuthRequest{
+		ClientID:      client.ID,
+		UserID:        user.ID,
+		AuthReqIDHash: h.sha256Hex(authReqID),
+		Scope:         scope,
+		DeliveryMode:  deliveryMode,
+		Status:        model.BackchannelAuthStatusPending,
+		PollInterval:  cibaPollInterval,
+		ExpiresAt:     time.Now().Add(lifetime),
+	}
+	if bindingMessage != "" {
+		req.BindingMessage = &bindingMessage
+	}
+	// ping 通知には auth_req_id と client_notification_token の平文が必要になるため暗号化して保存する
+	if deliveryMode == model.BackchannelDeliveryModePing {
+		encryptedID, err := h.encryptSecret(authReqID)
+		if err != nil {
+			c.Logger().Errorf("failed to encrypt auth_req_id: %v", err)
+			return tokenError(c, http.StatusInternalServerError, "server_error", "")
+		}
+		encryptedToken, err := h.encryptSecret(notificationToken)
+		if err != nil {
+			c.Logger().Errorf("failed to encrypt client_notification_token: %v", err)
+			return tokenError(c, http.StatusInternalServerError, "server_error", "")
+		}
+		req.AuthReqIDEncrypted = &encryptedID
+		req.ClientNotificationTokenEncrypted = &encryptedToken
+	}
+	if err := h.requestStore.Create(ctx, req); err != nil {
+		c.Logger().Errorf("failed to create backchannel auth request: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	if err := h.notifier.NotifyAuthenticationRequest(ctx, &model.AuthenticationDeviceNotification{
+		TenantCode:     tenant.Code,
+		UserID:         user.ID,
+		LoginID:        user.LoginID,
+		Email:          user.Email,
+		ClientName:     client.Name,
+		Scope:          scope,
+		BindingMessage: bindingMessage,
+		ApprovalURL:    h.issuerBaseURL + "/" + url.PathEscape(tenant.Code) + "/ciba",
+		ExpiresAt:      req.ExpiresAt,
+	}); err != nil {
+		c.Logger().Errorf("failed to notify authentication device: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	return c.JSON(http.StatusOK, BackchannelAuthenticationResponse{
+		AuthReqID: authReqID,
+		ExpiresIn: int(lifetime.Seconds()),
+		Interval:  cibaPollInterval,
+	})
+}
+
+// userFromIDTokenHint は id_token_hint からユーザーを特定する。
+// このクライアントに発行した有効期限内の ID トークンに限る。特定できない場合は nil を返す。
+func (h *BackchannelAuthenticationHandler) userFromIDTokenHint(ctx context.Context, tenant *model.Tenant, client *model.Client, hint string) (*model.User, error) {
+	result, err := h.tokenValidator.ValidateIDToken(ctx, hint)
+	if err != nil {
+		return nil, nil
+	}
+	if result.Issuer != h.issuerBaseURL+"/"+tenant.Code || result.ClientID != client.ClientID {
+		return nil, nil
+	}
+	userID, err := h.subjectMapper.ResolveSubject(ctx, tenant.ID, result.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve subject: %w", err)
+	}
+	if userID == nil {
+		return nil, nil
+	}
+	return h.userFinder.FindByID(ctx, *userID)
+}
+
+func generateAuthReqID() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// isValidBindingMessage は binding_message が認証デバイスに表示できる短い平文か判定する (CIBA Core 1.0 Section 7.1)
+func isValidBindingMessage(msg string) bool {
+	if !utf8.ValidString(msg) || utf8.RuneCountInString(msg) > bindingMessageMaxLength {
+		return false
+	}
+	for _, r := range msg {
+		if unicode.IsControl(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,575 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// fakeBackchannelAuthStore は backchannel_auth_requests をメモリに持つ。検索結果は DB と同じく複製して返す
+type fakeBackchannelAuthStore struct {
+	requests map[uuid.UUID]*model.BackchannelAuthRequest
+}
+
+func newFakeBackchannelAuthStore(reqs ...*model.BackchannelAuthRequest) *fakeBackchannelAuthStore {
+	f := &fakeBackchannelAuthStore{requests: map[uuid.UUID]*model.BackchannelAuthRequest{}}
+	for _, r := range reqs {
+		f.requests[r.ID] = r
+	}
+	return f
+}
+
+func (f *fakeBackchannelAuthStore) Create(_ context.Context, req *model.BackchannelAuthRequest) error {
+	req.ID = uuid.New()
+	f.requests[req.ID] = req
+	return nil
+}
+
+func (f *fakeBackchannelAuthStore) FindByAuthReqIDHash(_ context.Context, hash string) (*model.BackchannelAuthRequest, error) {
+	for _, r := range f.requests {
+		if r.AuthReqIDHash == hash {
+			copied := *r
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeBackchannelAuthStore) FindByID(_ context.Context, id uuid.UUID) (*model.BackchannelAuthRequest, error) {
+	r := f.requests[id]
+	if r == nil {
+		return nil, nil
+	}
+	copied := *r
+	return &copied, nil
+}
+
+func (f *fakeBackchannelAuthStore) ListPendingByUserID(_ context.Context, userID uuid.UUID) ([]model.BackchannelAuthRequest, error) {
+	var list []model.BackchannelAuthRequest
+	for _, r := range f.requests {
+		if r.UserID == userID && r.Status == model.BackchannelAuthStatusPending && !r.IsExpired() {
+			list = append(list, *r)
+		}
+	}
+	return list, nil
+}
+
+func (f *fakeBackchannelAuthStore) Decide(_ context.Context, id, sessionID uuid.UUID, approved bool) (bool, error) {
+	r := f.requests[id]
+	if r == nil || r.Status != model.BackchannelAuthStatusPending || r.IsExpired() {
+		return false, nil
+	}
+	r.SessionID = &sessionID
+	r.Status = model.BackchannelAuthStatusDenied
+	if approved {
+		r.Status = model.BackchannelAuthStatusApproved
+	}
+	return true, nil
+}
+
+func (f *fakeBackchannelAuthStore) UpdatePolling(_ context.Context, id uuid.UUID, polledAt time.Time, interval int) error {
+	f.requests[id].LastPolledAt = &polledAt
+	f.requests[id].PollInterval = interval
+	return nil
+}
+
+func (f *fakeBackchannelAuthStore) MarkConsumed(_ context.Context, id uuid.UUID) (bool, error) {
+	r := f.requests[id]
+	if r.Status != model.BackchannelAuthStatusApproved {
+		return false, nil
+	}
+	r.Status = model.BackchannelAuthStatusConsumed
+	return true, nil
+}
+
+type fakeLoginIDFinder struct {
+	users []*model.User
+}
+
+func (f *fakeLoginIDFinder) FindByTenantAndLoginID(_ context.Context, tenantID uuid.UUID, loginID string) (*model.User, error) {
+	for _, u := range f.users {
+		if u.TenantID == tenantID && u.LoginID == loginID {
+			return u, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeDeviceNotifier struct {
+	sent []*model.AuthenticationDeviceNotification
+}
+
+func (f *fakeDeviceNotifier) NotifyAuthenticationRequest(_ context.Context, n *model.AuthenticationDeviceNotification) error {
+	f.sent = append(f.sent, n)
+	return nil
+}
+
+// テスト用の暗号化は "enc:" + 平文
+func fakeEncryptSecret(s string) (string, error) { return "enc:" + s, nil }
+
+func fakeDecryptSecret(s string) (string, error) { return strings.TrimPrefix(s, "enc:"), nil }
+
+func TestIsValidBindingMessage(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"":                      true,
+		"W4SCT":                 true,
+		"注文番号 1234 の決済":         true,
+		strings.Repeat("a", 64): true,
+		strings.Repeat("あ", 64): true,
+		strings.Repeat("a", 65): false,
+		"line1\nline2":          false,
+		"tab\there":             false,
+		"\xff\xfe":              false,
+	} {
+		if got := isValidBindingMessage(msg); got != want {
+			t.Errorf("isValidBindingMessage(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
+
+// backchannelAuthorizeFixture は poll・ping の CIBA クライアントとユーザー alice を用意する
+type backchannelAuthorizeFixture struct {
+	handler  *BackchannelAuthenticationHandler
+	tenant   *model.Tenant
+	poll     *model.Client
+	user     *model.User
+	store    *fakeBackchannelAuthStore
+	notifier *fakeDeviceNotifier
+}
+
+func newBackchannelAuthorizeFixture() *backchannelAuthorizeFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	newClient := func(clientID, mode string) *model.Client {
+		return &model.Client{
+			ID:                           uuid.New(),
+			TenantID:                     tenant.ID,
+			ClientID:                     clientID,
+			Name:                         "Bank",
+			Status:                       "active",
+			ClientSecretHash:             strPtr("hash:s3cret"),
+			TokenEndpointAuthMethod:      "client_secret_post",
+			GrantTypes:                   model.StringSlice{cibaGrantType},
+			BackchannelTokenDeliveryMode: strPtr(mode),
+		}
+	}
+	poll := newClient("poll-client", model.BackchannelDeliveryModePoll)
+	ping := newClient("ping-client", model.BackchannelDeliveryModePing)
+	noCIBA := newClient("code-client", model.BackchannelDeliveryModePoll)
+	noCIBA.GrantTypes = model.StringSlice{"authorization_code"}
+
+	user := &model.User{ID: uuid.New(), TenantID: tenant.ID, LoginID: "alice", Email: "alice@example.com", Status: "active"}
+	locked := &model.User{ID: uuid.New(), TenantID: tenant.ID, LoginID: "locked", Status: "locked"}
+	f := &backchannelAuthorizeFixture{
+		tenant:   tenant,
+		poll:     poll,
+		user:     user,
+		store:    newFakeBackchannelAuthStore(),
+		notifier: &fakeDeviceNotifier{},
+	}
+	f.handler = NewBackchannelAuthenticationHandler(
+		newFakeTenantFinder(tenant),
+		newTestClientAuthenticator(poll, ping, noCIBA),
+		f.store,
+		&fakeScopeDefinitionFinder{defs: []model.ScopeDefinition{{TenantID: tenant.ID, Name: "payments", ConsentRequired: true}}},
+		&fakeAPIResourceFinder{},
+		newFakeUserFinder(user),
+		&fakeLoginIDFinder{users: []*model.User{user, locked}},
+		NewSubjectMapper(nil, nil, nil),
+		&fakeTokenValidator{idTokens: map[string]*model.IDTokenResult{
+			"alice-id-token": {Issuer: testIssuerBaseURL + "/demo", Subject: user.ID.String(), ClientID: "poll-client"},
+			"other-client":   {Issuer: testIssuerBaseURL + "/demo", Subject: user.ID.String(), ClientID: "ping-client"},
+		}},
+		f.notifier,
+		fakeSHA256Hex,
+		fakeEncryptSecret,
+		testIssuerBaseURL,
+	)
+	return f
+}
+
+func (f *backchannelAuthorizeFixture) post(form url.Values) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/demo/bc-authorize", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("tenant_code")
+	c.SetParamValues("demo")
+	if err := f.handler.HandleAuthorize(c); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func TestBackchannelAuthorize(t *testing.T) {
+	f := newBackchannelAuthorizeFixture()
+	rec := f.post(url.Values{
+		"client_id": {"poll-client"}, "client_secret": {"s3cret"},
+		"scope": {"openid email"}, "login_hint": {"alice"}, "binding_message": {"W4SCT"}, "requested_expiry": {"120"},
+	})
+	var resp BackchannelAuthenticationResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if resp.AuthReqID == "" || resp.ExpiresIn != 120 || resp.Interval != cibaPollInterval {
+		t.Errorf("response = %+v", resp)
+	}
+
+	// auth_req_id はハッシュのみ保存する
+	stored, _ := f.store.FindByAuthReqIDHash(context.Background(), fakeSHA256Hex(resp.AuthReqID))
+	if stored == nil || stored.UserID != f.user.ID || stored.Status != model.BackchannelAuthStatusPending || stored.Scope != "openid email" {
+		t.Fatalf("stored = %+v", stored)
+	}
+	if stored.AuthReqIDEncrypted != nil || stored.BindingMessage == nil || *stored.BindingMessage != "W4SCT" {
+		t.Errorf("stored = %+v", stored)
+	}
+	if len(f.notifier.sent) != 1 || f.notifier.sent[0].BindingMessage != "W4SCT" || f.notifier.sent[0].ApprovalURL != testIssuerBaseURL+"/demo/ciba" {
+		t.Errorf("notifications = %+v", f.notifier.sent)
+	}
+
+	t.Run("id_token_hint", func(t *testing.T) {
+		rec := f.post(url.Values{"client_id": {"poll-client"}, "client_secret": {"s3cret"}, "scope": {"openid"}, "id_token_hint": {"alice-id-token"}})
+		if rec.Code != http.StatusOK {
+			t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+	})
+
+	t.Run("ping は通知用に auth_req_id と client_notification_token を暗号化して保存する", func(t *testing.T) {
+		rec := f.post(url.Values{"client_id": {"ping-client"}, "client_secret": {"s3cret"}, "scope": {"openid"}, "login_hint": {"alice"}, "client_notification_token": {"cnt"}})
+		var resp BackchannelAuthenticationResponse
+		_ = json.Unmarshal(rec.Body.Bytes(), &resp)
+		stored, _ := f.store.FindByAuthReqIDHash(context.Background(), fakeSHA256Hex(resp.AuthReqID))
+		if stored == nil || stored.AuthReqIDEncrypted == nil || *stored.AuthReqIDEncrypted != "enc:"+resp.AuthReqID ||
+			stored.ClientNotificationTokenEncrypted == nil || *stored.ClientNotificationTokenEncrypted != "enc:cnt" {
+			t.Fatalf("stored = %+v", stored)
+		}
+	})
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		wantCode  int
+		wantError string
+	}{
+		{name: "クライアント認証の失敗", form: url.Values{"client_id": {"poll-client"}, "client_secret": {"wrong"}, "scope": {"openid"}, "login_hint": {"alice"}}, wantCode: http.StatusUnauthorized, wantError: "invalid_client"},
+		{name: "CIBA を許可されていないクライアント", form: url.Values{"client_id": {"code-client"}, "client_secret": {"s3cret"}, "scope": {"openid"}, "login_hint": {"alice"}}, wantCode: http.StatusBadRequest, wantError: "unauthorized_client"},
+		{name: "openid なし", form: url.Values{"scope": {"email"}, "login_hint": {"alice"}}, wantCode: http.StatusBadRequest, wantError: "invalid_scope"},
+		{name: "テナントにないスコープ", form: url.Values{"scope": {"openid unknown"}, "login_hint": {"alice"}}, wantCode: http.StatusBadRequest, wantError: "invalid_scope"},
+		{name: "ヒントなし", form: url.Values{"scope": {"openid"}}, wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+		{name: "ヒントが2つ", form: url.Values{"scope": {"openid"}, "login_hint": {"alice"}, "id_token_hint": {"alice-id-token"}}, wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+		{name: "login_hint_token", form: url.Values{"scope": {"openid"}, "login_hint_token": {"x"}}, wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+		{name: "binding_message が長すぎる", form: url.Values{"scope": {"openid"}, "login_hint": {"alice"}, "binding_message": {strings.Repeat("a", 65)}}, wantCode: http.StatusBadRequest, wantError: "invalid_binding_message"},
+		{name: "requested_expiry が不正", form: url.Values{"scope": {"openid"}, "login_hint": {"alice"}, "requested_expiry": {"0"}}, wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+		{name: "存在しないユーザー", form: url.Values{"scope": {"openid"}, "login_hint": {"bob"}}, wantCode: http.StatusBadRequest, wantError: "unknown_user_id"},
+		{name: "無効なユーザー", form: url.Values{"scope": {"openid"}, "login_hint": {"locked"}}, wantCode: http.StatusBadRequest, wantError: "unknown_user_id"},
+		{name: "別のクライアントの ID トークン", form: url.Values{"scope": {"openid"}, "id_token_hint": {"other-client"}}, wantCode: http.StatusBadRequest, wantError: "unknown_user_id"},
+		{name: "検証できない ID トークン", form: url.Values{"scope": {"openid"}, "id_token_hint": {"forged"}}, wantCode: http.StatusBadRequest, wantError: "unknown_user_id"},
+		{name: "ping で client_notification_token なし", form: url.Values{"client_id": {"ping-client"}, "client_secret": {"s3cret"}, "scope": {"openid"}, "login_hint": {"alice"}}, wantCode: http.StatusBadRequest, wantError: "invalid_request"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before := len(f.store.requests)
+			form := tt.form
+			if !form.Has("client_id") {
+				form.Set("client_id", "poll-client")
+				form.Set("client_secret", "s3cret")
+			}
+			rec := f.post(form)
+			var body map[string]string
+			_ = json.Unmarshal(rec.Body.Bytes(), &body)
+			if rec.Code != tt.wantCode || body["error"] != tt.wantError {
+				t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			if len(f.store.requests) != before {
+				t.Error("request stored on error")
+			}
+		})
+	}
+}
+
+func TestCIBAGrantStates(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{cibaGrantType}, BackchannelTokenDeliveryMode: strPtr(model.BackchannelDeliveryModePoll)}
+	otherClient := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{cibaGrantType}, BackchannelTokenDeliveryMode: strPtr(model.BackchannelDeliveryModePoll)}
+	userID := uuid.New()
+	revoked := time.Now()
+
+	tests := []struct {
+		name   string
+		client *model.Client
+		req    model.BackchannelAuthRequest
+		want   error
+		// wantInterval はポーリング後の間隔 (0 は確認しない)
+		wantInterval int
+		// wantStatus は問い合わせ後の状態 (空は確認しない)
+		wantStatus string
+	}{
+		{name: "承認待ち", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusPending}, want: ErrAuthorizationPending, wantInterval: cibaPollInterval},
+		{name: "間隔より早いポーリング", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusPending, LastPolledAt: ptrTime(time.Now())}, want: ErrSlowDown, wantInterval: cibaPollInterval + deviceSlowDownIncrement},
+		{name: "間隔を空けたポーリング", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusPending, LastPolledAt: ptrTime(time.Now().Add(-10 * time.Second))}, want: ErrAuthorizationPending, wantInterval: cibaPollInterval},
+		{name: "拒否", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusDenied}, want: ErrAccessDenied, wantStatus: model.BackchannelAuthStatusDenied},
+		{name: "期限切れ", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved, ExpiresAt: time.Now().Add(-time.Second)}, want: ErrExpiredToken, wantStatus: model.BackchannelAuthStatusApproved},
+		{name: "発行済み", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusConsumed}, want: ErrInvalidGrant},
+		{name: "別のクライアント", client: otherClient, req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved}, want: ErrInvalidGrant, wantStatus: model.BackchannelAuthStatusApproved},
+		{name: "承認後に失効したセッション", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved, Session: &model.Session{UserID: userID, RevokedAt: &revoked, ExpiresAt: time.Now().Add(time.Hour)}}, want: ErrInvalidGrant, wantStatus: model.BackchannelAuthStatusConsumed},
+		{name: "別のユーザーのセッションで承認", req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved, Session: &model.Session{UserID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour)}}, want: ErrInvalidGrant, wantStatus: model.BackchannelAuthStatusConsumed},
+		{name: "CIBA を許可されていないクライアント", client: &model.Client{ID: client.ID, BackchannelTokenDeliveryMode: client.BackchannelTokenDeliveryMode}, req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved}, want: ErrUnauthorizedClient},
+		{name: "受け渡し方式が未登録のクライアント", client: &model.Client{ID: client.ID, GrantTypes: client.GrantTypes}, req: model.BackchannelAuthRequest{Status: model.BackchannelAuthStatusApproved}, want: ErrUnauthorizedClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := tt.req
+			req.ID = uuid.New()
+			req.ClientID = client.ID
+			req.UserID = userID
+			req.AuthReqIDHash = fakeSHA256Hex("auth-req-id")
+			if req.ExpiresAt.IsZero() {
+				req.ExpiresAt = time.Now().Add(time.Minute)
+			}
+			if req.PollInterval == 0 {
+				req.PollInterval = cibaPollInterval
+			}
+			store := newFakeBackchannelAuthStore(&req)
+			h := &TokenHandler{backchannelAuthStore: store, sha256Hex: fakeSHA256Hex}
+
+			requester := client
+			if tt.client != nil {
+				requester = tt.client
+			}
+			_, err := h.handleCIBAGrantLogic(context.Background(), &CIBAGrantInput{Client: requester, AuthReqID: "auth-req-id"})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.wantInterval != 0 && store.requests[req.ID].PollInterval != tt.wantInterval {
+				t.Errorf("interval = %d, want %d", store.requests[req.ID].PollInterval, tt.wantInterval)
+			}
+			if tt.wantStatus != "" && store.requests[req.ID].Status != tt.wantStatus {
+				t.Errorf("status = %s, want %s", store.requests[req.ID].Status, tt.wantStatus)
+			}
+		})
+	}
+}
+
+func TestCIBAGrantUnknownAuthReqID(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{cibaGrantType}, BackchannelTokenDeliveryMode: strPtr(model.BackchannelDeliveryModePoll)}
+	h := &TokenHandler{backchannelAuthStore: newFakeBackchannelAuthStore(), sha256Hex: fakeSHA256Hex}
+	if _, err := h.handleCIBAGrantLogic(context.Background(), &CIBAGrantInput{Client: client, AuthReqID: "unknown"}); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("err = %v, want ErrInvalidGrant", err)
+	}
+}
+
+// backchannelApprovalFixture はログイン済みのユーザー alice と、alice 宛ての承認待ちリクエストを用意する
+type backchannelApprovalFixture struct {
+	handler  *BackchannelApprovalHandler
+	store    *fakeBackchannelAuthStore
+	consents *fakeUserConsentStore
+	session  *model.Session
+	other    *model.Session
+	req      *model.BackchannelAuthRequest
+}
+
+func newBackchannelApprovalFixture(mode string, endpoint string) *backchannelApprovalFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	session := newTestSession(tenant)
+	other := newTestSession(tenant)
+	client := model.Client{ID: uuid.New(), TenantID: tenant.ID, Name: "Bank"}
+	if endpoint != "" {
+		client.BackchannelClientNotificationEndpoint = &endpoint
+	}
+	req := &model.BackchannelAuthRequest{
+		ID:                               uuid.New(),
+		ClientID:                         client.ID,
+		UserID:                           session.UserID,
+		Scope:                            "openid",
+		BindingMessage:                   strPtr("W4SCT"),
+		DeliveryMode:                     mode,
+		AuthReqIDEncrypted:               strPtr("enc:auth-req-id"),
+		ClientNotificationTokenEncrypted: strPtr("enc:cnt"),
+		Status:                           model.BackchannelAuthStatusPending,
+		ExpiresAt:                        time.Now().Add(time.Minute),
+		Client:                           client,
+	}
+	store := newFakeBackchannelAuthStore(req)
+	consents := &fakeUserConsentStore{consents: map[uuid.UUID]*model.UserConsent{}}
+	h := NewBackchannelApprovalHandler(
+		newFakeTenantFinder(tenant),
+		store,
+		&fakeScopeDefinitionFinder{defs: []model.ScopeDefinition{{TenantID: tenant.ID, Name: "payments", Description: "支払い", ConsentRequired: true}}},
+		&fakeAPIResourceFinder{},
+		consents,
+		newFakeSessionValidator(session, other),
+		fakeDecryptSecret,
+		"https://login.example.com",
+	)
+	return &backchannelApprovalFixture{handler: h, store: store, consents: consents, session: session, other: other, req: req}
+}
+
+func (f *backchannelApprovalFixture) call(handle func(echo.Context) error, method, target string, session *model.Session, body map[string]interface{}) *httptest.ResponseRecorder {
+	var req *http.Request
+	if body != nil {
+		raw, _ := json.Marshal(body)
+		req = httptest.NewRequest(method, target, strings.NewReader(string(raw)))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	} else {
+		req = httptest.NewRequest(method, target, nil)
+	}
+	if session != nil {
+		req.AddCookie(&http.Cookie{Name: "op_session", Value: session.ID.String()})
+	}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("tenant_code")
+	c.SetParamValues("demo")
+	if err := handle(c); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func (f *backchannelApprovalFixture) decide(session *model.Session, approved bool) *httptest.ResponseRecorder {
+	return f.call(f.handler.HandleDecide, http.MethodPost, "/internal/ciba/decide", session,
+		map[string]interface{}{"tenant_code": "demo", "request_id": f.req.ID.String(), "approved": approved})
+}
+
+func TestBackchannelApprovalRedirect(t *testing.T) {
+	f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+
+	loc := location(f.call(f.handler.HandleApproval, http.MethodGet, "/demo/ciba", f.session, nil))
+	if loc.Path != "/ciba" || loc.Query().Get("tenant_code") != "demo" {
+		t.Errorf("logged in: location = %s", loc)
+	}
+	// 未ログインの場合はログイン後に戻る
+	loc = location(f.call(f.handler.HandleApproval, http.MethodGet, "/demo/ciba", nil, nil))
+	if loc.Path != "/login" || loc.Query().Get("redirect_after_login") != "/demo/ciba" {
+		t.Errorf("logged out: location = %s", loc)
+	}
+}
+
+func TestBackchannelApprovalDecide(t *testing.T) {
+	f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+
+	rec := f.call(f.handler.HandleList, http.MethodGet, "/internal/ciba/requests?tenant_code=demo", f.session, nil)
+	var list []cibaPendingRequestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if list[0].ID != f.req.ID.String() || list[0].ClientName != "Bank" || list[0].BindingMessage == nil || *list[0].BindingMessage != "W4SCT" {
+		t.Errorf("list = %+v", list)
+	}
+	// 別のユーザーには見えない
+	rec = f.call(f.handler.HandleList, http.MethodGet, "/internal/ciba/requests?tenant_code=demo", f.other, nil)
+	if strings.TrimSpace(rec.Body.String()) != "[]" {
+		t.Errorf("other user list = %s", rec.Body)
+	}
+
+	if rec := f.decide(f.other, true); rec.Code != http.StatusNotFound || f.req.Status != model.BackchannelAuthStatusPending {
+		t.Fatalf("other user decide: status = %d, request = %s", rec.Code, f.req.Status)
+	}
+	if rec := f.decide(nil, true); rec.Code != http.StatusUnauthorized {
+		t.Errorf("no session: status = %d", rec.Code)
+	}
+
+	if rec := f.decide(f.session, true); rec.Code != http.StatusOK {
+		t.Fatalf("decide: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if f.req.Status != model.BackchannelAuthStatusApproved || f.req.SessionID == nil || *f.req.SessionID != f.session.ID {
+		t.Errorf("request not approved by session: %+v", f.req)
+	}
+	// 決定は1回のみ
+	if rec := f.decide(f.session, false); rec.Code != http.StatusBadRequest || f.req.Status != model.BackchannelAuthStatusApproved {
+		t.Errorf("second decide: status = %d, request = %s", rec.Code, f.req.Status)
+	}
+
+	t.Run("メールアドレス未確認のユーザーは承認できないが拒否はできる", func(t *testing.T) {
+		f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+		f.session.Tenant.EmailVerificationPolicy = model.EmailVerificationPolicyAuthorization
+		if rec := f.decide(f.session, true); rec.Code != http.StatusForbidden {
+			t.Errorf("approve: status = %d", rec.Code)
+		}
+		if rec := f.decide(f.session, false); rec.Code != http.StatusOK || f.req.Status != model.BackchannelAuthStatusDenied {
+			t.Errorf("deny: status = %d, request = %s", rec.Code, f.req.Status)
+		}
+	})
+}
+
+func TestBackchannelApprovalConsent(t *testing.T) {
+	f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+	f.req.Scope = "openid payments"
+
+	// 同意が必要なスコープを承認画面に示す
+	rec := f.call(f.handler.HandleList, http.MethodGet, "/internal/ciba/requests?tenant_code=demo", f.session, nil)
+	var list []cibaPendingRequestResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &list); err != nil || len(list) != 1 {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if len(list[0].ConsentScopes) != 1 || list[0].ConsentScopes[0].Name != "payments" || list[0].ConsentScopes[0].Description != "支払い" {
+		t.Errorf("consent_scopes = %+v", list[0].ConsentScopes)
+	}
+
+	// 承認すると同意を記録する
+	if rec := f.decide(f.session, true); rec.Code != http.StatusOK {
+		t.Fatalf("decide: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	consent := f.consents.consents[f.req.Client.ID]
+	if consent == nil || consent.UserID != f.session.UserID || !containsScope(consent.Scopes, "payments") {
+		t.Errorf("consent = %+v", consent)
+	}
+
+	t.Run("拒否では同意を記録しない", func(t *testing.T) {
+		f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+		f.req.Scope = "openid payments"
+		if rec := f.decide(f.session, false); rec.Code != http.StatusOK || len(f.consents.consents) != 0 {
+			t.Errorf("status = %d, consents = %+v", rec.Code, f.consents.consents)
+		}
+	})
+
+	t.Run("リクエスト後にクライアントに許可されなくなったスコープは承認できない", func(t *testing.T) {
+		f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePoll, "")
+		f.req.Scope = "openid payments"
+		f.req.Client.AllowedScopes = model.StringSlice{"openid"}
+		if rec := f.decide(f.session, true); rec.Code != http.StatusBadRequest || f.req.Status != model.BackchannelAuthStatusPending || len(f.consents.consents) != 0 {
+			t.Errorf("status = %d, request = %s", rec.Code, f.req.Status)
+		}
+		rec := f.call(f.handler.HandleList, http.MethodGet, "/internal/ciba/requests?tenant_code=demo", f.session, nil)
+		if strings.TrimSpace(rec.Body.String()) != "[]" {
+			t.Errorf("list = %s", rec.Body)
+		}
+	})
+}
+
+func TestBackchannelApprovalPing(t *testing.T) {
+	var gotAuth, gotBody string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotAuth = r.Header.Get("Authorization")
+		var body map[string]string
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		gotBody = body["auth_req_id"]
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePing, srv.URL+"/cb")
+	if rec := f.decide(f.session, false); rec.Code != http.StatusOK {
+		t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+	// client_notification_token を Bearer トークンとして auth_req_id を送る
+	if gotAuth != "Bearer cnt" || gotBody != "auth-req-id" {
+		t.Errorf("Authorization = %q, auth_req_id = %q", gotAuth, gotBody)
+	}
+
+	t.Run("通知の失敗は決定に影響しない", func(t *testing.T) {
+		f := newBackchannelApprovalFixture(model.BackchannelDeliveryModePing, "http://127.0.0.1:1/cb")
+		if rec := f.decide(f.session, true); rec.Code != http.StatusOK || f.req.Status != model.BackchannelAuthStatusApproved {
+			t.Errorf("status = %d, request = %s", rec.Code, f.req.Status)
+		}
+	})
+}
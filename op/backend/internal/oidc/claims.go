@@ -0,0 +1,143 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// scopeClaims はスコープで要求されるクレーム (OIDC Core 1.0 Section 5.4)
+var scopeClaims = map[string][]string{
+	"profile": {
+		"name", "family_name", "given_name", "middle_name", "nickname", "preferred_username",
+		"profile", "picture", "website", "gender", "birthdate", "zoneinfo", "locale", "updated_at",
+	},
+	"email":   {"email", "email_verified"},
+	"phone":   {"phone_number", "phone_number_verified"},
+	"address": {"address"},
+}
+
+// parseClaimsParam は claims パラメータを検証する。指定が無い場合は nil を返す。
+// 未知のメンバー・クレーム名は無視する (MUST: OIDC Core 1.0 Section 5.5)
+func parseClaimsParam(raw string) (*model.ClaimsRequest, error) {
+	if raw == "" {
+		return nil, nil
+	}
+
+	var req model.ClaimsRequest
+	if err := json.Unmarshal([]byte(raw), &req); err != nil {
+		return nil, fmt.Errorf("claims must be a JSON object")
+	}
+	for _, members := range []map[string]*model.ClaimRequest{req.UserInfo, req.IDToken} {
+		for name, cr := range members {
+			if cr == nil {
+				continue
+			}
+			if cr.Value != nil && cr.Values != nil {
+				return nil, fmt.Errorf("claim %s must not specify both value and values", name)
+			}
+			if cr.Values != nil && len(cr.Values) == 0 {
+				return nil, fmt.Errorf("claim %s values must not be empty", name)
+			}
+		}
+	}
+	return &req, nil
+}
+
+// availableUserClaims はユーザーについて提供できるクレームの値を返す。未設定のクレームは含めない。
+// カスタム属性はテナントのスキーマに現在定義されているもののみ含める (スキーマから削除された属性の値は返さない)
+func availableUserClaims(user *model.User) map[string]interface{} {
+	claims := map[string]interface{}{
+		"updated_at":     user.UpdatedAt.Unix(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+	for name, v := range map[string]*string{
+		"name":               user.Name,
+		"given_name":         user.GivenName,
+		"family_name":        user.FamilyName,
+		"middle_name":        user.MiddleName,
+		"nickname":           user.Nickname,
+		"preferred_username": user.PreferredUsername,
+		"profile":            user.Profile,
+		"picture":            user.Picture,
+		"website":            user.Website,
+		"gender":             user.Gender,
+		"birthdate":          user.Birthdate,
+		"zoneinfo":           user.Zoneinfo,
+		"locale":             user.Locale,
+	} {
+		if v != nil {
+			claims[name] = *v
+		}
+	}
+	if user.PhoneNumber != nil {
+		claims["phone_number"] = *user.PhoneNumber
+		claims["phone_number_verified"] = user.PhoneNumberVerified
+	}
+	if !user.Address.IsEmpty() {
+		claims["address"] = user.Address
+	}
+	for _, name := range user.Tenant.UserAttributeNames() {
+		if v, ok := user.CustomAttributes[name]; ok {
+			claims[name] = v
+		}
+	}
+	return claims
+}
+
+// buildUserClaims はスコープと claims パラメータから返すユーザークレームを組み立てる。sub は含めない。
+// includeScope が false の場合はスコープ由来のクレームを含めない (アクセストークンを発行した場合の ID トークン)。
+// value / values に一致しないクレームは返さない。essential でも提供できないクレームはエラーにしない (OIDC Core 1.0 Section 5.5.1)
+func buildUserClaims(user *model.User, scope string, requested map[string]*model.ClaimRequest, includeScope bool) map[string]interface{} {
+	available := availableUserClaims(user)
+	claims := map[string]interface{}{}
+
+	if includeScope {
+		for _, s := range strings.Fields(scope) {
+			for _, name := range scopeClaims[s] {
+				if v, ok := available[name]; ok {
+					claims[name] = v
+				}
+			}
+			// テナントが定義したスコープのクレーム。値が未設定の属性は返さない
+			if def := user.Tenant.ScopeDefinition(s); def != nil {
+				for _, m := range def.Claims {
+					if m.Attribute == "" {
+						claims[m.Claim] = m.Value
+					} else if v, ok := available[m.Attribute]; ok {
+						claims[m.Claim] = v
+					}
+				}
+			}
+		}
+	}
+
+	for name, cr := range requested {
+		v, ok := available[name]
+		if !ok {
+			continue
+		}
+		if cr.Accepts(v) {
+			claims[name] = v
+		} else {
+			delete(claims, name)
+		}
+	}
+	return claims
+}
+
+// requestedSubject は claims パラメータで sub の値が指定されている場合にその候補を返す
+func requestedSubject(req *model.ClaimsRequest) *model.ClaimRequest {
+	if req == nil {
+		return nil
+	}
+	for _, members := range []map[string]*model.ClaimRequest{req.IDToken, req.UserInfo} {
+		if cr := members["sub"]; cr != nil && (cr.Value != nil || cr.Values != nil) {
+			return cr
+		}
+	}
+	return nil
+}
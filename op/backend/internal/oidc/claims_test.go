@@ -0,0 +1,208 @@
+package oidc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+func TestParseClaimsParam(t *testing.T) {
+	tests := []struct {
+		name    string
+		raw     string
+		wantErr bool
+		check   func(t *testing.T, req *model.ClaimsRequest)
+	}{
+		{name: "指定なし", check: func(t *testing.T, req *model.ClaimsRequest) {
+			if req != nil {
+				t.Errorf("req = %+v, want nil", req)
+			}
+		}},
+		{name: "null と essential", raw: `{"id_token":{"email":null,"name":{"essential":true}},"userinfo":{"picture":null}}`, check: func(t *testing.T, req *model.ClaimsRequest) {
+			if cr, ok := req.IDToken["email"]; !ok || cr != nil {
+				t.Errorf("email = %+v, %v", cr, ok)
+			}
+			if cr := req.IDToken["name"]; cr == nil || !cr.Essential {
+				t.Errorf("name = %+v", cr)
+			}
+			if _, ok := req.UserInfo["picture"]; !ok {
+				t.Error("picture missing from userinfo")
+			}
+		}},
+		{name: "未知のメンバーは無視", raw: `{"id_token":{"email":null},"unknown":{"x":1}}`, check: func(t *testing.T, req *model.ClaimsRequest) {
+			if len(req.IDToken) != 1 {
+				t.Errorf("id_token = %+v", req.IDToken)
+			}
+		}},
+		{name: "JSON でない", raw: `email`, wantErr: true},
+		{name: "配列", raw: `["email"]`, wantErr: true},
+		{name: "value と values の両方", raw: `{"id_token":{"acr":{"value":"a","values":["a"]}}}`, wantErr: true},
+		{name: "空の values", raw: `{"userinfo":{"acr":{"values":[]}}}`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := parseClaimsParam(tt.raw)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.check != nil {
+				tt.check(t, req)
+			}
+		})
+	}
+}
+
+func TestClaimRequestAccepts(t *testing.T) {
+	tests := []struct {
+		name   string
+		cr     *model.ClaimRequest
+		actual interface{}
+		want   bool
+	}{
+		{name: "null", cr: nil, actual: "x", want: true},
+		{name: "essential のみ", cr: &model.ClaimRequest{Essential: true}, actual: "x", want: true},
+		{name: "value 一致", cr: &model.ClaimRequest{Value: "alice@example.com"}, actual: "alice@example.com", want: true},
+		{name: "value 不一致", cr: &model.ClaimRequest{Value: "bob@example.com"}, actual: "alice@example.com", want: false},
+		{name: "values のいずれかに一致", cr: &model.ClaimRequest{Values: []interface{}{"ja-JP", "en-US"}}, actual: "en-US", want: true},
+		{name: "values に不一致", cr: &model.ClaimRequest{Values: []interface{}{"ja-JP"}}, actual: "en-US", want: false},
+		// JSON から読んだ数値 (float64) と Go の値 (int64) を JSON の値として比較する
+		{name: "数値の型違い", cr: &model.ClaimRequest{Value: float64(1700000000)}, actual: int64(1700000000), want: true},
+		{name: "真偽値", cr: &model.ClaimRequest{Value: true}, actual: false, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cr.Accepts(tt.actual); got != tt.want {
+				t.Errorf("Accepts = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func testClaimsUser() *model.User {
+	name := "Alice Example"
+	locale := "ja-JP"
+	phone := "+81-90-0000-0000"
+	return &model.User{
+		Email:         "alice@example.com",
+		EmailVerified: true,
+		Name:          &name,
+		Locale:        &locale,
+		PhoneNumber:   &phone,
+		UpdatedAt:     time.Unix(1700000000, 0),
+	}
+}
+
+func TestBuildUserClaims(t *testing.T) {
+	user := testClaimsUser()
+	tests := []struct {
+		name         string
+		scope        string
+		requested    map[string]*model.ClaimRequest
+		includeScope bool
+		want         []string
+	}{
+		{name: "スコープ由来", scope: "openid email", includeScope: true, want: []string{"email", "email_verified"}},
+		{name: "未設定のクレームは含めない", scope: "openid profile", includeScope: true, want: []string{"locale", "name", "updated_at"}},
+		{name: "アクセストークン発行時はスコープ由来を含めない", scope: "openid email", want: nil},
+		{name: "claims パラメータの個別要求", scope: "openid", requested: map[string]*model.ClaimRequest{"email": nil}, want: []string{"email"}},
+		{name: "essential でも提供できないクレームはエラーにしない", scope: "openid", requested: map[string]*model.ClaimRequest{"picture": {Essential: true}}, want: nil},
+		{name: "value に一致しない場合はスコープ由来でも返さない", scope: "openid email", includeScope: true, requested: map[string]*model.ClaimRequest{"email": {Value: "bob@example.com"}}, want: []string{"email_verified"}},
+		{name: "values に一致", scope: "openid", requested: map[string]*model.ClaimRequest{"locale": {Values: []interface{}{"en-US", "ja-JP"}}}, want: []string{"locale"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := buildUserClaims(user, tt.scope, tt.requested, tt.includeScope)
+			if got := sortedKeys(claims); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("claims = %v, want %v", got, tt.want)
+			}
+			if _, ok := claims["sub"]; ok {
+				t.Error("sub must not be built from user claims")
+			}
+		})
+	}
+}
+
+func TestAvailableUserClaims(t *testing.T) {
+	schema := `{"type":"object","properties":{"department":{"type":"string"},"grade":{"type":"integer"}}}`
+	user := testClaimsUser()
+	user.Address = &model.Address{Country: "JP"}
+	user.Tenant = model.Tenant{UserAttributeSchema: &schema}
+	// retired はスキーマから削除された属性
+	user.CustomAttributes = model.UserAttributes{"department": "sales", "retired": "x"}
+
+	claims := availableUserClaims(user)
+	want := []string{"address", "department", "email", "email_verified", "locale", "name", "phone_number", "phone_number_verified", "updated_at"}
+	if got := sortedKeys(claims); !reflect.DeepEqual(got, want) {
+		t.Errorf("claims = %v, want %v", got, want)
+	}
+	if claims["department"] != "sales" || claims["updated_at"] != int64(1700000000) || claims["phone_number_verified"] != false {
+		t.Errorf("claims = %v", claims)
+	}
+
+	// 空の address は返さない
+	user.Address = &model.Address{}
+	if _, ok := availableUserClaims(user)["address"]; ok {
+		t.Error("empty address returned")
+	}
+	// スキーマのないテナントではカスタム属性を返さない
+	user.Tenant = model.Tenant{}
+	if _, ok := availableUserClaims(user)["department"]; ok {
+		t.Error("custom attribute returned without schema")
+	}
+}
+
+func TestBuildUserClaimsScopeDefinition(t *testing.T) {
+	schema := `{"type":"object","properties":{"department":{"type":"string"}}}`
+	user := testClaimsUser()
+	user.CustomAttributes = model.UserAttributes{"department": "sales"}
+	user.Tenant = model.Tenant{
+		UserAttributeSchema: &schema,
+		ScopeDefinitions: []model.ScopeDefinition{{
+			Name: "employee",
+			Claims: model.ScopeClaimMappings{
+				{Claim: "dept", Attribute: "department"},
+				{Claim: "org", Value: "example"},
+				{Claim: "title", Attribute: "job_title"},
+			},
+		}},
+	}
+
+	claims := buildUserClaims(user, "openid employee", nil, true)
+	// 値が未設定の属性 (job_title) は返さない
+	if got := sortedKeys(claims); !reflect.DeepEqual(got, []string{"dept", "org"}) {
+		t.Errorf("claims = %v", got)
+	}
+	if claims["dept"] != "sales" || claims["org"] != "example" {
+		t.Errorf("claims = %v", claims)
+	}
+	// カスタム属性は claims パラメータでも個別に要求できる
+	claims = buildUserClaims(user, "openid", map[string]*model.ClaimRequest{"department": nil}, false)
+	if claims["department"] != "sales" {
+		t.Errorf("claims = %v", claims)
+	}
+}
+
+func TestRequestedSubject(t *testing.T) {
+	if requestedSubject(nil) != nil {
+		t.Error("nil request")
+	}
+	if requestedSubject(&model.ClaimsRequest{IDToken: map[string]*model.ClaimRequest{"sub": nil}}) != nil {
+		t.Error("sub without value must not constrain the subject")
+	}
+	cr := &model.ClaimRequest{Value: "248289761001"}
+	if got := requestedSubject(&model.ClaimsRequest{UserInfo: map[string]*model.ClaimRequest{"sub": cr}}); got != cr {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	var keys []string
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,408 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	infra_crypto "github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// clientAssertionTypeJWTBearer は client_assertion_type の値 (RFC 7523 Section 2.2)
+const clientAssertionTypeJWTBearer = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// clientAssertionSkew はクライアントアサーションの exp / nbf / iat に許容する時計のずれ
+const clientAssertionSkew = 30 * time.Second
+
+// ClientAuthenticator はトークンエンドポイント / リボケーションエンドポイントのクライアント認証を行う。
+// 仕様参照: RFC 6749 Section 2.3, OIDC Core 1.0 Section 9, RFC 7523
+type ClientAuthenticator struct {
+	clientFinder   ClientFinder
+	rehashStore    ClientSecretRehashStore
+	jtiStore       ClientAssertionJTIStore
+	verifyPassword VerifyPasswordFunc
+	hashPassword   HashPasswordFunc
+	needsRehash    PasswordNeedsRehashFunc
+	decryptSecret  DecryptSecretFunc
+	certExtractor  *ClientCertificateExtractor
+	clientCAs      *x509.CertPool
+	httpClient     *http.Client
+	issuerBaseURL  string
+}
+
+// NewClientAuthenticator は ClientAuthenticator を生成する。
+// clientCAs は tls_client_auth の証明書チェーン検証に使う信頼済み CA。nil の場合 tls_client_auth は常に失敗する。
+func NewClientAuthenticator(
+	clientFinder ClientFinder,
+	rehashStore ClientSecretRehashStore,
+	jtiStore ClientAssertionJTIStore,
+	verifyPassword VerifyPasswordFunc,
+	hashPassword HashPasswordFunc,
+	needsRehash PasswordNeedsRehashFunc,
+	decryptSecret DecryptSecretFunc,
+	certExtractor *ClientCertificateExtractor,
+	clientCAs *x509.CertPool,
+	issuerBaseURL string,
+) *ClientAuthenticator {
+	return &ClientAuthenticator{
+		clientFinder:   clientFinder,
+		rehashStore:    rehashStore,
+		jtiStore:       jtiStore,
+		verifyPassword: verifyPassword,
+		hashPassword:   hashPassword,
+		needsRehash:    needsRehash,
+		decryptSecret:  decryptSecret,
+		certExtractor:  certExtractor,
+		clientCAs:      clientCAs,
+		httpClient:     &http.Client{Timeout: 5 * time.Second},
+		issuerBaseURL:  issuerBaseURL,
+	}
+}
+
+// Authenticate はリクエストからクライアントを認証する。
+// クライアントが登録した token_endpoint_auth_method 以外の方式は受け付けない。
+// 認証失敗時は ErrInvalidClient を返す。
+func (a *ClientAuthenticator) Authenticate(c echo.Context) (*model.Client, error) {
+	clientID := c.FormValue("client_id")
+	clientSecret := c.FormValue("client_secret")
+	assertionType := c.FormValue("client_assertion_type")
+	assertion := c.FormValue("client_assertion")
+	basicID, basicSecret, hasBasic := c.Request().BasicAuth()
+
+	// 複数の認証方式を同時に使ってはならない (MUST NOT: RFC 6749 Section 2.3)
+	presented := 0
+	for _, used := range []bool{hasBasic, clientSecret != "", assertion != ""} {
+		if used {
+			presented++
+		}
+	}
+	if presented > 1 {
+		return nil, ErrInvalidClient
+	}
+
+	switch {
+	case hasBasic:
+		// client_secret_basic: client_id / client_secret は form-urlencoded されている (RFC 6749 Section 2.3.1)
+		id, err := url.QueryUnescape(basicID)
+		if err != nil {
+			return nil, ErrInvalidClient
+		}
+		secret, err := url.QueryUnescape(basicSecret)
+		if err != nil {
+			return nil, ErrInvalidClient
+		}
+		if clientID != "" && clientID != id {
+			return nil, ErrInvalidClient
+		}
+		return a.authenticateSecret(c, id, secret, "client_secret_basic")
+
+	case clientSecret != "":
+		return a.authenticateSecret(c, clientID, clientSecret, "client_secret_post")
+
+	case assertion != "":
+		if assertionType != clientAssertionTypeJWTBearer {
+			return nil, ErrInvalidClient
+		}
+		return a.authenticateAssertion(c, clientID, assertion)
+
+	default:
+		return a.authenticateClientID(c, clientID)
+	}
+}
+
+// authenticateSecret は client_secret_basic / client_secret_post を検証する
+func (a *ClientAuthenticator) authenticateSecret(c echo.Context, clientID, clientSecret, method string) (*model.Client, error) {
+	if clientID == "" || clientSecret == "" {
+		return nil, ErrInvalidClient
+	}
+	ctx := c.Request().Context()
+
+	client, err := a.findActiveClient(ctx, clientID)
+	if err != nil {
+		return nil, err
+	}
+	if client.TokenEndpointAuthMethod != method || client.ClientSecretHash == nil {
+		return nil, ErrInvalidClient
+	}
+
+	match, err := a.verifyPassword(ctx, clientSecret, *client.ClientSecretHash)
+	if errors.Is(err, infra_crypto.ErrPasswordHasherBusy) {
+		return nil, err
+	}
+	if err != nil || !match {
+		return nil, ErrInvalidClient
+	}
+
+	if a.needsRehash(*client.ClientSecretHash) {
+		a.rehashSecret(c, client, clientSecret)
+	}
+	return client, nil
+}
+
+// rehashSecret は client_secret を現在のパラメータでハッシュし直す。
+// 失敗しても次の認証で作り直せるため、ログに残して認証は成功させる
+func (a *ClientAuthenticator) rehashSecret(c echo.Context, client *model.Client, clientSecret string) {
+	ctx := c.Request().Context()
+	rehashed, err := a.hashPassword(ctx, clientSecret)
+	if err != nil {
+		c.Logger().Errorf("failed to rehash client secret for client %s: %v", client.ClientID, err)
+		return
+	}
+	// 同時に client_secret が再発行された場合は置き換えない
+	if _, err := a.rehashStore.RehashSecret(ctx, client.ID, *client.ClientSecretHash, rehashed); err != nil {
+		c.Logger().Errorf("failed to save rehashed client secret for client %s: %v", client.ClientID, err)
+	}
+}
+
+// authenticateClientID は client_id のみが送られた場合の認証を行う。
+// 公開クライアント (none) と Mutual-TLS (RFC 8705) がこれに該当する。
+// 公開クライアントは認証できないため、PKCE の検証は呼び出し側で必須とする (RFC 9700 Section 2.1.1)。
+func (a *ClientAuthenticator) authenticateClientID(c echo.Context, clientID string) (*model.Client, error) {
+	if clientID == "" {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := a.findActiveClient(c.Request().Context(), clientID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch client.TokenEndpointAuthMethod {
+	case "none":
+		return client, nil
+	case "tls_client_auth", "self_signed_tls_client_auth":
+		chain, err := a.certExtractor.ExtractChain(c)
+		if err != nil || len(chain) == 0 {
+			return nil, ErrInvalidClient
+		}
+		if client.TokenEndpointAuthMethod == "tls_client_auth" {
+			err = a.verifyPKICertificate(client, chain)
+		} else {
+			err = a.verifySelfSignedCertificate(c.Request().Context(), client, chain[0])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		return client, nil
+	default:
+		return nil, ErrInvalidClient
+	}
+}
+
+// verifyPKICertificate は tls_client_auth の証明書を検証する。chain の先頭がクライアント証明書で、残りは提示された中間 CA 証明書。
+// 信頼済み CA までのチェーンを検証し、登録された Subject DN または SAN のいずれか1つと一致することを確認する (RFC 8705 Section 2.1)。
+func (a *ClientAuthenticator) verifyPKICertificate(client *model.Client, chain []*x509.Certificate) error {
+	if a.clientCAs == nil {
+		return errors.New("no trusted client CA configured")
+	}
+	cert := chain[0]
+	intermediates := x509.NewCertPool()
+	for _, c := range chain[1:] {
+		intermediates.AddCert(c)
+	}
+	if _, err := cert.Verify(x509.VerifyOptions{
+		Roots:         a.clientCAs,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}); err != nil {
+		return fmt.Errorf("certificate chain verification failed: %w", err)
+	}
+
+	switch {
+	case client.TLSClientAuthSubjectDN != nil:
+		if cert.Subject.String() == *client.TLSClientAuthSubjectDN {
+			return nil
+		}
+	case client.TLSClientAuthSANDNS != nil:
+		for _, v := range cert.DNSNames {
+			if v == *client.TLSClientAuthSANDNS {
+				return nil
+			}
+		}
+	case client.TLSClientAuthSANURI != nil:
+		for _, v := range cert.URIs {
+			if v.String() == *client.TLSClientAuthSANURI {
+				return nil
+			}
+		}
+	case client.TLSClientAuthSANIP != nil:
+		expected := net.ParseIP(*client.TLSClientAuthSANIP)
+		for _, v := range cert.IPAddresses {
+			if v.Equal(expected) {
+				return nil
+			}
+		}
+	case client.TLSClientAuthSANEmail != nil:
+		for _, v := range cert.EmailAddresses {
+			if v == *client.TLSClientAuthSANEmail {
+				return nil
+			}
+		}
+	}
+	return errors.New("certificate does not match registered subject")
+}
+
+// verifySelfSignedCertificate は self_signed_tls_client_auth の証明書を検証する。
+// 証明書の公開鍵がクライアントの登録した jwks / jwks_uri のいずれかの鍵と一致することを確認する (RFC 8705 Section 2.2)。
+func (a *ClientAuthenticator) verifySelfSignedCertificate(ctx context.Context, client *model.Client, cert *x509.Certificate) error {
+	set, err := a.clientKeySet(ctx, client)
+	if err != nil {
+		return err
+	}
+
+	certKey, ok := cert.PublicKey.(interface{ Equal(x crypto.PublicKey) bool })
+	if !ok {
+		return errors.New("unsupported certificate public key")
+	}
+	for i := 0; i < set.Len(); i++ {
+		key, _ := set.Key(i)
+		var raw any
+		if err := jwk.Export(key, &raw); err != nil {
+			continue
+		}
+		if certKey.Equal(raw) {
+			return nil
+		}
+	}
+	return errors.New("certificate public key is not registered")
+}
+
+// authenticateAssertion は private_key_jwt / client_secret_jwt を検証する
+// 仕様参照: RFC 7523 Section 3, OIDC Core 1.0 Section 9
+func (a *ClientAuthenticator) authenticateAssertion(c echo.Context, clientID, assertion string) (*model.Client, error) {
+	ctx := c.Request().Context()
+
+	// 署名検証前に iss / sub からクライアントを特定する (client_id は任意パラメータ)
+	unverified, err := jwt.ParseInsecure([]byte(assertion))
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+	sub, _ := unverified.Subject()
+	if sub == "" || (clientID != "" && clientID != sub) {
+		return nil, ErrInvalidClient
+	}
+
+	client, err := a.findActiveClient(ctx, sub)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyOption jwt.ParseOption
+	switch client.TokenEndpointAuthMethod {
+	case "client_secret_jwt":
+		if client.ClientSecretEncrypted == nil {
+			return nil, ErrInvalidClient
+		}
+		secret, err := a.decryptSecret(*client.ClientSecretEncrypted)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decrypt client secret: %w", err)
+		}
+		keyOption = jwt.WithKey(jwa.HS256(), []byte(secret))
+	case "private_key_jwt":
+		set, err := a.clientKeySet(ctx, client)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidClient, err)
+		}
+		keyOption = jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true))
+	default:
+		return nil, ErrInvalidClient
+	}
+
+	// iss と sub は client_id (MUST: RFC 7523 Section 3)
+	token, err := jwt.Parse([]byte(assertion),
+		keyOption,
+		jwt.WithIssuer(client.ClientID),
+		jwt.WithSubject(client.ClientID),
+		jwt.WithAcceptableSkew(clientAssertionSkew),
+	)
+	if err != nil {
+		return nil, ErrInvalidClient
+	}
+
+	// aud は認可サーバーを示す値 (MUST: RFC 7523 Section 3)
+	if !a.isAcceptableAudience(c, token) {
+		return nil, ErrInvalidClient
+	}
+
+	// exp と jti は必須 (MUST: OIDC Core 1.0 Section 9)
+	exp, ok := token.Expiration()
+	if !ok {
+		return nil, ErrInvalidClient
+	}
+	jti, ok := token.JwtID()
+	if !ok || jti == "" {
+		return nil, ErrInvalidClient
+	}
+
+	// jti リプレイ検知
+	fresh, err := a.jtiStore.Register(ctx, client.ID, jti, exp.Add(clientAssertionSkew))
+	if err != nil {
+		return nil, fmt.Errorf("failed to register client assertion jti: %w", err)
+	}
+	if !fresh {
+		return nil, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+// isAcceptableAudience は aud に issuer、トークンエンドポイント、または今回呼ばれたエンドポイントの URL が含まれるか確認する
+func (a *ClientAuthenticator) isAcceptableAudience(c echo.Context, token jwt.Token) bool {
+	aud, ok := token.Audience()
+	if !ok {
+		return false
+	}
+
+	issuer := a.issuerBaseURL + "/" + c.Param("tenant_code")
+	acceptable := map[string]bool{
+		issuer:                                 true,
+		issuer + "/token":                      true,
+		a.issuerBaseURL + c.Request().URL.Path: true,
+	}
+	for _, v := range aud {
+		if acceptable[v] {
+			return true
+		}
+	}
+	return false
+}
+
+// clientKeySet はクライアントが登録した jwks、または jwks_uri から取得した鍵セットを返す
+func (a *ClientAuthenticator) clientKeySet(ctx context.Context, client *model.Client) (jwk.Set, error) {
+	return fetchClientKeySet(ctx, a.httpClient, client)
+}
+
+// fetchClientKeySet はクライアントが登録した jwks、または jwks_uri から取得した鍵セットを返す
+func fetchClientKeySet(ctx context.Context, httpClient *http.Client, client *model.Client) (jwk.Set, error) {
+	if client.JWKS != nil && *client.JWKS != "" {
+		return jwk.ParseString(*client.JWKS)
+	}
+	if client.JWKSURI != nil && *client.JWKSURI != "" {
+		return jwk.Fetch(ctx, *client.JWKSURI, jwk.WithHTTPClient(httpClient))
+	}
+	return nil, errors.New("client has no registered keys")
+}
+
+func (a *ClientAuthenticator) findActiveClient(ctx context.Context, clientID string) (*model.Client, error) {
+	client, err := a.clientFinder.FindByClientID(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find client: %w", err)
+	}
+	if client == nil || client.Status != "active" {
+		return nil, ErrInvalidClient
+	}
+	return client, nil
+}
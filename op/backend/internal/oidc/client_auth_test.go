@@ -0,0 +1,516 @@
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwk"
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	infra_crypto "github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeJTIStore struct {
+	seen map[string]bool
+}
+
+func (f *fakeJTIStore) Register(_ context.Context, clientID uuid.UUID, jti string, _ time.Time) (bool, error) {
+	key := clientID.String() + ":" + jti
+	if f.seen[key] {
+		return false, nil
+	}
+	f.seen[key] = true
+	return true, nil
+}
+
+type fakeSecretRehashStore struct {
+	err      error
+	from, to string
+}
+
+func (f *fakeSecretRehashStore) RehashSecret(_ context.Context, _ uuid.UUID, from, to string) (bool, error) {
+	if f.err != nil {
+		return false, f.err
+	}
+	f.from, f.to = from, to
+	return true, nil
+}
+
+// テスト用のハッシュは "hash:" + 平文
+func fakeVerifyPassword(_ context.Context, password, hash string) (bool, error) {
+	return hash == "hash:"+password, nil
+}
+
+func fakeHashPassword(_ context.Context, password string) (string, error) {
+	return "hash:" + password, nil
+}
+
+func newTestClientAuthenticator(clients ...*model.Client) *ClientAuthenticator {
+	return NewClientAuthenticator(
+		newFakeClientFinder(clients...), &fakeSecretRehashStore{}, &fakeJTIStore{seen: map[string]bool{}},
+		fakeVerifyPassword, fakeHashPassword, func(string) bool { return false },
+		func(s string) (string, error) { return strings.TrimPrefix(s, "enc:"), nil },
+		&ClientCertificateExtractor{}, nil, testIssuerBaseURL,
+	)
+}
+
+// newTokenContext はテナント demo のトークンエンドポイントへの POST を作る
+func newTokenContext(form url.Values) (echo.Context, *http.Request) {
+	req := httptest.NewRequest(http.MethodPost, "/demo/token", strings.NewReader(form.Encode()))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+	c.SetParamNames("tenant_code")
+	c.SetParamValues("demo")
+	return c, req
+}
+
+func TestAuthenticateSecret(t *testing.T) {
+	basic := &model.Client{ID: uuid.New(), ClientID: "basic-client", ClientSecretHash: strPtr("hash:s3cret"), TokenEndpointAuthMethod: "client_secret_basic", Status: "active"}
+	post := &model.Client{ID: uuid.New(), ClientID: "post-client", ClientSecretHash: strPtr("hash:s3cret"), TokenEndpointAuthMethod: "client_secret_post", Status: "active"}
+	disabled := &model.Client{ID: uuid.New(), ClientID: "disabled-client", ClientSecretHash: strPtr("hash:s3cret"), TokenEndpointAuthMethod: "client_secret_post", Status: "disabled"}
+	a := newTestClientAuthenticator(basic, post, disabled)
+
+	tests := []struct {
+		name      string
+		form      url.Values
+		basicUser string
+		basicPass string
+		wantID    string
+	}{
+		{name: "basic", basicUser: "basic-client", basicPass: "s3cret", wantID: "basic-client"},
+		{name: "basic は form-urlencoded を復号する", basicUser: "basic%2Dclient", basicPass: "s3cret", wantID: "basic-client"},
+		{name: "basic のパスワード不一致", basicUser: "basic-client", basicPass: "wrong"},
+		{name: "basic で登録された post のクライアント", basicUser: "post-client", basicPass: "s3cret"},
+		{name: "basic と client_id の不一致", form: url.Values{"client_id": {"post-client"}}, basicUser: "basic-client", basicPass: "s3cret"},
+		{name: "post", form: url.Values{"client_id": {"post-client"}, "client_secret": {"s3cret"}}, wantID: "post-client"},
+		{name: "post で登録された basic のクライアント", form: url.Values{"client_id": {"basic-client"}, "client_secret": {"s3cret"}}},
+		{name: "無効なクライアント", form: url.Values{"client_id": {"disabled-client"}, "client_secret": {"s3cret"}}},
+		{name: "存在しないクライアント", form: url.Values{"client_id": {"unknown"}, "client_secret": {"s3cret"}}},
+		{name: "複数の認証方式", form: url.Values{"client_id": {"basic-client"}, "client_secret": {"s3cret"}}, basicUser: "basic-client", basicPass: "s3cret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, req := newTokenContext(tt.form)
+			if tt.basicUser != "" {
+				req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte(tt.basicUser+":"+tt.basicPass)))
+			}
+			client, err := a.Authenticate(c)
+			if tt.wantID == "" {
+				if err != ErrInvalidClient {
+					t.Fatalf("err = %v, want ErrInvalidClient", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if client.ClientID != tt.wantID {
+				t.Errorf("client_id = %s, want %s", client.ClientID, tt.wantID)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSecretRehash(t *testing.T) {
+	tests := []struct {
+		name     string
+		hashErr  error
+		storeErr error
+		wantTo   string
+		wantLog  string
+	}{
+		{name: "作り直したハッシュを保存する", wantTo: "hash:s3cret"},
+		// 作り直しは次の認証でもできるため、失敗しても認証は成功させ、echo のロガーに記録する
+		{name: "ハッシュ化の失敗", hashErr: infra_crypto.ErrPasswordHasherBusy, wantLog: "failed to rehash client secret"},
+		{name: "保存の失敗", storeErr: errors.New("database is down"), wantLog: "failed to save rehashed client secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &model.Client{ID: uuid.New(), ClientID: "post-client", ClientSecretHash: strPtr("legacy:s3cret"), TokenEndpointAuthMethod: "client_secret_post", Status: "active"}
+			a := newTestClientAuthenticator(client)
+			store := &fakeSecretRehashStore{err: tt.storeErr}
+			a.rehashStore = store
+			a.verifyPassword = func(_ context.Context, password, hash string) (bool, error) { return hash == "legacy:"+password, nil }
+			a.needsRehash = func(hash string) bool { return strings.HasPrefix(hash, "legacy:") }
+			a.hashPassword = func(ctx context.Context, password string) (string, error) {
+				if tt.hashErr != nil {
+					return "", tt.hashErr
+				}
+				return fakeHashPassword(ctx, password)
+			}
+
+			c, _ := newTokenContext(url.Values{"client_id": {"post-client"}, "client_secret": {"s3cret"}})
+			var logs bytes.Buffer
+			c.Logger().SetOutput(&logs)
+			got, err := a.Authenticate(c)
+			if err != nil || got == nil || got.ID != client.ID {
+				t.Fatalf("client = %v, err = %v", got, err)
+			}
+			if store.to != tt.wantTo {
+				t.Errorf("rehashed = %q, want %q", store.to, tt.wantTo)
+			}
+			if got := logs.String(); (tt.wantLog == "" && got != "") || !strings.Contains(got, tt.wantLog) {
+				t.Errorf("logs = %q, want %q", got, tt.wantLog)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSecretHasherBusy(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), ClientID: "post-client", ClientSecretHash: strPtr("hash:s3cret"), TokenEndpointAuthMethod: "client_secret_post", Status: "active"}
+	a := newTestClientAuthenticator(client)
+	a.verifyPassword = func(context.Context, string, string) (bool, error) { return false, infra_crypto.ErrPasswordHasherBusy }
+
+	// 混み合っている場合は invalid_client にせず、再試行できるよう 503 を返す
+	c, _ := newTokenContext(url.Values{"client_id": {"post-client"}, "client_secret": {"s3cret"}})
+	_, err := a.Authenticate(c)
+	if !errors.Is(err, infra_crypto.ErrPasswordHasherBusy) {
+		t.Fatalf("err = %v", err)
+	}
+	rec := httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/demo/token", nil), rec)
+	if err := clientAuthError(c, err); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusServiceUnavailable || !strings.Contains(rec.Body.String(), `"temporarily_unavailable"`) {
+		t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAuthenticatePublicClient(t *testing.T) {
+	public := &model.Client{ID: uuid.New(), ClientID: "spa", TokenEndpointAuthMethod: "none", Status: "active"}
+	confidential := &model.Client{ID: uuid.New(), ClientID: "web", ClientSecretHash: strPtr("hash:x"), TokenEndpointAuthMethod: "client_secret_post", Status: "active"}
+	a := newTestClientAuthenticator(public, confidential)
+
+	c, _ := newTokenContext(url.Values{"client_id": {"spa"}})
+	if client, err := a.Authenticate(c); err != nil || client.ClientID != "spa" {
+		t.Fatalf("public client: client = %v, err = %v", client, err)
+	}
+	// 機密クライアントは client_id だけでは認証できない
+	c, _ = newTokenContext(url.Values{"client_id": {"web"}})
+	if _, err := a.Authenticate(c); err != ErrInvalidClient {
+		t.Fatalf("confidential client without secret: err = %v", err)
+	}
+}
+
+type assertionClaims struct {
+	iss, sub string
+	aud      []string
+	exp      time.Time
+	jti      string
+}
+
+func validAssertionClaims(clientID string) assertionClaims {
+	return assertionClaims{
+		iss: clientID,
+		sub: clientID,
+		aud: []string{testIssuerBaseURL + "/demo/token"},
+		exp: time.Now().Add(time.Minute),
+		jti: uuid.NewString(),
+	}
+}
+
+func (ac assertionClaims) build(t *testing.T) jwt.Token {
+	t.Helper()
+	b := jwt.NewBuilder().Issuer(ac.iss).Subject(ac.sub).Audience(ac.aud).IssuedAt(time.Now())
+	if !ac.exp.IsZero() {
+		b = b.Expiration(ac.exp)
+	}
+	if ac.jti != "" {
+		b = b.JwtID(ac.jti)
+	}
+	token, err := b.Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func signRS256(t *testing.T, token jwt.Token, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	hdrs := jws.NewHeaders()
+	_ = hdrs.Set(jws.KeyIDKey, kid)
+	signed, err := jwt.Sign(token, jwt.WithKey(jwa.RS256(), key, jws.WithProtectedHeaders(hdrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(signed)
+}
+
+// publicJWKS は鍵の公開鍵を kid 付きの JWKS にする
+func publicJWKS(t *testing.T, key *rsa.PrivateKey, kid string) string {
+	t.Helper()
+	pub, err := jwk.Import(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = pub.Set(jwk.KeyIDKey, kid)
+	set := jwk.NewSet()
+	_ = set.AddKey(pub)
+	raw, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(raw)
+}
+
+func assertionForm(assertion string) url.Values {
+	return url.Values{"client_assertion_type": {clientAssertionTypeJWTBearer}, "client_assertion": {assertion}}
+}
+
+func TestAuthenticatePrivateKeyJWT(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &model.Client{ID: uuid.New(), ClientID: "pkjwt", TokenEndpointAuthMethod: "private_key_jwt", JWKS: strPtr(publicJWKS(t, key, "k1")), Status: "active"}
+	secretClient := &model.Client{ID: uuid.New(), ClientID: "post", ClientSecretHash: strPtr("hash:x"), TokenEndpointAuthMethod: "client_secret_post", Status: "active"}
+
+	tests := []struct {
+		name   string
+		modify func(*assertionClaims)
+		key    *rsa.PrivateKey
+		form   func(string) url.Values
+		ok     bool
+	}{
+		{name: "有効", ok: true},
+		{name: "aud が issuer", modify: func(c *assertionClaims) { c.aud = []string{testIssuerBaseURL + "/demo"} }, ok: true},
+		{name: "aud が別のテナント", modify: func(c *assertionClaims) { c.aud = []string{testIssuerBaseURL + "/other/token"} }},
+		{name: "iss と sub の不一致", modify: func(c *assertionClaims) { c.iss = "someone-else" }},
+		{name: "期限切れ", modify: func(c *assertionClaims) { c.exp = time.Now().Add(-time.Hour) }},
+		{name: "exp なし", modify: func(c *assertionClaims) { c.exp = time.Time{} }},
+		{name: "jti なし", modify: func(c *assertionClaims) { c.jti = "" }},
+		{name: "登録されていない鍵", key: otherKey},
+		{name: "client_id と sub の不一致", form: func(a string) url.Values {
+			f := assertionForm(a)
+			f.Set("client_id", "post")
+			return f
+		}},
+		{name: "client_assertion_type の誤り", form: func(a string) url.Values {
+			f := assertionForm(a)
+			f.Set("client_assertion_type", "urn:example")
+			return f
+		}},
+		{name: "private_key_jwt で登録されていないクライアント", modify: func(c *assertionClaims) { c.iss, c.sub = "post", "post" }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newTestClientAuthenticator(client, secretClient)
+			claims := validAssertionClaims("pkjwt")
+			if tt.modify != nil {
+				tt.modify(&claims)
+			}
+			signingKey := key
+			if tt.key != nil {
+				signingKey = tt.key
+			}
+			assertion := signRS256(t, claims.build(t), signingKey, "k1")
+			form := assertionForm(assertion)
+			if tt.form != nil {
+				form = tt.form(assertion)
+			}
+			c, _ := newTokenContext(form)
+			got, err := a.Authenticate(c)
+			if !tt.ok {
+				if err != ErrInvalidClient {
+					t.Fatalf("err = %v, want ErrInvalidClient", err)
+				}
+				return
+			}
+			if err != nil || got.ClientID != "pkjwt" {
+				t.Fatalf("client = %v, err = %v", got, err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateAssertionReplay(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &model.Client{ID: uuid.New(), ClientID: "pkjwt", TokenEndpointAuthMethod: "private_key_jwt", JWKS: strPtr(publicJWKS(t, key, "k1")), Status: "active"}
+	a := newTestClientAuthenticator(client)
+
+	assertion := signRS256(t, validAssertionClaims("pkjwt").build(t), key, "k1")
+	c, _ := newTokenContext(assertionForm(assertion))
+	if _, err := a.Authenticate(c); err != nil {
+		t.Fatalf("first use: %v", err)
+	}
+	// 同じ jti のアサーションは 2 回目以降拒否する
+	c, _ = newTokenContext(assertionForm(assertion))
+	if _, err := a.Authenticate(c); err != ErrInvalidClient {
+		t.Fatalf("replay: err = %v, want ErrInvalidClient", err)
+	}
+}
+
+func TestAuthenticateClientSecretJWT(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), ClientID: "csjwt", ClientSecretEncrypted: strPtr("enc:shared-secret-0123456789abcdef"), TokenEndpointAuthMethod: "client_secret_jwt", Status: "active"}
+	a := newTestClientAuthenticator(client)
+
+	sign := func(secret string) string {
+		signed, err := jwt.Sign(validAssertionClaims("csjwt").build(t), jwt.WithKey(jwa.HS256(), []byte(secret)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(signed)
+	}
+
+	c, _ := newTokenContext(assertionForm(sign("shared-secret-0123456789abcdef")))
+	if got, err := a.Authenticate(c); err != nil || got.ClientID != "csjwt" {
+		t.Fatalf("client = %v, err = %v", got, err)
+	}
+	c, _ = newTokenContext(assertionForm(sign("wrong-secret-0123456789abcdefgh")))
+	if _, err := a.Authenticate(c); err != ErrInvalidClient {
+		t.Fatalf("wrong secret: err = %v, want ErrInvalidClient", err)
+	}
+}
+
+func TestAuthenticateTLSClientAuth(t *testing.T) {
+	root := issueTestCert(t, "Root CA", nil, true)
+	inter := issueTestCert(t, "Intermediate CA", root, true)
+	leaf := issueTestCert(t, "client.example.com", inter, false)
+	direct := issueTestCert(t, "direct.example.com", root, false)
+	untrustedRoot := issueTestCert(t, "Untrusted CA", nil, true)
+	untrusted := issueTestCert(t, "client.example.com", untrustedRoot, false)
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(root.cert)
+
+	newAuthenticator := func(client *model.Client) *ClientAuthenticator {
+		a := newTestClientAuthenticator(client)
+		a.clientCAs = clientCAs
+		return a
+	}
+	sanClient := func(dns string) *model.Client {
+		return &model.Client{ID: uuid.New(), ClientID: "mtls", TokenEndpointAuthMethod: "tls_client_auth", TLSClientAuthSANDNS: strPtr(dns), Status: "active"}
+	}
+
+	tests := []struct {
+		name   string
+		client *model.Client
+		chain  []*x509.Certificate
+		ok     bool
+	}{
+		{name: "中間 CA を含むチェーン", client: sanClient("client.example.com"), chain: []*x509.Certificate{leaf.cert, inter.cert}, ok: true},
+		{name: "中間 CA が提示されない", client: sanClient("client.example.com"), chain: []*x509.Certificate{leaf.cert}},
+		{name: "ルート CA が直接発行", client: sanClient("direct.example.com"), chain: []*x509.Certificate{direct.cert}, ok: true},
+		{name: "Subject DN が一致", client: &model.Client{ID: uuid.New(), ClientID: "mtls", TokenEndpointAuthMethod: "tls_client_auth", TLSClientAuthSubjectDN: strPtr(leaf.cert.Subject.String()), Status: "active"}, chain: []*x509.Certificate{leaf.cert, inter.cert}, ok: true},
+		{name: "SAN が一致しない", client: sanClient("other.example.com"), chain: []*x509.Certificate{leaf.cert, inter.cert}},
+		{name: "信頼されていない CA", client: sanClient("client.example.com"), chain: []*x509.Certificate{untrusted.cert, untrustedRoot.cert}},
+		// 提示された中間 CA 証明書は信頼の起点にならない
+		{name: "クライアント証明書だけのチェーン", client: sanClient("Intermediate CA"), chain: []*x509.Certificate{inter.cert}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := newAuthenticator(tt.client)
+			c, req := newTokenContext(url.Values{"client_id": {"mtls"}})
+			req.TLS = &tls.ConnectionState{PeerCertificates: tt.chain}
+			_, err := a.Authenticate(c)
+			if tt.ok && err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.ok && !errors.Is(err, ErrInvalidClient) {
+				t.Fatalf("err = %v, want ErrInvalidClient", err)
+			}
+		})
+	}
+}
+
+func TestAuthenticateSelfSignedTLSClientAuth(t *testing.T) {
+	self := issueTestCert(t, "self.example.com", nil, false)
+	other := issueTestCert(t, "other.example.com", nil, false)
+	pub, err := jwk.Import(&self.key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	set := jwk.NewSet()
+	_ = set.AddKey(pub)
+	raw, _ := json.Marshal(set)
+	client := &model.Client{ID: uuid.New(), ClientID: "self", TokenEndpointAuthMethod: "self_signed_tls_client_auth", JWKS: strPtr(string(raw)), Status: "active"}
+	a := newTestClientAuthenticator(client)
+
+	for _, tt := range []struct {
+		cert *x509.Certificate
+		ok   bool
+	}{{cert: self.cert, ok: true}, {cert: other.cert}} {
+		c, req := newTokenContext(url.Values{"client_id": {"self"}})
+		req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{tt.cert}}
+		_, err := a.Authenticate(c)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: err = %v, want ok = %v", tt.cert.Subject.CommonName, err, tt.ok)
+		}
+	}
+}
+
+// TestTLSClientAuthHandshake は実際の TLS 接続で中間 CA を含むチェーンを提示し、tls_client_auth で認証できることを確認する
+func TestTLSClientAuthHandshake(t *testing.T) {
+	root := issueTestCert(t, "Root CA", nil, true)
+	inter := issueTestCert(t, "Intermediate CA", root, true)
+	leaf := issueTestCert(t, "client.example.com", inter, false)
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(root.cert)
+
+	client := &model.Client{ID: uuid.New(), ClientID: "mtls", TokenEndpointAuthMethod: "tls_client_auth", TLSClientAuthSANDNS: strPtr("client.example.com"), Status: "active"}
+	a := newTestClientAuthenticator(client)
+	a.clientCAs = clientCAs
+
+	e := echo.New()
+	e.POST("/:tenant_code/token", func(c echo.Context) error {
+		if _, err := a.Authenticate(c); err != nil {
+			return c.String(http.StatusUnauthorized, err.Error())
+		}
+		return c.String(http.StatusOK, "ok")
+	})
+	srv := httptest.NewUnstartedServer(e)
+	srv.TLS = &tls.Config{ClientAuth: tls.RequestClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	post := func(chain ...*x509.Certificate) int {
+		t.Helper()
+		httpClient := srv.Client()
+		transport := httpClient.Transport.(*http.Transport).Clone()
+		var raw [][]byte
+		for _, c := range chain {
+			raw = append(raw, c.Raw)
+		}
+		transport.TLSClientConfig.Certificates = []tls.Certificate{{Certificate: raw, PrivateKey: leaf.key}}
+		httpClient.Transport = transport
+		res, err := httpClient.PostForm(srv.URL+"/demo/token", url.Values{"client_id": {"mtls"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		return res.StatusCode
+	}
+
+	if status := post(leaf.cert, inter.cert); status != http.StatusOK {
+		t.Errorf("with intermediate: status = %d, want 200", status)
+	}
+	if status := post(leaf.cert); status != http.StatusUnauthorized {
+		t.Errorf("without intermediate: status = %d, want 401", status)
+	}
+}
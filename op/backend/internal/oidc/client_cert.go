@@ -0,0 +1,124 @@
+package oidc
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ClientCertificateExtractor はリクエストからクライアント証明書を取り出す。
+// TLS 接続のピア証明書を優先し、なければ信頼済みプロキシが付与したヘッダを参照する。
+type ClientCertificateExtractor struct {
+	headerName     string
+	trustedProxies []*net.IPNet
+}
+
+func NewClientCertificateExtractor(headerName string, trustedProxyCIDRs []string) (*ClientCertificateExtractor, error) {
+	proxies := make([]*net.IPNet, 0, len(trustedProxyCIDRs))
+	for _, cidr := range trustedProxyCIDRs {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy CIDR %q: %w", cidr, err)
+		}
+		proxies = append(proxies, ipNet)
+	}
+	return &ClientCertificateExtractor{headerName: headerName, trustedProxies: proxies}, nil
+}
+
+// Extract はクライアント証明書を返す。証明書が提示されていない場合は (nil, nil)。
+func (e *ClientCertificateExtractor) Extract(c echo.Context) (*x509.Certificate, error) {
+	chain, err := e.ExtractChain(c)
+	if err != nil || len(chain) == 0 {
+		return nil, err
+	}
+	return chain[0], nil
+}
+
+// ExtractChain はクライアント証明書と、続けて提示された中間 CA 証明書を返す。先頭がクライアント証明書。
+// 証明書が提示されていない場合は (nil, nil)。
+func (e *ClientCertificateExtractor) ExtractChain(c echo.Context) ([]*x509.Certificate, error) {
+	req := c.Request()
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		return req.TLS.PeerCertificates, nil
+	}
+
+	if e.headerName == "" {
+		return nil, nil
+	}
+	value := req.Header.Get(e.headerName)
+	if value == "" {
+		return nil, nil
+	}
+
+	// ヘッダは偽装できるため、信頼済みプロキシからの接続に限って受け付ける
+	if !e.fromTrustedProxy(req.RemoteAddr) {
+		return nil, nil
+	}
+
+	return parseForwardedCertificates(value)
+}
+
+func (e *ClientCertificateExtractor) fromTrustedProxy(remoteAddr string) bool {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		host = remoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range e.trustedProxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseForwardedCertificates はプロキシが転送した証明書をパースする。
+// nginx の $ssl_client_escaped_cert (URL エンコード済み PEM) と、base64 DER の両方に対応する。
+// 中間 CA 証明書は PEM ブロックを続けるか、base64 DER をカンマ区切りで続けて渡す (先頭がクライアント証明書)
+func parseForwardedCertificates(value string) ([]*x509.Certificate, error) {
+	decoded, err := url.PathUnescape(value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unescape client certificate header: %w", err)
+	}
+
+	var chain []*x509.Certificate
+	if block, rest := pem.Decode([]byte(decoded)); block != nil {
+		for ; block != nil; block, rest = pem.Decode(rest) {
+			cert, err := x509.ParseCertificate(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			chain = append(chain, cert)
+		}
+		return chain, nil
+	}
+
+	for _, v := range strings.Split(decoded, ",") {
+		der, err := base64.StdEncoding.DecodeString(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("client certificate header is neither PEM nor base64 DER")
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		chain = append(chain, cert)
+	}
+	return chain, nil
+}
+
+// CertificateThumbprint は証明書の SHA-256 サムプリント (x5t#S256) を返す (RFC 8705 Section 3.1)
+func CertificateThumbprint(cert *x509.Certificate) string {
+	hash := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
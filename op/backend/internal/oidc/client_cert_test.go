@@ -0,0 +1,169 @@
+package oidc
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// testCert はテスト用に発行した証明書と秘密鍵
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// issueTestCert は parent で署名した証明書を発行する。parent が nil の場合は自己署名。
+// isCA の場合は CA 証明書、そうでなければクライアント認証用の証明書 (SAN DNS に cn を入れる)
+func issueTestCert(t *testing.T, cn string, parent *testCert, isCA bool) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, _ := rand.Int(rand.Reader, big.NewInt(1<<62))
+	tmpl := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: cn, Organization: []string{"Example"}},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if isCA {
+		tmpl.IsCA = true
+		tmpl.BasicConstraintsValid = true
+		tmpl.KeyUsage = x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature
+	} else {
+		tmpl.KeyUsage = x509.KeyUsageDigitalSignature
+		tmpl.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+		tmpl.DNSNames = []string{cn}
+	}
+	signerCert, signerKey := tmpl, key
+	if parent != nil {
+		signerCert, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signerCert, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+func certPEM(certs ...*x509.Certificate) string {
+	var b strings.Builder
+	for _, c := range certs {
+		_ = pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: c.Raw})
+	}
+	return b.String()
+}
+
+func TestParseForwardedCertificates(t *testing.T) {
+	root := issueTestCert(t, "Root CA", nil, true)
+	inter := issueTestCert(t, "Intermediate CA", root, true)
+	leaf := issueTestCert(t, "client.example.com", inter, false)
+
+	tests := []struct {
+		name  string
+		value string
+		want  []*x509.Certificate
+	}{
+		{name: "URL エンコード済み PEM", value: url.PathEscape(certPEM(leaf.cert)), want: []*x509.Certificate{leaf.cert}},
+		{name: "PEM のチェーン", value: url.PathEscape(certPEM(leaf.cert, inter.cert)), want: []*x509.Certificate{leaf.cert, inter.cert}},
+		{name: "base64 DER", value: base64.StdEncoding.EncodeToString(leaf.cert.Raw), want: []*x509.Certificate{leaf.cert}},
+		{name: "base64 DER のチェーン", value: base64.StdEncoding.EncodeToString(leaf.cert.Raw) + "," + base64.StdEncoding.EncodeToString(inter.cert.Raw), want: []*x509.Certificate{leaf.cert, inter.cert}},
+		{name: "不正な値", value: "not-a-certificate"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseForwardedCertificates(tt.value)
+			if tt.want == nil {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("len = %d, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !got[i].Equal(tt.want[i]) {
+					t.Errorf("certificate %d mismatch", i)
+				}
+			}
+		})
+	}
+}
+
+func TestExtractChainTrustedProxy(t *testing.T) {
+	root := issueTestCert(t, "Root CA", nil, true)
+	leaf := issueTestCert(t, "client.example.com", root, false)
+	e, err := NewClientCertificateExtractor("X-Client-Cert", []string{"10.0.0.0/8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		remoteAddr string
+		want       bool
+	}{
+		{remoteAddr: "10.1.2.3:4567", want: true},
+		{remoteAddr: "192.0.2.1:4567", want: false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/demo/token", nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("X-Client-Cert", url.PathEscape(certPEM(leaf.cert)))
+		chain, err := e.ExtractChain(echo.New().NewContext(req, httptest.NewRecorder()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := len(chain) == 1; got != tt.want {
+			t.Errorf("%s: extracted = %v, want %v", tt.remoteAddr, got, tt.want)
+		}
+	}
+}
+
+func TestCertificateBinding(t *testing.T) {
+	root := issueTestCert(t, "Root CA", nil, true)
+	leaf := issueTestCert(t, "client.example.com", root, false)
+	h := &TokenHandler{certExtractor: &ClientCertificateExtractor{}}
+
+	req := httptest.NewRequest(http.MethodPost, "/demo/token", nil)
+	req.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{leaf.cert}}
+	c := echo.New().NewContext(req, httptest.NewRecorder())
+
+	// バインドが無効なクライアントは証明書があっても空
+	if got, err := h.certificateBinding(c, &model.Client{}); err != nil || got != "" {
+		t.Fatalf("unbound: got %q, err %v", got, err)
+	}
+	bound := &model.Client{TLSClientCertificateBoundAccessTokens: true}
+	got, err := h.certificateBinding(c, bound)
+	if err != nil || got != CertificateThumbprint(leaf.cert) {
+		t.Fatalf("bound: got %q, err %v", got, err)
+	}
+	// 証明書なしでは発行できない
+	noCert := echo.New().NewContext(httptest.NewRequest(http.MethodPost, "/demo/token", nil), httptest.NewRecorder())
+	if _, err := h.certificateBinding(noCert, bound); err == nil {
+		t.Fatal("expected error without client certificate")
+	}
+}
@@ -0,0 +1,141 @@
+package oidc
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// ConsentHandler は OP Frontend の同意画面向けの内部 API を処理する。
+// ユーザーは op_session でログイン済みであることを前提とする。
+type ConsentHandler struct {
+	tenantFinder     TenantFinder
+	clientFinder     ClientFinder
+	scopeFinder      ScopeDefinitionFinder
+	consentStore     UserConsentStore
+	sessionValidator SessionValidator
+}
+
+func NewConsentHandler(
+	tenantFinder TenantFinder,
+	clientFinder ClientFinder,
+	scopeFinder ScopeDefinitionFinder,
+	consentStore UserConsentStore,
+	sessionValidator SessionValidator,
+) *ConsentHandler {
+	return &ConsentHandler{
+		tenantFinder:     tenantFinder,
+		clientFinder:     clientFinder,
+		scopeFinder:      scopeFinder,
+		consentStore:     consentStore,
+		sessionValidator: sessionValidator,
+	}
+}
+
+type consentRequest struct {
+	TenantCode string   `json:"tenant_code"`
+	ClientID   string   `json:"client_id"`
+	Scopes     []string `json:"scopes"`
+}
+
+// HandleConsent は POST /internal/consent を処理する。
+// 同意が必要なスコープへの同意を記録する。記録済みの同意には追加する。
+func (h *ConsentHandler) HandleConsent(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req consentRequest
+	if err := c.Bind(&req); err != nil || req.TenantCode == "" || req.ClientID == "" || len(req.Scopes) == 0 {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	session := sessionFromCookie(c, h.sessionValidator)
+	if session == nil {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "no_session"})
+	}
+	tenant, err := h.tenantFinder.FindByCode(ctx, req.TenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil || session.TenantID != tenant.ID {
+		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_session"})
+	}
+
+	client, err := h.clientFinder.FindByClientID(ctx, req.ClientID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if client == nil || client.Status != "active" || client.TenantID != tenant.ID {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_client"})
+	}
+
+	// 同意が必要と定義され、クライアントに許可されたスコープのみ記録する
+	defs, err := h.scopeFinder.ListByTenantID(ctx, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to list scope definitions: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	consentable := map[string]bool{}
+	for _, d := range defs {
+		if d.ConsentRequired && client.AllowsScope(d.Name) {
+			consentable[d.Name] = true
+		}
+	}
+	for _, s := range req.Scopes {
+		if !consentable[s] {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_scope"})
+		}
+	}
+
+	existing, err := h.consentStore.FindByUserAndClient(ctx, session.UserID, client.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	scopes := req.Scopes
+	if existing != nil {
+		scopes = append(scopes, existing.Scopes...)
+	}
+	if err := h.consentStore.Save(ctx, &model.UserConsent{
+		UserID:   session.UserID,
+		ClientID: client.ID,
+		Scopes:   model.StringSlice(uniqueSorted(scopes)),
+	}); err != nil {
+		c.Logger().Errorf("failed to save consent: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// sessionFromCookie は op_session Cookie のセッションを返す。ログインしていない場合は nil を返す。
+func sessionFromCookie(c echo.Context, validator SessionValidator) *model.Session {
+	cookie, err := c.Cookie("op_session")
+	if err != nil {
+		return nil
+	}
+	sessionID, err := uuid.Parse(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	session, err := validator.ValidateSession(c.Request().Context(), sessionID)
+	if err != nil {
+		return nil
+	}
+	return session
+}
+
+func uniqueSorted(values []string) []string {
+	seen := map[string]bool{}
+	var result []string
+	for _, v := range values {
+		if !seen[v] {
+			seen[v] = true
+			result = append(result, v)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
@@ -20,6 +20,12 @@ type ClientFinder interface {
 	FindByClientIDWithRedirectURIs(ctx context.Context, clientID string) (*model.Client, error)
 }
 
+// ClientSecretRehashStore はクライアント認証で検証した client_secret のハッシュを置き換える
+type ClientSecretRehashStore interface {
+	// RehashSecret は client_secret_hash が from のままの場合に to で置き換える。置き換えなかった場合は false を返す
+	RehashSecret(ctx context.Context, id uuid.UUID, from, to string) (bool, error)
+}
+
 type AuthorizationCodeStore interface {
 	Create(ctx context.Context, code *model.AuthorizationCode) error
 	FindByCode(ctx context.Context, code string) (*model.AuthorizationCode, error)
@@ -41,14 +47,85 @@ type RefreshTokenStore interface {
 	MarkReuseDetected(ctx context.Context, id uuid.UUID) error
 }
 
+type ClientAssertionJTIStore interface {
+	Register(ctx context.Context, clientID uuid.UUID, jti string, expiresAt time.Time) (bool, error)
+}
+
+type DeviceCodeStore interface {
+	Create(ctx context.Context, dc *model.DeviceCode) error
+	FindByDeviceCodeHash(ctx context.Context, hash string) (*model.DeviceCode, error)
+	FindByUserCode(ctx context.Context, userCode string) (*model.DeviceCode, error)
+	Decide(ctx context.Context, id uuid.UUID, sessionID uuid.UUID, approved bool) (bool, error)
+	UpdatePolling(ctx context.Context, id uuid.UUID, polledAt time.Time, interval int) error
+	MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error)
+	RecordUserCodeFailure(ctx context.Context, sessionID uuid.UUID, ipAddress string) error
+	CountUserCodeFailures(ctx context.Context, sessionID uuid.UUID, ipAddress string, since time.Time) (int64, error)
+}
+
+type BackchannelAuthRequestStore interface {
+	Create(ctx context.Context, req *model.BackchannelAuthRequest) error
+	FindByAuthReqIDHash(ctx context.Context, hash string) (*model.BackchannelAuthRequest, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*model.BackchannelAuthRequest, error)
+	ListPendingByUserID(ctx context.Context, userID uuid.UUID) ([]model.BackchannelAuthRequest, error)
+	Decide(ctx context.Context, id uuid.UUID, sessionID uuid.UUID, approved bool) (bool, error)
+	UpdatePolling(ctx context.Context, id uuid.UUID, polledAt time.Time, interval int) error
+	MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+// AuthenticationDeviceNotifier は CIBA の承認依頼をユーザーの認証デバイスへ届ける (CIBA Core 1.0 Section 7.1)。
+// プッシュ通知・SMS 等の配信手段はこのインターフェースの実装で差し替える
+type AuthenticationDeviceNotifier interface {
+	NotifyAuthenticationRequest(ctx context.Context, n *model.AuthenticationDeviceNotification) error
+}
+
+type APIResourceFinder interface {
+	FindByIdentifier(ctx context.Context, tenantID uuid.UUID, identifier string) (*model.APIResource, error)
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.APIResource, error)
+}
+
+type ScopeDefinitionFinder interface {
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.ScopeDefinition, error)
+}
+
+type UserConsentStore interface {
+	FindByUserAndClient(ctx context.Context, userID, clientID uuid.UUID) (*model.UserConsent, error)
+	Save(ctx context.Context, consent *model.UserConsent) error
+}
+
+type AuthorizationDetailTypeFinder interface {
+	FindByType(ctx context.Context, tenantID uuid.UUID, detailType string) (*model.AuthorizationDetailType, error)
+	ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.AuthorizationDetailType, error)
+}
+
+type AuthorizationDetailsApprovalStore interface {
+	Create(ctx context.Context, approval *model.AuthorizationDetailsApproval) error
+	FindByID(ctx context.Context, id uuid.UUID) (*model.AuthorizationDetailsApproval, error)
+	FindLatest(ctx context.Context, sessionID, clientID uuid.UUID, detailsHash string) (*model.AuthorizationDetailsApproval, error)
+	Decide(ctx context.Context, id uuid.UUID, approved bool) (bool, error)
+	MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error)
+}
+
+type PairwiseSubjectStore interface {
+	Save(ctx context.Context, subject *model.PairwiseSubject) error
+	FindBySubject(ctx context.Context, tenantID uuid.UUID, subject string) (*model.PairwiseSubject, error)
+}
+
 type IDTokenCreator interface {
 	Create(ctx context.Context, token *model.IDToken) error
 }
 
+type IDTokenFinder interface {
+	FindByJTI(ctx context.Context, jti string) (*model.IDToken, error)
+}
+
 type UserFinder interface {
 	FindByID(ctx context.Context, id uuid.UUID) (*model.User, error)
 }
 
+type UserLoginIDFinder interface {
+	FindByTenantAndLoginID(ctx context.Context, tenantID uuid.UUID, loginID string) (*model.User, error)
+}
+
 type SessionValidator interface {
 	ValidateSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error)
 }
@@ -63,13 +140,27 @@ type TokenSigner interface {
 	GenerateRefreshToken() (token string, tokenHash string, err error)
 }
 
+type UserInfoSigner interface {
+	SignUserInfo(ctx context.Context, issuer, audience string, claims map[string]interface{}) (string, error)
+}
+
+type AuthorizationResponseSigner interface {
+	SignAuthorizationResponse(ctx context.Context, issuer, audience string, params map[string]interface{}, lifetime time.Duration) (string, error)
+}
+
 type TokenValidator interface {
 	ValidateAccessToken(ctx context.Context, tokenString string) (*model.AccessTokenResult, error)
+	ValidateIDToken(ctx context.Context, tokenString string) (*model.IDTokenResult, error)
 }
 
 type (
-	VerifyPasswordFunc      func(password, hash string) (bool, error)
+	VerifyPasswordFunc      func(ctx context.Context, password, hash string) (bool, error)
+	HashPasswordFunc        func(ctx context.Context, password string) (string, error)
+	PasswordNeedsRehashFunc func(hash string) bool
 	VerifyCodeChallengeFunc func(verifier, challenge string) bool
 	ComputeATHashFunc       func(accessToken string) string
 	SHA256HexFunc           func(s string) string
+	EncryptSecretFunc       func(plaintext string) (string, error)
+	DecryptSecretFunc       func(encrypted string) (string, error)
+	EncryptJWTFunc          func(rawJWKS, alg, enc string, payload []byte, contentType string) (string, error)
 )
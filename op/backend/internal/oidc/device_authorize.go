@@ -0,0 +1,238 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"math/big"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// deviceCodeGrantType はデバイスコードグラントの grant_type (RFC 8628 Section 3.4)
+	deviceCodeGrantType = "urn:ietf:params:oauth:grant-type:device_code"
+	// deviceCodeLifetime は device_code / user_code の有効期間
+	deviceCodeLifetime = 10 * time.Minute
+	// devicePollInterval はポーリング間隔の初期値（秒）。省略時のデフォルトと同じ (RFC 8628 Section 3.2)
+	devicePollInterval = 5
+	// userCodeCharset は user_code に使う文字集合。母音と紛らわしい文字を除いた 20 文字 (RFC 8628 Section 6.1)
+	userCodeCharset = "BCDFGHJKLMNPQRSTVWXZ"
+	// userCodeLength は user_code の長さ。20^8 ≒ 2^34.5 のエントロピー
+	userCodeLength = 8
+)
+
+type DeviceAuthorizationHandler struct {
+	tenantFinder        TenantFinder
+	clientAuthenticator *ClientAuthenticator
+	deviceCodeStore     DeviceCodeStore
+	scopeFinder         ScopeDefinitionFinder
+	apiResourceFinder   APIResourceFinder
+	sessionValidator    SessionValidator
+	sha256Hex           SHA256HexFunc
+	issuerBaseURL       string
+	frontendBaseURL     string
+}
+
+func NewDeviceAuthorizationHandler(
+	tenantFinder TenantFinder,
+	clientAuthenticator *ClientAuthenticator,
+	deviceCodeStore DeviceCodeStore,
+	scopeFinder ScopeDefinitionFinder,
+	apiResourceFinder APIResourceFinder,
+	sessionValidator SessionValidator,
+	sha256Hex SHA256HexFunc,
+	issuerBaseURL string,
+	frontendBaseURL string,
+) *DeviceAuthorizationHandler {
+	return &DeviceAuthorizationHandler{
+		tenantFinder:        tenantFinder,
+		clientAuthenticator: clientAuthenticator,
+		deviceCodeStore:     deviceCodeStore,
+		scopeFinder:         scopeFinder,
+		apiResourceFinder:   apiResourceFinder,
+		sessionValidator:    sessionValidator,
+		sha256Hex:           sha256Hex,
+		issuerBaseURL:       issuerBaseURL,
+		frontendBaseURL:     frontendBaseURL,
+	}
+}
+
+// DeviceAuthorizationResponse はデバイス認可レスポンス (RFC 8628 Section 3.2)
+type DeviceAuthorizationResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// HandleAuthorize は POST /{tenant_code}/device/authorize を処理する
+// 仕様参照: RFC 8628 Section 3.1, 3.2
+func (h *DeviceAuthorizationHandler) HandleAuthorize(c echo.Context) error {
+	ctx := c.Request().Context()
+	c.Response().Header().Set("Cache-Control", "no-store")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, c.Param("tenant_code"))
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	// クライアント認証 (公開クライアントは client_id のみ)
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
+	}
+	if client.TenantID != tenant.ID {
+		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client does not belong to this tenant")
+	}
+	if !client.HasGrantType(deviceCodeGrantType) {
+		return tokenError(c, http.StatusBadRequest, "unauthorized_client", "client does not support device_code grant")
+	}
+
+	// スコープはテナントで利用でき、クライアントに許可されたもののみ受け付ける (RFC 8628 Section 3.1)
+	scope := c.FormValue("scope")
+	availableScopes, err := loadTenantScopes(ctx, h.scopeFinder, h.apiResourceFinder, tenant.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to load tenant scopes: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+	if err := availableScopes.validate(client, strings.Fields(scope)); err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_scope", err.Error())
+	}
+
+	deviceCode, err := generateDeviceCode()
+	if err != nil {
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+	userCode, err := generateUserCode()
+	if err != nil {
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	dc := &model.DeviceCode{
+		ClientID:       client.ID,
+		DeviceCodeHash: h.sha256Hex(deviceCode),
+		UserCode:       userCode,
+		Scope:          scope,
+		Status:         model.DeviceCodeStatusPending,
+		PollInterval:   devicePollInterval,
+		ExpiresAt:      time.Now().Add(deviceCodeLifetime),
+	}
+	if err := h.deviceCodeStore.Create(ctx, dc); err != nil {
+		c.Logger().Errorf("failed to create device code: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	verificationURI := h.issuerBaseURL + "/" + tenant.Code + "/device"
+	displayCode := formatUserCode(userCode)
+
+	return c.JSON(http.StatusOK, DeviceAuthorizationResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                displayCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + url.QueryEscape(displayCode),
+		ExpiresIn:               int(deviceCodeLifetime.Seconds()),
+		Interval:                devicePollInterval,
+	})
+}
+
+// HandleVerification は GET /{tenant_code}/device を処理する。
+// ユーザーが入力する verification_uri。ログイン済みであれば OP Frontend の検証画面へ、未ログインであればログイン画面へリダイレクトする。
+// 仕様参照: RFC 8628 Section 3.3
+func (h *DeviceAuthorizationHandler) HandleVerification(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	// 既存のログインセッション (op_session) を再利用する
+	var hasSession bool
+	if cookie, err := c.Cookie("op_session"); err == nil {
+		if sid, err := uuid.Parse(cookie.Value); err == nil {
+			session, err := h.sessionValidator.ValidateSession(ctx, sid)
+			hasSession = err == nil && session != nil && session.TenantID == tenant.ID
+		}
+	}
+
+	if !hasSession {
+		loginURL, err := url.Parse(h.frontendBaseURL + "/login")
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		q := loginURL.Query()
+		q.Set("tenant_code", tenantCode)
+		q.Set("redirect_after_login", c.Request().URL.String())
+		loginURL.RawQuery = q.Encode()
+		return c.Redirect(http.StatusFound, loginURL.String())
+	}
+
+	verifyURL, err := url.Parse(h.frontendBaseURL + "/device")
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := verifyURL.Query()
+	q.Set("tenant_code", tenantCode)
+	if userCode := c.QueryParam("user_code"); userCode != "" {
+		q.Set("user_code", userCode)
+	}
+	verifyURL.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, verifyURL.String())
+}
+
+func generateDeviceCode() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// generateUserCode は userCodeCharset から一様ランダムに user_code を生成する
+func generateUserCode() (string, error) {
+	charsetLen := big.NewInt(int64(len(userCodeCharset)))
+	var sb strings.Builder
+	for i := 0; i < userCodeLength; i++ {
+		n, err := rand.Int(rand.Reader, charsetLen)
+		if err != nil {
+			return "", err
+		}
+		sb.WriteByte(userCodeCharset[n.Int64()])
+	}
+	return sb.String(), nil
+}
+
+// formatUserCode は表示用に4文字ごとにハイフンで区切る (例: BDWP-HQPK)
+func formatUserCode(code string) string {
+	return code[:4] + "-" + code[4:]
+}
+
+// normalizeUserCode はユーザー入力を保存形式に正規化する。
+// 大文字小文字・ハイフン・空白の違いを許容する (SHOULD: RFC 8628 Section 6.1)
+func normalizeUserCode(input string) string {
+	var sb strings.Builder
+	for _, r := range strings.ToUpper(input) {
+		if r == '-' || unicode.IsSpace(r) {
+			continue
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
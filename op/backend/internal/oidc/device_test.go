@@ -0,0 +1,384 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// fakeDeviceCodeStore は device_codes と入力失敗の記録をメモリに持つ
+type fakeDeviceCodeStore struct {
+	codes    map[uuid.UUID]*model.DeviceCode
+	failures int64
+}
+
+func newFakeDeviceCodeStore(codes ...*model.DeviceCode) *fakeDeviceCodeStore {
+	f := &fakeDeviceCodeStore{codes: map[uuid.UUID]*model.DeviceCode{}}
+	for _, dc := range codes {
+		f.codes[dc.ID] = dc
+	}
+	return f
+}
+
+func (f *fakeDeviceCodeStore) Create(_ context.Context, dc *model.DeviceCode) error {
+	dc.ID = uuid.New()
+	f.codes[dc.ID] = dc
+	return nil
+}
+
+func (f *fakeDeviceCodeStore) FindByDeviceCodeHash(_ context.Context, hash string) (*model.DeviceCode, error) {
+	for _, dc := range f.codes {
+		if dc.DeviceCodeHash == hash {
+			copied := *dc
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDeviceCodeStore) FindByUserCode(_ context.Context, userCode string) (*model.DeviceCode, error) {
+	for _, dc := range f.codes {
+		if dc.UserCode == userCode {
+			copied := *dc
+			return &copied, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDeviceCodeStore) Decide(_ context.Context, id, sessionID uuid.UUID, approved bool) (bool, error) {
+	dc := f.codes[id]
+	if dc == nil || dc.Status != model.DeviceCodeStatusPending || dc.IsExpired() {
+		return false, nil
+	}
+	dc.SessionID = &sessionID
+	dc.Status = model.DeviceCodeStatusDenied
+	if approved {
+		dc.Status = model.DeviceCodeStatusApproved
+	}
+	return true, nil
+}
+
+func (f *fakeDeviceCodeStore) UpdatePolling(_ context.Context, id uuid.UUID, polledAt time.Time, interval int) error {
+	f.codes[id].LastPolledAt = &polledAt
+	f.codes[id].PollInterval = interval
+	return nil
+}
+
+func (f *fakeDeviceCodeStore) MarkConsumed(_ context.Context, id uuid.UUID) (bool, error) {
+	dc := f.codes[id]
+	if dc.Status != model.DeviceCodeStatusApproved {
+		return false, nil
+	}
+	dc.Status = model.DeviceCodeStatusConsumed
+	return true, nil
+}
+
+func (f *fakeDeviceCodeStore) RecordUserCodeFailure(context.Context, uuid.UUID, string) error {
+	f.failures++
+	return nil
+}
+
+func (f *fakeDeviceCodeStore) CountUserCodeFailures(context.Context, uuid.UUID, string, time.Time) (int64, error) {
+	return f.failures, nil
+}
+
+func fakeSHA256Hex(s string) string { return "sha:" + s }
+
+func TestGenerateUserCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := generateUserCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(code) != userCodeLength {
+			t.Fatalf("len(%q) = %d", code, len(code))
+		}
+		for _, r := range code {
+			if !strings.ContainsRune(userCodeCharset, r) {
+				t.Fatalf("%q contains %q outside the charset", code, r)
+			}
+		}
+		seen[code] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("generated duplicate user codes: %d unique of 100", len(seen))
+	}
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for input, want := range map[string]string{
+		"BDWP-HQPK":   "BDWPHQPK",
+		"bdwp-hqpk":   "BDWPHQPK",
+		" bdwp hqpk ": "BDWPHQPK",
+		"BDWPHQPK":    "BDWPHQPK",
+	} {
+		if got := normalizeUserCode(input); got != want {
+			t.Errorf("normalizeUserCode(%q) = %q, want %q", input, got, want)
+		}
+	}
+	if got := formatUserCode("BDWPHQPK"); got != "BDWP-HQPK" {
+		t.Errorf("formatUserCode = %q", got)
+	}
+}
+
+func TestDeviceCodeGrantStates(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{deviceCodeGrantType}}
+	otherClient := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{deviceCodeGrantType}}
+	revoked := time.Now()
+
+	tests := []struct {
+		name   string
+		client *model.Client
+		dc     model.DeviceCode
+		want   error
+		// wantInterval はポーリング後の間隔 (0 は確認しない)
+		wantInterval int
+	}{
+		{name: "承認待ち", dc: model.DeviceCode{Status: model.DeviceCodeStatusPending}, want: ErrAuthorizationPending, wantInterval: devicePollInterval},
+		{name: "間隔より早いポーリング", dc: model.DeviceCode{Status: model.DeviceCodeStatusPending, LastPolledAt: ptrTime(time.Now())}, want: ErrSlowDown, wantInterval: devicePollInterval + deviceSlowDownIncrement},
+		{name: "間隔を空けたポーリング", dc: model.DeviceCode{Status: model.DeviceCodeStatusPending, LastPolledAt: ptrTime(time.Now().Add(-10 * time.Second))}, want: ErrAuthorizationPending, wantInterval: devicePollInterval},
+		{name: "拒否", dc: model.DeviceCode{Status: model.DeviceCodeStatusDenied}, want: ErrAccessDenied},
+		{name: "期限切れ", dc: model.DeviceCode{Status: model.DeviceCodeStatusApproved, ExpiresAt: time.Now().Add(-time.Second)}, want: ErrExpiredToken},
+		{name: "発行済み", dc: model.DeviceCode{Status: model.DeviceCodeStatusConsumed}, want: ErrInvalidGrant},
+		{name: "別のクライアント", client: otherClient, dc: model.DeviceCode{Status: model.DeviceCodeStatusApproved}, want: ErrInvalidGrant},
+		{name: "承認後に失効したセッション", dc: model.DeviceCode{Status: model.DeviceCodeStatusApproved, Session: &model.Session{RevokedAt: &revoked, ExpiresAt: time.Now().Add(time.Hour)}}, want: ErrInvalidGrant},
+		{name: "device_code グラントを許可されていないクライアント", client: &model.Client{ID: client.ID}, dc: model.DeviceCode{Status: model.DeviceCodeStatusApproved}, want: ErrUnauthorizedClient},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dc := tt.dc
+			dc.ID = uuid.New()
+			dc.ClientID = client.ID
+			dc.DeviceCodeHash = fakeSHA256Hex("device-code")
+			if dc.ExpiresAt.IsZero() {
+				dc.ExpiresAt = time.Now().Add(time.Minute)
+			}
+			if dc.PollInterval == 0 {
+				dc.PollInterval = devicePollInterval
+			}
+			store := newFakeDeviceCodeStore(&dc)
+			h := &TokenHandler{deviceCodeStore: store, sha256Hex: fakeSHA256Hex}
+
+			requester := client
+			if tt.client != nil {
+				requester = tt.client
+			}
+			_, err := h.handleDeviceCodeGrantLogic(context.Background(), &DeviceCodeGrantInput{Client: requester, DeviceCode: "device-code"})
+			if !errors.Is(err, tt.want) {
+				t.Fatalf("err = %v, want %v", err, tt.want)
+			}
+			if tt.wantInterval != 0 && store.codes[dc.ID].PollInterval != tt.wantInterval {
+				t.Errorf("interval = %d, want %d", store.codes[dc.ID].PollInterval, tt.wantInterval)
+			}
+		})
+	}
+}
+
+func TestDeviceCodeGrantUnknownCode(t *testing.T) {
+	client := &model.Client{ID: uuid.New(), GrantTypes: model.StringSlice{deviceCodeGrantType}}
+	h := &TokenHandler{deviceCodeStore: newFakeDeviceCodeStore(), sha256Hex: fakeSHA256Hex}
+	if _, err := h.handleDeviceCodeGrantLogic(context.Background(), &DeviceCodeGrantInput{Client: client, DeviceCode: "unknown"}); !errors.Is(err, ErrInvalidGrant) {
+		t.Fatalf("err = %v, want ErrInvalidGrant", err)
+	}
+}
+
+// deviceVerificationFixture はログイン済みのセッションと承認待ちのデバイス認可リクエストを用意する
+type deviceVerificationFixture struct {
+	handler  *DeviceVerificationHandler
+	store    *fakeDeviceCodeStore
+	consents *fakeUserConsentStore
+	session  *model.Session
+	dc       *model.DeviceCode
+}
+
+func newDeviceVerificationFixture() *deviceVerificationFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	session := newTestSession(tenant)
+	dc := &model.DeviceCode{
+		ID:        uuid.New(),
+		UserCode:  "BDWPHQPK",
+		Scope:     "openid",
+		Status:    model.DeviceCodeStatusPending,
+		ExpiresAt: time.Now().Add(time.Minute),
+		Client:    model.Client{ID: uuid.New(), TenantID: tenant.ID, Name: "TV"},
+	}
+	store := newFakeDeviceCodeStore(dc)
+	consents := &fakeUserConsentStore{consents: map[uuid.UUID]*model.UserConsent{}}
+	h := NewDeviceVerificationHandler(
+		newFakeTenantFinder(tenant),
+		store,
+		&fakeScopeDefinitionFinder{defs: []model.ScopeDefinition{{TenantID: tenant.ID, Name: "payments", Description: "支払い", ConsentRequired: true}}},
+		&fakeAPIResourceFinder{},
+		consents,
+		newFakeSessionValidator(session),
+	)
+	return &deviceVerificationFixture{handler: h, store: store, consents: consents, session: session, dc: dc}
+}
+
+func (f *deviceVerificationFixture) post(handle func(echo.Context) error, body map[string]interface{}) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	req := httptest.NewRequest(http.MethodPost, "/internal/device/verify", strings.NewReader(string(raw)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	req.AddCookie(&http.Cookie{Name: "op_session", Value: f.session.ID.String()})
+	rec := httptest.NewRecorder()
+	if err := handle(echo.New().NewContext(req, rec)); err != nil {
+		panic(err)
+	}
+	return rec
+}
+
+func TestDeviceVerificationDecide(t *testing.T) {
+	f := newDeviceVerificationFixture()
+	rec := f.post(f.handler.HandleVerify, map[string]interface{}{"tenant_code": "demo", "user_code": "bdwp-hqpk"})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"client_name":"TV"`) {
+		t.Fatalf("verify: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	rec = f.post(f.handler.HandleDecide, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK", "approved": true})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("decide: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	if f.dc.Status != model.DeviceCodeStatusApproved || f.dc.SessionID == nil || *f.dc.SessionID != f.session.ID {
+		t.Errorf("device code not approved by session: %+v", f.dc)
+	}
+
+	// 決定済みの user_code は再び使えない
+	rec = f.post(f.handler.HandleDecide, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK", "approved": false})
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("second decide: status = %d, want 400", rec.Code)
+	}
+}
+
+func TestDeviceVerificationConsent(t *testing.T) {
+	f := newDeviceVerificationFixture()
+	f.dc.Scope = "openid payments"
+
+	// 同意が必要なスコープを確認画面に示す
+	rec := f.post(f.handler.HandleVerify, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK"})
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"consent_scopes":[{"name":"payments","description":"支払い"}]`) {
+		t.Fatalf("verify: status = %d, body = %s", rec.Code, rec.Body)
+	}
+
+	// 承認すると同意を記録し、以降は同意済みとして示さない
+	if rec := f.post(f.handler.HandleDecide, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK", "approved": true}); rec.Code != http.StatusOK {
+		t.Fatalf("decide: status = %d, body = %s", rec.Code, rec.Body)
+	}
+	consent := f.consents.consents[f.dc.Client.ID]
+	if consent == nil || consent.UserID != f.session.UserID || !containsScope(consent.Scopes, "payments") {
+		t.Errorf("consent = %+v", consent)
+	}
+	f.dc.Status = model.DeviceCodeStatusPending
+	if rec := f.post(f.handler.HandleVerify, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK"}); !strings.Contains(rec.Body.String(), `"consent_scopes":[]`) {
+		t.Errorf("verify after consent: body = %s", rec.Body)
+	}
+
+	t.Run("リクエスト後にクライアントに許可されなくなったスコープは承認できない", func(t *testing.T) {
+		f := newDeviceVerificationFixture()
+		f.dc.Scope = "openid payments"
+		f.dc.Client.AllowedScopes = model.StringSlice{"openid"}
+		rec := f.post(f.handler.HandleDecide, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK", "approved": true})
+		if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_scope") || f.dc.Status != model.DeviceCodeStatusPending || len(f.consents.consents) != 0 {
+			t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+	})
+}
+
+func TestDeviceAuthorizationScope(t *testing.T) {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo"}
+	client := &model.Client{
+		ID:                      uuid.New(),
+		TenantID:                tenant.ID,
+		ClientID:                "tv",
+		Status:                  "active",
+		TokenEndpointAuthMethod: "none",
+		GrantTypes:              model.StringSlice{deviceCodeGrantType},
+		AllowedScopes:           model.StringSlice{"openid", "profile"},
+	}
+	store := newFakeDeviceCodeStore()
+	h := NewDeviceAuthorizationHandler(
+		newFakeTenantFinder(tenant),
+		newTestClientAuthenticator(client),
+		store,
+		&fakeScopeDefinitionFinder{},
+		&fakeAPIResourceFinder{},
+		newFakeSessionValidator(),
+		fakeSHA256Hex,
+		testIssuerBaseURL,
+		"https://login.example.com",
+	)
+
+	tests := []struct {
+		name     string
+		scope    string
+		wantCode int
+	}{
+		{name: "許可されたスコープ", scope: "openid profile", wantCode: http.StatusOK},
+		{name: "クライアントに許可されていないスコープ", scope: "openid email", wantCode: http.StatusBadRequest},
+		{name: "テナントにないスコープ", scope: "openid unknown", wantCode: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{"client_id": {"tv"}, "scope": {tt.scope}}
+			req := httptest.NewRequest(http.MethodPost, "/demo/device/authorize", strings.NewReader(form.Encode()))
+			req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationForm)
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(req, rec)
+			c.SetParamNames("tenant_code")
+			c.SetParamValues("demo")
+			if err := h.HandleAuthorize(c); err != nil {
+				t.Fatal(err)
+			}
+			if rec.Code != tt.wantCode {
+				t.Errorf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			if rec.Code != http.StatusOK && !strings.Contains(rec.Body.String(), "invalid_scope") {
+				t.Errorf("body = %s", rec.Body)
+			}
+		})
+	}
+	if len(store.codes) != 1 {
+		t.Errorf("stored %d device codes", len(store.codes))
+	}
+}
+
+func TestDeviceVerificationBruteForce(t *testing.T) {
+	f := newDeviceVerificationFixture()
+	for i := 0; i < userCodeMaxFailures; i++ {
+		rec := f.post(f.handler.HandleVerify, map[string]interface{}{"tenant_code": "demo", "user_code": "XXXX-XXXX"})
+		if rec.Code != http.StatusBadRequest {
+			t.Fatalf("attempt %d: status = %d, want 400", i, rec.Code)
+		}
+	}
+	// 上限に達したら正しい user_code でも照合しない
+	rec := f.post(f.handler.HandleVerify, map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK"})
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("status = %d, want 429", rec.Code)
+	}
+}
+
+func TestDeviceVerificationRequiresSession(t *testing.T) {
+	f := newDeviceVerificationFixture()
+	raw, _ := json.Marshal(map[string]interface{}{"tenant_code": "demo", "user_code": "BDWP-HQPK"})
+	req := httptest.NewRequest(http.MethodPost, "/internal/device/verify", strings.NewReader(string(raw)))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	if err := f.handler.HandleVerify(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want 401", rec.Code)
+	}
+}
@@ -0,0 +1,205 @@
+package oidc
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// userCodeMaxFailures は userCodeFailureWindow 内に許容する user_code の入力失敗回数 (RFC 8628 Section 5.1)
+	userCodeMaxFailures   = 5
+	userCodeFailureWindow = 15 * time.Minute
+)
+
+// DeviceVerificationHandler は OP Frontend のデバイス検証画面向けの内部 API を処理する。
+// ユーザーは既存の op_session でログイン済みであることを前提とする。
+type DeviceVerificationHandler struct {
+	tenantFinder      TenantFinder
+	deviceCodeStore   DeviceCodeStore
+	scopeFinder       ScopeDefinitionFinder
+	apiResourceFinder APIResourceFinder
+	consentStore      UserConsentStore
+	sessionValidator  SessionValidator
+}
+
+func NewDeviceVerificationHandler(
+	tenantFinder TenantFinder,
+	deviceCodeStore DeviceCodeStore,
+	scopeFinder ScopeDefinitionFinder,
+	apiResourceFinder APIResourceFinder,
+	consentStore UserConsentStore,
+	sessionValidator SessionValidator,
+) *DeviceVerificationHandler {
+	return &DeviceVerificationHandler{
+		tenantFinder:      tenantFinder,
+		deviceCodeStore:   deviceCodeStore,
+		scopeFinder:       scopeFinder,
+		apiResourceFinder: apiResourceFinder,
+		consentStore:      consentStore,
+		sessionValidator:  sessionValidator,
+	}
+}
+
+type deviceVerifyRequest struct {
+	TenantCode string `json:"tenant_code"`
+	UserCode   string `json:"user_code"`
+}
+
+type deviceDecideRequest struct {
+	TenantCode string `json:"tenant_code"`
+	UserCode   string `json:"user_code"`
+	Approved   bool   `json:"approved"`
+}
+
+// deviceVerifyError は内部 API のエラーレスポンス
+type deviceVerifyError struct {
+	status  int
+	errCode string
+}
+
+// HandleVerify は POST /internal/device/verify を処理する。
+// user_code に対応する認可リクエストの内容 (クライアント名・スコープ・同意が必要なスコープ) を返し、同意画面の表示に使う。
+func (h *DeviceVerificationHandler) HandleVerify(c echo.Context) error {
+	var req deviceVerifyRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	session, dc, verr := h.resolve(c, req.TenantCode, req.UserCode)
+	if verr != nil {
+		return c.JSON(verr.status, map[string]string{"error": verr.errCode})
+	}
+	pending, _, verr := h.consent(c, session, dc)
+	if verr != nil {
+		return c.JSON(verr.status, map[string]string{"error": verr.errCode})
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"user_code":      formatUserCode(dc.UserCode),
+		"client_name":    dc.Client.Name,
+		"scope":          dc.Scope,
+		"consent_scopes": consentScopes(pending),
+	})
+}
+
+// HandleDecide は POST /internal/device/decide を処理する。
+// ユーザーの承認・拒否を記録する。承認した場合はデバイス側のポーリングでトークンが発行される。
+// 承認は画面に示した同意が必要なスコープへの同意を兼ね、同意として記録する (OIDC Core 1.0 Section 3.1.2.4)。
+func (h *DeviceVerificationHandler) HandleDecide(c echo.Context) error {
+	var req deviceDecideRequest
+	if err := c.Bind(&req); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request"})
+	}
+
+	session, dc, verr := h.resolve(c, req.TenantCode, req.UserCode)
+	if verr != nil {
+		return c.JSON(verr.status, map[string]string{"error": verr.errCode})
+	}
+	if req.Approved {
+		pending, consent, verr := h.consent(c, session, dc)
+		if verr != nil {
+			return c.JSON(verr.status, map[string]string{"error": verr.errCode})
+		}
+		if err := saveConsent(c.Request().Context(), h.consentStore, session.UserID, &dc.Client, pending, consent); err != nil {
+			c.Logger().Errorf("failed to save consent: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+	}
+
+	ok, err := h.deviceCodeStore.Decide(c.Request().Context(), dc.ID, session.ID, req.Approved)
+	if err != nil {
+		c.Logger().Errorf("failed to decide device code: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if !ok {
+		// 同時に別の画面で処理された、または期限切れになった
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_user_code"})
+	}
+
+	status := model.DeviceCodeStatusDenied
+	if req.Approved {
+		status = model.DeviceCodeStatusApproved
+	}
+	return c.JSON(http.StatusOK, map[string]string{"status": status})
+}
+
+// resolve はセッションを検証し、user_code に対応する承認待ちの認可リクエストを返す。
+// 入力失敗はセッションと IP アドレスごとに記録し、上限を超えた場合は受け付けない (総当たり対策)。
+func (h *DeviceVerificationHandler) resolve(c echo.Context, tenantCode, userCode string) (*model.Session, *model.DeviceCode, *deviceVerifyError) {
+	ctx := c.Request().Context()
+	serverErr := &deviceVerifyError{status: http.StatusInternalServerError, errCode: "server_error"}
+
+	if tenantCode == "" || userCode == "" {
+		return nil, nil, &deviceVerifyError{status: http.StatusBadRequest, errCode: "invalid_request"}
+	}
+
+	// セッション確認
+	cookie, err := c.Cookie("op_session")
+	if err != nil {
+		return nil, nil, &deviceVerifyError{status: http.StatusUnauthorized, errCode: "no_session"}
+	}
+	sessionID, err := uuid.Parse(cookie.Value)
+	if err != nil {
+		return nil, nil, &deviceVerifyError{status: http.StatusUnauthorized, errCode: "invalid_session"}
+	}
+	session, err := h.sessionValidator.ValidateSession(ctx, sessionID)
+	if err != nil || session == nil {
+		return nil, nil, &deviceVerifyError{status: http.StatusUnauthorized, errCode: "session_expired"}
+	}
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		c.Logger().Errorf("failed to find tenant: %v", err)
+		return nil, nil, serverErr
+	}
+	if tenant == nil || session.TenantID != tenant.ID {
+		return nil, nil, &deviceVerifyError{status: http.StatusUnauthorized, errCode: "invalid_session"}
+	}
+	if emailVerificationPending(session) {
+		return nil, nil, &deviceVerifyError{status: http.StatusForbidden, errCode: "email_not_verified"}
+	}
+
+	// 総当たり対策: 直近の失敗回数が上限に達していれば照合自体を行わない
+	ip := c.RealIP()
+	failures, err := h.deviceCodeStore.CountUserCodeFailures(ctx, session.ID, ip, time.Now().Add(-userCodeFailureWindow))
+	if err != nil {
+		c.Logger().Errorf("failed to count user_code failures: %v", err)
+		return nil, nil, serverErr
+	}
+	if failures >= userCodeMaxFailures {
+		return nil, nil, &deviceVerifyError{status: http.StatusTooManyRequests, errCode: "too_many_attempts"}
+	}
+
+	dc, err := h.deviceCodeStore.FindByUserCode(ctx, normalizeUserCode(userCode))
+	if err != nil {
+		c.Logger().Errorf("failed to find device code: %v", err)
+		return nil, nil, serverErr
+	}
+	if dc == nil || dc.IsExpired() || dc.Status != model.DeviceCodeStatusPending || dc.Client.TenantID != tenant.ID {
+		if err := h.deviceCodeStore.RecordUserCodeFailure(ctx, session.ID, ip); err != nil {
+			c.Logger().Errorf("failed to record user_code failure: %v", err)
+		}
+		return nil, nil, &deviceVerifyError{status: http.StatusBadRequest, errCode: "invalid_user_code"}
+	}
+
+	return session, dc, nil
+}
+
+// consent はスコープがクライアントに現在も許可されていることを確認し、同意が必要で未同意のスコープと記録済みの同意を返す
+func (h *DeviceVerificationHandler) consent(c echo.Context, session *model.Session, dc *model.DeviceCode) ([]*model.ScopeDefinition, *model.UserConsent, *deviceVerifyError) {
+	pending, consent, err := approvalConsent(c.Request().Context(), h.scopeFinder, h.apiResourceFinder, h.consentStore, &dc.Client, session.UserID, dc.Scope)
+	if errors.Is(err, ErrInvalidScope) {
+		return nil, nil, &deviceVerifyError{status: http.StatusBadRequest, errCode: "invalid_scope"}
+	}
+	if err != nil {
+		c.Logger().Errorf("failed to check consent: %v", err)
+		return nil, nil, &deviceVerifyError{status: http.StatusInternalServerError, errCode: "server_error"}
+	}
+	return pending, consent, nil
+}
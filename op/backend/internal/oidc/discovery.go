@@ -4,17 +4,35 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// clientAuthMethodsSupported は ClientAuthenticator が対応するクライアント認証方式
+var clientAuthMethodsSupported = []string{"client_secret_basic", "client_secret_post", "client_secret_jwt", "private_key_jwt", "tls_client_auth", "self_signed_tls_client_auth", "none"}
+
+// clientAuthSigningAlgsSupported はクライアントアサーションの署名アルゴリズム
+var clientAuthSigningAlgsSupported = []string{"RS256", "PS256", "ES256", "HS256"}
+
+// encryptionAlgsSupported / encryptionEncsSupported は ID トークン・UserInfo の暗号化アルゴリズム
+var (
+	encryptionAlgsSupported = []string{"RSA-OAEP-256", "ECDH-ES"}
+	encryptionEncsSupported = []string{"A256GCM"}
 )
 
 type DiscoveryHandler struct {
-	issuerBaseURL string
-	tenantFinder  TenantFinder
+	issuerBaseURL    string
+	tenantFinder     TenantFinder
+	detailTypeFinder AuthorizationDetailTypeFinder
+	scopeFinder      ScopeDefinitionFinder
 }
 
-func NewDiscoveryHandler(issuerBaseURL string, tenantFinder TenantFinder) *DiscoveryHandler {
+func NewDiscoveryHandler(issuerBaseURL string, tenantFinder TenantFinder, detailTypeFinder AuthorizationDetailTypeFinder, scopeFinder ScopeDefinitionFinder) *DiscoveryHandler {
 	return &DiscoveryHandler{
-		issuerBaseURL: issuerBaseURL,
-		tenantFinder:  tenantFinder,
+		issuerBaseURL:    issuerBaseURL,
+		tenantFinder:     tenantFinder,
+		detailTypeFinder: detailTypeFinder,
+		scopeFinder:      scopeFinder,
 	}
 }
 
@@ -35,20 +53,81 @@ func (h *DiscoveryHandler) Handle(c echo.Context) error {
 	issuer := h.issuerBaseURL + "/" + tenantCode
 
 	metadata := map[string]interface{}{
-		"issuer":                                issuer,
-		"authorization_endpoint":                issuer + "/authorize",
-		"token_endpoint":                        issuer + "/token",
-		"userinfo_endpoint":                     issuer + "/userinfo",
-		"jwks_uri":                              h.issuerBaseURL + "/jwks",
-		"revocation_endpoint":                   issuer + "/revoke",
-		"response_types_supported":              []string{"code"},
-		"grant_types_supported":                 []string{"authorization_code", "refresh_token"},
-		"subject_types_supported":               []string{"public"},
-		"id_token_signing_alg_values_supported": []string{"RS256"},
-		"scopes_supported":                      []string{"openid", "profile", "email", "offline_access"},
-		"token_endpoint_auth_methods_supported": []string{"client_secret_basic", "client_secret_post"},
-		"code_challenge_methods_supported":      []string{"S256"},
-		"claims_supported":                      []string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "name", "email", "email_verified"},
+		"issuer":                                                issuer,
+		"authorization_endpoint":                                issuer + "/authorize",
+		"token_endpoint":                                        issuer + "/token",
+		"userinfo_endpoint":                                     issuer + "/userinfo",
+		"jwks_uri":                                              h.issuerBaseURL + "/jwks",
+		"revocation_endpoint":                                   issuer + "/revoke",
+		"device_authorization_endpoint":                         issuer + "/device/authorize",
+		"backchannel_authentication_endpoint":                   issuer + "/bc-authorize",
+		"response_types_supported":                              []string{"code"},
+		"response_modes_supported":                              responseModesSupported,
+		"authorization_response_iss_parameter_supported":        true,
+		"authorization_signing_alg_values_supported":            []string{"RS256"},
+		"grant_types_supported":                                 []string{"authorization_code", "refresh_token", deviceCodeGrantType, tokenExchangeGrantType, cibaGrantType},
+		"subject_types_supported":                               []string{"public", "pairwise"},
+		"id_token_signing_alg_values_supported":                 []string{"RS256"},
+		"id_token_encryption_alg_values_supported":              encryptionAlgsSupported,
+		"id_token_encryption_enc_values_supported":              encryptionEncsSupported,
+		"userinfo_signing_alg_values_supported":                 []string{"RS256"},
+		"userinfo_encryption_alg_values_supported":              encryptionAlgsSupported,
+		"userinfo_encryption_enc_values_supported":              encryptionEncsSupported,
+		"token_endpoint_auth_methods_supported":                 clientAuthMethodsSupported,
+		"token_endpoint_auth_signing_alg_values_supported":      clientAuthSigningAlgsSupported,
+		"revocation_endpoint_auth_methods_supported":            clientAuthMethodsSupported,
+		"revocation_endpoint_auth_signing_alg_values_supported": clientAuthSigningAlgsSupported,
+		"tls_client_certificate_bound_access_tokens":            true,
+		"code_challenge_methods_supported":                      []string{"S256"},
+		"claims_parameter_supported":                            true,
+		"request_parameter_supported":                           true,
+		"request_uri_parameter_supported":                       true,
+		"require_request_uri_registration":                      true,
+		"request_object_signing_alg_values_supported":           requestObjectSigningAlgsSupported,
+		"backchannel_token_delivery_modes_supported":            cibaDeliveryModesSupported,
+		"backchannel_user_code_parameter_supported":             false,
+	}
+
+	// テナントが定義したスコープと、そのスコープで返すクレーム
+	scopeDefs, err := h.scopeFinder.ListByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	scopesSupported := append([]string{}, model.StandardScopes...)
+	var scopeClaimNames []string
+	for _, d := range scopeDefs {
+		scopesSupported = append(scopesSupported, d.Name)
+		for _, m := range d.Claims {
+			scopeClaimNames = append(scopeClaimNames, m.Claim)
+		}
+	}
+	metadata["scopes_supported"] = scopesSupported
+
+	// テナントが提供するクレーム。標準クレームに加え、テナントが定義したカスタム属性とスコープのクレームを含める
+	claimsSupported := append([]string{"sub", "iss", "aud", "exp", "iat", "auth_time", "nonce", "amr"}, model.StandardUserClaims...)
+	for _, name := range append(tenant.UserAttributeNames(), scopeClaimNames...) {
+		if !containsScope(claimsSupported, name) {
+			claimsSupported = append(claimsSupported, name)
+		}
+	}
+	metadata["claims_supported"] = claimsSupported
+
+	// テナントに登録された authorization_details の type (RFC 9396 Section 10)
+	detailTypes, err := h.detailTypeFinder.ListByTenantID(c.Request().Context(), tenant.ID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if len(detailTypes) > 0 {
+		types := make([]string, len(detailTypes))
+		for i, t := range detailTypes {
+			types[i] = t.Type
+		}
+		metadata["authorization_details_types_supported"] = types
+	}
+
+	// 動的クライアント登録を受け付けるテナントのみ公開する (RFC 8414 Section 2)
+	if tenant.RegistrationEnabled() {
+		metadata["registration_endpoint"] = issuer + "/register"
 	}
 
 	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
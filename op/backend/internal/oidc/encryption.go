@@ -0,0 +1,16 @@
+package oidc
+
+import (
+	"fmt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// encryptForClient はクライアントが登録した jwks の公開鍵で payload を暗号化する。
+// alg / enc はクライアントメタデータの *_encrypted_response_alg / *_encrypted_response_enc
+func encryptForClient(encrypt EncryptJWTFunc, client *model.Client, alg, enc *string, payload []byte, contentType string) (string, error) {
+	if client.JWKS == nil || alg == nil || enc == nil {
+		return "", fmt.Errorf("client %s has incomplete encryption settings", client.ClientID)
+	}
+	return encrypt(*client.JWKS, *alg, *enc, payload, contentType)
+}
@@ -6,4 +6,22 @@ var (
 	ErrInvalidClient        = errors.New("invalid_client")
 	ErrInvalidGrant         = errors.New("invalid_grant")
 	ErrUnsupportedGrantType = errors.New("unsupported_grant_type")
+	ErrUnauthorizedClient   = errors.New("unauthorized_client")
+
+	// デバイスコードグラント・CIBA のポーリング応答 (RFC 8628 Section 3.5, CIBA Core 1.0 Section 11)
+	ErrAuthorizationPending = errors.New("authorization_pending")
+	ErrSlowDown             = errors.New("slow_down")
+	ErrAccessDenied         = errors.New("access_denied")
+	ErrExpiredToken         = errors.New("expired_token")
+
+	// トークン交換 (RFC 8693 Section 2.2.2)
+	ErrInvalidTarget = errors.New("invalid_target")
+	ErrInvalidScope  = errors.New("invalid_scope")
+
+	// リクエストオブジェクト (OIDC Core 1.0 Section 3.1.2.6, RFC 9101 Section 6.3)
+	ErrInvalidRequestObject = errors.New("invalid_request_object")
+	ErrInvalidRequestURI    = errors.New("invalid_request_uri")
+
+	// authorization_details (RFC 9396 Section 5)
+	ErrInvalidAuthorizationDetails = errors.New("invalid_authorization_details")
 )
@@ -0,0 +1,308 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// このファイルはパッケージ内のテストで共有するフェイクとヘルパーをまとめる。
+// 1つのテストでしか使わないフェイクはそのテストのファイルに置く
+
+const testIssuerBaseURL = "https://op.example.com"
+
+func strPtr(s string) *string { return &s }
+
+func ptrTime(t time.Time) *time.Time { return &t }
+
+// newTestSession はテナントにログイン済みで1時間有効なセッションを返す
+func newTestSession(tenant *model.Tenant) *model.Session {
+	return &model.Session{ID: uuid.New(), TenantID: tenant.ID, UserID: uuid.New(), Tenant: *tenant, ExpiresAt: time.Now().Add(time.Hour)}
+}
+
+// location はリダイレクト先を返す
+func location(rec *httptest.ResponseRecorder) *url.URL {
+	loc, _ := url.Parse(rec.Header().Get("Location"))
+	if loc == nil {
+		return &url.URL{}
+	}
+	return loc
+}
+
+type fakeTenantFinder struct {
+	tenants []*model.Tenant
+}
+
+func newFakeTenantFinder(tenants ...*model.Tenant) *fakeTenantFinder {
+	return &fakeTenantFinder{tenants: tenants}
+}
+
+func (f *fakeTenantFinder) FindByCode(_ context.Context, code string) (*model.Tenant, error) {
+	for _, t := range f.tenants {
+		if t.Code == code {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeTenantFinder) FindByID(_ context.Context, id uuid.UUID) (*model.Tenant, error) {
+	for _, t := range f.tenants {
+		if t.ID == id {
+			return t, nil
+		}
+	}
+	return nil, nil
+}
+
+type fakeClientFinder struct {
+	clients map[string]*model.Client
+}
+
+func newFakeClientFinder(clients ...*model.Client) *fakeClientFinder {
+	f := &fakeClientFinder{clients: map[string]*model.Client{}}
+	for _, c := range clients {
+		f.clients[c.ClientID] = c
+	}
+	return f
+}
+
+func (f *fakeClientFinder) FindByClientID(_ context.Context, clientID string) (*model.Client, error) {
+	return f.clients[clientID], nil
+}
+
+func (f *fakeClientFinder) FindByClientIDWithRedirectURIs(_ context.Context, clientID string) (*model.Client, error) {
+	return f.clients[clientID], nil
+}
+
+type fakeSessionValidator struct {
+	sessions map[uuid.UUID]*model.Session
+}
+
+func newFakeSessionValidator(sessions ...*model.Session) *fakeSessionValidator {
+	f := &fakeSessionValidator{sessions: map[uuid.UUID]*model.Session{}}
+	for _, s := range sessions {
+		f.sessions[s.ID] = s
+	}
+	return f
+}
+
+func (f *fakeSessionValidator) ValidateSession(_ context.Context, id uuid.UUID) (*model.Session, error) {
+	return f.sessions[id], nil
+}
+
+type fakeUserFinder struct {
+	users map[uuid.UUID]*model.User
+}
+
+func newFakeUserFinder(users ...*model.User) *fakeUserFinder {
+	f := &fakeUserFinder{users: map[uuid.UUID]*model.User{}}
+	for _, u := range users {
+		f.users[u.ID] = u
+	}
+	return f
+}
+
+func (f *fakeUserFinder) FindByID(_ context.Context, id uuid.UUID) (*model.User, error) {
+	return f.users[id], nil
+}
+
+type fakeScopeDefinitionFinder struct {
+	defs []model.ScopeDefinition
+}
+
+func (f *fakeScopeDefinitionFinder) ListByTenantID(_ context.Context, tenantID uuid.UUID) ([]model.ScopeDefinition, error) {
+	var list []model.ScopeDefinition
+	for _, d := range f.defs {
+		if d.TenantID == tenantID {
+			list = append(list, d)
+		}
+	}
+	return list, nil
+}
+
+type fakeUserConsentStore struct {
+	consents map[uuid.UUID]*model.UserConsent
+}
+
+func (f *fakeUserConsentStore) FindByUserAndClient(_ context.Context, userID, clientID uuid.UUID) (*model.UserConsent, error) {
+	if c := f.consents[clientID]; c != nil && c.UserID == userID {
+		return c, nil
+	}
+	return nil, nil
+}
+
+func (f *fakeUserConsentStore) Save(_ context.Context, consent *model.UserConsent) error {
+	f.consents[consent.ClientID] = consent
+	return nil
+}
+
+type fakeAPIResourceFinder struct {
+	resources []model.APIResource
+}
+
+func (f *fakeAPIResourceFinder) FindByIdentifier(_ context.Context, tenantID uuid.UUID, identifier string) (*model.APIResource, error) {
+	for i := range f.resources {
+		if f.resources[i].TenantID == tenantID && f.resources[i].Identifier == identifier {
+			return &f.resources[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeAPIResourceFinder) ListByTenantID(_ context.Context, tenantID uuid.UUID) ([]model.APIResource, error) {
+	var list []model.APIResource
+	for _, r := range f.resources {
+		if r.TenantID == tenantID {
+			list = append(list, r)
+		}
+	}
+	return list, nil
+}
+
+// fakeDetailTypeFinder は type 名からスキーマを返す。テナントは区別しない
+type fakeDetailTypeFinder struct {
+	types map[string]string
+}
+
+func (f *fakeDetailTypeFinder) FindByType(_ context.Context, tenantID uuid.UUID, detailType string) (*model.AuthorizationDetailType, error) {
+	schema, ok := f.types[detailType]
+	if !ok {
+		return nil, nil
+	}
+	return &model.AuthorizationDetailType{TenantID: tenantID, Type: detailType, Schema: schema}, nil
+}
+
+func (f *fakeDetailTypeFinder) ListByTenantID(_ context.Context, tenantID uuid.UUID) ([]model.AuthorizationDetailType, error) {
+	var list []model.AuthorizationDetailType
+	for name, schema := range f.types {
+		list = append(list, model.AuthorizationDetailType{TenantID: tenantID, Type: name, Schema: schema})
+	}
+	return list, nil
+}
+
+// fakeDetailsApprovalStore は DB と同じく検索結果を複製して返す
+type fakeDetailsApprovalStore struct {
+	approvals map[uuid.UUID]*model.AuthorizationDetailsApproval
+}
+
+func newFakeDetailsApprovalStore() *fakeDetailsApprovalStore {
+	return &fakeDetailsApprovalStore{approvals: map[uuid.UUID]*model.AuthorizationDetailsApproval{}}
+}
+
+func (f *fakeDetailsApprovalStore) Create(_ context.Context, approval *model.AuthorizationDetailsApproval) error {
+	approval.ID = uuid.New()
+	approval.CreatedAt = time.Now()
+	f.approvals[approval.ID] = approval
+	return nil
+}
+
+func (f *fakeDetailsApprovalStore) FindByID(_ context.Context, id uuid.UUID) (*model.AuthorizationDetailsApproval, error) {
+	a := f.approvals[id]
+	if a == nil {
+		return nil, nil
+	}
+	found := *a
+	return &found, nil
+}
+
+func (f *fakeDetailsApprovalStore) FindLatest(_ context.Context, sessionID, clientID uuid.UUID, detailsHash string) (*model.AuthorizationDetailsApproval, error) {
+	var latest *model.AuthorizationDetailsApproval
+	for _, a := range f.approvals {
+		if a.SessionID != sessionID || a.ClientID != clientID || a.DetailsHash != detailsHash ||
+			a.Status == model.AuthorizationDetailsApprovalStatusConsumed || a.IsExpired() {
+			continue
+		}
+		if latest == nil || a.CreatedAt.After(latest.CreatedAt) {
+			latest = a
+		}
+	}
+	if latest == nil {
+		return nil, nil
+	}
+	found := *latest
+	return &found, nil
+}
+
+func (f *fakeDetailsApprovalStore) Decide(_ context.Context, id uuid.UUID, approved bool) (bool, error) {
+	a := f.approvals[id]
+	if a == nil || a.Status != model.AuthorizationDetailsApprovalStatusPending || a.IsExpired() {
+		return false, nil
+	}
+	a.Status = model.AuthorizationDetailsApprovalStatusDenied
+	if approved {
+		a.Status = model.AuthorizationDetailsApprovalStatusApproved
+	}
+	return true, nil
+}
+
+func (f *fakeDetailsApprovalStore) MarkConsumed(_ context.Context, id uuid.UUID) (bool, error) {
+	a := f.approvals[id]
+	if a == nil || (a.Status != model.AuthorizationDetailsApprovalStatusApproved && a.Status != model.AuthorizationDetailsApprovalStatusDenied) {
+		return false, nil
+	}
+	a.Status = model.AuthorizationDetailsApprovalStatusConsumed
+	return true, nil
+}
+
+// fakeTokenValidator はトークン文字列をそのまま検証結果に対応付ける
+type fakeTokenValidator struct {
+	accessTokens map[string]*model.AccessTokenResult
+	idTokens     map[string]*model.IDTokenResult
+}
+
+func (f *fakeTokenValidator) ValidateAccessToken(_ context.Context, token string) (*model.AccessTokenResult, error) {
+	if r, ok := f.accessTokens[token]; ok {
+		return r, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+func (f *fakeTokenValidator) ValidateIDToken(_ context.Context, token string) (*model.IDTokenResult, error) {
+	if r, ok := f.idTokens[token]; ok {
+		return r, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+type fakeAccessTokenStore struct {
+	tokens  map[string]*model.AccessToken
+	created []*model.AccessToken
+}
+
+func (f *fakeAccessTokenStore) Create(_ context.Context, token *model.AccessToken) error {
+	f.created = append(f.created, token)
+	return nil
+}
+
+func (f *fakeAccessTokenStore) FindByJTI(_ context.Context, jti string) (*model.AccessToken, error) {
+	return f.tokens[jti], nil
+}
+
+func (f *fakeAccessTokenStore) Revoke(context.Context, uuid.UUID) error { return nil }
+
+func (f *fakeAccessTokenStore) RevokeBySessionID(context.Context, uuid.UUID) error { return nil }
+
+// fakeResponseSigner は署名の代わりにクレームの JSON を返す
+type fakeResponseSigner struct {
+	err error
+}
+
+func (f *fakeResponseSigner) SignAuthorizationResponse(_ context.Context, issuer, audience string, params map[string]interface{}, lifetime time.Duration) (string, error) {
+	if f.err != nil {
+		return "", f.err
+	}
+	body := map[string]interface{}{"iss": issuer, "aud": audience, "lifetime": lifetime.String()}
+	for k, v := range params {
+		body[k] = v
+	}
+	raw, err := json.Marshal(body)
+	return string(raw), err
+}
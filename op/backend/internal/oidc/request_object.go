@@ -0,0 +1,235 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jws"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// requestObjectSkew はリクエストオブジェクトの exp / nbf 検証で許容する時計のずれ
+	requestObjectSkew = 30 * time.Second
+	// requestObjectMaxBytes は request_uri から読み込むリクエストオブジェクトの上限
+	requestObjectMaxBytes = 64 * 1024
+)
+
+// requestObjectSigningAlgsSupported はリクエストオブジェクトの署名アルゴリズム。署名なし (none) は受け付けない
+var requestObjectSigningAlgsSupported = []string{"RS256", "PS256", "ES256"}
+
+// リクエストオブジェクトに含めてはならない・認可パラメータとして扱わないクレーム
+var requestObjectReservedClaims = map[string]bool{
+	"iss": true, "sub": true, "aud": true, "exp": true, "nbf": true, "iat": true, "jti": true,
+}
+
+// requestObjectJSONParams は値が JSON の認可パラメータ。配列でも要素に分けず、1 つの JSON 文字列にする
+var requestObjectJSONParams = map[string]bool{
+	"claims":                true, // OIDC Core 1.0 Section 5.5
+	"authorization_details": true, // RFC 9396 Section 2
+}
+
+// RequestObjectResolver は request / request_uri パラメータのリクエストオブジェクトを検証する。
+// 仕様参照: RFC 9101, OIDC Core 1.0 Section 6
+type RequestObjectResolver struct {
+	httpClient    *http.Client
+	issuerBaseURL string
+}
+
+func NewRequestObjectResolver(issuerBaseURL string) *RequestObjectResolver {
+	return &RequestObjectResolver{
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+		issuerBaseURL: issuerBaseURL,
+	}
+}
+
+// Resolve はリクエストオブジェクトを検証し、含まれる認可パラメータを返す。
+// request_uri の取得・登録確認に失敗した場合は ErrInvalidRequestURI、
+// オブジェクトの検証に失敗した場合は ErrInvalidRequestObject をラップして返す
+func (r *RequestObjectResolver) Resolve(ctx context.Context, client *model.Client, tenantCode, request, requestURI string) (url.Values, error) {
+	// request と request_uri の同時指定は不可 (MUST NOT: RFC 9101 Section 5)
+	if request != "" && requestURI != "" {
+		return nil, fmt.Errorf("%w: request and request_uri must not both be present", ErrInvalidRequestObject)
+	}
+
+	raw := request
+	if requestURI != "" {
+		fetched, err := r.fetch(ctx, client, requestURI)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidRequestURI, err)
+		}
+		raw = fetched
+	}
+
+	token, err := r.verify(ctx, client, r.issuerBaseURL+"/"+tenantCode, raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequestObject, err)
+	}
+	params, err := requestObjectParams(token)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRequestObject, err)
+	}
+	return params, nil
+}
+
+// fetch は登録済みの request_uri からリクエストオブジェクトを取得する。
+// 任意の URL への取得を防ぐため、事前登録された https の URI のみ受け付ける (RFC 9101 Section 10.4)
+func (r *RequestObjectResolver) fetch(ctx context.Context, client *model.Client, requestURI string) (string, error) {
+	parsed, err := url.Parse(requestURI)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return "", errors.New("request_uri must be an https URL")
+	}
+	if !isRegisteredRequestURI(client.RequestURIs, requestURI) {
+		return "", errors.New("request_uri is not registered for this client")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, requestURI, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Accept", "application/oauth-authz-req+jwt")
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch request_uri: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("request_uri returned status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, requestObjectMaxBytes+1))
+	if err != nil {
+		return "", fmt.Errorf("failed to read request_uri: %w", err)
+	}
+	if len(body) > requestObjectMaxBytes {
+		return "", errors.New("request object is too large")
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// isRegisteredRequestURI はフラグメントを除いた request_uri が登録済みか判定する (OIDC Registration 1.0 Section 2)
+func isRegisteredRequestURI(registered []string, requestURI string) bool {
+	target := stripFragment(requestURI)
+	for _, uri := range registered {
+		if stripFragment(uri) == target {
+			return true
+		}
+	}
+	return false
+}
+
+func stripFragment(uri string) string {
+	if i := strings.IndexByte(uri, '#'); i >= 0 {
+		return uri[:i]
+	}
+	return uri
+}
+
+// verify はリクエストオブジェクトの署名とクレームを検証する。
+// iss は client_id、aud は OP の issuer (MUST: RFC 9101 Section 4)。exp は必須とし、nbf があれば検証する
+func (r *RequestObjectResolver) verify(ctx context.Context, client *model.Client, issuer, raw string) (jwt.Token, error) {
+	if raw == "" {
+		return nil, errors.New("request object is empty")
+	}
+
+	msg, err := jws.Parse([]byte(raw))
+	if err != nil {
+		return nil, errors.New("request object must be a signed JWT")
+	}
+	sigs := msg.Signatures()
+	if len(sigs) != 1 {
+		return nil, errors.New("request object must have exactly one signature")
+	}
+	alg, ok := sigs[0].ProtectedHeaders().Algorithm()
+	if !ok || !containsScope(requestObjectSigningAlgsSupported, alg.String()) {
+		return nil, errors.New("unsupported request object signing algorithm")
+	}
+
+	set, err := fetchClientKeySet(ctx, r.httpClient, client)
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.Parse([]byte(raw),
+		jwt.WithKeySet(set, jws.WithInferAlgorithmFromKey(true)),
+		jwt.WithIssuer(client.ClientID),
+		jwt.WithAudience(issuer),
+		jwt.WithAcceptableSkew(requestObjectSkew),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("request object verification failed: %w", err)
+	}
+	if _, ok := token.Expiration(); !ok {
+		return nil, errors.New("request object must have exp")
+	}
+
+	// client_id を含む場合はクエリの client_id と一致すること (MUST: RFC 9101 Section 5)
+	var objectClientID string
+	if err := token.Get("client_id", &objectClientID); err == nil && objectClientID != client.ClientID {
+		return nil, errors.New("client_id in request object does not match")
+	}
+	return token, nil
+}
+
+// requestObjectParams はリクエストオブジェクトのクレームを認可パラメータに変換する。
+// 配列は複数値 (resource 等)、オブジェクトと requestObjectJSONParams の値は JSON 文字列として扱う
+func requestObjectParams(token jwt.Token) (url.Values, error) {
+	params := url.Values{}
+	for _, name := range token.Keys() {
+		if requestObjectReservedClaims[name] {
+			continue
+		}
+		// リクエストオブジェクトの入れ子は不可 (MUST NOT: RFC 9101 Section 4)
+		if name == "request" || name == "request_uri" {
+			return nil, fmt.Errorf("request object must not contain %s", name)
+		}
+
+		var v interface{}
+		if err := token.Get(name, &v); err != nil {
+			return nil, fmt.Errorf("failed to read claim %s: %w", name, err)
+		}
+		if values, ok := v.([]interface{}); ok && !requestObjectJSONParams[name] {
+			for _, item := range values {
+				s, err := requestObjectParamValue(item)
+				if err != nil {
+					return nil, err
+				}
+				params.Add(name, s)
+			}
+			continue
+		}
+		s, err := requestObjectParamValue(v)
+		if err != nil {
+			return nil, err
+		}
+		params.Set(name, s)
+	}
+	return params, nil
+}
+
+func requestObjectParamValue(v interface{}) (string, error) {
+	switch value := v.(type) {
+	case string:
+		return value, nil
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(value), nil
+	default:
+		b, err := json.Marshal(value)
+		if err != nil {
+			return "", err
+		}
+		return string(b), nil
+	}
+}
@@ -0,0 +1,217 @@
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lestrrat-go/jwx/v3/jwa"
+	"github.com/lestrrat-go/jwx/v3/jwt"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// requestObjectClaims は demo テナント向けの正しいリクエストオブジェクトのクレーム。テストケースで上書きする
+func requestObjectClaims(clientID string) map[string]interface{} {
+	return map[string]interface{}{
+		"iss":           clientID,
+		"aud":           testIssuerBaseURL + "/demo",
+		"exp":           time.Now().Add(time.Minute).Unix(),
+		"client_id":     clientID,
+		"response_type": "code",
+		"scope":         "openid email",
+		"max_age":       600,
+		"resource":      []string{"https://api.example.com/a", "https://api.example.com/b"},
+		"claims":        map[string]interface{}{"id_token": map[string]interface{}{"email": nil}},
+	}
+}
+
+func signRequestObject(t *testing.T, claims map[string]interface{}, key *rsa.PrivateKey) string {
+	t.Helper()
+	token := jwt.New()
+	for name, value := range claims {
+		if err := token.Set(name, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return signRS256(t, token, key, "ro")
+}
+
+// unsignedRequestObject は alg=none のリクエストオブジェクトを組み立てる
+func unsignedRequestObject(payload string) string {
+	enc := base64.RawURLEncoding.EncodeToString
+	return enc([]byte(`{"alg":"none"}`)) + "." + enc([]byte(payload)) + "."
+}
+
+func TestResolveRequestObject(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwks := publicJWKS(t, key, "ro")
+	client := &model.Client{ClientID: "rp", JWKS: &jwks}
+	resolver := NewRequestObjectResolver(testIssuerBaseURL)
+
+	with := func(overrides map[string]interface{}) map[string]interface{} {
+		claims := requestObjectClaims("rp")
+		for name, value := range overrides {
+			if value == nil {
+				delete(claims, name)
+			} else {
+				claims[name] = value
+			}
+		}
+		return claims
+	}
+
+	t.Run("パラメータへの変換", func(t *testing.T) {
+		params, err := resolver.Resolve(context.Background(), client, "demo", signRequestObject(t, requestObjectClaims("rp"), key), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if params.Get("response_type") != "code" || params.Get("scope") != "openid email" || params.Get("max_age") != "600" {
+			t.Errorf("params = %v", params)
+		}
+		if got := params["resource"]; !reflect.DeepEqual(got, []string{"https://api.example.com/a", "https://api.example.com/b"}) {
+			t.Errorf("resource = %v", got)
+		}
+		if got := params.Get("claims"); got != `{"id_token":{"email":null}}` {
+			t.Errorf("claims = %s", got)
+		}
+		for _, reserved := range []string{"iss", "aud", "exp"} {
+			if params.Has(reserved) {
+				t.Errorf("%s must not become an authorization parameter", reserved)
+			}
+		}
+	})
+
+	t.Run("authorization_details は配列のまま渡す", func(t *testing.T) {
+		details := []interface{}{
+			map[string]interface{}{"type": "payment_initiation", "instructedAmount": map[string]interface{}{"currency": "EUR", "amount": "123.50"}},
+			map[string]interface{}{"type": "account_information", "locations": []string{"https://api.example.com/accounts"}},
+		}
+		params, err := resolver.Resolve(context.Background(), client, "demo", signRequestObject(t, with(map[string]interface{}{"authorization_details": details}), key), "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := params["authorization_details"]; len(got) != 1 {
+			t.Fatalf("authorization_details = %v", got)
+		}
+		decoded, err := decodeAuthorizationDetails(params.Get("authorization_details"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(decoded) != 2 || decoded[0]["type"] != "payment_initiation" || decoded[1]["type"] != "account_information" {
+			t.Errorf("authorization_details = %+v", decoded)
+		}
+	})
+
+	tests := []struct {
+		name    string
+		request string
+	}{
+		{name: "iss が client_id でない", request: signRequestObject(t, with(map[string]interface{}{"iss": "other"}), key)},
+		{name: "aud が他のテナント", request: signRequestObject(t, with(map[string]interface{}{"aud": testIssuerBaseURL + "/other"}), key)},
+		{name: "exp なし", request: signRequestObject(t, with(map[string]interface{}{"exp": nil}), key)},
+		{name: "期限切れ", request: signRequestObject(t, with(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}), key)},
+		{name: "nbf が未来", request: signRequestObject(t, with(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()}), key)},
+		{name: "client_id が一致しない", request: signRequestObject(t, with(map[string]interface{}{"client_id": "other"}), key)},
+		{name: "入れ子の request", request: signRequestObject(t, with(map[string]interface{}{"request": "x"}), key)},
+		{name: "入れ子の request_uri", request: signRequestObject(t, with(map[string]interface{}{"request_uri": "https://rp.example.com/ro"}), key)},
+		{name: "登録されていない鍵で署名", request: signRequestObject(t, requestObjectClaims("rp"), otherKey)},
+		{name: "署名なし", request: unsignedRequestObject(`{"iss":"rp","aud":"https://op.example.com/demo","exp":9999999999}`)},
+		{name: "JWT でない", request: "not-a-jwt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := resolver.Resolve(context.Background(), client, "demo", tt.request, ""); !errors.Is(err, ErrInvalidRequestObject) {
+				t.Fatalf("err = %v, want ErrInvalidRequestObject", err)
+			}
+		})
+	}
+
+	t.Run("HS256 は受け付けない", func(t *testing.T) {
+		token := jwt.New()
+		for name, value := range requestObjectClaims("rp") {
+			_ = token.Set(name, value)
+		}
+		signed, err := jwt.Sign(token, jwt.WithKey(jwa.HS256(), []byte("0123456789abcdef0123456789abcdef")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := resolver.Resolve(context.Background(), client, "demo", string(signed), ""); !errors.Is(err, ErrInvalidRequestObject) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+
+	t.Run("request と request_uri の同時指定", func(t *testing.T) {
+		_, err := resolver.Resolve(context.Background(), client, "demo", signRequestObject(t, requestObjectClaims("rp"), key), "https://rp.example.com/ro")
+		if !errors.Is(err, ErrInvalidRequestObject) {
+			t.Fatalf("err = %v", err)
+		}
+	})
+}
+
+func TestResolveRequestURI(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	request := signRequestObject(t, requestObjectClaims("rp"), key)
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ro":
+			if accept := r.Header.Get("Accept"); accept != "application/oauth-authz-req+jwt" {
+				http.Error(w, "bad accept: "+accept, http.StatusNotAcceptable)
+				return
+			}
+			_, _ = w.Write([]byte(request + "\n"))
+		case "/large":
+			_, _ = w.Write([]byte(strings.Repeat("a", requestObjectMaxBytes+1)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	jwks := publicJWKS(t, key, "ro")
+	client := &model.Client{
+		ClientID:    "rp",
+		JWKS:        &jwks,
+		RequestURIs: model.StringSlice{srv.URL + "/ro#v1", srv.URL + "/large", srv.URL + "/missing"},
+	}
+	resolver := NewRequestObjectResolver(testIssuerBaseURL)
+	resolver.httpClient = srv.Client()
+
+	// 登録時のフラグメントは照合に使わない
+	params, err := resolver.Resolve(context.Background(), client, "demo", "", srv.URL+"/ro#v2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.Get("scope") != "openid email" {
+		t.Errorf("params = %v", params)
+	}
+
+	for name, uri := range map[string]string{
+		"未登録":       srv.URL + "/other",
+		"https でない": strings.Replace(srv.URL, "https://", "http://", 1) + "/ro",
+		"上限超過":      srv.URL + "/large",
+		"404":       srv.URL + "/missing",
+	} {
+		if _, err := resolver.Resolve(context.Background(), client, "demo", "", uri); !errors.Is(err, ErrInvalidRequestURI) {
+			t.Errorf("%s: err = %v, want ErrInvalidRequestURI", name, err)
+		}
+	}
+}
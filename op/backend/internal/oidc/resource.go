@@ -0,0 +1,69 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// parseResourceParams は resource パラメータを検証して重複を除いた一覧を返す。
+// 絶対 URI でフラグメントを含まないこと (MUST: RFC 8707 Section 2)
+func parseResourceParams(values []string) ([]string, bool) {
+	var resources []string
+	for _, v := range values {
+		parsed, err := url.Parse(v)
+		if err != nil || !parsed.IsAbs() || strings.Contains(v, "#") {
+			return nil, false
+		}
+		if !containsScope(resources, v) {
+			resources = append(resources, v)
+		}
+	}
+	return resources, true
+}
+
+// selectResource はアクセストークンの aud にする API リソースを選び、そのリソース向けに絞り込んだスコープを返す。
+// granted は認可時に指定された resource。空の場合はテナントに登録された任意のリソースを指定できる。
+// resource を指定せず認可時の resource も無い場合は (nil, scope, nil) を返し、従来通りクライアント向けのトークンを発行する。
+// 仕様参照: RFC 8707 Section 2.2
+func (h *TokenHandler) selectResource(ctx context.Context, tenantID uuid.UUID, granted, requested []string, scope string) (*model.APIResource, string, error) {
+	var identifier string
+	switch len(requested) {
+	case 0:
+		switch len(granted) {
+		case 0:
+			return nil, scope, nil
+		case 1:
+			identifier = granted[0]
+		default:
+			// 複数のリソースが認可されている場合はどれ向けか指定させる
+			return nil, "", ErrInvalidTarget
+		}
+	case 1:
+		if len(granted) > 0 && !containsScope(granted, requested[0]) {
+			return nil, "", ErrInvalidTarget
+		}
+		identifier = requested[0]
+	default:
+		return nil, "", ErrInvalidTarget
+	}
+
+	resource, err := h.apiResourceFinder.FindByIdentifier(ctx, tenantID, identifier)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to find api resource: %w", err)
+	}
+	if resource == nil {
+		return nil, "", ErrInvalidTarget
+	}
+
+	scopes := resource.FilterScopes(strings.Fields(scope))
+	if len(scopes) == 0 {
+		return nil, "", ErrInvalidScope
+	}
+	return resource, strings.Join(scopes, " "), nil
+}
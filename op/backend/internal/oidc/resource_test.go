@@ -0,0 +1,101 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+func TestParseResourceParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []string
+		want   []string
+		ok     bool
+	}{
+		{name: "指定なし", ok: true},
+		{name: "重複を除く", values: []string{"https://api.example.com/orders", "https://api.example.com/orders", "urn:example:billing"}, want: []string{"https://api.example.com/orders", "urn:example:billing"}, ok: true},
+		{name: "相対 URI", values: []string{"/orders"}},
+		{name: "フラグメント付き", values: []string{"https://api.example.com/orders#v1"}},
+		{name: "空のフラグメント", values: []string{"https://api.example.com/orders#"}},
+		{name: "不正な URI", values: []string{"https://api.example.com/%zz"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := parseResourceParams(tt.values)
+			if ok != tt.ok || !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("got %v, %v; want %v, %v", got, ok, tt.want, tt.ok)
+			}
+		})
+	}
+}
+
+func TestSelectResource(t *testing.T) {
+	tenantID := uuid.New()
+	const (
+		orders  = "https://api.example.com/orders"
+		billing = "https://api.example.com/billing"
+	)
+	h := &TokenHandler{apiResourceFinder: &fakeAPIResourceFinder{resources: []model.APIResource{
+		{TenantID: tenantID, Identifier: orders, Scopes: model.StringSlice{"orders:read", "orders:write"}},
+		{TenantID: tenantID, Identifier: billing, Scopes: model.StringSlice{"billing:read"}},
+		{TenantID: uuid.New(), Identifier: "https://api.example.com/other-tenant", Scopes: model.StringSlice{"orders:read"}},
+	}}}
+
+	tests := []struct {
+		name      string
+		granted   []string
+		requested []string
+		scope     string
+		wantAud   string
+		wantScope string
+		wantErr   error
+	}{
+		{name: "resource なしはクライアント向け", scope: "openid orders:read", wantScope: "openid orders:read"},
+		{name: "認可時の resource が1つなら省略可", granted: []string{orders}, scope: "openid orders:read billing:read", wantAud: orders, wantScope: "orders:read"},
+		{name: "認可時の resource が複数なら指定が必要", granted: []string{orders, billing}, scope: "orders:read", wantErr: ErrInvalidTarget},
+		{name: "認可された resource から1つ選ぶ", granted: []string{orders, billing}, requested: []string{billing}, scope: "orders:read billing:read", wantAud: billing, wantScope: "billing:read"},
+		{name: "認可されていない resource", granted: []string{orders}, requested: []string{billing}, scope: "billing:read", wantErr: ErrInvalidTarget},
+		{name: "トークン要求で複数指定", requested: []string{orders, billing}, scope: "orders:read", wantErr: ErrInvalidTarget},
+		{name: "未登録の resource", requested: []string{"https://api.example.com/unknown"}, scope: "orders:read", wantErr: ErrInvalidTarget},
+		{name: "他テナントの resource", requested: []string{"https://api.example.com/other-tenant"}, scope: "orders:read", wantErr: ErrInvalidTarget},
+		{name: "resource 向けのスコープが残らない", requested: []string{billing}, scope: "openid orders:read", wantErr: ErrInvalidScope},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource, scope, err := h.selectResource(context.Background(), tenantID, tt.granted, tt.requested, tt.scope)
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			aud := ""
+			if resource != nil {
+				aud = resource.Identifier
+			}
+			if aud != tt.wantAud || scope != tt.wantScope {
+				t.Errorf("got (%q, %q), want (%q, %q)", aud, scope, tt.wantAud, tt.wantScope)
+			}
+		})
+	}
+}
+
+func TestAPIResourceTokenLifetime(t *testing.T) {
+	tenant := &model.Tenant{AccessTokenLifetime: 3600}
+	lifetime := 300
+	if got := (&model.APIResource{}).TokenLifetime(tenant); got != 3600 {
+		t.Errorf("default lifetime = %d", got)
+	}
+	if got := (&model.APIResource{AccessTokenLifetime: &lifetime}).TokenLifetime(tenant); got != 300 {
+		t.Errorf("resource lifetime = %d", got)
+	}
+}
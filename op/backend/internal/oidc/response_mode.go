@@ -0,0 +1,127 @@
+package oidc
+
+import (
+	"bytes"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// 認可レスポンスの返却方法
+// 仕様参照: OAuth 2.0 Multiple Response Type Encoding Practices Section 2.1, OAuth 2.0 Form Post Response Mode, JARM Section 2.3
+const (
+	responseModeQuery       = "query"
+	responseModeFragment    = "fragment"
+	responseModeFormPost    = "form_post"
+	responseModeJWT         = "jwt"
+	responseModeQueryJWT    = "query.jwt"
+	responseModeFragmentJWT = "fragment.jwt"
+	responseModeFormPostJWT = "form_post.jwt"
+)
+
+var responseModesSupported = []string{
+	responseModeQuery, responseModeFragment, responseModeFormPost,
+	responseModeJWT, responseModeQueryJWT, responseModeFragmentJWT, responseModeFormPostJWT,
+}
+
+// authorizationResponseLifetime は JWT 形式の認可レスポンスの有効期間。短くすることが推奨される (JARM Section 2.1)
+const authorizationResponseLifetime = 10 * time.Minute
+
+// formPostTemplate は form_post で返す自動送信フォーム (OAuth 2.0 Form Post Response Mode Section 2)
+var formPostTemplate = template.Must(template.New("form_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{- range $name, $values := .Params}}{{range $values}}
+<input type="hidden" name="{{$name}}" value="{{.}}"/>
+{{- end}}{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// authorizationResponse は redirect_uri の検証後に認可レスポンスの返し先と返し方を保持する
+type authorizationResponse struct {
+	redirectURI  string
+	responseMode string
+	state        string
+	issuer       string
+	clientID     string
+}
+
+// send は response_mode に従って認可レスポンスを返す。
+// iss を付与して mix-up 攻撃を防ぐ (RFC 9207 Section 2)。JWT 形式では iss クレームとして含める
+func (h *AuthorizeHandler) send(c echo.Context, ar *authorizationResponse, params url.Values) error {
+	if ar.state != "" {
+		params.Set("state", ar.state)
+	}
+
+	mode := ar.responseMode
+	if strings.HasSuffix(mode, "jwt") {
+		claims := make(map[string]interface{}, len(params))
+		for name := range params {
+			claims[name] = params.Get(name)
+		}
+		signed, err := h.responseSigner.SignAuthorizationResponse(c.Request().Context(), ar.issuer, ar.clientID, claims, authorizationResponseLifetime)
+		if err != nil {
+			c.Logger().Errorf("failed to sign authorization response: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		params = url.Values{"response": {signed}}
+		// jwt は response_type=code の既定である query.jwt として扱う (JARM Section 2.3.4)
+		mode = strings.TrimSuffix(strings.TrimSuffix(mode, "jwt"), ".")
+	} else {
+		params.Set("iss", ar.issuer)
+	}
+
+	switch mode {
+	case responseModeFragment:
+		return c.Redirect(http.StatusFound, ar.redirectURI+"#"+params.Encode())
+	case responseModeFormPost:
+		return renderFormPost(c, ar.redirectURI, params)
+	default:
+		u, err := url.Parse(ar.redirectURI)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		q := u.Query()
+		for name, values := range params {
+			q[name] = values
+		}
+		u.RawQuery = q.Encode()
+		return c.Redirect(http.StatusFound, u.String())
+	}
+}
+
+// sendError は response_mode に従ってエラーの認可レスポンスを返す (RFC 6749 Section 4.1.2.1)
+func (h *AuthorizeHandler) sendError(c echo.Context, ar *authorizationResponse, errCode, errDescription string) error {
+	params := url.Values{"error": {errCode}}
+	if errDescription != "" {
+		params.Set("error_description", errDescription)
+	}
+	return h.send(c, ar, params)
+}
+
+// renderFormPost はパラメータを redirect_uri へ POST する HTML を返す。
+// レスポンスはキャッシュさせない (OAuth 2.0 Form Post Response Mode Section 2)
+func renderFormPost(c echo.Context, action string, params url.Values) error {
+	var buf bytes.Buffer
+	// action は html/template に検証させる。javascript: などの登録済みの redirect_uri も実行させない
+	data := map[string]interface{}{"Action": action, "Params": params}
+	if err := formPostTemplate.Execute(&buf, data); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	c.Response().Header().Set("Cache-Control", "no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
+
+func isSupportedResponseMode(mode string) bool {
+	return containsScope(responseModesSupported, mode)
+}
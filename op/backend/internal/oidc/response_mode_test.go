@@ -0,0 +1,160 @@
+package oidc
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+)
+
+func sendAuthorizationResponse(t *testing.T, signer *fakeResponseSigner, mode, state string) *httptest.ResponseRecorder {
+	t.Helper()
+	h := &AuthorizeHandler{responseSigner: signer}
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/demo/authorize", nil), rec)
+	ar := &authorizationResponse{
+		redirectURI:  "https://rp.example.com/cb?tenant=a",
+		responseMode: mode,
+		state:        state,
+		issuer:       testIssuerBaseURL + "/demo",
+		clientID:     "rp",
+	}
+	if err := h.send(c, ar, url.Values{"code": {"abc"}}); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestSendResponseModes(t *testing.T) {
+	issuer := testIssuerBaseURL + "/demo"
+
+	t.Run("query は既存のクエリを残して iss を付ける", func(t *testing.T) {
+		rec := sendAuthorizationResponse(t, &fakeResponseSigner{}, responseModeQuery, "xyz")
+		loc, _ := url.Parse(rec.Header().Get("Location"))
+		q := loc.Query()
+		if rec.Code != http.StatusFound || q.Get("tenant") != "a" || q.Get("code") != "abc" || q.Get("state") != "xyz" || q.Get("iss") != issuer {
+			t.Errorf("status = %d, location = %s", rec.Code, loc)
+		}
+	})
+
+	t.Run("fragment", func(t *testing.T) {
+		rec := sendAuthorizationResponse(t, &fakeResponseSigner{}, responseModeFragment, "xyz")
+		loc, _ := url.Parse(rec.Header().Get("Location"))
+		frag, _ := url.ParseQuery(loc.Fragment)
+		if loc.Query().Get("code") != "" || frag.Get("code") != "abc" || frag.Get("iss") != issuer {
+			t.Errorf("location = %s", loc)
+		}
+	})
+
+	t.Run("form_post はエスケープしてキャッシュさせない", func(t *testing.T) {
+		rec := sendAuthorizationResponse(t, &fakeResponseSigner{}, responseModeFormPost, `"><script>alert(1)</script>`)
+		body := rec.Body.String()
+		if rec.Code != http.StatusOK || rec.Header().Get("Cache-Control") != "no-store" {
+			t.Fatalf("status = %d, headers = %v", rec.Code, rec.Header())
+		}
+		if strings.Contains(body, "<script>alert") {
+			t.Errorf("state is not escaped: %s", body)
+		}
+		for _, want := range []string{`action="https://rp.example.com/cb?tenant=a"`, `name="code" value="abc"`, `name="iss"`} {
+			if !strings.Contains(body, want) {
+				t.Errorf("body lacks %s: %s", want, body)
+			}
+		}
+	})
+
+	jwtModes := []struct {
+		mode     string
+		response func(rec *httptest.ResponseRecorder) string
+	}{
+		{mode: responseModeJWT, response: func(rec *httptest.ResponseRecorder) string {
+			loc, _ := url.Parse(rec.Header().Get("Location"))
+			return loc.Query().Get("response")
+		}},
+		{mode: responseModeQueryJWT, response: func(rec *httptest.ResponseRecorder) string {
+			loc, _ := url.Parse(rec.Header().Get("Location"))
+			return loc.Query().Get("response")
+		}},
+		{mode: responseModeFragmentJWT, response: func(rec *httptest.ResponseRecorder) string {
+			loc, _ := url.Parse(rec.Header().Get("Location"))
+			frag, _ := url.ParseQuery(loc.Fragment)
+			return frag.Get("response")
+		}},
+		{mode: responseModeFormPostJWT, response: func(rec *httptest.ResponseRecorder) string {
+			body := rec.Body.String()
+			start := strings.Index(body, `name="response" value="`)
+			if start < 0 {
+				return ""
+			}
+			rest := body[start+len(`name="response" value="`):]
+			raw := rest[:strings.IndexByte(rest, '"')]
+			return strings.NewReplacer("&#34;", `"`, "&quot;", `"`).Replace(raw)
+		}},
+	}
+	for _, tt := range jwtModes {
+		t.Run(tt.mode, func(t *testing.T) {
+			rec := sendAuthorizationResponse(t, &fakeResponseSigner{}, tt.mode, "xyz")
+			raw := tt.response(rec)
+			var claims map[string]interface{}
+			if err := json.Unmarshal([]byte(raw), &claims); err != nil {
+				t.Fatalf("response = %q: %v", raw, err)
+			}
+			if claims["iss"] != issuer || claims["aud"] != "rp" || claims["code"] != "abc" || claims["state"] != "xyz" {
+				t.Errorf("claims = %v", claims)
+			}
+			if claims["lifetime"] != authorizationResponseLifetime.String() {
+				t.Errorf("lifetime = %v", claims["lifetime"])
+			}
+			// iss は JWT のクレームとしてのみ返す
+			if loc, _ := url.Parse(rec.Header().Get("Location")); loc != nil && loc.Query().Get("iss") != "" {
+				t.Errorf("iss leaked outside the JWT: %s", loc)
+			}
+		})
+	}
+
+	t.Run("署名に失敗したら 500", func(t *testing.T) {
+		rec := sendAuthorizationResponse(t, &fakeResponseSigner{err: errors.New("no key")}, responseModeJWT, "")
+		if rec.Code != http.StatusInternalServerError || rec.Header().Get("Location") != "" {
+			t.Errorf("status = %d, location = %q", rec.Code, rec.Header().Get("Location"))
+		}
+	})
+}
+
+func TestRenderFormPostUnsafeAction(t *testing.T) {
+	for _, action := range []string{"javascript://x/%0aalert(1)", "JavaScript:alert(1)", "data:text/html,<script>alert(1)</script>", "vbscript:msgbox(1)"} {
+		t.Run(action, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/demo/authorize", nil), rec)
+			if err := renderFormPost(c, action, url.Values{"code": {"abc"}}); err != nil {
+				t.Fatal(err)
+			}
+			body := strings.ToLower(rec.Body.String())
+			// 送信先は安全でない URL の代わりの値になり、スクリプトとして実行されない
+			if !strings.Contains(body, `action="#zgotmplz"`) {
+				t.Errorf("unsafe action is rendered: %s", rec.Body)
+			}
+			for _, scheme := range []string{"javascript:", "data:", "vbscript:"} {
+				if strings.Contains(strings.ReplaceAll(body, `onload="javascript:document`, ""), scheme) {
+					t.Errorf("body contains %s: %s", scheme, rec.Body)
+				}
+			}
+		})
+	}
+}
+
+func TestIsSupportedResponseMode(t *testing.T) {
+	for _, mode := range responseModesSupported {
+		if !isSupportedResponseMode(mode) {
+			t.Errorf("%s not supported", mode)
+		}
+	}
+	for _, mode := range []string{"", "web_message", "jwt.query", "QUERY"} {
+		if isSupportedResponseMode(mode) {
+			t.Errorf("%q must not be supported", mode)
+		}
+	}
+}
@@ -4,53 +4,41 @@ import (
 	"context"
 	"net/http"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 type RevokeHandler struct {
-	clientFinder      ClientFinder
-	accessTokenStore  AccessTokenStore
-	refreshTokenStore RefreshTokenStore
-	tokenValidator    TokenValidator
-	verifyPassword    VerifyPasswordFunc
-	sha256Hex         SHA256HexFunc
+	clientAuthenticator *ClientAuthenticator
+	accessTokenStore    AccessTokenStore
+	refreshTokenStore   RefreshTokenStore
+	tokenValidator      TokenValidator
+	sha256Hex           SHA256HexFunc
 }
 
 func NewRevokeHandler(
-	clientFinder ClientFinder,
+	clientAuthenticator *ClientAuthenticator,
 	accessTokenStore AccessTokenStore,
 	refreshTokenStore RefreshTokenStore,
 	tokenValidator TokenValidator,
-	verifyPassword VerifyPasswordFunc,
 	sha256Hex SHA256HexFunc,
 ) *RevokeHandler {
 	return &RevokeHandler{
-		clientFinder:      clientFinder,
-		accessTokenStore:  accessTokenStore,
-		refreshTokenStore: refreshTokenStore,
-		tokenValidator:    tokenValidator,
-		verifyPassword:    verifyPassword,
-		sha256Hex:         sha256Hex,
+		clientAuthenticator: clientAuthenticator,
+		accessTokenStore:    accessTokenStore,
+		refreshTokenStore:   refreshTokenStore,
+		tokenValidator:      tokenValidator,
+		sha256Hex:           sha256Hex,
 	}
 }
 
 // Handle は POST /{tenant_code}/revoke を処理する
 // 仕様参照: RFC 7009 Section 2
 func (h *RevokeHandler) Handle(c echo.Context) error {
-	// クライアント認証
-	clientID, clientSecret := extractClientCredentials(c)
-	if clientID == "" || clientSecret == "" {
-		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client credentials required")
-	}
-
-	client, err := h.clientFinder.FindByClientID(c.Request().Context(), clientID)
-	if err != nil || client == nil || client.Status != "active" {
-		return tokenError(c, http.StatusUnauthorized, "invalid_client", "")
-	}
-
-	match, err := h.verifyPassword(clientSecret, client.ClientSecretHash)
-	if err != nil || !match {
-		return tokenError(c, http.StatusUnauthorized, "invalid_client", "")
+	// クライアント認証 (公開クライアントも client_id のみで失効できる: RFC 7009 Section 5)
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
 	}
 
 	token := c.FormValue("token")
@@ -63,16 +51,16 @@ func (h *RevokeHandler) Handle(c echo.Context) error {
 
 	switch tokenTypeHint {
 	case "refresh_token":
-		if !h.revokeRefreshToken(ctx, token) {
-			h.revokeAccessToken(ctx, token)
+		if !h.revokeRefreshToken(ctx, client.ID, token) {
+			h.revokeAccessToken(ctx, client.ID, token)
 		}
 	case "access_token":
-		if !h.revokeAccessToken(ctx, token) {
-			h.revokeRefreshToken(ctx, token)
+		if !h.revokeAccessToken(ctx, client.ID, token) {
+			h.revokeRefreshToken(ctx, client.ID, token)
 		}
 	default:
-		if !h.revokeAccessToken(ctx, token) {
-			h.revokeRefreshToken(ctx, token)
+		if !h.revokeAccessToken(ctx, client.ID, token) {
+			h.revokeRefreshToken(ctx, client.ID, token)
 		}
 	}
 
@@ -80,7 +68,7 @@ func (h *RevokeHandler) Handle(c echo.Context) error {
 	return c.NoContent(http.StatusOK)
 }
 
-func (h *RevokeHandler) revokeAccessToken(ctx context.Context, tokenStr string) bool {
+func (h *RevokeHandler) revokeAccessToken(ctx context.Context, clientDBID uuid.UUID, tokenStr string) bool {
 	result, err := h.tokenValidator.ValidateAccessToken(ctx, tokenStr)
 	if err != nil {
 		return false
@@ -91,11 +79,16 @@ func (h *RevokeHandler) revokeAccessToken(ctx context.Context, tokenStr string)
 		return false
 	}
 
+	// 他クライアントに発行されたトークンは失効させない (RFC 7009 Section 2.1)
+	if dbToken.ClientID != clientDBID {
+		return false
+	}
+
 	_ = h.accessTokenStore.Revoke(ctx, dbToken.ID)
 	return true
 }
 
-func (h *RevokeHandler) revokeRefreshToken(ctx context.Context, tokenStr string) bool {
+func (h *RevokeHandler) revokeRefreshToken(ctx context.Context, clientDBID uuid.UUID, tokenStr string) bool {
 	tokenHash := h.sha256Hex(tokenStr)
 
 	rt, err := h.refreshTokenStore.FindByTokenHash(ctx, tokenHash)
@@ -103,6 +96,10 @@ func (h *RevokeHandler) revokeRefreshToken(ctx context.Context, tokenStr string)
 		return false
 	}
 
+	if rt.AccessToken.ClientID != clientDBID {
+		return false
+	}
+
 	_ = h.refreshTokenStore.Revoke(ctx, rt.ID)
 	_ = h.accessTokenStore.Revoke(ctx, rt.AccessTokenID)
 	return true
@@ -0,0 +1,176 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// tenantScopes はテナントで利用できるスコープ。組み込みのスコープ、テナントが定義したスコープ、API リソースのスコープからなる
+type tenantScopes struct {
+	definitions    map[string]*model.ScopeDefinition
+	resourceScopes map[string]bool
+}
+
+// loadTenantScopes はテナントのスコープ定義と API リソースのスコープを読み込む
+func loadTenantScopes(ctx context.Context, scopeFinder ScopeDefinitionFinder, apiResourceFinder APIResourceFinder, tenantID uuid.UUID) (*tenantScopes, error) {
+	defs, err := scopeFinder.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list scope definitions: %w", err)
+	}
+	resources, err := apiResourceFinder.ListByTenantID(ctx, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list api resources: %w", err)
+	}
+
+	ts := newTenantScopes(defs)
+	for _, r := range resources {
+		for _, s := range r.Scopes {
+			ts.resourceScopes[s] = true
+		}
+	}
+	return ts, nil
+}
+
+// newTenantScopes はスコープ定義から tenantScopes を作る。API リソースのスコープは含めない
+func newTenantScopes(defs []model.ScopeDefinition) *tenantScopes {
+	ts := &tenantScopes{
+		definitions:    make(map[string]*model.ScopeDefinition, len(defs)),
+		resourceScopes: map[string]bool{},
+	}
+	for i := range defs {
+		ts.definitions[defs[i].Name] = &defs[i]
+	}
+	return ts
+}
+
+// validate は要求されたスコープがテナントで利用でき、クライアントに許可されていることを検証する
+func (ts *tenantScopes) validate(client *model.Client, scopes []string) error {
+	for _, s := range scopes {
+		if !model.IsStandardScope(s) && ts.definitions[s] == nil && !ts.resourceScopes[s] {
+			return fmt.Errorf("unknown scope: %s", s)
+		}
+		if !client.AllowsScope(s) {
+			return fmt.Errorf("scope is not allowed for this client: %s", s)
+		}
+	}
+	return nil
+}
+
+// consentRequired は scopes のうちユーザーの同意が必要で、まだ同意されていないスコープの定義を返す
+func (ts *tenantScopes) consentRequired(scopes []string, consent *model.UserConsent) []*model.ScopeDefinition {
+	var pending []*model.ScopeDefinition
+	for _, s := range scopes {
+		if !ts.consentGranted(s, consent) {
+			pending = append(pending, ts.definitions[s])
+		}
+	}
+	return pending
+}
+
+// consentGranted はスコープの同意が不要か、ユーザーが同意済みであるかを返す
+func (ts *tenantScopes) consentGranted(scope string, consent *model.UserConsent) bool {
+	def := ts.definitions[scope]
+	return def == nil || !def.ConsentRequired || (consent != nil && containsScope(consent.Scopes, scope))
+}
+
+// filterClaimsRequest は claims パラメータで要求されたユーザークレームのうち、クレームを定義するスコープの
+// いずれかがクライアントに許可され、同意が必要な場合は同意済みのものだけを残す。
+// claims パラメータでスコープのポリシーと同意を迂回してクレームを取得させないため (OIDC Core 1.0 Section 5.5)。
+// カスタムスコープはクレームの値の出どころとなる属性で判定する。sub・acr などユーザー属性でないクレームはそのまま残す
+func (ts *tenantScopes) filterClaimsRequest(req *model.ClaimsRequest, tenant *model.Tenant, client *model.Client, consent *model.UserConsent) *model.ClaimsRequest {
+	if req == nil {
+		return nil
+	}
+
+	userClaims := map[string]bool{}
+	for _, name := range model.StandardUserClaims {
+		userClaims[name] = true
+	}
+	for _, name := range tenant.UserAttributeNames() {
+		userClaims[name] = true
+	}
+	granted := func(name string) bool {
+		for s, names := range scopeClaims {
+			if containsScope(names, name) && client.AllowsScope(s) {
+				return true
+			}
+		}
+		for s, def := range ts.definitions {
+			if !client.AllowsScope(s) || !ts.consentGranted(s, consent) {
+				continue
+			}
+			for _, m := range def.Claims {
+				if m.Attribute == name {
+					return true
+				}
+			}
+		}
+		return false
+	}
+	filter := func(members map[string]*model.ClaimRequest) map[string]*model.ClaimRequest {
+		if members == nil {
+			return nil
+		}
+		filtered := make(map[string]*model.ClaimRequest, len(members))
+		for name, cr := range members {
+			if !userClaims[name] || granted(name) {
+				filtered[name] = cr
+			}
+		}
+		return filtered
+	}
+	return &model.ClaimsRequest{UserInfo: filter(req.UserInfo), IDToken: filter(req.IDToken)}
+}
+
+// approvalConsent はデバイスフロー・CIBA の承認画面でユーザーが承認するスコープについて、同意が必要で未同意のスコープの定義と
+// 記録済みの同意を返す。認可リクエストの後でクライアントに許可されなくなったスコープがあれば ErrInvalidScope を返す
+func approvalConsent(ctx context.Context, scopeFinder ScopeDefinitionFinder, apiResourceFinder APIResourceFinder, consentStore UserConsentStore, client *model.Client, userID uuid.UUID, scope string) ([]*model.ScopeDefinition, *model.UserConsent, error) {
+	scopes := strings.Fields(scope)
+	ts, err := loadTenantScopes(ctx, scopeFinder, apiResourceFinder, client.TenantID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := ts.validate(client, scopes); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrInvalidScope, err)
+	}
+	consent, err := consentStore.FindByUserAndClient(ctx, userID, client.ID)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to find consent: %w", err)
+	}
+	return ts.consentRequired(scopes, consent), consent, nil
+}
+
+// saveConsent はユーザーが承認画面で承認したスコープのうち、同意が必要なスコープへの同意を記録済みの同意に追加する
+func saveConsent(ctx context.Context, consentStore UserConsentStore, userID uuid.UUID, client *model.Client, pending []*model.ScopeDefinition, existing *model.UserConsent) error {
+	if len(pending) == 0 {
+		return nil
+	}
+	var scopes []string
+	if existing != nil {
+		scopes = append(scopes, existing.Scopes...)
+	}
+	for _, def := range pending {
+		scopes = append(scopes, def.Name)
+	}
+	return consentStore.Save(ctx, &model.UserConsent{
+		UserID:   userID,
+		ClientID: client.ID,
+		Scopes:   model.StringSlice(uniqueSorted(scopes)),
+	})
+}
+
+// filterAllowedScopes はクライアントに許可されなくなったスコープを除く
+func filterAllowedScopes(client *model.Client, scopes []string) []string {
+	var allowed []string
+	for _, s := range scopes {
+		if client.AllowsScope(s) {
+			allowed = append(allowed, s)
+		}
+	}
+	return allowed
+}
@@ -0,0 +1,95 @@
+package oidc
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+func TestFilterClaimsRequest(t *testing.T) {
+	schema := `{"type":"object","properties":{"department":{"type":"string"},"grade":{"type":"integer"}}}`
+	tenant := &model.Tenant{UserAttributeSchema: &schema}
+	ts := newTenantScopes([]model.ScopeDefinition{
+		{Name: "employee", ConsentRequired: true, Claims: model.ScopeClaimMappings{{Claim: "dept", Attribute: "department"}}},
+		{Name: "hr", Claims: model.ScopeClaimMappings{{Claim: "grade_level", Attribute: "grade"}}},
+	})
+	clientID := uuid.New()
+	consented := &model.UserConsent{ClientID: clientID, Scopes: model.StringSlice{"employee"}}
+	requested := []string{"sub", "acr", "email", "name", "phone_number", "department", "grade"}
+
+	tests := []struct {
+		name    string
+		allowed model.StringSlice
+		consent *model.UserConsent
+		want    []string
+	}{
+		// 同意が必要なスコープの属性は同意がなければ返さない
+		{name: "スコープの制限なし・未同意", want: []string{"acr", "email", "grade", "name", "phone_number", "sub"}},
+		{name: "スコープの制限なし・同意済み", consent: consented, want: []string{"acr", "department", "email", "grade", "name", "phone_number", "sub"}},
+		// ユーザー属性でないクレームはスコープに関わらず残す
+		{name: "許可されたスコープのクレームのみ", allowed: model.StringSlice{"openid", "email", "employee"}, consent: consented, want: []string{"acr", "department", "email", "sub"}},
+		{name: "同意済みでもクライアントに許可されていないスコープ", allowed: model.StringSlice{"openid", "profile"}, consent: consented, want: []string{"acr", "name", "sub"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &model.Client{ID: clientID, AllowedScopes: tt.allowed}
+			req := &model.ClaimsRequest{UserInfo: map[string]*model.ClaimRequest{}, IDToken: map[string]*model.ClaimRequest{}}
+			for _, name := range requested {
+				req.UserInfo[name] = nil
+				req.IDToken[name] = &model.ClaimRequest{Essential: true}
+			}
+
+			filtered := ts.filterClaimsRequest(req, tenant, client, tt.consent)
+			for member, claims := range map[string]map[string]*model.ClaimRequest{"userinfo": filtered.UserInfo, "id_token": filtered.IDToken} {
+				var got []string
+				for name := range claims {
+					got = append(got, name)
+				}
+				sort.Strings(got)
+				if !reflect.DeepEqual(got, tt.want) {
+					t.Errorf("%s = %v, want %v", member, got, tt.want)
+				}
+			}
+			// 要求の内容 (essential など) は変えない
+			if cr := filtered.IDToken["sub"]; cr == nil || !cr.Essential {
+				t.Errorf("id_token.sub = %+v", cr)
+			}
+		})
+	}
+
+	if ts.filterClaimsRequest(nil, tenant, &model.Client{}, nil) != nil {
+		t.Error("nil request not preserved")
+	}
+	// 指定されなかったメンバーは nil のまま
+	if filtered := ts.filterClaimsRequest(&model.ClaimsRequest{IDToken: map[string]*model.ClaimRequest{"email": nil}}, tenant, &model.Client{}, nil); filtered.UserInfo != nil {
+		t.Errorf("userinfo = %v", filtered.UserInfo)
+	}
+}
+
+func TestConsentRequired(t *testing.T) {
+	ts := newTenantScopes([]model.ScopeDefinition{
+		{Name: "payments", ConsentRequired: true},
+		{Name: "accounts", ConsentRequired: true},
+		{Name: "reports"},
+	})
+	scopes := []string{"openid", "payments", "accounts", "reports", "api:read"}
+
+	names := func(defs []*model.ScopeDefinition) []string {
+		var list []string
+		for _, d := range defs {
+			list = append(list, d.Name)
+		}
+		return list
+	}
+	if got := names(ts.consentRequired(scopes, nil)); !reflect.DeepEqual(got, []string{"payments", "accounts"}) {
+		t.Errorf("no consent: %v", got)
+	}
+	consent := &model.UserConsent{Scopes: model.StringSlice{"payments"}}
+	if got := names(ts.consentRequired(scopes, consent)); !reflect.DeepEqual(got, []string{"accounts"}) {
+		t.Errorf("partial consent: %v", got)
+	}
+}
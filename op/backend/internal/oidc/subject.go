@@ -0,0 +1,133 @@
+package oidc
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// sectorIdentifierMaxBytes は sector_identifier_uri から読み込む文書の上限
+const sectorIdentifierMaxBytes = 64 * 1024
+
+// SubjectMapper はクライアントに公開する sub を決める。
+// pairwise クライアントにはセクターごとに異なる sub を発行し、逆引き用に対応を保存する。
+// 仕様参照: OIDC Core 1.0 Section 8
+type SubjectMapper struct {
+	tenantFinder  TenantFinder
+	clientFinder  ClientFinder
+	pairwiseStore PairwiseSubjectStore
+}
+
+func NewSubjectMapper(tenantFinder TenantFinder, clientFinder ClientFinder, pairwiseStore PairwiseSubjectStore) *SubjectMapper {
+	return &SubjectMapper{
+		tenantFinder:  tenantFinder,
+		clientFinder:  clientFinder,
+		pairwiseStore: pairwiseStore,
+	}
+}
+
+// Subject はクライアントに対して公開する sub を返す。public クライアントではユーザー ID をそのまま返す
+func (m *SubjectMapper) Subject(ctx context.Context, client *model.Client, userID uuid.UUID) (string, error) {
+	if !client.IsPairwise() {
+		return userID.String(), nil
+	}
+
+	// セクターをリダイレクト URI から求める場合は URI をロードする
+	if client.SectorIdentifierURI == nil && len(client.RedirectURIs) == 0 {
+		loaded, err := m.clientFinder.FindByClientIDWithRedirectURIs(ctx, client.ClientID)
+		if err != nil {
+			return "", fmt.Errorf("failed to load redirect uris: %w", err)
+		}
+		if loaded != nil {
+			client = loaded
+		}
+	}
+	sector := client.SectorIdentifier()
+	if sector == "" {
+		return "", fmt.Errorf("pairwise client %s has no sector identifier", client.ClientID)
+	}
+
+	tenant, err := m.tenantFinder.FindByID(ctx, client.TenantID)
+	if err != nil || tenant == nil {
+		return "", fmt.Errorf("failed to find tenant: %w", err)
+	}
+
+	subject := pairwiseSubject(tenant.PairwiseSalt, sector, userID)
+	if err := m.pairwiseStore.Save(ctx, &model.PairwiseSubject{
+		TenantID:         tenant.ID,
+		SectorIdentifier: sector,
+		UserID:           userID,
+		Subject:          subject,
+	}); err != nil {
+		return "", fmt.Errorf("failed to save pairwise subject: %w", err)
+	}
+	return subject, nil
+}
+
+// ResolveSubject は発行済みの sub からユーザー ID を逆引きする。見つからない場合は nil を返す
+func (m *SubjectMapper) ResolveSubject(ctx context.Context, tenantID uuid.UUID, subject string) (*uuid.UUID, error) {
+	// public sub はユーザー ID そのもの
+	if userID, err := uuid.Parse(subject); err == nil {
+		return &userID, nil
+	}
+
+	ps, err := m.pairwiseStore.FindBySubject(ctx, tenantID, subject)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find pairwise subject: %w", err)
+	}
+	if ps == nil {
+		return nil, nil
+	}
+	return &ps.UserID, nil
+}
+
+// pairwiseSubject はセクター・ユーザー ID・テナントのソルトから決定的に sub を計算する。
+// ソルトを知らない RP 同士では sub を突き合わせられない (OIDC Core 1.0 Section 8.1)
+func pairwiseSubject(salt, sector string, userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(sector))
+	mac.Write([]byte{0})
+	mac.Write([]byte(userID.String()))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+var sectorIdentifierHTTPClient = &http.Client{Timeout: 5 * time.Second}
+
+// FetchSectorIdentifier は sector_identifier_uri の文書 (リダイレクト URI の JSON 配列) を取得する。
+// 仕様参照: OIDC Registration 1.0 Section 5
+func FetchSectorIdentifier(ctx context.Context, uri string) ([]string, error) {
+	parsed, err := url.Parse(uri)
+	if err != nil || parsed.Scheme != "https" || parsed.Host == "" {
+		return nil, fmt.Errorf("sector_identifier_uri must be an https URL")
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, uri, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build sector_identifier_uri request: %w", err)
+	}
+	resp, err := sectorIdentifierHTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch sector_identifier_uri: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("sector_identifier_uri returned status %d", resp.StatusCode)
+	}
+
+	var uris []string
+	if err := json.NewDecoder(io.LimitReader(resp.Body, sectorIdentifierMaxBytes)).Decode(&uris); err != nil {
+		return nil, fmt.Errorf("sector_identifier_uri must return a JSON array of URIs")
+	}
+	return uris, nil
+}
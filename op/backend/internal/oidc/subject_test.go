@@ -0,0 +1,162 @@
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakePairwiseSubjectStore struct {
+	subjects []model.PairwiseSubject
+}
+
+func (f *fakePairwiseSubjectStore) Save(_ context.Context, s *model.PairwiseSubject) error {
+	for _, existing := range f.subjects {
+		if existing.TenantID == s.TenantID && existing.Subject == s.Subject {
+			return nil
+		}
+	}
+	f.subjects = append(f.subjects, *s)
+	return nil
+}
+
+func (f *fakePairwiseSubjectStore) FindBySubject(_ context.Context, tenantID uuid.UUID, subject string) (*model.PairwiseSubject, error) {
+	for i := range f.subjects {
+		if f.subjects[i].TenantID == tenantID && f.subjects[i].Subject == subject {
+			return &f.subjects[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func pairwiseClient(tenantID uuid.UUID, clientID string, redirectURIs ...string) *model.Client {
+	c := &model.Client{ID: uuid.New(), TenantID: tenantID, ClientID: clientID, SubjectType: model.SubjectTypePairwise}
+	for _, u := range redirectURIs {
+		c.RedirectURIs = append(c.RedirectURIs, model.RedirectURI{URI: u})
+	}
+	return c
+}
+
+func TestPairwiseSubject(t *testing.T) {
+	userID := uuid.New()
+	base := pairwiseSubject("salt", "rp.example.com", userID)
+	if base != pairwiseSubject("salt", "rp.example.com", userID) {
+		t.Fatal("pairwise sub must be deterministic")
+	}
+	for name, other := range map[string]string{
+		"セクター違い":  pairwiseSubject("salt", "other.example.com", userID),
+		"ソルト違い":   pairwiseSubject("other-salt", "rp.example.com", userID),
+		"ユーザー違い":  pairwiseSubject("salt", "rp.example.com", uuid.New()),
+		"区切りの曖昧さ": pairwiseSubject("salt", "rp.example.com"+userID.String()[:1], userID),
+	} {
+		if other == base {
+			t.Errorf("%s: sub collided", name)
+		}
+	}
+	if _, err := uuid.Parse(base); err == nil {
+		t.Error("pairwise sub must not look like a public sub")
+	}
+}
+
+func TestSubjectMapper(t *testing.T) {
+	tenant := &model.Tenant{ID: uuid.New(), PairwiseSalt: "salt"}
+	store := &fakePairwiseSubjectStore{}
+	// リダイレクト URI 未ロードのクライアントは clientFinder から読み直す
+	loaded := pairwiseClient(tenant.ID, "unloaded", "https://app.example.com/callback")
+	m := NewSubjectMapper(newFakeTenantFinder(tenant), &fakeClientFinder{clients: map[string]*model.Client{"unloaded": loaded}}, store)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	public := &model.Client{TenantID: tenant.ID, ClientID: "public"}
+	if sub, err := m.Subject(ctx, public, userID); err != nil || sub != userID.String() {
+		t.Fatalf("public sub = %q, err %v", sub, err)
+	}
+
+	sameSectorA := pairwiseClient(tenant.ID, "a", "https://app.example.com/a")
+	sameSectorB := pairwiseClient(tenant.ID, "b", "https://app.example.com/b")
+	sector := "https://sector.example.com/uris.json"
+	viaSectorURI := pairwiseClient(tenant.ID, "c", "https://app.example.com/c")
+	viaSectorURI.SectorIdentifierURI = &sector
+	unloaded := &model.Client{TenantID: tenant.ID, ClientID: "unloaded", SubjectType: model.SubjectTypePairwise}
+
+	subA, err := m.Subject(ctx, sameSectorA, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	subB, _ := m.Subject(ctx, sameSectorB, userID)
+	subC, _ := m.Subject(ctx, viaSectorURI, userID)
+	subUnloaded, err := m.Subject(ctx, unloaded, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if subA != subB || subA != subUnloaded {
+		t.Errorf("same sector must share sub: %q %q %q", subA, subB, subUnloaded)
+	}
+	if subA == subC {
+		t.Error("sector_identifier_uri host must change sub")
+	}
+	if subA == userID.String() {
+		t.Error("pairwise sub must not expose user id")
+	}
+
+	// 発行した sub から逆引きできる
+	for _, sub := range []string{subA, subC, userID.String()} {
+		got, err := m.ResolveSubject(ctx, tenant.ID, sub)
+		if err != nil || got == nil || *got != userID {
+			t.Errorf("ResolveSubject(%q) = %v, %v", sub, got, err)
+		}
+	}
+	// 他テナントや未知の sub は解決しない
+	if got, _ := m.ResolveSubject(ctx, uuid.New(), subA); got != nil {
+		t.Errorf("resolved sub in another tenant: %v", got)
+	}
+	if got, _ := m.ResolveSubject(ctx, tenant.ID, "unknown"); got != nil {
+		t.Errorf("resolved unknown sub: %v", got)
+	}
+
+	noSector := &model.Client{TenantID: tenant.ID, ClientID: "no-sector", SubjectType: model.SubjectTypePairwise}
+	if _, err := m.Subject(ctx, noSector, userID); err == nil {
+		t.Error("expected error for pairwise client without sector")
+	}
+}
+
+func TestFetchSectorIdentifier(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/uris.json":
+			_, _ = w.Write([]byte(`["https://a.example.com/cb","https://b.example.com/cb"]`))
+		case "/object.json":
+			_, _ = w.Write([]byte(`{"redirect_uris":[]}`))
+		case "/huge.json":
+			_, _ = w.Write([]byte(`["` + strings.Repeat("a", sectorIdentifierMaxBytes) + `"]`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	orig := sectorIdentifierHTTPClient
+	sectorIdentifierHTTPClient = srv.Client()
+	defer func() { sectorIdentifierHTTPClient = orig }()
+
+	got, err := FetchSectorIdentifier(context.Background(), srv.URL+"/uris.json")
+	if err != nil || !reflect.DeepEqual(got, []string{"https://a.example.com/cb", "https://b.example.com/cb"}) {
+		t.Fatalf("got %v, err %v", got, err)
+	}
+	for _, uri := range []string{
+		srv.URL + "/object.json",
+		srv.URL + "/huge.json",
+		srv.URL + "/missing.json",
+		strings.Replace(srv.URL, "https://", "http://", 1) + "/uris.json",
+	} {
+		if _, err := FetchSectorIdentifier(context.Background(), uri); err == nil {
+			t.Errorf("%s: expected error", uri)
+		}
+	}
+}
@@ -5,21 +5,32 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
+
+	infra_crypto "github.com/isurugi-k/oidc-demo/op/backend/internal/crypto"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
 
 type TokenHandler struct {
-	authCodeStore       AuthorizationCodeStore
-	accessTokenStore    AccessTokenStore
-	refreshTokenStore   RefreshTokenStore
-	idTokenCreator      IDTokenCreator
-	clientFinder        ClientFinder
-	tenantFinder        TenantFinder
-	tokenSigner         TokenSigner
-	verifyPassword      VerifyPasswordFunc
-	verifyCodeChallenge VerifyCodeChallengeFunc
-	computeATHash       ComputeATHashFunc
-	sha256Hex           SHA256HexFunc
-	issuerBaseURL       string
+	authCodeStore        AuthorizationCodeStore
+	accessTokenStore     AccessTokenStore
+	refreshTokenStore    RefreshTokenStore
+	idTokenCreator       IDTokenCreator
+	idTokenFinder        IDTokenFinder
+	deviceCodeStore      DeviceCodeStore
+	backchannelAuthStore BackchannelAuthRequestStore
+	apiResourceFinder    APIResourceFinder
+	clientAuthenticator  *ClientAuthenticator
+	certExtractor        *ClientCertificateExtractor
+	subjectMapper        *SubjectMapper
+	tenantFinder         TenantFinder
+	userFinder           UserFinder
+	tokenSigner          TokenSigner
+	tokenValidator       TokenValidator
+	verifyCodeChallenge  VerifyCodeChallengeFunc
+	computeATHash        ComputeATHashFunc
+	sha256Hex            SHA256HexFunc
+	encryptJWT           EncryptJWTFunc
+	issuerBaseURL        string
 }
 
 func NewTokenHandler(
@@ -27,28 +38,44 @@ func NewTokenHandler(
 	accessTokenStore AccessTokenStore,
 	refreshTokenStore RefreshTokenStore,
 	idTokenCreator IDTokenCreator,
-	clientFinder ClientFinder,
+	idTokenFinder IDTokenFinder,
+	deviceCodeStore DeviceCodeStore,
+	backchannelAuthStore BackchannelAuthRequestStore,
+	apiResourceFinder APIResourceFinder,
+	clientAuthenticator *ClientAuthenticator,
+	certExtractor *ClientCertificateExtractor,
+	subjectMapper *SubjectMapper,
 	tenantFinder TenantFinder,
+	userFinder UserFinder,
 	tokenSigner TokenSigner,
-	verifyPassword VerifyPasswordFunc,
+	tokenValidator TokenValidator,
 	verifyCodeChallenge VerifyCodeChallengeFunc,
 	computeATHash ComputeATHashFunc,
 	sha256Hex SHA256HexFunc,
+	encryptJWT EncryptJWTFunc,
 	issuerBaseURL string,
 ) *TokenHandler {
 	return &TokenHandler{
-		authCodeStore:       authCodeStore,
-		accessTokenStore:    accessTokenStore,
-		refreshTokenStore:   refreshTokenStore,
-		idTokenCreator:      idTokenCreator,
-		clientFinder:        clientFinder,
-		tenantFinder:        tenantFinder,
-		tokenSigner:         tokenSigner,
-		verifyPassword:      verifyPassword,
-		verifyCodeChallenge: verifyCodeChallenge,
-		computeATHash:       computeATHash,
-		sha256Hex:           sha256Hex,
-		issuerBaseURL:       issuerBaseURL,
+		authCodeStore:        authCodeStore,
+		accessTokenStore:     accessTokenStore,
+		refreshTokenStore:    refreshTokenStore,
+		idTokenCreator:       idTokenCreator,
+		idTokenFinder:        idTokenFinder,
+		deviceCodeStore:      deviceCodeStore,
+		backchannelAuthStore: backchannelAuthStore,
+		apiResourceFinder:    apiResourceFinder,
+		clientAuthenticator:  clientAuthenticator,
+		certExtractor:        certExtractor,
+		subjectMapper:        subjectMapper,
+		tenantFinder:         tenantFinder,
+		userFinder:           userFinder,
+		tokenSigner:          tokenSigner,
+		tokenValidator:       tokenValidator,
+		verifyCodeChallenge:  verifyCodeChallenge,
+		computeATHash:        computeATHash,
+		sha256Hex:            sha256Hex,
+		encryptJWT:           encryptJWT,
+		issuerBaseURL:        issuerBaseURL,
 	}
 }
 
@@ -66,16 +93,22 @@ func (h *TokenHandler) Handle(c echo.Context) error {
 		return h.handleAuthCodeGrant(c)
 	case "refresh_token":
 		return h.handleRefreshTokenGrant(c)
+	case deviceCodeGrantType:
+		return h.handleDeviceCodeGrant(c)
+	case tokenExchangeGrantType:
+		return h.handleTokenExchangeGrant(c)
+	case cibaGrantType:
+		return h.handleCIBAGrant(c)
 	default:
 		return tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "")
 	}
 }
 
 func (h *TokenHandler) handleAuthCodeGrant(c echo.Context) error {
-	// クライアント認証: client_secret_post または client_secret_basic
-	clientID, clientSecret := extractClientCredentials(c)
-	if clientID == "" || clientSecret == "" {
-		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client credentials required")
+	// クライアント認証: クライアントが登録した token_endpoint_auth_method で認証する
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
 	}
 
 	code := c.FormValue("code")
@@ -86,12 +119,23 @@ func (h *TokenHandler) handleAuthCodeGrant(c echo.Context) error {
 		return tokenError(c, http.StatusBadRequest, "invalid_request", "code is required")
 	}
 
+	resources, ok := h.resourceParams(c)
+	if !ok {
+		return tokenError(c, http.StatusBadRequest, "invalid_target", "resource must be an absolute URI without a fragment")
+	}
+
+	certThumbprint, err := h.certificateBinding(c, client)
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
 	resp, err := h.handleAuthCodeGrantLogic(c.Request().Context(), &AuthCodeGrantInput{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		Code:         code,
-		RedirectURI:  redirectURI,
-		CodeVerifier: codeVerifier,
+		Client:         client,
+		CertThumbprint: certThumbprint,
+		Code:           code,
+		RedirectURI:    redirectURI,
+		CodeVerifier:   codeVerifier,
+		Resources:      resources,
 	})
 	if err != nil {
 		if errors.Is(err, ErrInvalidClient) {
@@ -100,6 +144,9 @@ func (h *TokenHandler) handleAuthCodeGrant(c echo.Context) error {
 		if errors.Is(err, ErrInvalidGrant) {
 			return tokenError(c, http.StatusBadRequest, "invalid_grant", "")
 		}
+		if errors.Is(err, ErrInvalidTarget) || errors.Is(err, ErrInvalidScope) {
+			return tokenError(c, http.StatusBadRequest, err.Error(), "")
+		}
 		c.Logger().Errorf("token error: %v", err)
 		return tokenError(c, http.StatusInternalServerError, "server_error", "")
 	}
@@ -108,9 +155,9 @@ func (h *TokenHandler) handleAuthCodeGrant(c echo.Context) error {
 }
 
 func (h *TokenHandler) handleRefreshTokenGrant(c echo.Context) error {
-	clientID, clientSecret := extractClientCredentials(c)
-	if clientID == "" || clientSecret == "" {
-		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client credentials required")
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
 	}
 
 	refreshToken := c.FormValue("refresh_token")
@@ -120,11 +167,29 @@ func (h *TokenHandler) handleRefreshTokenGrant(c echo.Context) error {
 
 	scope := c.FormValue("scope")
 
+	resources, ok := h.resourceParams(c)
+	if !ok {
+		return tokenError(c, http.StatusBadRequest, "invalid_target", "resource must be an absolute URI without a fragment")
+	}
+
+	// authorization_details は認可済みの範囲への絞り込みとしてのみ受け付ける (RFC 9396 Section 6.2)
+	details, err := decodeAuthorizationDetails(c.FormValue("authorization_details"))
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, ErrInvalidAuthorizationDetails.Error(), "")
+	}
+
+	certThumbprint, err := h.certificateBinding(c, client)
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
 	resp, err := h.handleRefreshTokenGrantLogic(c.Request().Context(), &RefreshTokenGrantInput{
-		ClientID:     clientID,
-		ClientSecret: clientSecret,
-		RefreshToken: refreshToken,
-		Scope:        scope,
+		Client:               client,
+		CertThumbprint:       certThumbprint,
+		RefreshToken:         refreshToken,
+		Scope:                scope,
+		Resources:            resources,
+		AuthorizationDetails: details,
 	})
 	if err != nil {
 		if errors.Is(err, ErrInvalidClient) {
@@ -133,6 +198,9 @@ func (h *TokenHandler) handleRefreshTokenGrant(c echo.Context) error {
 		if errors.Is(err, ErrInvalidGrant) {
 			return tokenError(c, http.StatusBadRequest, "invalid_grant", "")
 		}
+		if errors.Is(err, ErrInvalidTarget) || errors.Is(err, ErrInvalidScope) || errors.Is(err, ErrInvalidAuthorizationDetails) {
+			return tokenError(c, http.StatusBadRequest, err.Error(), "")
+		}
 		if errors.Is(err, ErrUnsupportedGrantType) {
 			return tokenError(c, http.StatusBadRequest, "unsupported_grant_type", "")
 		}
@@ -143,22 +211,191 @@ func (h *TokenHandler) handleRefreshTokenGrant(c echo.Context) error {
 	return c.JSON(http.StatusOK, resp)
 }
 
-// extractClientCredentials は client_secret_post と client_secret_basic の両方をサポートする
-func extractClientCredentials(c echo.Context) (clientID, clientSecret string) {
-	// client_secret_post
-	clientID = c.FormValue("client_id")
-	clientSecret = c.FormValue("client_secret")
-	if clientID != "" && clientSecret != "" {
-		return
+func (h *TokenHandler) handleDeviceCodeGrant(c echo.Context) error {
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
+	}
+
+	deviceCode := c.FormValue("device_code")
+	if deviceCode == "" {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "device_code is required")
 	}
 
-	// client_secret_basic (Authorization: Basic base64(client_id:client_secret))
-	clientID, clientSecret, ok := c.Request().BasicAuth()
-	if ok && clientID != "" && clientSecret != "" {
-		return
+	certThumbprint, err := h.certificateBinding(c, client)
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	resp, err := h.handleDeviceCodeGrantLogic(c.Request().Context(), &DeviceCodeGrantInput{
+		Client:         client,
+		CertThumbprint: certThumbprint,
+		DeviceCode:     deviceCode,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAuthorizationPending), errors.Is(err, ErrSlowDown),
+			errors.Is(err, ErrAccessDenied), errors.Is(err, ErrExpiredToken),
+			errors.Is(err, ErrInvalidGrant), errors.Is(err, ErrUnauthorizedClient):
+			// エラーコードはセンチネルエラーのメッセージと一致させている (RFC 8628 Section 3.5)
+			return tokenError(c, http.StatusBadRequest, err.Error(), "")
+		}
+		c.Logger().Errorf("device code token error: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *TokenHandler) handleCIBAGrant(c echo.Context) error {
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
+	}
+
+	authReqID := c.FormValue("auth_req_id")
+	if authReqID == "" {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "auth_req_id is required")
+	}
+
+	certThumbprint, err := h.certificateBinding(c, client)
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	resp, err := h.handleCIBAGrantLogic(c.Request().Context(), &CIBAGrantInput{
+		Client:         client,
+		CertThumbprint: certThumbprint,
+		AuthReqID:      authReqID,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrAuthorizationPending), errors.Is(err, ErrSlowDown),
+			errors.Is(err, ErrAccessDenied), errors.Is(err, ErrExpiredToken),
+			errors.Is(err, ErrInvalidGrant), errors.Is(err, ErrUnauthorizedClient):
+			// エラーコードはセンチネルエラーのメッセージと一致させている (CIBA Core 1.0 Section 11)
+			return tokenError(c, http.StatusBadRequest, err.Error(), "")
+		}
+		c.Logger().Errorf("CIBA token error: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
 	}
 
-	return "", ""
+	return c.JSON(http.StatusOK, resp)
+}
+
+func (h *TokenHandler) handleTokenExchangeGrant(c echo.Context) error {
+	client, err := h.clientAuthenticator.Authenticate(c)
+	if err != nil {
+		return clientAuthError(c, err)
+	}
+
+	subjectToken := c.FormValue("subject_token")
+	subjectTokenType := c.FormValue("subject_token_type")
+	if subjectToken == "" || subjectTokenType == "" {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "subject_token and subject_token_type are required")
+	}
+	if !isSupportedExchangeTokenType(subjectTokenType) {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "unsupported subject_token_type")
+	}
+
+	// actor_token_type は actor_token がある場合のみ指定する (MUST: RFC 8693 Section 2.1)
+	actorToken := c.FormValue("actor_token")
+	actorTokenType := c.FormValue("actor_token_type")
+	if (actorToken == "") != (actorTokenType == "") {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "actor_token and actor_token_type must be provided together")
+	}
+	if actorToken != "" && !isSupportedExchangeTokenType(actorTokenType) {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "unsupported actor_token_type")
+	}
+
+	// 発行できるのはアクセストークンのみ
+	if rtt := c.FormValue("requested_token_type"); rtt != "" && rtt != tokenTypeAccessToken {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "unsupported requested_token_type")
+	}
+
+	form, err := c.FormParams()
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", "")
+	}
+
+	certThumbprint, err := h.certificateBinding(c, client)
+	if err != nil {
+		return tokenError(c, http.StatusBadRequest, "invalid_request", err.Error())
+	}
+
+	// 証明書バインドされた subject_token の確認には、クライアントのバインド設定によらず提示された証明書を使う
+	var presentedThumbprint string
+	if cert, err := h.certExtractor.Extract(c); err == nil && cert != nil {
+		presentedThumbprint = CertificateThumbprint(cert)
+	}
+
+	resp, err := h.handleTokenExchangeGrantLogic(c.Request().Context(), &TokenExchangeGrantInput{
+		Client:           client,
+		SubjectToken:     subjectToken,
+		SubjectTokenType: subjectTokenType,
+		ActorToken:       actorToken,
+		ActorTokenType:   actorTokenType,
+		// resource (RFC 8707) も audience と同様に扱う (RFC 8693 Section 2.1)
+		Audiences:               append(form["audience"], form["resource"]...),
+		Scope:                   c.FormValue("scope"),
+		CertThumbprint:          certThumbprint,
+		PresentedCertThumbprint: presentedThumbprint,
+	})
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvalidGrant), errors.Is(err, ErrUnauthorizedClient),
+			errors.Is(err, ErrInvalidTarget), errors.Is(err, ErrInvalidScope):
+			return tokenError(c, http.StatusBadRequest, err.Error(), "")
+		}
+		c.Logger().Errorf("token exchange error: %v", err)
+		return tokenError(c, http.StatusInternalServerError, "server_error", "")
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// resourceParams はフォームの resource パラメータを検証して返す
+func (h *TokenHandler) resourceParams(c echo.Context) ([]string, bool) {
+	form, err := c.FormParams()
+	if err != nil {
+		return nil, false
+	}
+	return parseResourceParams(form["resource"])
+}
+
+// isSupportedExchangeTokenType は subject_token / actor_token として受け付けるトークン種別か判定する
+func isSupportedExchangeTokenType(tokenType string) bool {
+	return tokenType == tokenTypeAccessToken || tokenType == tokenTypeIDToken
+}
+
+// certificateBinding は証明書バインドが有効なクライアントについて、提示されたクライアント証明書の x5t#S256 を返す。
+// バインドが無効なクライアントでは空文字を返す (RFC 8705 Section 3)。
+func (h *TokenHandler) certificateBinding(c echo.Context, client *model.Client) (string, error) {
+	if !client.TLSClientCertificateBoundAccessTokens {
+		return "", nil
+	}
+	cert, err := h.certExtractor.Extract(c)
+	if err != nil || cert == nil {
+		return "", errors.New("client certificate is required for certificate-bound access tokens")
+	}
+	return CertificateThumbprint(cert), nil
+}
+
+// clientAuthError はクライアント認証失敗をトークンエラーレスポンスに変換する (RFC 6749 Section 5.2)
+func clientAuthError(c echo.Context, err error) error {
+	if errors.Is(err, ErrInvalidClient) {
+		if _, _, ok := c.Request().BasicAuth(); ok {
+			c.Response().Header().Set("WWW-Authenticate", `Basic realm="token"`)
+		}
+		return tokenError(c, http.StatusUnauthorized, "invalid_client", "client authentication failed")
+	}
+	// client_secret のハッシュの計算が混み合っている場合は再試行できるよう 503 を返す
+	if errors.Is(err, infra_crypto.ErrPasswordHasherBusy) {
+		c.Logger().Warnf("client authentication error: %v", err)
+		return tokenError(c, http.StatusServiceUnavailable, "temporarily_unavailable", "")
+	}
+	c.Logger().Errorf("client authentication error: %v", err)
+	return tokenError(c, http.StatusInternalServerError, "server_error", "")
 }
 
 func tokenError(c echo.Context, status int, errCode, errDescription string) error {
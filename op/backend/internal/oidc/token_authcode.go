@@ -3,46 +3,39 @@ package oidc
 import (
 	"context"
 	"fmt"
-	"time"
 
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
 
 // AuthCodeGrantInput は認可コードグラントの入力
 type AuthCodeGrantInput struct {
-	ClientID     string
-	ClientSecret string
+	Client       *model.Client
 	Code         string
 	RedirectURI  string
 	CodeVerifier string
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+	// Resources はトークンリクエストの resource (RFC 8707)
+	Resources []string
 }
 
 // TokenResponse はトークンレスポンス
 type TokenResponse struct {
-	AccessToken  string `json:"access_token"`
-	TokenType    string `json:"token_type"`
-	ExpiresIn    int    `json:"expires_in"`
-	RefreshToken string `json:"refresh_token,omitempty"`
-	IDToken      string `json:"id_token,omitempty"`
-	Scope        string `json:"scope"`
+	AccessToken string `json:"access_token"`
+	// IssuedTokenType はトークン交換のレスポンスでのみ返す (RFC 8693 Section 2.2.1)
+	IssuedTokenType string `json:"issued_token_type,omitempty"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int    `json:"expires_in"`
+	RefreshToken    string `json:"refresh_token,omitempty"`
+	IDToken         string `json:"id_token,omitempty"`
+	Scope           string `json:"scope"`
+	// AuthorizationDetails は認可された authorization_details (RFC 9396 Section 7)
+	AuthorizationDetails model.AuthorizationDetails `json:"authorization_details,omitempty"`
 }
 
 // handleAuthCodeGrantLogic は認可コードグラントのビジネスロジック
 func (h *TokenHandler) handleAuthCodeGrantLogic(ctx context.Context, input *AuthCodeGrantInput) (*TokenResponse, error) {
-	// クライアント認証
-	client, err := h.clientFinder.FindByClientID(ctx, input.ClientID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find client: %w", err)
-	}
-	if client == nil || client.Status != "active" {
-		return nil, ErrInvalidClient
-	}
-
-	// client_secret 検証
-	match, err := h.verifyPassword(input.ClientSecret, client.ClientSecretHash)
-	if err != nil || !match {
-		return nil, ErrInvalidClient
-	}
+	client := input.Client
 
 	// 認可コード検証
 	authCode, err := h.authCodeStore.FindByCode(ctx, input.Code)
@@ -75,6 +68,11 @@ func (h *TokenHandler) handleAuthCodeGrantLogic(ctx context.Context, input *Auth
 		return nil, ErrInvalidGrant
 	}
 
+	// 公開クライアントは PKCE 必須 (MUST: RFC 9700 Section 2.1.1)
+	if client.IsPublic() && (authCode.CodeChallenge == nil || *authCode.CodeChallenge == "") {
+		return nil, ErrInvalidGrant
+	}
+
 	// PKCE 検証
 	if authCode.CodeChallenge != nil && *authCode.CodeChallenge != "" {
 		if input.CodeVerifier == "" {
@@ -85,6 +83,12 @@ func (h *TokenHandler) handleAuthCodeGrantLogic(ctx context.Context, input *Auth
 		}
 	}
 
+	// aud にする API リソースの決定 (RFC 8707 Section 2.2)。不正な場合は認可コードを消費しない
+	resource, accessScope, err := h.selectResource(ctx, client.TenantID, authCode.Resources, input.Resources, authCode.Scope)
+	if err != nil {
+		return nil, err
+	}
+
 	// 認可コードを使用済みにマーク
 	if err := h.authCodeStore.MarkAsUsed(ctx, authCode.ID); err != nil {
 		return nil, fmt.Errorf("failed to mark auth code as used: %w", err)
@@ -96,88 +100,18 @@ func (h *TokenHandler) handleAuthCodeGrantLogic(ctx context.Context, input *Auth
 		return nil, fmt.Errorf("failed to find tenant: %w", err)
 	}
 
-	issuer := h.issuerBaseURL + "/" + tenant.Code
-	userID := authCode.Session.UserID.String()
-
-	// アクセストークン生成
-	accessTokenLifetime := time.Duration(tenant.AccessTokenLifetime) * time.Second
-	accessJTI, accessTokenStr, err := h.tokenSigner.SignAccessToken(ctx, &model.AccessTokenClaims{
-		Issuer:    issuer,
-		Subject:   userID,
-		Audience:  client.ClientID,
-		Scope:     authCode.Scope,
-		SessionID: authCode.SessionID.String(),
-	}, accessTokenLifetime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to sign access token: %w", err)
-	}
-
-	// アクセストークンDB保存
-	accessToken := &model.AccessToken{
-		JTI:       accessJTI,
-		SessionID: authCode.SessionID,
-		ClientID:  client.ID,
-		Scope:     authCode.Scope,
-		ExpiresAt: time.Now().Add(accessTokenLifetime),
-	}
-	if err := h.accessTokenStore.Create(ctx, accessToken); err != nil {
-		return nil, fmt.Errorf("failed to save access token: %w", err)
-	}
-
-	// IDトークン生成 (at_hash 含む)
-	atHash := h.computeATHash(accessTokenStr)
-	idTokenLifetime := time.Duration(tenant.IDTokenLifetime) * time.Second
-	idTokenJTI, idTokenStr, err := h.tokenSigner.SignIDToken(ctx, &model.IDTokenClaims{
-		Issuer:   issuer,
-		Subject:  userID,
-		Audience: client.ClientID,
-		Nonce:    authCode.Nonce,
-		AuthTime: authCode.Session.CreatedAt,
-		ATHash:   atHash,
-	}, idTokenLifetime)
-	if err != nil {
-		return nil, fmt.Errorf("failed to sign ID token: %w", err)
-	}
-
-	// IDトークンDB保存
-	idToken := &model.IDToken{
-		JTI:       idTokenJTI,
-		SessionID: authCode.SessionID,
-		ClientID:  client.ID,
-		Nonce:     authCode.Nonce,
-		ExpiresAt: time.Now().Add(idTokenLifetime),
-	}
-	if err := h.idTokenCreator.Create(ctx, idToken); err != nil {
-		return nil, fmt.Errorf("failed to save ID token: %w", err)
-	}
-
-	// リフレッシュトークン生成 (offline_access スコープまたはrefresh_token grant対応時)
-	var refreshTokenStr string
-	if client.HasGrantType("refresh_token") {
-		var tokenHash string
-		refreshTokenStr, tokenHash, err = h.tokenSigner.GenerateRefreshToken()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate refresh token: %w", err)
-		}
-
-		refreshTokenLifetime := time.Duration(tenant.RefreshTokenLifetime) * time.Second
-		refreshToken := &model.RefreshToken{
-			TokenHash:     tokenHash,
-			SessionID:     authCode.SessionID,
-			AccessTokenID: accessToken.ID,
-			ExpiresAt:     time.Now().Add(refreshTokenLifetime),
-		}
-		if err := h.refreshTokenStore.Create(ctx, refreshToken); err != nil {
-			return nil, fmt.Errorf("failed to save refresh token: %w", err)
-		}
-	}
-
-	return &TokenResponse{
-		AccessToken:  accessTokenStr,
-		TokenType:    "Bearer",
-		ExpiresIn:    tenant.AccessTokenLifetime,
-		RefreshToken: refreshTokenStr,
-		IDToken:      idTokenStr,
-		Scope:        authCode.Scope,
-	}, nil
+	return h.issueTokens(ctx, &tokenIssueParams{
+		Tenant:               tenant,
+		Client:               client,
+		Session:              &authCode.Session,
+		Scope:                authCode.Scope,
+		AccessScope:          accessScope,
+		Resource:             resource,
+		Resources:            authCode.Resources,
+		Nonce:                authCode.Nonce,
+		Claims:               authCode.Claims,
+		CertThumbprint:       input.CertThumbprint,
+		AuthorizationDetails: authCode.AuthorizationDetails,
+		IssueIDToken:         true,
+	})
 }
@@ -0,0 +1,94 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// CIBAGrantInput は CIBA グラントの入力
+type CIBAGrantInput struct {
+	Client    *model.Client
+	AuthReqID string
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+}
+
+// handleCIBAGrantLogic は CIBA グラントのビジネスロジック。
+// poll モードではユーザーの承認が済むまでポーリング状態に応じたエラーを返す。
+// ping モードでも通知前の問い合わせには同じエラーを返す。
+// 仕様参照: CIBA Core 1.0 Section 10.1, 11
+func (h *TokenHandler) handleCIBAGrantLogic(ctx context.Context, input *CIBAGrantInput) (*TokenResponse, error) {
+	client := input.Client
+
+	if !client.HasGrantType(cibaGrantType) || client.BackchannelTokenDeliveryMode == nil {
+		return nil, ErrUnauthorizedClient
+	}
+
+	req, err := h.backchannelAuthStore.FindByAuthReqIDHash(ctx, h.sha256Hex(input.AuthReqID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find backchannel auth request: %w", err)
+	}
+	if req == nil || req.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+	if req.IsExpired() {
+		return nil, ErrExpiredToken
+	}
+
+	// ポーリング間隔より早い問い合わせは間隔を延長する (CIBA Core 1.0 Section 11)
+	now := time.Now()
+	interval := req.PollInterval
+	tooFast := req.LastPolledAt != nil && now.Sub(*req.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooFast {
+		interval += deviceSlowDownIncrement
+	}
+	if err := h.backchannelAuthStore.UpdatePolling(ctx, req.ID, now, interval); err != nil {
+		return nil, fmt.Errorf("failed to update backchannel auth request polling: %w", err)
+	}
+
+	switch req.Status {
+	case model.BackchannelAuthStatusPending:
+		if tooFast {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	case model.BackchannelAuthStatusDenied:
+		return nil, ErrAccessDenied
+	case model.BackchannelAuthStatusApproved:
+	default:
+		// トークン発行済みの auth_req_id は再利用できない
+		return nil, ErrInvalidGrant
+	}
+
+	// 並行リクエストで二重発行しないよう、発行済みへの遷移に成功した場合のみ続行する
+	consumed, err := h.backchannelAuthStore.MarkConsumed(ctx, req.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark backchannel auth request as consumed: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidGrant
+	}
+
+	// 承認後にログアウト・失効したセッションではトークンを発行しない
+	if req.Session == nil || !req.Session.IsValid() || req.Session.UserID != req.UserID {
+		return nil, ErrInvalidGrant
+	}
+
+	tenant, err := h.tenantFinder.FindByID(ctx, req.Session.TenantID)
+	if err != nil || tenant == nil {
+		return nil, fmt.Errorf("failed to find tenant: %w", err)
+	}
+
+	// scope には openid が必須のため ID トークンを常に発行する (CIBA Core 1.0 Section 10.1.1)
+	return h.issueTokens(ctx, &tokenIssueParams{
+		Tenant:         tenant,
+		Client:         client,
+		Session:        req.Session,
+		Scope:          req.Scope,
+		CertThumbprint: input.CertThumbprint,
+		IssueIDToken:   true,
+	})
+}
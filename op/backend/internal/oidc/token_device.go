@@ -0,0 +1,96 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// deviceSlowDownIncrement は slow_down 応答ごとに延長するポーリング間隔（秒） (MUST: RFC 8628 Section 3.5)
+const deviceSlowDownIncrement = 5
+
+// DeviceCodeGrantInput はデバイスコードグラントの入力
+type DeviceCodeGrantInput struct {
+	Client     *model.Client
+	DeviceCode string
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+}
+
+// handleDeviceCodeGrantLogic はデバイスコードグラントのビジネスロジック。
+// ユーザーの承認が済むまではポーリング状態に応じたエラーを返す。
+// 仕様参照: RFC 8628 Section 3.4, 3.5
+func (h *TokenHandler) handleDeviceCodeGrantLogic(ctx context.Context, input *DeviceCodeGrantInput) (*TokenResponse, error) {
+	client := input.Client
+
+	if !client.HasGrantType(deviceCodeGrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	dc, err := h.deviceCodeStore.FindByDeviceCodeHash(ctx, h.sha256Hex(input.DeviceCode))
+	if err != nil {
+		return nil, fmt.Errorf("failed to find device code: %w", err)
+	}
+	if dc == nil || dc.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+	if dc.IsExpired() {
+		return nil, ErrExpiredToken
+	}
+
+	// ポーリング間隔より早い問い合わせは間隔を延長する
+	now := time.Now()
+	interval := dc.PollInterval
+	tooFast := dc.LastPolledAt != nil && now.Sub(*dc.LastPolledAt) < time.Duration(interval)*time.Second
+	if tooFast {
+		interval += deviceSlowDownIncrement
+	}
+	if err := h.deviceCodeStore.UpdatePolling(ctx, dc.ID, now, interval); err != nil {
+		return nil, fmt.Errorf("failed to update device code polling: %w", err)
+	}
+
+	switch dc.Status {
+	case model.DeviceCodeStatusPending:
+		if tooFast {
+			return nil, ErrSlowDown
+		}
+		return nil, ErrAuthorizationPending
+	case model.DeviceCodeStatusDenied:
+		return nil, ErrAccessDenied
+	case model.DeviceCodeStatusApproved:
+	default:
+		// トークン発行済みの device_code は再利用できない
+		return nil, ErrInvalidGrant
+	}
+
+	// 並行ポーリングで二重発行しないよう、発行済みへの遷移に成功した場合のみ続行する
+	consumed, err := h.deviceCodeStore.MarkConsumed(ctx, dc.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to mark device code as consumed: %w", err)
+	}
+	if !consumed {
+		return nil, ErrInvalidGrant
+	}
+
+	// 承認後にログアウト・失効したセッションではトークンを発行しない
+	if dc.Session == nil || !dc.Session.IsValid() {
+		return nil, ErrInvalidGrant
+	}
+
+	tenant, err := h.tenantFinder.FindByID(ctx, dc.Session.TenantID)
+	if err != nil || tenant == nil {
+		return nil, fmt.Errorf("failed to find tenant: %w", err)
+	}
+
+	return h.issueTokens(ctx, &tokenIssueParams{
+		Tenant:         tenant,
+		Client:         client,
+		Session:        dc.Session,
+		Scope:          dc.Scope,
+		CertThumbprint: input.CertThumbprint,
+		IssueIDToken:   containsScope(strings.Fields(dc.Scope), "openid"),
+	})
+}
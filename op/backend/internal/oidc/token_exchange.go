@@ -0,0 +1,265 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+const (
+	// tokenExchangeGrantType はトークン交換の grant_type (RFC 8693 Section 2.1)
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+
+	// トークン種別識別子 (RFC 8693 Section 3)
+	tokenTypeAccessToken = "urn:ietf:params:oauth:token-type:access_token"
+	tokenTypeIDToken     = "urn:ietf:params:oauth:token-type:id_token"
+)
+
+// TokenExchangeGrantInput はトークン交換グラントの入力
+type TokenExchangeGrantInput struct {
+	Client           *model.Client
+	SubjectToken     string
+	SubjectTokenType string
+	ActorToken       string
+	ActorTokenType   string
+	Audiences        []string
+	Scope            string
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+	// PresentedCertThumbprint は提示されたクライアント証明書の x5t#S256。証明書が無ければ空
+	PresentedCertThumbprint string
+}
+
+// exchangeToken は subject_token / actor_token の検証結果
+type exchangeToken struct {
+	Issuer  string
+	Subject string
+	Session *model.Session
+	// Scope は元のアクセストークンのスコープ。ID トークンの場合は発行元の認可で付与されたスコープ
+	Scope  string
+	Actor  *model.ActorClaim
+	MayAct *model.ActorClaim
+	// ClientID と CertThumbprint はアクセストークンの発行先と cnf.x5t#S256。ID トークンでは空
+	ClientID       string
+	CertThumbprint string
+}
+
+// presentableBy はクライアントがこのトークンを交換に使えるか判定する。
+// 証明書バインドされたトークンは、発行先のクライアント自身か、同じ証明書を提示したクライアントに限る (RFC 8705 Section 3)
+func (t *exchangeToken) presentableBy(client *model.Client, certThumbprint string) bool {
+	if t.CertThumbprint == "" || t.ClientID == client.ClientID {
+		return true
+	}
+	return certThumbprint == t.CertThumbprint
+}
+
+// handleTokenExchangeGrantLogic はトークン交換グラントのビジネスロジック。
+// subject_token と同じセッションに紐付けたアクセストークンを発行するため、セッション単位の失効が交換後のトークンにも及ぶ。
+// 仕様参照: RFC 8693 Section 2
+func (h *TokenHandler) handleTokenExchangeGrantLogic(ctx context.Context, input *TokenExchangeGrantInput) (*TokenResponse, error) {
+	client := input.Client
+
+	if !client.HasGrantType(tokenExchangeGrantType) {
+		return nil, ErrUnauthorizedClient
+	}
+
+	tenant, err := h.tenantFinder.FindByID(ctx, client.TenantID)
+	if err != nil || tenant == nil {
+		return nil, fmt.Errorf("failed to find tenant: %w", err)
+	}
+	issuer := h.issuerBaseURL + "/" + tenant.Code
+
+	// subject_token: 同じテナントで発行され、セッションが有効であること
+	subject, err := h.resolveExchangeToken(ctx, input.SubjectToken, input.SubjectTokenType)
+	if err != nil {
+		return nil, err
+	}
+	if subject == nil || subject.Issuer != issuer || subject.Session.TenantID != tenant.ID {
+		return nil, ErrInvalidGrant
+	}
+	if !subject.presentableBy(client, input.PresentedCertThumbprint) {
+		return nil, ErrInvalidGrant
+	}
+
+	// actor_token が無い場合は要求元クライアント自身がアクターとなる
+	actor := &model.ActorClaim{ClientID: client.ClientID}
+	if input.ActorToken != "" {
+		at, err := h.resolveExchangeToken(ctx, input.ActorToken, input.ActorTokenType)
+		if err != nil {
+			return nil, err
+		}
+		if at == nil || at.Issuer != issuer || !at.presentableBy(client, input.PresentedCertThumbprint) {
+			return nil, ErrInvalidGrant
+		}
+		actor = &model.ActorClaim{Subject: at.Subject}
+	}
+
+	// subject_token の may_act で委任先が限定されている場合はアクターが一致すること (RFC 8693 Section 4.4)
+	if subject.MayAct != nil {
+		candidate := &model.ActorClaim{Subject: actor.Subject, ClientID: client.ClientID}
+		if !subject.MayAct.Matches(candidate) {
+			return nil, ErrInvalidGrant
+		}
+	}
+
+	audience, err := selectExchangeAudience(client, input.Audiences)
+	if err != nil {
+		return nil, err
+	}
+
+	scope, err := selectExchangeScope(client, subject, input.Scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// sub は要求元クライアントに対する値で発行し直す (pairwise の場合にセクターをまたいで sub を持ち出さない)
+	sub, err := h.subjectMapper.Subject(ctx, client, subject.Session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 委任の連鎖は act の入れ子で表す (RFC 8693 Section 4.1)
+	actor.Actor = subject.Actor
+
+	// 交換後のトークンが元のセッションより長く有効にならないようにする
+	lifetime := time.Duration(tenant.AccessTokenLifetime) * time.Second
+	if remaining := time.Until(subject.Session.ExpiresAt); remaining < lifetime {
+		lifetime = remaining
+	}
+
+	accessJTI, accessTokenStr, err := h.tokenSigner.SignAccessToken(ctx, &model.AccessTokenClaims{
+		Issuer:         issuer,
+		Subject:        sub,
+		Audience:       audience,
+		Scope:          scope,
+		SessionID:      subject.Session.ID.String(),
+		CertThumbprint: input.CertThumbprint,
+		ClientID:       client.ClientID,
+		Actor:          actor,
+		// 下流サービスがさらに交換する場合は audience と同じ client_id で登録したクライアントに限る
+		MayAct: &model.ActorClaim{ClientID: audience},
+	}, lifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	accessToken := &model.AccessToken{
+		JTI:       accessJTI,
+		SessionID: subject.Session.ID,
+		ClientID:  client.ID,
+		Scope:     scope,
+		ExpiresAt: time.Now().Add(lifetime),
+	}
+	if err := h.accessTokenStore.Create(ctx, accessToken); err != nil {
+		return nil, fmt.Errorf("failed to save access token: %w", err)
+	}
+
+	// リフレッシュトークンは発行しない (RFC 8693 Section 2.2.1)
+	return &TokenResponse{
+		AccessToken:     accessTokenStr,
+		IssuedTokenType: tokenTypeAccessToken,
+		TokenType:       "Bearer",
+		ExpiresIn:       int(lifetime.Seconds()),
+		Scope:           scope,
+	}, nil
+}
+
+// resolveExchangeToken は自身が発行したアクセストークンまたは ID トークンを検証し、紐付くセッションを返す。
+// 失効済み・セッション無効のトークンは nil を返す。
+func (h *TokenHandler) resolveExchangeToken(ctx context.Context, token, tokenType string) (*exchangeToken, error) {
+	switch tokenType {
+	case tokenTypeAccessToken:
+		result, err := h.tokenValidator.ValidateAccessToken(ctx, token)
+		if err != nil {
+			return nil, nil
+		}
+		dbToken, err := h.accessTokenStore.FindByJTI(ctx, result.JTI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find access token: %w", err)
+		}
+		if dbToken == nil || dbToken.RevokedAt != nil || !dbToken.Session.IsValid() {
+			return nil, nil
+		}
+		return &exchangeToken{
+			Issuer:  result.Issuer,
+			Subject: result.Subject,
+			Session: &dbToken.Session,
+			Scope:   result.Scope,
+			Actor:   result.Actor,
+			MayAct:  result.MayAct,
+			// 証明書バインドの確認に使う
+			ClientID:       result.ClientID,
+			CertThumbprint: result.CertThumbprint,
+		}, nil
+	case tokenTypeIDToken:
+		result, err := h.tokenValidator.ValidateIDToken(ctx, token)
+		if err != nil {
+			return nil, nil
+		}
+		dbToken, err := h.idTokenFinder.FindByJTI(ctx, result.JTI)
+		if err != nil {
+			return nil, fmt.Errorf("failed to find ID token: %w", err)
+		}
+		// 付与されたスコープが分からない ID トークンは、交換で同意を超えるスコープを得られないよう受け付けない
+		if dbToken == nil || dbToken.Scope == nil || !dbToken.Session.IsValid() {
+			return nil, nil
+		}
+		return &exchangeToken{
+			Issuer:  result.Issuer,
+			Subject: result.Subject,
+			Session: &dbToken.Session,
+			Scope:   *dbToken.Scope,
+		}, nil
+	default:
+		return nil, ErrInvalidGrant
+	}
+}
+
+// selectExchangeAudience はクライアントのポリシーで許可された audience を1つ選ぶ。
+// 省略時は許可された audience がちょうど1つの場合のみそれを使う。
+func selectExchangeAudience(client *model.Client, requested []string) (string, error) {
+	switch len(requested) {
+	case 0:
+		if len(client.TokenExchangeAudiences) != 1 {
+			return "", ErrInvalidTarget
+		}
+		return client.TokenExchangeAudiences[0], nil
+	case 1:
+		if !containsScope(client.TokenExchangeAudiences, requested[0]) {
+			return "", ErrInvalidTarget
+		}
+		return requested[0], nil
+	default:
+		// 複数の audience を1つのトークンにまとめるとダウンスコープの意味がなくなるため受け付けない
+		return "", ErrInvalidTarget
+	}
+}
+
+// selectExchangeScope は発行するスコープを決める。
+// 上限はクライアントのポリシーと subject_token のスコープ (ID トークンの場合は発行元の認可で付与されたスコープ) の共通部分。
+func selectExchangeScope(client *model.Client, subject *exchangeToken, requested string) (string, error) {
+	var allowed []string
+	for _, s := range client.TokenExchangeScopes {
+		if !containsScope(strings.Fields(subject.Scope), s) {
+			continue
+		}
+		allowed = append(allowed, s)
+	}
+
+	granted := allowed
+	if requested != "" {
+		granted = strings.Fields(requested)
+		for _, s := range granted {
+			if !containsScope(allowed, s) {
+				return "", ErrInvalidScope
+			}
+		}
+	}
+	if len(granted) == 0 {
+		return "", ErrInvalidScope
+	}
+	return strings.Join(granted, " "), nil
+}
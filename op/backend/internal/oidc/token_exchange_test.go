@@ -0,0 +1,185 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type fakeIDTokenFinder struct {
+	tokens map[string]*model.IDToken
+}
+
+func (f *fakeIDTokenFinder) FindByJTI(_ context.Context, jti string) (*model.IDToken, error) {
+	return f.tokens[jti], nil
+}
+
+// fakeTokenSigner は署名せずに最後のクレームを記録する
+type fakeTokenSigner struct {
+	accessClaims *model.AccessTokenClaims
+}
+
+func (f *fakeTokenSigner) SignIDToken(context.Context, *model.IDTokenClaims, time.Duration) (string, string, error) {
+	return "id-jti", "id-token", nil
+}
+
+func (f *fakeTokenSigner) SignAccessToken(_ context.Context, claims *model.AccessTokenClaims, _ time.Duration) (string, string, error) {
+	f.accessClaims = claims
+	return "at-jti", "access-token", nil
+}
+
+func (f *fakeTokenSigner) GenerateRefreshToken() (string, string, error) {
+	return "refresh-token", "sha:refresh-token", nil
+}
+
+// tokenExchangeFixture は API ゲートウェイ (gateway) がユーザーのトークンを orders サービス向けに交換する構成
+type tokenExchangeFixture struct {
+	handler *TokenHandler
+	signer  *fakeTokenSigner
+	gateway *model.Client
+	session *model.Session
+}
+
+func newTokenExchangeFixture() *tokenExchangeFixture {
+	tenant := &model.Tenant{ID: uuid.New(), Code: "demo", AccessTokenLifetime: 3600}
+	issuer := testIssuerBaseURL + "/demo"
+	session := newTestSession(tenant)
+	gateway := &model.Client{
+		ID:                     uuid.New(),
+		TenantID:               tenant.ID,
+		ClientID:               "gateway",
+		GrantTypes:             model.StringSlice{tokenExchangeGrantType},
+		TokenExchangeAudiences: model.StringSlice{"orders"},
+		TokenExchangeScopes:    model.StringSlice{"orders:read", "orders:write"},
+	}
+	revokedAt := time.Now()
+
+	validator := &fakeTokenValidator{
+		accessTokens: map[string]*model.AccessTokenResult{
+			"user-at":    {JTI: "user-at", Issuer: issuer, Subject: session.UserID.String(), Scope: "openid orders:read"},
+			"revoked-at": {JTI: "revoked-at", Issuer: issuer, Subject: session.UserID.String(), Scope: "orders:read"},
+			"foreign-at": {JTI: "foreign-at", Issuer: "https://other.example.com/demo", Subject: session.UserID.String(), Scope: "orders:read"},
+			"limited-at": {JTI: "limited-at", Issuer: issuer, Subject: session.UserID.String(), Scope: "orders:read", MayAct: &model.ActorClaim{ClientID: "billing"}},
+			// mobile-app に証明書バインドして発行したトークンと、gateway 自身に証明書バインドして発行したトークン
+			"bound-at":      {JTI: "bound-at", Issuer: issuer, Subject: session.UserID.String(), Scope: "orders:read", ClientID: "mobile-app", CertThumbprint: "mobile-thumb"},
+			"self-bound-at": {JTI: "self-bound-at", Issuer: issuer, Subject: session.UserID.String(), Scope: "orders:read", ClientID: "gateway", CertThumbprint: "gateway-thumb"},
+		},
+		idTokens: map[string]*model.IDTokenResult{
+			"user-idt":   {JTI: "user-idt", Issuer: issuer, Subject: session.UserID.String()},
+			"legacy-idt": {JTI: "legacy-idt", Issuer: issuer, Subject: session.UserID.String()},
+		},
+	}
+	accessTokens := &fakeAccessTokenStore{tokens: map[string]*model.AccessToken{
+		"user-at":       {JTI: "user-at", Session: *session},
+		"revoked-at":    {JTI: "revoked-at", Session: *session, RevokedAt: &revokedAt},
+		"foreign-at":    {JTI: "foreign-at", Session: *session},
+		"limited-at":    {JTI: "limited-at", Session: *session},
+		"bound-at":      {JTI: "bound-at", Session: *session},
+		"self-bound-at": {JTI: "self-bound-at", Session: *session},
+	}}
+	idTokens := &fakeIDTokenFinder{tokens: map[string]*model.IDToken{
+		"user-idt":   {JTI: "user-idt", Session: *session, Scope: strPtr("openid orders:read")},
+		"legacy-idt": {JTI: "legacy-idt", Session: *session},
+	}}
+	signer := &fakeTokenSigner{}
+	h := &TokenHandler{
+		tenantFinder:     newFakeTenantFinder(tenant),
+		accessTokenStore: accessTokens,
+		idTokenFinder:    idTokens,
+		subjectMapper:    NewSubjectMapper(nil, nil, nil),
+		tokenSigner:      signer,
+		tokenValidator:   validator,
+		issuerBaseURL:    testIssuerBaseURL,
+	}
+	return &tokenExchangeFixture{handler: h, signer: signer, gateway: gateway, session: session}
+}
+
+func TestTokenExchange(t *testing.T) {
+	tests := []struct {
+		name      string
+		tokenType string
+		token     string
+		scope     string
+		audiences []string
+		// certThumbprint は提示されたクライアント証明書の x5t#S256
+		certThumbprint string
+		wantScope      string
+		wantErr        error
+	}{
+		{name: "アクセストークンをダウンスコープ", tokenType: tokenTypeAccessToken, token: "user-at", wantScope: "orders:read"},
+		{name: "元のトークンに無いスコープは要求できない", tokenType: tokenTypeAccessToken, token: "user-at", scope: "orders:write", wantErr: ErrInvalidScope},
+		{name: "ID トークンは発行元の認可のスコープまで", tokenType: tokenTypeIDToken, token: "user-idt", wantScope: "orders:read"},
+		{name: "ID トークンで同意を超えるスコープは要求できない", tokenType: tokenTypeIDToken, token: "user-idt", scope: "orders:read orders:write", wantErr: ErrInvalidScope},
+		{name: "スコープの記録が無い ID トークン", tokenType: tokenTypeIDToken, token: "legacy-idt", wantErr: ErrInvalidGrant},
+		{name: "失効したアクセストークン", tokenType: tokenTypeAccessToken, token: "revoked-at", wantErr: ErrInvalidGrant},
+		{name: "他の発行者のトークン", tokenType: tokenTypeAccessToken, token: "foreign-at", wantErr: ErrInvalidGrant},
+		{name: "may_act に含まれないアクター", tokenType: tokenTypeAccessToken, token: "limited-at", wantErr: ErrInvalidGrant},
+		{name: "未知のトークン種別", tokenType: "urn:example:unknown", token: "user-at", wantErr: ErrInvalidGrant},
+		{name: "許可されていない audience", tokenType: tokenTypeAccessToken, token: "user-at", audiences: []string{"billing"}, wantErr: ErrInvalidTarget},
+		{name: "証明書バインドされたトークンを証明書なしで交換", tokenType: tokenTypeAccessToken, token: "bound-at", wantErr: ErrInvalidGrant},
+		{name: "証明書バインドされたトークンを別の証明書で交換", tokenType: tokenTypeAccessToken, token: "bound-at", certThumbprint: "gateway-thumb", wantErr: ErrInvalidGrant},
+		{name: "証明書バインドされたトークンを同じ証明書で交換", tokenType: tokenTypeAccessToken, token: "bound-at", certThumbprint: "mobile-thumb", wantScope: "orders:read"},
+		{name: "発行先のクライアント自身は証明書バインドされたトークンを交換できる", tokenType: tokenTypeAccessToken, token: "self-bound-at", wantScope: "orders:read"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newTokenExchangeFixture()
+			resp, err := f.handler.handleTokenExchangeGrantLogic(context.Background(), &TokenExchangeGrantInput{
+				Client:           f.gateway,
+				SubjectToken:     tt.token,
+				SubjectTokenType: tt.tokenType,
+				Audiences:        tt.audiences,
+				Scope:            tt.scope,
+
+				PresentedCertThumbprint: tt.certThumbprint,
+			})
+			if tt.wantErr != nil {
+				if !errors.Is(err, tt.wantErr) {
+					t.Fatalf("err = %v, want %v", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Scope != tt.wantScope || resp.IssuedTokenType != tokenTypeAccessToken || resp.RefreshToken != "" {
+				t.Errorf("unexpected response: %+v", resp)
+			}
+			claims := f.signer.accessClaims
+			if claims.Audience != "orders" || claims.SessionID != f.session.ID.String() {
+				t.Errorf("aud = %q, sid = %q", claims.Audience, claims.SessionID)
+			}
+			if claims.Actor == nil || claims.Actor.ClientID != "gateway" || claims.MayAct == nil || claims.MayAct.ClientID != "orders" {
+				t.Errorf("act = %+v, may_act = %+v", claims.Actor, claims.MayAct)
+			}
+		})
+	}
+}
+
+func TestSelectExchangeScope(t *testing.T) {
+	client := &model.Client{TokenExchangeScopes: model.StringSlice{"a", "b", "c"}}
+	for _, tt := range []struct {
+		subject   string
+		requested string
+		want      string
+		wantErr   bool
+	}{
+		{subject: "a b", want: "a b"},
+		{subject: "a b", requested: "b", want: "b"},
+		{subject: "a b", requested: "c", wantErr: true},
+		{subject: "x", wantErr: true},
+		{subject: "", wantErr: true},
+	} {
+		name := fmt.Sprintf("subject=%q requested=%q", tt.subject, tt.requested)
+		got, err := selectExchangeScope(client, &exchangeToken{Scope: tt.subject}, tt.requested)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("%s: got %q, err %v", name, got, err)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package oidc
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// tokenIssueParams は認証済みセッションに対してトークンを発行するための入力
+type tokenIssueParams struct {
+	Tenant  *model.Tenant
+	Client  *model.Client
+	Session *model.Session
+	// Scope は認可されたスコープ全体。リフレッシュトークンに保存する
+	Scope string
+	// AccessScope はアクセストークンに含めるスコープ。空の場合は Scope と同じ
+	AccessScope string
+	// Resource はアクセストークンの aud にする API リソース。nil の場合は aud がクライアント (RFC 8707)
+	Resource *model.APIResource
+	// Resources は認可された resource の一覧。リフレッシュトークンに保存する
+	Resources []string
+	Nonce     *string
+	// Claims は認可リクエストの claims パラメータ。nil の場合は要求なし
+	Claims *model.ClaimsRequest
+	// AuthorizationDetails は認可された authorization_details (RFC 9396)
+	AuthorizationDetails model.AuthorizationDetails
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+	// IssueIDToken が false の場合は ID トークンを発行しない (openid スコープを含まないデバイスフロー等)
+	IssueIDToken bool
+}
+
+// issueTokens はアクセストークン・IDトークン・リフレッシュトークンを発行して保存する。
+// 認可コードグラント・デバイスコードグラント・CIBA グラントで共通。
+func (h *TokenHandler) issueTokens(ctx context.Context, p *tokenIssueParams) (*TokenResponse, error) {
+	tenant, client, session := p.Tenant, p.Client, p.Session
+
+	issuer := h.issuerBaseURL + "/" + tenant.Code
+	// pairwise クライアントにはセクター固有の sub を発行する (OIDC Core 1.0 Section 8)
+	subject, err := h.subjectMapper.Subject(ctx, client, session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	accessScope := p.AccessScope
+	if accessScope == "" {
+		accessScope = p.Scope
+	}
+	claims := &model.AccessTokenClaims{
+		Issuer:               issuer,
+		Subject:              subject,
+		Audience:             client.ClientID,
+		Scope:                accessScope,
+		SessionID:            session.ID.String(),
+		CertThumbprint:       p.CertThumbprint,
+		AuthorizationDetails: p.AuthorizationDetails,
+	}
+	lifetimeSeconds := tenant.AccessTokenLifetime
+	var resourceID *string
+	if p.Resource != nil {
+		claims.Audience = p.Resource.Identifier
+		claims.ClientID = client.ClientID
+		lifetimeSeconds = p.Resource.TokenLifetime(tenant)
+		resourceID = &p.Resource.Identifier
+	}
+
+	// アクセストークン生成
+	accessTokenLifetime := time.Duration(lifetimeSeconds) * time.Second
+	accessJTI, accessTokenStr, err := h.tokenSigner.SignAccessToken(ctx, claims, accessTokenLifetime)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign access token: %w", err)
+	}
+
+	// アクセストークンDB保存
+	accessToken := &model.AccessToken{
+		JTI:                  accessJTI,
+		SessionID:            session.ID,
+		ClientID:             client.ID,
+		Scope:                accessScope,
+		Resource:             resourceID,
+		Claims:               p.Claims,
+		ExpiresAt:            time.Now().Add(accessTokenLifetime),
+		AuthorizationDetails: p.AuthorizationDetails,
+	}
+	if err := h.accessTokenStore.Create(ctx, accessToken); err != nil {
+		return nil, fmt.Errorf("failed to save access token: %w", err)
+	}
+
+	var idTokenStr string
+	if p.IssueIDToken {
+		// IDトークン生成 (at_hash 含む)
+		atHash := h.computeATHash(accessTokenStr)
+		// アクセストークンを発行した場合、スコープ由来のクレームは UserInfo で返す (OIDC Core 1.0 Section 5.4)
+		extra, err := h.idTokenUserClaims(ctx, session, p.Scope, p.Claims, accessTokenStr == "")
+		if err != nil {
+			return nil, err
+		}
+		idTokenLifetime := time.Duration(tenant.IDTokenLifetime) * time.Second
+		var idTokenJTI string
+		idTokenJTI, idTokenStr, err = h.tokenSigner.SignIDToken(ctx, &model.IDTokenClaims{
+			Issuer:   issuer,
+			Subject:  subject,
+			Audience: client.ClientID,
+			Nonce:    p.Nonce,
+			AuthTime: session.CreatedAt,
+			ATHash:   atHash,
+			AMR:      session.AMR,
+			Extra:    extra,
+		}, idTokenLifetime)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign ID token: %w", err)
+		}
+
+		// IDトークンDB保存
+		idToken := &model.IDToken{
+			JTI:       idTokenJTI,
+			SessionID: session.ID,
+			ClientID:  client.ID,
+			Nonce:     p.Nonce,
+			Scope:     &p.Scope,
+			ExpiresAt: time.Now().Add(idTokenLifetime),
+		}
+		if err := h.idTokenCreator.Create(ctx, idToken); err != nil {
+			return nil, fmt.Errorf("failed to save ID token: %w", err)
+		}
+
+		// 暗号化を要求するクライアントには署名済み ID トークンを入れ子にして暗号化する (OIDC Core 1.0 Section 10.2)
+		if client.IDTokenEncryptedResponseAlg != nil {
+			idTokenStr, err = encryptForClient(h.encryptJWT, client, client.IDTokenEncryptedResponseAlg, client.IDTokenEncryptedResponseEnc, []byte(idTokenStr), "JWT")
+			if err != nil {
+				return nil, fmt.Errorf("failed to encrypt ID token: %w", err)
+			}
+		}
+	}
+
+	// リフレッシュトークン生成 (offline_access スコープまたはrefresh_token grant対応時)
+	var refreshTokenStr string
+	if client.HasGrantType("refresh_token") {
+		var tokenHash string
+		refreshTokenStr, tokenHash, err = h.tokenSigner.GenerateRefreshToken()
+		if err != nil {
+			return nil, fmt.Errorf("failed to generate refresh token: %w", err)
+		}
+
+		refreshTokenLifetime := time.Duration(tenant.RefreshTokenLifetime) * time.Second
+		scope := p.Scope
+		refreshToken := &model.RefreshToken{
+			TokenHash:            tokenHash,
+			SessionID:            session.ID,
+			AccessTokenID:        accessToken.ID,
+			Scope:                &scope,
+			Resources:            model.StringSlice(p.Resources),
+			Claims:               p.Claims,
+			ExpiresAt:            time.Now().Add(refreshTokenLifetime),
+			AuthorizationDetails: p.AuthorizationDetails,
+		}
+		if err := h.refreshTokenStore.Create(ctx, refreshToken); err != nil {
+			return nil, fmt.Errorf("failed to save refresh token: %w", err)
+		}
+	}
+
+	return &TokenResponse{
+		AccessToken:          accessTokenStr,
+		TokenType:            "Bearer",
+		ExpiresIn:            lifetimeSeconds,
+		RefreshToken:         refreshTokenStr,
+		IDToken:              idTokenStr,
+		Scope:                accessScope,
+		AuthorizationDetails: p.AuthorizationDetails,
+	}, nil
+}
+
+// idTokenUserClaims は ID トークンに含めるユーザークレームを返す。
+// claims パラメータの id_token メンバーに加え、includeScope が true の場合はスコープ由来のクレームも含める。
+func (h *TokenHandler) idTokenUserClaims(ctx context.Context, session *model.Session, scope string, claimsReq *model.ClaimsRequest, includeScope bool) (map[string]interface{}, error) {
+	var requested map[string]*model.ClaimRequest
+	if claimsReq != nil {
+		requested = claimsReq.IDToken
+	}
+	if !includeScope && len(requested) == 0 {
+		return nil, nil
+	}
+
+	user, err := h.userFinder.FindByID(ctx, session.UserID)
+	if err != nil || user == nil {
+		return nil, fmt.Errorf("failed to find user: %w", err)
+	}
+	return buildUserClaims(user, scope, requested, includeScope), nil
+}
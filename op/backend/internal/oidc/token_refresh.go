@@ -3,6 +3,7 @@ package oidc
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
@@ -10,28 +11,21 @@ import (
 
 // RefreshTokenGrantInput はリフレッシュトークングラントの入力
 type RefreshTokenGrantInput struct {
-	ClientID     string
-	ClientSecret string
+	Client       *model.Client
 	RefreshToken string
 	Scope        string
+	// CertThumbprint は証明書バインドする場合のクライアント証明書 x5t#S256
+	CertThumbprint string
+	// Resources はリクエストの resource。認可済みのリソースのうち1つに絞り込める (RFC 8707 Section 2.2)
+	Resources []string
+	// AuthorizationDetails はリクエストの authorization_details。認可済みの範囲内でのみ絞り込める (RFC 9396 Section 6.2)
+	AuthorizationDetails model.AuthorizationDetails
 }
 
 // handleRefreshTokenGrantLogic はリフレッシュトークングラントのビジネスロジック。
 // Refresh Token Rotation + Reuse Detection (RFC 9700) を実装。
 func (h *TokenHandler) handleRefreshTokenGrantLogic(ctx context.Context, input *RefreshTokenGrantInput) (*TokenResponse, error) {
-	// クライアント認証
-	client, err := h.clientFinder.FindByClientID(ctx, input.ClientID)
-	if err != nil {
-		return nil, fmt.Errorf("failed to find client: %w", err)
-	}
-	if client == nil || client.Status != "active" {
-		return nil, ErrInvalidClient
-	}
-
-	match, err := h.verifyPassword(input.ClientSecret, client.ClientSecretHash)
-	if err != nil || !match {
-		return nil, ErrInvalidClient
-	}
+	client := input.Client
 
 	if !client.HasGrantType("refresh_token") {
 		return nil, ErrUnsupportedGrantType
@@ -56,6 +50,11 @@ func (h *TokenHandler) handleRefreshTokenGrantLogic(ctx context.Context, input *
 		return nil, ErrInvalidGrant
 	}
 
+	// 発行先クライアント一致チェック (MUST: RFC 6749 Section 6)
+	if rt.AccessToken.ClientID != client.ID {
+		return nil, ErrInvalidGrant
+	}
+
 	// 有効期限チェック
 	if rt.ExpiresAt.Before(time.Now()) {
 		return nil, ErrInvalidGrant
@@ -66,6 +65,51 @@ func (h *TokenHandler) handleRefreshTokenGrantLogic(ctx context.Context, input *
 		return nil, ErrInvalidGrant
 	}
 
+	// 認可されたスコープ全体 (リソース指標導入前に発行されたトークンは直前のアクセストークンのスコープ)
+	grantedScope := rt.AccessToken.Scope
+	if rt.Scope != nil {
+		grantedScope = *rt.Scope
+	}
+	// 認可後にクライアントに許可されなくなったスコープは引き継がない
+	grantedScope = strings.Join(filterAllowedScopes(client, strings.Fields(grantedScope)), " ")
+
+	// スコープ: リクエストのscopeが指定されていればそれを使う（ただし元のスコープ以下）
+	scope := grantedScope
+	if input.Scope != "" {
+		granted := strings.Fields(grantedScope)
+		for _, s := range strings.Fields(input.Scope) {
+			if !containsScope(granted, s) {
+				return nil, ErrInvalidScope
+			}
+		}
+		scope = input.Scope
+	}
+
+	// resource の指定が無ければ直前のアクセストークンと同じリソース向けに発行する
+	requested := input.Resources
+	if len(requested) == 0 && rt.AccessToken.Resource != nil {
+		requested = []string{*rt.AccessToken.Resource}
+	}
+	resource, accessScope, err := h.selectResource(ctx, rt.Session.TenantID, rt.Resources, requested, scope)
+	if err != nil {
+		return nil, err
+	}
+
+	// authorization_details の指定が無ければ認可済みの全体を引き継ぐ。認可済みの範囲を超える要求は拒否する
+	details := rt.AuthorizationDetails
+	if input.AuthorizationDetails != nil {
+		if !rt.AuthorizationDetails.Covers(input.AuthorizationDetails) {
+			return nil, ErrInvalidAuthorizationDetails
+		}
+		details = input.AuthorizationDetails
+	}
+
+	// 失効前に sub を確定させ、失敗してもリフレッシュトークンを失わないようにする
+	subject, err := h.subjectMapper.Subject(ctx, client, rt.Session.UserID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 古いリフレッシュトークンを失効 (Rotation)
 	if err := h.refreshTokenStore.Revoke(ctx, rt.ID); err != nil {
 		return nil, fmt.Errorf("failed to revoke old refresh token: %w", err)
@@ -81,33 +125,41 @@ func (h *TokenHandler) handleRefreshTokenGrantLogic(ctx context.Context, input *
 	}
 
 	issuer := h.issuerBaseURL + "/" + tenant.Code
-	userID := rt.Session.UserID.String()
 
-	// スコープ: リクエストのscopeが指定されていればそれを使う（ただし元のスコープ以下）
-	scope := rt.AccessToken.Scope
-	if input.Scope != "" {
-		scope = input.Scope
+	claims := &model.AccessTokenClaims{
+		Issuer:               issuer,
+		Subject:              subject,
+		Audience:             client.ClientID,
+		Scope:                accessScope,
+		SessionID:            rt.SessionID.String(),
+		CertThumbprint:       input.CertThumbprint,
+		AuthorizationDetails: details,
+	}
+	lifetimeSeconds := tenant.AccessTokenLifetime
+	var resourceID *string
+	if resource != nil {
+		claims.Audience = resource.Identifier
+		claims.ClientID = client.ClientID
+		lifetimeSeconds = resource.TokenLifetime(tenant)
+		resourceID = &resource.Identifier
 	}
 
 	// 新しいアクセストークン生成
-	accessTokenLifetime := time.Duration(tenant.AccessTokenLifetime) * time.Second
-	accessJTI, accessTokenStr, err := h.tokenSigner.SignAccessToken(ctx, &model.AccessTokenClaims{
-		Issuer:    issuer,
-		Subject:   userID,
-		Audience:  client.ClientID,
-		Scope:     scope,
-		SessionID: rt.SessionID.String(),
-	}, accessTokenLifetime)
+	accessTokenLifetime := time.Duration(lifetimeSeconds) * time.Second
+	accessJTI, accessTokenStr, err := h.tokenSigner.SignAccessToken(ctx, claims, accessTokenLifetime)
 	if err != nil {
 		return nil, fmt.Errorf("failed to sign access token: %w", err)
 	}
 
 	accessToken := &model.AccessToken{
-		JTI:       accessJTI,
-		SessionID: rt.SessionID,
-		ClientID:  client.ID,
-		Scope:     scope,
-		ExpiresAt: time.Now().Add(accessTokenLifetime),
+		JTI:                  accessJTI,
+		SessionID:            rt.SessionID,
+		ClientID:             client.ID,
+		Scope:                accessScope,
+		Resource:             resourceID,
+		Claims:               rt.Claims,
+		ExpiresAt:            time.Now().Add(accessTokenLifetime),
+		AuthorizationDetails: details,
 	}
 	if err := h.accessTokenStore.Create(ctx, accessToken); err != nil {
 		return nil, fmt.Errorf("failed to save access token: %w", err)
@@ -119,23 +171,29 @@ func (h *TokenHandler) handleRefreshTokenGrantLogic(ctx context.Context, input *
 		return nil, fmt.Errorf("failed to generate refresh token: %w", err)
 	}
 
+	// 認可されたスコープ・リソースは絞り込まずに引き継ぐ
 	refreshTokenLifetime := time.Duration(tenant.RefreshTokenLifetime) * time.Second
 	newRefreshToken := &model.RefreshToken{
-		TokenHash:     newTokenHash,
-		ParentID:      &rt.ID,
-		SessionID:     rt.SessionID,
-		AccessTokenID: accessToken.ID,
-		ExpiresAt:     time.Now().Add(refreshTokenLifetime),
+		TokenHash:            newTokenHash,
+		ParentID:             &rt.ID,
+		SessionID:            rt.SessionID,
+		AccessTokenID:        accessToken.ID,
+		Scope:                &grantedScope,
+		Resources:            rt.Resources,
+		Claims:               rt.Claims,
+		ExpiresAt:            time.Now().Add(refreshTokenLifetime),
+		AuthorizationDetails: rt.AuthorizationDetails,
 	}
 	if err := h.refreshTokenStore.Create(ctx, newRefreshToken); err != nil {
 		return nil, fmt.Errorf("failed to save refresh token: %w", err)
 	}
 
 	return &TokenResponse{
-		AccessToken:  accessTokenStr,
-		TokenType:    "Bearer",
-		ExpiresIn:    tenant.AccessTokenLifetime,
-		RefreshToken: newRefreshTokenStr,
-		Scope:        scope,
+		AccessToken:          accessTokenStr,
+		TokenType:            "Bearer",
+		ExpiresIn:            lifetimeSeconds,
+		RefreshToken:         newRefreshTokenStr,
+		Scope:                accessScope,
+		AuthorizationDetails: details,
 	}, nil
 }
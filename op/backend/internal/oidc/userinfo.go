@@ -1,27 +1,43 @@
 package oidc
 
 import (
+	"context"
+	"encoding/json"
 	"net/http"
 	"strings"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 )
 
 type UserInfoHandler struct {
 	tokenValidator   TokenValidator
 	userFinder       UserFinder
 	accessTokenStore AccessTokenStore
+	consentStore     UserConsentStore
+	certExtractor    *ClientCertificateExtractor
+	userInfoSigner   UserInfoSigner
+	encryptJWT       EncryptJWTFunc
 }
 
 func NewUserInfoHandler(
 	tokenValidator TokenValidator,
 	userFinder UserFinder,
 	accessTokenStore AccessTokenStore,
+	consentStore UserConsentStore,
+	certExtractor *ClientCertificateExtractor,
+	userInfoSigner UserInfoSigner,
+	encryptJWT EncryptJWTFunc,
 ) *UserInfoHandler {
 	return &UserInfoHandler{
 		tokenValidator:   tokenValidator,
 		userFinder:       userFinder,
 		accessTokenStore: accessTokenStore,
+		consentStore:     consentStore,
+		certExtractor:    certExtractor,
+		userInfoSigner:   userInfoSigner,
+		encryptJWT:       encryptJWT,
 	}
 }
 
@@ -43,6 +59,15 @@ func (h *UserInfoHandler) Handle(c echo.Context) error {
 		return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
 	}
 
+	// 証明書バインドされたトークンは同じクライアント証明書での提示を要求する (MUST: RFC 8705 Section 3)
+	if result.CertThumbprint != "" {
+		cert, err := h.certExtractor.Extract(c)
+		if err != nil || cert == nil || CertificateThumbprint(cert) != result.CertThumbprint {
+			c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			return c.JSON(http.StatusUnauthorized, map[string]string{"error": "invalid_token"})
+		}
+	}
+
 	// DB でアクセストークンの失効チェック
 	dbToken, err := h.accessTokenStore.FindByJTI(c.Request().Context(), result.JTI)
 	if err != nil || dbToken == nil || dbToken.RevokedAt != nil {
@@ -51,29 +76,60 @@ func (h *UserInfoHandler) Handle(c echo.Context) error {
 	}
 
 	// ユーザー情報取得
-	user, err := h.userFinder.FindByID(c.Request().Context(), result.Subject)
+	// sub は pairwise の場合ユーザー ID ではないため、セッションからユーザーを引く
+	user, err := h.userFinder.FindByID(c.Request().Context(), dbToken.Session.UserID)
 	if err != nil || user == nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
 	}
 
-	// スコープに応じたクレーム構築
-	claims := map[string]interface{}{
-		"sub": user.ID.String(),
-	}
-
-	scopes := strings.Split(result.Scope, " ")
+	client := &dbToken.Client
 
-	if containsScope(scopes, "profile") {
-		if user.Name != nil {
-			claims["name"] = *user.Name
+	// スコープと claims パラメータの userinfo メンバーに応じたクレーム構築。
+	// claims パラメータは認可時にも絞り込んでいるが、その後のクライアントの設定変更や同意の取り消しを反映するため改めて絞り込む
+	var requested map[string]*model.ClaimRequest
+	if dbToken.Claims != nil {
+		consent, err := h.consentStore.FindByUserAndClient(c.Request().Context(), user.ID, client.ID)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
 		}
-		claims["updated_at"] = user.UpdatedAt.Unix()
+		requested = newTenantScopes(user.Tenant.ScopeDefinitions).filterClaimsRequest(dbToken.Claims, &user.Tenant, client, consent).UserInfo
 	}
+	claims := buildUserClaims(user, result.Scope, requested, true)
+	// sub は ID トークンと同じ値を返す (MUST: OIDC Core 1.0 Section 5.3.2)
+	claims["sub"] = result.Subject
 
-	if containsScope(scopes, "email") {
-		claims["email"] = user.Email
-		claims["email_verified"] = user.EmailVerified
+	if client.UserInfoSignedResponseAlg == nil && client.UserInfoEncryptedResponseAlg == nil {
+		return c.JSON(http.StatusOK, claims)
 	}
 
-	return c.JSON(http.StatusOK, claims)
+	// 署名・暗号化を要求するクライアントには application/jwt で返す (OIDC Core 1.0 Section 5.3.2)
+	body, err := h.protectResponse(c.Request().Context(), result.Issuer, client, claims)
+	if err != nil {
+		c.Logger().Errorf("failed to build userinfo response: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	return c.Blob(http.StatusOK, "application/jwt", []byte(body))
+}
+
+// protectResponse は UserInfo レスポンスを署名・暗号化する。
+// 両方指定されている場合は署名してから暗号化する (MUST: OIDC Core 1.0 Section 5.3.2)
+func (h *UserInfoHandler) protectResponse(ctx context.Context, issuer string, client *model.Client, claims map[string]interface{}) (string, error) {
+	var payload []byte
+	var contentType string
+	if client.UserInfoSignedResponseAlg != nil {
+		signed, err := h.userInfoSigner.SignUserInfo(ctx, issuer, client.ClientID, claims)
+		if err != nil {
+			return "", err
+		}
+		if client.UserInfoEncryptedResponseAlg == nil {
+			return signed, nil
+		}
+		payload, contentType = []byte(signed), "JWT"
+	} else {
+		var err error
+		if payload, err = json.Marshal(claims); err != nil {
+			return "", err
+		}
+	}
+	return encryptForClient(h.encryptJWT, client, client.UserInfoEncryptedResponseAlg, client.UserInfoEncryptedResponseEnc, payload, contentType)
 }
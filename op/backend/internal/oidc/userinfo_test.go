@@ -0,0 +1,178 @@
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// fakeUserInfoSigner は署名の代わりに "signed:" + JSON を返す
+type fakeUserInfoSigner struct{}
+
+func (fakeUserInfoSigner) SignUserInfo(_ context.Context, issuer, audience string, claims map[string]interface{}) (string, error) {
+	body := map[string]interface{}{"iss": issuer, "aud": audience}
+	for k, v := range claims {
+		body[k] = v
+	}
+	raw, err := json.Marshal(body)
+	return "signed:" + string(raw), err
+}
+
+// fakeEncryptJWT は暗号化の代わりに引数を並べた文字列を返す
+func fakeEncryptJWT(jwks, alg, enc string, payload []byte, contentType string) (string, error) {
+	return strings.Join([]string{"encrypted", alg, enc, contentType, string(payload)}, "|"), nil
+}
+
+type userInfoFixture struct {
+	handler  *UserInfoHandler
+	token    *model.AccessToken
+	user     *model.User
+	consents *fakeUserConsentStore
+}
+
+func newUserInfoFixture(client model.Client, claims *model.ClaimsRequest) *userInfoFixture {
+	user := testClaimsUser()
+	user.ID = uuid.New()
+	session := model.Session{ID: uuid.New(), UserID: user.ID, ExpiresAt: time.Now().Add(time.Hour)}
+	token := &model.AccessToken{JTI: "at", Session: session, Client: client, Claims: claims}
+	consents := &fakeUserConsentStore{consents: map[uuid.UUID]*model.UserConsent{}}
+	h := NewUserInfoHandler(
+		&fakeTokenValidator{accessTokens: map[string]*model.AccessTokenResult{
+			"at": {JTI: "at", Issuer: testIssuerBaseURL + "/demo", Subject: "pairwise-sub", Scope: "openid email"},
+		}},
+		newFakeUserFinder(user),
+		&fakeAccessTokenStore{tokens: map[string]*model.AccessToken{"at": token}},
+		consents,
+		&ClientCertificateExtractor{},
+		fakeUserInfoSigner{},
+		fakeEncryptJWT,
+	)
+	return &userInfoFixture{handler: h, token: token, user: user, consents: consents}
+}
+
+func (f *userInfoFixture) get(t *testing.T, bearer string) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(http.MethodGet, "/demo/userinfo", nil)
+	if bearer != "" {
+		req.Header.Set("Authorization", "Bearer "+bearer)
+	}
+	rec := httptest.NewRecorder()
+	if err := f.handler.Handle(echo.New().NewContext(req, rec)); err != nil {
+		t.Fatal(err)
+	}
+	return rec
+}
+
+func TestUserInfoResponseFormats(t *testing.T) {
+	rs256 := "RS256"
+	oaep := "RSA-OAEP-256"
+	gcm := "A256GCM"
+	jwks := `{"keys":[]}`
+
+	tests := []struct {
+		name            string
+		client          model.Client
+		wantContentType string
+		wantPrefix      string
+	}{
+		{name: "JSON", client: model.Client{ClientID: "plain"}, wantContentType: echo.MIMEApplicationJSON, wantPrefix: "{"},
+		{name: "署名のみ", client: model.Client{ClientID: "signed", UserInfoSignedResponseAlg: &rs256}, wantContentType: "application/jwt", wantPrefix: `signed:{`},
+		// 署名してから暗号化し、入れ子であることを cty=JWT で示す
+		{name: "署名して暗号化", client: model.Client{ClientID: "nested", JWKS: &jwks, UserInfoSignedResponseAlg: &rs256, UserInfoEncryptedResponseAlg: &oaep, UserInfoEncryptedResponseEnc: &gcm}, wantContentType: "application/jwt", wantPrefix: "encrypted|RSA-OAEP-256|A256GCM|JWT|signed:{"},
+		{name: "暗号化のみ", client: model.Client{ClientID: "encrypted", JWKS: &jwks, UserInfoEncryptedResponseAlg: &oaep, UserInfoEncryptedResponseEnc: &gcm}, wantContentType: "application/jwt", wantPrefix: "encrypted|RSA-OAEP-256|A256GCM||{"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := newUserInfoFixture(tt.client, nil)
+			rec := f.get(t, "at")
+			if rec.Code != http.StatusOK {
+				t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+			}
+			if ct := rec.Header().Get(echo.HeaderContentType); !strings.HasPrefix(ct, tt.wantContentType) {
+				t.Errorf("content-type = %q", ct)
+			}
+			body := rec.Body.String()
+			if !strings.HasPrefix(body, tt.wantPrefix) {
+				t.Errorf("body = %s", body)
+			}
+			if !strings.Contains(body, `"sub":"pairwise-sub"`) || !strings.Contains(body, `"email":"alice@example.com"`) {
+				t.Errorf("claims missing: %s", body)
+			}
+		})
+	}
+}
+
+func TestUserInfoEncryptionWithoutJWKS(t *testing.T) {
+	oaep := "RSA-OAEP-256"
+	gcm := "A256GCM"
+	f := newUserInfoFixture(model.Client{ClientID: "broken", UserInfoEncryptedResponseAlg: &oaep, UserInfoEncryptedResponseEnc: &gcm}, nil)
+	if rec := f.get(t, "at"); rec.Code != http.StatusInternalServerError {
+		t.Errorf("status = %d, want 500", rec.Code)
+	}
+}
+
+func TestUserInfoRejectsInvalidToken(t *testing.T) {
+	f := newUserInfoFixture(model.Client{ClientID: "plain"}, nil)
+	for _, bearer := range []string{"", "unknown"} {
+		rec := f.get(t, bearer)
+		if rec.Code != http.StatusUnauthorized || rec.Header().Get("WWW-Authenticate") == "" {
+			t.Errorf("bearer %q: status = %d", bearer, rec.Code)
+		}
+	}
+	revoked := time.Now()
+	f.token.RevokedAt = &revoked
+	if rec := f.get(t, "at"); rec.Code != http.StatusUnauthorized {
+		t.Errorf("revoked: status = %d", rec.Code)
+	}
+}
+
+func TestUserInfoFiltersClaimsRequest(t *testing.T) {
+	schema := `{"type":"object","properties":{"department":{"type":"string"}}}`
+	client := model.Client{ID: uuid.New(), ClientID: "rp", AllowedScopes: model.StringSlice{"openid", "email", "employee"}}
+	f := newUserInfoFixture(client, &model.ClaimsRequest{UserInfo: map[string]*model.ClaimRequest{"department": nil, "phone_number": nil}})
+	f.user.CustomAttributes = model.UserAttributes{"department": "sales"}
+	f.user.Tenant = model.Tenant{
+		UserAttributeSchema: &schema,
+		ScopeDefinitions: []model.ScopeDefinition{{
+			Name: "employee", ConsentRequired: true,
+			Claims: model.ScopeClaimMappings{{Claim: "dept", Attribute: "department"}},
+		}},
+	}
+
+	claims := func() map[string]interface{} {
+		t.Helper()
+		rec := f.get(t, "at")
+		var body map[string]interface{}
+		if err := json.Unmarshal(rec.Body.Bytes(), &body); err != nil || rec.Code != http.StatusOK {
+			t.Fatalf("status = %d, body = %s", rec.Code, rec.Body)
+		}
+		return body
+	}
+
+	// phone スコープはクライアントに許可されていない
+	_ = f.consents.Save(context.Background(), &model.UserConsent{UserID: f.user.ID, ClientID: client.ID, Scopes: model.StringSlice{"employee"}})
+	body := claims()
+	if body["department"] != "sales" || body["phone_number"] != nil {
+		t.Errorf("claims = %v", body)
+	}
+
+	// 同意の取り消しやクライアントの設定変更はトークン発行後でも反映する
+	delete(f.consents.consents, client.ID)
+	if body := claims(); body["department"] != nil {
+		t.Errorf("after consent revoked: %v", body)
+	}
+	_ = f.consents.Save(context.Background(), &model.UserConsent{UserID: f.user.ID, ClientID: client.ID, Scopes: model.StringSlice{"employee"}})
+	f.token.Client.AllowedScopes = model.StringSlice{"openid", "email"}
+	if body := claims(); body["department"] != nil {
+		t.Errorf("after scope disallowed: %v", body)
+	}
+}
@@ -0,0 +1,196 @@
+package saml
+
+import (
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// assertionLifetime はアサーションの有効期間。受け取った SP がすぐに使う前提で短くする
+const assertionLifetime = 5 * time.Minute
+
+// assertionParams はアサーションの組み立てに使う値
+type assertionParams struct {
+	issuer       string
+	sp           *model.SAMLServiceProvider
+	session      *model.Session
+	samlSession  *model.SAMLSession
+	inResponseTo string
+	now          time.Time
+}
+
+// buildAssertion は署名付きのアサーションを返す (SAML Profiles 2.0 Section 4.1.4.2)
+func buildAssertion(p *assertionParams, key *rsa.PrivateKey, cert *x509.Certificate) (*element, error) {
+	id, err := newID()
+	if err != nil {
+		return nil, err
+	}
+	notOnOrAfter := formatTime(p.now.Add(assertionLifetime))
+
+	confirmationData := []attr{{"NotOnOrAfter", notOnOrAfter}, {"Recipient", p.sp.ACSURL}}
+	if p.inResponseTo != "" {
+		confirmationData = append(confirmationData, attr{"InResponseTo", p.inResponseTo})
+	}
+
+	assertion := newElement("saml:Assertion",
+		attr{"xmlns:saml", nsAssertion},
+		attr{"ID", id},
+		attr{"Version", "2.0"},
+		attr{"IssueInstant", formatTime(p.now)},
+	).add(
+		issuerElement(p.issuer),
+		newElement("saml:Subject").add(
+			textElement("saml:NameID", p.samlSession.NameID, attr{"Format", p.sp.NameIDFormat}),
+			newElement("saml:SubjectConfirmation", attr{"Method", subjectConfirmationBearer}).add(
+				newElement("saml:SubjectConfirmationData", confirmationData...),
+			),
+		),
+		newElement("saml:Conditions", attr{"NotBefore", formatTime(p.now)}, attr{"NotOnOrAfter", notOnOrAfter}).add(
+			newElement("saml:AudienceRestriction").add(textElement("saml:Audience", p.sp.EntityID)),
+		),
+		newElement("saml:AuthnStatement",
+			attr{"AuthnInstant", formatTime(p.session.CreatedAt)},
+			attr{"SessionIndex", p.samlSession.SessionIndex},
+			attr{"SessionNotOnOrAfter", formatTime(p.session.ExpiresAt)},
+		).add(
+			newElement("saml:AuthnContext").add(textElement("saml:AuthnContextClassRef", authnContextClass(p.session))),
+		),
+	)
+
+	user := p.session.User
+	user.Tenant = p.session.Tenant
+	if statement := attributeStatement(&user, p.sp.AttributeMappings); statement != nil {
+		assertion.add(statement)
+	}
+
+	if err := signEnveloped(assertion, id, key, cert); err != nil {
+		return nil, fmt.Errorf("failed to sign assertion: %w", err)
+	}
+	return assertion, nil
+}
+
+// authnContextClass はセッションの認証方式に対応する AuthnContext のクラスを返す。
+// 外部 IdP でのログインなど、パスワードを使っていない場合は unspecified
+func authnContextClass(session *model.Session) string {
+	for _, amr := range session.AMR {
+		if amr == model.AMRPassword {
+			return authnContextPasswordProtectedTransport
+		}
+	}
+	return authnContextUnspecified
+}
+
+// attributeStatement は属性マッピングに従い saml:AttributeStatement を返す。値が未設定の属性は含めない
+func attributeStatement(user *model.User, mappings model.SAMLAttributeMappings) *element {
+	statement := newElement("saml:AttributeStatement")
+	for _, m := range mappings {
+		values := userAttributeValues(user, m.Attribute)
+		if len(values) == 0 {
+			continue
+		}
+		attribute := newElement("saml:Attribute", attr{"Name", m.Name}, attr{"NameFormat", m.NameFormatURI()})
+		for _, v := range values {
+			attribute.add(textElement("saml:AttributeValue", v))
+		}
+		statement.add(attribute)
+	}
+	if len(statement.children) == 0 {
+		return nil
+	}
+	return statement
+}
+
+// userAttributeValues はユーザー属性の値を文字列で返す。配列のカスタム属性は複数の値になる。
+// カスタム属性はテナントのスキーマに現在定義されているもののみ返す
+func userAttributeValues(user *model.User, attribute string) []string {
+	switch attribute {
+	case model.FederatedLoginIDAttribute:
+		return []string{user.LoginID}
+	case "email":
+		return []string{user.Email}
+	case "email_verified":
+		return []string{fmt.Sprint(user.EmailVerified)}
+	case "phone_number_verified":
+		if user.PhoneNumber == nil {
+			return nil
+		}
+		return []string{fmt.Sprint(user.PhoneNumberVerified)}
+	case "updated_at":
+		return []string{formatTime(user.UpdatedAt)}
+	case "address":
+		if user.Address.IsEmpty() || user.Address.Formatted == "" {
+			return nil
+		}
+		return []string{user.Address.Formatted}
+	}
+
+	profile := map[string]*string{
+		"name": user.Name, "given_name": user.GivenName, "family_name": user.FamilyName,
+		"middle_name": user.MiddleName, "nickname": user.Nickname, "preferred_username": user.PreferredUsername,
+		"profile": user.Profile, "picture": user.Picture, "website": user.Website,
+		"gender": user.Gender, "birthdate": user.Birthdate, "zoneinfo": user.Zoneinfo, "locale": user.Locale,
+		"phone_number": user.PhoneNumber,
+	}
+	if v, ok := profile[attribute]; ok {
+		if v == nil {
+			return nil
+		}
+		return []string{*v}
+	}
+
+	for _, name := range user.Tenant.UserAttributeNames() {
+		if name != attribute {
+			continue
+		}
+		v, ok := user.CustomAttributes[name]
+		if !ok {
+			return nil
+		}
+		if list, ok := v.([]interface{}); ok {
+			values := make([]string, 0, len(list))
+			for _, item := range list {
+				values = append(values, formatAttributeValue(item))
+			}
+			return values
+		}
+		return []string{formatAttributeValue(v)}
+	}
+	return nil
+}
+
+// formatAttributeValue はカスタム属性の値を文字列にする。オブジェクトは JSON にする
+func formatAttributeValue(v interface{}) string {
+	switch val := v.(type) {
+	case string:
+		return val
+	case map[string]interface{}:
+		raw, _ := json.Marshal(val)
+		return string(raw)
+	default:
+		return fmt.Sprint(val)
+	}
+}
+
+// nameIDFor は SP の NameID の形式に従って NameID の値を返す。
+// persistent は SP ごとに異なる不透明な値 (テナントの pairwise 用の秘密の値を鍵にした HMAC)、transient はセッションごとの乱数
+func nameIDFor(sp *model.SAMLServiceProvider, session *model.Session) (string, error) {
+	switch sp.NameIDFormat {
+	case model.SAMLNameIDFormatEmailAddress:
+		return session.User.Email, nil
+	case model.SAMLNameIDFormatPersistent:
+		mac := hmac.New(sha256.New, []byte(session.Tenant.PairwiseSalt))
+		mac.Write([]byte(sp.EntityID + "|" + session.UserID.String()))
+		return base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+	case model.SAMLNameIDFormatTransient:
+		return newID()
+	default:
+		return session.User.LoginID, nil
+	}
+}
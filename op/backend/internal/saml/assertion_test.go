@@ -0,0 +1,156 @@
+package saml
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+func newAssertionParams() *assertionParams {
+	schema := `{"type":"object","properties":{"groups":{"type":"array"},"grade":{"type":"integer"}}}`
+	name := `Alice <"admin"> & Co.`
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	return &assertionParams{
+		issuer: "https://idp.example.com/demo/saml",
+		sp: &model.SAMLServiceProvider{
+			EntityID:     "https://sp.example.com",
+			ACSURL:       "https://sp.example.com/acs?a=1&b=2",
+			NameIDFormat: model.SAMLNameIDFormatEmailAddress,
+			AttributeMappings: model.SAMLAttributeMappings{
+				{Name: "mail", Attribute: "email"},
+				{Name: "urn:oid:2.5.4.3", Attribute: "name", NameFormat: "uri"},
+				{Name: "nickname", Attribute: "nickname"},
+				{Name: "memberOf", Attribute: "groups"},
+				{Name: "grade", Attribute: "grade", NameFormat: "unspecified"},
+				{Name: "undefined", Attribute: "not_in_schema"},
+			},
+		},
+		session: &model.Session{
+			ID:        uuid.New(),
+			AMR:       model.StringSlice{model.AMRPassword},
+			CreatedAt: now.Add(-time.Minute),
+			ExpiresAt: now.Add(time.Hour),
+			Tenant:    model.Tenant{UserAttributeSchema: &schema},
+			User: model.User{
+				Email:            "alice@example.com",
+				Name:             &name,
+				CustomAttributes: model.UserAttributes{"groups": []interface{}{"sales", "r&d"}, "grade": float64(3), "not_in_schema": "x"},
+			},
+		},
+		samlSession:  &model.SAMLSession{NameID: "alice@example.com", SessionIndex: "_s1"},
+		inResponseTo: "_req1",
+		now:          now,
+	}
+}
+
+func TestBuildAssertion(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newAssertionParams()
+	assertion, err := buildAssertion(p, key, &x509.Certificate{Raw: []byte("cert")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := assertion.String()
+	verifyEnveloped(t, signed, &key.PublicKey)
+
+	for _, want := range []string{
+		`<saml:Issuer>https://idp.example.com/demo/saml</saml:Issuer><ds:Signature `,
+		`<saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:emailAddress">alice@example.com</saml:NameID>`,
+		`<saml:SubjectConfirmationData InResponseTo="_req1" NotOnOrAfter="2026-01-01T00:05:00Z" Recipient="https://sp.example.com/acs?a=1&amp;b=2"></saml:SubjectConfirmationData>`,
+		`<saml:Conditions NotBefore="2026-01-01T00:00:00Z" NotOnOrAfter="2026-01-01T00:05:00Z"><saml:AudienceRestriction><saml:Audience>https://sp.example.com</saml:Audience>`,
+		`<saml:AuthnStatement AuthnInstant="2025-12-31T23:59:00Z" SessionIndex="_s1" SessionNotOnOrAfter="2026-01-01T01:00:00Z">`,
+		`<saml:AuthnContextClassRef>urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport</saml:AuthnContextClassRef>`,
+	} {
+		if !strings.Contains(signed, want) {
+			t.Errorf("missing %s", want)
+		}
+	}
+
+	// 属性はマッピングの順に並べ、値のない属性・スキーマにない属性は含めない
+	statement := regexp.MustCompile(`<saml:AttributeStatement>.*</saml:AttributeStatement>`).FindString(signed)
+	want := `<saml:AttributeStatement>` +
+		`<saml:Attribute Name="mail" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:basic"><saml:AttributeValue>alice@example.com</saml:AttributeValue></saml:Attribute>` +
+		`<saml:Attribute Name="urn:oid:2.5.4.3" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:uri"><saml:AttributeValue>Alice &lt;"admin"&gt; &amp; Co.</saml:AttributeValue></saml:Attribute>` +
+		`<saml:Attribute Name="memberOf" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:basic"><saml:AttributeValue>sales</saml:AttributeValue><saml:AttributeValue>r&amp;d</saml:AttributeValue></saml:Attribute>` +
+		`<saml:Attribute Name="grade" NameFormat="urn:oasis:names:tc:SAML:2.0:attrname-format:unspecified"><saml:AttributeValue>3</saml:AttributeValue></saml:Attribute>` +
+		`</saml:AttributeStatement>`
+	if statement != want {
+		t.Errorf("AttributeStatement\ngot  %s\nwant %s", statement, want)
+	}
+
+	// レスポンスに含めてもアサーションは自身で名前空間を宣言しているため、SP が取り出して検証できる
+	response := newResponse("_r1", p.issuer, p.sp.ACSURL, "_req1", p.now, statusElement(statusSuccess, "")).add(assertion).String()
+	if err := wellFormed(response); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(response, signed) {
+		t.Fatal("assertion changed in response")
+	}
+	verifyEnveloped(t, response[strings.Index(response, "<saml:Assertion "):strings.Index(response, "</samlp:Response>")], &key.PublicKey)
+}
+
+func TestBuildAssertionWithoutAttributes(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := newAssertionParams()
+	p.sp.AttributeMappings = model.SAMLAttributeMappings{{Name: "nickname", Attribute: "nickname"}}
+	p.session.AMR = model.StringSlice{}
+	p.inResponseTo = ""
+
+	assertion, err := buildAssertion(p, key, &x509.Certificate{Raw: []byte("cert")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := assertion.String()
+	verifyEnveloped(t, signed, &key.PublicKey)
+	// IdP-initiated SSO では InResponseTo を含めず、パスワードを使っていないセッションは unspecified
+	if strings.Contains(signed, "AttributeStatement") || strings.Contains(signed, "InResponseTo") || !strings.Contains(signed, authnContextUnspecified) {
+		t.Errorf("assertion = %s", signed)
+	}
+}
+
+func TestNameIDFor(t *testing.T) {
+	userID := uuid.New()
+	session := &model.Session{UserID: userID, User: model.User{LoginID: "alice", Email: "alice@example.com"}, Tenant: model.Tenant{PairwiseSalt: "salt"}}
+	sp := &model.SAMLServiceProvider{EntityID: "https://sp.example.com"}
+
+	for format, want := range map[string]string{
+		model.SAMLNameIDFormatEmailAddress: "alice@example.com",
+		model.SAMLNameIDFormatUnspecified:  "alice",
+	} {
+		sp.NameIDFormat = format
+		if got, _ := nameIDFor(sp, session); got != want {
+			t.Errorf("%s: got %s, want %s", format, got, want)
+		}
+	}
+
+	// persistent は SP ごとに異なり、同じ SP には同じ値を返す
+	sp.NameIDFormat = model.SAMLNameIDFormatPersistent
+	first, _ := nameIDFor(sp, session)
+	again, _ := nameIDFor(sp, session)
+	other, _ := nameIDFor(&model.SAMLServiceProvider{EntityID: "https://other.example.com", NameIDFormat: model.SAMLNameIDFormatPersistent}, session)
+	if first != again || first == other || strings.Contains(first, "alice") || strings.Contains(first, userID.String()) {
+		t.Errorf("persistent: %s, %s, %s", first, again, other)
+	}
+
+	// transient は毎回異なる
+	sp.NameIDFormat = model.SAMLNameIDFormatTransient
+	a, _ := nameIDFor(sp, session)
+	b, _ := nameIDFor(sp, session)
+	if a == b || !strings.HasPrefix(a, "_") {
+		t.Errorf("transient: %s, %s", a, b)
+	}
+}
@@ -0,0 +1,129 @@
+package saml
+
+import (
+	"bytes"
+	"compress/flate"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// バインディング (SAML Bindings 2.0 Section 3.4, 3.5)
+const (
+	bindingHTTPRedirect = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-Redirect"
+	bindingHTTPPost     = "urn:oasis:names:tc:SAML:2.0:bindings:HTTP-POST"
+)
+
+// maxMessageBytes は受け付けるメッセージ (展開後) の上限
+const maxMessageBytes = 64 * 1024
+
+// maxRelayStateBytes は受け付ける RelayState の上限。仕様上の上限は 80 バイトだが、SP の実装に合わせて緩める
+const maxRelayStateBytes = 1024
+
+var errInvalidMessage = errors.New("invalid saml message")
+
+// postFormTemplate は HTTP-POST バインディングで SP にメッセージを送る自動送信フォーム (SAML Bindings 2.0 Section 3.5.4)
+var postFormTemplate = template.Must(template.New("saml_post").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Submit This Form</title></head>
+<body onload="javascript:document.forms[0].submit()">
+<form method="post" action="{{.Action}}">
+{{- range $name, $values := .Params}}{{range $values}}
+<input type="hidden" name="{{$name}}" value="{{.}}"/>
+{{- end}}{{end}}
+<noscript><button type="submit">Continue</button></noscript>
+</form>
+</body>
+</html>
+`))
+
+// decodeRedirect は HTTP-Redirect バインディングのメッセージ (DEFLATE 圧縮 + Base64) を復元する (SAML Bindings 2.0 Section 3.4.4.1)
+func decodeRedirect(encoded string) ([]byte, error) {
+	compressed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errInvalidMessage
+	}
+	msg, err := io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(compressed)), maxMessageBytes+1))
+	if err != nil || len(msg) > maxMessageBytes {
+		return nil, errInvalidMessage
+	}
+	return msg, nil
+}
+
+// decodePost は HTTP-POST バインディングのメッセージ (Base64) を復元する (SAML Bindings 2.0 Section 3.5.4)
+func decodePost(encoded string) ([]byte, error) {
+	msg, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(msg) > maxMessageBytes {
+		return nil, errInvalidMessage
+	}
+	return msg, nil
+}
+
+// encodeRedirect はメッセージを HTTP-Redirect バインディングの形式にする。
+func encodeRedirect(msg []byte) (string, error) {
+	var buf bytes.Buffer
+	w, err := flate.NewWriter(&buf, flate.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return "", err
+	}
+	if err := w.Close(); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+// signedRedirectURL は HTTP-Redirect バインディングで送る署名付きの URL を返す。
+// 署名対象は SAMLRequest (SAMLResponse)・RelayState・SigAlg をこの順に連結したクエリ文字列 (SAML Bindings 2.0 Section 3.4.4.1)
+func signedRedirectURL(destination, param string, msg []byte, relayState string, key *rsa.PrivateKey) (string, error) {
+	encoded, err := encodeRedirect(msg)
+	if err != nil {
+		return "", err
+	}
+	query := param + "=" + url.QueryEscape(encoded)
+	if relayState != "" {
+		query += "&RelayState=" + url.QueryEscape(relayState)
+	}
+	query += "&SigAlg=" + url.QueryEscape(algRSASHA256)
+
+	hashed := sha256.Sum256([]byte(query))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return "", err
+	}
+	query += "&Signature=" + url.QueryEscape(base64.StdEncoding.EncodeToString(sig))
+
+	u, err := url.Parse(destination)
+	if err != nil {
+		return "", err
+	}
+	if u.RawQuery != "" {
+		query = u.RawQuery + "&" + query
+	}
+	u.RawQuery = query
+	return u.String(), nil
+}
+
+// renderPostForm はメッセージを SP へ POST する HTML を返す。レスポンスはキャッシュさせない (SAML Bindings 2.0 Section 3.5.5.1)
+func renderPostForm(c echo.Context, action string, params url.Values) error {
+	var buf bytes.Buffer
+	// action は登録済みの URL
+	data := map[string]interface{}{"Action": template.URL(action), "Params": params}
+	if err := postFormTemplate.Execute(&buf, data); err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	c.Response().Header().Set("Cache-Control", "no-cache, no-store")
+	c.Response().Header().Set("Pragma", "no-cache")
+	return c.HTMLBlob(http.StatusOK, buf.Bytes())
+}
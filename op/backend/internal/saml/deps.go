@@ -0,0 +1,43 @@
+// Package saml はテナントごとの SAML 2.0 IdP (SAML のみに対応するアプリケーション向けのブリッジ) を実装する。
+// 認証は OP のセッション (op_session) とログイン画面を共用する。
+package saml
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type TenantFinder interface {
+	FindByCode(ctx context.Context, code string) (*model.Tenant, error)
+}
+
+type ServiceProviderFinder interface {
+	FindByEntityID(ctx context.Context, tenantID uuid.UUID, entityID string) (*model.SAMLServiceProvider, error)
+}
+
+type SessionValidator interface {
+	ValidateSession(ctx context.Context, sessionID uuid.UUID) (*model.Session, error)
+}
+
+type SessionRevoker interface {
+	Revoke(ctx context.Context, id uuid.UUID) error
+}
+
+type SAMLSessionStore interface {
+	FindBySessionAndServiceProvider(ctx context.Context, sessionID, serviceProviderID uuid.UUID) (*model.SAMLSession, error)
+	Create(ctx context.Context, s *model.SAMLSession) error
+	FindActiveBySessionIndex(ctx context.Context, serviceProviderID uuid.UUID, sessionIndex string) (*model.SAMLSession, error)
+	FindByID(ctx context.Context, id uuid.UUID) (*model.SAMLSession, error)
+	StartLogout(ctx context.Context, id uuid.UUID, requestID, relayState string) (bool, error)
+	NextForLogout(ctx context.Context, sessionID uuid.UUID) (*model.SAMLSession, error)
+}
+
+// CertificateProvider はアサーション・メッセージの署名に使う鍵と、その公開鍵の証明書を提供する。
+type CertificateProvider interface {
+	GetActiveSigningCertificate(ctx context.Context) (*x509.Certificate, *rsa.PrivateKey, error)
+}
@@ -0,0 +1,141 @@
+package saml
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/xml"
+	"time"
+)
+
+// ステータスコード (SAML Core 2.0 Section 3.2.2.2)
+const (
+	statusSuccess             = "urn:oasis:names:tc:SAML:2.0:status:Success"
+	statusRequester           = "urn:oasis:names:tc:SAML:2.0:status:Requester"
+	statusResponder           = "urn:oasis:names:tc:SAML:2.0:status:Responder"
+	statusNoPassive           = "urn:oasis:names:tc:SAML:2.0:status:NoPassive"
+	statusInvalidNameIDPolicy = "urn:oasis:names:tc:SAML:2.0:status:InvalidNameIDPolicy"
+	statusUnsupportedBinding  = "urn:oasis:names:tc:SAML:2.0:status:UnsupportedBinding"
+)
+
+// AuthnContext のクラス (SAML Authn Context 2.0 Section 3.4)
+const (
+	authnContextPasswordProtectedTransport = "urn:oasis:names:tc:SAML:2.0:ac:classes:PasswordProtectedTransport"
+	authnContextUnspecified                = "urn:oasis:names:tc:SAML:2.0:ac:classes:unspecified"
+)
+
+// subjectConfirmationBearer は Web Browser SSO Profile のサブジェクト確認方法 (SAML Profiles 2.0 Section 4.1.4.2)
+const subjectConfirmationBearer = "urn:oasis:names:tc:SAML:2.0:cm:bearer"
+
+// authnRequest は SP からの AuthnRequest のうち使う項目 (SAML Core 2.0 Section 3.4.1)
+type authnRequest struct {
+	XMLName                     xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol AuthnRequest"`
+	ID                          string   `xml:"ID,attr"`
+	Version                     string   `xml:"Version,attr"`
+	IssueInstant                string   `xml:"IssueInstant,attr"`
+	Destination                 string   `xml:"Destination,attr"`
+	AssertionConsumerServiceURL string   `xml:"AssertionConsumerServiceURL,attr"`
+	ProtocolBinding             string   `xml:"ProtocolBinding,attr"`
+	ForceAuthn                  bool     `xml:"ForceAuthn,attr"`
+	IsPassive                   bool     `xml:"IsPassive,attr"`
+	Issuer                      string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameIDPolicy                *struct {
+		Format string `xml:"Format,attr"`
+	} `xml:"urn:oasis:names:tc:SAML:2.0:protocol NameIDPolicy"`
+}
+
+// logoutRequest は SP からの LogoutRequest のうち使う項目 (SAML Core 2.0 Section 3.7.1)
+type logoutRequest struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutRequest"`
+	ID           string   `xml:"ID,attr"`
+	Version      string   `xml:"Version,attr"`
+	Destination  string   `xml:"Destination,attr"`
+	NotOnOrAfter string   `xml:"NotOnOrAfter,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+	NameID       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion NameID"`
+	SessionIndex []string `xml:"urn:oasis:names:tc:SAML:2.0:protocol SessionIndex"`
+}
+
+// logoutResponse は SLO の伝播先の SP からの LogoutResponse のうち使う項目 (SAML Core 2.0 Section 3.7.2)
+type logoutResponse struct {
+	XMLName      xml.Name `xml:"urn:oasis:names:tc:SAML:2.0:protocol LogoutResponse"`
+	InResponseTo string   `xml:"InResponseTo,attr"`
+	Issuer       string   `xml:"urn:oasis:names:tc:SAML:2.0:assertion Issuer"`
+}
+
+// newID はメッセージ・アサーションの ID を生成する。xs:ID は数字で始められないため "_" を前置する
+func newID() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "_" + hex.EncodeToString(b), nil
+}
+
+// formatTime は xs:dateTime (UTC) に変換する (SAML Core 2.0 Section 1.3.3)
+func formatTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+// parseTime は xs:dateTime を解析する。
+func parseTime(s string) (time.Time, error) {
+	return time.Parse(time.RFC3339Nano, s)
+}
+
+// issuerElement は saml:Issuer 要素を返す。
+func issuerElement(entityID string) *element {
+	return textElement("saml:Issuer", entityID)
+}
+
+// statusElement は samlp:Status 要素を返す。第2レベルのコードがある場合は Requester / Responder の下に置く
+func statusElement(code, subCode string) *element {
+	status := newElement("samlp:StatusCode", attr{"Value", code})
+	if subCode != "" {
+		status.add(newElement("samlp:StatusCode", attr{"Value", subCode}))
+	}
+	return newElement("samlp:Status").add(status)
+}
+
+// newResponse は samlp:Response 要素を返す。inResponseTo は IdP-initiated SSO の場合は空
+func newResponse(id, issuer, destination, inResponseTo string, now time.Time, status *element) *element {
+	attrs := []attr{
+		{"xmlns:samlp", nsProtocol},
+		{"xmlns:saml", nsAssertion},
+		{"ID", id},
+		{"Version", "2.0"},
+		{"IssueInstant", formatTime(now)},
+		{"Destination", destination},
+	}
+	if inResponseTo != "" {
+		attrs = append(attrs, attr{"InResponseTo", inResponseTo})
+	}
+	return newElement("samlp:Response", attrs...).add(issuerElement(issuer), status)
+}
+
+// newLogoutRequest は SLO の伝播先の SP に送る samlp:LogoutRequest 要素を返す。
+func newLogoutRequest(id, issuer, destination, nameIDFormat, nameID, sessionIndex string, now time.Time) *element {
+	return newElement("samlp:LogoutRequest",
+		attr{"xmlns:samlp", nsProtocol},
+		attr{"xmlns:saml", nsAssertion},
+		attr{"ID", id},
+		attr{"Version", "2.0"},
+		attr{"IssueInstant", formatTime(now)},
+		attr{"Destination", destination},
+	).add(
+		issuerElement(issuer),
+		textElement("saml:NameID", nameID, attr{"Format", nameIDFormat}),
+		textElement("samlp:SessionIndex", sessionIndex),
+	)
+}
+
+// newLogoutResponse は LogoutRequest を送った SP に返す samlp:LogoutResponse 要素を返す。
+func newLogoutResponse(id, issuer, destination, inResponseTo string, now time.Time, status *element) *element {
+	return newElement("samlp:LogoutResponse",
+		attr{"xmlns:samlp", nsProtocol},
+		attr{"xmlns:saml", nsAssertion},
+		attr{"ID", id},
+		attr{"Version", "2.0"},
+		attr{"IssueInstant", formatTime(now)},
+		attr{"Destination", destination},
+		attr{"InResponseTo", inResponseTo},
+	).add(issuerElement(issuer), status)
+}
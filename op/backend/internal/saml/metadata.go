@@ -0,0 +1,80 @@
+package saml
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// idpEndpoints はテナントの SAML IdP の entityID とエンドポイントの URL
+type idpEndpoints struct {
+	entityID string
+	ssoURL   string
+	sloURL   string
+}
+
+// endpointsFor はテナントの SAML IdP の URL を返す。entityID はメタデータの URL とする
+func endpointsFor(baseURL, tenantCode string) idpEndpoints {
+	prefix := baseURL + "/" + tenantCode + "/saml"
+	return idpEndpoints{
+		entityID: prefix + "/metadata",
+		ssoURL:   prefix + "/sso",
+		sloURL:   prefix + "/slo",
+	}
+}
+
+// MetadataHandler はテナントの SAML IdP のメタデータを返す。
+type MetadataHandler struct {
+	tenantFinder TenantFinder
+	certProvider CertificateProvider
+	baseURL      string
+}
+
+func NewMetadataHandler(tenantFinder TenantFinder, certProvider CertificateProvider, baseURL string) *MetadataHandler {
+	return &MetadataHandler{tenantFinder: tenantFinder, certProvider: certProvider, baseURL: baseURL}
+}
+
+// Handle は GET /:tenant_code/saml/metadata を処理する (SAML Metadata 2.0 Section 2.4.3)
+func (h *MetadataHandler) Handle(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	cert, _, err := h.certProvider.GetActiveSigningCertificate(ctx)
+	if err != nil {
+		c.Logger().Errorf("failed to get signing certificate: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	ep := endpointsFor(h.baseURL, tenantCode)
+	descriptor := newElement("md:IDPSSODescriptor",
+		attr{"WantAuthnRequestsSigned", "false"},
+		attr{"protocolSupportEnumeration", nsProtocol},
+	).add(
+		newElement("md:KeyDescriptor", attr{"use", "signing"}).add(keyInfo(cert)),
+		newElement("md:SingleLogoutService", attr{"Binding", bindingHTTPRedirect}, attr{"Location", ep.sloURL}),
+		newElement("md:SingleLogoutService", attr{"Binding", bindingHTTPPost}, attr{"Location", ep.sloURL}),
+	)
+	for _, format := range model.SAMLNameIDFormats {
+		descriptor.add(textElement("md:NameIDFormat", format))
+	}
+	descriptor.add(
+		newElement("md:SingleSignOnService", attr{"Binding", bindingHTTPRedirect}, attr{"Location", ep.ssoURL}),
+		newElement("md:SingleSignOnService", attr{"Binding", bindingHTTPPost}, attr{"Location", ep.ssoURL}),
+	)
+	metadata := newElement("md:EntityDescriptor",
+		attr{"xmlns:md", nsMetadata},
+		attr{"entityID", ep.entityID},
+	).add(descriptor)
+
+	return c.Blob(http.StatusOK, "application/samlmetadata+xml", []byte(metadata.String()))
+}
@@ -0,0 +1,237 @@
+package saml
+
+import (
+	"encoding/xml"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// SLOHandler は SP-initiated の Single Logout を処理する (SAML Profiles 2.0 Section 4.4)。
+// SP から LogoutRequest を受けると OP のセッションを失効させ、同じセッションでログインした他の SP に
+// 順に LogoutRequest を送ってから、最初の SP に LogoutResponse を返す。
+// SP へのメッセージは署名付きの HTTP-Redirect バインディングで送る。SP からのメッセージの署名は検証しない
+type SLOHandler struct {
+	tenantFinder     TenantFinder
+	spFinder         ServiceProviderFinder
+	samlSessionStore SAMLSessionStore
+	sessionRevoker   SessionRevoker
+	certProvider     CertificateProvider
+	baseURL          string
+	isSecure         bool
+}
+
+func NewSLOHandler(
+	tenantFinder TenantFinder,
+	spFinder ServiceProviderFinder,
+	samlSessionStore SAMLSessionStore,
+	sessionRevoker SessionRevoker,
+	certProvider CertificateProvider,
+	baseURL string,
+	isSecure bool,
+) *SLOHandler {
+	return &SLOHandler{
+		tenantFinder:     tenantFinder,
+		spFinder:         spFinder,
+		samlSessionStore: samlSessionStore,
+		sessionRevoker:   sessionRevoker,
+		certProvider:     certProvider,
+		baseURL:          baseURL,
+		isSecure:         isSecure,
+	}
+}
+
+// Handle は GET (HTTP-Redirect バインディング) と POST (HTTP-POST バインディング) の /:tenant_code/saml/slo を処理する。
+// SAMLRequest は SP からのログアウトの要求、SAMLResponse は伝播先の SP からの応答
+func (h *SLOHandler) Handle(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	decode := decodeRedirect
+	if c.Request().Method == http.MethodPost {
+		decode = decodePost
+	}
+	relayState := c.FormValue("RelayState")
+	if len(relayState) > maxRelayStateBytes {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "RelayState is too long"})
+	}
+
+	ep := endpointsFor(h.baseURL, tenantCode)
+	if encoded := c.FormValue("SAMLRequest"); encoded != "" {
+		msg, err := decode(encoded)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest is invalid"})
+		}
+		return h.handleLogoutRequest(c, ep, tenant, msg, relayState)
+	}
+	if encoded := c.FormValue("SAMLResponse"); encoded != "" {
+		msg, err := decode(encoded)
+		if err != nil {
+			return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLResponse is invalid"})
+		}
+		return h.handleLogoutResponse(c, ep, tenant, msg, relayState)
+	}
+	return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest or SAMLResponse is required"})
+}
+
+// handleLogoutRequest は SP からの LogoutRequest を処理する (SAML Core 2.0 Section 3.7.3.2)
+func (h *SLOHandler) handleLogoutRequest(c echo.Context, ep idpEndpoints, tenant *model.Tenant, msg []byte, relayState string) error {
+	ctx := c.Request().Context()
+
+	var req logoutRequest
+	if err := xml.Unmarshal(msg, &req); err != nil || req.ID == "" || req.Issuer == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest is not a valid LogoutRequest"})
+	}
+
+	sp, err := h.spFinder.FindByEntityID(ctx, tenant.ID, req.Issuer)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	// LogoutResponse の送り先がない SP は SLO に参加できない
+	if sp == nil || sp.Status != "active" || sp.SLOURL == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unknown service provider or single logout is not configured"})
+	}
+
+	switch {
+	case req.Version != "2.0":
+		return h.respond(c, ep, sp, req.ID, relayState, statusRequester)
+	case req.Destination != "" && req.Destination != ep.sloURL:
+		return h.respond(c, ep, sp, req.ID, relayState, statusRequester)
+	case req.NotOnOrAfter != "":
+		notOnOrAfter, err := parseTime(req.NotOnOrAfter)
+		if err != nil || !time.Now().Before(notOnOrAfter) {
+			return h.respond(c, ep, sp, req.ID, relayState, statusRequester)
+		}
+	}
+
+	var initiator *model.SAMLSession
+	for _, index := range req.SessionIndex {
+		s, err := h.samlSessionStore.FindActiveBySessionIndex(ctx, sp.ID, index)
+		if err != nil {
+			c.Logger().Errorf("failed to find saml session: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		if s != nil && s.NameID == req.NameID {
+			initiator = s
+			break
+		}
+	}
+	// 該当するセッションがない (既にログアウト済み) 場合はそのまま成功を返す
+	if initiator == nil {
+		return h.respond(c, ep, sp, req.ID, relayState, statusSuccess)
+	}
+
+	started, err := h.samlSessionStore.StartLogout(ctx, initiator.ID, req.ID, relayState)
+	if err != nil {
+		c.Logger().Errorf("failed to start saml logout: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if !started {
+		return h.respond(c, ep, sp, req.ID, relayState, statusSuccess)
+	}
+	if err := h.sessionRevoker.Revoke(ctx, initiator.SessionID); err != nil {
+		c.Logger().Errorf("failed to revoke session: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	c.SetCookie(&http.Cookie{
+		Name:     "op_session",
+		Value:    "",
+		Path:     "/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   h.isSecure,
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	initiator.ServiceProvider = *sp
+	initiator.LogoutRequestID = &req.ID
+	if relayState != "" {
+		initiator.LogoutRelayState = &relayState
+	}
+	return h.continueLogout(c, ep, initiator)
+}
+
+// handleLogoutResponse は伝播先の SP からの LogoutResponse を処理し、次の SP に進む。
+// RelayState には LogoutRequest を送った SP の SAML セッションの ID を入れている
+func (h *SLOHandler) handleLogoutResponse(c echo.Context, ep idpEndpoints, tenant *model.Tenant, msg []byte, relayState string) error {
+	var res logoutResponse
+	if err := xml.Unmarshal(msg, &res); err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLResponse is not a valid LogoutResponse"})
+	}
+	id, err := uuid.Parse(relayState)
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "RelayState is invalid"})
+	}
+
+	initiator, err := h.samlSessionStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find saml session: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if initiator == nil || initiator.ServiceProvider.TenantID != tenant.ID || initiator.LogoutRequestID == nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "RelayState is invalid"})
+	}
+	return h.continueLogout(c, ep, initiator)
+}
+
+// continueLogout はログアウトしていない次の SP に LogoutRequest を送る。
+// 残っていない場合は LogoutRequest を送ってきた SP に LogoutResponse を返す
+func (h *SLOHandler) continueLogout(c echo.Context, ep idpEndpoints, initiator *model.SAMLSession) error {
+	ctx := c.Request().Context()
+
+	next, err := h.samlSessionStore.NextForLogout(ctx, initiator.SessionID)
+	if err != nil {
+		c.Logger().Errorf("failed to find next saml session for logout: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if next == nil {
+		relayState := ""
+		if initiator.LogoutRelayState != nil {
+			relayState = *initiator.LogoutRelayState
+		}
+		return h.respond(c, ep, &initiator.ServiceProvider, *initiator.LogoutRequestID, relayState, statusSuccess)
+	}
+
+	id, err := newID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	sp := &next.ServiceProvider
+	req := newLogoutRequest(id, ep.entityID, *sp.SLOURL, sp.NameIDFormat, next.NameID, next.SessionIndex, time.Now())
+	return h.redirect(c, *sp.SLOURL, "SAMLRequest", req, initiator.ID.String())
+}
+
+// respond は SP に LogoutResponse を返す。
+func (h *SLOHandler) respond(c echo.Context, ep idpEndpoints, sp *model.SAMLServiceProvider, inResponseTo, relayState, code string) error {
+	id, err := newID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	res := newLogoutResponse(id, ep.entityID, *sp.SLOURL, inResponseTo, time.Now(), statusElement(code, ""))
+	return h.redirect(c, *sp.SLOURL, "SAMLResponse", res, relayState)
+}
+
+func (h *SLOHandler) redirect(c echo.Context, destination, param string, msg *element, relayState string) error {
+	_, key, err := h.certProvider.GetActiveSigningCertificate(c.Request().Context())
+	if err != nil {
+		c.Logger().Errorf("failed to get signing key: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	u, err := signedRedirectURL(destination, param, []byte(msg.String()), relayState, key)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	return c.Redirect(http.StatusFound, u)
+}
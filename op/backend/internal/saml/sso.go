@@ -0,0 +1,295 @@
+package saml
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// SSOHandler は SP-initiated / IdP-initiated の Web Browser SSO を処理する (SAML Profiles 2.0 Section 4.1)。
+// 認証は OP のセッションを使い、セッションがなければ OP Frontend のログイン画面にリダイレクトする。
+type SSOHandler struct {
+	tenantFinder     TenantFinder
+	spFinder         ServiceProviderFinder
+	sessionValidator SessionValidator
+	samlSessionStore SAMLSessionStore
+	certProvider     CertificateProvider
+	baseURL          string
+	loginPageURL     string
+}
+
+func NewSSOHandler(
+	tenantFinder TenantFinder,
+	spFinder ServiceProviderFinder,
+	sessionValidator SessionValidator,
+	samlSessionStore SAMLSessionStore,
+	certProvider CertificateProvider,
+	baseURL string,
+	loginPageURL string,
+) *SSOHandler {
+	return &SSOHandler{
+		tenantFinder:     tenantFinder,
+		spFinder:         spFinder,
+		sessionValidator: sessionValidator,
+		samlSessionStore: samlSessionStore,
+		certProvider:     certProvider,
+		baseURL:          baseURL,
+		loginPageURL:     loginPageURL,
+	}
+}
+
+// HandlePost は POST /:tenant_code/saml/sso (HTTP-POST バインディング) を処理する。
+// SP のサイトからの POST には SameSite=Lax の op_session Cookie が送られないため、
+// HTTP-Redirect バインディングの形式に変換して GET でやり直させる。ログイン後の戻り先にもこの URL を使う。
+func (h *SSOHandler) HandlePost(c echo.Context) error {
+	msg, err := decodePost(c.FormValue("SAMLRequest"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest is invalid"})
+	}
+	encoded, err := encodeRedirect(msg)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	q := url.Values{"SAMLRequest": {encoded}}
+	if relayState := c.FormValue("RelayState"); relayState != "" {
+		q.Set("RelayState", relayState)
+	}
+	return c.Redirect(http.StatusSeeOther, c.Request().URL.Path+"?"+q.Encode())
+}
+
+// Handle は GET /:tenant_code/saml/sso (HTTP-Redirect バインディング) を処理する。
+// AuthnRequest の署名は検証しない。レスポンスは登録済みの AssertionConsumerService にのみ送る
+func (h *SSOHandler) Handle(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	msg, err := decodeRedirect(c.QueryParam("SAMLRequest"))
+	if err != nil {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest is invalid"})
+	}
+	var req authnRequest
+	if err := xml.Unmarshal(msg, &req); err != nil || req.ID == "" || req.Issuer == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "SAMLRequest is not a valid AuthnRequest"})
+	}
+	relayState := c.QueryParam("RelayState")
+	if len(relayState) > maxRelayStateBytes {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "RelayState is too long"})
+	}
+
+	sp, err := h.spFinder.FindByEntityID(ctx, tenant.ID, req.Issuer)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if sp == nil || sp.Status != "active" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unknown service provider"})
+	}
+	// 登録と異なる URL にはエラーも送らない
+	if req.AssertionConsumerServiceURL != "" && req.AssertionConsumerServiceURL != sp.ACSURL {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "AssertionConsumerServiceURL is not registered"})
+	}
+
+	ep := endpointsFor(h.baseURL, tenantCode)
+	switch {
+	case req.Version != "2.0":
+		return h.sendError(c, ep, sp, req.ID, relayState, statusRequester, "")
+	case req.Destination != "" && req.Destination != ep.ssoURL:
+		return h.sendError(c, ep, sp, req.ID, relayState, statusRequester, "")
+	case req.ProtocolBinding != "" && req.ProtocolBinding != bindingHTTPPost:
+		return h.sendError(c, ep, sp, req.ID, relayState, statusRequester, statusUnsupportedBinding)
+	case req.NameIDPolicy != nil && req.NameIDPolicy.Format != "" &&
+		req.NameIDPolicy.Format != model.SAMLNameIDFormatUnspecified && req.NameIDPolicy.Format != sp.NameIDFormat:
+		return h.sendError(c, ep, sp, req.ID, relayState, statusRequester, statusInvalidNameIDPolicy)
+	}
+
+	session := h.currentSession(c, tenant)
+	// ForceAuthn の場合は AuthnRequest の発行後にログインしたセッションのみ使う
+	if session != nil && req.ForceAuthn {
+		issuedAt, err := parseTime(req.IssueInstant)
+		if err != nil {
+			return h.sendError(c, ep, sp, req.ID, relayState, statusRequester, "")
+		}
+		if session.CreatedAt.Before(issuedAt) {
+			session = nil
+		}
+	}
+	if session == nil {
+		if req.IsPassive {
+			return h.sendError(c, ep, sp, req.ID, relayState, statusResponder, statusNoPassive)
+		}
+		return h.redirectToFrontend(c, "/login", "redirect_after_login", tenantCode)
+	}
+	// テナントがメールアドレスの確認を求める場合、未確認のユーザーは確認画面へ
+	if session.Tenant.RequiresVerifiedEmailForAuthorization() && !session.User.EmailVerified {
+		if req.IsPassive {
+			return h.sendError(c, ep, sp, req.ID, relayState, statusResponder, statusNoPassive)
+		}
+		return h.redirectToFrontend(c, "/verify-email", "redirect_after_verify", tenantCode)
+	}
+
+	return h.sendAssertion(c, ep, sp, session, req.ID, relayState)
+}
+
+// HandleIdPInitiated は GET /:tenant_code/saml/idp-initiated?sp={entityID}&RelayState=... を処理する。
+// AuthnRequest なしで SP にアサーションを送る (SAML Profiles 2.0 Section 4.1.5)。SP ごとに許可が必要
+func (h *SSOHandler) HandleIdPInitiated(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenantCode := c.Param("tenant_code")
+
+	tenant, err := h.tenantFinder.FindByCode(ctx, tenantCode)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if tenant == nil {
+		return c.JSON(http.StatusNotFound, map[string]string{"error": "not_found"})
+	}
+
+	entityID := c.QueryParam("sp")
+	relayState := c.QueryParam("RelayState")
+	if entityID == "" {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "sp is required"})
+	}
+	if len(relayState) > maxRelayStateBytes {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "RelayState is too long"})
+	}
+
+	sp, err := h.spFinder.FindByEntityID(ctx, tenant.ID, entityID)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if sp == nil || sp.Status != "active" || !sp.IdPInitiatedSSOEnabled {
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "unknown service provider or idp-initiated sso is not enabled"})
+	}
+
+	session := h.currentSession(c, tenant)
+	if session == nil {
+		return h.redirectToFrontend(c, "/login", "redirect_after_login", tenantCode)
+	}
+	if session.Tenant.RequiresVerifiedEmailForAuthorization() && !session.User.EmailVerified {
+		return h.redirectToFrontend(c, "/verify-email", "redirect_after_verify", tenantCode)
+	}
+
+	return h.sendAssertion(c, endpointsFor(h.baseURL, tenantCode), sp, session, "", relayState)
+}
+
+// currentSession は op_session Cookie のセッションを返す。無効な場合やテナントが異なる場合は nil
+func (h *SSOHandler) currentSession(c echo.Context, tenant *model.Tenant) *model.Session {
+	cookie, err := c.Cookie("op_session")
+	if err != nil {
+		return nil
+	}
+	sid, err := uuid.Parse(cookie.Value)
+	if err != nil {
+		return nil
+	}
+	session, err := h.sessionValidator.ValidateSession(c.Request().Context(), sid)
+	if err != nil || session == nil || session.TenantID != tenant.ID {
+		return nil
+	}
+	return session
+}
+
+// sendAssertion は署名付きのアサーションを含むレスポンスを SP の AssertionConsumerService に POST する。
+// SLO に使うため、OP のセッションと SP の組ごとに NameID と SessionIndex を記録する
+func (h *SSOHandler) sendAssertion(c echo.Context, ep idpEndpoints, sp *model.SAMLServiceProvider, session *model.Session, inResponseTo, relayState string) error {
+	ctx := c.Request().Context()
+
+	samlSession, err := h.samlSessionStore.FindBySessionAndServiceProvider(ctx, session.ID, sp.ID)
+	if err != nil {
+		c.Logger().Errorf("failed to find saml session: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	if samlSession == nil {
+		nameID, err := nameIDFor(sp, session)
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		sessionIndex, err := newID()
+		if err != nil {
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+		samlSession = &model.SAMLSession{
+			SessionID:         session.ID,
+			ServiceProviderID: sp.ID,
+			NameID:            nameID,
+			SessionIndex:      sessionIndex,
+		}
+		if err := h.samlSessionStore.Create(ctx, samlSession); err != nil {
+			c.Logger().Errorf("failed to create saml session: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		}
+	}
+
+	cert, key, err := h.certProvider.GetActiveSigningCertificate(ctx)
+	if err != nil {
+		c.Logger().Errorf("failed to get signing certificate: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	now := time.Now()
+	assertion, err := buildAssertion(&assertionParams{
+		issuer:       ep.entityID,
+		sp:           sp,
+		session:      session,
+		samlSession:  samlSession,
+		inResponseTo: inResponseTo,
+		now:          now,
+	}, key, cert)
+	if err != nil {
+		c.Logger().Errorf("failed to build assertion: %v", err)
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+
+	id, err := newID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	response := newResponse(id, ep.entityID, sp.ACSURL, inResponseTo, now, statusElement(statusSuccess, "")).add(assertion)
+	return h.post(c, sp, response, relayState)
+}
+
+// sendError はエラーのステータスのレスポンスを SP の AssertionConsumerService に POST する (SAML Core 2.0 Section 3.2.2)
+func (h *SSOHandler) sendError(c echo.Context, ep idpEndpoints, sp *model.SAMLServiceProvider, inResponseTo, relayState, code, subCode string) error {
+	id, err := newID()
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	response := newResponse(id, ep.entityID, sp.ACSURL, inResponseTo, time.Now(), statusElement(code, subCode))
+	return h.post(c, sp, response, relayState)
+}
+
+func (h *SSOHandler) post(c echo.Context, sp *model.SAMLServiceProvider, response *element, relayState string) error {
+	params := url.Values{"SAMLResponse": {base64.StdEncoding.EncodeToString([]byte(response.String()))}}
+	if relayState != "" {
+		params.Set("RelayState", relayState)
+	}
+	return renderPostForm(c, sp.ACSURL, params)
+}
+
+// redirectToFrontend は OP Frontend のログイン画面・メールアドレス確認画面にリダイレクトする。
+// 完了後に SSO をやり直すため、現在の URL を戻り先として渡す
+func (h *SSOHandler) redirectToFrontend(c echo.Context, path, returnParam, tenantCode string) error {
+	u, err := url.Parse(h.loginPageURL + path)
+	if err != nil {
+		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "server_error"})
+	}
+	q := u.Query()
+	q.Set("tenant_code", tenantCode)
+	q.Set(returnParam, c.Request().URL.String())
+	u.RawQuery = q.Encode()
+	return c.Redirect(http.StatusFound, u.String())
+}
@@ -0,0 +1,158 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"sort"
+	"strings"
+	"unicode/utf8"
+)
+
+// XML 名前空間
+const (
+	nsProtocol  = "urn:oasis:names:tc:SAML:2.0:protocol"
+	nsAssertion = "urn:oasis:names:tc:SAML:2.0:assertion"
+	nsMetadata  = "urn:oasis:names:tc:SAML:2.0:metadata"
+	nsDSig      = "http://www.w3.org/2000/09/xmldsig#"
+)
+
+// XML 署名のアルゴリズム (XML Signature Syntax and Processing 1.1, RFC 6931)
+const (
+	algExcC14N            = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	algEnvelopedSignature = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	algRSASHA256          = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	algSHA256             = "http://www.w3.org/2001/04/xmlenc#sha256"
+)
+
+// element は送信する XML の要素。
+// 署名の検証側が計算する Exclusive XML Canonicalization (exc-c14n) の結果と一致する形で書き出すため、
+// 要素の間に空白を入れず、名前空間宣言は使用する要素に置き、属性は名前順に並べる。
+// 属性は名前空間なし (xmlns 宣言を除く) のもののみ使う。
+type element struct {
+	name     string
+	attrs    []attr
+	children []*element
+	text     string
+}
+
+type attr struct {
+	name  string
+	value string
+}
+
+func newElement(name string, attrs ...attr) *element {
+	return &element{name: name, attrs: attrs}
+}
+
+// textElement はテキストのみを持つ要素を返す。
+func textElement(name, text string, attrs ...attr) *element {
+	return &element{name: name, attrs: attrs, text: text}
+}
+
+func (e *element) add(children ...*element) *element {
+	e.children = append(e.children, children...)
+	return e
+}
+
+// String は要素を exc-c14n 形式で返す (XML Exclusive Canonicalization 1.0 Section 3, Canonical XML 1.0 Section 2.3)
+func (e *element) String() string {
+	var b strings.Builder
+	e.write(&b)
+	return b.String()
+}
+
+func (e *element) write(b *strings.Builder) {
+	attrs := make([]attr, len(e.attrs))
+	copy(attrs, e.attrs)
+	// 名前空間宣言 (接頭辞の順) → 属性 (名前の順)
+	sort.SliceStable(attrs, func(i, j int) bool {
+		ni, nj := strings.HasPrefix(attrs[i].name, "xmlns"), strings.HasPrefix(attrs[j].name, "xmlns")
+		if ni != nj {
+			return ni
+		}
+		return attrs[i].name < attrs[j].name
+	})
+
+	b.WriteString("<")
+	b.WriteString(e.name)
+	for _, a := range attrs {
+		b.WriteString(" ")
+		b.WriteString(a.name)
+		b.WriteString(`="`)
+		b.WriteString(escapeAttr(a.value))
+		b.WriteString(`"`)
+	}
+	b.WriteString(">")
+	b.WriteString(escapeText(e.text))
+	for _, child := range e.children {
+		child.write(b)
+	}
+	// 空要素も開始タグと終了タグの組で書く
+	b.WriteString("</")
+	b.WriteString(e.name)
+	b.WriteString(">")
+}
+
+var (
+	textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	attrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeText(s string) string { return textEscaper.Replace(validXMLChars(s)) }
+func escapeAttr(s string) string { return attrEscaper.Replace(validXMLChars(s)) }
+
+// validXMLChars は XML 1.0 で使えない文字 (制御文字・不正な UTF-8) を取り除く。
+func validXMLChars(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r == utf8.RuneError || (r < 0x20 && r != '\t' && r != '\n' && r != '\r') || r == 0xFFFE || r == 0xFFFF {
+			return -1
+		}
+		return r
+	}, s)
+}
+
+// signEnveloped は要素に enveloped 署名を付ける。署名は最初の子要素 (Issuer) の直後に置く (SAML Core 2.0 Section 5.4.1)。
+// 参照する要素の ID 属性は id であること。
+func signEnveloped(e *element, id string, key *rsa.PrivateKey, cert *x509.Certificate) error {
+	digest := sha256.Sum256([]byte(e.String()))
+
+	signedInfo := newElement("ds:SignedInfo", attr{"xmlns:ds", nsDSig}).add(
+		newElement("ds:CanonicalizationMethod", attr{"Algorithm", algExcC14N}),
+		newElement("ds:SignatureMethod", attr{"Algorithm", algRSASHA256}),
+		newElement("ds:Reference", attr{"URI", "#" + id}).add(
+			newElement("ds:Transforms").add(
+				newElement("ds:Transform", attr{"Algorithm", algEnvelopedSignature}),
+				newElement("ds:Transform", attr{"Algorithm", algExcC14N}),
+			),
+			newElement("ds:DigestMethod", attr{"Algorithm", algSHA256}),
+			textElement("ds:DigestValue", base64.StdEncoding.EncodeToString(digest[:])),
+		),
+	)
+	hashed := sha256.Sum256([]byte(signedInfo.String()))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	signature := newElement("ds:Signature", attr{"xmlns:ds", nsDSig}).add(
+		signedInfo,
+		textElement("ds:SignatureValue", base64.StdEncoding.EncodeToString(sig)),
+		keyInfo(cert),
+	)
+	children := append([]*element{e.children[0], signature}, e.children[1:]...)
+	e.children = children
+	return nil
+}
+
+// keyInfo は署名の検証に使う証明書を示す ds:KeyInfo 要素を返す。
+func keyInfo(cert *x509.Certificate) *element {
+	return newElement("ds:KeyInfo", attr{"xmlns:ds", nsDSig}).add(
+		newElement("ds:X509Data").add(
+			textElement("ds:X509Certificate", base64.StdEncoding.EncodeToString(cert.Raw)),
+		),
+	)
+}
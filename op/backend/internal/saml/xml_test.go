@@ -0,0 +1,186 @@
+package saml
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"io"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+// 以下の期待値は xmllint --exc-c14n (libxml2) で正規化しても変わらないことを確認した値。
+// ダイジェストは正規化の結果の SHA-256 を openssl dgst で計算した値。
+
+// testUnsignedAssertion は署名のテストに使う固定のアサーション
+func testUnsignedAssertion() *element {
+	return newElement("saml:Assertion", attr{"xmlns:saml", nsAssertion}, attr{"ID", "_a1"}, attr{"Version", "2.0"}, attr{"IssueInstant", "2026-01-01T00:00:00Z"}).add(
+		issuerElement("https://idp.example.com/demo/saml"),
+		newElement("saml:Subject").add(textElement("saml:NameID", "alice", attr{"Format", "urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified"})),
+	)
+}
+
+const (
+	testUnsignedAssertionC14N = `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_a1" IssueInstant="2026-01-01T00:00:00Z" Version="2.0">` +
+		`<saml:Issuer>https://idp.example.com/demo/saml</saml:Issuer>` +
+		`<saml:Subject><saml:NameID Format="urn:oasis:names:tc:SAML:1.1:nameid-format:unspecified">alice</saml:NameID></saml:Subject>` +
+		`</saml:Assertion>`
+	testUnsignedAssertionDigest = "4X9Nm8UmpR+3z9YxYgq1sOMj3zcqI3o92rccC4XPIIw="
+	testSignedInfoC14N          = `<ds:SignedInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#">` +
+		`<ds:CanonicalizationMethod Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:CanonicalizationMethod>` +
+		`<ds:SignatureMethod Algorithm="http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"></ds:SignatureMethod>` +
+		`<ds:Reference URI="#_a1"><ds:Transforms>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2000/09/xmldsig#enveloped-signature"></ds:Transform>` +
+		`<ds:Transform Algorithm="http://www.w3.org/2001/10/xml-exc-c14n#"></ds:Transform>` +
+		`</ds:Transforms><ds:DigestMethod Algorithm="http://www.w3.org/2001/04/xmlenc#sha256"></ds:DigestMethod>` +
+		`<ds:DigestValue>4X9Nm8UmpR+3z9YxYgq1sOMj3zcqI3o92rccC4XPIIw=</ds:DigestValue></ds:Reference></ds:SignedInfo>`
+)
+
+func TestElementCanonicalization(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *element
+		want string
+	}{
+		{
+			name: "固定のアサーション",
+			e:    testUnsignedAssertion(),
+			want: testUnsignedAssertionC14N,
+		},
+		{
+			name: "名前空間宣言を先に、属性を名前 (コードポイント) の順に並べる",
+			e:    newElement("saml:Attribute", attr{"NameFormat", "b"}, attr{"Name", "n"}, attr{"xmlns:saml", nsAssertion}, attr{"FriendlyName", "f"}, attr{"a", "x"}, attr{"Z", "y"}),
+			want: `<saml:Attribute xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" FriendlyName="f" Name="n" NameFormat="b" Z="y" a="x"></saml:Attribute>`,
+		},
+		{
+			name: "子孫は祖先の名前空間宣言を使い、別の名前空間の要素は自身で宣言する",
+			e: newElement("saml:Assertion", attr{"ID", "_a1"}, attr{"xmlns:saml", nsAssertion}).add(
+				newElement("saml:Subject").add(textElement("saml:NameID", "a")),
+				newElement("ds:KeyInfo", attr{"xmlns:ds", nsDSig}).add(textElement("ds:KeyName", "k")),
+			),
+			want: `<saml:Assertion xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" ID="_a1"><saml:Subject><saml:NameID>a</saml:NameID></saml:Subject>` +
+				`<ds:KeyInfo xmlns:ds="http://www.w3.org/2000/09/xmldsig#"><ds:KeyName>k</ds:KeyName></ds:KeyInfo></saml:Assertion>`,
+		},
+		{
+			name: "属性値とテキストのエスケープ",
+			e: newElement("saml:Attribute", attr{"xmlns:saml", nsAssertion}, attr{"Name", "a&b<c>d\"e'f\tg\nh\ri"}).add(
+				textElement("saml:AttributeValue", "a&b<c>d\"e'f\tg\nh\ri"),
+				textElement("saml:AttributeValue", ""),
+			),
+			want: `<saml:Attribute xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" Name="a&amp;b&lt;c>d&quot;e'f&#x9;g&#xA;h&#xD;i">` +
+				"<saml:AttributeValue>a&amp;b&lt;c&gt;d\"e'f\tg\nh&#xD;i</saml:AttributeValue><saml:AttributeValue></saml:AttributeValue></saml:Attribute>",
+		},
+		{
+			name: "XML で使えない文字を取り除く",
+			e:    textElement("saml:AttributeValue", "a\x00b\x01c\xffd￾e", attr{"xmlns:saml", nsAssertion}, attr{"Name", "x\x1by"}),
+			want: `<saml:AttributeValue xmlns:saml="urn:oasis:names:tc:SAML:2.0:assertion" Name="xy">abcde</saml:AttributeValue>`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.e.String()
+			if got != tt.want {
+				t.Errorf("got  %s\nwant %s", got, tt.want)
+			}
+			if err := wellFormed(got); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSignEnveloped(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := testUnsignedAssertion()
+	if err := signEnveloped(e, "_a1", key, &x509.Certificate{Raw: []byte("cert")}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 署名は Issuer の直後に置く
+	if len(e.children) != 3 || e.children[0].name != "saml:Issuer" || e.children[1].name != "ds:Signature" || e.children[2].name != "saml:Subject" {
+		t.Fatalf("children = %v", e.children)
+	}
+	signature := e.children[1]
+	if got := signature.children[0].String(); got != testSignedInfoC14N {
+		t.Errorf("SignedInfo\ngot  %s\nwant %s", got, testSignedInfoC14N)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signature.children[1].text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256([]byte(testSignedInfoC14N))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, hashed[:], sig); err != nil {
+		t.Errorf("signature: %v", err)
+	}
+	if got := signature.children[2].String(); !strings.Contains(got, "<ds:X509Certificate>Y2VydA==</ds:X509Certificate>") {
+		t.Errorf("KeyInfo = %s", got)
+	}
+
+	if id := verifyEnveloped(t, e.String(), &key.PublicKey); id != "_a1" {
+		t.Errorf("id = %s", id)
+	}
+}
+
+var (
+	signaturePattern   = regexp.MustCompile(`<ds:Signature xmlns:ds="[^"]*">.*?</ds:Signature>`)
+	signedInfoPattern  = regexp.MustCompile(`<ds:SignedInfo xmlns:ds="[^"]*">.*?</ds:SignedInfo>`)
+	referencePattern   = regexp.MustCompile(`<ds:Reference URI="#([^"]*)">`)
+	digestValuePattern = regexp.MustCompile(`<ds:DigestValue>([^<]*)</ds:DigestValue>`)
+	signatureValuePat  = regexp.MustCompile(`<ds:SignatureValue>([^<]*)</ds:SignatureValue>`)
+	rootIDPattern      = regexp.MustCompile(`^<[^ >]+ [^>]*\bID="([^"]*)"`)
+)
+
+// verifyEnveloped は SP と同じ手順で enveloped 署名を検証し、署名された要素の ID を返す。
+// ds:Signature を除いた signed と ds:SignedInfo は exc-c14n 形式で書き出されていること (TestElementCanonicalization で確認している)。
+// 正規化済みのため、enveloped-signature 変換は ds:Signature 要素を取り除くだけでよい
+func verifyEnveloped(t *testing.T, signed string, pub *rsa.PublicKey) string {
+	t.Helper()
+	if err := wellFormed(signed); err != nil {
+		t.Fatal(err)
+	}
+	signatures := signaturePattern.FindAllString(signed, -1)
+	if len(signatures) != 1 {
+		t.Fatalf("%d signatures", len(signatures))
+	}
+	signedInfo := signedInfoPattern.FindString(signatures[0])
+	ref := referencePattern.FindStringSubmatch(signedInfo)
+	root := rootIDPattern.FindStringSubmatch(signed)
+	if ref == nil || root == nil || ref[1] != root[1] {
+		t.Fatalf("reference %v does not point to the root %v", ref, root)
+	}
+
+	digest := sha256.Sum256([]byte(strings.Replace(signed, signatures[0], "", 1)))
+	if want := digestValuePattern.FindStringSubmatch(signedInfo); want == nil || want[1] != base64.StdEncoding.EncodeToString(digest[:]) {
+		t.Fatalf("digest mismatch: %v", want)
+	}
+	sig, err := base64.StdEncoding.DecodeString(signatureValuePat.FindStringSubmatch(signatures[0])[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256([]byte(signedInfo))
+	if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig); err != nil {
+		t.Fatalf("signature: %v", err)
+	}
+	return ref[1]
+}
+
+// wellFormed は s が整形式の XML か確認する
+func wellFormed(s string) error {
+	d := xml.NewDecoder(strings.NewReader(s))
+	for {
+		if _, err := d.Token(); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+	}
+}
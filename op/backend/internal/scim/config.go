@@ -0,0 +1,196 @@
+package scim
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ConfigHandler は SCIM のサービスプロバイダの設定を返すエンドポイント (RFC 7644 Section 4) を処理する。
+type ConfigHandler struct {
+	baseURL string
+}
+
+func NewConfigHandler(baseURL string) *ConfigHandler {
+	return &ConfigHandler{baseURL: baseURL}
+}
+
+type supported struct {
+	Supported bool `json:"supported"`
+}
+
+type filterSupported struct {
+	Supported  bool `json:"supported"`
+	MaxResults int  `json:"maxResults"`
+}
+
+type bulkSupported struct {
+	Supported      bool `json:"supported"`
+	MaxOperations  int  `json:"maxOperations"`
+	MaxPayloadSize int  `json:"maxPayloadSize"`
+}
+
+type authenticationScheme struct {
+	Type        string `json:"type"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	SpecURI     string `json:"specUri"`
+	Primary     bool   `json:"primary"`
+}
+
+// HandleServiceProviderConfig は GET /scim/v2/:tenant_code/ServiceProviderConfig を処理する (RFC 7643 Section 5)。
+func (h *ConfigHandler) HandleServiceProviderConfig(c echo.Context) error {
+	return writeJSON(c, http.StatusOK, map[string]interface{}{
+		"schemas":        []string{schemaServiceProviderConfig},
+		"patch":          supported{Supported: true},
+		"bulk":           bulkSupported{Supported: false},
+		"filter":         filterSupported{Supported: true, MaxResults: maxCount},
+		"changePassword": supported{Supported: true},
+		"sort":           supported{Supported: false},
+		"etag":           supported{Supported: true},
+		"authenticationSchemes": []authenticationScheme{{
+			Type:        "oauthbearertoken",
+			Name:        "OAuth Bearer Token",
+			Description: "Authentication with a SCIM token issued by the management API",
+			SpecURI:     "https://www.rfc-editor.org/info/rfc6750",
+			Primary:     true,
+		}},
+		"meta": meta{
+			ResourceType: "ServiceProviderConfig",
+			Location:     h.endpoint(c, "/ServiceProviderConfig"),
+		},
+	})
+}
+
+// HandleResourceTypes は GET /scim/v2/:tenant_code/ResourceTypes を処理する (RFC 7643 Section 6)。
+func (h *ConfigHandler) HandleResourceTypes(c echo.Context) error {
+	resources := []interface{}{
+		h.resourceType(c, "User", "/Users", "User Account", schemaUser),
+		h.resourceType(c, "Group", "/Groups", "Group", schemaGroup),
+	}
+	return writeJSON(c, http.StatusOK, listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// HandleSchemas は GET /scim/v2/:tenant_code/Schemas を処理する (RFC 7643 Section 7)。
+func (h *ConfigHandler) HandleSchemas(c echo.Context) error {
+	resources := []interface{}{
+		h.schema(c, schemaUser, "User", "User Account", userSchemaAttributes),
+		h.schema(c, schemaGroup, "Group", "Group", groupSchemaAttributes),
+	}
+	return writeJSON(c, http.StatusOK, listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: int64(len(resources)),
+		StartIndex:   1,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+func (h *ConfigHandler) resourceType(c echo.Context, name, endpoint, description, schema string) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{schemaResourceType},
+		"id":          name,
+		"name":        name,
+		"endpoint":    endpoint,
+		"description": description,
+		"schema":      schema,
+		"meta": meta{
+			ResourceType: "ResourceType",
+			Location:     h.endpoint(c, "/ResourceTypes/"+name),
+		},
+	}
+}
+
+func (h *ConfigHandler) schema(c echo.Context, id, name, description string, attributes []schemaAttribute) map[string]interface{} {
+	return map[string]interface{}{
+		"schemas":     []string{schemaSchema},
+		"id":          id,
+		"name":        name,
+		"description": description,
+		"attributes":  attributes,
+		"meta": meta{
+			ResourceType: "Schema",
+			Location:     h.endpoint(c, "/Schemas/"+id),
+		},
+	}
+}
+
+func (h *ConfigHandler) endpoint(c echo.Context, path string) string {
+	return fmt.Sprintf("%s/scim/v2/%s%s", h.baseURL, url.PathEscape(c.Param("tenant_code")), path)
+}
+
+// schemaAttribute は属性の定義 (RFC 7643 Section 7)
+type schemaAttribute struct {
+	Name           string            `json:"name"`
+	Type           string            `json:"type"`
+	MultiValued    bool              `json:"multiValued"`
+	Required       bool              `json:"required"`
+	CaseExact      bool              `json:"caseExact"`
+	Mutability     string            `json:"mutability"`
+	Returned       string            `json:"returned"`
+	Uniqueness     string            `json:"uniqueness"`
+	SubAttributes  []schemaAttribute `json:"subAttributes,omitempty"`
+	ReferenceTypes []string          `json:"referenceTypes,omitempty"`
+}
+
+func stringAttr(name string) schemaAttribute {
+	return schemaAttribute{Name: name, Type: "string", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+}
+
+func multiValuedAttr(name string, subAttributes ...schemaAttribute) schemaAttribute {
+	return schemaAttribute{Name: name, Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none", SubAttributes: subAttributes}
+}
+
+var (
+	typeAttr    = stringAttr("type")
+	primaryAttr = schemaAttribute{Name: "primary", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"}
+	valueAttr   = stringAttr("value")
+)
+
+var userSchemaAttributes = []schemaAttribute{
+	{Name: "userName", Type: "string", Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "server"},
+	{Name: "name", Type: "complex", Mutability: "readWrite", Returned: "default", Uniqueness: "none", SubAttributes: []schemaAttribute{
+		stringAttr("formatted"), stringAttr("familyName"), stringAttr("givenName"), stringAttr("middleName"),
+	}},
+	stringAttr("displayName"),
+	stringAttr("nickName"),
+	{Name: "profileUrl", Type: "reference", Mutability: "readWrite", Returned: "default", Uniqueness: "none", ReferenceTypes: []string{"external"}},
+	stringAttr("locale"),
+	stringAttr("timezone"),
+	{Name: "active", Type: "boolean", Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+	{Name: "password", Type: "string", Mutability: "writeOnly", Returned: "never", Uniqueness: "none"},
+	{Name: "emails", Type: "complex", MultiValued: true, Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "server", SubAttributes: []schemaAttribute{
+		valueAttr, typeAttr, primaryAttr,
+	}},
+	multiValuedAttr("phoneNumbers", valueAttr, typeAttr, primaryAttr),
+	multiValuedAttr("addresses",
+		stringAttr("formatted"), stringAttr("streetAddress"), stringAttr("locality"), stringAttr("region"),
+		stringAttr("postalCode"), stringAttr("country"), typeAttr, primaryAttr,
+	),
+	{Name: "groups", Type: "complex", MultiValued: true, Mutability: "readOnly", Returned: "default", Uniqueness: "none", SubAttributes: []schemaAttribute{
+		{Name: "value", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+		{Name: "$ref", Type: "reference", Mutability: "readOnly", Returned: "default", Uniqueness: "none", ReferenceTypes: []string{"Group"}},
+		{Name: "display", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+		{Name: "type", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+	}},
+	{Name: "externalId", Type: "string", CaseExact: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+}
+
+var groupSchemaAttributes = []schemaAttribute{
+	{Name: "displayName", Type: "string", Required: true, Mutability: "readWrite", Returned: "default", Uniqueness: "server"},
+	{Name: "members", Type: "complex", MultiValued: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none", SubAttributes: []schemaAttribute{
+		{Name: "value", Type: "string", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+		{Name: "$ref", Type: "reference", Mutability: "immutable", Returned: "default", Uniqueness: "none", ReferenceTypes: []string{"User"}},
+		{Name: "display", Type: "string", Mutability: "readOnly", Returned: "default", Uniqueness: "none"},
+		{Name: "type", Type: "string", Mutability: "immutable", Returned: "default", Uniqueness: "none"},
+	}},
+	{Name: "externalId", Type: "string", CaseExact: true, Mutability: "readWrite", Returned: "default", Uniqueness: "none"},
+}
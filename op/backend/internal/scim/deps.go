@@ -0,0 +1,64 @@
+// Package scim はテナントごとの SCIM 2.0 (RFC 7643 / RFC 7644) のプロビジョニング API を実装する。
+// 人事システムなどの SCIM クライアントが、管理 API で発行した Bearer トークンでユーザーとグループを登録・更新・削除する。
+package scim
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+type TenantFinder interface {
+	FindByCode(ctx context.Context, code string) (*model.Tenant, error)
+}
+
+type TokenStore interface {
+	// FindValidByHash は有効期限内のトークンをハッシュ値で検索する。見つからない場合は (nil, nil) を返す。
+	FindValidByHash(ctx context.Context, tenantID uuid.UUID, tokenHash string) (*model.SCIMToken, error)
+	UpdateLastUsedAt(ctx context.Context, id uuid.UUID, t time.Time) error
+}
+
+type UserStore interface {
+	// SearchActive は削除されていないユーザーを検索し、offset から limit 件と総件数を返す。
+	SearchActive(ctx context.Context, tenantID uuid.UUID, where string, args []interface{}, offset, limit int) ([]model.User, int64, error)
+	// FindByID はテナントもプリロードする。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.User, error)
+	FindByTenantAndLoginID(ctx context.Context, tenantID uuid.UUID, loginID string) (*model.User, error)
+	// FindByTenantAndEmail は大文字小文字を区別せずに検索する。
+	FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error)
+	CreateWithPassword(ctx context.Context, user *model.User, passwordHash string) error
+	UpdateProfile(ctx context.Context, user *model.User) error
+	SetPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error
+	// SoftDelete は status を "deleted" にしてグループから外す。
+	SoftDelete(ctx context.Context, id uuid.UUID) error
+	// FindActiveIDs は ids のうちテナントに属する削除されていないユーザーの ID を返す。
+	FindActiveIDs(ctx context.Context, tenantID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error)
+}
+
+type GroupStore interface {
+	// Search はグループを検索し、offset から limit 件と総件数を返す。メンバーのユーザーもプリロードする。
+	Search(ctx context.Context, tenantID uuid.UUID, where string, args []interface{}, offset, limit int) ([]model.Group, int64, error)
+	// FindByID はメンバーのユーザーもプリロードする。見つからない場合は (nil, nil) を返す。
+	FindByID(ctx context.Context, id uuid.UUID) (*model.Group, error)
+	FindByDisplayName(ctx context.Context, tenantID uuid.UUID, displayName string) (*model.Group, error)
+	Create(ctx context.Context, group *model.Group) error
+	// Update はグループの変更を保存し、メンバーを group.Members で置き換える。
+	Update(ctx context.Context, group *model.Group) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	// ListByMemberIDs はユーザーが所属するグループをユーザー ID ごとに返す。
+	ListByMemberIDs(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]model.Group, error)
+}
+
+// UserRevoker はユーザーのセッションとトークンを失効させる。インシデント対応のユーザー単位の失効と同じ処理を使う。
+type UserRevoker interface {
+	RevokeUser(ctx context.Context, userID uuid.UUID) (sessions, accessTokens, refreshTokens int64, err error)
+}
+
+// HashTokenFunc はトークンを保存用に SHA-256 でハッシュ化する (hex エンコード)。
+type HashTokenFunc func(token string) string
+
+// HashPasswordFunc は平文パスワードを argon2id でハッシュ化する。
+type HashPasswordFunc func(ctx context.Context, password string) (string, error)
@@ -0,0 +1,606 @@
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// フィルタ式 (RFC 7644 Section 3.4.2.2) の構文木
+type filterNode interface{}
+
+type logicalNode struct {
+	op          string // "and" / "or"
+	left, right filterNode
+}
+
+type notNode struct {
+	inner filterNode
+}
+
+type compareNode struct {
+	path  string // 小文字化した属性のパス ("emails.value" など)
+	op    string // "eq" / "ne" / "co" / "sw" / "ew" / "gt" / "ge" / "lt" / "le" / "pr"
+	value interface{}
+}
+
+// valuePathNode は複数値属性の要素に対する条件 ("emails[type eq \"work\"]")
+type valuePathNode struct {
+	attr   string
+	filter filterNode
+}
+
+type filterToken struct {
+	kind  string // "(" / ")" / "[" / "]" / "word" / "value"
+	text  string
+	value interface{}
+}
+
+func tokenizeFilter(s string) ([]filterToken, error) {
+	var tokens []filterToken
+	for i := 0; i < len(s); {
+		ch := s[i]
+		switch {
+		case ch == ' ' || ch == '\t':
+			i++
+		case ch == '(' || ch == ')' || ch == '[' || ch == ']':
+			tokens = append(tokens, filterToken{kind: string(ch)})
+			i++
+		case ch == '"':
+			end := i + 1
+			for end < len(s) && s[end] != '"' {
+				if s[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(s) {
+				return nil, fmt.Errorf("unterminated string")
+			}
+			var v string
+			if err := json.Unmarshal([]byte(s[i:end+1]), &v); err != nil {
+				return nil, fmt.Errorf("invalid string")
+			}
+			tokens = append(tokens, filterToken{kind: "value", value: v})
+			i = end + 1
+		default:
+			end := i
+			for end < len(s) && !strings.ContainsRune(" \t()[]\"", rune(s[end])) {
+				end++
+			}
+			tokens = append(tokens, filterToken{kind: "word", text: s[i:end]})
+			i = end
+		}
+	}
+	return tokens, nil
+}
+
+type filterParser struct {
+	tokens []filterToken
+	pos    int
+}
+
+// parseFilter はフィルタ式を構文木に変換する。
+func parseFilter(s string) (filterNode, error) {
+	tokens, err := tokenizeFilter(s)
+	if err != nil {
+		return nil, err
+	}
+	p := &filterParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.tokens) {
+		return nil, fmt.Errorf("unexpected token at %d", p.pos)
+	}
+	return node, nil
+}
+
+func (p *filterParser) peek() *filterToken {
+	if p.pos >= len(p.tokens) {
+		return nil
+	}
+	return &p.tokens[p.pos]
+}
+
+func (p *filterParser) peekKeyword(keyword string) bool {
+	t := p.peek()
+	return t != nil && t.kind == "word" && strings.EqualFold(t.text, keyword)
+}
+
+func (p *filterParser) expect(kind string) error {
+	t := p.peek()
+	if t == nil || t.kind != kind {
+		return fmt.Errorf("%q is expected", kind)
+	}
+	p.pos++
+	return nil
+}
+
+func (p *filterParser) parseOr() (filterNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("or") {
+		p.pos++
+		right, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "or", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseAnd() (filterNode, error) {
+	left, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peekKeyword("and") {
+		p.pos++
+		right, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		left = &logicalNode{op: "and", left: left, right: right}
+	}
+	return left, nil
+}
+
+func (p *filterParser) parseNot() (filterNode, error) {
+	if !p.peekKeyword("not") {
+		return p.parseAtom()
+	}
+	p.pos++
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	inner, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+	return &notNode{inner: inner}, nil
+}
+
+func (p *filterParser) parseAtom() (filterNode, error) {
+	t := p.peek()
+	if t == nil {
+		return nil, fmt.Errorf("unexpected end of filter")
+	}
+	if t.kind == "(" {
+		p.pos++
+		node, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(")"); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+	if t.kind != "word" {
+		return nil, fmt.Errorf("attribute path is expected")
+	}
+	p.pos++
+	path := strings.ToLower(stripSchemaPrefix(t.text))
+
+	if next := p.peek(); next != nil && next.kind == "[" {
+		p.pos++
+		inner, err := p.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+		return &valuePathNode{attr: path, filter: inner}, nil
+	}
+
+	opToken := p.peek()
+	if opToken == nil || opToken.kind != "word" {
+		return nil, fmt.Errorf("operator is expected")
+	}
+	p.pos++
+	op := strings.ToLower(opToken.text)
+	if op == "pr" {
+		return &compareNode{path: path, op: op}, nil
+	}
+	switch op {
+	case "eq", "ne", "co", "sw", "ew", "gt", "ge", "lt", "le":
+	default:
+		return nil, fmt.Errorf("unknown operator %q", opToken.text)
+	}
+
+	valueToken := p.peek()
+	if valueToken == nil {
+		return nil, fmt.Errorf("comparison value is expected")
+	}
+	p.pos++
+	value, err := comparisonValue(valueToken)
+	if err != nil {
+		return nil, err
+	}
+	return &compareNode{path: path, op: op, value: value}, nil
+}
+
+// comparisonValue は比較値の JSON リテラル (文字列、数値、true / false / null) を取り出す。
+func comparisonValue(t *filterToken) (interface{}, error) {
+	if t.kind == "value" {
+		return t.value, nil
+	}
+	if t.kind != "word" {
+		return nil, fmt.Errorf("comparison value is expected")
+	}
+	switch strings.ToLower(t.text) {
+	case "true":
+		return true, nil
+	case "false":
+		return false, nil
+	case "null":
+		return nil, nil
+	}
+	var n float64
+	if err := json.Unmarshal([]byte(t.text), &n); err != nil {
+		return nil, fmt.Errorf("invalid comparison value %q", t.text)
+	}
+	return n, nil
+}
+
+// attrKind は属性の型 (RFC 7643 Section 2.3)
+type attrKind int
+
+const (
+	kindString attrKind = iota
+	kindBoolean
+	kindDateTime
+)
+
+// attrSpec はフィルタで使える属性と SQL の式の対応
+type attrSpec struct {
+	expr      string
+	kind      attrKind
+	caseExact bool
+	// exists は式を相関サブクエリで評価する場合の EXISTS 句 (%s に条件が入る)
+	exists string
+}
+
+var userAttrSpecs = map[string]attrSpec{
+	"id":                 {expr: "users.id::text", caseExact: true},
+	"externalid":         {expr: "users.external_id", caseExact: true},
+	"username":           {expr: "users.login_id"},
+	"displayname":        {expr: "users.name"},
+	"name.formatted":     {expr: "users.name"},
+	"name.givenname":     {expr: "users.given_name"},
+	"name.familyname":    {expr: "users.family_name"},
+	"name.middlename":    {expr: "users.middle_name"},
+	"nickname":           {expr: "users.nickname"},
+	"locale":             {expr: "users.locale"},
+	"timezone":           {expr: "users.zoneinfo"},
+	"active":             {expr: "(users.status = 'active')", kind: kindBoolean},
+	"emails":             {expr: "users.email"},
+	"emails.value":       {expr: "users.email"},
+	"emails.type":        {expr: "'work'"},
+	"emails.primary":     {expr: "TRUE", kind: kindBoolean},
+	"phonenumbers":       {expr: "users.phone_number"},
+	"phonenumbers.value": {expr: "users.phone_number"},
+	"phonenumbers.type":  {expr: "CASE WHEN users.phone_number IS NULL THEN NULL ELSE 'mobile' END"},
+	"groups":             {expr: "group_members.group_id::text", caseExact: true, exists: "EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND %s)"},
+	"groups.value":       {expr: "group_members.group_id::text", caseExact: true, exists: "EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND %s)"},
+	"meta.created":       {expr: "users.created_at", kind: kindDateTime},
+	"meta.lastmodified":  {expr: "users.updated_at", kind: kindDateTime},
+}
+
+var groupAttrSpecs = map[string]attrSpec{
+	"id":                {expr: "groups.id::text", caseExact: true},
+	"externalid":        {expr: "groups.external_id", caseExact: true},
+	"displayname":       {expr: "groups.display_name"},
+	"members":           {expr: "group_members.user_id::text", caseExact: true, exists: "EXISTS (SELECT 1 FROM group_members WHERE group_members.group_id = groups.id AND %s)"},
+	"members.value":     {expr: "group_members.user_id::text", caseExact: true, exists: "EXISTS (SELECT 1 FROM group_members WHERE group_members.group_id = groups.id AND %s)"},
+	"meta.created":      {expr: "groups.created_at", kind: kindDateTime},
+	"meta.lastmodified": {expr: "groups.updated_at", kind: kindDateTime},
+}
+
+// compileFilter はフィルタ式をプレースホルダ付きの SQL の条件式に変換する。specs にない属性はエラーにする
+func compileFilter(filter string, specs map[string]attrSpec) (string, []interface{}, error) {
+	if strings.TrimSpace(filter) == "" {
+		return "", nil, nil
+	}
+	node, err := parseFilter(filter)
+	if err != nil {
+		return "", nil, err
+	}
+	var args []interface{}
+	where, err := compileNode(node, "", specs, &args)
+	if err != nil {
+		return "", nil, err
+	}
+	return where, args, nil
+}
+
+func compileNode(node filterNode, prefix string, specs map[string]attrSpec, args *[]interface{}) (string, error) {
+	switch n := node.(type) {
+	case *logicalNode:
+		left, err := compileNode(n.left, prefix, specs, args)
+		if err != nil {
+			return "", err
+		}
+		right, err := compileNode(n.right, prefix, specs, args)
+		if err != nil {
+			return "", err
+		}
+		return "(" + left + " " + strings.ToUpper(n.op) + " " + right + ")", nil
+	case *notNode:
+		inner, err := compileNode(n.inner, prefix, specs, args)
+		if err != nil {
+			return "", err
+		}
+		return "NOT " + inner, nil
+	case *valuePathNode:
+		if prefix != "" {
+			return "", fmt.Errorf("nested value path is not supported")
+		}
+		return compileNode(n.filter, n.attr+".", specs, args)
+	case *compareNode:
+		return compileCompare(n, prefix, specs, args)
+	}
+	return "", fmt.Errorf("unknown filter node")
+}
+
+func compileCompare(n *compareNode, prefix string, specs map[string]attrSpec, args *[]interface{}) (string, error) {
+	path := prefix + n.path
+	spec, ok := specs[path]
+	if !ok {
+		return "", fmt.Errorf("attribute %q is not supported in filter", path)
+	}
+	cond, err := compareSQL(spec, n.op, n.value, args)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+	if spec.exists != "" {
+		return fmt.Sprintf(spec.exists, cond), nil
+	}
+	return cond, nil
+}
+
+func compareSQL(spec attrSpec, op string, value interface{}, args *[]interface{}) (string, error) {
+	expr := spec.expr
+	if op == "pr" {
+		if spec.kind == kindString {
+			return "(" + expr + " IS NOT NULL AND " + expr + " <> '')", nil
+		}
+		return "(" + expr + " IS NOT NULL)", nil
+	}
+	if value == nil {
+		switch op {
+		case "eq":
+			return "(" + expr + " IS NULL)", nil
+		case "ne":
+			return "(" + expr + " IS NOT NULL)", nil
+		}
+		return "", fmt.Errorf("null can only be compared with eq or ne")
+	}
+
+	switch spec.kind {
+	case kindBoolean:
+		b, ok := value.(bool)
+		if !ok || (op != "eq" && op != "ne") {
+			return "", fmt.Errorf("boolean attribute supports only eq and ne with true or false")
+		}
+		*args = append(*args, b)
+		if op == "eq" {
+			return "(" + expr + " = ?)", nil
+		}
+		return "(" + expr + " IS DISTINCT FROM ?)", nil
+	case kindDateTime:
+		s, ok := value.(string)
+		if !ok {
+			return "", fmt.Errorf("dateTime value is expected")
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return "", fmt.Errorf("dateTime value is expected")
+		}
+		sqlOp, ok := orderingOps[op]
+		if !ok {
+			return "", fmt.Errorf("operator %q is not supported for dateTime", op)
+		}
+		*args = append(*args, t)
+		return "(" + expr + " " + sqlOp + " ?)", nil
+	}
+
+	s, ok := value.(string)
+	if !ok {
+		return "", fmt.Errorf("string value is expected")
+	}
+	if !spec.caseExact {
+		expr = "LOWER(" + expr + ")"
+		s = strings.ToLower(s)
+	}
+	switch op {
+	case "co", "sw", "ew":
+		pattern := escapeLike(s)
+		switch op {
+		case "co":
+			pattern = "%" + pattern + "%"
+		case "sw":
+			pattern = pattern + "%"
+		case "ew":
+			pattern = "%" + pattern
+		}
+		*args = append(*args, pattern)
+		return "(" + expr + ` LIKE ? ESCAPE '\')`, nil
+	case "ne":
+		*args = append(*args, s)
+		return "(" + expr + " IS DISTINCT FROM ?)", nil
+	}
+	*args = append(*args, s)
+	return "(" + expr + " " + orderingOps[op] + " ?)", nil
+}
+
+var orderingOps = map[string]string{
+	"eq": "=",
+	"ne": "<>",
+	"gt": ">",
+	"ge": ">=",
+	"lt": "<",
+	"le": "<=",
+}
+
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
+
+// matchElement は複数値属性の要素がフィルタに一致するか判定する。PATCH のパスのフィルタ (RFC 7644 Section 3.5.2) に使う
+func matchElement(node filterNode, element map[string]interface{}) bool {
+	switch n := node.(type) {
+	case *logicalNode:
+		if n.op == "and" {
+			return matchElement(n.left, element) && matchElement(n.right, element)
+		}
+		return matchElement(n.left, element) || matchElement(n.right, element)
+	case *notNode:
+		return !matchElement(n.inner, element)
+	case *compareNode:
+		return matchCompare(n, lookup(element, n.path))
+	}
+	return false
+}
+
+func matchCompare(n *compareNode, actual interface{}) bool {
+	if n.op == "pr" {
+		return actual != nil && actual != ""
+	}
+	// null は eq / ne でのみ比較できる (compareSQL と同じ)
+	if n.value == nil || actual == nil {
+		switch n.op {
+		case "eq":
+			return n.value == nil && actual == nil
+		case "ne":
+			return n.value != nil || actual != nil
+		}
+		return false
+	}
+	switch expected := n.value.(type) {
+	case bool:
+		b, ok := actual.(bool)
+		return ok && (n.op == "eq") == (b == expected)
+	case float64:
+		f, ok := actual.(float64)
+		if !ok {
+			return false
+		}
+		switch n.op {
+		case "eq":
+			return f == expected
+		case "ne":
+			return f != expected
+		case "gt":
+			return f > expected
+		case "ge":
+			return f >= expected
+		case "lt":
+			return f < expected
+		case "le":
+			return f <= expected
+		}
+		return false
+	case string:
+		s, ok := actual.(string)
+		if !ok {
+			return false
+		}
+		s, expected = strings.ToLower(s), strings.ToLower(expected)
+		switch n.op {
+		case "eq":
+			return s == expected
+		case "ne":
+			return s != expected
+		case "co":
+			return strings.Contains(s, expected)
+		case "sw":
+			return strings.HasPrefix(s, expected)
+		case "ew":
+			return strings.HasSuffix(s, expected)
+		case "gt":
+			return s > expected
+		case "ge":
+			return s >= expected
+		case "lt":
+			return s < expected
+		case "le":
+			return s <= expected
+		}
+	}
+	return false
+}
+
+// equalityConditions は "and" でつながった eq の条件を属性と値の組で返す。
+// 一致する要素がない場合に PATCH の add / replace で新しい要素を作るために使う
+func equalityConditions(node filterNode) (map[string]interface{}, bool) {
+	switch n := node.(type) {
+	case *logicalNode:
+		if n.op != "and" {
+			return nil, false
+		}
+		left, ok := equalityConditions(n.left)
+		if !ok {
+			return nil, false
+		}
+		right, ok := equalityConditions(n.right)
+		if !ok {
+			return nil, false
+		}
+		for k, v := range right {
+			left[k] = v
+		}
+		return left, true
+	case *compareNode:
+		if n.op != "eq" || strings.Contains(n.path, ".") {
+			return nil, false
+		}
+		return map[string]interface{}{n.path: n.value}, true
+	}
+	return nil, false
+}
+
+// lookup はオブジェクトのキーを大文字小文字を区別せずに引く (RFC 7643 Section 2.1)。"a.b" はサブ属性を引く
+func lookup(m map[string]interface{}, path string) interface{} {
+	name, sub, hasSub := strings.Cut(path, ".")
+	key := findKey(m, name)
+	if key == "" {
+		return nil
+	}
+	if !hasSub {
+		return m[key]
+	}
+	child, ok := m[key].(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	return lookup(child, sub)
+}
+
+// findKey は name と大文字小文字を区別せずに一致するキーを返す。見つからない場合は空文字列を返す
+func findKey(m map[string]interface{}, name string) string {
+	if _, ok := m[name]; ok {
+		return name
+	}
+	for key := range m {
+		if strings.EqualFold(key, name) {
+			return key
+		}
+	}
+	return ""
+}
+
+func isAttrNameChar(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '-' || r == '$'
+}
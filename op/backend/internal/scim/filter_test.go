@@ -0,0 +1,261 @@
+package scim
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// formatNode は構文木を S 式で表す
+func formatNode(node filterNode) string {
+	switch n := node.(type) {
+	case *logicalNode:
+		return "(" + n.op + " " + formatNode(n.left) + " " + formatNode(n.right) + ")"
+	case *notNode:
+		return "(not " + formatNode(n.inner) + ")"
+	case *valuePathNode:
+		return "(" + n.attr + "[] " + formatNode(n.filter) + ")"
+	case *compareNode:
+		if n.op == "pr" {
+			return "(pr " + n.path + ")"
+		}
+		return fmt.Sprintf("(%s %s %#v)", n.op, n.path, n.value)
+	}
+	return "?"
+}
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   string
+	}{
+		{filter: `userName eq "bjensen"`, want: `(eq username "bjensen")`},
+		{filter: `USERNAME EQ "bjensen"`, want: `(eq username "bjensen")`},
+		{filter: `urn:ietf:params:scim:schemas:core:2.0:User:userName sw "J"`, want: `(sw username "J")`},
+		{filter: `title pr`, want: `(pr title)`},
+		{filter: `displayName eq "a \"quoted\" \\ name"`, want: `(eq displayname "a \"quoted\" \\ name")`},
+		{filter: `displayName eq "日本語A"`, want: `(eq displayname "日本語A")`},
+		{filter: `active eq true`, want: `(eq active true)`},
+		{filter: `active ne False`, want: `(ne active false)`},
+		{filter: `externalId eq null`, want: `(eq externalid <nil>)`},
+		{filter: `grade ge 2.5`, want: `(ge grade 2.5)`},
+		{filter: `meta.lastModified gt "2011-05-13T04:42:34Z"`, want: `(gt meta.lastmodified "2011-05-13T04:42:34Z")`},
+		// and は or より優先する
+		{filter: `a eq "1" or b eq "2" and c eq "3"`, want: `(or (eq a "1") (and (eq b "2") (eq c "3")))`},
+		{filter: `(a eq "1" or b eq "2") and c eq "3"`, want: `(and (or (eq a "1") (eq b "2")) (eq c "3"))`},
+		// 同じ演算子は左結合
+		{filter: `a pr and b pr and c pr`, want: `(and (and (pr a) (pr b)) (pr c))`},
+		{filter: `not (a pr) and b pr`, want: `(and (not (pr a)) (pr b))`},
+		{filter: `emails[type eq "work" and value co "@example.com"]`, want: `(emails[] (and (eq type "work") (co value "@example.com")))`},
+		{filter: `emails[type eq "work"] or userName eq "x"`, want: `(or (emails[] (eq type "work")) (eq username "x"))`},
+		{filter: "userName\teq\t\"x\"", want: `(eq username "x")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			node, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := formatNode(node); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		``,
+		`userName`,
+		`userName eq`,
+		`userName like "x"`,
+		`userName eq "x" userName`,
+		`userName eq "unterminated`,
+		`userName eq "bad \q escape"`,
+		`userName eq bare`,
+		`"x" eq "y"`,
+		`(userName eq "x"`,
+		`userName eq "x")`,
+		`not userName eq "x"`,
+		`emails[type eq "work"`,
+		`emails[type eq "work"].value eq "x"`,
+		`userName eq "x" and`,
+		`or userName eq "x"`,
+	} {
+		if node, err := parseFilter(filter); err == nil {
+			t.Errorf("%q: parsed as %s", filter, formatNode(node))
+		}
+	}
+}
+
+func TestCompileFilter(t *testing.T) {
+	lastModified := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		filter string
+		specs  map[string]attrSpec
+		want   string
+		args   []interface{}
+	}{
+		{filter: `userName eq "Alice"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) = ?)`, args: []interface{}{"alice"}},
+		{filter: `id eq "ABC"`, specs: userAttrSpecs, want: `(users.id::text = ?)`, args: []interface{}{"ABC"}},
+		{filter: `userName ne "a"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) IS DISTINCT FROM ?)`, args: []interface{}{"a"}},
+		{filter: `userName co "50%_\\"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) LIKE ? ESCAPE '\')`, args: []interface{}{`%50\%\_\\%`}},
+		{filter: `userName sw "a"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) LIKE ? ESCAPE '\')`, args: []interface{}{"a%"}},
+		{filter: `userName ew "a"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) LIKE ? ESCAPE '\')`, args: []interface{}{"%a"}},
+		{filter: `displayName pr`, specs: userAttrSpecs, want: `(users.name IS NOT NULL AND users.name <> '')`},
+		{filter: `externalId eq null`, specs: userAttrSpecs, want: `(users.external_id IS NULL)`},
+		{filter: `active eq true`, specs: userAttrSpecs, want: `((users.status = 'active') = ?)`, args: []interface{}{true}},
+		{filter: `active ne true`, specs: userAttrSpecs, want: `((users.status = 'active') IS DISTINCT FROM ?)`, args: []interface{}{true}},
+		{filter: `meta.lastModified ge "2026-01-01T09:00:00+09:00"`, specs: userAttrSpecs, want: `(users.updated_at >= ?)`, args: []interface{}{lastModified.In(time.FixedZone("", 9*3600))}},
+		{filter: `not (userName eq "a") or active eq false`, specs: userAttrSpecs, want: `(NOT (LOWER(users.login_id) = ?) OR ((users.status = 'active') = ?))`, args: []interface{}{"a", false}},
+		{
+			filter: `emails[type eq "work" and value ew "@example.com"]`, specs: userAttrSpecs,
+			want: `((LOWER('work') = ?) AND (LOWER(users.email) LIKE ? ESCAPE '\'))`, args: []interface{}{"work", "%@example.com"},
+		},
+		{
+			filter: `groups eq "g1"`, specs: userAttrSpecs,
+			want: `EXISTS (SELECT 1 FROM group_members WHERE group_members.user_id = users.id AND (group_members.group_id::text = ?))`, args: []interface{}{"g1"},
+		},
+		{
+			filter: `members[value eq "u1"]`, specs: groupAttrSpecs,
+			want: `EXISTS (SELECT 1 FROM group_members WHERE group_members.group_id = groups.id AND (group_members.user_id::text = ?))`, args: []interface{}{"u1"},
+		},
+		// 値はプレースホルダで渡し、SQL に埋め込まない
+		{filter: `userName eq "x') OR 1=1 --"`, specs: userAttrSpecs, want: `(LOWER(users.login_id) = ?)`, args: []interface{}{"x') or 1=1 --"}},
+		{filter: "  ", specs: userAttrSpecs, want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			where, args, err := compileFilter(tt.filter, tt.specs)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if where != tt.want {
+				t.Errorf("where = %s, want %s", where, tt.want)
+			}
+			if len(args) != len(tt.args) {
+				t.Fatalf("args = %v, want %v", args, tt.args)
+			}
+			for i := range args {
+				if at, ok := args[i].(time.Time); ok {
+					if !at.Equal(tt.args[i].(time.Time)) {
+						t.Errorf("args[%d] = %v, want %v", i, at, tt.args[i])
+					}
+				} else if !reflect.DeepEqual(args[i], tt.args[i]) {
+					t.Errorf("args[%d] = %#v, want %#v", i, args[i], tt.args[i])
+				}
+			}
+		})
+	}
+}
+
+func TestCompileFilterErrors(t *testing.T) {
+	for _, filter := range []string{
+		`password eq "x"`,
+		`name.unknown eq "x"`,
+		`emails[unknown eq "x"]`,
+		`emails[value eq "a" and phoneNumbers[value eq "b"]]`,
+		`userName gt 1`,
+		`userName eq true`,
+		`userName gt null`,
+		`active eq "true"`,
+		`active gt true`,
+		`meta.created eq "yesterday"`,
+		`meta.created co "2026"`,
+		`meta.created gt 1`,
+		`displayName eq`,
+	} {
+		if where, _, err := compileFilter(filter, userAttrSpecs); err == nil {
+			t.Errorf("%q: compiled to %s", filter, where)
+		}
+	}
+	// グループのフィルタではユーザーの属性を使えない
+	if _, _, err := compileFilter(`userName eq "x"`, groupAttrSpecs); err == nil || !strings.Contains(err.Error(), "username") {
+		t.Errorf("err = %v", err)
+	}
+}
+
+func TestMatchElement(t *testing.T) {
+	element := map[string]interface{}{
+		"Type":    "Work",
+		"value":   "alice@example.com",
+		"primary": true,
+		"weight":  float64(2),
+		"display": "",
+		"nested":  map[string]interface{}{"Code": "x"},
+	}
+	tests := []struct {
+		filter string
+		want   bool
+	}{
+		{filter: `type eq "work"`, want: true},
+		{filter: `TYPE eq "WORK"`, want: true},
+		{filter: `type ne "work"`, want: false},
+		{filter: `value co "@EXAMPLE"`, want: true},
+		{filter: `value sw "alice"`, want: true},
+		{filter: `value ew ".org"`, want: false},
+		{filter: `value gt "a"`, want: true},
+		{filter: `value lt "a"`, want: false},
+		{filter: `primary eq true`, want: true},
+		{filter: `primary ne true`, want: false},
+		{filter: `primary eq "true"`, want: false},
+		{filter: `weight ge 2`, want: true},
+		{filter: `weight gt 2`, want: false},
+		{filter: `weight eq "2"`, want: false},
+		{filter: `value pr`, want: true},
+		{filter: `display pr`, want: false},
+		{filter: `missing pr`, want: false},
+		{filter: `missing eq null`, want: true},
+		{filter: `missing ne null`, want: false},
+		{filter: `value eq null`, want: false},
+		{filter: `value ne null`, want: true},
+		// null との大小比較は一致しない
+		{filter: `value gt null`, want: false},
+		{filter: `missing lt null`, want: false},
+		{filter: `missing eq "x"`, want: false},
+		{filter: `missing ne "x"`, want: true},
+		{filter: `nested.code eq "X"`, want: true},
+		{filter: `type eq "work" and not (primary eq false)`, want: true},
+		{filter: `type eq "home" or weight le 2`, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			node, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := matchElement(node, element); got != tt.want {
+				t.Errorf("got %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEqualityConditions(t *testing.T) {
+	tests := []struct {
+		filter string
+		want   map[string]interface{}
+		ok     bool
+	}{
+		{filter: `type eq "work"`, want: map[string]interface{}{"type": "work"}, ok: true},
+		{filter: `type eq "work" and primary eq true`, want: map[string]interface{}{"type": "work", "primary": true}, ok: true},
+		{filter: `type eq "work" or type eq "home"`},
+		{filter: `type ne "work"`},
+		{filter: `not (type eq "work")`},
+		{filter: `nested.code eq "x"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.filter, func(t *testing.T) {
+			node, err := parseFilter(tt.filter)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := equalityConditions(node)
+			if ok != tt.ok || (ok && !reflect.DeepEqual(got, tt.want)) {
+				t.Errorf("got %v, %v", got, ok)
+			}
+		})
+	}
+}
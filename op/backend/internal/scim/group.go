@@ -0,0 +1,309 @@
+package scim
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// groupResource は Group リソース (RFC 7643 Section 4.2)
+type groupResource struct {
+	Schemas     []string    `json:"schemas"`
+	ID          string      `json:"id,omitempty"`
+	ExternalID  *string     `json:"externalId,omitempty"`
+	DisplayName string      `json:"displayName"`
+	Members     []memberRef `json:"members,omitempty"`
+	Meta        *meta       `json:"meta,omitempty"`
+}
+
+// GroupHandler は /scim/v2/:tenant_code/Groups を処理する。メンバーにはテナントのユーザーだけを指定できる
+type GroupHandler struct {
+	groupStore GroupStore
+	userStore  UserStore
+	baseURL    string
+}
+
+func NewGroupHandler(groupStore GroupStore, userStore UserStore, baseURL string) *GroupHandler {
+	return &GroupHandler{
+		groupStore: groupStore,
+		userStore:  userStore,
+		baseURL:    baseURL,
+	}
+}
+
+// HandleList は GET /scim/v2/:tenant_code/Groups を処理する (RFC 7644 Section 3.4.2)。
+func (h *GroupHandler) HandleList(c echo.Context) error {
+	where, args, err := compileFilter(c.QueryParam("filter"), groupAttrSpecs)
+	if err != nil {
+		return badRequest(c, scimTypeInvalidFilter, err.Error())
+	}
+	startIndex, count := pagination(c)
+
+	groups, total, err := h.groupStore.Search(c.Request().Context(), tenantFrom(c).ID, where, args, startIndex-1, count)
+	if err != nil {
+		c.Logger().Errorf("failed to search groups: %v", err)
+		return serverError(c)
+	}
+
+	resources := make([]interface{}, 0, len(groups))
+	for i := range groups {
+		resource, _, err := h.toResource(c, &groups[i])
+		if err != nil {
+			return serverError(c)
+		}
+		project(c, resource)
+		resources = append(resources, resource)
+	}
+	return writeJSON(c, http.StatusOK, listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// HandleGet は GET /scim/v2/:tenant_code/Groups/:id を処理する。
+func (h *GroupHandler) HandleGet(c echo.Context) error {
+	group, err := h.findGroup(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if notModified(c, versionOf(group.UpdatedAt)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return h.writeGroup(c, http.StatusOK, group)
+}
+
+// HandleCreate は POST /scim/v2/:tenant_code/Groups を処理する (RFC 7644 Section 3.3)。
+func (h *GroupHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+
+	var req groupResource
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+
+	group := &model.Group{TenantID: tenantFrom(c).ID}
+	if err := h.applyGroupResource(ctx, group, &req); err != nil {
+		return writeRequestError(c, err)
+	}
+	if err := h.checkUniqueness(ctx, group); err != nil {
+		return writeRequestError(c, err)
+	}
+	if err := h.groupStore.Create(ctx, group); err != nil {
+		c.Logger().Errorf("failed to create group: %v", err)
+		return serverError(c)
+	}
+	return h.reloadAndWrite(c, http.StatusCreated, group.ID)
+}
+
+// HandleReplace は PUT /scim/v2/:tenant_code/Groups/:id を処理する (RFC 7644 Section 3.5.1)。
+func (h *GroupHandler) HandleReplace(c echo.Context) error {
+	group, err := h.findGroup(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(group.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+
+	var req groupResource
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+	return h.update(c, group, &req)
+}
+
+// HandlePatch は PATCH /scim/v2/:tenant_code/Groups/:id を処理する (RFC 7644 Section 3.5.2)。
+// 現在のリソースに操作を適用した結果を PUT と同じ規則で保存する
+func (h *GroupHandler) HandlePatch(c echo.Context) error {
+	group, err := h.findGroup(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(group.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+
+	var req patchRequest
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+	current, err := toMap(h.groupToResource(c, group))
+	if err != nil {
+		return serverError(c)
+	}
+	if err := applyPatch(current, &req); err != nil {
+		return writeRequestError(c, err)
+	}
+	var patched groupResource
+	if err := fromMap(current, &patched); err != nil {
+		return badRequest(c, scimTypeInvalidValue, err.Error())
+	}
+	return h.update(c, group, &patched)
+}
+
+// HandleDelete は DELETE /scim/v2/:tenant_code/Groups/:id を処理する (RFC 7644 Section 3.6)。
+func (h *GroupHandler) HandleDelete(c echo.Context) error {
+	group, err := h.findGroup(c)
+	if err != nil || group == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(group.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+	if err := h.groupStore.Delete(c.Request().Context(), group.ID); err != nil {
+		c.Logger().Errorf("failed to delete group: %v", err)
+		return serverError(c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *GroupHandler) update(c echo.Context, group *model.Group, req *groupResource) error {
+	ctx := c.Request().Context()
+	if err := h.applyGroupResource(ctx, group, req); err != nil {
+		return writeRequestError(c, err)
+	}
+	if err := h.checkUniqueness(ctx, group); err != nil {
+		return writeRequestError(c, err)
+	}
+	if err := h.groupStore.Update(ctx, group); err != nil {
+		c.Logger().Errorf("failed to update group: %v", err)
+		return serverError(c)
+	}
+	return h.reloadAndWrite(c, http.StatusOK, group.ID)
+}
+
+// applyGroupResource は Group リソースの値をグループに設定する。メンバーはテナントの削除されていないユーザーに限る
+func (h *GroupHandler) applyGroupResource(ctx context.Context, group *model.Group, r *groupResource) error {
+	displayName := strings.TrimSpace(r.DisplayName)
+	if displayName == "" || len(displayName) > 255 {
+		return newRequestError(scimTypeInvalidValue, "displayName is required")
+	}
+	group.DisplayName = displayName
+
+	group.ExternalID = nil
+	if r.ExternalID != nil && *r.ExternalID != "" {
+		if len(*r.ExternalID) > 255 {
+			return newRequestError(scimTypeInvalidValue, "invalid externalId")
+		}
+		externalID := *r.ExternalID
+		group.ExternalID = &externalID
+	}
+
+	seen := map[uuid.UUID]bool{}
+	var ids []uuid.UUID
+	for _, m := range r.Members {
+		id, err := uuid.Parse(m.Value)
+		if err != nil {
+			return newRequestError(scimTypeInvalidValue, "member %q is not a user", m.Value)
+		}
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	found, err := h.userStore.FindActiveIDs(ctx, group.TenantID, ids)
+	if err != nil {
+		return err
+	}
+	if len(found) != len(ids) {
+		return newRequestError(scimTypeInvalidValue, "members contain unknown users")
+	}
+
+	group.Members = make([]model.GroupMember, len(ids))
+	for i, id := range ids {
+		group.Members[i] = model.GroupMember{GroupID: group.ID, UserID: id}
+	}
+	return nil
+}
+
+// checkUniqueness は displayName がテナント内で他のグループと重複しないか確認する。
+func (h *GroupHandler) checkUniqueness(ctx context.Context, group *model.Group) error {
+	existing, err := h.groupStore.FindByDisplayName(ctx, group.TenantID, group.DisplayName)
+	if err != nil {
+		return err
+	}
+	if existing != nil && existing.ID != group.ID {
+		return newConflictError("displayName is already in use")
+	}
+	return nil
+}
+
+// findGroup はパスの ID のグループを検索する。他のテナントのグループは 404 を返す
+func (h *GroupHandler) findGroup(c echo.Context) (*model.Group, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, notFound(c, "group not found")
+	}
+	group, err := h.groupStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find group: %v", err)
+		return nil, serverError(c)
+	}
+	if group == nil || group.TenantID != tenantFrom(c).ID {
+		return nil, notFound(c, "group not found")
+	}
+	return group, nil
+}
+
+// reloadAndWrite はメンバーのユーザーを読み込み直してグループを返す。
+func (h *GroupHandler) reloadAndWrite(c echo.Context, status int, id uuid.UUID) error {
+	group, err := h.groupStore.FindByID(c.Request().Context(), id)
+	if err != nil || group == nil {
+		c.Logger().Errorf("failed to find group: %v", err)
+		return serverError(c)
+	}
+	return h.writeGroup(c, status, group)
+}
+
+func (h *GroupHandler) writeGroup(c echo.Context, status int, group *model.Group) error {
+	resource, m, err := h.toResource(c, group)
+	if err != nil {
+		return serverError(c)
+	}
+	return writeResource(c, status, resource, m)
+}
+
+func (h *GroupHandler) toResource(c echo.Context, group *model.Group) (map[string]interface{}, meta, error) {
+	r := h.groupToResource(c, group)
+	m := meta{
+		ResourceType: "Group",
+		Created:      formatTime(group.CreatedAt),
+		LastModified: formatTime(group.UpdatedAt),
+		Location:     resourceLocation(h.baseURL, c.Param("tenant_code"), "Groups", group.ID),
+		Version:      versionOf(group.UpdatedAt),
+	}
+	r.Meta = &m
+	resource, err := toMap(r)
+	return resource, m, err
+}
+
+// groupToResource はグループを Group リソースに変換する。meta は呼び出し元で設定する
+func (h *GroupHandler) groupToResource(c echo.Context, group *model.Group) *groupResource {
+	r := &groupResource{
+		Schemas:     []string{schemaGroup},
+		ID:          group.ID.String(),
+		ExternalID:  group.ExternalID,
+		DisplayName: group.DisplayName,
+	}
+	for _, m := range group.Members {
+		display := m.User.LoginID
+		if m.User.Name != nil {
+			display = *m.User.Name
+		}
+		r.Members = append(r.Members, memberRef{
+			Value:   m.UserID.String(),
+			Display: display,
+			Ref:     resourceLocation(h.baseURL, c.Param("tenant_code"), "Users", m.UserID),
+			Type:    "User",
+		})
+	}
+	return r
+}
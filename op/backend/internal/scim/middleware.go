@@ -0,0 +1,58 @@
+package scim
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// tenantContextKey は認証したテナントを echo.Context に保存するキー
+const tenantContextKey = "scim_tenant"
+
+// NewAuthMiddleware は SCIM トークンを Authorization ヘッダーの Bearer で検証する Echo ミドルウェアを返す (RFC 7644 Section 2)。
+// トークンはパスのテナントで発行されたものに限る
+func NewAuthMiddleware(tenantFinder TenantFinder, tokenStore TokenStore, hashToken HashTokenFunc) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			ctx := c.Request().Context()
+
+			tenant, err := tenantFinder.FindByCode(ctx, c.Param("tenant_code"))
+			if err != nil {
+				c.Logger().Errorf("failed to find tenant: %v", err)
+				return serverError(c)
+			}
+			if tenant == nil {
+				return notFound(c, "tenant not found")
+			}
+
+			authHeader := c.Request().Header.Get("Authorization")
+			if !strings.HasPrefix(authHeader, "Bearer ") {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer`)
+				return scimError(c, http.StatusUnauthorized, "", "authorization is required")
+			}
+			token, err := tokenStore.FindValidByHash(ctx, tenant.ID, hashToken(strings.TrimPrefix(authHeader, "Bearer ")))
+			if err != nil {
+				c.Logger().Errorf("failed to find scim token: %v", err)
+				return serverError(c)
+			}
+			if token == nil {
+				c.Response().Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+				return scimError(c, http.StatusUnauthorized, "", "invalid token")
+			}
+			if err := tokenStore.UpdateLastUsedAt(ctx, token.ID, time.Now()); err != nil {
+				c.Logger().Errorf("failed to update scim token last used at: %v", err)
+			}
+
+			c.Set(tenantContextKey, tenant)
+			return next(c)
+		}
+	}
+}
+
+func tenantFrom(c echo.Context) *model.Tenant {
+	return c.Get(tenantContextKey).(*model.Tenant)
+}
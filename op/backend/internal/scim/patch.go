@@ -0,0 +1,361 @@
+package scim
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patchRequest は PATCH のリクエスト (RFC 7644 Section 3.5.2)
+type patchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []patchOperation `json:"Operations"`
+}
+
+type patchOperation struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+}
+
+// patchPath は PATCH の path を分解したもの ("emails[type eq \"work\"].value" → emails / フィルタ / value)
+type patchPath struct {
+	attr   string
+	filter filterNode
+	sub    string
+}
+
+func parsePatchPath(path string) (*patchPath, error) {
+	path = stripSchemaPrefix(strings.TrimSpace(path))
+	p := &patchPath{}
+	hasSub := false
+	if i := strings.Index(path, "["); i >= 0 {
+		end := strings.LastIndex(path, "]")
+		if end < i {
+			return nil, fmt.Errorf("invalid path %q", path)
+		}
+		filter, err := parseFilter(path[i+1 : end])
+		if err != nil {
+			return nil, err
+		}
+		p.attr, p.filter = path[:i], filter
+		rest := path[end+1:]
+		if rest != "" {
+			if !strings.HasPrefix(rest, ".") {
+				return nil, fmt.Errorf("invalid path %q", path)
+			}
+			p.sub, hasSub = rest[1:], true
+		}
+	} else {
+		p.attr, p.sub, hasSub = strings.Cut(path, ".")
+	}
+	// "name." のように "." の後が空のパスも不正
+	if !validAttrName(p.attr) || (hasSub && !validAttrName(p.sub)) {
+		return nil, fmt.Errorf("invalid path %q", path)
+	}
+	return p, nil
+}
+
+func validAttrName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for _, r := range name {
+		if !isAttrNameChar(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// isExtensionPath はコアスキーマ以外 (拡張スキーマ) の URI で始まる属性か判定する。拡張スキーマには対応しないため無視する
+func isExtensionPath(path string) bool {
+	return strings.HasPrefix(strings.ToLower(stripSchemaPrefix(path)), "urn:")
+}
+
+// applyPatch は JSON のオブジェクトとして表したリソースに PATCH の操作を順に適用する。
+// readOnly な属性などの検証は呼び出し元で PUT と同じ規則で行う
+func applyPatch(resource map[string]interface{}, req *patchRequest) error {
+	if !containsFold(req.Schemas, schemaPatchOp) {
+		return newRequestError(scimTypeInvalidSyntax, "schemas must contain %s", schemaPatchOp)
+	}
+	if len(req.Operations) == 0 {
+		return newRequestError(scimTypeInvalidSyntax, "Operations is required")
+	}
+	for _, op := range req.Operations {
+		if err := applyOperation(resource, op); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func applyOperation(resource map[string]interface{}, op patchOperation) error {
+	kind := strings.ToLower(op.Op)
+	switch kind {
+	case "add", "replace", "remove":
+	default:
+		return newRequestError(scimTypeInvalidSyntax, "unknown op %q", op.Op)
+	}
+
+	if op.Path == "" {
+		if kind == "remove" {
+			return newRequestError(scimTypeNoTarget, "path is required for remove")
+		}
+		values, ok := op.Value.(map[string]interface{})
+		if !ok {
+			return newRequestError(scimTypeInvalidValue, "value must be an object when path is omitted")
+		}
+		for name, value := range values {
+			if isExtensionPath(name) {
+				continue
+			}
+			name = stripSchemaPrefix(name)
+			if strings.Contains(name, ".") {
+				// Azure AD などが送る "name.givenName" のようなキーはパスとして扱う
+				if err := applyOperation(resource, patchOperation{Op: kind, Path: name, Value: value}); err != nil {
+					return err
+				}
+				continue
+			}
+			setAttribute(resource, kind, name, value)
+		}
+		return nil
+	}
+
+	if isExtensionPath(op.Path) {
+		return nil
+	}
+	path, err := parsePatchPath(op.Path)
+	if err != nil {
+		return newRequestError(scimTypeInvalidPath, "%v", err)
+	}
+
+	if path.filter != nil {
+		return applyFiltered(resource, kind, path, op.Value)
+	}
+
+	if path.sub == "" {
+		if kind == "remove" {
+			return removeAttribute(resource, path.attr, op.Value)
+		}
+		setAttribute(resource, kind, path.attr, op.Value)
+		return nil
+	}
+
+	key := keyFor(resource, path.attr)
+	switch target := resource[key].(type) {
+	case map[string]interface{}:
+		return applyToElement(target, kind, path.sub, op.Value)
+	case []interface{}:
+		// 複数値属性のサブ属性はすべての要素に適用する
+		for _, e := range target {
+			if element, ok := e.(map[string]interface{}); ok {
+				if err := applyToElement(element, kind, path.sub, op.Value); err != nil {
+					return err
+				}
+			}
+		}
+		if len(target) == 0 && kind != "remove" {
+			resource[key] = []interface{}{map[string]interface{}{path.sub: op.Value}}
+		}
+		return nil
+	case nil:
+		if kind != "remove" {
+			resource[key] = map[string]interface{}{path.sub: op.Value}
+		}
+		return nil
+	}
+	return newRequestError(scimTypeInvalidPath, "%s has no sub-attributes", path.attr)
+}
+
+// applyFiltered はフィルタに一致する複数値属性の要素に操作を適用する。
+// add / replace で一致する要素がない場合は、フィルタの eq の条件から要素を作って追加する
+func applyFiltered(resource map[string]interface{}, kind string, path *patchPath, value interface{}) error {
+	key := keyFor(resource, path.attr)
+	var elements []interface{}
+	switch v := resource[key].(type) {
+	case []interface{}:
+		elements = v
+	case nil:
+	default:
+		return newRequestError(scimTypeInvalidPath, "%s is not multi-valued", path.attr)
+	}
+
+	var kept []interface{}
+	matched := false
+	for _, e := range elements {
+		element, ok := e.(map[string]interface{})
+		if !ok || !matchElement(path.filter, element) {
+			kept = append(kept, e)
+			continue
+		}
+		matched = true
+		switch {
+		case kind == "remove" && path.sub == "":
+			continue
+		case path.sub != "":
+			if err := applyToElement(element, kind, path.sub, value); err != nil {
+				return err
+			}
+		case kind == "replace":
+			replacement, ok := value.(map[string]interface{})
+			if !ok {
+				return newRequestError(scimTypeInvalidValue, "value must be an object")
+			}
+			element = replacement
+		default:
+			values, ok := value.(map[string]interface{})
+			if !ok {
+				return newRequestError(scimTypeInvalidValue, "value must be an object")
+			}
+			for k, v := range values {
+				element[keyFor(element, k)] = v
+			}
+		}
+		kept = append(kept, element)
+	}
+
+	if !matched && kind != "remove" {
+		element, ok := equalityConditions(path.filter)
+		if !ok {
+			return newRequestError(scimTypeNoTarget, "no value matches the filter of %s", path.attr)
+		}
+		if path.sub != "" {
+			element[path.sub] = value
+		} else if values, ok := value.(map[string]interface{}); ok {
+			for k, v := range values {
+				element[keyFor(element, k)] = v
+			}
+		} else {
+			return newRequestError(scimTypeInvalidValue, "value must be an object")
+		}
+		kept = append(kept, element)
+	}
+
+	if kept == nil {
+		delete(resource, key)
+		return nil
+	}
+	resource[key] = kept
+	return nil
+}
+
+// applyToElement は複合属性 (または複数値属性の要素) のサブ属性に操作を適用する。
+func applyToElement(element map[string]interface{}, kind, sub string, value interface{}) error {
+	key := keyFor(element, sub)
+	if kind == "remove" {
+		delete(element, key)
+		return nil
+	}
+	element[key] = value
+	return nil
+}
+
+// setAttribute は属性に add / replace を適用する (RFC 7644 Section 3.5.2.1, 3.5.2.3)。
+// add は複数値属性に値を追加し、複合属性にはサブ属性をマージする。replace は複数値属性を置き換え、複合属性にはサブ属性をマージする
+func setAttribute(resource map[string]interface{}, kind, name string, value interface{}) {
+	key := keyFor(resource, name)
+	current := resource[key]
+
+	if values, ok := value.(map[string]interface{}); ok {
+		if existing, ok := current.(map[string]interface{}); ok {
+			for k, v := range values {
+				existing[keyFor(existing, k)] = v
+			}
+			return
+		}
+	}
+	if kind == "add" {
+		if existing, ok := current.([]interface{}); ok {
+			switch v := value.(type) {
+			case []interface{}:
+				resource[key] = appendUnique(existing, v...)
+			default:
+				resource[key] = appendUnique(existing, v)
+			}
+			return
+		}
+	}
+	resource[key] = value
+}
+
+// appendUnique は同じ value の要素を重複させずに追加する。
+func appendUnique(elements []interface{}, added ...interface{}) []interface{} {
+	for _, a := range added {
+		duplicated := false
+		if am, ok := a.(map[string]interface{}); ok {
+			for _, e := range elements {
+				if em, ok := e.(map[string]interface{}); ok && sameValue(em, am) {
+					duplicated = true
+					break
+				}
+			}
+		}
+		if !duplicated {
+			elements = append(elements, a)
+		}
+	}
+	return elements
+}
+
+// sameValue は複数値属性の2つの要素の value が同じか判定する。
+func sameValue(a, b map[string]interface{}) bool {
+	av, aok := lookup(a, "value").(string)
+	bv, bok := lookup(b, "value").(string)
+	return aok && bok && strings.EqualFold(av, bv)
+}
+
+// removeAttribute は属性を削除する (RFC 7644 Section 3.5.2.2)。
+// 複数値属性に value で要素の配列が指定された場合は、value が一致する要素だけを削除する (Azure AD のメンバー削除の形式)
+func removeAttribute(resource map[string]interface{}, name string, value interface{}) error {
+	key := keyFor(resource, name)
+	elements, isMulti := resource[key].([]interface{})
+	removed, hasValues := value.([]interface{})
+	if !isMulti || !hasValues {
+		delete(resource, key)
+		return nil
+	}
+
+	targets := map[string]bool{}
+	for _, r := range removed {
+		rm, ok := r.(map[string]interface{})
+		if !ok {
+			return newRequestError(scimTypeInvalidValue, "value must be an array of objects")
+		}
+		if v, ok := lookup(rm, "value").(string); ok {
+			targets[strings.ToLower(v)] = true
+		}
+	}
+	var kept []interface{}
+	for _, e := range elements {
+		if em, ok := e.(map[string]interface{}); ok {
+			if v, ok := lookup(em, "value").(string); ok && targets[strings.ToLower(v)] {
+				continue
+			}
+		}
+		kept = append(kept, e)
+	}
+	if kept == nil {
+		delete(resource, key)
+		return nil
+	}
+	resource[key] = kept
+	return nil
+}
+
+// keyFor は既存のキーと大文字小文字を区別せずに一致すればそのキーを、なければ name を返す。
+func keyFor(m map[string]interface{}, name string) string {
+	if key := findKey(m, name); key != "" {
+		return key
+	}
+	return name
+}
+
+func containsFold(values []string, target string) bool {
+	for _, v := range values {
+		if strings.EqualFold(v, target) {
+			return true
+		}
+	}
+	return false
+}
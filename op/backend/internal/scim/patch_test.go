@@ -0,0 +1,195 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+)
+
+func TestParsePatchPath(t *testing.T) {
+	tests := []struct {
+		path   string
+		attr   string
+		filter string
+		sub    string
+	}{
+		{path: "displayName", attr: "displayName"},
+		{path: " name.givenName ", attr: "name", sub: "givenName"},
+		{path: "urn:ietf:params:scim:schemas:core:2.0:User:name.familyName", attr: "name", sub: "familyName"},
+		{path: `emails[type eq "work"]`, attr: "emails", filter: `(eq type "work")`},
+		{path: `emails[type eq "work"].value`, attr: "emails", filter: `(eq type "work")`, sub: "value"},
+		{path: `members[value eq "a]b"]`, attr: "members", filter: `(eq value "a]b")`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			p, err := parsePatchPath(tt.path)
+			if err != nil {
+				t.Fatal(err)
+			}
+			filter := ""
+			if p.filter != nil {
+				filter = formatNode(p.filter)
+			}
+			if p.attr != tt.attr || filter != tt.filter || p.sub != tt.sub {
+				t.Errorf("got %s / %s / %s", p.attr, filter, p.sub)
+			}
+		})
+	}
+
+	for _, path := range []string{
+		"",
+		"name.",
+		"a.b.c",
+		"emails]",
+		`emails[type eq "work"`,
+		`emails[type eq]`,
+		`emails[type eq "work"]value`,
+		`emails[type eq "work"].`,
+		"user name",
+		`[type eq "work"]`,
+	} {
+		if p, err := parsePatchPath(path); err == nil {
+			t.Errorf("%q: parsed as %+v", path, p)
+		}
+	}
+}
+
+func TestApplyPatch(t *testing.T) {
+	tests := []struct {
+		name       string
+		resource   string
+		operations string
+		want       string
+	}{
+		{
+			name:       "属性の置き換えはキーの大文字小文字を区別しない",
+			resource:   `{"displayName":"Old"}`,
+			operations: `[{"op":"Replace","path":"DISPLAYNAME","value":"New"}]`,
+			want:       `{"displayName":"New"}`,
+		},
+		{
+			name:       "複合属性のサブ属性",
+			resource:   `{"name":{"givenName":"A","familyName":"B"}}`,
+			operations: `[{"op":"replace","path":"name.familyName","value":"C"},{"op":"remove","path":"name.givenName"}]`,
+			want:       `{"name":{"familyName":"C"}}`,
+		},
+		{
+			name:       "path なしの add は複合属性をマージし、ドット区切りのキーをパスとして扱う",
+			resource:   `{"name":{"givenName":"A"},"active":true}`,
+			operations: `[{"op":"add","value":{"name":{"familyName":"B"},"name.middleName":"M","active":false,"urn:example:ext:1.0:User":{"x":1}}}]`,
+			want:       `{"name":{"givenName":"A","familyName":"B","middleName":"M"},"active":false}`,
+		},
+		{
+			name:       "複数値属性への add は重複を追加しない",
+			resource:   `{"members":[{"value":"u1"}]}`,
+			operations: `[{"op":"add","path":"members","value":[{"value":"U1"},{"value":"u2"}]}]`,
+			want:       `{"members":[{"value":"u1"},{"value":"u2"}]}`,
+		},
+		{
+			name:       "複数値属性の replace は置き換える",
+			resource:   `{"members":[{"value":"u1"}]}`,
+			operations: `[{"op":"replace","path":"members","value":[{"value":"u2"}]}]`,
+			want:       `{"members":[{"value":"u2"}]}`,
+		},
+		{
+			name:       "フィルタに一致する要素のサブ属性",
+			resource:   `{"emails":[{"type":"work","value":"a@example.com"},{"type":"home","value":"b@example.com"}]}`,
+			operations: `[{"op":"replace","path":"emails[type eq \"work\"].value","value":"c@example.com"}]`,
+			want:       `{"emails":[{"type":"work","value":"c@example.com"},{"type":"home","value":"b@example.com"}]}`,
+		},
+		{
+			name:       "一致する要素がなければ eq の条件から作る",
+			resource:   `{"emails":[{"type":"home","value":"b@example.com"}]}`,
+			operations: `[{"op":"add","path":"emails[type eq \"work\"].value","value":"a@example.com"}]`,
+			want:       `{"emails":[{"type":"home","value":"b@example.com"},{"type":"work","value":"a@example.com"}]}`,
+		},
+		{
+			name:       "フィルタに一致する要素の削除",
+			resource:   `{"members":[{"value":"u1"},{"value":"u2"}]}`,
+			operations: `[{"op":"remove","path":"members[value eq \"U1\"]"}]`,
+			want:       `{"members":[{"value":"u2"}]}`,
+		},
+		{
+			name:       "最後の要素を削除すると属性ごと消す",
+			resource:   `{"members":[{"value":"u1"}]}`,
+			operations: `[{"op":"remove","path":"members[value eq \"u1\"]"}]`,
+			want:       `{}`,
+		},
+		{
+			name:       "value の配列で指定した要素だけを削除する",
+			resource:   `{"members":[{"value":"u1"},{"value":"u2"},{"value":"u3"}]}`,
+			operations: `[{"op":"remove","path":"members","value":[{"value":"u1"},{"value":"U3"}]}]`,
+			want:       `{"members":[{"value":"u2"}]}`,
+		},
+		{
+			name:       "拡張スキーマの属性は無視する",
+			resource:   `{"displayName":"A"}`,
+			operations: `[{"op":"replace","path":"urn:ietf:params:scim:schemas:extension:enterprise:2.0:User:department","value":"x"}]`,
+			want:       `{"displayName":"A"}`,
+		},
+		{
+			name:       "一致しない要素の削除は何もしない",
+			resource:   `{"members":[{"value":"u1"}]}`,
+			operations: `[{"op":"remove","path":"members[value eq \"u9\"]"}]`,
+			want:       `{"members":[{"value":"u1"}]}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var resource, want map[string]interface{}
+			_ = json.Unmarshal([]byte(tt.resource), &resource)
+			_ = json.Unmarshal([]byte(tt.want), &want)
+			req := &patchRequest{Schemas: []string{schemaPatchOp}}
+			if err := json.Unmarshal([]byte(tt.operations), &req.Operations); err != nil {
+				t.Fatal(err)
+			}
+			if err := applyPatch(resource, req); err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(resource, want) {
+				got, _ := json.Marshal(resource)
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestApplyPatchErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		schemas    []string
+		resource   string
+		operations string
+		scimType   string
+	}{
+		{name: "schemas に PatchOp がない", schemas: []string{schemaUser}, operations: `[{"op":"add","path":"x","value":1}]`, scimType: scimTypeInvalidSyntax},
+		{name: "Operations が空", operations: `[]`, scimType: scimTypeInvalidSyntax},
+		{name: "未知の op", operations: `[{"op":"move","path":"x"}]`, scimType: scimTypeInvalidSyntax},
+		{name: "path なしの remove", operations: `[{"op":"remove"}]`, scimType: scimTypeNoTarget},
+		{name: "path なしで value がオブジェクトでない", operations: `[{"op":"add","value":"x"}]`, scimType: scimTypeInvalidValue},
+		{name: "不正な path", operations: `[{"op":"add","path":"emails[type eq","value":"x"}]`, scimType: scimTypeInvalidPath},
+		{name: "単一値の属性にフィルタ", resource: `{"displayName":"A"}`, operations: `[{"op":"replace","path":"displayName[value eq \"A\"]","value":{}}]`, scimType: scimTypeInvalidPath},
+		{name: "サブ属性のない属性", resource: `{"displayName":"A"}`, operations: `[{"op":"replace","path":"displayName.x","value":"B"}]`, scimType: scimTypeInvalidPath},
+		{name: "eq 以外の条件で一致する要素がない", resource: `{"emails":[]}`, operations: `[{"op":"add","path":"emails[type ne \"work\"].value","value":"x"}]`, scimType: scimTypeNoTarget},
+		{name: "一致した要素の replace にオブジェクトでない値", resource: `{"emails":[{"type":"work"}]}`, operations: `[{"op":"replace","path":"emails[type eq \"work\"]","value":"x"}]`, scimType: scimTypeInvalidValue},
+		{name: "削除する要素の指定がオブジェクトでない", resource: `{"members":[{"value":"u1"}]}`, operations: `[{"op":"remove","path":"members","value":["u1"]}]`, scimType: scimTypeInvalidValue},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resource := map[string]interface{}{}
+			if tt.resource != "" {
+				_ = json.Unmarshal([]byte(tt.resource), &resource)
+			}
+			req := &patchRequest{Schemas: tt.schemas}
+			if req.Schemas == nil {
+				req.Schemas = []string{schemaPatchOp}
+			}
+			_ = json.Unmarshal([]byte(tt.operations), &req.Operations)
+			var reqErr *requestError
+			if err := applyPatch(resource, req); !errors.As(err, &reqErr) || reqErr.scimType != tt.scimType {
+				t.Errorf("err = %v, want %s", err, tt.scimType)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package scim
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// スキーマの URI (RFC 7643 Section 8.7, RFC 7644 Section 3)
+const (
+	schemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	schemaGroup                 = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	schemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+	schemaResourceType          = "urn:ietf:params:scim:schemas:core:2.0:ResourceType"
+	schemaSchema                = "urn:ietf:params:scim:schemas:core:2.0:Schema"
+	schemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	schemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	schemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+)
+
+// contentType は SCIM のメディアタイプ (RFC 7644 Section 3.1)
+const contentType = "application/scim+json"
+
+// scimType はエラーの詳細な種別 (RFC 7644 Section 3.12)
+const (
+	scimTypeInvalidFilter = "invalidFilter"
+	scimTypeInvalidSyntax = "invalidSyntax"
+	scimTypeInvalidPath   = "invalidPath"
+	scimTypeNoTarget      = "noTarget"
+	scimTypeInvalidValue  = "invalidValue"
+	scimTypeUniqueness    = "uniqueness"
+	scimTypeMutability    = "mutability"
+	scimTypeTooMany       = "tooMany"
+)
+
+// ページネーション (RFC 7644 Section 3.4.2.4)
+const (
+	defaultCount = 100
+	maxCount     = 200
+)
+
+// maxBodyBytes は受け付けるリクエストボディの上限
+const maxBodyBytes = 1 << 20
+
+type errorResponse struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// scimError は SCIM のエラーレスポンスを返す (RFC 7644 Section 3.12)
+func scimError(c echo.Context, status int, scimType, detail string) error {
+	return writeJSON(c, status, errorResponse{
+		Schemas:  []string{schemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	})
+}
+
+func badRequest(c echo.Context, scimType, detail string) error {
+	return scimError(c, http.StatusBadRequest, scimType, detail)
+}
+
+func notFound(c echo.Context, detail string) error {
+	return scimError(c, http.StatusNotFound, "", detail)
+}
+
+func serverError(c echo.Context) error {
+	return scimError(c, http.StatusInternalServerError, "", "internal server error")
+}
+
+func writeJSON(c echo.Context, status int, v interface{}) error {
+	body, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.Blob(status, contentType, body)
+}
+
+// requestError はリクエストを処理できない理由と scimType
+type requestError struct {
+	status   int
+	scimType string
+	detail   string
+}
+
+func (e *requestError) Error() string { return e.detail }
+
+func newRequestError(scimType, format string, args ...interface{}) *requestError {
+	return &requestError{status: http.StatusBadRequest, scimType: scimType, detail: fmt.Sprintf(format, args...)}
+}
+
+// newConflictError は一意であるべき属性が重複している場合のエラー (RFC 7644 Section 3.3)
+func newConflictError(format string, args ...interface{}) *requestError {
+	return &requestError{status: http.StatusConflict, scimType: scimTypeUniqueness, detail: fmt.Sprintf(format, args...)}
+}
+
+// writeRequestError は err が requestError の場合はその内容を、それ以外は 500 を返す。
+func writeRequestError(c echo.Context, err error) error {
+	var reqErr *requestError
+	if errors.As(err, &reqErr) {
+		return scimError(c, reqErr.status, reqErr.scimType, reqErr.detail)
+	}
+	c.Logger().Errorf("scim request failed: %v", err)
+	return serverError(c)
+}
+
+type listResponse struct {
+	Schemas      []string      `json:"schemas"`
+	TotalResults int64         `json:"totalResults"`
+	StartIndex   int           `json:"startIndex"`
+	ItemsPerPage int           `json:"itemsPerPage"`
+	Resources    []interface{} `json:"Resources"`
+}
+
+type meta struct {
+	ResourceType string `json:"resourceType"`
+	Created      string `json:"created,omitempty"`
+	LastModified string `json:"lastModified,omitempty"`
+	Location     string `json:"location,omitempty"`
+	Version      string `json:"version,omitempty"`
+}
+
+// versionOf は ETag に使うリソースのバージョン (RFC 7644 Section 3.14)。
+// 更新日時から作る弱い ETag。DB に保存される精度に合わせてマイクロ秒までを使う
+func versionOf(updatedAt time.Time) string {
+	return fmt.Sprintf(`W/"%x"`, updatedAt.UnixMicro())
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// checkIfMatch は If-Match ヘッダーが現在のバージョンと一致するか判定する。ヘッダーがない場合は一致とみなす
+func checkIfMatch(c echo.Context, version string) bool {
+	header := c.Request().Header.Get("If-Match")
+	if header == "" {
+		return true
+	}
+	return etagListContains(header, version)
+}
+
+// notModified は If-None-Match ヘッダーが現在のバージョンと一致するか判定する。
+func notModified(c echo.Context, version string) bool {
+	header := c.Request().Header.Get("If-None-Match")
+	return header != "" && etagListContains(header, version)
+}
+
+func etagListContains(header, version string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		// 弱い比較を行う (RFC 7232 Section 2.3.2)
+		if tag == "*" || strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(version, "W/") {
+			return true
+		}
+	}
+	return false
+}
+
+func preconditionFailed(c echo.Context) error {
+	return scimError(c, http.StatusPreconditionFailed, "", "resource version does not match If-Match")
+}
+
+// writeResource はリソースを ETag と Location 付きで返す。
+func writeResource(c echo.Context, status int, resource map[string]interface{}, m meta) error {
+	c.Response().Header().Set("ETag", m.Version)
+	if status == http.StatusCreated {
+		c.Response().Header().Set("Location", m.Location)
+	}
+	project(c, resource)
+	return writeJSON(c, status, resource)
+}
+
+// decodeBody はリクエストボディの JSON を v に読み込む。Content-Type は application/scim+json と application/json を受け付ける
+func decodeBody(c echo.Context, v interface{}) error {
+	body, err := io.ReadAll(io.LimitReader(c.Request().Body, maxBodyBytes+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxBodyBytes {
+		return fmt.Errorf("request body is too large")
+	}
+	return json.Unmarshal(body, v)
+}
+
+// toMap は構造体を JSON のオブジェクトとして扱うために変換する。
+func toMap(v interface{}) (map[string]interface{}, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// fromMap は JSON のオブジェクトを構造体に変換する。
+func fromMap(m map[string]interface{}, v interface{}) error {
+	raw, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(raw, v)
+}
+
+// pagination は startIndex (1 始まり) と count を返す (RFC 7644 Section 3.4.2.4)。不正な値は既定値に丸める
+func pagination(c echo.Context) (startIndex, count int) {
+	startIndex, err := strconv.Atoi(c.QueryParam("startIndex"))
+	if err != nil || startIndex < 1 {
+		startIndex = 1
+	}
+	count, err = strconv.Atoi(c.QueryParam("count"))
+	if err != nil {
+		count = defaultCount
+	}
+	if count < 0 {
+		count = 0
+	}
+	if count > maxCount {
+		count = maxCount
+	}
+	return startIndex, count
+}
+
+// alwaysReturned は attributes / excludedAttributes の指定にかかわらず返す属性 (RFC 7643 Section 7 returned "always")
+var alwaysReturned = map[string]bool{"schemas": true, "id": true, "meta": true}
+
+// project は attributes / excludedAttributes クエリパラメータに従ってトップレベルの属性を絞り込む (RFC 7644 Section 3.4.2.5)。
+// "name.givenName" のようなサブ属性の指定は親の属性単位で扱う
+func project(c echo.Context, resource map[string]interface{}) {
+	attributes := attributeNames(c.QueryParam("attributes"))
+	excluded := attributeNames(c.QueryParam("excludedAttributes"))
+	for key := range resource {
+		lower := strings.ToLower(key)
+		if alwaysReturned[lower] {
+			continue
+		}
+		if len(attributes) > 0 && !attributes[lower] {
+			delete(resource, key)
+			continue
+		}
+		if excluded[lower] {
+			delete(resource, key)
+		}
+	}
+}
+
+func attributeNames(param string) map[string]bool {
+	names := map[string]bool{}
+	for _, name := range strings.Split(param, ",") {
+		name = strings.ToLower(stripSchemaPrefix(strings.TrimSpace(name)))
+		if name == "" {
+			continue
+		}
+		if i := strings.Index(name, "."); i >= 0 {
+			name = name[:i]
+		}
+		names[name] = true
+	}
+	return names
+}
+
+// stripSchemaPrefix はコアスキーマの URI を前置した属性名から URI を取り除く (RFC 7644 Section 3.10)
+func stripSchemaPrefix(name string) string {
+	for _, schema := range []string{schemaUser, schemaGroup} {
+		if len(name) > len(schema) && strings.EqualFold(name[:len(schema)+1], schema+":") {
+			return name[len(schema)+1:]
+		}
+	}
+	return name
+}
@@ -0,0 +1,584 @@
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/mail"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+var (
+	// phoneNumberRegex は E.164 形式の電話番号 (OIDC Core 1.0 Section 5.1)
+	phoneNumberRegex = regexp.MustCompile(`^\+[1-9][0-9]{1,14}$`)
+	// zoneinfoRegex は tz database のタイムゾーン名 (例: Asia/Tokyo)
+	zoneinfoRegex = regexp.MustCompile(`^[A-Za-z][A-Za-z0-9_+-]*(/[A-Za-z0-9_+-]+)*$`)
+	// localeRegex は BCP47 の言語タグ
+	localeRegex = regexp.MustCompile(`^[A-Za-z]{2,3}([-_][A-Za-z0-9]{2,8})*$`)
+)
+
+// flexBool は true / false に加えて "True" / "False" の文字列も受け付ける真偽値。
+// Azure AD などの SCIM クライアントは active を文字列で送ることがある
+type flexBool bool
+
+func (b *flexBool) UnmarshalJSON(data []byte) error {
+	var v interface{}
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	switch t := v.(type) {
+	case bool:
+		*b = flexBool(t)
+		return nil
+	case string:
+		switch strings.ToLower(t) {
+		case "true":
+			*b = true
+			return nil
+		case "false":
+			*b = false
+			return nil
+		}
+	}
+	return fmt.Errorf("boolean value is expected")
+}
+
+// userResource は User リソース (RFC 7643 Section 4.1)
+type userResource struct {
+	Schemas      []string       `json:"schemas"`
+	ID           string         `json:"id,omitempty"`
+	ExternalID   *string        `json:"externalId,omitempty"`
+	UserName     string         `json:"userName"`
+	Name         *userName      `json:"name,omitempty"`
+	DisplayName  *string        `json:"displayName,omitempty"`
+	NickName     *string        `json:"nickName,omitempty"`
+	ProfileURL   *string        `json:"profileUrl,omitempty"`
+	Locale       *string        `json:"locale,omitempty"`
+	Timezone     *string        `json:"timezone,omitempty"`
+	Active       *flexBool      `json:"active,omitempty"`
+	Password     *string        `json:"password,omitempty"`
+	Emails       []multiValue   `json:"emails,omitempty"`
+	PhoneNumbers []multiValue   `json:"phoneNumbers,omitempty"`
+	Addresses    []addressValue `json:"addresses,omitempty"`
+	Groups       []memberRef    `json:"groups,omitempty"`
+	Meta         *meta          `json:"meta,omitempty"`
+}
+
+type userName struct {
+	Formatted  *string `json:"formatted,omitempty"`
+	FamilyName *string `json:"familyName,omitempty"`
+	GivenName  *string `json:"givenName,omitempty"`
+	MiddleName *string `json:"middleName,omitempty"`
+}
+
+type multiValue struct {
+	Value   string   `json:"value"`
+	Type    string   `json:"type,omitempty"`
+	Primary flexBool `json:"primary,omitempty"`
+}
+
+type addressValue struct {
+	Formatted     string   `json:"formatted,omitempty"`
+	StreetAddress string   `json:"streetAddress,omitempty"`
+	Locality      string   `json:"locality,omitempty"`
+	Region        string   `json:"region,omitempty"`
+	PostalCode    string   `json:"postalCode,omitempty"`
+	Country       string   `json:"country,omitempty"`
+	Type          string   `json:"type,omitempty"`
+	Primary       flexBool `json:"primary,omitempty"`
+}
+
+// memberRef はグループのメンバーまたはユーザーの所属グループへの参照
+type memberRef struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+	Ref     string `json:"$ref,omitempty"`
+	Type    string `json:"type,omitempty"`
+}
+
+// UserHandler は /scim/v2/:tenant_code/Users を処理する。
+// ユーザーの削除は status を "deleted" にする論理削除で、削除したユーザーの userName とメールアドレスは再利用できない
+type UserHandler struct {
+	userStore    UserStore
+	groupStore   GroupStore
+	userRevoker  UserRevoker
+	hashPassword HashPasswordFunc
+	baseURL      string
+}
+
+func NewUserHandler(userStore UserStore, groupStore GroupStore, userRevoker UserRevoker, hashPassword HashPasswordFunc, baseURL string) *UserHandler {
+	return &UserHandler{
+		userStore:    userStore,
+		groupStore:   groupStore,
+		userRevoker:  userRevoker,
+		hashPassword: hashPassword,
+		baseURL:      baseURL,
+	}
+}
+
+// HandleList は GET /scim/v2/:tenant_code/Users を処理する (RFC 7644 Section 3.4.2)。
+func (h *UserHandler) HandleList(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenant := tenantFrom(c)
+
+	where, args, err := compileFilter(c.QueryParam("filter"), userAttrSpecs)
+	if err != nil {
+		return badRequest(c, scimTypeInvalidFilter, err.Error())
+	}
+	startIndex, count := pagination(c)
+
+	users, total, err := h.userStore.SearchActive(ctx, tenant.ID, where, args, startIndex-1, count)
+	if err != nil {
+		c.Logger().Errorf("failed to search users: %v", err)
+		return serverError(c)
+	}
+	ids := make([]uuid.UUID, len(users))
+	for i, u := range users {
+		ids[i] = u.ID
+	}
+	groups, err := h.groupStore.ListByMemberIDs(ctx, ids)
+	if err != nil {
+		c.Logger().Errorf("failed to list groups: %v", err)
+		return serverError(c)
+	}
+
+	resources := make([]interface{}, 0, len(users))
+	for i := range users {
+		resource, _, err := h.toResource(c, &users[i], groups[users[i].ID])
+		if err != nil {
+			return serverError(c)
+		}
+		project(c, resource)
+		resources = append(resources, resource)
+	}
+	return writeJSON(c, http.StatusOK, listResponse{
+		Schemas:      []string{schemaListResponse},
+		TotalResults: total,
+		StartIndex:   startIndex,
+		ItemsPerPage: len(resources),
+		Resources:    resources,
+	})
+}
+
+// HandleGet は GET /scim/v2/:tenant_code/Users/:id を処理する。
+func (h *UserHandler) HandleGet(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	if notModified(c, versionOf(user.UpdatedAt)) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return h.writeUser(c, http.StatusOK, user)
+}
+
+// HandleCreate は POST /scim/v2/:tenant_code/Users を処理する (RFC 7644 Section 3.3)。
+// SCIM で作成したユーザーのメールアドレスは未確認として扱う
+func (h *UserHandler) HandleCreate(c echo.Context) error {
+	ctx := c.Request().Context()
+	tenant := tenantFrom(c)
+
+	var req userResource
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+
+	user := &model.User{TenantID: tenant.ID, Status: "active"}
+	if err := applyUserResource(user, &req); err != nil {
+		return writeRequestError(c, err)
+	}
+	if req.Active != nil && !bool(*req.Active) {
+		user.Status = "disabled"
+	}
+	if err := h.checkUniqueness(ctx, user, nil); err != nil {
+		return writeRequestError(c, err)
+	}
+
+	passwordHash := ""
+	if req.Password != nil && *req.Password != "" {
+		hash, err := h.hashPassword(ctx, *req.Password)
+		if err != nil {
+			return serverError(c)
+		}
+		passwordHash = hash
+	}
+	if err := h.userStore.CreateWithPassword(ctx, user, passwordHash); err != nil {
+		c.Logger().Errorf("failed to create user: %v", err)
+		return serverError(c)
+	}
+	return h.writeUser(c, http.StatusCreated, user)
+}
+
+// HandleReplace は PUT /scim/v2/:tenant_code/Users/:id を処理する (RFC 7644 Section 3.5.1)。
+func (h *UserHandler) HandleReplace(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(user.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+
+	var req userResource
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+	return h.update(c, user, &req)
+}
+
+// HandlePatch は PATCH /scim/v2/:tenant_code/Users/:id を処理する (RFC 7644 Section 3.5.2)。
+// 現在のリソースに操作を適用した結果を PUT と同じ規則で保存する
+func (h *UserHandler) HandlePatch(c echo.Context) error {
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(user.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+
+	var req patchRequest
+	if err := decodeBody(c, &req); err != nil {
+		return badRequest(c, scimTypeInvalidSyntax, "invalid request body")
+	}
+	current, err := toMap(userToResource(user))
+	if err != nil {
+		return serverError(c)
+	}
+	if err := applyPatch(current, &req); err != nil {
+		return writeRequestError(c, err)
+	}
+	var patched userResource
+	if err := fromMap(current, &patched); err != nil {
+		return badRequest(c, scimTypeInvalidValue, err.Error())
+	}
+	return h.update(c, user, &patched)
+}
+
+// HandleDelete は DELETE /scim/v2/:tenant_code/Users/:id を処理する (RFC 7644 Section 3.6)。
+// セッションとトークンを失効させてからユーザーを論理削除する
+func (h *UserHandler) HandleDelete(c echo.Context) error {
+	ctx := c.Request().Context()
+	user, err := h.findUser(c)
+	if err != nil || user == nil {
+		return err
+	}
+	if !checkIfMatch(c, versionOf(user.UpdatedAt)) {
+		return preconditionFailed(c)
+	}
+
+	if _, _, _, err := h.userRevoker.RevokeUser(ctx, user.ID); err != nil {
+		c.Logger().Errorf("failed to revoke user: %v", err)
+		return serverError(c)
+	}
+	if err := h.userStore.SoftDelete(ctx, user.ID); err != nil {
+		c.Logger().Errorf("failed to delete user: %v", err)
+		return serverError(c)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// update は PUT の規則でユーザーを更新する。
+// メールアドレスを変更した場合は未確認に、電話番号を変更した場合は未確認にして SMS の2要素認証を無効にする。
+// active を false にした場合はセッションとトークンを失効させる
+func (h *UserHandler) update(c echo.Context, user *model.User, req *userResource) error {
+	ctx := c.Request().Context()
+
+	before := *user
+	if err := applyUserResource(user, req); err != nil {
+		return writeRequestError(c, err)
+	}
+	if err := h.checkUniqueness(ctx, user, &before); err != nil {
+		return writeRequestError(c, err)
+	}
+
+	if !strings.EqualFold(user.Email, before.Email) {
+		user.EmailVerified = false
+	}
+	if !equalStringPtr(user.PhoneNumber, before.PhoneNumber) {
+		user.PhoneNumberVerified = false
+	}
+	if !user.PhoneNumberVerified {
+		user.SMSMFAEnabled = false
+	}
+
+	deactivated := false
+	if req.Active != nil {
+		switch {
+		case bool(*req.Active) && user.Status != "active":
+			user.Status = "active"
+		case !bool(*req.Active) && user.Status != "disabled":
+			user.Status = "disabled"
+			deactivated = true
+		}
+	}
+
+	if err := h.userStore.UpdateProfile(ctx, user); err != nil {
+		c.Logger().Errorf("failed to update user: %v", err)
+		return serverError(c)
+	}
+	if req.Password != nil && *req.Password != "" {
+		hash, err := h.hashPassword(ctx, *req.Password)
+		if err != nil {
+			return serverError(c)
+		}
+		if err := h.userStore.SetPassword(ctx, user.ID, hash); err != nil {
+			c.Logger().Errorf("failed to set password: %v", err)
+			return serverError(c)
+		}
+	}
+	if deactivated {
+		if _, _, _, err := h.userRevoker.RevokeUser(ctx, user.ID); err != nil {
+			c.Logger().Errorf("failed to revoke user: %v", err)
+			return serverError(c)
+		}
+	}
+	return h.writeUser(c, http.StatusOK, user)
+}
+
+// checkUniqueness は userName とメールアドレスがテナント内で他のユーザーと重複しないか確認する。
+// 削除済みのユーザーとも重複させない
+func (h *UserHandler) checkUniqueness(ctx context.Context, user *model.User, before *model.User) error {
+	if before == nil || user.LoginID != before.LoginID {
+		existing, err := h.userStore.FindByTenantAndLoginID(ctx, user.TenantID, user.LoginID)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.ID != user.ID {
+			return newConflictError("userName is already in use")
+		}
+	}
+	if before == nil || !strings.EqualFold(user.Email, before.Email) {
+		existing, err := h.userStore.FindByTenantAndEmail(ctx, user.TenantID, user.Email)
+		if err != nil {
+			return err
+		}
+		if existing != nil && existing.ID != user.ID {
+			return newConflictError("email is already in use")
+		}
+	}
+	return nil
+}
+
+// findUser はパスの ID のユーザーを検索する。他のテナントのユーザーと削除済みのユーザーは 404 を返す
+func (h *UserHandler) findUser(c echo.Context) (*model.User, error) {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, notFound(c, "user not found")
+	}
+	user, err := h.userStore.FindByID(c.Request().Context(), id)
+	if err != nil {
+		c.Logger().Errorf("failed to find user: %v", err)
+		return nil, serverError(c)
+	}
+	if user == nil || user.TenantID != tenantFrom(c).ID || user.Status == "deleted" {
+		return nil, notFound(c, "user not found")
+	}
+	return user, nil
+}
+
+func (h *UserHandler) writeUser(c echo.Context, status int, user *model.User) error {
+	groups, err := h.groupStore.ListByMemberIDs(c.Request().Context(), []uuid.UUID{user.ID})
+	if err != nil {
+		c.Logger().Errorf("failed to list groups: %v", err)
+		return serverError(c)
+	}
+	resource, m, err := h.toResource(c, user, groups[user.ID])
+	if err != nil {
+		return serverError(c)
+	}
+	return writeResource(c, status, resource, m)
+}
+
+func (h *UserHandler) toResource(c echo.Context, user *model.User, groups []model.Group) (map[string]interface{}, meta, error) {
+	tenantCode := c.Param("tenant_code")
+	r := userToResource(user)
+	for _, g := range groups {
+		r.Groups = append(r.Groups, memberRef{
+			Value:   g.ID.String(),
+			Display: g.DisplayName,
+			Ref:     resourceLocation(h.baseURL, tenantCode, "Groups", g.ID),
+			Type:    "direct",
+		})
+	}
+	m := meta{
+		ResourceType: "User",
+		Created:      formatTime(user.CreatedAt),
+		LastModified: formatTime(user.UpdatedAt),
+		Location:     resourceLocation(h.baseURL, tenantCode, "Users", user.ID),
+		Version:      versionOf(user.UpdatedAt),
+	}
+	r.Meta = &m
+	resource, err := toMap(r)
+	return resource, m, err
+}
+
+// resourceLocation はリソースの URI (RFC 7644 Section 3.1)
+func resourceLocation(baseURL, tenantCode, resourceType string, id uuid.UUID) string {
+	return fmt.Sprintf("%s/scim/v2/%s/%s/%s", baseURL, url.PathEscape(tenantCode), resourceType, id)
+}
+
+// userToResource はユーザーを User リソースに変換する。groups と meta は呼び出し元で設定する
+func userToResource(u *model.User) *userResource {
+	active := flexBool(u.Status == "active")
+	r := &userResource{
+		Schemas:     []string{schemaUser},
+		ID:          u.ID.String(),
+		ExternalID:  u.ExternalID,
+		UserName:    u.LoginID,
+		DisplayName: u.Name,
+		NickName:    u.Nickname,
+		ProfileURL:  u.Profile,
+		Locale:      u.Locale,
+		Timezone:    u.Zoneinfo,
+		Active:      &active,
+		Emails:      []multiValue{{Value: u.Email, Type: "work", Primary: true}},
+	}
+	if u.Name != nil || u.GivenName != nil || u.FamilyName != nil || u.MiddleName != nil {
+		r.Name = &userName{Formatted: u.Name, GivenName: u.GivenName, FamilyName: u.FamilyName, MiddleName: u.MiddleName}
+	}
+	if u.PhoneNumber != nil {
+		r.PhoneNumbers = []multiValue{{Value: *u.PhoneNumber, Type: "mobile", Primary: true}}
+	}
+	if u.Address != nil {
+		r.Addresses = []addressValue{{
+			Formatted:     u.Address.Formatted,
+			StreetAddress: u.Address.StreetAddress,
+			Locality:      u.Address.Locality,
+			Region:        u.Address.Region,
+			PostalCode:    u.Address.PostalCode,
+			Country:       u.Address.Country,
+			Type:          "work",
+			Primary:       true,
+		}}
+	}
+	return r
+}
+
+// applyUserResource は User リソースの値をユーザーに設定する。指定のない属性は削除する (RFC 7644 Section 3.5.1)。
+// readOnly の id / groups / meta と、status を変える active は呼び出し元で扱う
+func applyUserResource(u *model.User, r *userResource) error {
+	loginID := strings.TrimSpace(r.UserName)
+	if loginID == "" || len(loginID) > 255 {
+		return newRequestError(scimTypeInvalidValue, "userName is required")
+	}
+	u.LoginID = loginID
+
+	email := primaryValue(r.Emails)
+	if email == nil {
+		return newRequestError(scimTypeInvalidValue, "emails is required")
+	}
+	addr, err := mail.ParseAddress(email.Value)
+	if err != nil || addr.Address != email.Value || len(email.Value) > 255 {
+		return newRequestError(scimTypeInvalidValue, "invalid emails.value")
+	}
+	u.Email = email.Value
+
+	var name *userName
+	if r.Name != nil {
+		name = r.Name
+	} else {
+		name = &userName{}
+	}
+	displayName := r.DisplayName
+	if displayName == nil || *displayName == "" {
+		displayName = name.Formatted
+	}
+
+	var phoneNumber *string
+	if phone := primaryValue(r.PhoneNumbers); phone != nil {
+		phoneNumber = &phone.Value
+	}
+
+	fields := []struct {
+		name     string
+		value    *string
+		target   **string
+		maxLen   int
+		validate func(string) bool
+	}{
+		{"externalId", r.ExternalID, &u.ExternalID, 255, nil},
+		{"displayName", displayName, &u.Name, 255, nil},
+		{"name.givenName", name.GivenName, &u.GivenName, 255, nil},
+		{"name.familyName", name.FamilyName, &u.FamilyName, 255, nil},
+		{"name.middleName", name.MiddleName, &u.MiddleName, 255, nil},
+		{"nickName", r.NickName, &u.Nickname, 255, nil},
+		{"profileUrl", r.ProfileURL, &u.Profile, 2048, isHTTPURL},
+		{"locale", r.Locale, &u.Locale, 35, localeRegex.MatchString},
+		{"timezone", r.Timezone, &u.Zoneinfo, 63, zoneinfoRegex.MatchString},
+		{"phoneNumbers.value", phoneNumber, &u.PhoneNumber, 32, phoneNumberRegex.MatchString},
+	}
+	for _, f := range fields {
+		if f.value == nil || *f.value == "" {
+			*f.target = nil
+			continue
+		}
+		if len(*f.value) > f.maxLen || (f.validate != nil && !f.validate(*f.value)) {
+			return newRequestError(scimTypeInvalidValue, "invalid %s", f.name)
+		}
+		v := *f.value
+		*f.target = &v
+	}
+
+	u.Address = nil
+	if a := primaryAddress(r.Addresses); a != nil {
+		address := &model.Address{
+			Formatted:     a.Formatted,
+			StreetAddress: a.StreetAddress,
+			Locality:      a.Locality,
+			Region:        a.Region,
+			PostalCode:    a.PostalCode,
+			Country:       a.Country,
+		}
+		if !address.IsEmpty() {
+			u.Address = address
+		}
+	}
+	return nil
+}
+
+// primaryValue は primary が true の値、なければ最初の値を返す。このシステムはメールアドレスと電話番号を1つずつしか持たない
+func primaryValue(values []multiValue) *multiValue {
+	for i := range values {
+		if values[i].Primary {
+			return &values[i]
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return &values[0]
+}
+
+func primaryAddress(values []addressValue) *addressValue {
+	for i := range values {
+		if values[i].Primary {
+			return &values[i]
+		}
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	return &values[0]
+}
+
+// isHTTPURL は http(s) の絶対 URL か判定する。
+func isHTTPURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http") && u.Host != ""
+}
+
+func equalStringPtr(a, b *string) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
@@ -0,0 +1,54 @@
+// Package sms は SMS 送信 (auth.SMSSender) の実装を提供する。
+package sms
+
+import (
+	"context"
+	"log"
+	"sync"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+)
+
+// outboxCapacity は Outbox が保持する SMS の上限。超えた分は古いものから捨てる
+const outboxCapacity = 100
+
+// Outbox は送信した SMS をメモリに保持してログに出力する。SMS ゲートウェイのないローカル開発・テスト用で、
+// ログに出たワンタイムコードを入力するとユーザーとして操作できる。
+type Outbox struct {
+	logger *log.Logger
+
+	mu       sync.Mutex
+	messages []model.SMSMessage
+}
+
+// NewOutbox は Outbox を生成する。
+func NewOutbox(logger *log.Logger) *Outbox {
+	return &Outbox{logger: logger}
+}
+
+// SendSMS は SMS を Outbox に追加し、内容をログに出力する。
+func (o *Outbox) SendSMS(_ context.Context, msg *model.SMSMessage) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	o.messages = append(o.messages, *msg)
+	if len(o.messages) > outboxCapacity {
+		o.messages = o.messages[len(o.messages)-outboxCapacity:]
+	}
+	o.logger.Printf("[sms] to=%s body=%q", msg.To, msg.Body)
+	return nil
+}
+
+// Messages は to 宛てに送信した SMS を送信順に返す。to が空の場合は全ての SMS を返す。
+func (o *Outbox) Messages(to string) []model.SMSMessage {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var out []model.SMSMessage
+	for _, msg := range o.messages {
+		if to == "" || msg.To == to {
+			out = append(out, msg)
+		}
+	}
+	return out
+}
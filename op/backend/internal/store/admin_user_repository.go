@@ -53,3 +53,16 @@ func (r *AdminUserRepository) UpdateLastLoginAt(ctx context.Context, id uuid.UUI
 		Where("id = ?", id).
 		Update("last_login_at", t).Error
 }
+
+// RehashPassword はパスワードのハッシュが from のままの場合に to で置き換える。
+// 同時にパスワードが変更されていた場合は更新せず false を返す。
+func (r *AdminUserRepository) RehashPassword(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.AdminUser{}).
+		Where("id = ? AND password_hash = ?", id, from).
+		Updates(map[string]interface{}{"password_hash": to, "updated_at": time.Now()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// APIResourceRepository はテナントの API リソースを永続化する。
+type APIResourceRepository struct {
+	db *gorm.DB
+}
+
+// NewAPIResourceRepository は APIResourceRepository を生成する。
+func NewAPIResourceRepository(db *gorm.DB) *APIResourceRepository {
+	return &APIResourceRepository{db: db}
+}
+
+// ListByTenantID はテナントに属する API リソースを識別子の昇順で返す。
+func (r *APIResourceRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.APIResource, error) {
+	var resources []model.APIResource
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("identifier ASC").
+		Find(&resources)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return resources, nil
+}
+
+// Create は新しい API リソースを永続化する。
+func (r *APIResourceRepository) Create(ctx context.Context, resource *model.APIResource) error {
+	return r.db.WithContext(ctx).Create(resource).Error
+}
+
+// FindByID は UUID で API リソースを検索する。見つからない場合は (nil, nil) を返す。
+func (r *APIResourceRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.APIResource, error) {
+	var resource model.APIResource
+	result := r.db.WithContext(ctx).First(&resource, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &resource, nil
+}
+
+// FindByIdentifier はテナント内でリソース識別子が一致する API リソースを検索する。見つからない場合は (nil, nil) を返す。
+func (r *APIResourceRepository) FindByIdentifier(ctx context.Context, tenantID uuid.UUID, identifier string) (*model.APIResource, error) {
+	var resource model.APIResource
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND identifier = ?", tenantID, identifier).
+		First(&resource)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &resource, nil
+}
+
+// Update は API リソースの変更を保存する。
+func (r *APIResourceRepository) Update(ctx context.Context, resource *model.APIResource) error {
+	return r.db.WithContext(ctx).Save(resource).Error
+}
+
+// Delete は API リソースを削除する。
+func (r *APIResourceRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.APIResource{}, "id = ?", id).Error
+}
@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// AuthorizationDetailTypeRepository はテナントの authorization_details の type を永続化する。
+type AuthorizationDetailTypeRepository struct {
+	db *gorm.DB
+}
+
+// NewAuthorizationDetailTypeRepository は AuthorizationDetailTypeRepository を生成する。
+func NewAuthorizationDetailTypeRepository(db *gorm.DB) *AuthorizationDetailTypeRepository {
+	return &AuthorizationDetailTypeRepository{db: db}
+}
+
+// ListByTenantID はテナントに属する type を名前の昇順で返す。
+func (r *AuthorizationDetailTypeRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.AuthorizationDetailType, error) {
+	var types []model.AuthorizationDetailType
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("type ASC").
+		Find(&types)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return types, nil
+}
+
+// Create は新しい type を永続化する。
+func (r *AuthorizationDetailTypeRepository) Create(ctx context.Context, detailType *model.AuthorizationDetailType) error {
+	return r.db.WithContext(ctx).Create(detailType).Error
+}
+
+// FindByID は UUID で type を検索する。見つからない場合は (nil, nil) を返す。
+func (r *AuthorizationDetailTypeRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.AuthorizationDetailType, error) {
+	var detailType model.AuthorizationDetailType
+	result := r.db.WithContext(ctx).First(&detailType, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &detailType, nil
+}
+
+// FindByType はテナント内で type の値が一致するものを検索する。見つからない場合は (nil, nil) を返す。
+func (r *AuthorizationDetailTypeRepository) FindByType(ctx context.Context, tenantID uuid.UUID, detailType string) (*model.AuthorizationDetailType, error) {
+	var found model.AuthorizationDetailType
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND type = ?", tenantID, detailType).
+		First(&found)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &found, nil
+}
+
+// Update は type の変更を保存する。
+func (r *AuthorizationDetailTypeRepository) Update(ctx context.Context, detailType *model.AuthorizationDetailType) error {
+	return r.db.WithContext(ctx).Save(detailType).Error
+}
+
+// Delete は type を削除する。
+func (r *AuthorizationDetailTypeRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.AuthorizationDetailType{}, "id = ?", id).Error
+}
@@ -0,0 +1,87 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// AuthorizationDetailsApprovalRepository は authorization_details (RFC 9396) に対するユーザーの承認を永続化する。
+type AuthorizationDetailsApprovalRepository struct {
+	db *gorm.DB
+}
+
+// NewAuthorizationDetailsApprovalRepository は AuthorizationDetailsApprovalRepository を生成する。
+func NewAuthorizationDetailsApprovalRepository(db *gorm.DB) *AuthorizationDetailsApprovalRepository {
+	return &AuthorizationDetailsApprovalRepository{db: db}
+}
+
+// Create は新しい承認待ちの記録を永続化する。
+func (r *AuthorizationDetailsApprovalRepository) Create(ctx context.Context, a *model.AuthorizationDetailsApproval) error {
+	return r.db.WithContext(ctx).Create(a).Error
+}
+
+// FindByID は ID で検索し、クライアントをプリロードして返す。
+func (r *AuthorizationDetailsApprovalRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.AuthorizationDetailsApproval, error) {
+	var a model.AuthorizationDetailsApproval
+	result := r.db.WithContext(ctx).
+		Preload("Client").
+		Where("id = ?", id).
+		First(&a)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &a, nil
+}
+
+// FindLatest はセッション・クライアント・authorization_details のハッシュ値が一致する、未使用で期限内の最新の記録を返す。
+func (r *AuthorizationDetailsApprovalRepository) FindLatest(ctx context.Context, sessionID, clientID uuid.UUID, detailsHash string) (*model.AuthorizationDetailsApproval, error) {
+	var a model.AuthorizationDetailsApproval
+	result := r.db.WithContext(ctx).
+		Where("session_id = ? AND client_id = ? AND details_hash = ? AND status <> ? AND expires_at > ?",
+			sessionID, clientID, detailsHash, model.AuthorizationDetailsApprovalStatusConsumed, time.Now()).
+		Order("created_at DESC").
+		First(&a)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &a, nil
+}
+
+// Decide は承認待ちの記録を承認または拒否する。既に処理済み・期限切れの場合は false を返す。
+func (r *AuthorizationDetailsApprovalRepository) Decide(ctx context.Context, id uuid.UUID, approved bool) (bool, error) {
+	status := model.AuthorizationDetailsApprovalStatusDenied
+	if approved {
+		status = model.AuthorizationDetailsApprovalStatusApproved
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.AuthorizationDetailsApproval{}).
+		Where("id = ? AND status = ? AND expires_at > ?", id, model.AuthorizationDetailsApprovalStatusPending, time.Now()).
+		Update("status", status)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkConsumed は承認または拒否された記録を使用済みにする。並行リクエストで既に使用済みの場合は false を返す。
+func (r *AuthorizationDetailsApprovalRepository) MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.AuthorizationDetailsApproval{}).
+		Where("id = ? AND status IN ?", id, []string{model.AuthorizationDetailsApprovalStatusApproved, model.AuthorizationDetailsApprovalStatusDenied}).
+		Update("status", model.AuthorizationDetailsApprovalStatusConsumed)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
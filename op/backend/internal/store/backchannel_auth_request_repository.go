@@ -0,0 +1,111 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// BackchannelAuthRequestRepository は CIBA のバックチャネル認証リクエストを永続化する。
+type BackchannelAuthRequestRepository struct {
+	db *gorm.DB
+}
+
+// NewBackchannelAuthRequestRepository は BackchannelAuthRequestRepository を生成する。
+func NewBackchannelAuthRequestRepository(db *gorm.DB) *BackchannelAuthRequestRepository {
+	return &BackchannelAuthRequestRepository{db: db}
+}
+
+// Create は新しいバックチャネル認証リクエストを永続化する。
+func (r *BackchannelAuthRequestRepository) Create(ctx context.Context, req *model.BackchannelAuthRequest) error {
+	return r.db.WithContext(ctx).Create(req).Error
+}
+
+// FindByAuthReqIDHash は auth_req_id のハッシュ値で検索する。承認済みの場合はセッションもプリロードする。
+func (r *BackchannelAuthRequestRepository) FindByAuthReqIDHash(ctx context.Context, hash string) (*model.BackchannelAuthRequest, error) {
+	var req model.BackchannelAuthRequest
+	result := r.db.WithContext(ctx).
+		Preload("Session").
+		Where("auth_req_id_hash = ?", hash).
+		First(&req)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &req, nil
+}
+
+// FindByID は ID で検索し、クライアントをプリロードして返す。
+func (r *BackchannelAuthRequestRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.BackchannelAuthRequest, error) {
+	var req model.BackchannelAuthRequest
+	result := r.db.WithContext(ctx).
+		Preload("Client").
+		Where("id = ?", id).
+		First(&req)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &req, nil
+}
+
+// ListPendingByUserID はユーザー宛ての承認待ち・有効期限内のリクエストを新しい順に返す。
+func (r *BackchannelAuthRequestRepository) ListPendingByUserID(ctx context.Context, userID uuid.UUID) ([]model.BackchannelAuthRequest, error) {
+	var reqs []model.BackchannelAuthRequest
+	err := r.db.WithContext(ctx).
+		Preload("Client").
+		Where("user_id = ? AND status = ? AND expires_at > ?", userID, model.BackchannelAuthStatusPending, time.Now()).
+		Order("created_at DESC").
+		Find(&reqs).Error
+	return reqs, err
+}
+
+// Decide は承認待ちのリクエストを承認または拒否する。既に処理済み・期限切れの場合は false を返す。
+func (r *BackchannelAuthRequestRepository) Decide(ctx context.Context, id uuid.UUID, sessionID uuid.UUID, approved bool) (bool, error) {
+	status := model.BackchannelAuthStatusDenied
+	if approved {
+		status = model.BackchannelAuthStatusApproved
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.BackchannelAuthRequest{}).
+		Where("id = ? AND status = ? AND expires_at > ?", id, model.BackchannelAuthStatusPending, time.Now()).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"session_id": sessionID,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdatePolling は最終ポーリング日時とポーリング間隔を更新する。
+func (r *BackchannelAuthRequestRepository) UpdatePolling(ctx context.Context, id uuid.UUID, polledAt time.Time, interval int) error {
+	return r.db.WithContext(ctx).
+		Model(&model.BackchannelAuthRequest{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_polled_at": polledAt,
+			"poll_interval":  interval,
+		}).Error
+}
+
+// MarkConsumed は承認済みのリクエストをトークン発行済みにする。並行リクエストで既に発行済みの場合は false を返す。
+func (r *BackchannelAuthRequestRepository) MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.BackchannelAuthRequest{}).
+		Where("id = ? AND status = ?", id, model.BackchannelAuthStatusApproved).
+		Update("status", model.BackchannelAuthStatusConsumed)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ClientAssertionJTIRepository はクライアントアサーションの使用済み jti を永続化する。
+type ClientAssertionJTIRepository struct {
+	db *gorm.DB
+}
+
+// NewClientAssertionJTIRepository は ClientAssertionJTIRepository を生成する。
+func NewClientAssertionJTIRepository(db *gorm.DB) *ClientAssertionJTIRepository {
+	return &ClientAssertionJTIRepository{db: db}
+}
+
+// Register は jti を使用済みとして記録する。同じクライアントで既に使用済みの場合は false を返す。
+func (r *ClientAssertionJTIRepository) Register(ctx context.Context, clientID uuid.UUID, jti string, expiresAt time.Time) (bool, error) {
+	// 期限切れの行はリプレイ判定に不要なので、ついでに掃除する
+	if err := r.db.WithContext(ctx).
+		Where("expires_at < ?", time.Now()).
+		Delete(&model.ClientAssertionJTI{}).Error; err != nil {
+		return false, err
+	}
+
+	result := r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(&model.ClientAssertionJTI{
+			ClientID:  clientID,
+			JTI:       jti,
+			ExpiresAt: expiresAt,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
@@ -7,6 +7,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type ClientRepository struct {
@@ -102,12 +103,28 @@ func (r *ClientRepository) Update(ctx context.Context, client *model.Client) err
 	return r.db.WithContext(ctx).Save(client).Error
 }
 
-// UpdateSecretHash は client_secret_hash フィールドのみを更新する。
-func (r *ClientRepository) UpdateSecretHash(ctx context.Context, id uuid.UUID, hash string) error {
+// UpdateSecret は client_secret_hash と client_secret_encrypted フィールドのみを更新する。
+func (r *ClientRepository) UpdateSecret(ctx context.Context, id uuid.UUID, hash, encrypted string) error {
 	return r.db.WithContext(ctx).
 		Model(&model.Client{}).
 		Where("id = ?", id).
-		Update("client_secret_hash", hash).Error
+		Updates(map[string]interface{}{
+			"client_secret_hash":      hash,
+			"client_secret_encrypted": encrypted,
+		}).Error
+}
+
+// RehashSecret は client_secret_hash が from のままの場合に to で置き換える。
+// 同時に client_secret が再発行されていた場合は更新せず false を返す。
+func (r *ClientRepository) RehashSecret(ctx context.Context, id uuid.UUID, from, to string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.Client{}).
+		Where("id = ? AND client_secret_hash = ?", id, from).
+		Update("client_secret_hash", to)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
 }
 
 // SoftDelete はクライアントの status を "disabled" に設定して論理削除する。
@@ -117,3 +134,38 @@ func (r *ClientRepository) SoftDelete(ctx context.Context, id uuid.UUID) error {
 		Where("id = ?", id).
 		Update("status", "disabled").Error
 }
+
+// UpdateWithRedirectURIs はクライアントの変更を保存し、リダイレクト URI とポストログアウトリダイレクト URI を
+// client.RedirectURIs / client.PostLogoutRedirectURIs の内容で置き換える。
+func (r *ClientRepository) UpdateWithRedirectURIs(ctx context.Context, client *model.Client) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Save(client).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ID).Delete(&model.RedirectURI{}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("client_id = ?", client.ID).Delete(&model.PostLogoutRedirectURI{}).Error; err != nil {
+			return err
+		}
+		for i := range client.RedirectURIs {
+			client.RedirectURIs[i].ID = uuid.Nil
+			client.RedirectURIs[i].ClientDBID = client.ID
+		}
+		if len(client.RedirectURIs) > 0 {
+			if err := tx.Create(&client.RedirectURIs).Error; err != nil {
+				return err
+			}
+		}
+		for i := range client.PostLogoutRedirectURIs {
+			client.PostLogoutRedirectURIs[i].ID = uuid.Nil
+			client.PostLogoutRedirectURIs[i].ClientDBID = client.ID
+		}
+		if len(client.PostLogoutRedirectURIs) > 0 {
+			if err := tx.Create(&client.PostLogoutRedirectURIs).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
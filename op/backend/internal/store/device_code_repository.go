@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// DeviceCodeRepository はデバイス認可リクエスト (RFC 8628) を永続化する。
+type DeviceCodeRepository struct {
+	db *gorm.DB
+}
+
+// NewDeviceCodeRepository は DeviceCodeRepository を生成する。
+func NewDeviceCodeRepository(db *gorm.DB) *DeviceCodeRepository {
+	return &DeviceCodeRepository{db: db}
+}
+
+// Create は新しいデバイス認可リクエストを永続化する。
+func (r *DeviceCodeRepository) Create(ctx context.Context, dc *model.DeviceCode) error {
+	return r.db.WithContext(ctx).Create(dc).Error
+}
+
+// FindByDeviceCodeHash は device_code のハッシュ値で検索する。承認済みの場合はセッションもプリロードする。
+func (r *DeviceCodeRepository) FindByDeviceCodeHash(ctx context.Context, hash string) (*model.DeviceCode, error) {
+	var dc model.DeviceCode
+	result := r.db.WithContext(ctx).
+		Preload("Session").
+		Where("device_code_hash = ?", hash).
+		First(&dc)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &dc, nil
+}
+
+// FindByUserCode は user_code で検索し、クライアントをプリロードして返す。
+func (r *DeviceCodeRepository) FindByUserCode(ctx context.Context, userCode string) (*model.DeviceCode, error) {
+	var dc model.DeviceCode
+	result := r.db.WithContext(ctx).
+		Preload("Client").
+		Where("user_code = ?", userCode).
+		First(&dc)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &dc, nil
+}
+
+// Decide は承認待ちのリクエストを承認または拒否する。既に処理済み・期限切れの場合は false を返す。
+func (r *DeviceCodeRepository) Decide(ctx context.Context, id uuid.UUID, sessionID uuid.UUID, approved bool) (bool, error) {
+	status := model.DeviceCodeStatusDenied
+	if approved {
+		status = model.DeviceCodeStatusApproved
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.DeviceCode{}).
+		Where("id = ? AND status = ? AND expires_at > ?", id, model.DeviceCodeStatusPending, time.Now()).
+		Updates(map[string]interface{}{
+			"status":     status,
+			"session_id": sessionID,
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdatePolling は最終ポーリング日時とポーリング間隔を更新する。
+func (r *DeviceCodeRepository) UpdatePolling(ctx context.Context, id uuid.UUID, polledAt time.Time, interval int) error {
+	return r.db.WithContext(ctx).
+		Model(&model.DeviceCode{}).
+		Where("id = ?", id).
+		Updates(map[string]interface{}{
+			"last_polled_at": polledAt,
+			"poll_interval":  interval,
+		}).Error
+}
+
+// MarkConsumed は承認済みのリクエストをトークン発行済みにする。並行リクエストで既に発行済みの場合は false を返す。
+func (r *DeviceCodeRepository) MarkConsumed(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.DeviceCode{}).
+		Where("id = ? AND status = ?", id, model.DeviceCodeStatusApproved).
+		Update("status", model.DeviceCodeStatusConsumed)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// RecordUserCodeFailure は user_code の入力失敗を記録する。
+func (r *DeviceCodeRepository) RecordUserCodeFailure(ctx context.Context, sessionID uuid.UUID, ipAddress string) error {
+	return r.db.WithContext(ctx).Create(&model.DeviceUserCodeFailure{
+		SessionID: sessionID,
+		IPAddress: ipAddress,
+	}).Error
+}
+
+// CountUserCodeFailures は since 以降のセッションまたは IP アドレスごとの入力失敗回数のうち多い方を返す。
+func (r *DeviceCodeRepository) CountUserCodeFailures(ctx context.Context, sessionID uuid.UUID, ipAddress string, since time.Time) (int64, error) {
+	var bySession, byIP int64
+	if err := r.db.WithContext(ctx).
+		Model(&model.DeviceUserCodeFailure{}).
+		Where("session_id = ? AND created_at > ?", sessionID, since).
+		Count(&bySession).Error; err != nil {
+		return 0, err
+	}
+	if err := r.db.WithContext(ctx).
+		Model(&model.DeviceUserCodeFailure{}).
+		Where("ip_address = ? AND created_at > ?", ipAddress, since).
+		Count(&byIP).Error; err != nil {
+		return 0, err
+	}
+	return max(bySession, byIP), nil
+}
@@ -0,0 +1,113 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// EmailChangeRequestRepository はメールアドレスの変更リクエストを永続化する。
+type EmailChangeRequestRepository struct {
+	db *gorm.DB
+}
+
+// NewEmailChangeRequestRepository は EmailChangeRequestRepository を生成する。
+func NewEmailChangeRequestRepository(db *gorm.DB) *EmailChangeRequestRepository {
+	return &EmailChangeRequestRepository{db: db}
+}
+
+// Create は新しい変更リクエストを永続化する。
+func (r *EmailChangeRequestRepository) Create(ctx context.Context, req *model.EmailChangeRequest) error {
+	return r.db.WithContext(ctx).Create(req).Error
+}
+
+// FindLatestByUserID はユーザーの最新の変更リクエストを返す。見つからない場合は (nil, nil) を返す。
+func (r *EmailChangeRequestRepository) FindLatestByUserID(ctx context.Context, userID uuid.UUID) (*model.EmailChangeRequest, error) {
+	var req model.EmailChangeRequest
+	result := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		First(&req)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &req, nil
+}
+
+// DeletePendingByUserID はユーザーの未確認の変更リクエストを削除する。新しいリクエストで古い確認リンクを無効にするために使う。
+func (r *EmailChangeRequestRepository) DeletePendingByUserID(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND confirmed_at IS NULL", userID).
+		Delete(&model.EmailChangeRequest{}).Error
+}
+
+// Confirm は有効期限内の未確認リクエストを確認用トークンのハッシュ値で検索し、確認済みにして取り消し用トークンを記録する。
+// 見つからない場合や並行リクエストで既に確認された場合は (nil, nil) を返す。
+func (r *EmailChangeRequestRepository) Confirm(ctx context.Context, tokenHash, revertTokenHash string, revertExpiresAt time.Time) (*model.EmailChangeRequest, error) {
+	var req model.EmailChangeRequest
+	result := r.db.WithContext(ctx).
+		Where("token_hash = ? AND confirmed_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&req)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+
+	now := time.Now()
+	result = r.db.WithContext(ctx).
+		Model(&model.EmailChangeRequest{}).
+		Where("id = ? AND confirmed_at IS NULL", req.ID).
+		Updates(map[string]interface{}{
+			"confirmed_at":      now,
+			"revert_token_hash": revertTokenHash,
+			"revert_expires_at": revertExpiresAt,
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	req.ConfirmedAt = &now
+	req.RevertTokenHash = &revertTokenHash
+	req.RevertExpiresAt = &revertExpiresAt
+	return &req, nil
+}
+
+// Revert は有効期限内の取り消し用トークンをハッシュ値で検索し、取り消し済みにする。
+// 見つからない場合や並行リクエストで既に取り消された場合は (nil, nil) を返す。
+func (r *EmailChangeRequestRepository) Revert(ctx context.Context, revertTokenHash string) (*model.EmailChangeRequest, error) {
+	var req model.EmailChangeRequest
+	result := r.db.WithContext(ctx).
+		Where("revert_token_hash = ? AND reverted_at IS NULL AND revert_expires_at > ?", revertTokenHash, time.Now()).
+		First(&req)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+
+	now := time.Now()
+	result = r.db.WithContext(ctx).
+		Model(&model.EmailChangeRequest{}).
+		Where("id = ? AND reverted_at IS NULL", req.ID).
+		Update("reverted_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	req.RevertedAt = &now
+	return &req, nil
+}
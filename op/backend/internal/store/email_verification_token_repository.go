@@ -0,0 +1,78 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// EmailVerificationTokenRepository はメールアドレス確認用のトークンを永続化する。
+type EmailVerificationTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewEmailVerificationTokenRepository は EmailVerificationTokenRepository を生成する。
+func NewEmailVerificationTokenRepository(db *gorm.DB) *EmailVerificationTokenRepository {
+	return &EmailVerificationTokenRepository{db: db}
+}
+
+// Create は新しいトークンを永続化する。
+func (r *EmailVerificationTokenRepository) Create(ctx context.Context, token *model.EmailVerificationToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// FindLatestByUserID はユーザーに最後に発行したトークンを返す。見つからない場合は (nil, nil) を返す。
+func (r *EmailVerificationTokenRepository) FindLatestByUserID(ctx context.Context, userID uuid.UUID) (*model.EmailVerificationToken, error) {
+	var token model.EmailVerificationToken
+	result := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// DeleteUnusedByUserID はユーザーの未使用のトークンを削除する。再送時に古いトークンを無効にするために使う。
+func (r *EmailVerificationTokenRepository) DeleteUnusedByUserID(ctx context.Context, userID uuid.UUID) error {
+	return r.db.WithContext(ctx).
+		Where("user_id = ? AND used_at IS NULL", userID).
+		Delete(&model.EmailVerificationToken{}).Error
+}
+
+// Consume は有効期限内の未使用トークンをハッシュ値で検索し、使用済みにする。
+// 見つからない場合や並行リクエストで既に使用された場合は (nil, nil) を返す。
+func (r *EmailVerificationTokenRepository) Consume(ctx context.Context, tokenHash string) (*model.EmailVerificationToken, error) {
+	var token model.EmailVerificationToken
+	result := r.db.WithContext(ctx).
+		Where("token_hash = ? AND used_at IS NULL AND expires_at > ?", tokenHash, time.Now()).
+		First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+
+	now := time.Now()
+	result = r.db.WithContext(ctx).
+		Model(&model.EmailVerificationToken{}).
+		Where("id = ? AND used_at IS NULL", token.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	token.UsedAt = &now
+	return &token, nil
+}
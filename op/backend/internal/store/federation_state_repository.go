@@ -0,0 +1,56 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// FederationStateRepository は上流への認可リクエストの状態を永続化する。
+type FederationStateRepository struct {
+	db *gorm.DB
+}
+
+// NewFederationStateRepository は FederationStateRepository を生成する。
+func NewFederationStateRepository(db *gorm.DB) *FederationStateRepository {
+	return &FederationStateRepository{db: db}
+}
+
+// Create は新しい状態を永続化する。
+func (r *FederationStateRepository) Create(ctx context.Context, state *model.FederationState) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(state).Error
+}
+
+// Consume は有効期限内の未使用の状態をハッシュ値で検索し、使用済みにする。IdP とそのテナントもプリロードする。
+// 見つからない場合や並行リクエストで既に使用された場合は (nil, nil) を返す。
+func (r *FederationStateRepository) Consume(ctx context.Context, stateHash string) (*model.FederationState, error) {
+	var state model.FederationState
+	result := r.db.WithContext(ctx).
+		Preload("IdentityProvider.Tenant").
+		Where("state_hash = ? AND used_at IS NULL AND expires_at > ?", stateHash, time.Now()).
+		First(&state)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+
+	now := time.Now()
+	result = r.db.WithContext(ctx).
+		Model(&model.FederationState{}).
+		Where("id = ? AND used_at IS NULL", state.ID).
+		Update("used_at", now)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected != 1 {
+		return nil, nil
+	}
+	state.UsedAt = &now
+	return &state, nil
+}
@@ -0,0 +1,233 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// GroupRepository は SCIM でプロビジョニング、または LDAP ディレクトリから同期されるグループとメンバーを永続化する。
+type GroupRepository struct {
+	db *gorm.DB
+}
+
+// NewGroupRepository は GroupRepository を生成する。
+func NewGroupRepository(db *gorm.DB) *GroupRepository {
+	return &GroupRepository{db: db}
+}
+
+// Search はテナントのグループを条件で検索し、作成日時の昇順で offset から limit 件と総件数を返す。メンバーのユーザーもプリロードする。
+// where は呼び出し元で組み立てたプレースホルダ付きの条件式 (空の場合は条件なし)。
+func (r *GroupRepository) Search(ctx context.Context, tenantID uuid.UUID, where string, args []interface{}, offset, limit int) ([]model.Group, int64, error) {
+	query := r.db.WithContext(ctx).
+		Model(&model.Group{}).
+		Where("groups.tenant_id = ?", tenantID)
+	if where != "" {
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var groups []model.Group
+	if limit > 0 {
+		if err := query.Preload("Members.User").
+			Order("groups.created_at ASC, groups.id ASC").
+			Offset(offset).Limit(limit).
+			Find(&groups).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	return groups, total, nil
+}
+
+// FindByID は ID で検索する。メンバーのユーザーもプリロードする。見つからない場合は (nil, nil) を返す。
+func (r *GroupRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.Group, error) {
+	var group model.Group
+	result := r.db.WithContext(ctx).
+		Preload("Members.User").
+		First(&group, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &group, nil
+}
+
+// FindByDisplayName はテナント内で表示名が一致するグループを検索する。見つからない場合は (nil, nil) を返す。
+func (r *GroupRepository) FindByDisplayName(ctx context.Context, tenantID uuid.UUID, displayName string) (*model.Group, error) {
+	var group model.Group
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND display_name = ?", tenantID, displayName).
+		First(&group)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &group, nil
+}
+
+// Create はグループとメンバーを作成する。
+func (r *GroupRepository) Create(ctx context.Context, group *model.Group) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(group).Error; err != nil {
+			return err
+		}
+		return insertMembers(tx, group)
+	})
+}
+
+// Update はグループの変更を保存し、メンバーを group.Members で置き換える。
+func (r *GroupRepository) Update(ctx context.Context, group *model.Group) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		group.UpdatedAt = time.Now()
+		if err := tx.Omit(clause.Associations).Save(group).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("group_id = ?", group.ID).Delete(&model.GroupMember{}).Error; err != nil {
+			return err
+		}
+		return insertMembers(tx, group)
+	})
+}
+
+func insertMembers(tx *gorm.DB, group *model.Group) error {
+	for i := range group.Members {
+		group.Members[i].GroupID = group.ID
+		if err := tx.Omit(clause.Associations).Create(&group.Members[i]).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Delete はグループを削除する。メンバーも削除される。
+func (r *GroupRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.Group{}, "id = ?", id).Error
+}
+
+// ListByMemberIDs はユーザーが所属するグループをユーザー ID ごとに返す。
+func (r *GroupRepository) ListByMemberIDs(ctx context.Context, userIDs []uuid.UUID) (map[uuid.UUID][]model.Group, error) {
+	groups := map[uuid.UUID][]model.Group{}
+	if len(userIDs) == 0 {
+		return groups, nil
+	}
+
+	var rows []struct {
+		model.Group
+		UserID uuid.UUID
+	}
+	result := r.db.WithContext(ctx).
+		Table("groups").
+		Select("groups.*, group_members.user_id").
+		Joins("JOIN group_members ON group_members.group_id = groups.id").
+		Where("group_members.user_id IN ?", userIDs).
+		Order("groups.display_name ASC").
+		Scan(&rows)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	for _, row := range rows {
+		groups[row.UserID] = append(groups[row.UserID], row.Group)
+	}
+	return groups, nil
+}
+
+// SyncLDAPMemberships はユーザーが所属するディレクトリのグループを groups に合わせる。
+// ディレクトリのグループは DN (external_id) で識別し、なければ作成する。表示名がテナント内の他のグループと重複する場合は DN を表示名にする。
+// ディレクトリから同期したグループ以外のメンバーシップは変更しない
+func (r *GroupRepository) SyncLDAPMemberships(ctx context.Context, tenantID, directoryID, userID uuid.UUID, groups []model.LDAPGroup) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		want := map[uuid.UUID]bool{}
+		for _, g := range groups {
+			id, err := findOrCreateLDAPGroup(tx, tenantID, directoryID, g)
+			if err != nil {
+				return err
+			}
+			if id != uuid.Nil {
+				want[id] = true
+			}
+		}
+
+		var current []uuid.UUID
+		if err := tx.Model(&model.GroupMember{}).
+			Joins("JOIN groups ON groups.id = group_members.group_id").
+			Where("group_members.user_id = ? AND groups.ldap_directory_id = ?", userID, directoryID).
+			Pluck("group_members.group_id", &current).Error; err != nil {
+			return err
+		}
+
+		var changed, removed []uuid.UUID
+		for _, id := range current {
+			if want[id] {
+				delete(want, id)
+			} else {
+				removed = append(removed, id)
+			}
+		}
+		if len(removed) > 0 {
+			if err := tx.Where("user_id = ? AND group_id IN ?", userID, removed).Delete(&model.GroupMember{}).Error; err != nil {
+				return err
+			}
+			changed = append(changed, removed...)
+		}
+		for id := range want {
+			if err := tx.Clauses(clause.OnConflict{DoNothing: true}).
+				Create(&model.GroupMember{GroupID: id, UserID: userID}).Error; err != nil {
+				return err
+			}
+			changed = append(changed, id)
+		}
+		if len(changed) == 0 {
+			return nil
+		}
+		// メンバーの変更としてグループの ETag も変える
+		return tx.Model(&model.Group{}).Where("id IN ?", changed).Update("updated_at", time.Now()).Error
+	})
+}
+
+// findOrCreateLDAPGroup はディレクトリのグループを検索し、なければ作成して ID を返す。
+// 表示名が DN でも重複して作成できない場合は uuid.Nil を返す
+func findOrCreateLDAPGroup(tx *gorm.DB, tenantID, directoryID uuid.UUID, g model.LDAPGroup) (uuid.UUID, error) {
+	find := func() (uuid.UUID, error) {
+		var group model.Group
+		result := tx.Where("ldap_directory_id = ? AND external_id = ?", directoryID, g.DN).First(&group)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return uuid.Nil, nil
+			}
+			return uuid.Nil, result.Error
+		}
+		return group.ID, nil
+	}
+	if id, err := find(); err != nil || id != uuid.Nil {
+		return id, err
+	}
+
+	for _, name := range []string{g.Name, g.DN} {
+		dn := g.DN
+		group := &model.Group{TenantID: tenantID, DisplayName: name, ExternalID: &dn, LDAPDirectoryID: &directoryID}
+		// 並行するログインで同じグループを作成した場合や表示名が重複する場合は作成しない
+		result := tx.Omit(clause.Associations).Clauses(clause.OnConflict{DoNothing: true}).Create(group)
+		if result.Error != nil {
+			return uuid.Nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			return group.ID, nil
+		}
+		if id, err := find(); err != nil || id != uuid.Nil {
+			return id, err
+		}
+	}
+	return uuid.Nil, nil
+}
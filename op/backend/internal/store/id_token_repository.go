@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"errors"
 
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 	"gorm.io/gorm"
@@ -18,3 +19,19 @@ func NewIDTokenRepository(db *gorm.DB) *IDTokenRepository {
 func (r *IDTokenRepository) Create(ctx context.Context, token *model.IDToken) error {
 	return r.db.WithContext(ctx).Create(token).Error
 }
+
+// FindByJTI は jti で ID トークンの発行記録を検索する。セッションを含めて返す。
+func (r *IDTokenRepository) FindByJTI(ctx context.Context, jti string) (*model.IDToken, error) {
+	var token model.IDToken
+	result := r.db.WithContext(ctx).
+		Preload("Session").
+		Where("jti = ?", jti).
+		First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
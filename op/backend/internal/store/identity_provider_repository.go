@@ -0,0 +1,90 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// IdentityProviderRepository はテナントが連携する上流の OIDC Provider を永続化する。
+type IdentityProviderRepository struct {
+	db *gorm.DB
+}
+
+// NewIdentityProviderRepository は IdentityProviderRepository を生成する。
+func NewIdentityProviderRepository(db *gorm.DB) *IdentityProviderRepository {
+	return &IdentityProviderRepository{db: db}
+}
+
+// ListByTenantID はテナントに属する IdP をコードの昇順で返す。
+func (r *IdentityProviderRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.IdentityProvider, error) {
+	var providers []model.IdentityProvider
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("code ASC").
+		Find(&providers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return providers, nil
+}
+
+// ListActiveByTenantID はテナントに属する有効な IdP をコードの昇順で返す。
+func (r *IdentityProviderRepository) ListActiveByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.IdentityProvider, error) {
+	var providers []model.IdentityProvider
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND status = ?", tenantID, "active").
+		Order("code ASC").
+		Find(&providers)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return providers, nil
+}
+
+// Create は新しい IdP を永続化する。
+func (r *IdentityProviderRepository) Create(ctx context.Context, provider *model.IdentityProvider) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(provider).Error
+}
+
+// FindByID は UUID で IdP を検索する。見つからない場合は (nil, nil) を返す。
+func (r *IdentityProviderRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.IdentityProvider, error) {
+	var provider model.IdentityProvider
+	result := r.db.WithContext(ctx).First(&provider, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &provider, nil
+}
+
+// FindByCode はテナント内でコードが一致する IdP を検索する。見つからない場合は (nil, nil) を返す。
+func (r *IdentityProviderRepository) FindByCode(ctx context.Context, tenantID uuid.UUID, code string) (*model.IdentityProvider, error) {
+	var provider model.IdentityProvider
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND code = ?", tenantID, code).
+		First(&provider)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &provider, nil
+}
+
+// Update は IdP の変更を保存する。
+func (r *IdentityProviderRepository) Update(ctx context.Context, provider *model.IdentityProvider) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(provider).Error
+}
+
+// Delete は IdP を削除する。ユーザーとの紐付けも削除される。
+func (r *IdentityProviderRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.IdentityProvider{}, "id = ?", id).Error
+}
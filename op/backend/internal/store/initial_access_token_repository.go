@@ -0,0 +1,73 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// InitialAccessTokenRepository は動的クライアント登録用の初期アクセストークンを永続化する。
+type InitialAccessTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewInitialAccessTokenRepository は InitialAccessTokenRepository を生成する。
+func NewInitialAccessTokenRepository(db *gorm.DB) *InitialAccessTokenRepository {
+	return &InitialAccessTokenRepository{db: db}
+}
+
+// ListByTenantID はテナントに属する初期アクセストークンを作成日時の降順で返す。
+func (r *InitialAccessTokenRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.InitialAccessToken, error) {
+	var tokens []model.InitialAccessToken
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// Create は新しい初期アクセストークンを永続化する。
+func (r *InitialAccessTokenRepository) Create(ctx context.Context, token *model.InitialAccessToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// FindValidByHash はテナントの有効期限内の初期アクセストークンをハッシュ値で検索する。見つからない場合は (nil, nil) を返す。
+func (r *InitialAccessTokenRepository) FindValidByHash(ctx context.Context, tenantID uuid.UUID, tokenHash string) (*model.InitialAccessToken, error) {
+	var token model.InitialAccessToken
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND token_hash = ?", tenantID, tokenHash).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// FindByID は UUID で初期アクセストークンを検索する。見つからない場合は (nil, nil) を返す。
+func (r *InitialAccessTokenRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.InitialAccessToken, error) {
+	var token model.InitialAccessToken
+	result := r.db.WithContext(ctx).First(&token, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// Delete は初期アクセストークンを削除する。
+func (r *InitialAccessTokenRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.InitialAccessToken{}, "id = ?", id).Error
+}
@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// LDAPDirectoryRepository はテナントのパスワード認証を委譲する LDAP ディレクトリを永続化する。
+type LDAPDirectoryRepository struct {
+	db *gorm.DB
+}
+
+// NewLDAPDirectoryRepository は LDAPDirectoryRepository を生成する。
+func NewLDAPDirectoryRepository(db *gorm.DB) *LDAPDirectoryRepository {
+	return &LDAPDirectoryRepository{db: db}
+}
+
+// FindByTenantID はテナントのディレクトリを検索する。見つからない場合は (nil, nil) を返す。
+func (r *LDAPDirectoryRepository) FindByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.LDAPDirectory, error) {
+	return r.find(ctx, r.db.Where("tenant_id = ?", tenantID))
+}
+
+// FindActiveByTenantID はテナントの有効なディレクトリを検索する。見つからない場合は (nil, nil) を返す。
+func (r *LDAPDirectoryRepository) FindActiveByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.LDAPDirectory, error) {
+	return r.find(ctx, r.db.Where("tenant_id = ? AND status = ?", tenantID, "active"))
+}
+
+func (r *LDAPDirectoryRepository) find(ctx context.Context, query *gorm.DB) (*model.LDAPDirectory, error) {
+	var directory model.LDAPDirectory
+	result := query.WithContext(ctx).First(&directory)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &directory, nil
+}
+
+// Create は新しいディレクトリを永続化する。
+func (r *LDAPDirectoryRepository) Create(ctx context.Context, directory *model.LDAPDirectory) error {
+	return r.db.WithContext(ctx).Create(directory).Error
+}
+
+// Update はディレクトリの変更を保存する。
+func (r *LDAPDirectoryRepository) Update(ctx context.Context, directory *model.LDAPDirectory) error {
+	return r.db.WithContext(ctx).Save(directory).Error
+}
+
+// Delete はディレクトリを削除する。紐付けた認証情報も削除し、同期したグループは通常のグループとして残す。
+// 紐付けが削除されたユーザーは、パスワードが設定されていればローカルのパスワードでログインできる
+func (r *LDAPDirectoryRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("id IN (?)", tx.Model(&model.LDAPCredential{}).
+			Select("credential_id").
+			Where("ldap_directory_id = ?", id)).
+			Delete(&model.Credential{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(&model.LDAPDirectory{}, "id = ?", id).Error
+	})
+}
@@ -0,0 +1,43 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// PairwiseSubjectRepository は pairwise sub とユーザーの対応を永続化する。
+type PairwiseSubjectRepository struct {
+	db *gorm.DB
+}
+
+// NewPairwiseSubjectRepository は PairwiseSubjectRepository を生成する。
+func NewPairwiseSubjectRepository(db *gorm.DB) *PairwiseSubjectRepository {
+	return &PairwiseSubjectRepository{db: db}
+}
+
+// Save は対応を保存する。既に保存済みの場合は何もしない。
+func (r *PairwiseSubjectRepository) Save(ctx context.Context, subject *model.PairwiseSubject) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{DoNothing: true}).
+		Create(subject).Error
+}
+
+// FindBySubject はテナント内で sub が一致する対応を検索する。見つからない場合は (nil, nil) を返す。
+func (r *PairwiseSubjectRepository) FindBySubject(ctx context.Context, tenantID uuid.UUID, subject string) (*model.PairwiseSubject, error) {
+	var ps model.PairwiseSubject
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND subject = ?", tenantID, subject).
+		First(&ps)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &ps, nil
+}
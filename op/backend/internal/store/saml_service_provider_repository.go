@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// SAMLServiceProviderRepository はテナントの SAML IdP に登録された SP を永続化する。
+type SAMLServiceProviderRepository struct {
+	db *gorm.DB
+}
+
+// NewSAMLServiceProviderRepository は SAMLServiceProviderRepository を生成する。
+func NewSAMLServiceProviderRepository(db *gorm.DB) *SAMLServiceProviderRepository {
+	return &SAMLServiceProviderRepository{db: db}
+}
+
+// ListByTenantID はテナントに属する SP を作成日時の昇順で返す。
+func (r *SAMLServiceProviderRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.SAMLServiceProvider, error) {
+	var sps []model.SAMLServiceProvider
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at ASC").
+		Find(&sps)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return sps, nil
+}
+
+// Create は新しい SP を永続化する。
+func (r *SAMLServiceProviderRepository) Create(ctx context.Context, sp *model.SAMLServiceProvider) error {
+	return r.db.WithContext(ctx).Create(sp).Error
+}
+
+// FindByID は UUID で SP を検索する。見つからない場合は (nil, nil) を返す。
+func (r *SAMLServiceProviderRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.SAMLServiceProvider, error) {
+	var sp model.SAMLServiceProvider
+	result := r.db.WithContext(ctx).First(&sp, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &sp, nil
+}
+
+// FindByEntityID はテナント内で entityID が一致する SP を検索する。見つからない場合は (nil, nil) を返す。
+func (r *SAMLServiceProviderRepository) FindByEntityID(ctx context.Context, tenantID uuid.UUID, entityID string) (*model.SAMLServiceProvider, error) {
+	var sp model.SAMLServiceProvider
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND entity_id = ?", tenantID, entityID).
+		First(&sp)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &sp, nil
+}
+
+// Update は SP の変更を保存する。
+func (r *SAMLServiceProviderRepository) Update(ctx context.Context, sp *model.SAMLServiceProvider) error {
+	return r.db.WithContext(ctx).Save(sp).Error
+}
+
+// Delete は SP を削除する。SP のセッション (saml_sessions) も削除される。
+func (r *SAMLServiceProviderRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.SAMLServiceProvider{}, "id = ?", id).Error
+}
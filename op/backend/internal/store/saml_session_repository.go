@@ -0,0 +1,128 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SAMLSessionRepository は OP のセッションでアサーションを発行した SP を永続化する。
+type SAMLSessionRepository struct {
+	db *gorm.DB
+}
+
+// NewSAMLSessionRepository は SAMLSessionRepository を生成する。
+func NewSAMLSessionRepository(db *gorm.DB) *SAMLSessionRepository {
+	return &SAMLSessionRepository{db: db}
+}
+
+// FindBySessionAndServiceProvider は OP のセッションと SP の組で検索する。見つからない場合は (nil, nil) を返す。
+func (r *SAMLSessionRepository) FindBySessionAndServiceProvider(ctx context.Context, sessionID, serviceProviderID uuid.UUID) (*model.SAMLSession, error) {
+	var s model.SAMLSession
+	result := r.db.WithContext(ctx).
+		Where("session_id = ? AND service_provider_id = ?", sessionID, serviceProviderID).
+		First(&s)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &s, nil
+}
+
+// Create は新しい SAML セッションを永続化する。
+func (r *SAMLSessionRepository) Create(ctx context.Context, s *model.SAMLSession) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Create(s).Error
+}
+
+// FindActiveBySessionIndex は SP の SessionIndex でログアウトしていない SAML セッションを検索する。
+// 見つからない場合は (nil, nil) を返す。
+func (r *SAMLSessionRepository) FindActiveBySessionIndex(ctx context.Context, serviceProviderID uuid.UUID, sessionIndex string) (*model.SAMLSession, error) {
+	var s model.SAMLSession
+	result := r.db.WithContext(ctx).
+		Where("service_provider_id = ? AND session_index = ? AND logged_out_at IS NULL", serviceProviderID, sessionIndex).
+		First(&s)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &s, nil
+}
+
+// FindByID は ID で検索する。SP もプリロードする。見つからない場合は (nil, nil) を返す。
+func (r *SAMLSessionRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.SAMLSession, error) {
+	var s model.SAMLSession
+	result := r.db.WithContext(ctx).
+		Preload("ServiceProvider").
+		First(&s, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &s, nil
+}
+
+// StartLogout は SP から LogoutRequest を受けた SAML セッションをログアウト済みにし、LogoutResponse に使う値を記録する。
+// 並行リクエストで既にログアウト済みの場合は更新せず false を返す。
+func (r *SAMLSessionRepository) StartLogout(ctx context.Context, id uuid.UUID, requestID, relayState string) (bool, error) {
+	updates := map[string]interface{}{
+		"logged_out_at":     time.Now(),
+		"logout_request_id": requestID,
+	}
+	if relayState != "" {
+		updates["logout_relay_state"] = relayState
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.SAMLSession{}).
+		Where("id = ? AND logged_out_at IS NULL", id).
+		Updates(updates)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// NextForLogout は OP のセッションで SLO に参加する SP のうち、まだログアウトを要求していない SAML セッションを
+// 1 件ログアウト済みにして返す。SP もプリロードする。残っていない場合は (nil, nil) を返す。
+func (r *SAMLSessionRepository) NextForLogout(ctx context.Context, sessionID uuid.UUID) (*model.SAMLSession, error) {
+	for {
+		var s model.SAMLSession
+		result := r.db.WithContext(ctx).
+			Preload("ServiceProvider").
+			Joins("JOIN saml_service_providers ON saml_service_providers.id = saml_sessions.service_provider_id").
+			Where("saml_sessions.session_id = ? AND saml_sessions.logged_out_at IS NULL", sessionID).
+			Where("saml_service_providers.slo_url IS NOT NULL AND saml_service_providers.status = ?", "active").
+			Order("saml_sessions.created_at ASC").
+			First(&s)
+		if result.Error != nil {
+			if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+				return nil, nil
+			}
+			return nil, result.Error
+		}
+
+		// 並行リクエストで先に選ばれた場合は次を探す
+		now := time.Now()
+		result = r.db.WithContext(ctx).
+			Model(&model.SAMLSession{}).
+			Where("id = ? AND logged_out_at IS NULL", s.ID).
+			Update("logged_out_at", now)
+		if result.Error != nil {
+			return nil, result.Error
+		}
+		if result.RowsAffected == 1 {
+			s.LoggedOutAt = &now
+			return &s, nil
+		}
+	}
+}
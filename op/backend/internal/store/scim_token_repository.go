@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// SCIMTokenRepository は SCIM のプロビジョニング API 用の Bearer トークンを永続化する。
+type SCIMTokenRepository struct {
+	db *gorm.DB
+}
+
+// NewSCIMTokenRepository は SCIMTokenRepository を生成する。
+func NewSCIMTokenRepository(db *gorm.DB) *SCIMTokenRepository {
+	return &SCIMTokenRepository{db: db}
+}
+
+// ListByTenantID はテナントに属するトークンを作成日時の降順で返す。
+func (r *SCIMTokenRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.SCIMToken, error) {
+	var tokens []model.SCIMToken
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("created_at DESC").
+		Find(&tokens)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return tokens, nil
+}
+
+// Create は新しいトークンを永続化する。
+func (r *SCIMTokenRepository) Create(ctx context.Context, token *model.SCIMToken) error {
+	return r.db.WithContext(ctx).Create(token).Error
+}
+
+// FindValidByHash はテナントの有効期限内のトークンをハッシュ値で検索する。見つからない場合は (nil, nil) を返す。
+func (r *SCIMTokenRepository) FindValidByHash(ctx context.Context, tenantID uuid.UUID, tokenHash string) (*model.SCIMToken, error) {
+	var token model.SCIMToken
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND token_hash = ?", tenantID, tokenHash).
+		Where("expires_at IS NULL OR expires_at > ?", time.Now()).
+		First(&token)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// UpdateLastUsedAt は最後に認証に使われた日時を記録する。
+func (r *SCIMTokenRepository) UpdateLastUsedAt(ctx context.Context, id uuid.UUID, t time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.SCIMToken{}).
+		Where("id = ?", id).
+		Update("last_used_at", t).Error
+}
+
+// FindByID は UUID でトークンを検索する。見つからない場合は (nil, nil) を返す。
+func (r *SCIMTokenRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.SCIMToken, error) {
+	var token model.SCIMToken
+	result := r.db.WithContext(ctx).First(&token, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &token, nil
+}
+
+// Delete はトークンを削除する。
+func (r *SCIMTokenRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.SCIMToken{}, "id = ?", id).Error
+}
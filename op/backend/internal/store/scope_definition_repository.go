@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// ScopeDefinitionRepository はテナントのカスタムスコープ定義を永続化する。
+type ScopeDefinitionRepository struct {
+	db *gorm.DB
+}
+
+// NewScopeDefinitionRepository は ScopeDefinitionRepository を生成する。
+func NewScopeDefinitionRepository(db *gorm.DB) *ScopeDefinitionRepository {
+	return &ScopeDefinitionRepository{db: db}
+}
+
+// ListByTenantID はテナントに属するスコープ定義を名前の昇順で返す。
+func (r *ScopeDefinitionRepository) ListByTenantID(ctx context.Context, tenantID uuid.UUID) ([]model.ScopeDefinition, error) {
+	var defs []model.ScopeDefinition
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ?", tenantID).
+		Order("name ASC").
+		Find(&defs)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return defs, nil
+}
+
+// Create は新しいスコープ定義を永続化する。
+func (r *ScopeDefinitionRepository) Create(ctx context.Context, def *model.ScopeDefinition) error {
+	return r.db.WithContext(ctx).Create(def).Error
+}
+
+// FindByID は UUID でスコープ定義を検索する。見つからない場合は (nil, nil) を返す。
+func (r *ScopeDefinitionRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.ScopeDefinition, error) {
+	var def model.ScopeDefinition
+	result := r.db.WithContext(ctx).First(&def, "id = ?", id)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &def, nil
+}
+
+// FindByName はテナント内で名前が一致するスコープ定義を検索する。見つからない場合は (nil, nil) を返す。
+func (r *ScopeDefinitionRepository) FindByName(ctx context.Context, tenantID uuid.UUID, name string) (*model.ScopeDefinition, error) {
+	var def model.ScopeDefinition
+	result := r.db.WithContext(ctx).
+		Where("tenant_id = ? AND name = ?", tenantID, name).
+		First(&def)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &def, nil
+}
+
+// Update はスコープ定義の変更を保存する。
+func (r *ScopeDefinitionRepository) Update(ctx context.Context, def *model.ScopeDefinition) error {
+	return r.db.WithContext(ctx).Save(def).Error
+}
+
+// Delete はスコープ定義を削除する。
+func (r *ScopeDefinitionRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.ScopeDefinition{}, "id = ?", id).Error
+}
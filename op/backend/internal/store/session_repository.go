@@ -22,9 +22,13 @@ func (r *SessionRepository) Create(ctx context.Context, session *model.Session)
 	return r.db.WithContext(ctx).Create(session).Error
 }
 
+// FindByID は ID で検索する。メールアドレスの確認状況とテナントの方針を参照するためユーザーとテナントもプリロードする。
 func (r *SessionRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.Session, error) {
 	var session model.Session
-	result := r.db.WithContext(ctx).First(&session, "id = ?", id)
+	result := r.db.WithContext(ctx).
+		Preload("User").
+		Preload("Tenant").
+		First(&session, "id = ?", id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -0,0 +1,102 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// SMSOTPCodeRepository は SMS で送信したワンタイムコードを永続化する。
+type SMSOTPCodeRepository struct {
+	db *gorm.DB
+}
+
+// NewSMSOTPCodeRepository は SMSOTPCodeRepository を生成する。
+func NewSMSOTPCodeRepository(db *gorm.DB) *SMSOTPCodeRepository {
+	return &SMSOTPCodeRepository{db: db}
+}
+
+// Create は新しいコードを永続化する。
+func (r *SMSOTPCodeRepository) Create(ctx context.Context, code *model.SMSOTPCode) error {
+	return r.db.WithContext(ctx).Create(code).Error
+}
+
+// CountByUserIDSince は since 以降にユーザーに送信したコードの数を返す (送信回数の制限に使う)。
+func (r *SMSOTPCodeRepository) CountByUserIDSince(ctx context.Context, userID uuid.UUID, since time.Time) (int64, error) {
+	var count int64
+	err := r.db.WithContext(ctx).
+		Model(&model.SMSOTPCode{}).
+		Where("user_id = ? AND created_at >= ?", userID, since).
+		Count(&count).Error
+	return count, err
+}
+
+// FindActiveByUserID はユーザーに最後に送信した用途のコードのうち、有効期限内で未使用のものを返す。見つからない場合は (nil, nil) を返す。
+func (r *SMSOTPCodeRepository) FindActiveByUserID(ctx context.Context, userID uuid.UUID, purpose string) (*model.SMSOTPCode, error) {
+	var code model.SMSOTPCode
+	result := r.db.WithContext(ctx).
+		Where("user_id = ? AND purpose = ? AND consumed_at IS NULL AND expires_at > ?", userID, purpose, time.Now()).
+		Order("created_at DESC").
+		First(&code)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &code, nil
+}
+
+// FindActiveByChallengeHash は mfa_token のハッシュ値で有効期限内の未使用のコードを検索する。ユーザーとテナントもプリロードする。
+// 見つからない場合は (nil, nil) を返す。
+func (r *SMSOTPCodeRepository) FindActiveByChallengeHash(ctx context.Context, challengeHash string) (*model.SMSOTPCode, error) {
+	var code model.SMSOTPCode
+	result := r.db.WithContext(ctx).
+		Preload("User.Tenant").
+		Where("challenge_hash = ? AND consumed_at IS NULL AND expires_at > ?", challengeHash, time.Now()).
+		First(&code)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &code, nil
+}
+
+// InvalidateActive はユーザーに送信した用途のコードのうち、未使用のものをすべて使用済みにする。
+func (r *SMSOTPCodeRepository) InvalidateActive(ctx context.Context, userID uuid.UUID, purpose string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.SMSOTPCode{}).
+		Where("user_id = ? AND purpose = ? AND consumed_at IS NULL", userID, purpose).
+		Update("consumed_at", time.Now()).Error
+}
+
+// RecordAttempt はコードの照合前に入力回数を 1 増やす。上限に達している・使用済みの場合は増やさず false を返す。
+// 照合前に数えることで、並行リクエストでも上限を超えて照合しない。
+func (r *SMSOTPCodeRepository) RecordAttempt(ctx context.Context, id uuid.UUID, maxAttempts int) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.SMSOTPCode{}).
+		Where("id = ? AND attempts < ? AND consumed_at IS NULL", id, maxAttempts).
+		Update("attempts", gorm.Expr("attempts + 1"))
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// Consume はコードを使用済みにする。並行リクエストで既に使用された場合は false を返す。
+func (r *SMSOTPCodeRepository) Consume(ctx context.Context, id uuid.UUID) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.SMSOTPCode{}).
+		Where("id = ? AND consumed_at IS NULL", id).
+		Update("consumed_at", time.Now())
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
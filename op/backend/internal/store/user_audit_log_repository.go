@@ -0,0 +1,44 @@
+package store
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// UserAuditLogRepository はユーザーの監査ログを永続化する。
+type UserAuditLogRepository struct {
+	db *gorm.DB
+}
+
+// NewUserAuditLogRepository は UserAuditLogRepository を生成する。
+func NewUserAuditLogRepository(db *gorm.DB) *UserAuditLogRepository {
+	return &UserAuditLogRepository{db: db}
+}
+
+// Create は監査ログを記録する。
+func (r *UserAuditLogRepository) Create(ctx context.Context, entry *model.UserAuditLog) error {
+	return r.db.WithContext(ctx).Create(entry).Error
+}
+
+// ListByUserID はユーザーの監査ログを新しい順に返す。
+func (r *UserAuditLogRepository) ListByUserID(ctx context.Context, userID uuid.UUID, limit, offset int) ([]model.UserAuditLog, int64, error) {
+	var total int64
+	if err := r.db.WithContext(ctx).Model(&model.UserAuditLog{}).Where("user_id = ?", userID).Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var entries []model.UserAuditLog
+	result := r.db.WithContext(ctx).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Limit(limit).
+		Offset(offset).
+		Find(&entries)
+	if result.Error != nil {
+		return nil, 0, result.Error
+	}
+	return entries, total, nil
+}
@@ -0,0 +1,46 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// UserConsentRepository はユーザーのスコープへの同意を永続化する。
+type UserConsentRepository struct {
+	db *gorm.DB
+}
+
+// NewUserConsentRepository は UserConsentRepository を生成する。
+func NewUserConsentRepository(db *gorm.DB) *UserConsentRepository {
+	return &UserConsentRepository{db: db}
+}
+
+// FindByUserAndClient はユーザーがクライアントに与えた同意を検索する。見つからない場合は (nil, nil) を返す。
+func (r *UserConsentRepository) FindByUserAndClient(ctx context.Context, userID, clientID uuid.UUID) (*model.UserConsent, error) {
+	var consent model.UserConsent
+	result := r.db.WithContext(ctx).
+		Where("user_id = ? AND client_id = ?", userID, clientID).
+		First(&consent)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &consent, nil
+}
+
+// Save は同意したスコープを記録する。既存の同意は置き換える。
+func (r *UserConsentRepository) Save(ctx context.Context, consent *model.UserConsent) error {
+	return r.db.WithContext(ctx).
+		Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "user_id"}, {Name: "client_id"}},
+			DoUpdates: clause.AssignmentColumns([]string{"scopes", "updated_at"}),
+		}).
+		Create(consent).Error
+}
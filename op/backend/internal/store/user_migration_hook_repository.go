@@ -0,0 +1,57 @@
+package store
+
+import (
+	"context"
+	"errors"
+
+	"github.com/google/uuid"
+	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
+	"gorm.io/gorm"
+)
+
+// UserMigrationHookRepository は移行元でパスワードを検証する Webhook を永続化する。
+type UserMigrationHookRepository struct {
+	db *gorm.DB
+}
+
+// NewUserMigrationHookRepository は UserMigrationHookRepository を生成する。
+func NewUserMigrationHookRepository(db *gorm.DB) *UserMigrationHookRepository {
+	return &UserMigrationHookRepository{db: db}
+}
+
+// FindByTenantID はテナントの Webhook を検索する。見つからない場合は (nil, nil) を返す。
+func (r *UserMigrationHookRepository) FindByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.UserMigrationHook, error) {
+	return r.find(ctx, r.db.Where("tenant_id = ?", tenantID))
+}
+
+// FindActiveByTenantID はテナントの有効な Webhook を検索する。見つからない場合は (nil, nil) を返す。
+func (r *UserMigrationHookRepository) FindActiveByTenantID(ctx context.Context, tenantID uuid.UUID) (*model.UserMigrationHook, error) {
+	return r.find(ctx, r.db.Where("tenant_id = ? AND status = ?", tenantID, "active"))
+}
+
+func (r *UserMigrationHookRepository) find(ctx context.Context, query *gorm.DB) (*model.UserMigrationHook, error) {
+	var hook model.UserMigrationHook
+	result := query.WithContext(ctx).First(&hook)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &hook, nil
+}
+
+// Create は新しい Webhook を永続化する。
+func (r *UserMigrationHookRepository) Create(ctx context.Context, hook *model.UserMigrationHook) error {
+	return r.db.WithContext(ctx).Create(hook).Error
+}
+
+// Update は Webhook の変更を保存する。
+func (r *UserMigrationHookRepository) Update(ctx context.Context, hook *model.UserMigrationHook) error {
+	return r.db.WithContext(ctx).Save(hook).Error
+}
+
+// Delete は Webhook を削除する。移行済みのユーザーはローカルのパスワードでログインできるため変更しない
+func (r *UserMigrationHookRepository) Delete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Delete(&model.UserMigrationHook{}, "id = ?", id).Error
+}
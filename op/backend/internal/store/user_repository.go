@@ -8,6 +8,7 @@ import (
 	"github.com/google/uuid"
 	"github.com/isurugi-k/oidc-demo/op/backend/internal/model"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type UserRepository struct {
@@ -33,9 +34,12 @@ func (r *UserRepository) FindByTenantAndLoginID(ctx context.Context, tenantID uu
 	return &user, nil
 }
 
+// FindByID は ID で検索する。カスタム属性・カスタムスコープの定義を参照するためテナントもプリロードする。
 func (r *UserRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.User, error) {
 	var user model.User
-	result := r.db.WithContext(ctx).First(&user, "id = ?", id)
+	result := r.db.WithContext(ctx).
+		Preload("Tenant.ScopeDefinitions").
+		First(&user, "id = ?", id)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -45,11 +49,36 @@ func (r *UserRepository) FindByID(ctx context.Context, id uuid.UUID) (*model.Use
 	return &user, nil
 }
 
-func (r *UserRepository) FindByIDWithCredentials(ctx context.Context, id uuid.UUID) (*model.User, error) {
+func (r *UserRepository) UpdateLastLoginAt(ctx context.Context, id uuid.UUID, t time.Time) error {
+	return r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ?", id).
+		Update("last_login_at", t).Error
+}
+
+// UpdateProfile はユーザーのプロフィール (標準クレームとカスタム属性) を保存する。関連は更新しない。
+func (r *UserRepository) UpdateProfile(ctx context.Context, user *model.User) error {
+	return r.db.WithContext(ctx).Omit(clause.Associations).Save(user).Error
+}
+
+// MarkEmailVerified はメールアドレスを確認済みにする。確認後にメールアドレスが変更されていた場合は更新せず false を返す。
+func (r *UserRepository) MarkEmailVerified(ctx context.Context, id uuid.UUID, email string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND email = ?", id, email).
+		Update("email_verified", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// FindByTenantAndEmail はテナント内でメールアドレスが一致するユーザーを大文字小文字を区別せず検索する。見つからない場合は (nil, nil) を返す。
+func (r *UserRepository) FindByTenantAndEmail(ctx context.Context, tenantID uuid.UUID, email string) (*model.User, error) {
 	var user model.User
 	result := r.db.WithContext(ctx).
-		Preload("Credentials.PasswordCredential").
-		First(&user, "id = ?", id)
+		Where("tenant_id = ? AND LOWER(email) = LOWER(?)", tenantID, email).
+		First(&user)
 	if result.Error != nil {
 		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
 			return nil, nil
@@ -59,9 +88,294 @@ func (r *UserRepository) FindByIDWithCredentials(ctx context.Context, id uuid.UU
 	return &user, nil
 }
 
-func (r *UserRepository) UpdateLastLoginAt(ctx context.Context, id uuid.UUID, t time.Time) error {
+// ChangeEmail はメールアドレスを from から to に変更する。RP が変更を検知できるよう updated_at も更新する。
+// 並行して変更され現在の値が from でない場合は更新せず false を返す。
+func (r *UserRepository) ChangeEmail(ctx context.Context, id uuid.UUID, from, to string, verified bool) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND email = ?", id, from).
+		Updates(map[string]interface{}{
+			"email":          to,
+			"email_verified": verified,
+			"updated_at":     time.Now(),
+		})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// MarkPhoneNumberVerified は電話番号を確認済みにする。確認中に電話番号が変更されていた場合は更新せず false を返す。
+func (r *UserRepository) MarkPhoneNumberVerified(ctx context.Context, id uuid.UUID, phoneNumber string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("id = ? AND phone_number = ?", id, phoneNumber).
+		Update("phone_number_verified", true)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// UpdateSMSMFAEnabled は SMS による2要素認証の有効・無効を切り替える。
+func (r *UserRepository) UpdateSMSMFAEnabled(ctx context.Context, id uuid.UUID, enabled bool) error {
 	return r.db.WithContext(ctx).
 		Model(&model.User{}).
 		Where("id = ?", id).
-		Update("last_login_at", t).Error
+		Update("sms_mfa_enabled", enabled).Error
+}
+
+// FindByExternalIdentity は上流の OIDC Provider の sub に紐付くユーザーを検索する。テナントもプリロードする。
+// 見つからない場合は (nil, nil) を返す。
+func (r *UserRepository) FindByExternalIdentity(ctx context.Context, identityProviderID uuid.UUID, subject string) (*model.User, error) {
+	var user model.User
+	result := r.db.WithContext(ctx).
+		Preload("Tenant").
+		Where("id = (?)", r.db.
+			Table("credentials").
+			Select("credentials.user_id").
+			Joins("JOIN external_idp_credentials ON external_idp_credentials.credential_id = credentials.id").
+			Where("external_idp_credentials.identity_provider_id = ? AND external_idp_credentials.provider_subject = ?", identityProviderID, subject)).
+		First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// CreateWithExternalIdentity は上流の OIDC Provider の sub に紐付けたユーザーを作成する (JIT プロビジョニング)。
+func (r *UserRepository) CreateWithExternalIdentity(ctx context.Context, user *model.User, identityProviderID uuid.UUID, subject string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(user).Error; err != nil {
+			return err
+		}
+		return createExternalIdentity(tx, user.ID, identityProviderID, subject)
+	})
+}
+
+// LinkExternalIdentity は既存のユーザーを上流の OIDC Provider の sub に紐付ける。
+func (r *UserRepository) LinkExternalIdentity(ctx context.Context, userID, identityProviderID uuid.UUID, subject string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return createExternalIdentity(tx, userID, identityProviderID, subject)
+	})
+}
+
+func createExternalIdentity(tx *gorm.DB, userID, identityProviderID uuid.UUID, subject string) error {
+	credential := &model.Credential{
+		UserID: userID,
+		Type:   model.CredentialTypeOIDCProvider,
+	}
+	if err := tx.Omit(clause.Associations).Create(credential).Error; err != nil {
+		return err
+	}
+	return tx.Create(&model.ExternalIdPCredential{
+		CredentialID:       credential.ID,
+		IdentityProviderID: identityProviderID,
+		ProviderSubject:    subject,
+	}).Error
+}
+
+// FindByLDAPIdentity は LDAP ディレクトリのエントリ (id_attribute の値) に紐付くユーザーを検索する。
+// 見つからない場合は (nil, nil) を返す。
+func (r *UserRepository) FindByLDAPIdentity(ctx context.Context, directoryID uuid.UUID, subject string) (*model.User, error) {
+	var user model.User
+	result := r.db.WithContext(ctx).
+		Where("id = (?)", r.db.
+			Table("credentials").
+			Select("credentials.user_id").
+			Joins("JOIN ldap_credentials ON ldap_credentials.credential_id = credentials.id").
+			Where("ldap_credentials.ldap_directory_id = ? AND ldap_credentials.subject = ?", directoryID, subject)).
+		First(&user)
+	if result.Error != nil {
+		if errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return nil, nil
+		}
+		return nil, result.Error
+	}
+	return &user, nil
+}
+
+// CreateWithLDAPIdentity は LDAP ディレクトリのエントリに紐付けたユーザーを作成する (JIT プロビジョニング)。
+func (r *UserRepository) CreateWithLDAPIdentity(ctx context.Context, user *model.User, directoryID uuid.UUID, subject, dn string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(user).Error; err != nil {
+			return err
+		}
+		return createLDAPIdentity(tx, user.ID, directoryID, subject, dn)
+	})
+}
+
+// LinkLDAPIdentity は既存のユーザーを LDAP ディレクトリのエントリに紐付ける。
+func (r *UserRepository) LinkLDAPIdentity(ctx context.Context, userID, directoryID uuid.UUID, subject, dn string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return createLDAPIdentity(tx, userID, directoryID, subject, dn)
+	})
+}
+
+// UpdateLDAPIdentityDN は紐付けたエントリの DN を更新する (ディレクトリ内でエントリが移動・改名された場合)。
+func (r *UserRepository) UpdateLDAPIdentityDN(ctx context.Context, directoryID uuid.UUID, subject, dn string) error {
+	return r.db.WithContext(ctx).
+		Model(&model.LDAPCredential{}).
+		Where("ldap_directory_id = ? AND subject = ? AND dn <> ?", directoryID, subject, dn).
+		Updates(map[string]interface{}{"dn": dn, "updated_at": time.Now()}).Error
+}
+
+func createLDAPIdentity(tx *gorm.DB, userID, directoryID uuid.UUID, subject, dn string) error {
+	credential := &model.Credential{
+		UserID: userID,
+		Type:   model.CredentialTypeLDAP,
+	}
+	if err := tx.Omit(clause.Associations).Create(credential).Error; err != nil {
+		return err
+	}
+	return tx.Create(&model.LDAPCredential{
+		CredentialID:    credential.ID,
+		LDAPDirectoryID: directoryID,
+		Subject:         subject,
+		DN:              dn,
+	}).Error
+}
+
+// SearchActive はテナントの削除されていないユーザーを条件で検索し、作成日時の昇順で offset から limit 件と総件数を返す。
+// where は呼び出し元で組み立てたプレースホルダ付きの条件式 (空の場合は条件なし)。
+func (r *UserRepository) SearchActive(ctx context.Context, tenantID uuid.UUID, where string, args []interface{}, offset, limit int) ([]model.User, int64, error) {
+	query := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("users.tenant_id = ? AND users.status <> ?", tenantID, "deleted")
+	if where != "" {
+		query = query.Where(where, args...)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	var users []model.User
+	if limit > 0 {
+		if err := query.Order("users.created_at ASC, users.id ASC").Offset(offset).Limit(limit).Find(&users).Error; err != nil {
+			return nil, 0, err
+		}
+	}
+	return users, total, nil
+}
+
+// CreateWithPassword はユーザーを作成する。passwordHash が空でない場合はパスワードの認証情報も作成する。
+func (r *UserRepository) CreateWithPassword(ctx context.Context, user *model.User, passwordHash string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Omit(clause.Associations).Create(user).Error; err != nil {
+			return err
+		}
+		if passwordHash == "" {
+			return nil
+		}
+		return setPassword(tx, user.ID, passwordHash, "argon2id")
+	})
+}
+
+// SetPassword はユーザーのパスワードを設定する。パスワードの認証情報がなければ作成する。
+func (r *UserRepository) SetPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return setPassword(tx, userID, passwordHash, "argon2id")
+	})
+}
+
+// SaveImported はインポートしたユーザーを保存する。ID がなければ作成し、あればプロフィールを更新する。
+// passwordHash が空でない場合はパスワードの認証情報を algorithm のハッシュで置き換える。
+func (r *UserRepository) SaveImported(ctx context.Context, user *model.User, passwordHash, algorithm string) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		if user.ID == uuid.Nil {
+			err = tx.Omit(clause.Associations).Create(user).Error
+		} else {
+			err = tx.Omit(clause.Associations).Save(user).Error
+		}
+		if err != nil || passwordHash == "" {
+			return err
+		}
+		return setPassword(tx, user.ID, passwordHash, algorithm)
+	})
+}
+
+// RehashPassword はパスワードのハッシュが from のままの場合に argon2id のハッシュ to で置き換える。
+// 同時にパスワードが変更されていた場合は更新せず false を返す。
+func (r *UserRepository) RehashPassword(ctx context.Context, userID uuid.UUID, from, to string) (bool, error) {
+	result := r.db.WithContext(ctx).
+		Model(&model.PasswordCredential{}).
+		Where("credential_id IN (?) AND password_hash = ?",
+			r.db.Model(&model.Credential{}).Select("id").Where("user_id = ? AND type = ?", userID, "password"), from).
+		Updates(map[string]interface{}{"password_hash": to, "algorithm": "argon2id", "updated_at": time.Now()})
+	if result.Error != nil {
+		return false, result.Error
+	}
+	return result.RowsAffected == 1, nil
+}
+
+// ListForExport はテナントの削除されていないユーザーを ID の昇順で after の次から limit 件返す。
+// パスワードの認証情報もプリロードする。
+func (r *UserRepository) ListForExport(ctx context.Context, tenantID, after uuid.UUID, limit int) ([]model.User, error) {
+	var users []model.User
+	result := r.db.WithContext(ctx).
+		Preload("Credentials.PasswordCredential").
+		Where("tenant_id = ? AND status <> ? AND id > ?", tenantID, "deleted", after).
+		Order("id ASC").
+		Limit(limit).
+		Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return users, nil
+}
+
+func setPassword(tx *gorm.DB, userID uuid.UUID, passwordHash, algorithm string) error {
+	var credential model.Credential
+	result := tx.Where("user_id = ? AND type = ?", userID, "password").First(&credential)
+	if result.Error != nil {
+		if !errors.Is(result.Error, gorm.ErrRecordNotFound) {
+			return result.Error
+		}
+		credential = model.Credential{UserID: userID, Type: "password"}
+		if err := tx.Omit(clause.Associations).Create(&credential).Error; err != nil {
+			return err
+		}
+		return tx.Create(&model.PasswordCredential{CredentialID: credential.ID, PasswordHash: passwordHash, Algorithm: algorithm}).Error
+	}
+	return tx.Model(&model.PasswordCredential{}).
+		Where("credential_id = ?", credential.ID).
+		Updates(map[string]interface{}{"password_hash": passwordHash, "algorithm": algorithm, "updated_at": time.Now()}).Error
+}
+
+// SoftDelete はユーザーの status を "deleted" に設定して論理削除し、グループから外す。
+// 発行済みのトークンの履歴を残すため行は削除しない。
+func (r *UserRepository) SoftDelete(ctx context.Context, id uuid.UUID) error {
+	return r.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&model.User{}).Where("id = ?", id).Update("status", "deleted").Error; err != nil {
+			return err
+		}
+		// メンバーの変更としてグループの ETag も変える
+		if err := tx.Model(&model.Group{}).
+			Where("id IN (?)", tx.Model(&model.GroupMember{}).Select("group_id").Where("user_id = ?", id)).
+			Update("updated_at", time.Now()).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", id).Delete(&model.GroupMember{}).Error
+	})
+}
+
+// FindActiveIDs は ids のうちテナントに属する削除されていないユーザーの ID を返す。
+func (r *UserRepository) FindActiveIDs(ctx context.Context, tenantID uuid.UUID, ids []uuid.UUID) ([]uuid.UUID, error) {
+	var found []uuid.UUID
+	if len(ids) == 0 {
+		return found, nil
+	}
+	result := r.db.WithContext(ctx).
+		Model(&model.User{}).
+		Where("tenant_id = ? AND status <> ? AND id IN ?", tenantID, "deleted", ids).
+		Pluck("id", &found)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return found, nil
 }
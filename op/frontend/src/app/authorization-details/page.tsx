@@ -0,0 +1,147 @@
+"use client";
+
+import { useState, useEffect } from "react";
+import { Alert } from "@/components/ui/alert";
+import type { AuthorizationDetail } from "@/types";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+type ApprovalRequest = {
+  client_name: string;
+  authorization_details: AuthorizationDetail[];
+  expires_at: string;
+};
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "not_found":
+    case "request_not_pending":
+      return "このリクエストは既に処理されたか、有効期限が切れています。アプリケーションからやり直してください";
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインセッションが切れています。アプリケーションからやり直してください";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+export default function AuthorizationDetailsPage() {
+  const [tenantCode, setTenantCode] = useState("");
+  const [approvalId, setApprovalId] = useState("");
+  const [redirectAfterApproval, setRedirectAfterApproval] = useState("");
+  const [request, setRequest] = useState<ApprovalRequest | null>(null);
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    const code = params.get("tenant_code") || "demo";
+    const id = params.get("approval_id") || "";
+    setTenantCode(code);
+    setApprovalId(id);
+    setRedirectAfterApproval(params.get("redirect_after_approval") || "");
+
+    // 示す内容は URL からではなく、OP Backend が検証して保持している値を取得する
+    fetch(
+      `${API_URL}/internal/authorization-details?tenant_code=${encodeURIComponent(code)}&approval_id=${encodeURIComponent(id)}`,
+      { credentials: "include" },
+    )
+      .then(async (res) => {
+        const data = await res.json();
+        if (!res.ok) {
+          throw new Error(data.error);
+        }
+        setRequest(data);
+      })
+      .catch((err) => {
+        setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+      });
+  }, []);
+
+  async function handleDecide(approved: boolean) {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/authorization-details/decide`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify({ tenant_code: tenantCode, approval_id: approvalId, approved }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(data.error);
+      }
+
+      if (redirectAfterApproval) {
+        // 拒否した場合も認可リクエストに戻り、アプリケーションに access_denied を返す
+        // redirect_after_approval は OP Backend の相対パス（例: /demo/authorize?...）
+        window.location.href = `${API_URL}${redirectAfterApproval}`;
+      }
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          権限の承認
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {request && (
+          <>
+            <p className="text-sm text-gray-600 mb-4">
+              <span className="font-medium text-gray-800">{request.client_name}</span>{" "}
+              が次の権限を要求しています。
+            </p>
+            <ul className="space-y-2 mb-6 text-sm text-gray-700">
+              {request.authorization_details.map((detail, i) => (
+                <li key={i} className="border border-gray-200 rounded p-2">
+                  <p className="font-medium">{detail.type}</p>
+                  <dl className="mt-1">
+                    {Object.entries(detail)
+                      .filter(([name]) => name !== "type")
+                      .map(([name, value]) => (
+                        <div key={name} className="flex gap-2">
+                          <dt className="text-gray-500">{name}</dt>
+                          <dd className="break-all">
+                            {typeof value === "string" ? value : JSON.stringify(value)}
+                          </dd>
+                        </div>
+                      ))}
+                  </dl>
+                </li>
+              ))}
+            </ul>
+            <div className="flex gap-3">
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => handleDecide(false)}
+                className="flex-1 py-3 border border-gray-300 text-gray-700 rounded font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                拒否
+              </button>
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => handleDecide(true)}
+                className="flex-1 py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                承認
+              </button>
+            </div>
+          </>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,174 @@
+"use client";
+
+import { useState, useEffect, useCallback } from "react";
+import { Alert } from "@/components/ui/alert";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+type ConsentScope = {
+  name: string;
+  description: string;
+};
+
+type BackchannelRequest = {
+  id: string;
+  client_name: string;
+  scope: string;
+  consent_scopes: ConsentScope[];
+  binding_message?: string;
+  expires_at: string;
+};
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "not_found":
+    case "request_not_pending":
+      return "このリクエストは既に処理されたか、有効期限が切れています";
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインセッションが切れています。通知に記載された URL からやり直してください";
+    case "invalid_scope":
+      return "要求されている権限はこのアプリケーションに許可されていません";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+export default function CibaPage() {
+  const [tenantCode, setTenantCode] = useState("");
+  const [requests, setRequests] = useState<BackchannelRequest[] | null>(null);
+  const [results, setResults] = useState<Record<string, "approved" | "denied">>({});
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  const load = useCallback(async (code: string) => {
+    setError("");
+    try {
+      const res = await fetch(
+        `${API_URL}/internal/ciba/requests?tenant_code=${encodeURIComponent(code)}`,
+        { credentials: "include" },
+      );
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(data.error);
+      }
+      setRequests(data);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    }
+  }, []);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    const code = params.get("tenant_code") || "demo";
+    setTenantCode(code);
+    load(code);
+  }, [load]);
+
+  async function handleDecide(id: string, approved: boolean) {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/ciba/decide`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify({ tenant_code: tenantCode, request_id: id, approved }),
+      });
+      const data = await res.json();
+      if (!res.ok) {
+        throw new Error(data.error);
+      }
+      setResults((prev) => ({ ...prev, [id]: data.status }));
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          ログインの承認
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {requests && requests.length === 0 && (
+          <p className="text-sm text-gray-600 text-center">承認待ちのリクエストはありません。</p>
+        )}
+
+        {requests?.map((req) => (
+          <div key={req.id} className="border border-gray-200 rounded p-4 mb-4">
+            <p className="text-sm text-gray-600 mb-4">
+              <span className="font-medium text-gray-800">{req.client_name}</span>{" "}
+              があなたとしてのログインを求めています。
+            </p>
+            {req.binding_message && (
+              <div className="mb-4">
+                <div className="text-sm text-gray-500 mb-1">確認メッセージ</div>
+                <code className="block bg-gray-50 px-3 py-2 rounded text-sm font-mono">
+                  {req.binding_message}
+                </code>
+                <p className="text-xs text-gray-500 mt-1">
+                  利用中の端末や窓口に表示されたメッセージと一致することを確認してください。
+                </p>
+              </div>
+            )}
+            {req.scope && (
+              <div className="mb-4">
+                <div className="text-sm text-gray-500 mb-1">要求されている権限</div>
+                <ul className="text-sm text-gray-700 list-disc list-inside">
+                  {req.scope.split(" ").map((s) => (
+                    <li key={s}>{s}</li>
+                  ))}
+                </ul>
+              </div>
+            )}
+            {req.consent_scopes.length > 0 && (
+              <div className="mb-4">
+                <div className="text-sm text-gray-500 mb-1">承認すると次の権限への同意を記録します</div>
+                <ul className="space-y-2">
+                  {req.consent_scopes.map((scope) => (
+                    <li key={scope.name} className="border border-gray-200 rounded p-2 text-sm">
+                      <p className="font-medium text-gray-800">{scope.name}</p>
+                      {scope.description && <p className="text-gray-600 mt-1">{scope.description}</p>}
+                    </li>
+                  ))}
+                </ul>
+              </div>
+            )}
+            {results[req.id] ? (
+              <Alert variant={results[req.id] === "approved" ? "success" : "warning"}>
+                {results[req.id] === "approved" ? "ログインを承認しました。" : "ログインを拒否しました。"}
+              </Alert>
+            ) : (
+              <div className="flex gap-3">
+                <button
+                  type="button"
+                  disabled={loading}
+                  onClick={() => handleDecide(req.id, false)}
+                  className="flex-1 py-3 border border-gray-300 text-gray-700 rounded font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed"
+                >
+                  拒否
+                </button>
+                <button
+                  type="button"
+                  disabled={loading}
+                  onClick={() => handleDecide(req.id, true)}
+                  className="flex-1 py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+                >
+                  承認
+                </button>
+              </div>
+            )}
+          </div>
+        ))}
+      </div>
+    </div>
+  );
+}
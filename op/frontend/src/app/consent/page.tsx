@@ -0,0 +1,127 @@
+"use client";
+
+import { useState, useEffect } from "react";
+import { Alert } from "@/components/ui/alert";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+type ConsentScope = {
+  name: string;
+  description: string;
+};
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインセッションが切れています。アプリケーションからやり直してください";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+export default function ConsentPage() {
+  const [tenantCode, setTenantCode] = useState("");
+  const [clientId, setClientId] = useState("");
+  const [clientName, setClientName] = useState("");
+  const [scopes, setScopes] = useState<ConsentScope[]>([]);
+  const [redirectAfterConsent, setRedirectAfterConsent] = useState("");
+  const [declined, setDeclined] = useState(false);
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    setTenantCode(params.get("tenant_code") || "demo");
+    setClientId(params.get("client_id") || "");
+    setClientName(params.get("client_name") || "");
+    setRedirectAfterConsent(params.get("redirect_after_consent") || "");
+    // OP Backend が同意の必要なスコープとその説明を渡す
+    try {
+      setScopes(JSON.parse(params.get("scopes") || "[]"));
+    } catch {
+      setScopes([]);
+    }
+  }, []);
+
+  async function handleConsent() {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/consent`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify({
+          tenant_code: tenantCode,
+          client_id: clientId,
+          scopes: scopes.map((s) => s.name),
+        }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(data.error);
+      }
+
+      if (redirectAfterConsent) {
+        // redirect_after_consent は OP Backend の相対パス（例: /demo/authorize?...）
+        window.location.href = `${API_URL}${redirectAfterConsent}`;
+      }
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          アクセスの許可
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {declined ? (
+          <Alert variant="warning">許可しませんでした。このページを閉じてください。</Alert>
+        ) : (
+          <>
+            <p className="text-sm text-gray-600 mb-4">
+              <span className="font-medium text-gray-800">{clientName}</span>{" "}
+              が次の情報へのアクセスを求めています。
+            </p>
+            <ul className="space-y-2 mb-6">
+              {scopes.map((scope) => (
+                <li key={scope.name} className="border border-gray-200 rounded p-2 text-sm">
+                  <p className="font-medium text-gray-800">{scope.name}</p>
+                  {scope.description && <p className="text-gray-600 mt-1">{scope.description}</p>}
+                </li>
+              ))}
+            </ul>
+            <div className="flex gap-3">
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => setDeclined(true)}
+                className="flex-1 py-3 border border-gray-300 text-gray-700 rounded font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                許可しない
+              </button>
+              <button
+                type="button"
+                disabled={loading || scopes.length === 0}
+                onClick={handleConsent}
+                className="flex-1 py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                許可
+              </button>
+            </div>
+          </>
+        )}
+      </div>
+    </div>
+  );
+}
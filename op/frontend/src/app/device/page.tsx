@@ -0,0 +1,206 @@
+"use client";
+
+import { useState, useEffect, type FormEvent } from "react";
+import { Alert } from "@/components/ui/alert";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+type ConsentScope = {
+  name: string;
+  description: string;
+};
+
+type DeviceRequest = {
+  user_code: string;
+  client_name: string;
+  scope: string;
+  consent_scopes: ConsentScope[];
+};
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "invalid_user_code":
+      return "コードが正しくないか、有効期限が切れています";
+    case "too_many_attempts":
+      return "試行回数が上限に達しました。しばらく待ってから再度お試しください";
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインセッションが切れています。デバイスに表示された URL からやり直してください";
+    case "invalid_scope":
+      return "要求されている権限はこのアプリケーションに許可されていません";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+export default function DevicePage() {
+  const [tenantCode, setTenantCode] = useState("");
+  const [userCode, setUserCode] = useState("");
+  const [request, setRequest] = useState<DeviceRequest | null>(null);
+  const [result, setResult] = useState<"approved" | "denied" | null>(null);
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    setTenantCode(params.get("tenant_code") || "demo");
+    setUserCode(params.get("user_code") || "");
+  }, []);
+
+  async function post(path: string, body: object) {
+    const res = await fetch(`${API_URL}${path}`, {
+      method: "POST",
+      headers: { "Content-Type": "application/json" },
+      credentials: "include",
+      body: JSON.stringify(body),
+    });
+    const data = await res.json();
+    if (!res.ok) {
+      throw new Error(data.error);
+    }
+    return data;
+  }
+
+  async function handleVerify(e: FormEvent) {
+    e.preventDefault();
+    setError("");
+    setLoading(true);
+
+    try {
+      const data = await post("/internal/device/verify", {
+        tenant_code: tenantCode,
+        user_code: userCode,
+      });
+      setRequest(data);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  async function handleDecide(approved: boolean) {
+    setError("");
+    setLoading(true);
+
+    try {
+      const data = await post("/internal/device/decide", {
+        tenant_code: tenantCode,
+        user_code: userCode,
+        approved,
+      });
+      setResult(data.status);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          デバイスの接続
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {result ? (
+          <Alert variant={result === "approved" ? "success" : "warning"}>
+            {result === "approved"
+              ? "デバイスを承認しました。デバイスに戻って操作を続けてください。"
+              : "デバイスからのアクセスを拒否しました。"}
+          </Alert>
+        ) : request ? (
+          <div>
+            <p className="text-sm text-gray-600 mb-4">
+              <span className="font-medium text-gray-800">{request.client_name}</span>{" "}
+              がアカウントへのアクセスを求めています。
+            </p>
+            <div className="mb-4">
+              <div className="text-sm text-gray-500 mb-1">コード</div>
+              <code className="block bg-gray-50 px-3 py-2 rounded text-sm font-mono">
+                {request.user_code}
+              </code>
+            </div>
+            {request.scope && (
+              <div className="mb-4">
+                <div className="text-sm text-gray-500 mb-1">要求されている権限</div>
+                <ul className="text-sm text-gray-700 list-disc list-inside">
+                  {request.scope.split(" ").map((s) => (
+                    <li key={s}>{s}</li>
+                  ))}
+                </ul>
+              </div>
+            )}
+            {request.consent_scopes.length > 0 && (
+              <div className="mb-4">
+                <div className="text-sm text-gray-500 mb-1">承認すると次の権限への同意を記録します</div>
+                <ul className="space-y-2">
+                  {request.consent_scopes.map((scope) => (
+                    <li key={scope.name} className="border border-gray-200 rounded p-2 text-sm">
+                      <p className="font-medium text-gray-800">{scope.name}</p>
+                      {scope.description && <p className="text-gray-600 mt-1">{scope.description}</p>}
+                    </li>
+                  ))}
+                </ul>
+              </div>
+            )}
+            <p className="text-xs text-gray-500 mb-4">
+              デバイスに表示されているコードと一致することを確認してください。
+            </p>
+            <div className="flex gap-3">
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => handleDecide(false)}
+                className="flex-1 py-3 border border-gray-300 text-gray-700 rounded font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                拒否
+              </button>
+              <button
+                type="button"
+                disabled={loading}
+                onClick={() => handleDecide(true)}
+                className="flex-1 py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                承認
+              </button>
+            </div>
+          </div>
+        ) : (
+          <form onSubmit={handleVerify}>
+            <div className="mb-4">
+              <label
+                htmlFor="userCode"
+                className="block text-sm font-medium text-gray-600 mb-1"
+              >
+                デバイスに表示されたコード
+              </label>
+              <input
+                id="userCode"
+                type="text"
+                value={userCode}
+                onChange={(e) => setUserCode(e.target.value)}
+                required
+                autoFocus
+                autoComplete="off"
+                placeholder="XXXX-XXXX"
+                className="w-full px-3 py-2 border border-gray-300 rounded font-mono uppercase focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed mt-2"
+            >
+              {loading ? "確認中..." : "次へ"}
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,154 @@
+"use client";
+
+import { useState, useEffect, type FormEvent } from "react";
+import { Alert } from "@/components/ui/alert";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "invalid_email":
+      return "メールアドレスの形式が正しくありません";
+    case "email_unchanged":
+      return "現在と同じメールアドレスです";
+    case "email_already_in_use":
+      return "このメールアドレスは既に使われています";
+    case "invalid_token":
+      return "リンクが無効か有効期限が切れています";
+    case "too_many_requests":
+      return "しばらく待ってからやり直してください";
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインしてからやり直してください";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+// 変更のリクエスト（ログイン中）、新しいアドレスでの確認（token）、変更前のアドレスからの取り消し（revert_token）を兼ねる
+export default function EmailChangePage() {
+  const [token, setToken] = useState("");
+  const [revertToken, setRevertToken] = useState("");
+  const [newEmail, setNewEmail] = useState("");
+  const [done, setDone] = useState("");
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    setToken(params.get("token") || "");
+    setRevertToken(params.get("revert_token") || "");
+  }, []);
+
+  async function post(path: string, body: unknown, message: string) {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}${path}`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify(body),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(data.error);
+      }
+      setDone(message);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  function handleRequest(e: FormEvent) {
+    e.preventDefault();
+    post(
+      "/internal/email/change-request",
+      { new_email: newEmail },
+      "新しいメールアドレスに確認メールを送信しました。リンクを開くと変更が完了します。",
+    );
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          メールアドレスの変更
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {done ? (
+          <Alert variant="success">{done}</Alert>
+        ) : revertToken ? (
+          <>
+            <p className="text-sm text-gray-600 mb-6">
+              メールアドレスの変更を取り消し、元のアドレスに戻します。すべての端末からログアウトされます。
+            </p>
+            <button
+              type="button"
+              disabled={loading}
+              onClick={() =>
+                post(
+                  "/internal/email/change-revert",
+                  { token: revertToken },
+                  "変更を取り消しました。パスワードの変更をおすすめします。",
+                )
+              }
+              className="w-full py-3 bg-red-600 text-white rounded font-medium hover:bg-red-700 disabled:opacity-50 disabled:cursor-not-allowed"
+            >
+              変更を取り消す
+            </button>
+          </>
+        ) : token ? (
+          <>
+            <p className="text-sm text-gray-600 mb-6">
+              ボタンを押すとメールアドレスの変更が完了します。
+            </p>
+            <button
+              type="button"
+              disabled={loading}
+              onClick={() =>
+                post("/internal/email/change-confirm", { token }, "メールアドレスを変更しました。")
+              }
+              className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+            >
+              変更する
+            </button>
+          </>
+        ) : (
+          <form onSubmit={handleRequest}>
+            <div className="mb-4">
+              <label
+                htmlFor="newEmail"
+                className="block text-sm font-medium text-gray-600 mb-1"
+              >
+                新しいメールアドレス
+              </label>
+              <input
+                id="newEmail"
+                type="email"
+                value={newEmail}
+                onChange={(e) => setNewEmail(e.target.value)}
+                required
+                autoFocus
+                className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed mt-2"
+            >
+              確認メールを送信
+            </button>
+          </form>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -2,9 +2,36 @@
 
 import { useState, useEffect, type FormEvent } from "react";
 import { Alert } from "@/components/ui/alert";
+import type { FederationProvider } from "@/types";
 
 const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
 
+// 上流の IdP でのログインに失敗した場合に OP Backend が付けるエラーコード
+const federationErrorMessages: Record<string, string> = {
+  federation_failed: "外部サービスでのログインに失敗しました",
+  account_not_found: "外部サービスのアカウントに紐付くユーザーが登録されていません",
+  email_already_in_use: "このメールアドレスのユーザーが既に登録されています。ログインIDとパスワードでログインしてください",
+  login_id_already_in_use: "このログインIDのユーザーが既に登録されています",
+  email_not_verified: "メールアドレスが確認されていません。確認メールのリンクを開いてからログインしてください",
+  invalid_credentials: "このユーザーはログインできません",
+};
+
+// ログインID・パスワードでのログインに失敗した場合のエラーコード
+const loginErrorMessages: Record<string, string> = {
+  invalid_credentials: "ログインIDまたはパスワードが正しくありません",
+  email_not_verified: "メールアドレスが確認されていません。確認メールのリンクを開いてからログインしてください",
+  too_many_requests: "確認コードは送信済みです。しばらく待ってからやり直してください",
+  temporarily_unavailable: "ログインが混み合っています。しばらく待ってからやり直してください",
+  // 以下は LDAP ディレクトリのユーザーの場合
+  email_already_in_use: "このメールアドレスのユーザーが既に登録されています。管理者に連絡してください",
+  login_id_already_in_use: "このログインIDのユーザーが既に登録されています。管理者に連絡してください",
+  account_not_provisioned: "ディレクトリのアカウント情報が不足しているためログインできません。管理者に連絡してください",
+  directory_unavailable: "ディレクトリサービスに接続できません。しばらく待ってからやり直してください",
+  // 以下は移行元のシステムで認証するユーザーの場合
+  account_not_migrated: "移行元のアカウント情報が不足しているためログインできません。管理者に連絡してください",
+  migration_unavailable: "認証サービスに接続できません。しばらく待ってからやり直してください",
+};
+
 export default function LoginPage() {
   const [loginId, setLoginId] = useState("");
   const [password, setPassword] = useState("");
@@ -12,11 +39,25 @@ export default function LoginPage() {
   const [loading, setLoading] = useState(false);
   const [tenantCode, setTenantCode] = useState("");
   const [redirectAfterLogin, setRedirectAfterLogin] = useState("");
+  // SMS の2要素認証を有効にしているユーザーはパスワードの後にコードを入力する
+  const [mfaToken, setMfaToken] = useState("");
+  const [phoneNumberHint, setPhoneNumberHint] = useState("");
+  const [code, setCode] = useState("");
+  const [providers, setProviders] = useState<FederationProvider[]>([]);
 
   useEffect(() => {
     const params = new URLSearchParams(window.location.search);
-    setTenantCode(params.get("tenant_code") || "demo");
+    const tenant = params.get("tenant_code") || "demo";
+    setTenantCode(tenant);
     setRedirectAfterLogin(params.get("redirect_after_login") || "");
+    const federationError = params.get("error");
+    if (federationError) {
+      setError(federationErrorMessages[federationError] || "ログインに失敗しました");
+    }
+    fetch(`${API_URL}/internal/federation/providers?tenant_code=${encodeURIComponent(tenant)}`)
+      .then((res) => (res.ok ? res.json() : []))
+      .then(setProviders)
+      .catch(() => setProviders([]));
   }, []);
 
   async function handleSubmit(e: FormEvent) {
@@ -38,19 +79,18 @@ export default function LoginPage() {
 
       if (!res.ok) {
         const data = await res.json();
-        setError(
-          data.error === "invalid_credentials"
-            ? "ログインIDまたはパスワードが正しくありません"
-            : "ログインに失敗しました",
-        );
+        setError(loginErrorMessages[data.error] ?? "ログインに失敗しました");
         return;
       }
 
-      if (redirectAfterLogin) {
-        // redirect_after_login は OP Backend の相対パス（例: /demo/authorize?...）
-        // OP Frontend からのリダイレクトなので OP Backend の絶対 URL に変換する
-        window.location.href = `${API_URL}${redirectAfterLogin}`;
+      const data = await res.json();
+      if (data.mfa_required) {
+        setMfaToken(data.mfa_token);
+        setPhoneNumberHint(data.phone_number_hint);
+        return;
       }
+
+      redirectAfterSuccess();
     } catch {
       setError("サーバーに接続できません");
     } finally {
@@ -58,6 +98,60 @@ export default function LoginPage() {
     }
   }
 
+  async function handleSMSSubmit(e: FormEvent) {
+    e.preventDefault();
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/login/sms`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify({ mfa_token: mfaToken, code }),
+      });
+
+      if (!res.ok) {
+        const data = await res.json();
+        if (data.error === "invalid_code") {
+          setError("確認コードが正しくないか有効期限が切れています");
+        } else {
+          // 入力回数の上限に達したコードは使えないため、パスワードの入力からやり直す
+          setMfaToken("");
+          setCode("");
+          setError(
+            data.error === "too_many_attempts"
+              ? "確認コードの入力回数が上限に達しました。最初からやり直してください"
+              : "ログインに失敗しました",
+          );
+        }
+        return;
+      }
+
+      redirectAfterSuccess();
+    } catch {
+      setError("サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  function federationStartURL(provider: FederationProvider) {
+    const params = new URLSearchParams({ tenant_code: tenantCode });
+    if (redirectAfterLogin) {
+      params.set("redirect_after_login", redirectAfterLogin);
+    }
+    return `${API_URL}/internal/federation/${encodeURIComponent(provider.code)}/start?${params}`;
+  }
+
+  function redirectAfterSuccess() {
+    if (redirectAfterLogin) {
+      // redirect_after_login は OP Backend の相対パス（例: /demo/authorize?...）
+      // OP Frontend からのリダイレクトなので OP Backend の絶対 URL に変換する
+      window.location.href = `${API_URL}${redirectAfterLogin}`;
+    }
+  }
+
   return (
     <div className="min-h-screen flex items-center justify-center bg-gray-100">
       <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
@@ -65,48 +159,95 @@ export default function LoginPage() {
           ログイン
         </h1>
         {error && <Alert variant="error">{error}</Alert>}
-        <form onSubmit={handleSubmit}>
-          <div className="mb-4">
-            <label
-              htmlFor="loginId"
-              className="block text-sm font-medium text-gray-600 mb-1"
+        {mfaToken ? (
+          <form onSubmit={handleSMSSubmit}>
+            <p className="text-sm text-gray-600 mb-4">
+              {phoneNumberHint} に送信した確認コードを入力してください。
+            </p>
+            <div className="mb-4">
+              <label
+                htmlFor="code"
+                className="block text-sm font-medium text-gray-600 mb-1"
+              >
+                確認コード
+              </label>
+              <input
+                id="code"
+                type="text"
+                inputMode="numeric"
+                autoComplete="one-time-code"
+                value={code}
+                onChange={(e) => setCode(e.target.value)}
+                required
+                autoFocus
+                className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed mt-2"
             >
-              ログインID
-            </label>
-            <input
-              id="loginId"
-              type="text"
-              value={loginId}
-              onChange={(e) => setLoginId(e.target.value)}
-              required
-              autoFocus
-              className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
-            />
-          </div>
-          <div className="mb-4">
-            <label
-              htmlFor="password"
-              className="block text-sm font-medium text-gray-600 mb-1"
+              {loading ? "確認中..." : "確認"}
+            </button>
+          </form>
+        ) : (
+          <form onSubmit={handleSubmit}>
+            <div className="mb-4">
+              <label
+                htmlFor="loginId"
+                className="block text-sm font-medium text-gray-600 mb-1"
+              >
+                ログインID
+              </label>
+              <input
+                id="loginId"
+                type="text"
+                value={loginId}
+                onChange={(e) => setLoginId(e.target.value)}
+                required
+                autoFocus
+                className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+            <div className="mb-4">
+              <label
+                htmlFor="password"
+                className="block text-sm font-medium text-gray-600 mb-1"
+              >
+                パスワード
+              </label>
+              <input
+                id="password"
+                type="password"
+                value={password}
+                onChange={(e) => setPassword(e.target.value)}
+                required
+                className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
+              />
+            </div>
+            <button
+              type="submit"
+              disabled={loading}
+              className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed mt-2"
             >
-              パスワード
-            </label>
-            <input
-              id="password"
-              type="password"
-              value={password}
-              onChange={(e) => setPassword(e.target.value)}
-              required
-              className="w-full px-3 py-2 border border-gray-300 rounded focus:outline-none focus:ring-2 focus:ring-blue-500 focus:border-transparent"
-            />
-          </div>
-          <button
-            type="submit"
-            disabled={loading}
-            className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed mt-2"
-          >
-            {loading ? "ログイン中..." : "ログイン"}
-          </button>
-        </form>
+              {loading ? "ログイン中..." : "ログイン"}
+            </button>
+            {providers.length > 0 && (
+              <div className="mt-6 pt-4 border-t border-gray-200 space-y-2">
+                {providers.map((provider) => (
+                  <a
+                    key={provider.code}
+                    href={federationStartURL(provider)}
+                    className="block w-full py-2 text-center border border-gray-300 rounded text-gray-700 hover:bg-gray-50"
+                  >
+                    {provider.name} でログイン
+                  </a>
+                ))}
+              </div>
+            )}
+          </form>
+        )}
       </div>
     </div>
   );
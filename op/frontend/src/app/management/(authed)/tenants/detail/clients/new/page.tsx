@@ -55,14 +55,16 @@ export default function NewClientPage() {
               {created.client_id}
             </code>
           </div>
-          <div>
-            <label className="block text-sm text-gray-500 mb-1">
-              Client Secret
-            </label>
-            <code className="block bg-gray-50 px-3 py-2 rounded text-sm font-mono break-all">
-              {created.client_secret}
-            </code>
-          </div>
+          {created.client_secret && (
+            <div>
+              <label className="block text-sm text-gray-500 mb-1">
+                Client Secret
+              </label>
+              <code className="block bg-gray-50 px-3 py-2 rounded text-sm font-mono break-all">
+                {created.client_secret}
+              </code>
+            </div>
+          )}
         </div>
         <div className="mt-4 flex gap-3">
           <Link
@@ -126,6 +128,7 @@ export default function NewClientPage() {
           >
             <option value="client_secret_basic">client_secret_basic</option>
             <option value="client_secret_post">client_secret_post</option>
+            <option value="client_secret_jwt">client_secret_jwt</option>
             <option value="none">none (パブリッククライアント)</option>
           </select>
         </div>
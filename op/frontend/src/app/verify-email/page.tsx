@@ -0,0 +1,145 @@
+"use client";
+
+import { useState, useEffect } from "react";
+import { Alert } from "@/components/ui/alert";
+
+const API_URL = process.env.NEXT_PUBLIC_OP_BACKEND_BASE_URL || "http://localhost:8080";
+
+// 内部 API のエラーコードを表示用メッセージに変換する
+function errorMessage(code: string): string {
+  switch (code) {
+    case "invalid_token":
+      return "リンクが無効か有効期限が切れています。確認メールを再送してください";
+    case "already_verified":
+      return "メールアドレスは確認済みです";
+    case "too_many_requests":
+      return "確認メールは送信済みです。しばらく待ってから再送してください";
+    case "no_session":
+    case "invalid_session":
+    case "session_expired":
+      return "ログインセッションが切れています。アプリケーションからやり直してください";
+    default:
+      return "処理に失敗しました";
+  }
+}
+
+// 確認メールのリンク (token あり) と、認可時の確認画面 (redirect_after_verify あり) を兼ねる
+export default function VerifyEmailPage() {
+  const [token, setToken] = useState("");
+  const [redirectAfterVerify, setRedirectAfterVerify] = useState("");
+  const [verified, setVerified] = useState(false);
+  const [sent, setSent] = useState(false);
+  const [error, setError] = useState("");
+  const [loading, setLoading] = useState(false);
+
+  useEffect(() => {
+    const params = new URLSearchParams(window.location.search);
+    setToken(params.get("token") || "");
+    setRedirectAfterVerify(params.get("redirect_after_verify") || "");
+  }, []);
+
+  async function handleVerify() {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/email/verify`, {
+        method: "POST",
+        headers: { "Content-Type": "application/json" },
+        credentials: "include",
+        body: JSON.stringify({ token }),
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(data.error);
+      }
+      setVerified(true);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  async function handleSend() {
+    setError("");
+    setLoading(true);
+
+    try {
+      const res = await fetch(`${API_URL}/internal/email/verify-request`, {
+        method: "POST",
+        credentials: "include",
+      });
+      if (!res.ok) {
+        const data = await res.json();
+        throw new Error(data.error);
+      }
+      setSent(true);
+    } catch (err) {
+      setError(err instanceof Error ? errorMessage(err.message) : "サーバーに接続できません");
+    } finally {
+      setLoading(false);
+    }
+  }
+
+  function handleContinue() {
+    // redirect_after_verify は OP Backend の相対パス（例: /demo/authorize?...）
+    window.location.href = `${API_URL}${redirectAfterVerify}`;
+  }
+
+  return (
+    <div className="min-h-screen flex items-center justify-center bg-gray-100">
+      <div className="bg-white p-8 rounded-lg shadow-md w-full max-w-sm">
+        <h1 className="text-2xl font-semibold text-center text-gray-800 mb-6">
+          メールアドレスの確認
+        </h1>
+        {error && <Alert variant="error">{error}</Alert>}
+
+        {token ? (
+          verified ? (
+            <Alert variant="success">メールアドレスを確認しました。このページを閉じてください。</Alert>
+          ) : (
+            <>
+              <p className="text-sm text-gray-600 mb-6">
+                ボタンを押してメールアドレスの確認を完了してください。
+              </p>
+              <button
+                type="button"
+                disabled={loading}
+                onClick={handleVerify}
+                className="w-full py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                {loading ? "確認中..." : "確認する"}
+              </button>
+            </>
+          )
+        ) : (
+          <>
+            <p className="text-sm text-gray-600 mb-4">
+              続行するにはメールアドレスの確認が必要です。確認メールのリンクを開いてから「続ける」を押してください。
+            </p>
+            {sent && <Alert variant="success">確認メールを送信しました。</Alert>}
+            <div className="flex gap-3">
+              <button
+                type="button"
+                disabled={loading}
+                onClick={handleSend}
+                className="flex-1 py-3 border border-gray-300 text-gray-700 rounded font-medium hover:bg-gray-50 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                確認メールを送信
+              </button>
+              <button
+                type="button"
+                disabled={loading || !redirectAfterVerify}
+                onClick={handleContinue}
+                className="flex-1 py-3 bg-blue-600 text-white rounded font-medium hover:bg-blue-700 disabled:opacity-50 disabled:cursor-not-allowed"
+              >
+                続ける
+              </button>
+            </div>
+          </>
+        )}
+      </div>
+    </div>
+  );
+}
@@ -0,0 +1,12 @@
+/** テナントに登録された API リソース (RFC 8707)。identifier がアクセストークンの aud になる。 */
+export type ApiResource = {
+  id: string;
+  tenant_id: string;
+  identifier: string;
+  name: string;
+  scopes: string[];
+  /** 省略時はテナントの access_token_lifetime を使う */
+  access_token_lifetime?: number;
+  created_at: string;
+  updated_at: string;
+};
@@ -0,0 +1,18 @@
+/** テナントに登録された authorization_details の type (RFC 9396)。schema で各要素を検証する。 */
+export type AuthorizationDetailType = {
+  id: string;
+  tenant_id: string;
+  type: string;
+  /** ログイン画面でユーザーに示す説明 */
+  description: string;
+  /** JSON Schema のサブセット（type, enum, const, required, properties, additionalProperties, items, 長さ・範囲・pattern） */
+  schema: Record<string, unknown>;
+  created_at: string;
+  updated_at: string;
+};
+
+/** authorization_details の1要素。type 以外のフィールドは type ごとに異なる。 */
+export type AuthorizationDetail = {
+  type: string;
+  [field: string]: unknown;
+};
@@ -9,6 +9,31 @@ export type Client = {
   require_pkce: boolean;
   frontchannel_logout_uri?: string;
   backchannel_logout_uri?: string;
+  jwks?: { keys: Record<string, unknown>[] };
+  jwks_uri?: string;
+  tls_client_auth_subject_dn?: string;
+  tls_client_auth_san_dns?: string;
+  tls_client_auth_san_uri?: string;
+  tls_client_auth_san_ip?: string;
+  tls_client_auth_san_email?: string;
+  tls_client_certificate_bound_access_tokens: boolean;
+  token_exchange_audiences: string[];
+  token_exchange_scopes: string[];
+  /** 要求できるスコープ。空の場合はテナントで利用できる全てのスコープ（openid は常に許可） */
+  allowed_scopes: string[];
+  /** scope を省略した認可リクエストに使うスコープ */
+  default_scopes: string[];
+  subject_type: "public" | "pairwise";
+  sector_identifier_uri?: string;
+  userinfo_signed_response_alg?: string;
+  id_token_encrypted_response_alg?: string;
+  id_token_encrypted_response_enc?: string;
+  userinfo_encrypted_response_alg?: string;
+  userinfo_encrypted_response_enc?: string;
+  request_uris: string[];
+  require_signed_request_object: boolean;
+  backchannel_token_delivery_mode?: "poll" | "ping";
+  backchannel_client_notification_endpoint?: string;
   status: string;
   created_at: string;
   updated_at: string;
@@ -25,9 +50,9 @@ export type ClientDetail = Client & {
   post_logout_redirect_uris: RedirectURI[];
 };
 
-/** クライアント作成時のみ返される（平文のシークレットを含む）。 */
+/** クライアント作成時のみ返される（平文のシークレットを含む）。公開クライアント・private_key_jwt では省略される。 */
 export type ClientCreateResponse = Client & {
-  client_secret: string;
+  client_secret?: string;
 };
 
 export type RotateSecretResponse = {
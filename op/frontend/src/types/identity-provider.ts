@@ -0,0 +1,27 @@
+/** テナントが連携する上流の OIDC Provider。client_secret は返さず、設定済みかどうかのみ返す。 */
+export type IdentityProvider = {
+  id: string;
+  tenant_id: string;
+  /** /internal/federation/{code}/start・callback のパスに使う識別子 */
+  code: string;
+  name: string;
+  issuer: string;
+  client_id: string;
+  client_secret_set: boolean;
+  scopes: string[];
+  /** ユーザー属性 → 上流のクレーム名。未指定の標準クレームは同名のクレームを使う */
+  claim_mappings: Record<string, string>;
+  /** true の場合、紐付くユーザーがいなければ初回ログイン時に作成する */
+  jit_provisioning: boolean;
+  /** true の場合、確認済みのメールアドレスが一致する既存ユーザーに紐付ける */
+  link_by_email: boolean;
+  status: "active" | "disabled";
+  created_at: string;
+  updated_at: string;
+};
+
+/** ログイン画面に表示する IdP（GET /internal/federation/providers） */
+export type FederationProvider = {
+  code: string;
+  name: string;
+};
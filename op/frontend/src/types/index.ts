@@ -1,5 +1,12 @@
 export type { ApiError, ListResponse } from "./api";
-export type { Tenant } from "./tenant";
+export type {
+  EmailVerificationPolicy,
+  InitialAccessToken,
+  RegistrationPolicy,
+  SCIMToken,
+  SCIMTokenCreateResponse,
+  Tenant,
+} from "./tenant";
 export type {
   Client,
   ClientCreateResponse,
@@ -7,6 +14,14 @@ export type {
   RedirectURI,
   RotateSecretResponse,
 } from "./client";
+export type { ApiResource } from "./api-resource";
+export type { AuthorizationDetail, AuthorizationDetailType } from "./authorization-detail-type";
+export type { Address, User, UserAttributeSchema, UserAuditLog } from "./user";
+export type { ScopeClaimMapping, ScopeDefinition } from "./scope";
+export type { FederationProvider, IdentityProvider } from "./identity-provider";
+export type { SAMLAttributeMapping, SAMLServiceProvider } from "./saml-service-provider";
+export type { LDAPDirectory, LDAPDirectoryTestResult } from "./ldap-directory";
+export type { UserMigrationHook } from "./user-migration-hook";
 export type { SignKey } from "./key";
 export type { RevokeResponse } from "./incident";
 export type { AdminUser } from "./auth";
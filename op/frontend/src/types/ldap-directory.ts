@@ -0,0 +1,39 @@
+/** テナントのパスワード認証を委譲する LDAP / Active Directory。テナントごとに 1 つまで */
+export type LDAPDirectory = {
+  id: string;
+  tenant_id: string;
+  name: string;
+  host: string;
+  port: number;
+  /** ldaps はポートに直接 TLS で接続し、starttls は平文で接続してから TLS を開始する */
+  security: "ldaps" | "starttls";
+  /** サーバー証明書の検証に使う CA 証明書（PEM）。null の場合はシステムのルート証明書を使う */
+  ca_certificate: string | null;
+  /** ユーザー検索に使うサービスアカウント。null の場合は匿名で検索する */
+  bind_dn: string | null;
+  /** bind_password は返さず、設定済みかどうかのみ返す */
+  bind_password_set: boolean;
+  user_search_base: string;
+  /** {username} をエスケープした login_id に置き換えて検索する */
+  user_search_filter: string;
+  /** ユーザーを一意に識別する属性（objectGUID など） */
+  id_attribute: string;
+  /** ユーザー属性名 → ディレクトリの属性名 */
+  attribute_mappings: Record<string, string>;
+  jit_provisioning: boolean;
+  /** true の場合、login_id が一致する既存のユーザーにディレクトリのアカウントを紐付ける */
+  link_by_login_id: boolean;
+  group_sync_enabled: boolean;
+  group_attribute: string;
+  status: "active" | "disabled";
+  created_at: string;
+  updated_at: string;
+};
+
+/** 接続テストの結果 */
+export type LDAPDirectoryTestResult = {
+  success: boolean;
+  error?: string;
+  dn?: string;
+  attributes?: Record<string, string[]>;
+};
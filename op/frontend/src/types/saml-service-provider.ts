@@ -0,0 +1,26 @@
+/** アサーションに含める属性。attribute はユーザー属性名（標準クレーム名・カスタム属性名・login_id） */
+export type SAMLAttributeMapping = {
+  name: string;
+  attribute: string;
+  /** 省略時は basic */
+  name_format?: "basic" | "uri" | "unspecified";
+};
+
+/** テナントの SAML 2.0 IdP に登録された Service Provider */
+export type SAMLServiceProvider = {
+  id: string;
+  tenant_id: string;
+  entity_id: string;
+  name: string;
+  /** AssertionConsumerService の URL（HTTP-POST バインディング） */
+  acs_url: string;
+  /** SingleLogoutService の URL（HTTP-Redirect バインディング）。null の場合は SLO に参加しない */
+  slo_url: string | null;
+  name_id_format: string;
+  attribute_mappings: SAMLAttributeMapping[];
+  /** true の場合、AuthnRequest なしの IdP-initiated SSO を許可する */
+  idp_initiated_sso_enabled: boolean;
+  status: "active" | "disabled";
+  created_at: string;
+  updated_at: string;
+};
@@ -0,0 +1,20 @@
+/** スコープ要求時に返すクレーム。attribute（ユーザー属性）と value（固定値）のどちらか一方を指定する。 */
+export type ScopeClaimMapping = {
+  claim: string;
+  attribute?: string;
+  value?: unknown;
+};
+
+/** テナントが定義したカスタムスコープ。openid / profile / email / phone / address / offline_access は組み込み。 */
+export type ScopeDefinition = {
+  id: string;
+  tenant_id: string;
+  name: string;
+  /** 同意画面でユーザーに示す説明 */
+  description: string;
+  /** true の場合、クライアントごとにユーザーの同意を得てから認可する */
+  consent_required: boolean;
+  claims: ScopeClaimMapping[];
+  created_at: string;
+  updated_at: string;
+};
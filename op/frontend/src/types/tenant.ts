@@ -1,3 +1,8 @@
+export type RegistrationPolicy = "disabled" | "initial_access_token" | "open";
+
+// メールアドレス未確認ユーザーの扱い
+export type EmailVerificationPolicy = "optional" | "login" | "authorization";
+
 export type Tenant = {
   id: string;
   code: string;
@@ -7,6 +12,30 @@ export type Tenant = {
   access_token_lifetime: number;
   refresh_token_lifetime: number;
   id_token_lifetime: number;
+  registration_policy: RegistrationPolicy;
+  email_verification_policy: EmailVerificationPolicy;
   created_at: string;
   updated_at: string;
 };
+
+export type InitialAccessToken = {
+  id: string;
+  tenant_id: string;
+  description?: string;
+  expires_at?: string;
+  created_at: string;
+};
+
+/** SCIM 2.0 のプロビジョニング API 用の Bearer トークン。平文のトークンは作成時のみ返る */
+export type SCIMToken = {
+  id: string;
+  tenant_id: string;
+  description?: string;
+  expires_at?: string;
+  last_used_at?: string;
+  created_at: string;
+};
+
+export type SCIMTokenCreateResponse = SCIMToken & {
+  token: string;
+};
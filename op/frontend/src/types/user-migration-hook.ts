@@ -0,0 +1,21 @@
+/** ローカルにパスワードのないユーザーのログイン時に、移行元のシステムでパスワードを検証する Webhook。テナントごとに 1 つまで */
+export type UserMigrationHook = {
+  id: string;
+  tenant_id: string;
+  /** 移行元のエンドポイント（https のみ） */
+  url: string;
+  /** hmac はリクエストボディに HMAC-SHA256 の署名を付け、mtls はクライアント証明書で接続する */
+  auth_method: "hmac" | "mtls";
+  /** hmac_secret は返さず、設定済みかどうかのみ返す */
+  hmac_secret_set: boolean;
+  client_certificate: string | null;
+  /** client_private_key は返さず、設定済みかどうかのみ返す */
+  client_private_key_set: boolean;
+  /** サーバー証明書の検証に使う CA 証明書（PEM）。null の場合はシステムのルート証明書を使う */
+  ca_certificate: string | null;
+  timeout_ms: number;
+  /** 移行が完了したら disabled にする */
+  status: "active" | "disabled";
+  created_at: string;
+  updated_at: string;
+};
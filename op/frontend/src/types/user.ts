@@ -0,0 +1,66 @@
+/** address クレームの値 (OIDC Core 1.0 Section 5.1.1) */
+export type Address = {
+  formatted?: string;
+  street_address?: string;
+  locality?: string;
+  region?: string;
+  postal_code?: string;
+  country?: string;
+};
+
+/** エンドユーザーのプロフィール。未設定のクレームは null。 */
+export type User = {
+  id: string;
+  tenant_id: string;
+  login_id: string;
+  email: string;
+  email_verified: boolean;
+  /** active / locked / disabled / deleted (SCIM で削除されたユーザー) */
+  status: string;
+  name: string | null;
+  given_name: string | null;
+  family_name: string | null;
+  middle_name: string | null;
+  nickname: string | null;
+  preferred_username: string | null;
+  profile: string | null;
+  picture: string | null;
+  website: string | null;
+  gender: string | null;
+  /** YYYY-MM-DD / 0000-MM-DD / YYYY */
+  birthdate: string | null;
+  zoneinfo: string | null;
+  locale: string | null;
+  /** E.164 形式 */
+  phone_number: string | null;
+  phone_number_verified: boolean;
+  /** ログイン時に SMS のワンタイムコードを2要素目として要求する。確認済みの電話番号が必要 */
+  sms_mfa_enabled: boolean;
+  address: Address | null;
+  /** SCIM クライアントでの識別子 (SCIM の externalId) */
+  external_id: string | null;
+  /** テナントの UserAttributeSchema で定義されたカスタム属性 */
+  custom_attributes: Record<string, unknown>;
+  last_login_at: string | null;
+  created_at: string;
+  updated_at: string;
+};
+
+/** テナントのカスタムユーザー属性の定義。schema が null の場合はカスタム属性なし。 */
+export type UserAttributeSchema = {
+  tenant_id: string;
+  /** type: "object" の JSON Schema。properties のキーが属性名 (= クレーム名) になる */
+  schema: Record<string, unknown> | null;
+  attributes: string[];
+};
+
+/** ユーザーのアカウント情報の変更履歴 */
+export type UserAuditLog = {
+  id: string;
+  /** email_change_requested / email_changed / email_change_reverted */
+  event: string;
+  /** 変更前後の値など */
+  detail: Record<string, string>;
+  ip_address: string;
+  created_at: string;
+};